@@ -12,7 +12,7 @@ interface ConsensusTransaction {
   id: string;
   sender: string;
   receiver: string;
-  state: 'INITIATED' | 'SENT' | 'RECEIVED' | 'VALIDATED' | 'DISPUTED' | 'TIMEOUT';
+  state: 'INITIATED' | 'SENT' | 'RECEIVED' | 'VALIDATED' | 'DISPUTED' | 'TIMEOUT' | 'CANCELLED';
   itemType: string;
   itemId: string;
   quantity?: number;
@@ -100,6 +100,29 @@ export class ConsensusEngine {
     }
   }
 
+  /**
+   * Read all transactions, one GetAllTransactions page at a time
+   */
+  private async getAllTransactions(): Promise<ConsensusTransaction[]> {
+    const transactions: ConsensusTransaction[] = [];
+    let bookmark = '';
+    do {
+      const pageResult = await this.transactionHandler.evaluateTransaction(
+        this.consensusContract!,
+        'GetAllTransactions',
+        '1000',
+        bookmark
+      );
+      if (!pageResult.success) {
+        throw pageResult.error;
+      }
+      const page = pageResult.result as { records: ConsensusTransaction[]; bookmark: string };
+      transactions.push(...(page?.records || []));
+      bookmark = page?.bookmark || '';
+    } while (bookmark);
+    return transactions;
+  }
+
   private getPeerPort(org: string): number {
     const portMap: Record<string, number> = {
       'luxebags': 7051,
@@ -214,11 +237,7 @@ export class ConsensusEngine {
         
         // Try to get all transactions to debug
         try {
-          const allTxResult = await this.transactionHandler.evaluateTransaction(
-            this.consensusContract!,
-            'GetAllTransactions'
-          );
-          const allTx = allTxResult.result as ConsensusTransaction[];
+          const allTx = await this.getAllTransactions();
           console.log(`Total transactions in blockchain: ${allTx?.length || 0}`);
           const matchingTx = allTx?.find((t: ConsensusTransaction) => t.id === transactionId);
           if (matchingTx) {
@@ -481,10 +500,7 @@ export class ConsensusEngine {
       
       // Also get all transactions to find resolved disputes
       // We need to check metadata for disputeStatus = RESOLVED_*
-      const allTxResult = await this.transactionHandler.evaluateTransaction(
-        this.consensusContract!,
-        'GetAllTransactions'
-      );
+      const allTransactions = await this.getAllTransactions();
       
       console.log('Checking all transactions for resolved disputes');
 
@@ -497,8 +513,7 @@ export class ConsensusEngine {
       }
       
       // Add resolved disputes from all transactions
-      if (allTxResult.result) {
-        const allTransactions = allTxResult.result as ConsensusTransaction[];
+      if (allTransactions.length > 0) {
         const resolvedDisputes = allTransactions.filter(tx => 
           tx.metadata?.disputeStatus === 'RESOLVED_ACCEPTED' || 
           tx.metadata?.disputeStatus === 'RESOLVED_ARBITRATED'
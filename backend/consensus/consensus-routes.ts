@@ -354,8 +354,17 @@ export function createConsensusRoutes(pool: Pool) {
       console.error('Error details:', error.message);
       console.error('Error stack:', error.stack);
       
-      // Return 400 if it's a validation error, 500 otherwise
-      const statusCode = error.message?.includes('not found') || error.message?.includes('invalid') ? 400 : 500;
+      // Map the chaincode error code to an HTTP status; uncoded validation errors from the engine stay 400
+      const code = /\[([A-Z_]+)\]/.exec(error.message || '')?.[1];
+      const statusByCode: Record<string, number> = {
+        NOT_FOUND: 404,
+        PERMISSION_DENIED: 403,
+        INVALID_ARGUMENT: 400,
+        INVALID_STATE: 409,
+        ALREADY_EXISTS: 409,
+      };
+      const isValidationError = error.message?.includes('not found') || error.message?.includes('invalid');
+      const statusCode = (code && statusByCode[code]) || (isValidationError ? 400 : 500);
       res.status(statusCode).json({ 
         error: error.message || 'Failed to initiate dispute',
         details: error.toString()
@@ -126,7 +126,7 @@ export function createOwnershipRoutes(blockchainProxy: BlockchainProxy): Router
    */
   router.post('/report-stolen', async (req: Request, res: Response) => {
     try {
-      const { productId, email, phone, password, pin, policeReportId } = req.body;
+      const { productId, email, phone, password, pin, policeReportId, insurerId } = req.body;
       
       if (!productId || (!email && !phone) || !password || !pin) {
         return res.status(400).json({ 
@@ -145,7 +145,7 @@ export function createOwnershipRoutes(blockchainProxy: BlockchainProxy): Router
         .digest('hex');
       
       // Call blockchain with security verification
-      const result = await blockchainProxy.reportStolen(productId, ownerHash, securityHash, policeReportId);
+      const result = await blockchainProxy.reportStolen(productId, ownerHash, securityHash, policeReportId, insurerId);
       
       res.json({
         success: true,
@@ -18,6 +18,12 @@ export function createProductRoutes(blockchainProxy: BlockchainProxy): Router {
       const result = await blockchainProxy.verifyProduct(productId);
       
       if (result.authentic) {
+        // Country of the scan from the app, or from the CDN's geolocation header
+        const region = (req.query.region as string) || req.header('cf-ipcountry');
+        if (region) {
+          await blockchainProxy.recordVerificationScan(productId, region.toUpperCase());
+        }
+        
         res.json({
           authentic: true,
           message: 'Product is authentic',
@@ -118,5 +124,33 @@ export function createProductRoutes(blockchainProxy: BlockchainProxy): Router {
     }
   });
   
+  /**
+   * Report a suspected counterfeit - PUBLIC endpoint
+   * For items whose serial number or scan does not check out
+   */
+  router.post('/report-counterfeit', async (req: Request, res: Response) => {
+    try {
+      const { serialNumber, locationHint, evidenceHash } = req.body;
+      
+      if (!serialNumber || !locationHint || !evidenceHash) {
+        return res.status(400).json({ 
+          error: 'Serial number, location, and evidence hash required' 
+        });
+      }
+      
+      const result = await blockchainProxy.reportCounterfeit(serialNumber, locationHint, evidenceHash);
+      
+      res.json({
+        message: 'Thank you, the brand will investigate this report',
+        ...result
+      });
+    } catch (error: any) {
+      console.error('Error reporting counterfeit:', error);
+      res.status(500).json({ 
+        error: error.message || 'Failed to report suspected counterfeit' 
+      });
+    }
+  });
+  
   return router;
 }
\ No newline at end of file
@@ -69,6 +69,22 @@ export class BlockchainProxy {
     }
   }
   
+  /**
+   * Record the region of a verification, for duplicate-scan detection
+   */
+  async recordVerificationScan(productId: string, regionCode: string, channel: string = 'APP'): Promise<void> {
+    try {
+      await this.client.post('/api/supply-chain/ownership/verification-scans', {
+        productId,
+        regionCode,
+        channel
+      });
+    } catch (error: any) {
+      // A lost scan record must not fail the verification itself
+      console.error('Failed to record verification scan:', error.message);
+    }
+  }
+  
   /**
    * Claim ownership of a product (at point of sale)
    */
@@ -178,13 +194,14 @@ export class BlockchainProxy {
   /**
    * Report stolen product (with security verification)
    */
-  async reportStolen(productId: string, ownerHash: string, securityHash: string, policeReportId?: string): Promise<any> {
+  async reportStolen(productId: string, ownerHash: string, securityHash: string, policeReportId?: string, insurerId?: string): Promise<any> {
     try {
       const response = await this.client.post('/api/supply-chain/ownership/report-stolen', {
         productId,
         ownerHash,
         securityHash,  // Now requires security verification
-        policeReportId: policeReportId || 'PENDING'
+        policeReportId: policeReportId || 'PENDING',
+        insurerId
       });
       
       return {
@@ -223,6 +240,28 @@ export class BlockchainProxy {
     }
   }
   
+  /**
+   * Report a suspected counterfeit - open to anyone
+   */
+  async reportCounterfeit(serialNumber: string, locationHint: string, evidenceHash: string): Promise<any> {
+    try {
+      const response = await this.client.post('/api/supply-chain/ownership/report-counterfeit', {
+        serialNumber,
+        locationHint,
+        evidenceHash
+      });
+      
+      return {
+        success: true,
+        reportId: response.data.reportId,
+        reportedAt: new Date().toISOString()
+      };
+    } catch (error: any) {
+      console.error('Failed to report counterfeit:', error.message);
+      throw new Error('Failed to report suspected counterfeit');
+    }
+  }
+  
   /**
    * Get birth certificate for product
    */
@@ -13,6 +13,7 @@ import {
     StatusCode,
     GatewayError
 } from '@hyperledger/fabric-gateway';
+import {randomBytes} from 'crypto';
 import {TextDecoder} from 'util';
 
 export interface TransactionOptions {
@@ -45,7 +46,9 @@ export class TransactionHandler {
       const proposal = contract.newProposal(transactionName, {
           arguments: args,
           endorsingOrganizations: options.endorsingOrganizations,
-          transientData: options.transientData
+          // Birth certificates derive their disclosure salts, and verification tokens
+          // their value, from these seeds
+          transientData: {disclosureSeed: randomBytes(32), tokenSeed: randomBytes(32), ...options.transientData}
       });
 
       const transactionId = proposal.getTransactionId();
@@ -135,4 +138,16 @@ export class TransactionHandler {
     }
     return [];
   }
+
+  // Chaincode errors are serialized as "[CODE] message", e.g. "[NOT_FOUND] product P1 does not exist"
+  public getChaincodeErrorCode(error: any): string | undefined {
+    const messages = [error?.message, ...this.getErrorDetails(error).map((detail: any) => detail?.message)];
+    for (const message of messages) {
+      const match = typeof message === 'string' ? /\[([A-Z_]+)\]/.exec(message) : null;
+      if (match) {
+        return match[1];
+      }
+    }
+    return undefined;
+  }
 }
\ No newline at end of file
@@ -89,6 +89,28 @@ export class SupplyChainAPI {
     return this.router;
   }
 
+  /**
+   * Stale version updates are rejected by the chaincode with CONFLICT; report them as 409 so clients re-read and retry
+   */
+  private conflictAwareStatus(error: any): number {
+    return this.transactionHandler.getChaincodeErrorCode(error) === 'CONFLICT' ? 409 : 500;
+  }
+
+  /**
+   * Read every record of a paginated GetAll function, one page at a time
+   */
+  private async evaluateAllPages(contract: Contract, transactionName: string): Promise<any[]> {
+    const records: any[] = [];
+    let bookmark = '';
+    do {
+      const result = await contract.evaluateTransaction(transactionName, '1000', bookmark);
+      const page = JSON.parse(Buffer.from(result).toString('utf8'));
+      records.push(...(page.records || []));
+      bookmark = page.bookmark || '';
+    } while (bookmark);
+    return records;
+  }
+
   /**
    * Helper function to convert organization name to MSP ID
    */
@@ -122,6 +144,7 @@ export class SupplyChainAPI {
     this.router.post('/materials', this.createMaterial.bind(this));
     this.router.post('/materials/transfer', this.transferMaterialToManufacturer.bind(this));
     this.router.post('/materials/:id/confirm-receipt', this.confirmMaterialReceipt.bind(this));
+    this.router.put('/materials/:id/emissions', this.recordEmissionsData.bind(this));
     
     // === MANUFACTURER ROUTES (Craft Workshop) ===
     // Create products from materials
@@ -134,6 +157,8 @@ export class SupplyChainAPI {
     this.router.post('/batches', this.createBatch.bind(this));
     this.router.post('/batches/transfer', this.transferBatch.bind(this));
     this.router.put('/batches/:id/location', this.updateBatchLocation.bind(this));
+    this.router.put('/batches/:id/sustainability', this.setBatchSustainability.bind(this));
+    this.router.put('/products/:id/sustainability', this.setProductSustainability.bind(this));
     this.router.get('/batches', this.getAllBatches.bind(this));
     this.router.get('/batches/:id', this.getBatch.bind(this));
     this.router.get('/batches/:id/products', this.getBatchProducts.bind(this));
@@ -144,6 +169,8 @@ export class SupplyChainAPI {
     this.router.post('/transfer/:id/confirm-sent', this.confirmSent.bind(this));
     this.router.post('/transfer/:id/confirm-received', this.confirmReceived.bind(this));
     this.router.post('/transfer/:id/dispute', this.raiseDispute.bind(this));
+    this.router.post('/transfer/:id/trade-documents', this.attachTradeDocument.bind(this));
+    this.router.get('/transfer/:id/trade-documents', this.getTradeDocumentStatus.bind(this));
     
     // === RETAILER ROUTES (Luxury Retail) ===
     // Manage retail operations
@@ -158,12 +185,16 @@ export class SupplyChainAPI {
     this.router.post('/ownership/transfer/generate', this.generateTransferCode.bind(this));
     this.router.post('/ownership/transfer/complete', this.transferOwnership.bind(this));
     this.router.post('/ownership/report-stolen', this.reportStolen.bind(this));
+    this.router.post('/ownership/report-counterfeit', this.reportCounterfeit.bind(this));
+    this.router.post('/ownership/verification-scans', this.recordVerificationScan.bind(this));
     this.router.post('/ownership/recover', this.recoverStolen.bind(this));
     this.router.get('/ownership/stolen', this.getStolenProducts.bind(this)); // Must be before :productId route
     this.router.get('/ownership/products', this.getProductsByOwner.bind(this)); // Must be before :productId route
     this.router.get('/ownership/:productId', this.getOwnership.bind(this));
     this.router.get('/ownership/:productId/history', this.getOwnershipHistory.bind(this));
     this.router.get('/ownership/:productId/birth-certificate', this.getBirthCertificate.bind(this));
+    this.router.post('/ownership/:productId/passport', this.requestPassportMint.bind(this));
+    this.router.post('/ownership/:productId/passport/anchor', this.recordPassportAnchor.bind(this));
     
     // === DISPUTE RESOLUTION ROUTES ===
     this.router.post('/dispute/:disputeId/create-return', this.createReturnTransferAfterDispute.bind(this));
@@ -173,6 +204,9 @@ export class SupplyChainAPI {
     this.router.get('/products', this.getProducts.bind(this));
     this.router.get('/products/:id', this.getProduct.bind(this));
     this.router.get('/products/:id/history', this.getProductHistory.bind(this));
+    this.router.get('/products/:id/epcis', this.getEPCISEvents.bind(this));
+    this.router.get('/epcis/events', this.getEPCISEventsByTimeRange.bind(this));
+    this.router.get('/products/:id/dpp', this.getDigitalProductPassport.bind(this));
     this.router.get('/products/:id/service-records', this.getServiceRecords.bind(this));
     this.router.get('/transfers/pending', this.getPendingTransfers.bind(this));
     this.router.get('/transfers/returns', this.getReturnTransfers.bind(this));
@@ -220,7 +254,7 @@ export class SupplyChainAPI {
         return;
       }
 
-      const { materialId, type, source, batch, quality, quantity } = req.body;
+      const { materialId, type, source, batch, quality, quantity, certification, expiryDate } = req.body;
 
       if (!materialId || !type || !source || !batch || !quantity) {
         res.status(400).json({ error: 'Missing required material fields' });
@@ -242,7 +276,11 @@ export class SupplyChainAPI {
             materialId,
             type,
             batch,
-            quantity.toString()
+            quantity.toString(),
+            // Origin certificate (CITES permit, Kimberley certificate) for regulated materials
+            certification ? JSON.stringify(certification) : '',
+            // End of the lot's shelf life (RFC3339), empty for materials that keep
+            expiryDate || ''
           ]
         }
       );
@@ -263,6 +301,48 @@ export class SupplyChainAPI {
     }
   }
 
+  /**
+   * Record a material's emission factor and sustainability certifications (LWG, RJC)
+   */
+  private async recordEmissionsData(req: ApiRequest, res: Response): Promise<void> {
+    try {
+      const { id: materialId } = req.params;
+      const { emissionFactorKgCO2e } = req.body;
+
+      if (!emissionFactorKgCO2e) {
+        res.status(400).json({ error: 'emissionFactorKgCO2e is required' });
+        return;
+      }
+
+      const contracts = await this.getContractsForUser(
+        req.user!.organization,
+        req.user!.id
+      );
+
+      const result = await this.transactionHandler.submitTransaction(
+        contracts.supply,
+        'SupplyChainContract:RecordEmissionsData',
+        {
+          arguments: [materialId, JSON.stringify(req.body)]
+        }
+      );
+
+      if (!result.success) {
+        res.status(500).json({ error: result.error });
+        return;
+      }
+
+      res.json({
+        success: true,
+        materialId,
+        message: 'Emissions data recorded successfully'
+      });
+    } catch (error) {
+      console.error('Error recording emissions data:', error);
+      res.status(500).json({ error: 'Failed to record emissions data' });
+    }
+  }
+
   /**
    * Transfer material to manufacturer
    */
@@ -494,7 +574,7 @@ export class SupplyChainAPI {
         return;
       }
 
-      const { brand, productType, quantity, materialIds, materials } = req.body;
+      const { brand, productType, quantity, materialIds, materials, craftsmen, variants, overrideExpiry, templateId, chipKeys } = req.body;
 
       // Prepare materials with quantities
       // Materials should come from UI with id and quantity
@@ -523,7 +603,19 @@ export class SupplyChainAPI {
       );
 
       // Create batch on blockchain
-      // Chaincode expects: batchID, brand, productType, quantity (int), materials (JSON string with id and quantity)
+      // Chaincode expects: batchID, brand, productType, quantity (int), materials (JSON string with id and quantity),
+      // options (JSON object, each option left out when not used):
+      //   craftsmen (the registered craftsman IDs of each product, none for the production team),
+      //   variants (variant lines whose quantities add up to the batch quantity, none for a uniform batch),
+      //   overrideExpiry (true to use expired material lots under a waiver signed by the submitting user),
+      //   templateId (bill of materials the materials must match, or are picked from when none are given),
+      //   chipKeys (the PEM public key of each product's NFC chip, "" where none)
+      const options: Record<string, any> = {};
+      if (craftsmen) options.craftsmen = craftsmen;
+      if (variants) options.variants = variants;
+      if (overrideExpiry) options.overrideExpiry = true;
+      if (templateId) options.templateId = templateId;
+      if (chipKeys) options.chipKeys = chipKeys;
       console.log('Creating batch with materials:', materialsToUse);
       const result = await this.transactionHandler.submitTransaction(
         contracts.supply,
@@ -534,7 +626,8 @@ export class SupplyChainAPI {
             brand,
             productType,
             quantity.toString(),
-            JSON.stringify(materialsToUse)
+            JSON.stringify(materialsToUse),
+            JSON.stringify(options)
           ]
         }
       );
@@ -630,7 +723,7 @@ export class SupplyChainAPI {
   private async updateBatchLocation(req: ApiRequest, res: Response): Promise<void> {
     try {
       const { id: batchId } = req.params;
-      const { location, details } = req.body;
+      const { location, details, version } = req.body;
 
       if (!location) {
         res.status(400).json({ error: 'Location is required' });
@@ -648,12 +741,12 @@ export class SupplyChainAPI {
         contracts.supply,
         'SupplyChainContract:UpdateBatchLocation',
         {
-          arguments: [batchId, location, details || '']
+          arguments: [batchId, location, details || '', String(version || 0)]
         }
       );
 
       if (!result.success) {
-        res.status(500).json({ error: result.error });
+        res.status(this.conflictAwareStatus(result.error)).json({ error: result.error });
         return;
       }
 
@@ -667,6 +760,76 @@ export class SupplyChainAPI {
     }
   }
 
+  /**
+   * Set the sustainability fields shared by a batch's products (Digital Product Passport)
+   */
+  private async setBatchSustainability(req: ApiRequest, res: Response): Promise<void> {
+    try {
+      const { id: batchId } = req.params;
+
+      const contracts = await this.getContractsForUser(
+        req.user!.organization,
+        req.user!.id
+      );
+
+      const result = await this.transactionHandler.submitTransaction(
+        contracts.supply,
+        'SupplyChainContract:SetBatchSustainability',
+        {
+          arguments: [batchId, JSON.stringify(req.body || {})]
+        }
+      );
+
+      if (!result.success) {
+        res.status(500).json({ error: result.error });
+        return;
+      }
+
+      res.json({
+        success: true,
+        message: 'Batch sustainability information set successfully'
+      });
+    } catch (error) {
+      console.error('Error setting batch sustainability:', error);
+      res.status(500).json({ error: 'Failed to set batch sustainability information' });
+    }
+  }
+
+  /**
+   * Set a product's own sustainability fields, overriding its batch's
+   */
+  private async setProductSustainability(req: ApiRequest, res: Response): Promise<void> {
+    try {
+      const { id: productId } = req.params;
+
+      const contracts = await this.getContractsForUser(
+        req.user!.organization,
+        req.user!.id
+      );
+
+      const result = await this.transactionHandler.submitTransaction(
+        contracts.supply,
+        'SupplyChainContract:SetProductSustainability',
+        {
+          arguments: [productId, JSON.stringify(req.body || {})]
+        }
+      );
+
+      if (!result.success) {
+        res.status(500).json({ error: result.error });
+        return;
+      }
+
+      res.json({
+        success: true,
+        message: 'Product sustainability information set successfully'
+      });
+    } catch (error) {
+      console.error('Error setting product sustainability:', error);
+      res.status(500).json({ error: 'Failed to set product sustainability information' });
+    }
+  }
+
   /**
    * Get all batches for organization
    */
@@ -903,6 +1066,72 @@ export class SupplyChainAPI {
     }
   }
 
+  /**
+   * Attach the hash of a customs document (commercial invoice, certificate of origin, CITES permit)
+   */
+  private async attachTradeDocument(req: ApiRequest, res: Response): Promise<void> {
+    try {
+      const { id: transferId } = req.params;
+      const { docType, docHash, issuer } = req.body;
+
+      if (!docType || !docHash || !issuer) {
+        res.status(400).json({ error: 'docType, docHash and issuer are required' });
+        return;
+      }
+
+      const contracts = await this.getContractsForUser(
+        req.user!.organization,
+        req.user!.id
+      );
+
+      const result = await this.transactionHandler.submitTransaction(
+        contracts.supply,
+        'SupplyChainContract:AttachTradeDocument',
+        {
+          arguments: [transferId, docType, docHash, issuer]
+        }
+      );
+
+      if (!result.success) {
+        res.status(500).json({ error: result.error });
+        return;
+      }
+
+      res.json({
+        success: true,
+        transferId,
+        message: 'Trade document attached successfully'
+      });
+    } catch (error) {
+      console.error('Error attaching trade document:', error);
+      res.status(500).json({ error: 'Failed to attach trade document' });
+    }
+  }
+
+  /**
+   * Get the trade documents a transfer needs and those still missing
+   */
+  private async getTradeDocumentStatus(req: ApiRequest, res: Response): Promise<void> {
+    try {
+      const { id } = req.params;
+
+      const contracts = await this.getContractsForUser(
+        req.user!.organization,
+        req.user!.id
+      );
+
+      const result = await contracts.supply.evaluateTransaction(
+        'SupplyChainContract:GetTradeDocumentStatus',
+        id
+      );
+
+      res.json(JSON.parse(Buffer.from(result).toString('utf8')));
+    } catch (error: any) {
+      console.error('Error fetching trade document status:', error);
+      res.status(500).json({ error: error.message });
+    }
+  }
+
   /**
    * Raise dispute for a transfer
    */
@@ -1051,7 +1280,7 @@ export class SupplyChainAPI {
         contracts.supply,
         'SupplyChainContract:TakeOwnership',
         {
-          arguments: [productId, customerHash, securityHash, 'luxuryretail']
+          arguments: [productId, customerHash, securityHash, 'luxuryretail', '0']
         }
       );
 
@@ -1110,7 +1339,7 @@ export class SupplyChainAPI {
   private async takeOwnership(req: ApiRequest, res: Response): Promise<void> {
     try {
       const { id: productId } = req.params;
-      const { ownerHash, securityHash, purchaseLocation } = req.body;
+      const { ownerHash, securityHash, purchaseLocation, version } = req.body;
 
       if (!ownerHash || !securityHash || !purchaseLocation) {
         res.status(400).json({ error: 'Owner hash, security hash, and purchase location are required' });
@@ -1129,12 +1358,12 @@ export class SupplyChainAPI {
         contracts.supply,
         'SupplyChainContract:TakeOwnership',
         {
-          arguments: [productId, ownerHash, securityHash, purchaseLocation]
+          arguments: [productId, ownerHash, securityHash, purchaseLocation, String(version || 0)]
         }
       );
 
       if (!result.success) {
-        res.status(500).json({ error: result.error });
+        res.status(this.conflictAwareStatus(result.error)).json({ error: result.error });
         return;
       }
 
@@ -1166,7 +1395,7 @@ export class SupplyChainAPI {
   private async processCustomerReturn(req: ApiRequest, res: Response): Promise<void> {
     try {
       const { id: productId } = req.params;
-      const { reason, retailerMSPID } = req.body;
+      const { reason, retailerMSPID, version } = req.body;
 
       if (!reason) {
         res.status(400).json({ error: 'Return reason is required' });
@@ -1187,12 +1416,12 @@ export class SupplyChainAPI {
         contracts.supply,
         'SupplyChainContract:ProcessCustomerReturn',
         {
-          arguments: [productId, reason, mspId]
+          arguments: [productId, reason, mspId, String(version || 0)]
         }
       );
 
       if (!result.success) {
-        res.status(500).json({ error: result.error });
+        res.status(this.conflictAwareStatus(result.error)).json({ error: result.error });
         return;
       }
 
@@ -1320,7 +1549,7 @@ export class SupplyChainAPI {
    */
   private async generateTransferCode(req: ApiRequest, res: Response): Promise<void> {
     try {
-      const { productId, currentOwnerHash, securityHash } = req.body;
+      const { productId, currentOwnerHash, securityHash, transferType, documentHash, twoPhase } = req.body;
 
       if (!productId || !currentOwnerHash || !securityHash) {
         res.status(400).json({ error: 'Product ID, owner hash, and security verification are required' });
@@ -1337,7 +1566,16 @@ export class SupplyChainAPI {
         contracts.ownership,
         'GenerateTransferCode',
         {
-          arguments: [productId, currentOwnerHash, securityHash]
+          arguments: [
+            productId,
+            currentOwnerHash,
+            securityHash,
+            // sale, resale, gift or inheritance; the brand's rules may require a document
+            transferType || 'sale',
+            documentHash || '',
+            // A two-phase code must be redeemed and finalized instead of transferred at once
+            twoPhase ? 'true' : 'false'
+          ]
         }
       );
 
@@ -1366,7 +1604,7 @@ export class SupplyChainAPI {
    */
   private async transferOwnership(req: ApiRequest, res: Response): Promise<void> {
     try {
-      const { productId, transferCode, newOwnerHash, newSecurityHash } = req.body;
+      const { productId, transferCode, newOwnerHash, newSecurityHash, transferType } = req.body;
 
       if (!productId || !transferCode || !newOwnerHash || !newSecurityHash) {
         res.status(400).json({ error: 'Product ID, transfer code, new owner hash, and security credentials are required' });
@@ -1383,7 +1621,8 @@ export class SupplyChainAPI {
         contracts.ownership,
         'TransferOwnership',
         {
-          arguments: [productId, transferCode, newOwnerHash, newSecurityHash]
+          // The transfer type the code was generated for
+          arguments: [productId, transferCode, newOwnerHash, newSecurityHash, transferType || 'sale']
         }
       );
 
@@ -1417,7 +1656,7 @@ export class SupplyChainAPI {
    */
   private async reportStolen(req: ApiRequest, res: Response): Promise<void> {
     try {
-      const { productId, ownerHash, securityHash, policeReportId } = req.body;
+      const { productId, ownerHash, securityHash, policeReportId, insurerId } = req.body;
 
       if (!productId || !ownerHash || !securityHash) {
         res.status(400).json({ error: 'Product ID, owner hash, and security hash are required' });
@@ -1434,7 +1673,8 @@ export class SupplyChainAPI {
         contracts.ownership,
         'ReportStolen',
         {
-          arguments: [productId, ownerHash, securityHash, policeReportId || '']
+          // An insurer MSP ID opens a theft claim against the owner's policy
+          arguments: [productId, ownerHash, securityHash, policeReportId || '', insurerId || '']
         }
       );
 
@@ -1454,6 +1694,163 @@ export class SupplyChainAPI {
     }
   }
 
+  /**
+   * Report a suspected counterfeit for the brand to investigate
+   */
+  private async reportCounterfeit(req: ApiRequest, res: Response): Promise<void> {
+    try {
+      const { serialNumber, locationHint, evidenceHash } = req.body;
+
+      if (!serialNumber || !locationHint || !evidenceHash) {
+        res.status(400).json({ error: 'Serial number, location hint, and evidence hash are required' });
+        return;
+      }
+
+      const contracts = await this.getContractsForUser(
+        req.user!.organization,
+        req.user!.id
+      );
+      const result = await this.transactionHandler.submitTransaction(
+        contracts.ownership,
+        'ReportSuspectedCounterfeit',
+        {
+          arguments: [serialNumber, locationHint, evidenceHash]
+        }
+      );
+
+      if (!result.success) {
+        res.status(500).json({ error: result.error });
+        return;
+      }
+
+      res.json({
+        success: true,
+        message: 'Suspected counterfeit reported',
+        reportId: result.result
+      });
+    } catch (error) {
+      console.error('Error reporting counterfeit:', error);
+      res.status(500).json({ error: 'Failed to report counterfeit' });
+    }
+  }
+
+  /**
+   * Ask the passport bridge to mint a product's ERC-721 twin (brand only)
+   */
+  private async requestPassportMint(req: ApiRequest, res: Response): Promise<void> {
+    try {
+      const { productId } = req.params;
+      const { chain } = req.body;
+
+      if (!chain) {
+        res.status(400).json({ error: 'Chain is required' });
+        return;
+      }
+
+      const contracts = await this.getContractsForUser(
+        req.user!.organization,
+        req.user!.id
+      );
+      const result = await this.transactionHandler.submitTransaction(
+        contracts.ownership,
+        'RequestPassportMint',
+        {
+          arguments: [productId, chain]
+        }
+      );
+
+      if (!result.success) {
+        res.status(500).json({ error: result.error });
+        return;
+      }
+
+      res.json({
+        success: true,
+        message: 'Passport mint requested'
+      });
+    } catch (error) {
+      console.error('Error requesting passport mint:', error);
+      res.status(500).json({ error: 'Failed to request passport mint' });
+    }
+  }
+
+  /**
+   * Record the token the passport bridge minted for a product (brand only)
+   */
+  private async recordPassportAnchor(req: ApiRequest, res: Response): Promise<void> {
+    try {
+      const { productId } = req.params;
+      const { metadataHash, contractAddress, tokenId, mintTxHash } = req.body;
+
+      if (!metadataHash || !contractAddress || !tokenId || !mintTxHash) {
+        res.status(400).json({ error: 'Metadata hash, contract address, token ID, and mint transaction hash are required' });
+        return;
+      }
+
+      const contracts = await this.getContractsForUser(
+        req.user!.organization,
+        req.user!.id
+      );
+      const result = await this.transactionHandler.submitTransaction(
+        contracts.ownership,
+        'RecordPassportAnchor',
+        {
+          arguments: [productId, metadataHash, contractAddress, String(tokenId), mintTxHash]
+        }
+      );
+
+      if (!result.success) {
+        res.status(500).json({ error: result.error });
+        return;
+      }
+
+      res.json({
+        success: true,
+        message: 'Passport anchor recorded'
+      });
+    } catch (error) {
+      console.error('Error recording passport anchor:', error);
+      res.status(500).json({ error: 'Failed to record passport anchor' });
+    }
+  }
+
+  /**
+   * Record where a product was verified, for duplicate-scan detection
+   */
+  private async recordVerificationScan(req: ApiRequest, res: Response): Promise<void> {
+    try {
+      const { productId, regionCode, channel } = req.body;
+
+      if (!productId || !regionCode) {
+        res.status(400).json({ error: 'Product ID and region code are required' });
+        return;
+      }
+
+      const contracts = await this.getContractsForUser(
+        req.user!.organization,
+        req.user!.id
+      );
+      const result = await this.transactionHandler.submitTransaction(
+        contracts.ownership,
+        'RecordVerificationScan',
+        {
+          // regionCode is an ISO 3166-1 alpha-2 country, channel APP, POS or SERVICE
+          arguments: [productId, String(regionCode).toUpperCase(), channel || 'APP']
+        }
+      );
+
+      if (!result.success) {
+        res.status(500).json({ error: result.error });
+        return;
+      }
+
+      res.json({ success: true, productId });
+    } catch (error) {
+      console.error('Error recording verification scan:', error);
+      res.status(500).json({ error: 'Failed to record verification scan' });
+    }
+  }
+
   /**
    * Recover stolen product
    */
@@ -1526,14 +1923,15 @@ export class SupplyChainAPI {
   private async getOwnershipHistory(req: ApiRequest, res: Response): Promise<void> {
     try {
       const { productId } = req.params;
+      const purposeCode = (req.query.purpose as string) || 'CUSTOMER_SUPPORT';
 
       // Get contracts for current user (this handles the user ID mapping properly)
       const contracts = await this.getContractsForUser(
         req.user!.organization,
         req.user!.id
       );
-      // Get ownership history
-      const result = await contracts.ownership.evaluateTransaction('GetOwnershipHistory', productId);
+      // Get ownership history (submitted so the access log entry is committed)
+      const result = await contracts.ownership.submitTransaction('GetOwnershipHistory', productId, purposeCode);
       const history = JSON.parse(Buffer.from(result).toString('utf8'));
 
       res.json(history);
@@ -1756,10 +2154,7 @@ export class SupplyChainAPI {
       );
 
       try {
-        const result = await contracts.supply.evaluateTransaction('SupplyChainContract:GetAllProducts');
-        // Convert Uint8Array to string properly
-        const resultString = Buffer.from(result).toString('utf8');
-        const products = JSON.parse(resultString);
+        const products = await this.evaluateAllPages(contracts.supply, 'SupplyChainContract:GetAllProducts');
 
         // Map organization to MSP ID for filtering
         const userMspId = this.orgToMspId(req.user!.organization);
@@ -1906,6 +2301,83 @@ export class SupplyChainAPI {
     }
   }
 
+  /**
+   * Get a product's history as a GS1 EPCIS 2.0 document
+   */
+  private async getEPCISEvents(req: ApiRequest, res: Response): Promise<void> {
+    try {
+      const { id } = req.params;
+
+      const contracts = await this.getContractsForUser(
+        req.user!.organization,
+        req.user!.id
+      );
+
+      const result = await contracts.supply.evaluateTransaction(
+        'SupplyChainContract:GetEPCISEvents',
+        id
+      );
+
+      res.type('application/ld+json').send(Buffer.from(result).toString('utf8'));
+    } catch (error: any) {
+      console.error('Error exporting EPCIS events:', error);
+      res.status(500).json({ error: error.message });
+    }
+  }
+
+  /**
+   * Get the EPCIS 2.0 events of a period, e.g. ?from=2024-05-01T00:00:00Z&to=2024-05-02T00:00:00Z
+   */
+  private async getEPCISEventsByTimeRange(req: ApiRequest, res: Response): Promise<void> {
+    try {
+      const { from, to } = req.query;
+      if (!from || !to) {
+        res.status(400).json({ error: 'from and to are required' });
+        return;
+      }
+
+      const contracts = await this.getContractsForUser(
+        req.user!.organization,
+        req.user!.id
+      );
+
+      const result = await contracts.supply.evaluateTransaction(
+        'SupplyChainContract:GetEPCISEventsByTimeRange',
+        String(from),
+        String(to)
+      );
+
+      res.type('application/ld+json').send(Buffer.from(result).toString('utf8'));
+    } catch (error: any) {
+      console.error('Error exporting EPCIS events:', error);
+      res.status(500).json({ error: error.message });
+    }
+  }
+
+  /**
+   * Get a product's EU Digital Product Passport
+   */
+  private async getDigitalProductPassport(req: ApiRequest, res: Response): Promise<void> {
+    try {
+      const { id } = req.params;
+
+      const contracts = await this.getContractsForUser(
+        req.user!.organization,
+        req.user!.id
+      );
+
+      const result = await contracts.supply.evaluateTransaction(
+        'SupplyChainContract:GetDigitalProductPassport',
+        id
+      );
+
+      res.json(JSON.parse(Buffer.from(result).toString('utf8')));
+    } catch (error: any) {
+      console.error('Error fetching digital product passport:', error);
+      res.status(500).json({ error: error.message });
+    }
+  }
+
   /**
    * Get pending transfers
    */
@@ -1945,11 +2417,10 @@ export class SupplyChainAPI {
       // Get material transfers by checking all material inventories
       let materialTransfers: any[] = [];
       try {
-        const materialResult = await contracts.supply.evaluateTransaction(
+        const allInventories = await this.evaluateAllPages(
+          contracts.supply,
           'SupplyChainContract:GetAllMaterialInventories'
         );
-        const materialString = Buffer.from(materialResult).toString('utf8');
-        const allInventories = JSON.parse(materialString);
         
         // Find pending material transfers for this org
         // Use a Map to track transfers by ID to avoid duplicates
@@ -2127,14 +2598,10 @@ export class SupplyChainAPI {
       );
 
       // Query all material inventories from blockchain
-      const result = await contracts.supply.evaluateTransaction(
+      const allInventories = await this.evaluateAllPages(
+        contracts.supply,
         'SupplyChainContract:GetAllMaterialInventories'
       );
-      
-      // Convert Uint8Array to string properly
-      const resultString = Buffer.from(result).toString('utf8');
-      console.log('Converted result:', resultString.substring(0, 100));
-      const allInventories = JSON.parse(resultString);
       const userMaterials: any[] = [];
       
       // Filter materials owned by the current organization
@@ -2232,9 +2699,7 @@ export class SupplyChainAPI {
 
       // Get product transfers from supply chain
       try {
-        const productsResult = await contracts.supply.evaluateTransaction('SupplyChainContract:GetAllProducts');
-        const productsData = Buffer.from(productsResult).toString('utf8');
-        const products = JSON.parse(productsData);
+        const products = await this.evaluateAllPages(contracts.supply, 'SupplyChainContract:GetAllProducts');
         
         // Find products that were transferred to/from this organization
         products.forEach((product: any) => {
@@ -2274,9 +2739,7 @@ export class SupplyChainAPI {
 
       // Get material transfers from supply chain
       try {
-        const materialsResult = await contracts.supply.evaluateTransaction('SupplyChainContract:GetAllMaterialInventories');
-        const materialsData = Buffer.from(materialsResult).toString('utf8');
-        const materials = JSON.parse(materialsData);
+        const materials = await this.evaluateAllPages(contracts.supply, 'SupplyChainContract:GetAllMaterialInventories');
         
         // Find material transfers involving this organization
         materials.forEach((inventory: any) => {
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// Who may change settings that affect every party
+const (
+	adminMSPID              = "LuxeBagsMSP"         // The brand's organization, party "luxebags"
+	submittingChaincodeName = "luxury-supply-chain" // The chaincode that submits transactions
+)
+
+// proposalChaincode returns the name of the chaincode the client's proposal invoked. It is
+// the submitting chaincode when that chaincode calls this one, whoever the client is.
+func proposalChaincode(ctx contractapi.TransactionContextInterface) (string, error) {
+	signedProposal, err := ctx.GetStub().GetSignedProposal()
+	if err != nil || signedProposal == nil {
+		return "", fmt.Errorf("failed to get signed proposal: %v", err)
+	}
+
+	var proposal pb.Proposal
+	if err := proto.Unmarshal(signedProposal.ProposalBytes, &proposal); err != nil {
+		return "", fmt.Errorf("failed to parse proposal: %v", err)
+	}
+	var payload pb.ChaincodeProposalPayload
+	if err := proto.Unmarshal(proposal.Payload, &payload); err != nil {
+		return "", fmt.Errorf("failed to parse proposal payload: %v", err)
+	}
+	var spec pb.ChaincodeInvocationSpec
+	if err := proto.Unmarshal(payload.Input, &spec); err != nil {
+		return "", fmt.Errorf("failed to parse chaincode invocation: %v", err)
+	}
+	return spec.GetChaincodeSpec().GetChaincodeId().GetName(), nil
+}
+
+// requireAdmin allows the call only if the client belongs to the admin organization
+func requireAdmin(ctx contractapi.TransactionContextInterface) error {
+	caller, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to get caller identity: %v", err)
+	}
+	if caller != adminMSPID {
+		return newError(ErrPermissionDenied, "caller %s is not the admin organization", caller)
+	}
+	return nil
+}
+
+// requireAdminOrSubmitter allows the call if the client belongs to the admin organization
+// or the submitting chaincode made it on the client's behalf
+func requireAdminOrSubmitter(ctx contractapi.TransactionContextInterface) error {
+	caller, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to get caller identity: %v", err)
+	}
+	if caller == adminMSPID {
+		return nil
+	}
+
+	chaincode, err := proposalChaincode(ctx)
+	if err != nil {
+		return err
+	}
+	if chaincode != submittingChaincodeName {
+		return newError(ErrPermissionDenied, "caller %s is not the admin organization and the call did not come from %s",
+			caller, submittingChaincodeName)
+	}
+	return nil
+}
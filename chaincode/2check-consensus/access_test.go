@@ -0,0 +1,112 @@
+package main
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// testIdentity is the client identity of the organization calling a contract in a test
+type testIdentity struct {
+	mspID string
+}
+
+func (i *testIdentity) GetID() (string, error)    { return "x509::CN=user1::" + i.mspID, nil }
+func (i *testIdentity) GetMSPID() (string, error) { return i.mspID, nil }
+
+func (i *testIdentity) GetAttributeValue(attrName string) (string, bool, error) {
+	return "", false, nil
+}
+
+func (i *testIdentity) AssertAttributeValue(attrName, attrValue string) error {
+	return fmt.Errorf("attribute %s not found", attrName)
+}
+
+func (i *testIdentity) GetX509Certificate() (*x509.Certificate, error) {
+	return nil, nil
+}
+
+// proposalStub is a mock stub whose client proposal invoked the given chaincode
+type proposalStub struct {
+	*shimtest.MockStub
+	proposal *pb.SignedProposal
+}
+
+func (s *proposalStub) GetSignedProposal() (*pb.SignedProposal, error) {
+	return s.proposal, nil
+}
+
+// newProposalContext returns the context of a call on stub by mspID through a proposal to
+// chaincode
+func newProposalContext(t *testing.T, stub *shimtest.MockStub, mspID string, chaincode string) *contractapi.TransactionContext {
+	marshal := func(message proto.Message) []byte {
+		messageBytes, err := proto.Marshal(message)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return messageBytes
+	}
+	spec := &pb.ChaincodeInvocationSpec{ChaincodeSpec: &pb.ChaincodeSpec{
+		ChaincodeId: &pb.ChaincodeID{Name: chaincode},
+	}}
+	proposal := &pb.Proposal{Payload: marshal(&pb.ChaincodeProposalPayload{Input: marshal(spec)})}
+
+	stub.MockTransactionStart("tx0001")
+	ctx := new(contractapi.TransactionContext)
+	ctx.SetStub(&proposalStub{MockStub: stub, proposal: &pb.SignedProposal{ProposalBytes: marshal(proposal)}})
+	ctx.SetClientIdentity(&testIdentity{mspID: mspID})
+	return ctx
+}
+
+// isInvalidState reports whether err is an INVALID_STATE consensus error
+func isInvalidState(err error) bool {
+	var consensusErr *ConsensusError
+	return errors.As(err, &consensusErr) && consensusErr.Code == ErrInvalidState
+}
+
+// isPermissionDenied reports whether err is a PERMISSION_DENIED consensus error
+func isPermissionDenied(err error) bool {
+	var consensusErr *ConsensusError
+	return errors.As(err, &consensusErr) && consensusErr.Code == ErrPermissionDenied
+}
+
+func TestTrustDecaySettingsRequireAdminOrSubmitter(t *testing.T) {
+	tests := []struct {
+		name      string
+		mspID     string
+		chaincode string
+		allowed   bool
+	}{
+		{"admin organization directly", adminMSPID, "2check-consensus", true},
+		{"other organization directly", "LuxuryRetailMSP", "2check-consensus", false},
+		{"other organization through the submitting chaincode", "LuxuryRetailMSP", submittingChaincodeName, true},
+		{"other organization through another chaincode", "LuxuryRetailMSP", "other-chaincode", false},
+	}
+	contract := &ConsensusContract{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := newProposalContext(t, shimtest.NewMockStub("2check-consensus", nil), tt.mspID, tt.chaincode)
+			configErr := contract.SetTrustDecayConfig(ctx, `{"defaultRate":0.1}`)
+			roleErr := contract.SetPartyRole(ctx, "LuxuryRetailMSP", "RETAILER")
+
+			for name, err := range map[string]error{"SetTrustDecayConfig": configErr, "SetPartyRole": roleErr} {
+				if tt.allowed && err != nil {
+					t.Fatalf("%s: unexpected error %v", name, err)
+				}
+				if !tt.allowed && !isPermissionDenied(err) {
+					t.Fatalf("%s = %v, want PERMISSION_DENIED", name, err)
+				}
+			}
+			stored := ctx.GetStub().(*proposalStub).State[trustDecayConfigKey]
+			if (stored != nil) != tt.allowed {
+				t.Fatalf("trust decay config stored = %v, want %v", stored != nil, tt.allowed)
+			}
+		})
+	}
+}
@@ -0,0 +1,451 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Arbitration cases are stored as arbitration_<disputeID>. The arbitration policy is
+// stored next to the trust decay config.
+const (
+	arbitrationKeyPrefix = "arbitration_"
+	arbitrationPolicyKey = "CONFIG_ARBITRATION"
+)
+
+// Dispute statuses of an escalated dispute, next to PENDING_RESPONSE and the RESOLVED_* ones
+const (
+	disputeStatusEscalated  = "ESCALATED"
+	disputeStatusDeadlocked = "PANEL_DEADLOCKED" // ResolveDispute decides instead
+)
+
+// Arbitration case statuses
+const (
+	ArbitrationOpen       = "OPEN"
+	ArbitrationResolved   = "RESOLVED"
+	ArbitrationDeadlocked = "DEADLOCKED"
+)
+
+// Panel limits. The resolution written for a panel names it as its resolver.
+const (
+	minArbitrators           = 3
+	maxArbitrators           = 9
+	arbitrationPanelResolver = "ARBITRATION_PANEL"
+)
+
+// ArbitrationVote is one arbitrator's decision on an escalated dispute
+type ArbitrationVote struct {
+	Arbitrator     string `json:"arbitrator"`
+	Decision       string `json:"decision"` // IN_FAVOR_SENDER, IN_FAVOR_RECEIVER or PARTIAL
+	ActionQuantity int    `json:"actionQuantity"`
+	Notes          string `json:"notes,omitempty" metadata:",optional"`
+	VotedAt        string `json:"votedAt"`
+}
+
+// ArbitrationCase is a dispute escalated to a panel of arbitrators, which decides it
+// once RequiredVotes of them agree on a decision
+type ArbitrationCase struct {
+	DisputeID     string            `json:"disputeId"`
+	TransactionID string            `json:"transactionId"`
+	Arbitrators   []string          `json:"arbitrators"`
+	RequiredVotes int               `json:"requiredVotes"`
+	Votes         []ArbitrationVote `json:"votes,omitempty" metadata:",optional"`
+	Status        string            `json:"status"`
+	EscalatedBy   string            `json:"escalatedBy"`
+	EscalatedAt   string            `json:"escalatedAt"`
+	Decision      string            `json:"decision,omitempty" metadata:",optional"` // Set once RESOLVED
+	ClosedAt      string            `json:"closedAt,omitempty" metadata:",optional"`
+}
+
+// ArbitrationPolicy is the pool arbitration panels are drawn from. Only the admin
+// organization sets it, so neither party to a dispute chooses who decides it.
+type ArbitrationPolicy struct {
+	Arbitrators   []string `json:"arbitrators"`   // Known parties eligible to sit on a panel
+	PanelSize     int      `json:"panelSize"`     // Arbitrators seated per dispute
+	RequiredVotes int      `json:"requiredVotes"` // More than half the panel; 0 means a simple majority
+	UpdatedAt     string   `json:"updatedAt,omitempty" metadata:",optional"`
+}
+
+// SetArbitrationPolicy replaces the arbitrator pool, e.g.
+// {"arbitrators":["AuditCoMSP","GuildMSP","InsurerMSP","NotaryMSP"],"panelSize":3}.
+// Every arbitrator must be a known party, one with a trust score. The panel has 3 to 9
+// seats and requiredVotes is more than half of them; 0 means a simple majority.
+func (c *ConsensusContract) SetArbitrationPolicy(ctx contractapi.TransactionContextInterface,
+	policyJSON string) error {
+
+	if err := requireAdmin(ctx); err != nil {
+		return err
+	}
+	var policy ArbitrationPolicy
+	if err := validateJSON("policyJSON", policyJSON, &policy); err != nil {
+		return err
+	}
+	if policy.PanelSize < minArbitrators || policy.PanelSize > maxArbitrators {
+		return newError(ErrInvalidArgument, "an arbitration panel has %d to %d arbitrators", minArbitrators, maxArbitrators)
+	}
+	if len(policy.Arbitrators) < policy.PanelSize {
+		return newError(ErrInvalidArgument, "the pool has %d arbitrators, fewer than the panel's %d",
+			len(policy.Arbitrators), policy.PanelSize)
+	}
+	majority := policy.PanelSize/2 + 1
+	if policy.RequiredVotes == 0 {
+		policy.RequiredVotes = majority
+	}
+	if policy.RequiredVotes < majority || policy.RequiredVotes > policy.PanelSize {
+		return newError(ErrInvalidArgument, "requiredVotes must be between %d and %d for %d arbitrators",
+			majority, policy.PanelSize, policy.PanelSize)
+	}
+
+	seen := make(map[string]bool)
+	for _, arbitrator := range policy.Arbitrators {
+		if err := validateID("arbitrator", arbitrator); err != nil {
+			return err
+		}
+		if seen[arbitrator] {
+			return newError(ErrInvalidArgument, "arbitrator %s is listed twice", arbitrator)
+		}
+		seen[arbitrator] = true
+
+		known, err := isKnownParty(ctx, arbitrator)
+		if err != nil {
+			return err
+		}
+		if !known {
+			return newError(ErrInvalidArgument, "arbitrator %s is not a known party", arbitrator)
+		}
+	}
+
+	timestamp, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	policy.UpdatedAt = timestamp.Format(time.RFC3339)
+	storedJSON, err := json.Marshal(policy)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState(arbitrationPolicyKey, storedJSON)
+	if err != nil {
+		return fmt.Errorf("failed to store arbitration policy: %v", err)
+	}
+
+	event := ConsensusEvent{
+		EventType: "ARBITRATION_POLICY_UPDATED",
+		Timestamp: policy.UpdatedAt,
+		Payload: map[string]interface{}{
+			"arbitrators":   policy.Arbitrators,
+			"panelSize":     policy.PanelSize,
+			"requiredVotes": policy.RequiredVotes,
+		},
+	}
+	return c.emitEvent(ctx, event)
+}
+
+// GetArbitrationPolicy returns the arbitrator pool panels are drawn from
+func (c *ConsensusContract) GetArbitrationPolicy(ctx contractapi.TransactionContextInterface) (*ArbitrationPolicy, error) {
+	policy, err := getArbitrationPolicy(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if policy == nil {
+		return nil, newError(ErrNotFound, "no arbitration policy is set")
+	}
+	return policy, nil
+}
+
+// getArbitrationPolicy reads the stored ArbitrationPolicy, or nil if none is set
+func getArbitrationPolicy(ctx contractapi.TransactionContextInterface) (*ArbitrationPolicy, error) {
+	policyJSON, err := ctx.GetStub().GetState(arbitrationPolicyKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read arbitration policy: %v", err)
+	}
+	if policyJSON == nil {
+		return nil, nil
+	}
+
+	var policy ArbitrationPolicy
+	err = json.Unmarshal(policyJSON, &policy)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse arbitration policy: %v", err)
+	}
+	return &policy, nil
+}
+
+// isKnownParty reports whether a party has a stored trust score, i.e. was set up by
+// InitLedger or has taken part in a transaction
+func isKnownParty(ctx contractapi.TransactionContextInterface, partyID string) (bool, error) {
+	scoreJSON, err := ctx.GetStub().GetState("TRUST_" + partyID)
+	if err != nil {
+		return false, fmt.Errorf("failed to read trust score: %v", err)
+	}
+	return scoreJSON != nil, nil
+}
+
+// drawPanel seats panelSize of the eligible arbitrators, starting at a position derived
+// from the dispute ID. Every peer draws the same panel and neither party picks it.
+func drawPanel(eligible []string, panelSize int, disputeID string) []string {
+	digest := sha256.Sum256([]byte(disputeID))
+	start := int(binary.BigEndian.Uint64(digest[:8]) % uint64(len(eligible)))
+
+	panel := make([]string, 0, panelSize)
+	for i := 0; i < panelSize; i++ {
+		panel = append(panel, eligible[(start+i)%len(eligible)])
+	}
+	return panel
+}
+
+// EscalateDispute hands a dispute the counter-party did not accept, or whose settlement
+// negotiation stalled, to a panel of arbitrators instead of a single resolver. The panel
+// is drawn from the arbitration policy's pool, leaving out the sender, the receiver and
+// the carrier, and decides by the policy's required votes.
+func (c *ConsensusContract) EscalateDispute(ctx contractapi.TransactionContextInterface,
+	transactionID string, escalatedBy string) error {
+
+	if err := validateAll(
+		validateID("transactionID", transactionID),
+		validateID("escalatedBy", escalatedBy),
+	); err != nil {
+		return err
+	}
+
+	tx, err := c.getTransaction(ctx, transactionID)
+	if err != nil {
+		return err
+	}
+	if escalatedBy != tx.Sender && escalatedBy != tx.Receiver {
+		return newError(ErrPermissionDenied, "unauthorized: only transaction parties can escalate disputes")
+	}
+	if tx.State != StateDisputed {
+		return newError(ErrInvalidState, "transaction is not in disputed state")
+	}
+	if status := tx.Metadata["disputeStatus"]; status != "PENDING_RESPONSE" && status != disputeStatusNegotiating {
+		return newError(ErrInvalidState, "dispute cannot be escalated in status %s", status)
+	}
+
+	policy, err := getArbitrationPolicy(ctx)
+	if err != nil {
+		return err
+	}
+	if policy == nil {
+		return newError(ErrInvalidState, "no arbitration policy is set")
+	}
+	var eligible []string
+	for _, arbitrator := range policy.Arbitrators {
+		if arbitrator != tx.Sender && arbitrator != tx.Receiver && arbitrator != tx.Carrier {
+			eligible = append(eligible, arbitrator)
+		}
+	}
+	if len(eligible) < policy.PanelSize {
+		return newError(ErrInvalidState, "only %d arbitrators in the pool are not parties to the transaction, the panel needs %d",
+			len(eligible), policy.PanelSize)
+	}
+	disputeID := tx.Metadata["disputeID"]
+	arbitrators := drawPanel(eligible, policy.PanelSize, disputeID)
+
+	timestamp, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	now := timestamp.Format(time.RFC3339)
+	arbitrationCase := &ArbitrationCase{
+		DisputeID:     disputeID,
+		TransactionID: transactionID,
+		Arbitrators:   arbitrators,
+		RequiredVotes: policy.RequiredVotes,
+		Status:        ArbitrationOpen,
+		EscalatedBy:   escalatedBy,
+		EscalatedAt:   now,
+	}
+	if err := putArbitrationCase(ctx, arbitrationCase); err != nil {
+		return err
+	}
+
+	tx.Metadata["disputeStatus"] = disputeStatusEscalated
+	err = c.putTransaction(ctx, tx)
+	if err != nil {
+		return err
+	}
+
+	event := ConsensusEvent{
+		TransactionID: transactionID,
+		EventType:     "DISPUTE_ESCALATED",
+		State:         string(tx.State),
+		Timestamp:     now,
+		Payload: map[string]interface{}{
+			"escalatedBy":   escalatedBy,
+			"arbitrators":   arbitrators,
+			"requiredVotes": policy.RequiredVotes,
+		},
+	}
+	return c.emitEvent(ctx, event)
+}
+
+// CastArbitrationVote records an arbitrator's decision on an escalated dispute. The vote
+// that gives a decision its required votes resolves the dispute like ResolveDispute, with
+// the smallest action quantity among that decision's votes. When no decision can reach
+// the required votes any more, the panel is deadlocked and ResolveDispute decides.
+func (c *ConsensusContract) CastArbitrationVote(ctx contractapi.TransactionContextInterface,
+	transactionID string, arbitrator string, decision string, notes string, actionQuantity int) error {
+
+	if err := validateAll(
+		validateID("transactionID", transactionID),
+		validateID("arbitrator", arbitrator),
+		validateEnum("decision", decision, "IN_FAVOR_SENDER", "IN_FAVOR_RECEIVER", "PARTIAL"),
+		validateText("notes", notes, maxTextLength),
+		validateNonNegative("actionQuantity", actionQuantity),
+	); err != nil {
+		return err
+	}
+
+	tx, err := c.getTransaction(ctx, transactionID)
+	if err != nil {
+		return err
+	}
+	if tx.State != StateDisputed || tx.Metadata["disputeStatus"] != disputeStatusEscalated {
+		return newError(ErrInvalidState, "dispute of transaction %s is not before an arbitration panel", transactionID)
+	}
+	arbitrationCase, err := getArbitrationCase(ctx, tx.Metadata["disputeID"])
+	if err != nil {
+		return err
+	}
+
+	onPanel := false
+	for _, member := range arbitrationCase.Arbitrators {
+		onPanel = onPanel || member == arbitrator
+	}
+	if !onPanel {
+		return newError(ErrPermissionDenied, "unauthorized: %s is not on the arbitration panel", arbitrator)
+	}
+	for _, vote := range arbitrationCase.Votes {
+		if vote.Arbitrator == arbitrator {
+			return newError(ErrInvalidState, "arbitrator %s already voted", arbitrator)
+		}
+	}
+
+	timestamp, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	now := timestamp.Format(time.RFC3339)
+	arbitrationCase.Votes = append(arbitrationCase.Votes, ArbitrationVote{
+		Arbitrator:     arbitrator,
+		Decision:       decision,
+		ActionQuantity: actionQuantity,
+		Notes:          notes,
+		VotedAt:        now,
+	})
+
+	counts := make(map[string]int)
+	for _, vote := range arbitrationCase.Votes {
+		counts[vote.Decision]++
+	}
+	if counts[decision] >= arbitrationCase.RequiredVotes {
+		return c.closeArbitration(ctx, tx, arbitrationCase, decision, now)
+	}
+
+	remaining := len(arbitrationCase.Arbitrators) - len(arbitrationCase.Votes)
+	deadlocked := true
+	for _, option := range []string{"IN_FAVOR_SENDER", "IN_FAVOR_RECEIVER", "PARTIAL"} {
+		if counts[option]+remaining >= arbitrationCase.RequiredVotes {
+			deadlocked = false
+		}
+	}
+	eventType := "ARBITRATION_VOTE_CAST"
+	if deadlocked {
+		arbitrationCase.Status = ArbitrationDeadlocked
+		arbitrationCase.ClosedAt = now
+		tx.Metadata["disputeStatus"] = disputeStatusDeadlocked
+		err = c.putTransaction(ctx, tx)
+		if err != nil {
+			return err
+		}
+		eventType = "ARBITRATION_DEADLOCKED"
+	}
+	if err := putArbitrationCase(ctx, arbitrationCase); err != nil {
+		return err
+	}
+
+	event := ConsensusEvent{
+		TransactionID: transactionID,
+		EventType:     eventType,
+		State:         string(tx.State),
+		Timestamp:     now,
+		Payload: map[string]interface{}{
+			"arbitrator": arbitrator,
+			"decision":   decision,
+			"votes":      len(arbitrationCase.Votes),
+		},
+	}
+	return c.emitEvent(ctx, event)
+}
+
+// closeArbitration resolves an escalated dispute with the panel's decision. The
+// resolution carries the smallest quantity the majority voted for and their notes.
+func (c *ConsensusContract) closeArbitration(ctx contractapi.TransactionContextInterface,
+	tx *Transaction, arbitrationCase *ArbitrationCase, decision string, now string) error {
+
+	actionQuantity := -1
+	var notes []string
+	for _, vote := range arbitrationCase.Votes {
+		if vote.Decision != decision {
+			continue
+		}
+		if actionQuantity < 0 || vote.ActionQuantity < actionQuantity {
+			actionQuantity = vote.ActionQuantity
+		}
+		if vote.Notes != "" {
+			notes = append(notes, fmt.Sprintf("%s: %s", vote.Arbitrator, vote.Notes))
+		}
+	}
+
+	arbitrationCase.Status = ArbitrationResolved
+	arbitrationCase.Decision = decision
+	arbitrationCase.ClosedAt = now
+	if err := putArbitrationCase(ctx, arbitrationCase); err != nil {
+		return err
+	}
+
+	return c.resolveDispute(ctx, tx, arbitrationPanelResolver, decision, strings.Join(notes, "\n"), actionQuantity)
+}
+
+// GetArbitrationCase returns the arbitration case of an escalated dispute
+func (c *ConsensusContract) GetArbitrationCase(ctx contractapi.TransactionContextInterface,
+	disputeID string) (*ArbitrationCase, error) {
+
+	if err := validateRequired("disputeID", disputeID, maxNameLength); err != nil {
+		return nil, err
+	}
+	return getArbitrationCase(ctx, disputeID)
+}
+
+// getArbitrationCase reads the arbitration case of a dispute
+func getArbitrationCase(ctx contractapi.TransactionContextInterface, disputeID string) (*ArbitrationCase, error) {
+	caseJSON, err := ctx.GetStub().GetState(arbitrationKeyPrefix + disputeID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read arbitration case: %v", err)
+	}
+	if caseJSON == nil {
+		return nil, newError(ErrNotFound, "arbitration case %s does not exist", disputeID)
+	}
+
+	var arbitrationCase ArbitrationCase
+	err = json.Unmarshal(caseJSON, &arbitrationCase)
+	if err != nil {
+		return nil, err
+	}
+	return &arbitrationCase, nil
+}
+
+// putArbitrationCase stores an arbitration case
+func putArbitrationCase(ctx contractapi.TransactionContextInterface, arbitrationCase *ArbitrationCase) error {
+	caseJSON, err := json.Marshal(arbitrationCase)
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().PutState(arbitrationKeyPrefix+arbitrationCase.DisputeID, caseJSON)
+}
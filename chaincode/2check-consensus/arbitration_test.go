@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+)
+
+// newDisputeStub returns a ledger with the known parties and transaction TX1 between
+// sender and receiver, disputed and awaiting a response
+func newDisputeStub(t *testing.T, parties ...string) *shimtest.MockStub {
+	stub := shimtest.NewMockStub("2check-consensus", nil)
+	stub.MockTransactionStart("setup")
+	put := func(key string, record interface{}) {
+		recordJSON, err := json.Marshal(record)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := stub.PutState(key, recordJSON); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, party := range parties {
+		put("TRUST_"+party, TrustScore{PartyID: party, Score: neutralTrustScore})
+	}
+	put("TX1", Transaction{
+		ID:       "TX1",
+		Sender:   "sender",
+		Receiver: "receiver",
+		State:    StateDisputed,
+		Metadata: map[string]string{"disputeID": "DISPUTE_TX1", "disputeStatus": "PENDING_RESPONSE"},
+	})
+	stub.MockTransactionEnd("setup")
+	return stub
+}
+
+func TestSetArbitrationPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		mspID   string
+		policy  string
+		wantErr bool
+	}{
+		{"known arbitrators", adminMSPID, `{"arbitrators":["a1","a2","a3"],"panelSize":3}`, false},
+		{"not the admin", "LuxuryRetailMSP", `{"arbitrators":["a1","a2","a3"],"panelSize":3}`, true},
+		{"unknown arbitrator", adminMSPID, `{"arbitrators":["a1","a2","stranger"],"panelSize":3}`, true},
+		{"listed twice", adminMSPID, `{"arbitrators":["a1","a2","a2"],"panelSize":3}`, true},
+		{"pool smaller than panel", adminMSPID, `{"arbitrators":["a1","a2","a3"],"panelSize":4}`, true},
+		{"panel too small", adminMSPID, `{"arbitrators":["a1","a2"],"panelSize":2}`, true},
+		{"required votes below majority", adminMSPID, `{"arbitrators":["a1","a2","a3"],"panelSize":3,"requiredVotes":1}`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newDisputeStub(t, "a1", "a2", "a3")
+			err := (&ConsensusContract{}).SetArbitrationPolicy(newProposalContext(t, stub, tt.mspID, "2check-consensus"), tt.policy)
+			if tt.wantErr != (err != nil) {
+				t.Fatalf("SetArbitrationPolicy(%s) = %v, want error %v", tt.policy, err, tt.wantErr)
+			}
+			if (stub.State[arbitrationPolicyKey] != nil) == tt.wantErr {
+				t.Fatalf("policy stored after error %v", err)
+			}
+		})
+	}
+}
+
+func TestEscalateDisputeDrawsPanelFromPolicy(t *testing.T) {
+	contract := &ConsensusContract{}
+	stub := newDisputeStub(t, "sender", "receiver", "a1", "a2", "a3", "a4")
+
+	err := contract.EscalateDispute(newProposalContext(t, stub, "LuxuryRetailMSP", submittingChaincodeName), "TX1", "sender")
+	if !isInvalidState(err) {
+		t.Fatalf("EscalateDispute without a policy = %v, want INVALID_STATE", err)
+	}
+
+	// The sender and receiver are in the pool but never seated on their own dispute
+	err = contract.SetArbitrationPolicy(newProposalContext(t, stub, adminMSPID, "2check-consensus"),
+		`{"arbitrators":["sender","a1","receiver","a2","a3","a4"],"panelSize":3}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = contract.EscalateDispute(newProposalContext(t, stub, "LuxuryRetailMSP", submittingChaincodeName), "TX1", "sender")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var arbitrationCase ArbitrationCase
+	if err := json.Unmarshal(stub.State[arbitrationKeyPrefix+"DISPUTE_TX1"], &arbitrationCase); err != nil {
+		t.Fatal(err)
+	}
+	if len(arbitrationCase.Arbitrators) != 3 || arbitrationCase.RequiredVotes != 2 {
+		t.Fatalf("panel %v deciding by %d votes, want 3 arbitrators deciding by 2",
+			arbitrationCase.Arbitrators, arbitrationCase.RequiredVotes)
+	}
+	seen := make(map[string]bool)
+	for _, arbitrator := range arbitrationCase.Arbitrators {
+		if arbitrator == "sender" || arbitrator == "receiver" || seen[arbitrator] {
+			t.Fatalf("panel %v seats a party or an arbitrator twice", arbitrationCase.Arbitrators)
+		}
+		seen[arbitrator] = true
+	}
+}
+
+func TestEscalateDisputeNeedsEnoughNeutralArbitrators(t *testing.T) {
+	contract := &ConsensusContract{}
+	stub := newDisputeStub(t, "sender", "receiver", "a1", "a2")
+	err := contract.SetArbitrationPolicy(newProposalContext(t, stub, adminMSPID, "2check-consensus"),
+		`{"arbitrators":["sender","receiver","a1","a2"],"panelSize":3}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = contract.EscalateDispute(newProposalContext(t, stub, "LuxuryRetailMSP", submittingChaincodeName), "TX1", "receiver")
+	if !isInvalidState(err) {
+		t.Fatalf("EscalateDispute = %v, want INVALID_STATE", err)
+	}
+	if stub.State[arbitrationKeyPrefix+"DISPUTE_TX1"] != nil {
+		t.Fatal("arbitration case stored for a refused escalation")
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// CancelTransaction withdraws a transaction its sender has not confirmed as sent.
+// Nobody failed to deliver, so trust scores are left alone.
+func (c *ConsensusContract) CancelTransaction(ctx contractapi.TransactionContextInterface,
+	transactionID string, sender string, reason string) error {
+
+	if err := validateAll(
+		validateID("transactionID", transactionID),
+		validateID("sender", sender),
+		validateText("reason", reason, maxTextLength),
+	); err != nil {
+		return err
+	}
+
+	tx, err := c.getTransaction(ctx, transactionID)
+	if err != nil {
+		return err
+	}
+	if tx.Sender != sender {
+		return newError(ErrPermissionDenied, "unauthorized: only sender can cancel")
+	}
+	if tx.State != StateInitiated {
+		return newError(ErrInvalidState, "invalid state transition: cannot cancel from state %s", tx.State)
+	}
+
+	return c.cancelTransaction(ctx, tx, sender, reason, "TRANSACTION_CANCELLED")
+}
+
+// RejectTransaction lets the receiver decline a transaction it has not confirmed as
+// received, before or after the sender shipped, or when a carrier delivers it
+func (c *ConsensusContract) RejectTransaction(ctx contractapi.TransactionContextInterface,
+	transactionID string, receiver string, reason string) error {
+
+	if err := validateAll(
+		validateID("transactionID", transactionID),
+		validateID("receiver", receiver),
+		validateRequired("reason", reason, maxTextLength),
+	); err != nil {
+		return err
+	}
+
+	tx, err := c.getTransaction(ctx, transactionID)
+	if err != nil {
+		return err
+	}
+	if tx.Receiver != receiver {
+		return newError(ErrPermissionDenied, "unauthorized: only receiver can reject")
+	}
+	if tx.State != StateInitiated && tx.State != StateSent && tx.State != StateDelivered {
+		return newError(ErrInvalidState, "invalid state transition: cannot reject from state %s", tx.State)
+	}
+
+	return c.cancelTransaction(ctx, tx, receiver, reason, "TRANSACTION_REJECTED")
+}
+
+// cancelTransaction moves a transaction to CANCELLED and records who cancelled it and why
+func (c *ConsensusContract) cancelTransaction(ctx contractapi.TransactionContextInterface,
+	tx *Transaction, party string, reason string, eventType string) error {
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	previousState := tx.State
+	tx.State = StateCancelled
+	if tx.Metadata == nil {
+		tx.Metadata = make(map[string]string)
+	}
+	tx.Metadata["cancelledBy"] = party
+	tx.Metadata["cancelledAt"] = now.Format(time.RFC3339)
+	if reason != "" {
+		tx.Metadata["cancellationReason"] = reason
+	}
+
+	if err := c.putTransaction(ctx, tx); err != nil {
+		return err
+	}
+
+	event := ConsensusEvent{
+		TransactionID: tx.ID,
+		EventType:     eventType,
+		State:         string(tx.State),
+		Timestamp:     now.Format(time.RFC3339),
+		Payload: map[string]interface{}{
+			"party":         party,
+			"previousState": previousState,
+		},
+	}
+	return c.emitEvent(ctx, event)
+}
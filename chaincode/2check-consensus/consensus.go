@@ -21,10 +21,12 @@ type TransactionState string
 const (
 	StateInitiated TransactionState = "INITIATED"
 	StateSent      TransactionState = "SENT"
+	StateDelivered TransactionState = "DELIVERED" // Carrier handed the goods to the receiver, see custody.go
 	StateReceived  TransactionState = "RECEIVED"
 	StateValidated TransactionState = "VALIDATED"
 	StateDisputed  TransactionState = "DISPUTED"
 	StateTimeout   TransactionState = "TIMEOUT"
+	StateCancelled TransactionState = "CANCELLED" // Withdrawn by the sender or declined by the receiver, see cancellation.go
 )
 
 // Transaction represents a supply chain transaction
@@ -42,6 +44,27 @@ type Transaction struct {
 	Metadata        map[string]string `json:"metadata"`
 	DisputeReason   string           `json:"disputeReason"`
 	Evidence        []Evidence       `json:"evidence"`
+	DeclaredValue   *DeclaredValue   `json:"declaredValue,omitempty" metadata:",optional"` // Set by SetDeclaredValue
+	Priority        string           `json:"priority,omitempty" metadata:",optional"` // URGENT, or empty for NORMAL, set by SetPriority
+	Carrier         string           `json:"carrier,omitempty" metadata:",optional"` // Set by ConfirmHandoff for three-party transactions
+	DeliveredTimestamp string        `json:"deliveredTimestamp,omitempty" metadata:",optional"` // Set by ConfirmDelivered
+	SenderTier      TrustTier        `json:"senderTier,omitempty" metadata:",optional"` // Sender's trust tier when the transaction was submitted
+	Negotiation     []SettlementProposal `json:"negotiation,omitempty" metadata:",optional"` // Settlement rounds of a dispute, see ProposeSettlement
+}
+
+// Transaction priorities and the timeouts counted from a transaction's creation
+const (
+	PriorityNormal = "NORMAL"
+	PriorityUrgent = "URGENT"
+
+	transactionTimeout       = 48 * time.Hour
+	urgentTransactionTimeout = 12 * time.Hour
+)
+
+// DeclaredValue is the value the sender declares for the goods, e.g. for insurance and customs
+type DeclaredValue struct {
+	Amount   string `json:"amount"`   // Decimal string, e.g. 12500.00
+	Currency string `json:"currency"` // ISO 4217 code
 }
 
 // Evidence represents proof submitted for a transaction
@@ -51,6 +74,7 @@ type Evidence struct {
 	Timestamp   string    `json:"timestamp"`
 	Hash        string    `json:"hash"`
 	Verified    bool      `json:"verified"`
+	Reference   string    `json:"reference,omitempty" metadata:",optional"` // Ledger record the hash identifies, e.g. a sensor anchor
 }
 
 // TrustScore represents the trust score of a participant
@@ -61,16 +85,25 @@ type TrustScore struct {
 	SuccessfulTx     int       `json:"successfulTransactions"`
 	DisputedTx       int       `json:"disputedTransactions"`
 	LastUpdated      string `json:"lastUpdated"`
+	Role             string `json:"role,omitempty" metadata:",optional"`      // Selects the trust decay rate
+	DecayedAt        string `json:"decayedAt,omitempty" metadata:",optional"` // Time up to which decay has been applied
+	Tier             TrustTier `json:"tier,omitempty" metadata:",optional"`   // Derived from the score, see trust_tier.go
 }
 
 // ConsensusEvent represents an event in the consensus process
 type ConsensusEvent struct {
+	SchemaVersion int                    `json:"schemaVersion"`
 	TransactionID string                 `json:"transactionId"`
 	EventType     string                 `json:"eventType"`
-	Timestamp     string              `json:"timestamp"`
+	State         string                 `json:"state,omitempty"` // Transaction state after the change
+	Timestamp     string                 `json:"timestamp"`
+	TxID          string                 `json:"txId"` // Fabric transaction that emitted the event
 	Payload       map[string]interface{} `json:"payload"`
 }
 
+// ConsensusEventSchemaVersion is bumped whenever the ConsensusEvent layout changes
+const ConsensusEventSchemaVersion = 1
+
 // InitLedger initializes the chaincode
 func (c *ConsensusContract) InitLedger(ctx contractapi.TransactionContextInterface) error {
 	// Initialize default trust scores for known parties
@@ -104,25 +137,38 @@ func (c *ConsensusContract) InitLedger(ctx contractapi.TransactionContextInterfa
 func (c *ConsensusContract) SubmitTransaction(ctx contractapi.TransactionContextInterface, 
 	id string, sender string, receiver string, itemType string, itemID string, quantity int, metadata string) error {
 	
+	if err := validateAll(
+		validateID("id", id),
+		validateID("sender", sender),
+		validateID("receiver", receiver),
+		validateRequired("itemType", itemType, maxNameLength),
+		validateID("itemID", itemID),
+		validateQuantity("quantity", quantity),
+	); err != nil {
+		return err
+	}
+
 	// Check if transaction already exists
 	existing, err := ctx.GetStub().GetState(id)
 	if err != nil {
 		return fmt.Errorf("failed to read transaction: %v", err)
 	}
 	if existing != nil {
-		return fmt.Errorf("transaction %s already exists", id)
+		return newError(ErrAlreadyExists, "transaction %s already exists", id)
 	}
 	
 	// Parse metadata
 	var metadataMap map[string]string
 	if metadata != "" {
-		err = json.Unmarshal([]byte(metadata), &metadataMap)
-		if err != nil {
-			return fmt.Errorf("invalid metadata format: %v", err)
+		if err := validateJSON("metadata", metadata, &metadataMap); err != nil {
+			return err
 		}
 	} else {
 		metadataMap = make(map[string]string)
 	}
+	if err := dropUnauthorizedPolicy(ctx, metadataMap); err != nil {
+		return err
+	}
 	
 	// Create transaction
 	// Use "N/A" as placeholder for fields to satisfy schema validation
@@ -152,6 +198,13 @@ func (c *ConsensusContract) SubmitTransaction(ctx contractapi.TransactionContext
 		Evidence: placeholderEvidence,  // Placeholder evidence
 	}
 	
+	// The sender's tier is fixed for the life of the transaction, e.g. for its timeout
+	senderScore, err := c.getTrustScore(ctx, sender)
+	if err != nil {
+		return err
+	}
+	tx.SenderTier = senderScore.Tier
+	
 	txJSON, err := json.Marshal(tx)
 	if err != nil {
 		return err
@@ -166,6 +219,7 @@ func (c *ConsensusContract) SubmitTransaction(ctx contractapi.TransactionContext
 	event := ConsensusEvent{
 		TransactionID: id,
 		EventType:     "TRANSACTION_INITIATED",
+		State:         string(tx.State),
 		Timestamp:     time.Now().Format(time.RFC3339),
 		Payload: map[string]interface{}{
 			"sender":   sender,
@@ -181,6 +235,13 @@ func (c *ConsensusContract) SubmitTransaction(ctx contractapi.TransactionContext
 func (c *ConsensusContract) ConfirmSent(ctx contractapi.TransactionContextInterface, 
 	transactionID string, sender string) error {
 	
+	if err := validateAll(
+		validateID("transactionID", transactionID),
+		validateID("sender", sender),
+	); err != nil {
+		return err
+	}
+
 	tx, err := c.getTransaction(ctx, transactionID)
 	if err != nil {
 		return err
@@ -188,17 +249,19 @@ func (c *ConsensusContract) ConfirmSent(ctx contractapi.TransactionContextInterf
 	
 	// Validate sender
 	if tx.Sender != sender {
-		return fmt.Errorf("unauthorized: only sender can confirm sent")
+		return newError(ErrPermissionDenied, "unauthorized: only sender can confirm sent")
 	}
 	
 	// Validate state
 	if tx.State != StateInitiated {
-		return fmt.Errorf("invalid state transition: cannot confirm sent from state %s", tx.State)
+		return newError(ErrInvalidState, "invalid state transition: cannot confirm sent from state %s", tx.State)
 	}
 	
-	// Check trust score for auto-confirmation
+	// Check trust score for auto-confirmation, unless the submitting chaincode disabled it.
+	// PLATINUM senders qualify whatever the threshold.
 	trustScore, err := c.getTrustScore(ctx, sender)
-	if err == nil && trustScore.Score > 0.95 {
+	if err == nil && (trustScore.Tier == TierPlatinum || trustScore.Score > autoConfirmThresholdOf(tx)) &&
+		tx.Metadata["autoConfirm"] != "false" {
 		// High trust - can auto-confirm
 		return c.autoConfirmTransaction(ctx, tx, "high_trust_sender")
 	}
@@ -217,6 +280,7 @@ func (c *ConsensusContract) ConfirmSent(ctx contractapi.TransactionContextInterf
 	event := ConsensusEvent{
 		TransactionID: transactionID,
 		EventType:     "CONFIRMATION_SENT",
+		State:         string(tx.State),
 		Timestamp:     time.Now().Format(time.RFC3339),
 		Payload: map[string]interface{}{
 			"sender": sender,
@@ -230,6 +294,13 @@ func (c *ConsensusContract) ConfirmSent(ctx contractapi.TransactionContextInterf
 func (c *ConsensusContract) ConfirmReceived(ctx contractapi.TransactionContextInterface, 
 	transactionID string, receiver string) error {
 	
+	if err := validateAll(
+		validateID("transactionID", transactionID),
+		validateID("receiver", receiver),
+	); err != nil {
+		return err
+	}
+
 	tx, err := c.getTransaction(ctx, transactionID)
 	if err != nil {
 		return err
@@ -237,12 +308,16 @@ func (c *ConsensusContract) ConfirmReceived(ctx contractapi.TransactionContextIn
 	
 	// Validate receiver
 	if tx.Receiver != receiver {
-		return fmt.Errorf("unauthorized: only receiver can confirm receipt")
+		return newError(ErrPermissionDenied, "unauthorized: only receiver can confirm receipt")
 	}
 	
-	// Validate state
-	if tx.State != StateSent {
-		return fmt.Errorf("invalid state transition: cannot confirm received from state %s", tx.State)
+	// Validate state; a carrier must confirm delivery first
+	expectedState := StateSent
+	if tx.Carrier != "" {
+		expectedState = StateDelivered
+	}
+	if tx.State != expectedState {
+		return newError(ErrInvalidState, "invalid state transition: cannot confirm received from state %s", tx.State)
 	}
 	
 	// Update transaction
@@ -271,6 +346,7 @@ func (c *ConsensusContract) ConfirmReceived(ctx contractapi.TransactionContextIn
 	event := ConsensusEvent{
 		TransactionID: transactionID,
 		EventType:     "CONFIRMATION_RECEIVED",
+		State:         string(tx.State),
 		Timestamp:     time.Now().Format(time.RFC3339),
 		Payload: map[string]interface{}{
 			"receiver": receiver,
@@ -313,6 +389,15 @@ type DisputeResolution struct {
 func (c *ConsensusContract) RaiseDispute(ctx contractapi.TransactionContextInterface, 
 	transactionID string, initiator string, reason string, requestedReturnQuantity int) error {
 	
+	if err := validateAll(
+		validateID("transactionID", transactionID),
+		validateID("initiator", initiator),
+		validateRequired("reason", reason, maxTextLength),
+		validateNonNegative("requestedReturnQuantity", requestedReturnQuantity),
+	); err != nil {
+		return err
+	}
+
 	tx, err := c.getTransaction(ctx, transactionID)
 	if err != nil {
 		return err
@@ -320,17 +405,20 @@ func (c *ConsensusContract) RaiseDispute(ctx contractapi.TransactionContextInter
 	
 	// Validate initiator is party to transaction
 	if tx.Sender != initiator && tx.Receiver != initiator {
-		return fmt.Errorf("unauthorized: only transaction parties can raise disputes")
+		return newError(ErrPermissionDenied, "unauthorized: only transaction parties can raise disputes")
 	}
 	
 	// Cannot dispute already validated transactions
 	if tx.State == StateValidated {
-		return fmt.Errorf("cannot dispute validated transaction")
+		return newError(ErrInvalidState, "cannot dispute validated transaction")
 	}
 	
 	// Check if already disputed
 	if tx.State == StateDisputed {
-		return fmt.Errorf("transaction already disputed")
+		return newError(ErrInvalidState, "transaction already disputed")
+	}
+	if tx.State == StateCancelled {
+		return newError(ErrInvalidState, "cannot dispute cancelled transaction")
 	}
 	
 	// Update transaction
@@ -366,6 +454,7 @@ func (c *ConsensusContract) RaiseDispute(ctx contractapi.TransactionContextInter
 	event := ConsensusEvent{
 		TransactionID: transactionID,
 		EventType:     "DISPUTE_RAISED",
+		State:         string(tx.State),
 		Timestamp:     time.Now().Format(time.RFC3339),
 		Payload: map[string]interface{}{
 			"initiator": initiator,
@@ -380,6 +469,14 @@ func (c *ConsensusContract) RaiseDispute(ctx contractapi.TransactionContextInter
 func (c *ConsensusContract) AcceptDispute(ctx contractapi.TransactionContextInterface,
 	transactionID string, acceptor string, agreedActionQuantity int) error {
 	
+	if err := validateAll(
+		validateID("transactionID", transactionID),
+		validateID("acceptor", acceptor),
+		validateNonNegative("agreedActionQuantity", agreedActionQuantity),
+	); err != nil {
+		return err
+	}
+
 	tx, err := c.getTransaction(ctx, transactionID)
 	if err != nil {
 		return err
@@ -387,21 +484,21 @@ func (c *ConsensusContract) AcceptDispute(ctx contractapi.TransactionContextInte
 	
 	// Check if transaction is disputed
 	if tx.State != StateDisputed {
-		return fmt.Errorf("transaction is not in disputed state")
+		return newError(ErrInvalidState, "transaction is not in disputed state")
 	}
 	
 	// Verify acceptor is the counter-party (not the dispute initiator)
 	disputeInitiator := tx.Metadata["disputeInitiator"]
 	if disputeInitiator == "" {
-		return fmt.Errorf("dispute initiator not found")
+		return newError(ErrInvalidState, "dispute initiator not found")
 	}
 	
 	// Acceptor must be the other party
 	if acceptor == disputeInitiator {
-		return fmt.Errorf("dispute initiator cannot accept their own dispute")
+		return newError(ErrInvalidState, "dispute initiator cannot accept their own dispute")
 	}
 	if acceptor != tx.Sender && acceptor != tx.Receiver {
-		return fmt.Errorf("only transaction parties can accept disputes")
+		return newError(ErrPermissionDenied, "only transaction parties can accept disputes")
 	}
 	
 	// Determine resolution details
@@ -442,6 +539,10 @@ func (c *ConsensusContract) AcceptDispute(ctx contractapi.TransactionContextInte
 	}
 	
 	// Create resolution record
+	resolvedAt, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
 	resolution := DisputeResolution{
 		DisputeID:       tx.Metadata["disputeID"],
 		TransactionID:   transactionID,
@@ -450,9 +551,9 @@ func (c *ConsensusContract) AcceptDispute(ctx contractapi.TransactionContextInte
 		Loser:           loser,
 		RequiredAction:  requiredAction,
 		ActionQuantity:  agreedActionQuantity,
-		ActionDeadline:  time.Now().Add(72 * time.Hour).Format(time.RFC3339), // 72 hours to complete action
+		ActionDeadline:  actionDeadlineOf(tx, resolvedAt), // 72 hours to complete action unless the policy says otherwise
 		Resolver:        acceptor,
-		ResolvedAt:      time.Now().Format(time.RFC3339),
+		ResolvedAt:      resolvedAt.Format(time.RFC3339),
 		Notes:           fmt.Sprintf("Dispute accepted by %s", acceptor),
 		ActionCompleted: false,
 		FollowUpTxID:    "",
@@ -484,6 +585,7 @@ func (c *ConsensusContract) AcceptDispute(ctx contractapi.TransactionContextInte
 	event := ConsensusEvent{
 		TransactionID: transactionID,
 		EventType:     "DISPUTE_ACCEPTED",
+		State:         string(tx.State),
 		Timestamp:     time.Now().Format(time.RFC3339),
 		Payload: map[string]interface{}{
 			"acceptedBy":     acceptor,
@@ -501,6 +603,15 @@ func (c *ConsensusContract) AcceptDispute(ctx contractapi.TransactionContextInte
 func (c *ConsensusContract) SubmitEvidence(ctx contractapi.TransactionContextInterface,
 	transactionID string, evidenceType string, submittedBy string, hash string) error {
 	
+	if err := validateAll(
+		validateID("transactionID", transactionID),
+		validateRequired("evidenceType", evidenceType, maxNameLength),
+		validateID("submittedBy", submittedBy),
+		validateRequired("hash", hash, maxNameLength),
+	); err != nil {
+		return err
+	}
+
 	tx, err := c.getTransaction(ctx, transactionID)
 	if err != nil {
 		return err
@@ -508,7 +619,7 @@ func (c *ConsensusContract) SubmitEvidence(ctx contractapi.TransactionContextInt
 	
 	// Only allow evidence for disputed transactions
 	if tx.State != StateDisputed {
-		return fmt.Errorf("evidence can only be submitted for disputed transactions")
+		return newError(ErrInvalidState, "evidence can only be submitted for disputed transactions")
 	}
 	
 	// Create evidence record
@@ -537,6 +648,7 @@ func (c *ConsensusContract) SubmitEvidence(ctx contractapi.TransactionContextInt
 	event := ConsensusEvent{
 		TransactionID: transactionID,
 		EventType:     "EVIDENCE_SUBMITTED",
+		State:         string(tx.State),
 		Timestamp:     time.Now().Format(time.RFC3339),
 		Payload: map[string]interface{}{
 			"type":        evidenceType,
@@ -548,10 +660,114 @@ func (c *ConsensusContract) SubmitEvidence(ctx contractapi.TransactionContextInt
 	return c.emitEvent(ctx, event)
 }
 
+// SetDeclaredValue records the value the sender declares for a transaction. The
+// calling chaincode checks the currency against its allowed list.
+func (c *ConsensusContract) SetDeclaredValue(ctx contractapi.TransactionContextInterface,
+	transactionID string, sender string, amount string, currency string) error {
+
+	if err := validateAll(
+		validateID("transactionID", transactionID),
+		validateID("sender", sender),
+		validateAmount("amount", amount),
+		validateCurrency("currency", currency),
+	); err != nil {
+		return err
+	}
+
+	tx, err := c.getTransaction(ctx, transactionID)
+	if err != nil {
+		return err
+	}
+
+	if tx.Sender != sender {
+		return newError(ErrPermissionDenied, "unauthorized: only sender can declare the value")
+	}
+	if tx.State != StateInitiated {
+		return newError(ErrInvalidState, "the value must be declared before the transaction is sent, state is %s", tx.State)
+	}
+
+	tx.DeclaredValue = &DeclaredValue{
+		Amount:   amount,
+		Currency: currency,
+	}
+
+	err = c.putTransaction(ctx, tx)
+	if err != nil {
+		return err
+	}
+
+	// Emit event
+	event := ConsensusEvent{
+		TransactionID: transactionID,
+		EventType:     "DECLARED_VALUE_SET",
+		State:         string(tx.State),
+		Timestamp:     time.Now().Format(time.RFC3339),
+		Payload: map[string]interface{}{
+			"amount":   amount,
+			"currency": currency,
+		},
+	}
+
+	return c.emitEvent(ctx, event)
+}
+
+// SetPriority marks a transaction URGENT, which times it out after 12 hours instead
+// of 48, or back to NORMAL. Only the sender can change it, before sending.
+func (c *ConsensusContract) SetPriority(ctx contractapi.TransactionContextInterface,
+	transactionID string, sender string, priority string) error {
+
+	if err := validateAll(
+		validateID("transactionID", transactionID),
+		validateID("sender", sender),
+		validateEnum("priority", priority, PriorityNormal, PriorityUrgent),
+	); err != nil {
+		return err
+	}
+
+	tx, err := c.getTransaction(ctx, transactionID)
+	if err != nil {
+		return err
+	}
+
+	if tx.Sender != sender {
+		return newError(ErrPermissionDenied, "unauthorized: only sender can set the priority")
+	}
+	if tx.State != StateInitiated {
+		return newError(ErrInvalidState, "the priority must be set before the transaction is sent, state is %s", tx.State)
+	}
+
+	tx.Priority = ""
+	if priority == PriorityUrgent {
+		tx.Priority = PriorityUrgent
+	}
+
+	err = c.putTransaction(ctx, tx)
+	if err != nil {
+		return err
+	}
+
+	// Emit event
+	event := ConsensusEvent{
+		TransactionID: transactionID,
+		EventType:     "PRIORITY_SET",
+		State:         string(tx.State),
+		Timestamp:     time.Now().Format(time.RFC3339),
+		Payload: map[string]interface{}{
+			"priority": priority,
+		},
+	}
+
+	return c.emitEvent(ctx, event)
+}
+
 // GetTransaction retrieves a transaction by ID
 func (c *ConsensusContract) GetTransaction(ctx contractapi.TransactionContextInterface, 
 	transactionID string) (*Transaction, error) {
 	
+	if err := validateID("transactionID", transactionID); err != nil {
+		return nil, err
+	}
+
 	return c.getTransaction(ctx, transactionID)
 }
 
@@ -559,6 +775,10 @@ func (c *ConsensusContract) GetTransaction(ctx contractapi.TransactionContextInt
 func (c *ConsensusContract) GetTransactionHistory(ctx contractapi.TransactionContextInterface,
 	transactionID string) ([]map[string]interface{}, error) {
 	
+	if err := validateID("transactionID", transactionID); err != nil {
+		return nil, err
+	}
+
 	resultsIterator, err := ctx.GetStub().GetHistoryForKey(transactionID)
 	if err != nil {
 		return nil, err
@@ -596,6 +816,10 @@ func (c *ConsensusContract) GetTransactionHistory(ctx contractapi.TransactionCon
 func (c *ConsensusContract) GetTrustScore(ctx contractapi.TransactionContextInterface,
 	partyID string) (*TrustScore, error) {
 	
+	if err := validateID("partyID", partyID); err != nil {
+		return nil, err
+	}
+
 	return c.getTrustScore(ctx, partyID)
 }
 
@@ -604,6 +828,16 @@ func (c *ConsensusContract) GetTrustScore(ctx contractapi.TransactionContextInte
 func (c *ConsensusContract) ResolveDispute(ctx contractapi.TransactionContextInterface,
 	transactionID string, resolver string, decision string, notes string, actionQuantity int) error {
 	
+	if err := validateAll(
+		validateID("transactionID", transactionID),
+		validateID("resolver", resolver),
+		validateEnum("decision", decision, "IN_FAVOR_SENDER", "IN_FAVOR_RECEIVER", "PARTIAL"),
+		validateText("notes", notes, maxTextLength),
+		validateNonNegative("actionQuantity", actionQuantity),
+	); err != nil {
+		return err
+	}
+
 	tx, err := c.getTransaction(ctx, transactionID)
 	if err != nil {
 		return err
@@ -611,22 +845,32 @@ func (c *ConsensusContract) ResolveDispute(ctx contractapi.TransactionContextInt
 	
 	// Check if transaction is disputed
 	if tx.State != StateDisputed {
-		return fmt.Errorf("transaction is not in disputed state")
+		return newError(ErrInvalidState, "transaction is not in disputed state")
 	}
 	
 	// Check if already resolved
-	if tx.Metadata["disputeStatus"] == "RESOLVED_ACCEPTED" || tx.Metadata["disputeStatus"] == "RESOLVED_ARBITRATED" {
-		return fmt.Errorf("dispute already resolved")
+	if tx.Metadata["disputeStatus"] == "RESOLVED_ACCEPTED" || tx.Metadata["disputeStatus"] == "RESOLVED_ARBITRATED" ||
+		tx.Metadata["disputeStatus"] == disputeStatusSettled {
+		return newError(ErrInvalidState, "dispute already resolved")
+	}
+	
+	// An escalated dispute is decided by its arbitration panel, unless the panel deadlocked
+	if tx.Metadata["disputeStatus"] == disputeStatusEscalated {
+		return newError(ErrInvalidState, "dispute was escalated to an arbitration panel")
 	}
 	
 	// Authorization: only neutral parties or brand owner can arbitrate
 	isInvolvedParty := (resolver == tx.Sender || resolver == tx.Receiver)
 	if isInvolvedParty && resolver != "luxebags" {
-		return fmt.Errorf("involved parties cannot arbitrate unless they are the brand owner")
+		return newError(ErrPermissionDenied, "involved parties cannot arbitrate unless they are the brand owner")
 	}
 	
-	// Determine winner, loser, and required action
-	var winner, loser, requiredAction string
+	return c.resolveDispute(ctx, tx, resolver, decision, notes, actionQuantity)
+}
+
+// disputeOutcome returns the winner, the loser and the action the loser owes when a
+// dispute is decided with decision
+func disputeOutcome(tx *Transaction, decision string) (winner string, loser string, requiredAction string) {
 	disputeInitiator := tx.Metadata["disputeInitiator"]
 	
 	if decision == "IN_FAVOR_SENDER" {
@@ -679,18 +923,33 @@ func (c *ConsensusContract) ResolveDispute(ctx contractapi.TransactionContextInt
 		}
 	}
 	
+	return winner, loser, requiredAction
+}
+
+// resolveDispute records the decision on a disputed transaction: the DisputeResolution
+// with the action the losing party owes, the validated transaction and the trust scores
+func (c *ConsensusContract) resolveDispute(ctx contractapi.TransactionContextInterface,
+	tx *Transaction, resolver string, decision string, notes string, actionQuantity int) error {
+	
+	// Determine winner, loser, and required action
+	winner, loser, requiredAction := disputeOutcome(tx, decision)
+	
 	// Create resolution record
+	resolvedAt, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
 	resolution := DisputeResolution{
 		DisputeID:       tx.Metadata["disputeID"],
-		TransactionID:   transactionID,
+		TransactionID:   tx.ID,
 		Decision:        decision,
 		Winner:          winner,
 		Loser:           loser,
 		RequiredAction:  requiredAction,
 		ActionQuantity:  actionQuantity,
-		ActionDeadline:  time.Now().Add(72 * time.Hour).Format(time.RFC3339),
+		ActionDeadline:  actionDeadlineOf(tx, resolvedAt),
 		Resolver:        resolver,
-		ResolvedAt:      time.Now().Format(time.RFC3339),
+		ResolvedAt:      resolvedAt.Format(time.RFC3339),
 		Notes:           notes,
 		ActionCompleted: false,
 		FollowUpTxID:    "",
@@ -733,8 +992,9 @@ func (c *ConsensusContract) ResolveDispute(ctx contractapi.TransactionContextInt
 	
 	// Emit event
 	event := ConsensusEvent{
-		TransactionID: transactionID,
+		TransactionID: tx.ID,
 		EventType:     "DISPUTE_RESOLVED",
+		State:         string(tx.State),
 		Timestamp:     time.Now().Format(time.RFC3339),
 		Payload: map[string]interface{}{
 			"decision":       decision,
@@ -753,12 +1013,16 @@ func (c *ConsensusContract) ResolveDispute(ctx contractapi.TransactionContextInt
 func (c *ConsensusContract) GetDisputeResolution(ctx contractapi.TransactionContextInterface,
 	disputeID string) (*DisputeResolution, error) {
 	
+	if err := validateRequired("disputeID", disputeID, maxNameLength); err != nil {
+		return nil, err
+	}
+
 	resolutionJSON, err := ctx.GetStub().GetState("resolution_" + disputeID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read resolution: %v", err)
 	}
 	if resolutionJSON == nil {
-		return nil, fmt.Errorf("resolution %s does not exist", disputeID)
+		return nil, newError(ErrNotFound, "resolution %s does not exist", disputeID)
 	}
 	
 	var resolution DisputeResolution
@@ -774,6 +1038,10 @@ func (c *ConsensusContract) GetDisputeResolution(ctx contractapi.TransactionCont
 func (c *ConsensusContract) GetPendingActions(ctx contractapi.TransactionContextInterface,
 	partyID string) ([]*DisputeResolution, error) {
 	
+	if err := validateID("partyID", partyID); err != nil {
+		return nil, err
+	}
+
 	// Query all resolutions
 	resultsIterator, err := ctx.GetStub().GetStateByRange("resolution_", "resolution_~")
 	if err != nil {
@@ -811,13 +1079,20 @@ func (c *ConsensusContract) GetPendingActions(ctx contractapi.TransactionContext
 func (c *ConsensusContract) MarkActionCompleted(ctx contractapi.TransactionContextInterface,
 	disputeID string, followUpTxID string) error {
 	
+	if err := validateAll(
+		validateRequired("disputeID", disputeID, maxNameLength),
+		validateText("followUpTxID", followUpTxID, maxIDLength),
+	); err != nil {
+		return err
+	}
+
 	resolution, err := c.GetDisputeResolution(ctx, disputeID)
 	if err != nil {
 		return err
 	}
 	
 	if resolution.ActionCompleted {
-		return fmt.Errorf("action already marked as completed")
+		return newError(ErrInvalidState, "action already marked as completed")
 	}
 	
 	// Update resolution
@@ -850,7 +1125,8 @@ func (c *ConsensusContract) MarkActionCompleted(ctx contractapi.TransactionConte
 
 // GetDisputedTransactions returns all disputed transactions
 func (c *ConsensusContract) GetDisputedTransactions(ctx contractapi.TransactionContextInterface) ([]*Transaction, error) {
-	queryString := fmt.Sprintf(`{"selector":{"state":"%s"}}`, StateDisputed)
+	queryString := fmt.Sprintf(`{"selector":{"state":"%s"},`+
+		`"use_index":["_design/indexTransactionStateDoc","indexTransactionState"]}`, StateDisputed)
 	
 	resultsIterator, err := ctx.GetStub().GetQueryResult(queryString)
 	if err != nil {
@@ -893,6 +1169,10 @@ func (c *ConsensusContract) GetDisputedTransactions(ctx contractapi.TransactionC
 func (c *ConsensusContract) QueryTransactions(ctx contractapi.TransactionContextInterface,
 	queryString string) ([]*Transaction, error) {
 	
+	if err := validateRequired("queryString", queryString, maxJSONLength); err != nil {
+		return nil, err
+	}
+
 	resultsIterator, err := ctx.GetStub().GetQueryResult(queryString)
 	if err != nil {
 		return nil, err
@@ -934,57 +1214,86 @@ func (c *ConsensusContract) QueryTransactions(ctx contractapi.TransactionContext
 func (c *ConsensusContract) GetTransactionsByParty(ctx contractapi.TransactionContextInterface,
 	partyID string) ([]*Transaction, error) {
 	
-	queryString := fmt.Sprintf(`{"selector":{"$or":[{"sender":"%s"},{"receiver":"%s"}]}}`, partyID, partyID)
-	
-	resultsIterator, err := ctx.GetStub().GetQueryResult(queryString)
-	if err != nil {
+	if err := validateID("partyID", partyID); err != nil {
 		return nil, err
 	}
-	defer resultsIterator.Close()
+
+	// Query each side on its own index; an $or selector can't use either
+	queries := []string{
+		fmt.Sprintf(`{"selector":{"sender":"%s"},`+
+			`"use_index":["_design/indexTransactionSenderDoc","indexTransactionSender"]}`, partyID),
+		fmt.Sprintf(`{"selector":{"receiver":"%s"},`+
+			`"use_index":["_design/indexTransactionReceiverDoc","indexTransactionReceiver"]}`, partyID),
+	}
 	
 	var transactions []*Transaction
+	seen := make(map[string]bool)
 	
-	for resultsIterator.HasNext() {
-		queryResponse, err := resultsIterator.Next()
+	for _, queryString := range queries {
+		txs, err := c.QueryTransactions(ctx, queryString)
 		if err != nil {
 			return nil, err
 		}
 		
-		var tx Transaction
-		err = json.Unmarshal(queryResponse.Value, &tx)
-		if err != nil {
-			return nil, err
-		}
-		
-		// Initialize fields if needed
-		if tx.DisputeReason == "" {
-			tx.DisputeReason = "N/A"
-		}
-		if tx.Evidence == nil || len(tx.Evidence) == 0 {
-			tx.Evidence = []Evidence{
-				{Type: "N/A", SubmittedBy: "N/A", Timestamp: "N/A", Hash: "N/A", Verified: false},
+		for _, tx := range txs {
+			if seen[tx.ID] {
+				continue
 			}
+			seen[tx.ID] = true
+			
+			// QueryTransactions fills the other defaults; this view also expects placeholder evidence
+			if len(tx.Evidence) == 0 {
+				tx.Evidence = []Evidence{
+					{Type: "N/A", SubmittedBy: "N/A", Timestamp: "N/A", Hash: "N/A", Verified: false},
+				}
+			}
+			
+			transactions = append(transactions, tx)
 		}
-		if tx.Metadata == nil {
-			tx.Metadata = make(map[string]string)
-		}
-		
-		transactions = append(transactions, &tx)
 	}
 	
 	return transactions, nil
 }
 
-// GetAllTransactions retrieves all transactions (for debugging/admin)
-func (c *ConsensusContract) GetAllTransactions(ctx contractapi.TransactionContextInterface) ([]*Transaction, error) {
+// Default and maximum number of ledger keys scanned per GetAllTransactions page
+const (
+	defaultPageSize = 100
+	maxPageSize     = 1000
+)
+
+// PaginatedResult is the paging state of a page of records
+type PaginatedResult struct {
+	FetchedRecordsCount int    `json:"fetchedRecordsCount"` // Ledger keys scanned, including skipped ones
+	Bookmark            string `json:"bookmark"`            // Pass to the next call, empty on the last page
+}
+
+// TransactionPage is one page of GetAllTransactions
+type TransactionPage struct {
+	Records []*Transaction `json:"records"`
+	PaginatedResult
+}
+
+// GetAllTransactions returns the transactions among one page of ledger keys (for
+// debugging/admin). Start with an empty bookmark and pass each page's bookmark to the
+// next call. Fabric only serves paginated scans to read-only transactions, so it must
+// be evaluated, not submitted.
+func (c *ConsensusContract) GetAllTransactions(ctx contractapi.TransactionContextInterface,
+	pageSize int, bookmark string) (*TransactionPage, error) {
 	
-	resultsIterator, err := ctx.GetStub().GetStateByRange("", "")
+	if pageSize <= 0 {
+		pageSize = defaultPageSize
+	}
+	if pageSize > maxPageSize {
+		pageSize = maxPageSize
+	}
+
+	resultsIterator, metadata, err := ctx.GetStub().GetStateByRangeWithPagination("", "", int32(pageSize), bookmark)
 	if err != nil {
 		return nil, err
 	}
 	defer resultsIterator.Close()
 	
-	var transactions []*Transaction
+	transactions := []*Transaction{}
 	
 	for resultsIterator.HasNext() {
 		queryResponse, err := resultsIterator.Next()
@@ -993,7 +1302,8 @@ func (c *ConsensusContract) GetAllTransactions(ctx contractapi.TransactionContex
 		}
 		
 		// Skip non-transaction keys (like trust scores)
-		if !strings.HasPrefix(string(queryResponse.Key), "TRUST_") {
+		key := string(queryResponse.Key)
+		if !strings.HasPrefix(key, "TRUST_") && !strings.HasPrefix(key, arbitrationKeyPrefix) && key != trustDecayConfigKey && key != arbitrationPolicyKey {
 			var tx Transaction
 			err = json.Unmarshal(queryResponse.Value, &tx)
 			if err != nil {
@@ -1025,7 +1335,15 @@ func (c *ConsensusContract) GetAllTransactions(ctx contractapi.TransactionContex
 		}
 	}
 	
-	return transactions, nil
+	page := &TransactionPage{Records: transactions}
+	if metadata != nil {
+		page.FetchedRecordsCount = int(metadata.FetchedRecordsCount)
+		// A short page is the last one
+		if page.FetchedRecordsCount == pageSize {
+			page.Bookmark = metadata.Bookmark
+		}
+	}
+	return page, nil
 }
 
 // Helper functions
@@ -1038,7 +1356,7 @@ func (c *ConsensusContract) getTransaction(ctx contractapi.TransactionContextInt
 		return nil, fmt.Errorf("failed to read transaction: %v", err)
 	}
 	if txJSON == nil {
-		return nil, fmt.Errorf("transaction %s does not exist", transactionID)
+		return nil, newError(ErrNotFound, "transaction %s does not exist", transactionID)
 	}
 	
 	var tx Transaction
@@ -1102,6 +1420,7 @@ func (c *ConsensusContract) validateConsensus(ctx contractapi.TransactionContext
 	event := ConsensusEvent{
 		TransactionID: tx.ID,
 		EventType:     "CONSENSUS_ACHIEVED",
+		State:         string(tx.State),
 		Timestamp:     time.Now().Format(time.RFC3339),
 		Payload: map[string]interface{}{
 			"sender":   tx.Sender,
@@ -1115,31 +1434,54 @@ func (c *ConsensusContract) validateConsensus(ctx contractapi.TransactionContext
 // ValidateTransaction checks if a transaction has timed out and applies penalties
 func (c *ConsensusContract) ValidateTransaction(ctx contractapi.TransactionContextInterface,
 	transactionID string) error {
+
+	_, err := c.validateTransaction(ctx, transactionID)
+	return err
+}
+
+// ValidateTransactionState validates a transaction like ValidateTransaction and returns
+// it in the state the validation leaves it, e.g. TIMEOUT. A chaincode that invokes the
+// validation cannot read that state back with GetTransaction in the same transaction.
+func (c *ConsensusContract) ValidateTransactionState(ctx contractapi.TransactionContextInterface,
+	transactionID string) (*Transaction, error) {
+
+	return c.validateTransaction(ctx, transactionID)
+}
+
+// validateTransaction times out a transaction whose deadline has passed, penalizing the
+// parties that did not confirm, and returns the transaction
+func (c *ConsensusContract) validateTransaction(ctx contractapi.TransactionContextInterface,
+	transactionID string) (*Transaction, error) {
 	
+	if err := validateID("transactionID", transactionID); err != nil {
+		return nil, err
+	}
+
 	tx, err := c.getTransaction(ctx, transactionID)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	
-	// Check if already validated or disputed
-	if tx.State == StateValidated || tx.State == StateDisputed {
-		return nil // Already processed
+	// Check if already validated, disputed, cancelled or timed out, so penalties apply once
+	if tx.State == StateValidated || tx.State == StateDisputed || tx.State == StateCancelled || tx.State == StateTimeout {
+		return tx, nil // Already processed
 	}
 	
 	// Check if timeout has passed
 	currentTime := time.Now().Unix()
 	
 	// Parse timeout from transaction timestamp
-	// Add default timeout of 48 hours if not specified
+	// Urgent transactions time out sooner, and the policy may change the timeout
 	createdTime, err := time.Parse(time.RFC3339, tx.Timestamp)
 	if err != nil {
-		return fmt.Errorf("invalid transaction timestamp: %v", err)
+		return nil, newError(ErrInvalidArgument, "invalid transaction timestamp: %v", err)
 	}
-	timeoutTime := createdTime.Add(48 * time.Hour).Format(time.RFC3339)
+	timeoutAfter := transactionTimeoutOf(tx)
+	timeoutTime := createdTime.Add(timeoutAfter).Format(time.RFC3339)
 	
 	timeout, err := time.Parse(time.RFC3339, timeoutTime)
 	if err != nil {
-		return fmt.Errorf("invalid timeout format: %v", err)
+		return nil, newError(ErrInvalidArgument, "invalid timeout format: %v", err)
 	}
 	
 	if currentTime > timeout.Unix() {
@@ -1150,44 +1492,51 @@ func (c *ConsensusContract) ValidateTransaction(ctx contractapi.TransactionConte
 		// Apply penalties to parties who didn't confirm
 		if originalState == StateInitiated {
 			// Neither party confirmed - penalize both
-			senderScore, _ := c.getTrustScore(ctx, tx.Sender)
-			senderScore.Score = math.Max(senderScore.Score - 0.01, 0.0)
-			senderScore.LastUpdated = time.Now().Format(time.RFC3339)
-			c.saveTrustScore(ctx, senderScore)
-			
-			receiverScore, _ := c.getTrustScore(ctx, tx.Receiver)
-			receiverScore.Score = math.Max(receiverScore.Score - 0.01, 0.0)
-			receiverScore.LastUpdated = time.Now().Format(time.RFC3339)
-			c.saveTrustScore(ctx, receiverScore)
-			
-		} else if originalState == StateSent {
+			c.applyTimeoutPenalty(ctx, tx.Sender)
+			c.applyTimeoutPenalty(ctx, tx.Receiver)
+		} else if originalState == StateSent && tx.Carrier != "" {
+			// The carrier never confirmed delivery - penalize carrier
+			c.applyTimeoutPenalty(ctx, tx.Carrier)
+		} else if originalState == StateSent || originalState == StateDelivered {
 			// Only receiver didn't confirm - penalize receiver
-			receiverScore, _ := c.getTrustScore(ctx, tx.Receiver)
-			receiverScore.Score = math.Max(receiverScore.Score - 0.01, 0.0)
-			receiverScore.LastUpdated = time.Now().Format(time.RFC3339)
-			c.saveTrustScore(ctx, receiverScore)
+			c.applyTimeoutPenalty(ctx, tx.Receiver)
 		}
+		logFor(ctx).Info("transaction timed out", "transactionId", transactionID, "previousState", originalState)
 		
 		// Update transaction
 		err = c.putTransaction(ctx, tx)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		
 		// Emit timeout event
 		event := ConsensusEvent{
 			TransactionID: transactionID,
 			EventType:     "TRANSACTION_TIMEOUT",
+			State:         string(tx.State),
 			Timestamp:     time.Now().Format(time.RFC3339),
-			Payload: map[string]interface{}{
-				"state": string(tx.State),
-			},
+			Payload:       map[string]interface{}{},
 		}
 		
-		return c.emitEvent(ctx, event)
+		return tx, c.emitEvent(ctx, event)
 	}
 	
-	return nil
+	return tx, nil
+}
+
+// applyTimeoutPenalty lowers a party's trust score for a missed confirmation.
+// Failures are logged rather than returned so the timeout itself is still recorded.
+func (c *ConsensusContract) applyTimeoutPenalty(ctx contractapi.TransactionContextInterface, partyID string) {
+	score, err := c.getTrustScore(ctx, partyID)
+	if err != nil {
+		logFor(ctx).Warn("failed to load trust score for timeout penalty", "party", partyID, "error", err)
+		return
+	}
+	score.Score = math.Max(score.Score - 0.01, 0.0)
+	score.LastUpdated = time.Now().Format(time.RFC3339)
+	if err := c.saveTrustScore(ctx, score); err != nil {
+		logFor(ctx).Warn("failed to save trust score after timeout penalty", "party", partyID, "error", err)
+	}
 }
 
 // saveTrustScore helper function to save trust scores
@@ -1195,6 +1544,7 @@ func (c *ConsensusContract) saveTrustScore(ctx contractapi.TransactionContextInt
 	score *TrustScore) error {
 	
 	scoreKey := fmt.Sprintf("TRUST_%s", score.PartyID)
+	score.Tier = trustTierOf(score)
 	scoreJSON, err := json.Marshal(score)
 	if err != nil {
 		return err
@@ -1221,11 +1571,13 @@ func (c *ConsensusContract) autoConfirmTransaction(ctx contractapi.TransactionCo
 	if err != nil {
 		return err
 	}
+	logFor(ctx).Info("transaction auto-confirmed", "transactionId", tx.ID, "reason", reason)
 	
 	// Emit auto-confirmation event
 	event := ConsensusEvent{
 		TransactionID: tx.ID,
 		EventType:     "AUTO_CONFIRMATION",
+		State:         string(tx.State),
 		Timestamp:     time.Now().Format(time.RFC3339),
 		Payload: map[string]interface{}{
 			"reason": reason,
@@ -1254,6 +1606,7 @@ func (c *ConsensusContract) getTrustScore(ctx contractapi.TransactionContextInte
 			DisputedTx:       0,
 			LastUpdated:      time.Now().Format(time.RFC3339),
 		}
+		score.Tier = trustTierOf(score)
 		return score, nil
 	}
 	
@@ -1263,6 +1616,18 @@ func (c *ConsensusContract) getTrustScore(ctx contractapi.TransactionContextInte
 		return nil, err
 	}
 	
+	// Scores fade while a party is idle; callers that save the score persist the decay
+	config, err := getTrustDecayConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	now, err := txTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+	applyTrustDecay(&score, config, now)
+	score.Tier = trustTierOf(&score)
+	
 	return &score, nil
 }
 
@@ -1304,6 +1669,7 @@ func (c *ConsensusContract) updateTrustScores(ctx contractapi.TransactionContext
 	}
 	
 	senderScore.LastUpdated = time.Now().Format(time.RFC3339)
+	senderScore.Tier = trustTierOf(senderScore)
 	
 	senderJSON, err := json.Marshal(senderScore)
 	if err != nil {
@@ -1350,6 +1716,7 @@ func (c *ConsensusContract) updateTrustScores(ctx contractapi.TransactionContext
 	}
 	
 	receiverScore.LastUpdated = time.Now().Format(time.RFC3339)
+	receiverScore.Tier = trustTierOf(receiverScore)
 	
 	receiverJSON, err := json.Marshal(receiverScore)
 	if err != nil {
@@ -1362,6 +1729,8 @@ func (c *ConsensusContract) updateTrustScores(ctx contractapi.TransactionContext
 func (c *ConsensusContract) emitEvent(ctx contractapi.TransactionContextInterface,
 	event ConsensusEvent) error {
 	
+	event.SchemaVersion = ConsensusEventSchemaVersion
+	event.TxID = ctx.GetStub().GetTxID()
 	eventJSON, err := json.Marshal(event)
 	if err != nil {
 		return err
@@ -1370,24 +1739,33 @@ func (c *ConsensusContract) emitEvent(ctx contractapi.TransactionContextInterfac
 	return ctx.GetStub().SetEvent("ConsensusEvent", eventJSON)
 }
 
+// txTime returns the timestamp of the current transaction, which is the same on every
+// endorser, for timestamps written to the ledger
+func txTime(ctx contractapi.TransactionContextInterface) (time.Time, error) {
+	timestamp, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to read transaction timestamp: %v", err)
+	}
+	return timestamp.AsTime().UTC(), nil
+}
+
 // UpdateTrustFromEvent handles trust score updates from supply chain events
 func (c *ConsensusContract) UpdateTrustFromEvent(ctx contractapi.TransactionContextInterface,
 	eventDataJSON string) error {
 	
 	var eventData map[string]interface{}
-	err := json.Unmarshal([]byte(eventDataJSON), &eventData)
-	if err != nil {
-		return fmt.Errorf("failed to unmarshal event data: %v", err)
+	if err := validateJSON("event data", eventDataJSON, &eventData); err != nil {
+		return err
 	}
 	
 	partyID, ok := eventData["partyID"].(string)
 	if !ok {
-		return fmt.Errorf("partyID not found in event data")
+		return newError(ErrInvalidArgument, "partyID not found in event data")
 	}
 	
 	event, ok := eventData["event"].(string)
 	if !ok {
-		return fmt.Errorf("event type not found in event data")
+		return newError(ErrInvalidArgument, "event type not found in event data")
 	}
 	
 	// Get current trust score
@@ -1410,20 +1788,22 @@ func (c *ConsensusContract) UpdateTrustFromEvent(ctx contractapi.TransactionCont
 		// Larger penalty when found at fault in dispute
 		score.Score = math.Max(score.Score - 0.05, 0.0)
 		
+	case "TRANSIT_DAMAGE":
+		// Carrier liable for goods damaged in transit and repaired
+		score.Score = math.Max(score.Score - 0.02, 0.0)
+		
+	case "TRANSIT_LOSS":
+		// Carrier liable for goods damaged beyond repair
+		score.Score = math.Max(score.Score - 0.04, 0.0)
+		
 	default:
-		return fmt.Errorf("unknown event type: %s", event)
+		return newError(ErrInvalidArgument, "unknown event type: %s", event)
 	}
 	
 	score.LastUpdated = time.Now().Format(time.RFC3339)
 	
 	// Save updated score
-	scoreKey := fmt.Sprintf("trust_%s", partyID)
-	scoreJSON, err := json.Marshal(score)
-	if err != nil {
-		return err
-	}
-	
-	err = ctx.GetStub().PutState(scoreKey, scoreJSON)
+	err = c.saveTrustScore(ctx, score)
 	if err != nil {
 		return err
 	}
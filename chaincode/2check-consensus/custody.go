@@ -0,0 +1,115 @@
+package main
+
+import (
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// ConfirmHandoff confirms sent for a transaction whose goods the sender handed to a
+// carrier. It makes the transaction three-party: the carrier confirms delivery with
+// ConfirmDelivered before the receiver can confirm receipt. Unlike ConfirmSent it never
+// auto-confirms, as the receiver does not hold the goods yet.
+func (c *ConsensusContract) ConfirmHandoff(ctx contractapi.TransactionContextInterface,
+	transactionID string, sender string, carrier string) error {
+
+	if err := validateAll(
+		validateID("transactionID", transactionID),
+		validateID("sender", sender),
+		validateID("carrier", carrier),
+	); err != nil {
+		return err
+	}
+
+	tx, err := c.getTransaction(ctx, transactionID)
+	if err != nil {
+		return err
+	}
+	if tx.Sender != sender {
+		return newError(ErrPermissionDenied, "unauthorized: only sender can hand off to a carrier")
+	}
+	if carrier == tx.Sender || carrier == tx.Receiver {
+		return newError(ErrInvalidArgument, "carrier must not be a party of the transaction")
+	}
+	if tx.State != StateInitiated {
+		return newError(ErrInvalidState, "invalid state transition: cannot hand off from state %s", tx.State)
+	}
+
+	timestamp, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	now := timestamp.Format(time.RFC3339)
+	tx.State = StateSent
+	tx.SentTimestamp = now
+	tx.Carrier = carrier
+
+	err = c.putTransaction(ctx, tx)
+	if err != nil {
+		return err
+	}
+
+	event := ConsensusEvent{
+		TransactionID: transactionID,
+		EventType:     "CUSTODY_HANDED_OFF",
+		State:         string(tx.State),
+		Timestamp:     now,
+		Payload: map[string]interface{}{
+			"sender":  sender,
+			"carrier": carrier,
+		},
+	}
+	return c.emitEvent(ctx, event)
+}
+
+// ConfirmDelivered lets the carrier of a three-party transaction confirm it handed the
+// goods to the receiver
+func (c *ConsensusContract) ConfirmDelivered(ctx contractapi.TransactionContextInterface,
+	transactionID string, carrier string) error {
+
+	if err := validateAll(
+		validateID("transactionID", transactionID),
+		validateID("carrier", carrier),
+	); err != nil {
+		return err
+	}
+
+	tx, err := c.getTransaction(ctx, transactionID)
+	if err != nil {
+		return err
+	}
+	if tx.Carrier == "" {
+		return newError(ErrInvalidState, "transaction %s was not handed off to a carrier", transactionID)
+	}
+	if tx.Carrier != carrier {
+		return newError(ErrPermissionDenied, "unauthorized: only the carrier can confirm delivery")
+	}
+	if tx.State != StateSent {
+		return newError(ErrInvalidState, "invalid state transition: cannot confirm delivery from state %s", tx.State)
+	}
+
+	timestamp, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	now := timestamp.Format(time.RFC3339)
+	tx.State = StateDelivered
+	tx.DeliveredTimestamp = now
+
+	err = c.putTransaction(ctx, tx)
+	if err != nil {
+		return err
+	}
+
+	event := ConsensusEvent{
+		TransactionID: transactionID,
+		EventType:     "CARRIER_DELIVERED",
+		State:         string(tx.State),
+		Timestamp:     now,
+		Payload: map[string]interface{}{
+			"carrier":  carrier,
+			"receiver": tx.Receiver,
+		},
+	}
+	return c.emitEvent(ctx, event)
+}
@@ -0,0 +1,30 @@
+package main
+
+import "fmt"
+
+// ErrorCode classifies a consensus failure so clients don't have to match on message text.
+// The codes match those used by the luxury-supply-chain chaincode.
+type ErrorCode string
+
+const (
+	ErrNotFound         ErrorCode = "NOT_FOUND"
+	ErrPermissionDenied ErrorCode = "PERMISSION_DENIED"
+	ErrInvalidState     ErrorCode = "INVALID_STATE"
+	ErrAlreadyExists    ErrorCode = "ALREADY_EXISTS"
+	ErrInvalidArgument  ErrorCode = "INVALID_ARGUMENT"
+)
+
+// ConsensusError is a failure with a machine-readable code, serialized as "[CODE] message"
+type ConsensusError struct {
+	Code    ErrorCode
+	Message string
+}
+
+func (e *ConsensusError) Error() string {
+	return fmt.Sprintf("[%s] %s", e.Code, e.Message)
+}
+
+// newError creates a coded error
+func newError(code ErrorCode, format string, args ...interface{}) error {
+	return &ConsensusError{Code: code, Message: fmt.Sprintf(format, args...)}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// RouteLeadTimeEstimate summarizes how long validated transactions on a route took
+// from the sender's confirmation to the receiver's, in hours
+type RouteLeadTimeEstimate struct {
+	Sender      string  `json:"sender"`
+	Receiver    string  `json:"receiver"`
+	ItemType    string  `json:"itemType"`
+	SampleSize  int     `json:"sampleSize"`
+	MinHours    float64 `json:"minHours"`
+	MedianHours float64 `json:"medianHours"`
+	P75Hours    float64 `json:"p75Hours"`
+	P90Hours    float64 `json:"p90Hours"`
+	P95Hours    float64 `json:"p95Hours"`
+	MaxHours    float64 `json:"maxHours"`
+	OldestSent  string  `json:"oldestSent"` // Range of the samples' sent timestamps
+	LatestSent  string  `json:"latestSent"`
+}
+
+// percentileHours returns the nearest-rank percentile of sorted durations, in hours
+// rounded to 2 decimals
+func percentileHours(sorted []time.Duration, percentile float64) float64 {
+	rank := int(math.Ceil(percentile / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return math.Round(sorted[rank-1].Hours()*100) / 100
+}
+
+// GetRouteLeadTimeEstimate computes the transit times of the validated transactions
+// from sender to receiver for an item type, so planners can quote a data-driven ETA.
+// Auto-confirmed transactions have no transit time and are left out.
+func (c *ConsensusContract) GetRouteLeadTimeEstimate(ctx contractapi.TransactionContextInterface,
+	sender string, receiver string, itemType string) (*RouteLeadTimeEstimate, error) {
+
+	if err := validateAll(
+		validateID("sender", sender),
+		validateID("receiver", receiver),
+		validateRequired("itemType", itemType, maxNameLength),
+	); err != nil {
+		return nil, err
+	}
+
+	queryString := fmt.Sprintf(`{"selector":{"sender":"%s","receiver":"%s"},`+
+		`"use_index":["_design/indexTransactionRouteDoc","indexTransactionRoute"]}`, sender, receiver)
+	transactions, err := c.QueryTransactions(ctx, queryString)
+	if err != nil {
+		return nil, err
+	}
+
+	var durations []time.Duration
+	var oldest, latest time.Time
+	for _, tx := range transactions {
+		if tx.State != StateValidated || tx.ItemType != itemType {
+			continue
+		}
+		sent, err := time.Parse(time.RFC3339, tx.SentTimestamp)
+		if err != nil {
+			continue
+		}
+		received, err := time.Parse(time.RFC3339, tx.ReceivedTimestamp)
+		if err != nil || !received.After(sent) {
+			continue
+		}
+
+		durations = append(durations, received.Sub(sent))
+		if oldest.IsZero() || sent.Before(oldest) {
+			oldest = sent
+		}
+		if sent.After(latest) {
+			latest = sent
+		}
+	}
+	if len(durations) == 0 {
+		return nil, newError(ErrNotFound, "no validated %s transactions from %s to %s", itemType, sender, receiver)
+	}
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+
+	return &RouteLeadTimeEstimate{
+		Sender:      sender,
+		Receiver:    receiver,
+		ItemType:    itemType,
+		SampleSize:  len(durations),
+		MinHours:    percentileHours(durations, 0),
+		MedianHours: percentileHours(durations, 50),
+		P75Hours:    percentileHours(durations, 75),
+		P90Hours:    percentileHours(durations, 90),
+		P95Hours:    percentileHours(durations, 95),
+		MaxHours:    percentileHours(durations, 100),
+		OldestSent:  oldest.UTC().Format(time.RFC3339),
+		LatestSent:  latest.UTC().Format(time.RFC3339),
+	}, nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Metadata keys through which the submitting chaincode overrides the consensus policy of
+// a transaction, e.g. from a per-route policy. Missing or invalid values keep the defaults.
+const (
+	metadataTimeoutHours         = "timeoutHours"         // Hours before ValidateTransaction times it out
+	metadataAutoConfirmThreshold = "autoConfirmThreshold" // Sender trust score above which ConfirmSent auto-confirms
+	metadataEscalationHours      = "escalationHours"      // Hours to complete the action of a dispute resolution
+)
+
+// policyMetadataKeys are the metadata keys only the admin organization or the submitting
+// chaincode may set
+var policyMetadataKeys = []string{metadataTimeoutHours, metadataAutoConfirmThreshold, metadataEscalationHours}
+
+// Policy defaults
+const (
+	defaultAutoConfirmThreshold = 0.95
+	disputeActionDeadline       = 72 * time.Hour
+)
+
+// dropUnauthorizedPolicy removes the policy overrides from the metadata of a transaction
+// submitted by anyone other than the admin organization or the submitting chaincode, so a
+// party cannot lengthen its own timeouts or lower the auto-confirm threshold
+func dropUnauthorizedPolicy(ctx contractapi.TransactionContextInterface, metadata map[string]string) error {
+	overridden := false
+	for _, key := range policyMetadataKeys {
+		if _, ok := metadata[key]; ok {
+			overridden = true
+		}
+	}
+	if !overridden {
+		return nil
+	}
+
+	err := requireAdminOrSubmitter(ctx)
+	var consensusErr *ConsensusError
+	if errors.As(err, &consensusErr) && consensusErr.Code == ErrPermissionDenied {
+		for _, key := range policyMetadataKeys {
+			delete(metadata, key)
+		}
+		return nil
+	}
+	return err
+}
+
+// metadataHours reads a positive number of hours from a transaction's metadata
+func metadataHours(tx *Transaction, key string) (time.Duration, bool) {
+	hours, err := strconv.Atoi(tx.Metadata[key])
+	if err != nil || hours <= 0 {
+		return 0, false
+	}
+	return time.Duration(hours) * time.Hour, true
+}
+
+// transactionTimeoutOf returns how long after its creation a transaction times out. An
+// urgent transaction keeps the urgent timeout unless the policy's is shorter. PLATINUM
+// senders get twice as long.
+func transactionTimeoutOf(tx *Transaction) time.Duration {
+	timeout := transactionTimeout
+	if tx.Priority == PriorityUrgent {
+		timeout = urgentTransactionTimeout
+	}
+	if policyTimeout, ok := metadataHours(tx, metadataTimeoutHours); ok {
+		if tx.Priority != PriorityUrgent || policyTimeout < timeout {
+			timeout = policyTimeout
+		}
+	}
+	if tx.SenderTier == TierPlatinum {
+		timeout *= platinumTimeoutFactor
+	}
+	return timeout
+}
+
+// autoConfirmThresholdOf returns the sender trust score above which a transaction is
+// auto-confirmed when sent
+func autoConfirmThresholdOf(tx *Transaction) float64 {
+	threshold, err := strconv.ParseFloat(tx.Metadata[metadataAutoConfirmThreshold], 64)
+	if err != nil || threshold <= 0 {
+		return defaultAutoConfirmThreshold
+	}
+	return threshold
+}
+
+// actionDeadlineOf returns the deadline of the action required by a resolution of a
+// transaction's dispute made at resolvedAt
+func actionDeadlineOf(tx *Transaction, resolvedAt time.Time) string {
+	deadline, ok := metadataHours(tx, metadataEscalationHours)
+	if !ok {
+		deadline = disputeActionDeadline
+	}
+	return resolvedAt.Add(deadline).Format(time.RFC3339)
+}
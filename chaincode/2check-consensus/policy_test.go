@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+)
+
+func TestPolicyMetadataRequiresAdminOrSubmitter(t *testing.T) {
+	tests := []struct {
+		name      string
+		mspID     string
+		chaincode string
+		kept      bool
+	}{
+		{"admin organization directly", adminMSPID, "2check-consensus", true},
+		{"sender directly", "LuxuryRetailMSP", "2check-consensus", false},
+		{"sender through the submitting chaincode", "LuxuryRetailMSP", submittingChaincodeName, true},
+	}
+	contract := &ConsensusContract{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := newProposalContext(t, shimtest.NewMockStub("2check-consensus", nil), tt.mspID, tt.chaincode)
+			metadata := `{"timeoutHours":"1000","autoConfirmThreshold":"0.1","escalationHours":"1000","transferId":"T1"}`
+			err := contract.SubmitTransaction(ctx, "TX1", "LuxuryRetailMSP", "LuxeBagsMSP", "PRODUCT", "P1", 1, metadata)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var tx Transaction
+			if err := json.Unmarshal(ctx.GetStub().(*proposalStub).State["TX1"], &tx); err != nil {
+				t.Fatal(err)
+			}
+			if tx.Metadata["transferId"] != "T1" {
+				t.Fatalf("transferId = %q, want T1", tx.Metadata["transferId"])
+			}
+			for _, key := range policyMetadataKeys {
+				if _, ok := tx.Metadata[key]; ok != tt.kept {
+					t.Fatalf("%s kept = %v, want %v", key, ok, tt.kept)
+				}
+			}
+		})
+	}
+}
+
+func TestActionDeadlineCountsFromResolution(t *testing.T) {
+	resolvedAt := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		metadata map[string]string
+		want     string
+	}{
+		{"default deadline", map[string]string{}, "2024-03-04T12:00:00Z"},
+		{"policy deadline", map[string]string{metadataEscalationHours: "24"}, "2024-03-02T12:00:00Z"},
+		{"invalid policy deadline", map[string]string{metadataEscalationHours: "-1"}, "2024-03-04T12:00:00Z"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := actionDeadlineOf(&Transaction{Metadata: tt.metadata}, resolvedAt); got != tt.want {
+				t.Fatalf("actionDeadlineOf = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// EvidenceTypeSensorData marks evidence citing sensor telemetry anchored on the supply chain
+const EvidenceTypeSensorData = "SENSOR_DATA"
+
+// maxSensorEvidence caps the anchors cited in one call
+const maxSensorEvidence = 50
+
+// SubmitSensorEvidence lets the receiver of a transaction disputed as DEFECTIVE cite
+// sensor telemetry anchored on the supply chain, e.g. a temperature excursion in
+// transit. anchors is a comma-separated list of "anchorHash=reference" pairs, where the
+// reference is the anchor's ledger key in the calling chaincode. The supply chain's
+// CiteSensorEvidence checks the anchors before calling it.
+func (c *ConsensusContract) SubmitSensorEvidence(ctx contractapi.TransactionContextInterface,
+	transactionID string, receiver string, anchors string) error {
+
+	if err := validateAll(
+		validateID("transactionID", transactionID),
+		validateID("receiver", receiver),
+		validateRequired("anchors", anchors, maxJSONLength),
+	); err != nil {
+		return err
+	}
+
+	var evidence []Evidence
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	timestamp := now.Format(time.RFC3339)
+	for _, anchor := range strings.Split(anchors, ",") {
+		hash, reference, found := strings.Cut(strings.TrimSpace(anchor), "=")
+		if !found {
+			return newError(ErrInvalidArgument, "anchor %q is not of the form hash=reference", anchor)
+		}
+		if err := validateAll(
+			validateID("anchor hash", hash),
+			validateRequired("anchor reference", reference, maxNameLength),
+		); err != nil {
+			return err
+		}
+		evidence = append(evidence, Evidence{
+			Type:        EvidenceTypeSensorData,
+			SubmittedBy: receiver,
+			Timestamp:   timestamp,
+			Hash:        hash,
+			Reference:   reference,
+		})
+	}
+	if len(evidence) > maxSensorEvidence {
+		return newError(ErrInvalidArgument, "at most %d anchors can be cited at once", maxSensorEvidence)
+	}
+
+	tx, err := c.getTransaction(ctx, transactionID)
+	if err != nil {
+		return err
+	}
+	if tx.Receiver != receiver {
+		return newError(ErrPermissionDenied, "unauthorized: only receiver can cite sensor data")
+	}
+	if tx.State != StateDisputed || tx.DisputeReason != string(DisputeDefective) {
+		return newError(ErrInvalidState, "sensor data can only be cited in a %s dispute", DisputeDefective)
+	}
+	for _, existing := range tx.Evidence {
+		for _, cited := range evidence {
+			if existing.Type == EvidenceTypeSensorData && existing.Hash == cited.Hash {
+				return newError(ErrAlreadyExists, "sensor anchor %s is already cited", cited.Hash)
+			}
+		}
+	}
+
+	// Replace the placeholder evidence, as SubmitEvidence does
+	if len(tx.Evidence) == 1 && tx.Evidence[0].Type == "N/A" {
+		tx.Evidence = nil
+	}
+	tx.Evidence = append(tx.Evidence, evidence...)
+
+	err = c.putTransaction(ctx, tx)
+	if err != nil {
+		return err
+	}
+
+	return c.emitEvent(ctx, ConsensusEvent{
+		TransactionID: transactionID,
+		EventType:     "EVIDENCE_SUBMITTED",
+		State:         string(tx.State),
+		Timestamp:     timestamp,
+		Payload: map[string]interface{}{
+			"type":        EvidenceTypeSensorData,
+			"submittedBy": receiver,
+			"anchors":     len(evidence),
+		},
+	})
+}
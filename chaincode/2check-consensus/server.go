@@ -1,8 +1,10 @@
 package main
 
 import (
+	"fmt"
 	"log"
 	"os"
+	"strconv"
 
 	"github.com/hyperledger/fabric-chaincode-go/shim"
 	"github.com/hyperledger/fabric-contract-api-go/contractapi"
@@ -31,18 +33,92 @@ func RunAsService() {
 		log.Fatalf("Error creating consensus chaincode: %v", err)
 	}
 
+	tlsProps, err := tlsPropertiesFromEnv()
+	if err != nil {
+		log.Fatalf("Error loading consensus chaincode TLS configuration: %v", err)
+	}
+
+	// Optional health listener, e.g. CHAINCODE_HEALTH_ADDRESS=0.0.0.0:9443
+	monitor := newHealthMonitor()
+	if address := os.Getenv("CHAINCODE_HEALTH_ADDRESS"); address != "" {
+		monitor.serve(address)
+	}
+
 	server := &shim.ChaincodeServer{
 		CCID:    os.Getenv("CHAINCODE_ID"),
 		Address: os.Getenv("CHAINCODE_SERVER_ADDRESS"),
-		CC:      cc,
-		TLSProps: shim.TLSProperties{
-			Disabled: true, // No TLS for simplicity in production
-		},
+		CC:      monitor.instrument(cc),
+		TLSProps: tlsProps,
 	}
 
 	// Start the chaincode server
+	monitor.ready.Store(true)
 	err = server.Start()
 	if err != nil {
 		log.Fatalf("Error starting consensus chaincode server: %v", err)
 	}
-}
\ No newline at end of file
+}
+
+// tlsPropertiesFromEnv builds the chaincode server TLS settings.
+// TLS is enabled when CHAINCODE_TLS_CERT and CHAINCODE_TLS_KEY point to PEM files;
+// CHAINCODE_TLS_REQUIRE_CLIENT_AUTH=true additionally requires peers to present a
+// certificate signed by the CA in CHAINCODE_TLS_CLIENT_CA_CERT.
+// CHAINCODE_TLS_DISABLED=true forces plaintext.
+func tlsPropertiesFromEnv() (shim.TLSProperties, error) {
+	certPath := os.Getenv("CHAINCODE_TLS_CERT")
+	keyPath := os.Getenv("CHAINCODE_TLS_KEY")
+
+	disabled, err := envBool("CHAINCODE_TLS_DISABLED")
+	if err != nil {
+		return shim.TLSProperties{}, err
+	}
+	if disabled || (certPath == "" && keyPath == "") {
+		log.Printf("Chaincode server TLS is disabled")
+		return shim.TLSProperties{Disabled: true}, nil
+	}
+	if certPath == "" || keyPath == "" {
+		return shim.TLSProperties{}, fmt.Errorf("CHAINCODE_TLS_CERT and CHAINCODE_TLS_KEY must be set together")
+	}
+
+	cert, err := os.ReadFile(certPath)
+	if err != nil {
+		return shim.TLSProperties{}, fmt.Errorf("failed to read TLS certificate: %v", err)
+	}
+	key, err := os.ReadFile(keyPath)
+	if err != nil {
+		return shim.TLSProperties{}, fmt.Errorf("failed to read TLS key: %v", err)
+	}
+	props := shim.TLSProperties{Cert: cert, Key: key}
+
+	requireClientAuth, err := envBool("CHAINCODE_TLS_REQUIRE_CLIENT_AUTH")
+	if err != nil {
+		return shim.TLSProperties{}, err
+	}
+	if requireClientAuth {
+		caPath := os.Getenv("CHAINCODE_TLS_CLIENT_CA_CERT")
+		if caPath == "" {
+			return shim.TLSProperties{}, fmt.Errorf("CHAINCODE_TLS_CLIENT_CA_CERT is required when CHAINCODE_TLS_REQUIRE_CLIENT_AUTH is set")
+		}
+		// The shim requires client certificates whenever ClientCACerts is set
+		props.ClientCACerts, err = os.ReadFile(caPath)
+		if err != nil {
+			return shim.TLSProperties{}, fmt.Errorf("failed to read TLS client CA certificate: %v", err)
+		}
+	}
+
+	log.Printf("Chaincode server TLS is enabled (client auth required: %t)", requireClientAuth)
+	return props, nil
+}
+
+// envBool parses an optional boolean environment variable
+func envBool(name string) (bool, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return false, nil
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid value %q for %s: %v", value, name, err)
+	}
+	return parsed, nil
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Dispute statuses of a negotiated dispute
+const (
+	disputeStatusNegotiating = "NEGOTIATING"
+	disputeStatusSettled     = "RESOLVED_SETTLED"
+)
+
+// Settlement proposal statuses
+const (
+	ProposalOpen      = "OPEN"
+	ProposalCountered = "COUNTERED" // The other party proposed different terms
+	ProposalRevised   = "REVISED"   // The proposer replaced it
+	ProposalAccepted  = "ACCEPTED"
+)
+
+// A negotiation ends in an agreement, escalation or a ResolveDispute within this many rounds
+const maxSettlementRounds = 20
+
+// SettlementProposal is one round of the negotiation of a dispute's outcome
+type SettlementProposal struct {
+	Round          int    `json:"round"`
+	ProposedBy     string `json:"proposedBy"`
+	Decision       string `json:"decision"` // IN_FAVOR_SENDER, IN_FAVOR_RECEIVER or PARTIAL, as in ResolveDispute
+	ActionQuantity int    `json:"actionQuantity"`
+	Notes          string `json:"notes,omitempty" metadata:",optional"`
+	ProposedAt     string `json:"proposedAt"`
+	Status         string `json:"status"`
+}
+
+// openProposal returns the proposal awaiting an answer, if any
+func openProposal(tx *Transaction) *SettlementProposal {
+	if len(tx.Negotiation) == 0 {
+		return nil
+	}
+	last := &tx.Negotiation[len(tx.Negotiation)-1]
+	if last.Status != ProposalOpen {
+		return nil
+	}
+	return last
+}
+
+// ProposeSettlement offers terms to settle a dispute, e.g. the receiver proposes
+// IN_FAVOR_RECEIVER with 30 units to resend and the sender counters with PARTIAL and 20.
+// Either party can propose while the dispute awaits a response; a proposal replaces the
+// open one, which is kept in the transaction's negotiation history.
+func (c *ConsensusContract) ProposeSettlement(ctx contractapi.TransactionContextInterface,
+	transactionID string, proposer string, decision string, actionQuantity int, notes string) error {
+
+	if err := validateAll(
+		validateID("transactionID", transactionID),
+		validateID("proposer", proposer),
+		validateEnum("decision", decision, "IN_FAVOR_SENDER", "IN_FAVOR_RECEIVER", "PARTIAL"),
+		validateNonNegative("actionQuantity", actionQuantity),
+		validateText("notes", notes, maxTextLength),
+	); err != nil {
+		return err
+	}
+
+	tx, err := c.getTransaction(ctx, transactionID)
+	if err != nil {
+		return err
+	}
+	if proposer != tx.Sender && proposer != tx.Receiver {
+		return newError(ErrPermissionDenied, "unauthorized: only transaction parties can propose a settlement")
+	}
+	if tx.State != StateDisputed {
+		return newError(ErrInvalidState, "transaction is not in disputed state")
+	}
+	status := tx.Metadata["disputeStatus"]
+	if status != "PENDING_RESPONSE" && status != disputeStatusNegotiating {
+		return newError(ErrInvalidState, "dispute cannot be negotiated in status %s", status)
+	}
+	if len(tx.Negotiation) >= maxSettlementRounds {
+		return newError(ErrInvalidState, "dispute reached %d negotiation rounds, escalate it or have it resolved", maxSettlementRounds)
+	}
+
+	if previous := openProposal(tx); previous != nil {
+		previous.Status = ProposalCountered
+		if previous.ProposedBy == proposer {
+			previous.Status = ProposalRevised
+		}
+	}
+	timestamp, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	now := timestamp.Format(time.RFC3339)
+	proposal := SettlementProposal{
+		Round:          len(tx.Negotiation) + 1,
+		ProposedBy:     proposer,
+		Decision:       decision,
+		ActionQuantity: actionQuantity,
+		Notes:          notes,
+		ProposedAt:     now,
+		Status:         ProposalOpen,
+	}
+	tx.Negotiation = append(tx.Negotiation, proposal)
+	tx.Metadata["disputeStatus"] = disputeStatusNegotiating
+
+	err = c.putTransaction(ctx, tx)
+	if err != nil {
+		return err
+	}
+
+	event := ConsensusEvent{
+		TransactionID: transactionID,
+		EventType:     "SETTLEMENT_PROPOSED",
+		State:         string(tx.State),
+		Timestamp:     now,
+		Payload: map[string]interface{}{
+			"round":          proposal.Round,
+			"proposedBy":     proposer,
+			"decision":       decision,
+			"actionQuantity": actionQuantity,
+		},
+	}
+	return c.emitEvent(ctx, event)
+}
+
+// AcceptSettlement accepts the open settlement proposal of the other party, which
+// resolves the dispute on its terms like AcceptDispute. round names the proposal
+// accepted, so terms countered in the meantime are not accepted by mistake.
+func (c *ConsensusContract) AcceptSettlement(ctx contractapi.TransactionContextInterface,
+	transactionID string, acceptor string, round int) error {
+
+	if err := validateAll(
+		validateID("transactionID", transactionID),
+		validateID("acceptor", acceptor),
+		validateQuantity("round", round),
+	); err != nil {
+		return err
+	}
+
+	tx, err := c.getTransaction(ctx, transactionID)
+	if err != nil {
+		return err
+	}
+	if acceptor != tx.Sender && acceptor != tx.Receiver {
+		return newError(ErrPermissionDenied, "unauthorized: only transaction parties can accept a settlement")
+	}
+	if tx.State != StateDisputed || tx.Metadata["disputeStatus"] != disputeStatusNegotiating {
+		return newError(ErrInvalidState, "dispute of transaction %s is not being negotiated", transactionID)
+	}
+	proposal := openProposal(tx)
+	if proposal == nil || proposal.Round != round {
+		return newError(ErrInvalidState, "round %d is not the open settlement proposal", round)
+	}
+	if proposal.ProposedBy == acceptor {
+		return newError(ErrInvalidState, "a party cannot accept its own settlement proposal")
+	}
+
+	winner, loser, requiredAction := disputeOutcome(tx, proposal.Decision)
+	timestamp, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	now := timestamp.Format(time.RFC3339)
+	notes := fmt.Sprintf("Settlement proposed by %s in round %d accepted by %s", proposal.ProposedBy, round, acceptor)
+	if proposal.Notes != "" {
+		notes += ": " + proposal.Notes
+	}
+	resolution := DisputeResolution{
+		DisputeID:       tx.Metadata["disputeID"],
+		TransactionID:   transactionID,
+		Decision:        proposal.Decision,
+		Winner:          winner,
+		Loser:           loser,
+		RequiredAction:  requiredAction,
+		ActionQuantity:  proposal.ActionQuantity,
+		ActionDeadline:  actionDeadlineOf(tx, timestamp),
+		Resolver:        acceptor,
+		ResolvedAt:      now,
+		Notes:           notes,
+		ActionCompleted: false,
+		FollowUpTxID:    "",
+	}
+	resolutionJSON, err := json.Marshal(resolution)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState("resolution_"+resolution.DisputeID, resolutionJSON)
+	if err != nil {
+		return err
+	}
+
+	proposal.Status = ProposalAccepted
+	tx.State = StateValidated
+	tx.Metadata["disputeStatus"] = disputeStatusSettled
+	tx.Metadata["resolutionID"] = resolution.DisputeID
+	tx.Metadata["requiredAction"] = requiredAction
+	tx.Metadata["actionQuantity"] = fmt.Sprintf("%d", proposal.ActionQuantity)
+	tx.Metadata["winner"] = winner
+
+	err = c.putTransaction(ctx, tx)
+	if err != nil {
+		return err
+	}
+
+	event := ConsensusEvent{
+		TransactionID: transactionID,
+		EventType:     "SETTLEMENT_ACCEPTED",
+		State:         string(tx.State),
+		Timestamp:     now,
+		Payload: map[string]interface{}{
+			"round":          round,
+			"acceptedBy":     acceptor,
+			"winner":         winner,
+			"requiredAction": requiredAction,
+			"actionQuantity": proposal.ActionQuantity,
+			"deadline":       resolution.ActionDeadline,
+		},
+	}
+	return c.emitEvent(ctx, event)
+}
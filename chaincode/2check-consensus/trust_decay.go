@@ -0,0 +1,225 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// trustDecayConfigKey holds the TrustDecayConfig. Transaction IDs cannot contain '_', so
+// it never collides with a transaction.
+const trustDecayConfigKey = "CONFIG_TRUST_DECAY"
+
+// Trust scores decay toward the neutral score a new party starts with
+const (
+	neutralTrustScore       = 0.5
+	defaultTrustDecayRate   = 0.05
+	trustDecayPeriodDays    = 30
+	trustDecayUnit          = 24 * time.Hour
+	trustDecayRoleMaxLength = 64
+)
+
+// TrustDecayConfig sets how fast trust scores fade while a party does not trade. A rate
+// is the share of the score above neutral lost every 30 days, e.g. 0.05 takes 0.98 down
+// to about 0.64 after two idle years.
+type TrustDecayConfig struct {
+	DefaultRate float64            `json:"defaultRate"`                              // For parties without a role or a role-specific rate
+	RoleRates   map[string]float64 `json:"roleRates,omitempty" metadata:",optional"` // Per role, as recorded by SetPartyRole
+	UpdatedAt   string             `json:"updatedAt,omitempty" metadata:",optional"`
+}
+
+// getTrustDecayConfig reads the stored TrustDecayConfig, falling back to the default rate
+func getTrustDecayConfig(ctx contractapi.TransactionContextInterface) (*TrustDecayConfig, error) {
+	configJSON, err := ctx.GetStub().GetState(trustDecayConfigKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read trust decay config: %v", err)
+	}
+	if configJSON == nil {
+		return &TrustDecayConfig{DefaultRate: defaultTrustDecayRate}, nil
+	}
+
+	var config TrustDecayConfig
+	err = json.Unmarshal(configJSON, &config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse trust decay config: %v", err)
+	}
+	return &config, nil
+}
+
+// rateFor returns the decay rate of a party with the given role
+func (c *TrustDecayConfig) rateFor(role string) float64 {
+	if rate, ok := c.RoleRates[role]; ok {
+		return rate
+	}
+	return c.DefaultRate
+}
+
+// decayStart returns when a trust score last changed or was decayed, whichever is later
+func decayStart(score *TrustScore) (time.Time, bool) {
+	start, err := time.Parse(time.RFC3339, score.LastUpdated)
+	if err != nil {
+		return time.Time{}, false
+	}
+	if decayed, err := time.Parse(time.RFC3339, score.DecayedAt); err == nil && decayed.After(start) {
+		start = decayed
+	}
+	return start, true
+}
+
+// applyTrustDecay lowers a score above neutral for the whole days since it last changed
+// or was decayed. Scores at or below neutral are left alone, so idling never makes up
+// for penalties. It reports whether the score changed and, if so, sets DecayedAt to the
+// end of the last whole day decayed.
+func applyTrustDecay(score *TrustScore, config *TrustDecayConfig, now time.Time) bool {
+	rate := config.rateFor(score.Role)
+	if rate <= 0 || score.Score <= neutralTrustScore {
+		return false
+	}
+	start, ok := decayStart(score)
+	if !ok {
+		return false
+	}
+	days := int(now.Sub(start) / trustDecayUnit)
+	if days <= 0 {
+		return false
+	}
+
+	factor := math.Pow(1-rate, float64(days)/trustDecayPeriodDays)
+	score.Score = neutralTrustScore + (score.Score-neutralTrustScore)*factor
+	score.DecayedAt = start.Add(time.Duration(days) * trustDecayUnit).Format(time.RFC3339)
+	return true
+}
+
+// SetTrustDecayConfig replaces the trust decay rates, e.g.
+// {"defaultRate":0.05,"roleRates":{"CARRIER":0.1,"SUPPLIER":0.03}}. Rates must be
+// between 0 and 1, where 0 disables decay. Only the admin organization or the submitting
+// chaincode may change them.
+func (c *ConsensusContract) SetTrustDecayConfig(ctx contractapi.TransactionContextInterface,
+	configJSON string) error {
+
+	if err := requireAdminOrSubmitter(ctx); err != nil {
+		return err
+	}
+	var config TrustDecayConfig
+	if err := validateJSON("configJSON", configJSON, &config); err != nil {
+		return err
+	}
+	if config.DefaultRate < 0 || config.DefaultRate >= 1 {
+		return newError(ErrInvalidArgument, "defaultRate must be at least 0 and below 1")
+	}
+	for role, rate := range config.RoleRates {
+		if err := validateRequired("role", role, trustDecayRoleMaxLength); err != nil {
+			return err
+		}
+		if rate < 0 || rate >= 1 {
+			return newError(ErrInvalidArgument, "decay rate of role %s must be at least 0 and below 1", role)
+		}
+	}
+
+	timestamp, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	now := timestamp.Format(time.RFC3339)
+	config.UpdatedAt = now
+	storedJSON, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState(trustDecayConfigKey, storedJSON)
+	if err != nil {
+		return fmt.Errorf("failed to store trust decay config: %v", err)
+	}
+
+	event := ConsensusEvent{
+		EventType: "TRUST_DECAY_CONFIG_UPDATED",
+		Timestamp: now,
+		Payload: map[string]interface{}{
+			"defaultRate": config.DefaultRate,
+			"roleRates":   config.RoleRates,
+		},
+	}
+	return c.emitEvent(ctx, event)
+}
+
+// GetTrustDecayConfig returns the trust decay rates in force
+func (c *ConsensusContract) GetTrustDecayConfig(ctx contractapi.TransactionContextInterface) (*TrustDecayConfig, error) {
+	return getTrustDecayConfig(ctx)
+}
+
+// SetPartyRole records the role of a party, which selects its trust decay rate. The
+// submitting chaincode calls it when it assigns organization roles; otherwise only the
+// admin organization may.
+func (c *ConsensusContract) SetPartyRole(ctx contractapi.TransactionContextInterface,
+	partyID string, role string) error {
+
+	if err := requireAdminOrSubmitter(ctx); err != nil {
+		return err
+	}
+	if err := validateAll(
+		validateID("partyID", partyID),
+		validateRequired("role", role, trustDecayRoleMaxLength),
+	); err != nil {
+		return err
+	}
+
+	score, err := c.getTrustScore(ctx, partyID)
+	if err != nil {
+		return err
+	}
+	score.Role = role
+	return c.saveTrustScore(ctx, score)
+}
+
+// DecayTrustScores persists the decay of every stored trust score, so that queries and
+// reports reading the ledger directly see current scores. Scores are also decayed when
+// read through the contract, so running it is never required for correctness.
+func (c *ConsensusContract) DecayTrustScores(ctx contractapi.TransactionContextInterface) (int, error) {
+	config, err := getTrustDecayConfig(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	resultsIterator, err := ctx.GetStub().GetStateByRange("TRUST_", "TRUST_~")
+	if err != nil {
+		return 0, err
+	}
+	defer resultsIterator.Close()
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return 0, err
+	}
+	decayed := 0
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return 0, err
+		}
+
+		var score TrustScore
+		if err := json.Unmarshal(queryResponse.Value, &score); err != nil {
+			logFor(ctx).Warn("skipping unreadable trust score", "key", queryResponse.Key, "error", err)
+			continue
+		}
+		if !applyTrustDecay(&score, config, now) {
+			continue
+		}
+		if err := c.saveTrustScore(ctx, &score); err != nil {
+			return 0, err
+		}
+		decayed++
+	}
+
+	event := ConsensusEvent{
+		EventType: "TRUST_SCORES_DECAYED",
+		Timestamp: now.Format(time.RFC3339),
+		Payload: map[string]interface{}{
+			"decayed": decayed,
+		},
+	}
+	return decayed, c.emitEvent(ctx, event)
+}
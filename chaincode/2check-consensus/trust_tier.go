@@ -0,0 +1,55 @@
+package main
+
+import (
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// TrustTier groups trust scores into the levels other chaincodes act on
+type TrustTier string
+
+const (
+	TierProbation TrustTier = "PROBATION" // Senders must back their confirmations with evidence
+	TierStandard  TrustTier = "STANDARD"
+	TierTrusted   TrustTier = "TRUSTED"
+	TierPlatinum  TrustTier = "PLATINUM" // Longer timeouts, always auto-confirmed when sending
+)
+
+// Tier thresholds. The higher tiers also need a track record, so a handful of clean
+// transactions does not earn them.
+const (
+	probationScoreBelow     = 0.4
+	trustedMinScore         = 0.8
+	trustedMinTransactions  = 10
+	platinumMinScore        = 0.95
+	platinumMinTransactions = 50
+	platinumTimeoutFactor   = 2
+)
+
+// trustTierOf returns the tier a trust score falls in
+func trustTierOf(score *TrustScore) TrustTier {
+	switch {
+	case score.Score < probationScoreBelow:
+		return TierProbation
+	case score.Score >= platinumMinScore && score.TotalTransactions >= platinumMinTransactions:
+		return TierPlatinum
+	case score.Score >= trustedMinScore && score.TotalTransactions >= trustedMinTransactions:
+		return TierTrusted
+	default:
+		return TierStandard
+	}
+}
+
+// GetTrustTier returns the current trust tier of a party
+func (c *ConsensusContract) GetTrustTier(ctx contractapi.TransactionContextInterface,
+	partyID string) (string, error) {
+
+	if err := validateID("partyID", partyID); err != nil {
+		return "", err
+	}
+
+	score, err := c.getTrustScore(ctx, partyID)
+	if err != nil {
+		return "", err
+	}
+	return string(score.Tier), nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// Input limits shared by all transactions
+const (
+	maxIDLength   = 128
+	maxNameLength = 256
+	maxTextLength = 2048
+	maxJSONLength = 64 * 1024
+)
+
+// Transaction IDs are used directly as ledger keys, so they are limited to
+// the same characters the luxury-supply-chain chaincode accepts for IDs
+var idPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9.:-]*$`)
+
+// Declared values are decimal strings in an ISO 4217 currency
+var (
+	amountPattern   = regexp.MustCompile(`^[0-9]{1,15}(\.[0-9]{1,4})?$`)
+	currencyPattern = regexp.MustCompile(`^[A-Z]{3}$`)
+)
+
+// validateAll returns the first failed validation
+func validateAll(errs ...error) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateID checks a required identifier used in ledger keys
+func validateID(field string, value string) error {
+	if value == "" {
+		return newError(ErrInvalidArgument, "%s is required", field)
+	}
+	if len(value) > maxIDLength {
+		return newError(ErrInvalidArgument, "%s exceeds %d characters", field, maxIDLength)
+	}
+	if !idPattern.MatchString(value) {
+		return newError(ErrInvalidArgument, "%s %q may only contain letters, digits, '.', ':' and '-'", field, value)
+	}
+	return nil
+}
+
+// validateRequired checks a mandatory free-text value
+func validateRequired(field string, value string, maxLength int) error {
+	if strings.TrimSpace(value) == "" {
+		return newError(ErrInvalidArgument, "%s is required", field)
+	}
+	return validateText(field, value, maxLength)
+}
+
+// validateText checks an optional free-text value for length and control characters
+func validateText(field string, value string, maxLength int) error {
+	if len(value) > maxLength {
+		return newError(ErrInvalidArgument, "%s exceeds %d characters", field, maxLength)
+	}
+	for _, r := range value {
+		if unicode.IsControl(r) && r != '\n' && r != '\t' {
+			return newError(ErrInvalidArgument, "%s contains control characters", field)
+		}
+	}
+	return nil
+}
+
+// validateQuantity checks that a quantity is greater than zero
+func validateQuantity(field string, value int) error {
+	if value <= 0 {
+		return newError(ErrInvalidArgument, "%s must be greater than zero, got %d", field, value)
+	}
+	return nil
+}
+
+// validateNonNegative checks an optional quantity where zero means "not specified"
+func validateNonNegative(field string, value int) error {
+	if value < 0 {
+		return newError(ErrInvalidArgument, "%s cannot be negative, got %d", field, value)
+	}
+	return nil
+}
+
+// validateEnum checks that value is one of the allowed values
+func validateEnum(field string, value string, allowed ...string) error {
+	for _, candidate := range allowed {
+		if value == candidate {
+			return nil
+		}
+	}
+	return newError(ErrInvalidArgument, "invalid %s %q, must be one of %s", field, value, strings.Join(allowed, ", "))
+}
+
+// validateJSON checks the size of a JSON argument and decodes it into target
+func validateJSON(field string, value string, target interface{}) error {
+	if len(value) > maxJSONLength {
+		return newError(ErrInvalidArgument, "%s exceeds %d bytes", field, maxJSONLength)
+	}
+	if err := json.Unmarshal([]byte(value), target); err != nil {
+		return newError(ErrInvalidArgument, "invalid %s: %v", field, err)
+	}
+	return nil
+}
+
+// validateAmount checks a decimal amount greater than zero
+func validateAmount(field string, value string) error {
+	if !amountPattern.MatchString(value) || strings.Trim(value, "0.") == "" {
+		return newError(ErrInvalidArgument, "%s must be a positive decimal number, got %q", field, value)
+	}
+	return nil
+}
+
+// validateCurrency checks an ISO 4217 currency code
+func validateCurrency(field string, value string) error {
+	if !currencyPattern.MatchString(value) {
+		return newError(ErrInvalidArgument, "%s %q must be a 3-letter ISO 4217 code", field, value)
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// isInvalidArgument reports whether err is an INVALID_ARGUMENT consensus error
+func isInvalidArgument(err error) bool {
+	var consensusErr *ConsensusError
+	return errors.As(err, &consensusErr) && consensusErr.Code == ErrInvalidArgument
+}
+
+func TestValidateID(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		wantErr bool
+	}{
+		{"plain", "TX-2024.001:A", false},
+		{"empty", "", true},
+		{"underscore key separator", "TX_1", true},
+		{"range terminator", "TX~", true},
+		{"newline", "TX\n1", true},
+		{"null byte", "TX\x001", true},
+		{"space", "TX 1", true},
+		{"leading dash", "-TX", true},
+		{"length 128", strings.Repeat("a", maxIDLength), false},
+		{"length 129", strings.Repeat("a", maxIDLength+1), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateID("txID", tt.value)
+			if tt.wantErr != isInvalidArgument(err) || (!tt.wantErr && err != nil) {
+				t.Fatalf("validateID(%q) = %v, want error %v", tt.value, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateQuantity(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   int
+		wantErr bool
+	}{
+		{"positive", 1, false},
+		{"zero", 0, true},
+		{"negative", -5, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateQuantity("quantity", tt.value)
+			if tt.wantErr != isInvalidArgument(err) || (!tt.wantErr && err != nil) {
+				t.Fatalf("validateQuantity(%d) = %v, want error %v", tt.value, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateEnum(t *testing.T) {
+	allowed := []string{"SENT", "RECEIVED", "VALIDATED"}
+	tests := []struct {
+		name    string
+		value   string
+		wantErr bool
+	}{
+		{"allowed", "SENT", false},
+		{"last allowed", "VALIDATED", false},
+		{"lower case", "sent", true},
+		{"empty", "", true},
+		{"unknown", "SHIPPED", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateEnum("state", tt.value, allowed...)
+			if tt.wantErr != isInvalidArgument(err) || (!tt.wantErr && err != nil) {
+				t.Fatalf("validateEnum(%q) = %v, want error %v", tt.value, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateJSON(t *testing.T) {
+	padded := func(length int) string {
+		// A JSON string of exactly length bytes, quotes included
+		return `"` + strings.Repeat("a", length-2) + `"`
+	}
+	tests := []struct {
+		name    string
+		value   string
+		wantErr bool
+	}{
+		{"at size limit", padded(maxJSONLength), false},
+		{"over size limit", padded(maxJSONLength + 1), true},
+		{"malformed", `"unterminated`, true},
+		{"wrong type", `{"orgs":["A"]}`, true},
+		{"empty", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var target string
+			err := validateJSON("valueJSON", tt.value, &target)
+			if tt.wantErr != isInvalidArgument(err) || (!tt.wantErr && err != nil) {
+				t.Fatalf("validateJSON = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,622 @@
+package contracts
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// AdminContract handles maintenance operations such as state migrations
+type AdminContract struct {
+	contractapi.Contract
+}
+
+// Default and maximum number of keys scanned per MigrateNamespace call
+const (
+	defaultMigrationBatchSize = 100
+	maxMigrationBatchSize     = 1000
+)
+
+// Default and maximum number of records per ExportStateSnapshot page
+const (
+	defaultExportPageSize = 100
+	maxExportPageSize     = 1000
+)
+
+// MigrationProgress is the checkpoint of a namespace migration
+type MigrationProgress struct {
+	Namespace     string `json:"namespace"`
+	TargetVersion int    `json:"targetVersion"` // Schema version the records are migrated to
+	IndexVersion  int    `json:"indexVersion"`  // Composite index version the records are indexed to
+	LastKey       string `json:"lastKey"`       // Last key scanned, the next call resumes after it
+	Scanned       int    `json:"scanned"`
+	Migrated      int    `json:"migrated"`
+	Completed     bool   `json:"completed"`
+	UpdatedAt     string `json:"updatedAt"`
+}
+
+// StateSnapshotEntry is one exported record
+type StateSnapshotEntry struct {
+	Key       string `json:"key"`
+	Value     string `json:"value"`     // Canonical JSON: sorted keys, no insignificant whitespace
+	ValueHash string `json:"valueHash"` // SHA256 of Value
+}
+
+// StateSnapshotPage is one page of a namespace export.
+// PageHash covers the previous page hash and every entry, so the pages of an
+// export form a chain that an auditor can recompute from the entries alone.
+type StateSnapshotPage struct {
+	Namespace        string               `json:"namespace"`
+	PageNumber       int                  `json:"pageNumber"`
+	AsOf             string               `json:"asOf"` // Timestamp of the query transaction
+	Entries          []StateSnapshotEntry `json:"entries"`
+	PreviousPageHash string               `json:"previousPageHash"`
+	PageHash         string               `json:"pageHash"`
+	Bookmark         string               `json:"bookmark"` // Pass to the next call, empty on the last page
+}
+
+// snapshotCursor is the decoded form of a StateSnapshotPage bookmark
+type snapshotCursor struct {
+	Bookmark   string `json:"bookmark"` // Fabric range query bookmark
+	PageHash   string `json:"pageHash"`
+	PageNumber int    `json:"pageNumber"`
+}
+
+// stateNamespace describes the keys holding one persisted type, for migration and export
+type stateNamespace struct {
+	prefix    string // Key prefix, empty for legacy products stored under their bare ID, or the key of a single config record
+	newRecord func() schemaRecord
+}
+
+// legacyProductNamespace holds products stored under their bare ID.
+// Migrating it moves each product to its productKey.
+const legacyProductNamespace = "legacyProduct"
+
+var stateNamespaces = map[string]stateNamespace{
+	"product":             {productKeyPrefix, func() schemaRecord { return &Product{} }},
+	"legacyProduct":       {"", func() schemaRecord { return &Product{} }},
+	"batch":               {"batch_", func() schemaRecord { return &ProductBatch{} }},
+	"transfer":            {"transfer_", func() schemaRecord { return &Transfer{} }},
+	"certificate":         {"cert_", func() schemaRecord { return &DigitalBirthCertificate{} }},
+	"ownership":           {"ownership_", func() schemaRecord { return &Ownership{} }},
+	"inventory":           {"material_inventory_", func() schemaRecord { return &MaterialInventory{} }},
+	"organization":        {"org_", func() schemaRecord { return &OrganizationInfo{} }},
+	"accessLog":           {"access_log_", func() schemaRecord { return &OwnerDataAccessEntry{} }},
+	"verificationToken":   {"verify_token_", func() schemaRecord { return &VerificationToken{} }},
+	"disclosureSalt":      {"disclosure_salt_", func() schemaRecord { return &CertificateDisclosureSalts{} }},
+	"config":              {consensusConfigKey, func() schemaRecord { return &ConsensusConfig{} }},
+	"featureFlags":        {featureFlagsKey, func() schemaRecord { return &FeatureFlags{} }},
+	"paymentConfig":       {paymentConfigKey, func() schemaRecord { return &PaymentConfig{} }},
+	"ledgerLog":           {ledgerLogKeyPrefix, func() schemaRecord { return &LedgerLogEntry{} }},
+	"checkpoint":          {checkpointKeyPrefix, func() schemaRecord { return &Checkpoint{} }},
+	"oracle":              {oracleKeyPrefix, func() schemaRecord { return &Oracle{} }},
+	"referenceData":       {referenceDataKeyPrefix, func() schemaRecord { return &ReferenceData{} }},
+	"organizationStats":   {organizationStatsKeyPrefix, func() schemaRecord { return &OrganizationStats{} }},
+	"regulatedMaterials":  {regulatedMaterialsKey, func() schemaRecord { return &RegulatedMaterials{} }},
+	"sourcingDeclaration": {sourcingDeclarationKeyPrefix, func() schemaRecord { return &SourcingDeclaration{} }},
+	"craftsman":           {craftsmanKeyPrefix, func() schemaRecord { return &Craftsman{} }},
+	"conditionPhotos":     {conditionPhotosKeyPrefix, func() schemaRecord { return &ConditionPhotoRecord{} }},
+	"transferDuty":        {dutyKeyPrefix, func() schemaRecord { return &DutyRecord{} }},
+	"currencyConfig":      {currencyConfigKey, func() schemaRecord { return &CurrencyConfig{} }},
+	"damageClaim":         {damageClaimKeyPrefix, func() schemaRecord { return &DamageClaim{} }},
+	"writeOff":            {writeOffKeyPrefix, func() schemaRecord { return &WriteOff{} }},
+	"identifierReissue":   {reissueKeyPrefix, func() schemaRecord { return &IdentifierReissue{} }},
+	"nfcChip":             {nfcChipKeyPrefix, func() schemaRecord { return &NFCChip{} }},
+	"chipReplacement":     {chipReplacementKeyPrefix, func() schemaRecord { return &ChipReplacement{} }},
+	"transferFlowRules":   {transferFlowRulesKey, func() schemaRecord { return &TransferFlowRules{} }},
+	"anomaly":             {anomalyKeyPrefix, func() schemaRecord { return &Anomaly{} }},
+	"receiptNorm":         {receiptNormKeyPrefix, func() schemaRecord { return &ReceiptNorm{} }},
+	"materialReservation": {reservationKeyPrefix, func() schemaRecord { return &MaterialReservation{} }},
+	"productTemplate":     {productTemplateKeyPrefix, func() schemaRecord { return &ProductTemplate{} }},
+	"transitCheckpoint":   {transitCheckpointKeyPrefix, func() schemaRecord { return &TransitCheckpoint{} }},
+	"sensorAnchor":        {sensorAnchorKeyPrefix, func() schemaRecord { return &SensorAnchor{} }},
+	"consensusPolicy":     {consensusPolicyKeyPrefix, func() schemaRecord { return &ConsensusPolicy{} }},
+	"stolenSerial":        {stolenSerialKeyPrefix, func() schemaRecord { return &StolenRegistryEntry{} }},
+	"stolenChip":          {stolenChipKeyPrefix, func() schemaRecord { return &StolenRegistryEntry{} }},
+	"ownershipRules":      {ownershipTransferRulesKeyPrefix, func() schemaRecord { return &OwnershipTransferRules{} }},
+	"resaleCertification": {resaleCertificationKeyPrefix, func() schemaRecord { return &ResaleCertificationRequest{} }},
+	"warrantyTerms":       {warrantyTermsKeyPrefix, func() schemaRecord { return &WarrantyTerms{} }},
+	"insurancePolicy":     {insurancePolicyKeyPrefix, func() schemaRecord { return &InsurancePolicy{} }},
+	"insuranceClaim":      {insuranceClaimKeyPrefix, func() schemaRecord { return &InsuranceClaim{} }},
+	"appraisal":           {appraisalKeyPrefix, func() schemaRecord { return &AppraisalRecord{} }},
+	"certificateHistory":  {certificateHistoryKeyPrefix, func() schemaRecord { return &DigitalBirthCertificate{} }},
+	"chipVerification":    {chipVerificationFailureKeyPrefix, func() schemaRecord { return &ChipVerificationFailure{} }},
+	"counterfeitReport":   {counterfeitReportKeyPrefix, func() schemaRecord { return &CounterfeitReport{} }},
+	"verificationScan":    {verificationScanKeyPrefix, func() schemaRecord { return &VerificationScan{} }},
+	"gs1CompanyPrefix":    {gs1CompanyPrefixKeyPrefix, func() schemaRecord { return &GS1CompanyPrefix{} }},
+	"tradeDocumentRules":  {tradeDocumentRulesKey, func() schemaRecord { return &TradeDocumentRules{} }},
+}
+
+// GetSchemaVersion returns the schema version written by this chaincode
+func (a *AdminContract) GetSchemaVersion(ctx contractapi.TransactionContextInterface) int {
+	return CurrentSchemaVersion
+}
+
+// GetMigrationNamespaces lists the namespaces accepted by MigrateNamespace and ExportStateSnapshot
+func (a *AdminContract) GetMigrationNamespaces(ctx contractapi.TransactionContextInterface) []string {
+	return stateNamespaceNames()
+}
+
+// MigrateNamespace upgrades up to batchSize records of a namespace to the current schema.
+// Progress is checkpointed on the ledger, so it is called repeatedly until Completed is true.
+func (a *AdminContract) MigrateNamespace(ctx contractapi.TransactionContextInterface,
+	namespace string, batchSize int) (*MigrationProgress, error) {
+
+	if _, err := requireSuperAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	ns, err := lookupStateNamespace(namespace)
+	if err != nil {
+		return nil, err
+	}
+	if batchSize <= 0 {
+		batchSize = defaultMigrationBatchSize
+	}
+	if batchSize > maxMigrationBatchSize {
+		batchSize = maxMigrationBatchSize
+	}
+
+	progress, err := a.GetMigrationProgress(ctx, namespace)
+	if err != nil {
+		return nil, err
+	}
+	// A newer chaincode version starts the namespace over
+	if progress.TargetVersion != CurrentSchemaVersion || progress.IndexVersion != CurrentIndexVersion {
+		progress = newMigrationProgress(namespace)
+	}
+	// Checkpoints from before a namespace's prefix changed point outside it
+	if progress.LastKey != "" && !strings.HasPrefix(progress.LastKey, ns.prefix) {
+		progress = newMigrationProgress(namespace)
+	}
+	if progress.Completed {
+		return progress, nil
+	}
+
+	startKey := ns.prefix
+	if progress.LastKey != "" {
+		startKey = progress.LastKey
+	}
+	endKey := ""
+	if ns.prefix != "" {
+		endKey = ns.prefix + "~"
+	}
+
+	resultsIterator, err := ctx.GetStub().GetStateByRange(startKey, endKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan namespace %s: %v", namespace, err)
+	}
+	defer resultsIterator.Close()
+
+	scanned := 0
+	for scanned < batchSize && resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		key := queryResponse.Key
+		if key == progress.LastKey {
+			continue
+		}
+		scanned++
+		progress.LastKey = key
+
+		// Every other record type has a prefixed key
+		if ns.prefix == "" && strings.Contains(key, "_") {
+			continue
+		}
+
+		record := ns.newRecord()
+		if err := json.Unmarshal(queryResponse.Value, record); err != nil {
+			logFor(ctx).Warn("skipping unreadable record during migration", "key", key, "namespace", namespace, "error", err)
+			continue
+		}
+		upgraded := record.upgradeSchema()
+		// Transfers and products recorded before their indexes existed are indexed on the way
+		if transfer, ok := record.(*Transfer); ok {
+			if err := indexTransfer(ctx, transfer); err != nil {
+				return nil, err
+			}
+		}
+		if product, ok := record.(*Product); ok {
+			if err := indexProductIdentifier(ctx, product); err != nil {
+				return nil, err
+			}
+		}
+		if err := reindexRecord(ctx.GetStub(), record); err != nil {
+			return nil, fmt.Errorf("failed to index %s: %v", key, err)
+		}
+		targetKey := key
+		if namespace == legacyProductNamespace {
+			targetKey = productKey(key)
+		}
+		if !upgraded && targetKey == key {
+			continue
+		}
+
+		recordJSON, err := json.Marshal(record)
+		if err != nil {
+			return nil, err
+		}
+		if targetKey != key {
+			// A copy written under the new key since the upgrade is newer than this one
+			existing, err := ctx.GetStub().GetState(targetKey)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read %s: %v", targetKey, err)
+			}
+			if existing == nil {
+				if err := ctx.GetStub().PutState(targetKey, recordJSON); err != nil {
+					return nil, fmt.Errorf("failed to migrate %s: %v", key, err)
+				}
+			}
+			if err := ctx.GetStub().DelState(key); err != nil {
+				return nil, fmt.Errorf("failed to remove %s: %v", key, err)
+			}
+		} else if err := ctx.GetStub().PutState(key, recordJSON); err != nil {
+			return nil, fmt.Errorf("failed to migrate %s: %v", key, err)
+		}
+		progress.Migrated++
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+	progress.Scanned += scanned
+	progress.Completed = !resultsIterator.HasNext()
+	progress.UpdatedAt = now.UTC().Format(time.RFC3339)
+
+	progressJSON, err := json.Marshal(progress)
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.GetStub().PutState("migration_"+namespace, progressJSON); err != nil {
+		return nil, fmt.Errorf("failed to save migration progress: %v", err)
+	}
+
+	return progress, nil
+}
+
+// newMigrationProgress returns the checkpoint of a namespace migration that has not started
+func newMigrationProgress(namespace string) *MigrationProgress {
+	return &MigrationProgress{
+		Namespace:     namespace,
+		TargetVersion: CurrentSchemaVersion,
+		IndexVersion:  CurrentIndexVersion,
+	}
+}
+
+// GetMigrationProgress returns the migration checkpoint of a namespace
+func (a *AdminContract) GetMigrationProgress(ctx contractapi.TransactionContextInterface,
+	namespace string) (*MigrationProgress, error) {
+
+	if _, err := lookupStateNamespace(namespace); err != nil {
+		return nil, err
+	}
+
+	progressJSON, err := ctx.GetStub().GetState("migration_" + namespace)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migration progress: %v", err)
+	}
+	if progressJSON == nil {
+		return newMigrationProgress(namespace), nil
+	}
+
+	var progress MigrationProgress
+	err = json.Unmarshal(progressJSON, &progress)
+	if err != nil {
+		return nil, err
+	}
+
+	return &progress, nil
+}
+
+// SetConsensusConfig stores the consensus chaincode and channel used for cross-chaincode calls
+func (a *AdminContract) SetConsensusConfig(ctx contractapi.TransactionContextInterface,
+	chaincodeName string, channelName string) error {
+
+	if err := validateAll(
+		validateChaincodeName("chaincodeName", chaincodeName),
+		validateChannelName("channelName", channelName),
+	); err != nil {
+		return err
+	}
+
+	caller, err := requireSuperAdmin(ctx)
+	if err != nil {
+		return err
+	}
+
+	previous, err := getConsensusConfig(ctx)
+	if err != nil {
+		return err
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	config := ConsensusConfig{
+		ConsensusChaincodeName: chaincodeName,
+		ChannelName:            channelName,
+		UpdatedBy:              caller,
+		UpdatedAt:              now.UTC().Format(time.RFC3339),
+		SchemaVersion:          CurrentSchemaVersion,
+	}
+	configJSON, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState(consensusConfigKey, configJSON)
+	if err != nil {
+		return fmt.Errorf("failed to store consensus config: %v", err)
+	}
+
+	return emitEvent(ctx, ChaincodeEvent{
+		EventType:  EventConsensusConfigUpdated,
+		EntityType: EventEntityConfig,
+		EntityID:   consensusConfigKey,
+		Attributes: map[string]interface{}{
+			"previousChaincodeName": previous.ConsensusChaincodeName,
+			"previousChannelName":   previous.ChannelName,
+			"chaincodeName":         chaincodeName,
+			"channelName":           channelName,
+		},
+	})
+}
+
+// GetConsensusConfig returns the consensus chaincode and channel in use
+func (a *AdminContract) GetConsensusConfig(ctx contractapi.TransactionContextInterface) (*ConsensusConfig, error) {
+	return getConsensusConfig(ctx)
+}
+
+// SetPaymentConfig stores the token chaincode used to settle delivery-versus-payment transfers.
+// An empty chaincodeName disables payments; transferFunction defaults to "Transfer".
+func (a *AdminContract) SetPaymentConfig(ctx contractapi.TransactionContextInterface,
+	chaincodeName string, transferFunction string) error {
+
+	if transferFunction == "" {
+		transferFunction = DefaultPaymentTransferFunction
+	}
+	if chaincodeName != "" {
+		if err := validateChaincodeName("chaincodeName", chaincodeName); err != nil {
+			return err
+		}
+	}
+	if err := validateID("transferFunction", transferFunction); err != nil {
+		return err
+	}
+
+	caller, err := requireSuperAdmin(ctx)
+	if err != nil {
+		return err
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	config := PaymentConfig{
+		TokenChaincodeName: chaincodeName,
+		TransferFunction:   transferFunction,
+		UpdatedBy:          caller,
+		UpdatedAt:          now.UTC().Format(time.RFC3339),
+		SchemaVersion:      CurrentSchemaVersion,
+	}
+	configJSON, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState(paymentConfigKey, configJSON)
+	if err != nil {
+		return fmt.Errorf("failed to store payment config: %v", err)
+	}
+
+	return emitEvent(ctx, ChaincodeEvent{
+		EventType:  EventPaymentConfigUpdated,
+		EntityType: EventEntityConfig,
+		EntityID:   paymentConfigKey,
+		Attributes: map[string]interface{}{
+			"chaincodeName":    chaincodeName,
+			"transferFunction": transferFunction,
+		},
+	})
+}
+
+// GetPaymentConfig returns the token chaincode used for payments
+func (a *AdminContract) GetPaymentConfig(ctx contractapi.TransactionContextInterface) (*PaymentConfig, error) {
+	return getPaymentConfig(ctx)
+}
+
+// SetFeatureFlags updates the flags named in flagsJSON, e.g. {"requireBrandApproval":true}
+func (a *AdminContract) SetFeatureFlags(ctx contractapi.TransactionContextInterface,
+	flagsJSON string) error {
+
+	var update featureFlagsUpdate
+	if err := validateJSON("flagsJSON", flagsJSON, &update); err != nil {
+		return err
+	}
+	if update.EnableAutoConfirm == nil && update.RequireBrandApproval == nil {
+		return newError(ErrInvalidArgument, "flagsJSON does not set any known feature flag")
+	}
+
+	caller, err := requireSuperAdmin(ctx)
+	if err != nil {
+		return err
+	}
+
+	flags, err := getFeatureFlags(ctx)
+	if err != nil {
+		return err
+	}
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	if update.EnableAutoConfirm != nil {
+		flags.EnableAutoConfirm = *update.EnableAutoConfirm
+	}
+	if update.RequireBrandApproval != nil {
+		flags.RequireBrandApproval = *update.RequireBrandApproval
+	}
+	flags.UpdatedBy = caller
+	flags.UpdatedAt = now.UTC().Format(time.RFC3339)
+
+	storedJSON, err := json.Marshal(flags)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState(featureFlagsKey, storedJSON)
+	if err != nil {
+		return fmt.Errorf("failed to store feature flags: %v", err)
+	}
+
+	return emitEvent(ctx, ChaincodeEvent{
+		EventType:  EventFeatureFlagsUpdated,
+		EntityType: EventEntityConfig,
+		EntityID:   featureFlagsKey,
+		Attributes: map[string]interface{}{
+			"enableAutoConfirm":    flags.EnableAutoConfirm,
+			"requireBrandApproval": flags.RequireBrandApproval,
+		},
+	})
+}
+
+// GetFeatureFlags returns the feature flags in effect
+func (a *AdminContract) GetFeatureFlags(ctx contractapi.TransactionContextInterface) (*FeatureFlags, error) {
+	return getFeatureFlags(ctx)
+}
+
+// ExportStateSnapshot returns one canonicalized, hash-chained page of a namespace's records.
+// Start with an empty bookmark and pass each page's bookmark to the next call.
+func (a *AdminContract) ExportStateSnapshot(ctx contractapi.TransactionContextInterface,
+	namespace string, pageSize int, bookmark string) (*StateSnapshotPage, error) {
+
+	if _, err := requireSuperAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	ns, err := lookupStateNamespace(namespace)
+	if err != nil {
+		return nil, err
+	}
+	if pageSize <= 0 {
+		pageSize = defaultExportPageSize
+	}
+	if pageSize > maxExportPageSize {
+		pageSize = maxExportPageSize
+	}
+
+	var cursor snapshotCursor
+	if bookmark != "" {
+		cursorJSON, err := base64.StdEncoding.DecodeString(bookmark)
+		if err != nil || json.Unmarshal(cursorJSON, &cursor) != nil {
+			return nil, newError(ErrInvalidArgument, "invalid bookmark")
+		}
+	}
+
+	endKey := ""
+	if ns.prefix != "" {
+		endKey = ns.prefix + "~"
+	}
+	resultsIterator, metadata, err := ctx.GetStub().GetStateByRangeWithPagination(ns.prefix, endKey, int32(pageSize), cursor.Bookmark)
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan namespace %s: %v", namespace, err)
+	}
+	defer resultsIterator.Close()
+
+	asOf := ""
+	if timestamp, err := ctx.GetStub().GetTxTimestamp(); err == nil {
+		asOf = timestamp.AsTime().UTC().Format(time.RFC3339)
+	}
+
+	page := &StateSnapshotPage{
+		Namespace:        namespace,
+		PageNumber:       cursor.PageNumber + 1,
+		AsOf:             asOf,
+		Entries:          []StateSnapshotEntry{},
+		PreviousPageHash: cursor.PageHash,
+	}
+
+	chain := sha256.New()
+	chain.Write([]byte(page.PreviousPageHash))
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		// Every other record type has a prefixed key
+		if ns.prefix == "" && strings.Contains(queryResponse.Key, "_") {
+			continue
+		}
+
+		value, err := canonicalJSON(queryResponse.Value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to canonicalize %s: %v", queryResponse.Key, err)
+		}
+		valueDigest := sha256.Sum256(value)
+		entry := StateSnapshotEntry{
+			Key:       queryResponse.Key,
+			Value:     string(value),
+			ValueHash: hex.EncodeToString(valueDigest[:]),
+		}
+		chain.Write([]byte("|" + entry.Key + "|" + entry.ValueHash))
+		page.Entries = append(page.Entries, entry)
+	}
+	page.PageHash = hex.EncodeToString(chain.Sum(nil))
+
+	if metadata != nil && metadata.Bookmark != "" && int(metadata.FetchedRecordsCount) == pageSize {
+		cursorJSON, err := json.Marshal(snapshotCursor{
+			Bookmark:   metadata.Bookmark,
+			PageHash:   page.PageHash,
+			PageNumber: page.PageNumber,
+		})
+		if err != nil {
+			return nil, err
+		}
+		page.Bookmark = base64.StdEncoding.EncodeToString(cursorJSON)
+	}
+
+	return page, nil
+}
+
+// canonicalJSON re-encodes a stored JSON value with sorted keys and no whitespace.
+// Numbers are kept as written so no precision is lost.
+func canonicalJSON(data []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	return json.Marshal(value)
+}
+
+// lookupStateNamespace resolves a namespace name
+func lookupStateNamespace(namespace string) (stateNamespace, error) {
+	ns, exists := stateNamespaces[namespace]
+	if !exists {
+		return stateNamespace{}, validateEnum("namespace", namespace, stateNamespaceNames()...)
+	}
+	return ns, nil
+}
+
+// stateNamespaceNames returns the namespace names in sorted order
+func stateNamespaceNames() []string {
+	names := make([]string, 0, len(stateNamespaces))
+	for name := range stateNamespaces {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,294 @@
+package contracts
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Every emitted event is also recorded under ledgerLogKeyPrefix, keyed by transaction
+// time so that a range scan returns the changes in order. Checkpoints hash these entries.
+const (
+	ledgerLogKeyPrefix   = "ledger_log_"
+	checkpointKeyPrefix  = "checkpoint_"
+	checkpointHeadKey    = "anchor_checkpoint_head"
+	ledgerLogTimeLayout  = "2006-01-02T15:04:05.000000000Z" // Fixed width, so keys sort by time
+	defaultCheckpointMax = 500
+	maxCheckpointEntries = 5000
+)
+
+// LedgerLogEntry records one state change covered by the checkpoints
+type LedgerLogEntry struct {
+	TxID          string `json:"txId"`
+	EventType     string `json:"eventType"`
+	EntityType    string `json:"entityType"`
+	EntityID      string `json:"entityId"`
+	EventHash     string `json:"eventHash"` // SHA256 of the emitted event payload
+	SchemaVersion int    `json:"schemaVersion"`
+}
+
+// Checkpoint is a digest over the ledger log entries after the previous checkpoint,
+// together with where a relayer anchored it on a public chain
+type Checkpoint struct {
+	Sequence        int    `json:"sequence"`
+	FromKey         string `json:"fromKey"`    // Last entry of the previous checkpoint, not included
+	ThroughKey      string `json:"throughKey"` // Last entry included
+	EntryCount      int    `json:"entryCount"`
+	PreviousDigest  string `json:"previousDigest"`
+	Digest          string `json:"digest"`                                         // SHA256 over the previous digest and every entry's key and hash
+	AnchorChain     string `json:"anchorChain,omitempty" metadata:",optional"`     // e.g. ethereum:mainnet
+	AnchorReference string `json:"anchorReference,omitempty" metadata:",optional"` // Transaction or block holding the digest there
+	Relayer         string `json:"relayer,omitempty" metadata:",optional"`
+	AnchoredAt      string `json:"anchoredAt,omitempty" metadata:",optional"`
+	SchemaVersion   int    `json:"schemaVersion"`
+}
+
+// recordLedgerLogEntry adds the event of the current transaction to the ledger log
+func recordLedgerLogEntry(ctx contractapi.TransactionContextInterface, event ChaincodeEvent, eventJSON []byte) error {
+	timestamp, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return fmt.Errorf("failed to read transaction timestamp: %v", err)
+	}
+
+	eventHash := sha256.Sum256(eventJSON)
+	entryJSON, err := json.Marshal(LedgerLogEntry{
+		TxID:          event.TxID,
+		EventType:     event.EventType,
+		EntityType:    event.EntityType,
+		EntityID:      event.EntityID,
+		EventHash:     hex.EncodeToString(eventHash[:]),
+		SchemaVersion: CurrentSchemaVersion,
+	})
+	if err != nil {
+		return err
+	}
+
+	key := ledgerLogKeyPrefix + timestamp.AsTime().UTC().Format(ledgerLogTimeLayout) + "_" + event.TxID
+	return ctx.GetStub().PutState(key, entryJSON)
+}
+
+// GetPendingCheckpoint computes the next checkpoint over up to maxEntries log entries,
+// for a relayer to anchor before calling AnchorCheckpoint
+func (a *AdminContract) GetPendingCheckpoint(ctx contractapi.TransactionContextInterface,
+	maxEntries int) (*Checkpoint, error) {
+
+	if maxEntries <= 0 {
+		maxEntries = defaultCheckpointMax
+	}
+	if maxEntries > maxCheckpointEntries {
+		maxEntries = maxCheckpointEntries
+	}
+
+	head, err := getCheckpointHead(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return computeCheckpoint(ctx, head, "", maxEntries)
+}
+
+// AnchorCheckpoint records that the checkpoint ending at throughKey, with the given digest,
+// was anchored on a public chain. The digest is recomputed and must match.
+func (a *AdminContract) AnchorCheckpoint(ctx contractapi.TransactionContextInterface,
+	throughKey string, digest string, anchorChain string, anchorReference string) (*Checkpoint, error) {
+
+	if err := validateAll(
+		validateRequired("throughKey", throughKey, maxNameLength),
+		validateRequired("digest", digest, maxNameLength),
+		validateName("anchorChain", anchorChain),
+		validateRequired("anchorReference", anchorReference, maxNameLength),
+	); err != nil {
+		return nil, err
+	}
+
+	caller, err := requireSuperAdmin(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	head, err := getCheckpointHead(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if throughKey <= head.ThroughKey {
+		return nil, newError(ErrInvalidState, "entries through %s are already covered by checkpoint %d", throughKey, head.Sequence)
+	}
+
+	checkpoint, err := computeCheckpoint(ctx, head, throughKey, 0)
+	if err != nil {
+		return nil, err
+	}
+	if checkpoint.ThroughKey != throughKey {
+		return nil, newError(ErrNotFound, "ledger log entry %s does not exist", throughKey)
+	}
+	if checkpoint.Digest != digest {
+		return nil, newError(ErrConflict, "digest mismatch: computed %s", checkpoint.Digest)
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+	checkpoint.AnchorChain = anchorChain
+	checkpoint.AnchorReference = anchorReference
+	checkpoint.Relayer = caller
+	checkpoint.AnchoredAt = now.UTC().Format(time.RFC3339)
+
+	checkpointJSON, err := json.Marshal(checkpoint)
+	if err != nil {
+		return nil, err
+	}
+	err = ctx.GetStub().PutState(checkpointKey(checkpoint.Sequence), checkpointJSON)
+	if err != nil {
+		return nil, fmt.Errorf("failed to store checkpoint: %v", err)
+	}
+	err = ctx.GetStub().PutState(checkpointHeadKey, checkpointJSON)
+	if err != nil {
+		return nil, fmt.Errorf("failed to store checkpoint head: %v", err)
+	}
+
+	err = emitEvent(ctx, ChaincodeEvent{
+		EventType:  EventCheckpointAnchored,
+		EntityType: EventEntityConfig,
+		EntityID:   checkpointKey(checkpoint.Sequence),
+		Attributes: map[string]interface{}{
+			"digest":          checkpoint.Digest,
+			"entryCount":      checkpoint.EntryCount,
+			"anchorChain":     anchorChain,
+			"anchorReference": anchorReference,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return checkpoint, nil
+}
+
+// GetCheckpoint returns an anchored checkpoint by sequence number
+func (a *AdminContract) GetCheckpoint(ctx contractapi.TransactionContextInterface,
+	sequence int) (*Checkpoint, error) {
+
+	checkpointJSON, err := ctx.GetStub().GetState(checkpointKey(sequence))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read checkpoint: %v", err)
+	}
+	if checkpointJSON == nil {
+		return nil, newError(ErrNotFound, "checkpoint %d does not exist", sequence)
+	}
+
+	var checkpoint Checkpoint
+	err = json.Unmarshal(checkpointJSON, &checkpoint)
+	if err != nil {
+		return nil, err
+	}
+	checkpoint.upgradeSchema()
+
+	return &checkpoint, nil
+}
+
+// VerifyCheckpoint recomputes an anchored checkpoint from the current ledger log and
+// reports whether it still matches, i.e. none of the covered entries were rewritten
+func (a *AdminContract) VerifyCheckpoint(ctx contractapi.TransactionContextInterface,
+	sequence int) (bool, error) {
+
+	checkpoint, err := a.GetCheckpoint(ctx, sequence)
+	if err != nil {
+		return false, err
+	}
+
+	previous := &Checkpoint{
+		Sequence:   checkpoint.Sequence - 1,
+		ThroughKey: checkpoint.FromKey,
+		Digest:     checkpoint.PreviousDigest,
+	}
+	recomputed, err := computeCheckpoint(ctx, previous, checkpoint.ThroughKey, 0)
+	if err != nil {
+		return false, err
+	}
+
+	return recomputed.Digest == checkpoint.Digest && recomputed.EntryCount == checkpoint.EntryCount, nil
+}
+
+// computeCheckpoint hashes the log entries after previous.ThroughKey, up to throughKey
+// when given and otherwise up to maxEntries entries
+func computeCheckpoint(ctx contractapi.TransactionContextInterface, previous *Checkpoint,
+	throughKey string, maxEntries int) (*Checkpoint, error) {
+
+	startKey := ledgerLogKeyPrefix
+	if previous.ThroughKey != "" {
+		startKey = previous.ThroughKey
+	}
+	endKey := ledgerLogKeyPrefix + "~"
+
+	resultsIterator, err := ctx.GetStub().GetStateByRange(startKey, endKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan ledger log: %v", err)
+	}
+	defer resultsIterator.Close()
+
+	checkpoint := &Checkpoint{
+		Sequence:       previous.Sequence + 1,
+		FromKey:        previous.ThroughKey,
+		ThroughKey:     previous.ThroughKey,
+		PreviousDigest: previous.Digest,
+		SchemaVersion:  CurrentSchemaVersion,
+	}
+
+	chain := sha256.New()
+	chain.Write([]byte(checkpoint.PreviousDigest))
+	for resultsIterator.HasNext() {
+		if throughKey == "" && checkpoint.EntryCount >= maxEntries {
+			break
+		}
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		if queryResponse.Key == previous.ThroughKey {
+			continue
+		}
+		if throughKey != "" && queryResponse.Key > throughKey {
+			break
+		}
+
+		var entry LedgerLogEntry
+		if err := json.Unmarshal(queryResponse.Value, &entry); err != nil {
+			return nil, fmt.Errorf("failed to parse ledger log entry %s: %v", queryResponse.Key, err)
+		}
+		chain.Write([]byte("|" + queryResponse.Key + "|" + entry.EventHash))
+		checkpoint.ThroughKey = queryResponse.Key
+		checkpoint.EntryCount++
+	}
+	checkpoint.Digest = hex.EncodeToString(chain.Sum(nil))
+
+	return checkpoint, nil
+}
+
+// getCheckpointHead returns the latest anchored checkpoint, or an empty one before the first
+func getCheckpointHead(ctx contractapi.TransactionContextInterface) (*Checkpoint, error) {
+	headJSON, err := ctx.GetStub().GetState(checkpointHeadKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read checkpoint head: %v", err)
+	}
+	if headJSON == nil {
+		return &Checkpoint{SchemaVersion: CurrentSchemaVersion}, nil
+	}
+
+	var head Checkpoint
+	err = json.Unmarshal(headJSON, &head)
+	if err != nil {
+		return nil, err
+	}
+	head.upgradeSchema()
+
+	return &head, nil
+}
+
+// checkpointKey returns the ledger key of a checkpoint, zero-padded so keys sort by sequence
+func checkpointKey(sequence int) string {
+	return fmt.Sprintf("%s%010d", checkpointKeyPrefix, sequence)
+}
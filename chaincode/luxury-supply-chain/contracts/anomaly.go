@@ -0,0 +1,351 @@
+package contracts
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Anomalies are stored as anomaly_<anomalyID>. Open anomalies are also queued for
+// review as risk_queue_<detectedAt>_<anomalyID>, with the ledger log's fixed-width
+// time layout so the queue is in detection order.
+const (
+	anomalyKeyPrefix      = "anomaly_"
+	riskQueueKeyPrefix    = "risk_queue_"
+	receiptNormKeyPrefix  = "receipt_norm_"
+	maxAnomalyQueuePage   = 100
+	anomalySpikeFactor    = 3.0 // A receipt this many times the route's mean quantity is a spike
+	anomalySpikeMinSample = 5   // Receipts a route needs before spikes are flagged
+)
+
+// Anomaly types
+const (
+	AnomalyDuplicateReceipt = "DUPLICATE_RECEIPT" // The item was no longer held by the sender when received
+	AnomalyQuantitySpike    = "QUANTITY_SPIKE"    // Far above the quantities usually received on the route
+	AnomalyTransferLoop     = "TRANSFER_LOOP"     // Received back by its manufacturer or supplier outside a return
+)
+
+// Anomaly statuses
+const (
+	AnomalyOpen      = "OPEN"
+	AnomalyConfirmed = "CONFIRMED" // The risk team found a real problem
+	AnomalyDismissed = "DISMISSED" // A false positive
+)
+
+// Anomaly is a suspicious receiving pattern flagged for the brand's risk team. Flagging
+// never blocks the receipt; the team reviews the anomaly with ReviewAnomaly.
+type Anomaly struct {
+	AnomalyID     string `json:"anomalyId"`
+	Type          string `json:"type"`
+	EntityType    string `json:"entityType"` // PRODUCT, BATCH or MATERIAL
+	EntityID      string `json:"entityId"`
+	TransferID    string `json:"transferId"`
+	Sender        string `json:"sender"`
+	Receiver      string `json:"receiver"`
+	Details       string `json:"details"`
+	Status        string `json:"status"`
+	DetectedAt    string `json:"detectedAt"`
+	TxID          string `json:"txId"`
+	ReviewedBy    string `json:"reviewedBy,omitempty" metadata:",optional"`
+	ReviewedAt    string `json:"reviewedAt,omitempty" metadata:",optional"`
+	ReviewNote    string `json:"reviewNote,omitempty" metadata:",optional"`
+	SchemaVersion int    `json:"schemaVersion"`
+}
+
+// ReceiptNorm is the running mean of the quantities received on a route, stored as
+// receipt_norm_<itemType>_<sender>_<receiver>
+type ReceiptNorm struct {
+	ItemType      string  `json:"itemType"`
+	Sender        string  `json:"sender"`
+	Receiver      string  `json:"receiver"`
+	Receipts      int     `json:"receipts"`
+	MeanQuantity  float64 `json:"meanQuantity"`
+	SchemaVersion int     `json:"schemaVersion"`
+}
+
+// receiptObservation is what the receiving functions know about a receipt when they
+// check it for anomalies
+type receiptObservation struct {
+	itemType   string // FlowItemProduct, FlowItemBatch or FlowItemMaterial
+	entityID   string
+	transferID string
+	sender     string
+	receiver   string
+	holder     string  // Holder of the item before the receipt, empty for materials
+	origin     string  // Manufacturer or original supplier, if known
+	quantity   float64 // Zero for single products
+	isReturn   bool
+}
+
+// getAnomaly reads an anomaly
+func getAnomaly(ctx contractapi.TransactionContextInterface, anomalyID string) (*Anomaly, error) {
+	anomalyJSON, err := ctx.GetStub().GetState(anomalyKeyPrefix + anomalyID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read anomaly: %v", err)
+	}
+	if anomalyJSON == nil {
+		return nil, newError(ErrNotFound, "anomaly %s does not exist", anomalyID)
+	}
+
+	var anomaly Anomaly
+	err = json.Unmarshal(anomalyJSON, &anomaly)
+	if err != nil {
+		return nil, err
+	}
+	anomaly.upgradeSchema()
+
+	return &anomaly, nil
+}
+
+// putAnomaly stores an anomaly and keeps it in the review queue while it is open
+func putAnomaly(ctx contractapi.TransactionContextInterface, anomaly *Anomaly) error {
+	anomalyJSON, err := json.Marshal(anomaly)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState(anomalyKeyPrefix+anomaly.AnomalyID, anomalyJSON)
+	if err != nil {
+		return fmt.Errorf("failed to store anomaly: %v", err)
+	}
+
+	detectedAt, err := time.Parse(time.RFC3339, anomaly.DetectedAt)
+	if err != nil {
+		return newError(ErrInvalidState, "anomaly %s has an invalid detection time", anomaly.AnomalyID)
+	}
+	queueKey := riskQueueKeyPrefix + detectedAt.UTC().Format(ledgerLogTimeLayout) + "_" + anomaly.AnomalyID
+	if anomaly.Status == AnomalyOpen {
+		// Empty values are deletes in Fabric, so the entry holds the anomaly ID
+		err = ctx.GetStub().PutState(queueKey, []byte(anomaly.AnomalyID))
+	} else {
+		err = ctx.GetStub().DelState(queueKey)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update the anomaly review queue: %v", err)
+	}
+	return nil
+}
+
+// flagAnomaly records an open anomaly of a receipt. The ID is derived from the
+// transaction, so every endorser flags the same one, and the transaction's event
+// lists it under the attribute anomalies.
+func flagAnomaly(ctx contractapi.TransactionContextInterface, anomalyType string,
+	receipt receiptObservation, details string) error {
+
+	txID := ctx.GetStub().GetTxID()
+	digest := sha256.Sum256([]byte(txID + "|" + anomalyType + "|" + receipt.entityID))
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	entityType := EventEntityProduct
+	switch receipt.itemType {
+	case FlowItemBatch:
+		entityType = EventEntityBatch
+	case FlowItemMaterial:
+		entityType = EventEntityMaterial
+	}
+	anomaly := &Anomaly{
+		AnomalyID:     hex.EncodeToString(digest[:16]),
+		Type:          anomalyType,
+		EntityType:    entityType,
+		EntityID:      receipt.entityID,
+		TransferID:    receipt.transferID,
+		Sender:        receipt.sender,
+		Receiver:      receipt.receiver,
+		Details:       details,
+		Status:        AnomalyOpen,
+		DetectedAt:    now.UTC().Format(time.RFC3339),
+		TxID:          txID,
+		SchemaVersion: CurrentSchemaVersion,
+	}
+	if err := putAnomaly(ctx, anomaly); err != nil {
+		return err
+	}
+
+	logFor(ctx).Warn("anomaly flagged", "anomalyId", anomaly.AnomalyID, "type", anomalyType,
+		"entityId", receipt.entityID, "transferId", receipt.transferID)
+	if tc, ok := ctx.(*TransactionContext); ok {
+		tc.anomalyIDs = append(tc.anomalyIDs, anomaly.AnomalyID)
+	}
+	return nil
+}
+
+// checkReceiptAnomalies flags the anomalies of a receipt and adds its quantity to the
+// route's norm. It runs before the receipt changes the item's holder.
+func checkReceiptAnomalies(ctx contractapi.TransactionContextInterface, receipt receiptObservation) error {
+	if receipt.holder != "" && receipt.holder != receipt.sender {
+		details := fmt.Sprintf("%s was held by %s, not the sender, when %s received it", receipt.entityID, receipt.holder, receipt.receiver)
+		if err := flagAnomaly(ctx, AnomalyDuplicateReceipt, receipt, details); err != nil {
+			return err
+		}
+	}
+
+	if receipt.origin == receipt.receiver && !receipt.isReturn {
+		details := fmt.Sprintf("%s came back to its origin %s outside a return", receipt.entityID, receipt.origin)
+		if err := flagAnomaly(ctx, AnomalyTransferLoop, receipt, details); err != nil {
+			return err
+		}
+	}
+
+	if receipt.quantity <= 0 {
+		return nil
+	}
+	key := receiptNormKeyPrefix + receipt.itemType + "_" + receipt.sender + "_" + receipt.receiver
+	normJSON, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return fmt.Errorf("failed to read receipt norm: %v", err)
+	}
+	norm := ReceiptNorm{ItemType: receipt.itemType, Sender: receipt.sender, Receiver: receipt.receiver}
+	if normJSON != nil {
+		if err := json.Unmarshal(normJSON, &norm); err != nil {
+			return fmt.Errorf("failed to parse receipt norm: %v", err)
+		}
+	}
+
+	if norm.Receipts >= anomalySpikeMinSample && receipt.quantity > anomalySpikeFactor*norm.MeanQuantity {
+		details := fmt.Sprintf("received %.2f, the mean of %d earlier receipts from %s is %.2f",
+			receipt.quantity, norm.Receipts, receipt.sender, norm.MeanQuantity)
+		if err := flagAnomaly(ctx, AnomalyQuantitySpike, receipt, details); err != nil {
+			return err
+		}
+	}
+
+	norm.Receipts++
+	norm.MeanQuantity += (receipt.quantity - norm.MeanQuantity) / float64(norm.Receipts)
+	norm.SchemaVersion = CurrentSchemaVersion
+	normJSON, err = json.Marshal(norm)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState(key, normJSON)
+	if err != nil {
+		return fmt.Errorf("failed to store receipt norm: %v", err)
+	}
+	return nil
+}
+
+// checkProductReceiptAnomalies checks the receipt of a single product, whose origin is
+// the manufacturer of its batch
+func (s *SupplyChainContract) checkProductReceiptAnomalies(ctx contractapi.TransactionContextInterface,
+	product *Product, transfer *Transfer) error {
+
+	origin := ""
+	if product.BatchID != "" {
+		batch, err := s.GetBatch(ctx, product.BatchID)
+		if err != nil {
+			return wrapError(err, "failed to get batch")
+		}
+		origin = batch.Manufacturer
+	}
+
+	return checkReceiptAnomalies(ctx, receiptObservation{
+		itemType:   FlowItemProduct,
+		entityID:   product.ID,
+		transferID: transfer.ID,
+		sender:     transfer.From,
+		receiver:   transfer.To,
+		holder:     itemHolder(product.CurrentOwner, product.Consignment),
+		origin:     origin,
+		isReturn:   transfer.TransferType == TransferTypeReturn,
+	})
+}
+
+// ReviewAnomaly closes an open anomaly as CONFIRMED or DISMISSED. Only the brand
+// reviews anomalies.
+func (s *SupplyChainContract) ReviewAnomaly(ctx contractapi.TransactionContextInterface,
+	anomalyID string, decision string, note string) error {
+
+	if err := validateAll(
+		validateID("anomalyID", anomalyID),
+		validateEnum("decision", decision, AnomalyConfirmed, AnomalyDismissed),
+		validateRequired("note", note, maxTextLength),
+	); err != nil {
+		return err
+	}
+
+	caller, err := requireSuperAdmin(ctx)
+	if err != nil {
+		return err
+	}
+
+	anomaly, err := getAnomaly(ctx, anomalyID)
+	if err != nil {
+		return err
+	}
+	if anomaly.Status != AnomalyOpen {
+		return newError(ErrInvalidState, "anomaly %s is already %s", anomalyID, anomaly.Status)
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	anomaly.Status = decision
+	anomaly.ReviewedBy = caller
+	anomaly.ReviewedAt = now.UTC().Format(time.RFC3339)
+	anomaly.ReviewNote = note
+	err = putAnomaly(ctx, anomaly)
+	if err != nil {
+		return err
+	}
+
+	return emitEvent(ctx, ChaincodeEvent{
+		EventType:  EventAnomalyReviewed,
+		EntityType: EventEntityAnomaly,
+		EntityID:   anomalyID,
+		FromState:  AnomalyOpen,
+		ToState:    decision,
+		Attributes: map[string]interface{}{
+			"type":       anomaly.Type,
+			"itemId":     anomaly.EntityID,
+			"transferId": anomaly.TransferID,
+		},
+	})
+}
+
+// GetAnomaly returns an anomaly
+func (s *SupplyChainContract) GetAnomaly(ctx contractapi.TransactionContextInterface,
+	anomalyID string) (*Anomaly, error) {
+
+	if err := validateID("anomalyID", anomalyID); err != nil {
+		return nil, err
+	}
+
+	return getAnomaly(ctx, anomalyID)
+}
+
+// GetAnomalyReviewQueue returns up to 100 open anomalies, oldest first. Only the brand
+// reads the queue, since it shows other organizations' receiving patterns.
+func (s *SupplyChainContract) GetAnomalyReviewQueue(ctx contractapi.TransactionContextInterface) ([]*Anomaly, error) {
+	if _, err := requireSuperAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	resultsIterator, err := ctx.GetStub().GetStateByRange(riskQueueKeyPrefix, riskQueueKeyPrefix+"~")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query the anomaly review queue: %v", err)
+	}
+	defer resultsIterator.Close()
+
+	anomalies := []*Anomaly{}
+	for resultsIterator.HasNext() && len(anomalies) < maxAnomalyQueuePage {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		anomaly, err := getAnomaly(ctx, string(queryResponse.Value))
+		if err != nil {
+			logFor(ctx).Warn("skipping unreadable queued anomaly", "key", queryResponse.Key, "error", err)
+			continue
+		}
+		anomalies = append(anomalies, anomaly)
+	}
+
+	return anomalies, nil
+}
@@ -0,0 +1,196 @@
+package contracts
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Condition grades an appraiser can give, best first
+const (
+	ConditionGradeNew       = "NEW" // Unworn, with all packaging
+	ConditionGradeExcellent = "EXCELLENT"
+	ConditionGradeVeryGood  = "VERY_GOOD"
+	ConditionGradeGood      = "GOOD"
+	ConditionGradeFair      = "FAIR"
+)
+
+var conditionGrades = []string{
+	ConditionGradeNew, ConditionGradeExcellent, ConditionGradeVeryGood, ConditionGradeGood, ConditionGradeFair,
+}
+
+// Appraisal records are stored as appraisal_<productID>_<txTime>_<txID>, so a
+// product's appraisals are one key range in the order they were made
+const appraisalKeyPrefix = "appraisal_"
+
+// AppraisalRecord is an authorized appraiser's assessment of a product's condition.
+// The appraisal report, with the valuation, is kept off-chain and referenced by hash.
+type AppraisalRecord struct {
+	ProductID      string `json:"productId"`
+	ConditionGrade string `json:"conditionGrade"`
+	AppraisalHash  string `json:"appraisalHash"` // e.g. IPFS hash of the appraisal report
+	ValidUntil     string `json:"validUntil"`    // RFC3339; the appraisal is stale afterwards
+	AppraisedBy    string `json:"appraisedBy"`   // MSP ID of the appraiser
+	AppraisedAt    string `json:"appraisedAt"`
+	TxID           string `json:"txId"`
+	SchemaVersion  int    `json:"schemaVersion"`
+}
+
+// valid reports whether the appraisal has not expired at the given time
+func (a *AppraisalRecord) valid(at time.Time) bool {
+	validUntil, err := time.Parse(time.RFC3339, a.ValidUntil)
+	return err == nil && at.Before(validUntil)
+}
+
+// AddAppraisalRecord records an appraisal of a product by an organization with the
+// APPRAISER role. validUntil is an RFC3339 time after the transaction.
+func (r *ResaleContract) AddAppraisalRecord(ctx contractapi.TransactionContextInterface,
+	productID string, conditionGrade string, appraisalHash string, validUntil string) error {
+
+	if err := validateAll(
+		validateID("productID", productID),
+		validateEnum("conditionGrade", conditionGrade, conditionGrades...),
+		validateID("appraisalHash", appraisalHash),
+		validateRequired("validUntil", validUntil, maxNameLength),
+	); err != nil {
+		return err
+	}
+
+	caller, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to get caller identity: %v", err)
+	}
+	roleContract := &RoleManagementContract{}
+	hasPermission, err := roleContract.CheckPermission(ctx, caller, "ADD_APPRAISAL")
+	if err != nil || !hasPermission {
+		return newError(ErrPermissionDenied, "caller %s does not have permission to appraise products", caller)
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	expiry, err := time.Parse(time.RFC3339, validUntil)
+	if err != nil {
+		return newError(ErrInvalidArgument, "validUntil must be an RFC3339 time: %v", err)
+	}
+	if !expiry.After(now) {
+		return newError(ErrInvalidArgument, "validUntil %s is not in the future", validUntil)
+	}
+
+	supplyChain := &SupplyChainContract{}
+	product, err := supplyChain.GetProduct(ctx, productID)
+	if err != nil {
+		return err
+	}
+	switch product.Status {
+	case ProductStatusStolen, ProductStatusDestroyed, ProductStatusWrittenOff:
+		return newError(ErrInvalidState, "product %s is %s and cannot be appraised", productID, product.Status)
+	}
+
+	txID := ctx.GetStub().GetTxID()
+	record := AppraisalRecord{
+		ProductID:      productID,
+		ConditionGrade: conditionGrade,
+		AppraisalHash:  appraisalHash,
+		ValidUntil:     expiry.UTC().Format(time.RFC3339),
+		AppraisedBy:    caller,
+		AppraisedAt:    now.UTC().Format(time.RFC3339),
+		TxID:           txID,
+		SchemaVersion:  CurrentSchemaVersion,
+	}
+	recordJSON, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	key := appraisalKeyPrefix + productID + "_" + now.UTC().Format(ledgerLogTimeLayout) + "_" + txID
+	err = ctx.GetStub().PutState(key, recordJSON)
+	if err != nil {
+		return fmt.Errorf("failed to store appraisal: %v", err)
+	}
+
+	return emitEvent(ctx, ChaincodeEvent{
+		EventType:  EventAppraisalRecorded,
+		EntityType: EventEntityProduct,
+		EntityID:   productID,
+		Attributes: map[string]interface{}{
+			"conditionGrade": conditionGrade,
+			"appraisalHash":  appraisalHash,
+			"validUntil":     record.ValidUntil,
+		},
+	})
+}
+
+// GetLatestAppraisal returns a product's most recent appraisal, expired or not. The
+// current owner reads it with their owner and security hashes; organizations taking
+// part in certified pre-owned resale (ADD_SERVICE_RECORD), appraisers and the brand
+// may pass empty hashes.
+func (r *ResaleContract) GetLatestAppraisal(ctx contractapi.TransactionContextInterface,
+	productID string, ownerHash string, securityHash string) (*AppraisalRecord, error) {
+
+	if err := validateID("productID", productID); err != nil {
+		return nil, err
+	}
+
+	if ownerHash != "" || securityHash != "" {
+		ownershipContract := &OwnershipContract{}
+		ownership, err := ownershipContract.GetOwnership(ctx, productID)
+		if err != nil {
+			return nil, err
+		}
+		if ownership.OwnerHash != ownerHash || ownership.SecurityHash != securityHash {
+			return nil, newError(ErrPermissionDenied, "ownership verification failed")
+		}
+	} else {
+		caller, err := ctx.GetClientIdentity().GetMSPID()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get caller identity: %v", err)
+		}
+		roleContract := &RoleManagementContract{}
+		canCertify, _ := roleContract.CheckPermission(ctx, caller, "ADD_SERVICE_RECORD")
+		canAppraise, _ := roleContract.CheckPermission(ctx, caller, "ADD_APPRAISAL")
+		if !canCertify && !canAppraise {
+			return nil, newError(ErrPermissionDenied, "caller %s cannot read appraisals without the owner's verification", caller)
+		}
+	}
+
+	appraisal, err := latestAppraisal(ctx, productID)
+	if err != nil {
+		return nil, err
+	}
+	if appraisal == nil {
+		return nil, newError(ErrNotFound, "product %s has not been appraised", productID)
+	}
+	return appraisal, nil
+}
+
+// latestAppraisal returns a product's most recent appraisal, or nil if it has none
+func latestAppraisal(ctx contractapi.TransactionContextInterface, productID string) (*AppraisalRecord, error) {
+	prefix := appraisalKeyPrefix + productID + "_"
+	resultsIterator, err := ctx.GetStub().GetStateByRange(prefix, prefix+"~")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query appraisals: %v", err)
+	}
+	defer resultsIterator.Close()
+
+	var latest *AppraisalRecord
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var record AppraisalRecord
+		err = json.Unmarshal(queryResponse.Value, &record)
+		if err != nil {
+			return nil, err
+		}
+		record.upgradeSchema()
+		latest = &record
+	}
+
+	return latest, nil
+}
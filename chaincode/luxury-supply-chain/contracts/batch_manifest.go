@@ -0,0 +1,197 @@
+package contracts
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// ManifestProduct is one product line of a batch manifest
+type ManifestProduct struct {
+	ProductID        string `json:"productId"`
+	SerialNumber     string `json:"serialNumber"`
+	UniqueIdentifier string `json:"uniqueIdentifier"`
+	Variant          string `json:"variant,omitempty" metadata:",optional"`
+	CertificateHash  string `json:"certificateHash,omitempty" metadata:",optional"` // Empty for products without a certificate
+}
+
+// ManifestMaterialLot is one material lot a batch was made from
+type ManifestMaterialLot struct {
+	MaterialID   string  `json:"materialId"`
+	MaterialType string  `json:"materialType"`
+	Supplier     string  `json:"supplier"`
+	Lot          string  `json:"lot"` // Material batch number
+	QuantityUsed float64 `json:"quantityUsed"`
+}
+
+// manifestContent is the part of a BatchManifest covered by its hash
+type manifestContent struct {
+	BatchID      string                `json:"batchId"`
+	Brand        string                `json:"brand"`
+	Manufacturer string                `json:"manufacturer"`
+	ProductType  string                `json:"productType"`
+	Quantity     int                   `json:"quantity"`
+	Products     []ManifestProduct     `json:"products"`
+	MaterialLots []ManifestMaterialLot `json:"materialLots"`
+}
+
+// BatchManifest lists what a shipment of a batch should contain, for receivers to
+// compare with the goods at intake. ManifestHash is the SHA256 of the canonical JSON
+// (sorted keys, no whitespace) of every field before it.
+type BatchManifest struct {
+	BatchID      string                `json:"batchId"`
+	Brand        string                `json:"brand"`
+	Manufacturer string                `json:"manufacturer"`
+	ProductType  string                `json:"productType"`
+	Quantity     int                   `json:"quantity"`
+	Products     []ManifestProduct     `json:"products"`
+	MaterialLots []ManifestMaterialLot `json:"materialLots"`
+	ManifestHash string                `json:"manifestHash"`
+	RecordedHash string                `json:"recordedHash,omitempty" metadata:",optional"` // Last hash recorded by GenerateBatchManifest
+	GeneratedBy  string                `json:"generatedBy,omitempty" metadata:",optional"`
+	GeneratedAt  string                `json:"generatedAt,omitempty" metadata:",optional"`
+	Matches      bool                  `json:"matches"` // The batch is unchanged since the recorded manifest
+}
+
+// buildBatchManifest assembles the manifest of a batch from its products' current
+// certificates
+func buildBatchManifest(ctx contractapi.TransactionContextInterface, batch *ProductBatch) (*BatchManifest, error) {
+	content := manifestContent{
+		BatchID:      batch.ID,
+		Brand:        batch.Brand,
+		Manufacturer: batch.Manufacturer,
+		ProductType:  batch.ProductType,
+		Quantity:     batch.Quantity,
+		Products:     []ManifestProduct{},
+		MaterialLots: []ManifestMaterialLot{},
+	}
+
+	supplyChain := &SupplyChainContract{}
+	ownershipContract := &OwnershipContract{}
+	for _, productID := range batch.ProductIDs {
+		product, err := supplyChain.GetProduct(ctx, productID)
+		if err != nil {
+			return nil, err
+		}
+		line := ManifestProduct{
+			ProductID:        productID,
+			SerialNumber:     product.SerialNumber,
+			UniqueIdentifier: product.UniqueIdentifier,
+			Variant:          product.Variant,
+		}
+		certificate, err := ownershipContract.GetBirthCertificate(ctx, productID)
+		if err == nil {
+			line.CertificateHash = certificate.CertificateHash
+		} else if !hasErrorCode(err, ErrNotFound) {
+			return nil, err
+		}
+		content.Products = append(content.Products, line)
+	}
+	for _, usage := range batch.MaterialsUsed {
+		content.MaterialLots = append(content.MaterialLots, ManifestMaterialLot{
+			MaterialID:   usage.MaterialID,
+			MaterialType: usage.MaterialType,
+			Supplier:     usage.Supplier,
+			Lot:          usage.Batch,
+			QuantityUsed: usage.QuantityUsed,
+		})
+	}
+
+	contentJSON, err := json.Marshal(content)
+	if err != nil {
+		return nil, err
+	}
+	canonical, err := canonicalJSON(contentJSON)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256(canonical)
+
+	return &BatchManifest{
+		BatchID:      content.BatchID,
+		Brand:        content.Brand,
+		Manufacturer: content.Manufacturer,
+		ProductType:  content.ProductType,
+		Quantity:     content.Quantity,
+		Products:     content.Products,
+		MaterialLots: content.MaterialLots,
+		ManifestHash: hex.EncodeToString(digest[:]),
+		RecordedHash: batch.ManifestHash,
+		GeneratedBy:  batch.ManifestGeneratedBy,
+		GeneratedAt:  batch.ManifestGeneratedAt,
+		Matches:      batch.ManifestHash == hex.EncodeToString(digest[:]),
+	}, nil
+}
+
+// GenerateBatchManifest builds the manifest of a batch and records its hash on the batch,
+// endorsed under the caller's identity. The holder of the batch or the brand calls it,
+// e.g. before shipping, and again after products or certificates change.
+func (s *SupplyChainContract) GenerateBatchManifest(ctx contractapi.TransactionContextInterface,
+	batchID string) (*BatchManifest, error) {
+
+	batch, err := s.GetBatch(ctx, batchID)
+	if err != nil {
+		return nil, err
+	}
+
+	caller, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get caller identity: %v", err)
+	}
+	if batch.CurrentOwner != caller {
+		if _, err := requireSuperAdmin(ctx); err != nil {
+			return nil, newError(ErrPermissionDenied, "only the holder of batch %s or the brand can generate its manifest", batchID)
+		}
+	}
+
+	manifest, err := buildBatchManifest(ctx, batch)
+	if err != nil {
+		return nil, err
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+	batch.ManifestHash = manifest.ManifestHash
+	batch.ManifestGeneratedBy = caller
+	batch.ManifestGeneratedAt = now.UTC().Format(time.RFC3339)
+	err = putBatch(ctx, batch)
+	if err != nil {
+		return nil, err
+	}
+	manifest.RecordedHash = batch.ManifestHash
+	manifest.GeneratedBy = batch.ManifestGeneratedBy
+	manifest.GeneratedAt = batch.ManifestGeneratedAt
+	manifest.Matches = true
+
+	return manifest, emitEvent(ctx, ChaincodeEvent{
+		EventType:  EventBatchManifestGenerated,
+		EntityType: EventEntityBatch,
+		EntityID:   batchID,
+		Attributes: map[string]interface{}{
+			"manifestHash": manifest.ManifestHash,
+			"products":     len(manifest.Products),
+		},
+	})
+}
+
+// GetBatchManifest rebuilds the manifest of a batch from the ledger and reports whether
+// it still matches the hash recorded by GenerateBatchManifest
+func (s *SupplyChainContract) GetBatchManifest(ctx contractapi.TransactionContextInterface,
+	batchID string) (*BatchManifest, error) {
+
+	batch, err := s.GetBatch(ctx, batchID)
+	if err != nil {
+		return nil, err
+	}
+	if batch.ManifestHash == "" {
+		return nil, newError(ErrNotFound, "no manifest was generated for batch %s", batchID)
+	}
+
+	return buildBatchManifest(ctx, batch)
+}
@@ -0,0 +1,268 @@
+package contracts
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// maxMergeSourceBatches caps the batches combined by one MergeBatches call
+const maxMergeSourceBatches = 20
+
+// batchTypeLogistics marks a batch created by MergeBatches in its metadata
+const batchTypeLogistics = "LOGISTICS"
+
+// MergeBatches consolidates the leftover products of sourceBatchIDsJSON, a JSON array
+// of batch IDs, into a new logistics batch that ships as one unit. The leftovers are
+// the products the caller still holds that can be shipped. Products keep their batch
+// ID, serial number and birth certificate, so verification by the original batch QR
+// code still works; the logistics batch only lists them. The source batches become
+// MERGED and record the batch they went into.
+func (s *SupplyChainContract) MergeBatches(ctx contractapi.TransactionContextInterface,
+	batchID string, sourceBatchIDsJSON string) error {
+
+	var sourceBatchIDs []string
+	if err := validateAll(
+		validateID("batchID", batchID),
+		validateJSON("sourceBatchIds", sourceBatchIDsJSON, &sourceBatchIDs),
+	); err != nil {
+		return err
+	}
+	if len(sourceBatchIDs) < 2 {
+		return newError(ErrInvalidArgument, "at least 2 source batches are required")
+	}
+	if len(sourceBatchIDs) > maxMergeSourceBatches {
+		return newError(ErrInvalidArgument, "at most %d batches can be merged at once", maxMergeSourceBatches)
+	}
+	for i, sourceID := range sourceBatchIDs {
+		if err := validateID("source batch id", sourceID); err != nil {
+			return err
+		}
+		if sourceID == batchID {
+			return newError(ErrInvalidArgument, "batch %s cannot be merged into itself", batchID)
+		}
+		if containsString(sourceBatchIDs[:i], sourceID) {
+			return newError(ErrInvalidArgument, "batch %s is listed twice", sourceID)
+		}
+	}
+
+	caller, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to get caller identity: %v", err)
+	}
+	roleContract := &RoleManagementContract{}
+	hasPermission, err := roleContract.CheckPermission(ctx, caller, "TRANSFER_BATCH")
+	if err != nil || !hasPermission {
+		return newError(ErrPermissionDenied, "caller %s does not have permission to merge batches", caller)
+	}
+
+	existing, err := ctx.GetStub().GetState("batch_" + batchID)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return newError(ErrAlreadyExists, "batch %s already exists", batchID)
+	}
+
+	// Goods promised to another organization cannot be repackaged
+	openTransfers, err := getIndexedTransfers(ctx, pendingTransferKeyPrefix+caller+"_")
+	if err != nil {
+		return err
+	}
+	inTransfer := make(map[string]string)
+	for _, transfer := range openTransfers {
+		if transfer.From == caller {
+			inTransfer[transfer.ProductID] = transfer.ID
+		}
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	timestamp := now.UTC().Format(time.RFC3339)
+
+	var sources []*ProductBatch
+	var productIDs []string
+	var productTypes []string
+	for _, sourceID := range sourceBatchIDs {
+		source, err := s.GetBatch(ctx, sourceID)
+		if err != nil {
+			return err
+		}
+		if source.CurrentOwner != caller {
+			return newError(ErrPermissionDenied, "caller %s does not hold batch %s", caller, sourceID)
+		}
+		if len(sources) > 0 && source.Brand != sources[0].Brand {
+			return newError(ErrInvalidArgument, "batches of brands %s and %s cannot be merged", sources[0].Brand, source.Brand)
+		}
+		if transferID, ok := inTransfer[sourceID]; ok {
+			return newError(ErrInvalidState, "batch %s is being shipped in transfer %s", sourceID, transferID)
+		}
+		// A batch awaiting brand approval cannot leave its manufacturer inside another one
+		if err := s.checkBrandApproval(ctx, sourceID, caller); err != nil {
+			return err
+		}
+
+		leftovers := 0
+		for _, productID := range source.ProductIDs {
+			product, err := s.GetProduct(ctx, productID)
+			if err != nil {
+				continue
+			}
+			if product.CurrentOwner != caller || !canTransition(product.Status, ProductStatusInTransit, productStatusTransitions) {
+				continue
+			}
+			if transferID, ok := inTransfer[productID]; ok {
+				return newError(ErrInvalidState, "product %s is being shipped in transfer %s", productID, transferID)
+			}
+			productIDs = append(productIDs, productID)
+			leftovers++
+		}
+		if leftovers == 0 {
+			return newError(ErrInvalidState, "batch %s has no products left to merge", sourceID)
+		}
+
+		if err := setBatchStatus(source, BatchStatusMerged); err != nil {
+			return err
+		}
+		if source.Metadata == nil {
+			source.Metadata = make(map[string]string)
+		}
+		source.Metadata["mergedInto"] = batchID
+		source.Metadata["mergedAt"] = timestamp
+		sources = append(sources, source)
+		if !containsString(productTypes, source.ProductType) {
+			productTypes = append(productTypes, source.ProductType)
+		}
+	}
+
+	merged := &ProductBatch{
+		SchemaVersion:   CurrentSchemaVersion,
+		ID:              batchID,
+		Manufacturer:    sources[0].Manufacturer,
+		Brand:           sources[0].Brand,
+		ProductType:     strings.Join(productTypes, ", "),
+		Quantity:        len(productIDs),
+		ProductIDs:      productIDs,
+		MaterialsUsed:   []MaterialUsage{},
+		ManufactureDate: sources[0].ManufactureDate,
+		QRCode:          fmt.Sprintf("QR-%s-%d", batchID, now.Unix()),
+		CurrentOwner:    caller,
+		CurrentLocation: sources[0].CurrentLocation,
+		Status:          BatchStatusCreated,
+		Metadata: map[string]string{
+			"batchType":     batchTypeLogistics,
+			"sourceBatches": strings.Join(sourceBatchIDs, ","),
+			"repackagedBy":  caller,
+			"repackagedAt":  timestamp,
+		},
+	}
+	for _, source := range sources {
+		if source.Manufacturer != merged.Manufacturer {
+			merged.Manufacturer = caller
+		}
+		if source.ManufactureDate > merged.ManufactureDate {
+			merged.ManufactureDate = source.ManufactureDate
+		}
+		merged.MaterialsUsed = addMaterialUsage(merged.MaterialsUsed, mergedMaterialUsage(source, productIDs))
+	}
+	// The sources were approved by the brand, so the merge result is too
+	for _, source := range sources {
+		if source.BrandApprovedAt == "" {
+			merged.BrandApprovedBy, merged.BrandApprovedAt = "", ""
+			break
+		}
+		if source.BrandApprovedAt > merged.BrandApprovedAt {
+			merged.BrandApprovedBy, merged.BrandApprovedAt = source.BrandApprovedBy, source.BrandApprovedAt
+		}
+	}
+
+	for _, source := range sources {
+		if err := putBatch(ctx, source); err != nil {
+			return err
+		}
+	}
+	if err := putBatch(ctx, merged); err != nil {
+		return err
+	}
+
+	return emitEvent(ctx, ChaincodeEvent{
+		EventType:  EventBatchesMerged,
+		EntityType: EventEntityBatch,
+		EntityID:   batchID,
+		ToState:    string(merged.Status),
+		Attributes: map[string]interface{}{
+			"sourceBatchIds": sourceBatchIDs,
+			"quantity":       merged.Quantity,
+		},
+	})
+}
+
+// mergedMaterialUsage returns the share of a source batch's materials that went into
+// the products among productIDs
+func mergedMaterialUsage(source *ProductBatch, productIDs []string) []MaterialUsage {
+	moved := 0
+	for _, productID := range source.ProductIDs {
+		if containsString(productIDs, productID) {
+			moved++
+		}
+	}
+	if moved == 0 || source.Quantity == 0 {
+		return nil
+	}
+
+	usage := make([]MaterialUsage, 0, len(source.MaterialsUsed))
+	for _, material := range source.MaterialsUsed {
+		material.QuantityUsed = material.QuantityUsed * float64(moved) / float64(source.Quantity)
+		usage = append(usage, material)
+	}
+	return usage
+}
+
+// addMaterialUsage adds usage to totals, combining the quantities of the same material lot
+func addMaterialUsage(totals []MaterialUsage, usage []MaterialUsage) []MaterialUsage {
+	for _, material := range usage {
+		found := false
+		for i := range totals {
+			if totals[i].MaterialID == material.MaterialID && totals[i].Supplier == material.Supplier &&
+				totals[i].Batch == material.Batch {
+				totals[i].QuantityUsed += material.QuantityUsed
+				found = true
+				break
+			}
+		}
+		if !found {
+			totals = append(totals, material)
+		}
+	}
+	return totals
+}
+
+// currentBatchOf returns the batch a product is shipped with now: its own batch, or the
+// batch its leftovers were merged into. It returns nil when the product stayed behind
+// in a merged batch, e.g. because it had been sold.
+func (s *SupplyChainContract) currentBatchOf(ctx contractapi.TransactionContextInterface,
+	product *Product) (*ProductBatch, error) {
+
+	batchID := product.BatchID
+	batch, err := s.GetBatch(ctx, batchID)
+	if err != nil {
+		return nil, err
+	}
+	for depth := 0; batch.Status == BatchStatusMerged; depth++ {
+		if depth == maxMergeSourceBatches {
+			return nil, newError(ErrInvalidState, "batch %s is merged too many times", batchID)
+		}
+		batch, err = s.GetBatch(ctx, batch.Metadata["mergedInto"])
+		if err != nil {
+			return nil, err
+		}
+		if !containsString(batch.ProductIDs, product.ID) {
+			return nil, nil
+		}
+	}
+	return batch, nil
+}
@@ -0,0 +1,121 @@
+package contracts
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Reasons a birth certificate can be revoked for
+const (
+	RevocationCounterfeit   = "COUNTERFEIT"     // The product is not genuine
+	RevocationGreyMarket    = "GREY_MARKET"     // Genuine but diverted outside authorized distribution
+	RevocationIssuedInError = "ISSUED_IN_ERROR" // e.g. a manufacturing mix-up certified the wrong item
+	RevocationDuplicate     = "DUPLICATE"       // Another certificate covers the same item
+)
+
+var revocationReasons = []string{
+	RevocationCounterfeit, RevocationGreyMarket, RevocationIssuedInError, RevocationDuplicate,
+}
+
+// CertificateRevocation records that a birth certificate no longer vouches for its product
+type CertificateRevocation struct {
+	ReasonCode string `json:"reasonCode"`
+	Note       string `json:"note,omitempty" metadata:",optional"`
+	RevokedBy  string `json:"revokedBy"` // MSP ID
+	RevokedAt  string `json:"revokedAt"`
+}
+
+// checkCertificateNotRevoked rejects selling or certifying a product whose birth
+// certificate was revoked
+func checkCertificateNotRevoked(product *Product) error {
+	if product.CertificateRevoked {
+		return newError(ErrInvalidState, "the birth certificate of product %s is revoked", product.ID)
+	}
+	return nil
+}
+
+// RevokeBirthCertificate revokes a birth certificate found to cover a counterfeit or
+// grey-market product, or issued in error. The revocation becomes a new certificate
+// revision, VerifyAuthenticity reports the product as revoked and it can no longer be
+// sold to a customer or certified pre-owned. The brand (super admin) or the
+// manufacturer that issued the certificate may revoke it; a revocation is final.
+func (o *OwnershipContract) RevokeBirthCertificate(ctx contractapi.TransactionContextInterface,
+	productID string, reasonCode string, note string) error {
+
+	if err := validateAll(
+		validateID("productID", productID),
+		validateEnum("reasonCode", reasonCode, revocationReasons...),
+		validateText("note", note, maxTextLength),
+	); err != nil {
+		return err
+	}
+
+	caller, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to get caller identity: %v", err)
+	}
+	certificate, err := o.GetBirthCertificate(ctx, productID)
+	if err != nil {
+		return err
+	}
+	supplyChain := &SupplyChainContract{}
+	product, err := supplyChain.GetProduct(ctx, productID)
+	if err != nil {
+		return err
+	}
+
+	// Certificates of batch products are issued by the batch's manufacturer
+	issuer := certificate.ManufacturingPlace
+	if product.BatchID != "" {
+		batch, err := supplyChain.GetBatch(ctx, product.BatchID)
+		if err != nil {
+			return err
+		}
+		issuer = batch.Manufacturer
+	}
+	if _, err := requireSuperAdmin(ctx); err != nil {
+		roleContract := &RoleManagementContract{}
+		hasPermission, err := roleContract.CheckPermission(ctx, caller, "CREATE_BIRTH_CERTIFICATE")
+		if err != nil || !hasPermission || caller != issuer {
+			return newError(ErrPermissionDenied, "only the brand or the issuing manufacturer can revoke the certificate of product %s", productID)
+		}
+	}
+	if certificate.Revocation != nil {
+		return newError(ErrInvalidState, "the birth certificate of product %s is already revoked", productID)
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	certificate.Revocation = &CertificateRevocation{
+		ReasonCode: reasonCode,
+		Note:       note,
+		RevokedBy:  caller,
+		RevokedAt:  now.UTC().Format(time.RFC3339),
+	}
+	if err := recommitCertificate(ctx, certificate, "revoked: "+reasonCode); err != nil {
+		return err
+	}
+
+	product.CertificateRevoked = true
+	product.CertifiedPreOwned = false // A CPO certification rests on the certificate
+	if err := putProduct(ctx, product); err != nil {
+		return err
+	}
+
+	return emitEvent(ctx, ChaincodeEvent{
+		EventType:  EventBirthCertificateRevoked,
+		EntityType: EventEntityProduct,
+		EntityID:   productID,
+		Attributes: map[string]interface{}{
+			"reasonCode":      reasonCode,
+			"revision":        certificate.Revision,
+			"certificateHash": certificate.CertificateHash,
+			"brand":           product.Brand,
+			"serialNumber":    product.SerialNumber,
+		},
+	})
+}
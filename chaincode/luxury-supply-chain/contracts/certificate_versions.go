@@ -0,0 +1,217 @@
+package contracts
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Superseded certificate revisions are stored as certificate_history_<productID>_<revision>,
+// the revision zero-padded so a product's revisions are one key range in order
+const certificateHistoryKeyPrefix = "certificate_history_"
+
+func certificateHistoryKey(productID string, revision int) string {
+	return fmt.Sprintf("%s%s_%06d", certificateHistoryKeyPrefix, productID, revision)
+}
+
+// certificateRevision returns a certificate's revision. Certificates issued before
+// versioning have none and count as revision 1.
+func certificateRevision(certificate *DigitalBirthCertificate) int {
+	if certificate.Revision < 1 {
+		return 1
+	}
+	return certificate.Revision
+}
+
+// CertificateAmendment lists the birth certificate fields AmendBirthCertificate corrects.
+// Fields left out keep their value.
+type CertificateAmendment struct {
+	ManufacturingDate  *string           `json:"manufacturingDate,omitempty"` // RFC3339
+	ManufacturingPlace *string           `json:"manufacturingPlace,omitempty"`
+	Craftsman          *string           `json:"craftsman,omitempty"`
+	Materials          *[]MaterialRecord `json:"materials,omitempty"`
+}
+
+// recommitCertificate stores a changed birth certificate as a new revision. The stored
+// revision is archived unchanged under its history key, and the new one links to its
+// hash and records who changed it, when and why. The disclosure root and certificate
+// hash are recomputed exactly as at creation, so they commit to the change.
+func recommitCertificate(ctx contractapi.TransactionContextInterface, certificate *DigitalBirthCertificate,
+	reason string) error {
+
+	certKey := "cert_" + certificate.ProductID
+	storedJSON, err := ctx.GetStub().GetState(certKey)
+	if err != nil {
+		return fmt.Errorf("failed to read birth certificate: %v", err)
+	}
+	if storedJSON != nil {
+		var stored DigitalBirthCertificate
+		if err := json.Unmarshal(storedJSON, &stored); err != nil {
+			return err
+		}
+		revision := certificateRevision(&stored)
+		err = ctx.GetStub().PutState(certificateHistoryKey(certificate.ProductID, revision), storedJSON)
+		if err != nil {
+			return fmt.Errorf("failed to archive certificate revision %d: %v", revision, err)
+		}
+		certificate.Revision = revision + 1
+		certificate.PreviousHash = stored.CertificateHash
+	}
+
+	caller, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to get caller identity: %v", err)
+	}
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	certificate.AmendedBy = caller
+	certificate.AmendedAt = now.UTC().Format(time.RFC3339)
+	certificate.AmendmentReason = reason
+
+	err = prepareCertificateDisclosure(ctx, certificate)
+	if err != nil {
+		return err
+	}
+	certificate.CertificateHash = ""
+	certData, _ := json.Marshal(certificate)
+	hash := sha256.Sum256(certData)
+	certificate.CertificateHash = hex.EncodeToString(hash[:])
+
+	certJSON, err := json.Marshal(certificate)
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().PutState(certKey, certJSON)
+}
+
+// AmendBirthCertificate corrects the manufacturing details or materials of a birth
+// certificate, e.g. a misattributed craftsman. amendmentJSON holds the corrected fields,
+// e.g. {"craftsman":"Atelier Milano"}. The certificate becomes a new revision linked to
+// the previous one, which stays readable with GetCertificateHistory. Only the brand
+// (super admin) may amend certificates.
+func (o *OwnershipContract) AmendBirthCertificate(ctx contractapi.TransactionContextInterface,
+	productID string, amendmentJSON string, reason string) error {
+
+	var amendment CertificateAmendment
+	if err := validateAll(
+		validateID("productID", productID),
+		validateJSON("amendmentJSON", amendmentJSON, &amendment),
+		validateRequired("reason", reason, maxTextLength),
+	); err != nil {
+		return err
+	}
+
+	if _, err := requireSuperAdmin(ctx); err != nil {
+		return err
+	}
+
+	certificate, err := o.GetBirthCertificate(ctx, productID)
+	if err != nil {
+		return err
+	}
+	if certificate.Revocation != nil {
+		return newError(ErrInvalidState, "the birth certificate of product %s is revoked", productID)
+	}
+
+	fields := []string{}
+	if amendment.ManufacturingDate != nil {
+		if _, err := time.Parse(time.RFC3339, *amendment.ManufacturingDate); err != nil {
+			return newError(ErrInvalidArgument, "manufacturingDate must be an RFC3339 time: %v", err)
+		}
+		certificate.ManufacturingDate = *amendment.ManufacturingDate
+		fields = append(fields, "manufacturingDate")
+	}
+	if amendment.ManufacturingPlace != nil {
+		if err := validateName("manufacturingPlace", *amendment.ManufacturingPlace); err != nil {
+			return err
+		}
+		certificate.ManufacturingPlace = *amendment.ManufacturingPlace
+		fields = append(fields, "manufacturingPlace")
+	}
+	if amendment.Craftsman != nil {
+		if err := validateName("craftsman", *amendment.Craftsman); err != nil {
+			return err
+		}
+		certificate.Craftsman = *amendment.Craftsman
+		fields = append(fields, "craftsman")
+	}
+	if amendment.Materials != nil {
+		for _, material := range *amendment.Materials {
+			if err := validateAll(
+				validateName("material type", material.Type),
+				validateText("material source", material.Source, maxNameLength),
+				validateText("material supplier", material.Supplier, maxNameLength),
+				validateText("material batch", material.Batch, maxNameLength),
+			); err != nil {
+				return err
+			}
+		}
+		certificate.Materials = *amendment.Materials
+		fields = append(fields, "materials")
+	}
+	if len(fields) == 0 {
+		return newError(ErrInvalidArgument, "amendmentJSON changes no certificate field")
+	}
+
+	previousRevision := certificateRevision(certificate)
+	if err := recommitCertificate(ctx, certificate, reason); err != nil {
+		return err
+	}
+
+	return emitEvent(ctx, ChaincodeEvent{
+		EventType:  EventBirthCertificateAmended,
+		EntityType: EventEntityProduct,
+		EntityID:   productID,
+		Attributes: map[string]interface{}{
+			"previousRevision": previousRevision,
+			"revision":         certificate.Revision,
+			"previousHash":     certificate.PreviousHash,
+			"certificateHash":  certificate.CertificateHash,
+			"fields":           strings.Join(fields, ","),
+			"reason":           reason,
+		},
+	})
+}
+
+// GetCertificateHistory returns every revision of a product's birth certificate, oldest
+// first and the current one last
+func (o *OwnershipContract) GetCertificateHistory(ctx contractapi.TransactionContextInterface,
+	productID string) ([]*DigitalBirthCertificate, error) {
+
+	current, err := o.GetBirthCertificate(ctx, productID)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := certificateHistoryKeyPrefix + productID + "_"
+	resultsIterator, err := ctx.GetStub().GetStateByRange(prefix, prefix+"~")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query certificate history: %v", err)
+	}
+	defer resultsIterator.Close()
+
+	revisions := []*DigitalBirthCertificate{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var revision DigitalBirthCertificate
+		err = json.Unmarshal(queryResponse.Value, &revision)
+		if err != nil {
+			return nil, err
+		}
+		revision.upgradeSchema()
+		revisions = append(revisions, &revision)
+	}
+
+	return append(revisions, current), nil
+}
@@ -0,0 +1,431 @@
+package contracts
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// NFC chip IDs are indexed as nfc_chip_<chipID>, replacement requests are stored as
+// chip_replacement_<newChipID>
+const (
+	nfcChipKeyPrefix         = "nfc_chip_"
+	chipReplacementKeyPrefix = "chip_replacement_"
+)
+
+// NFC chip statuses. A retired chip stays indexed so it is never accepted again.
+const (
+	NFCChipActive  = "ACTIVE"
+	NFCChipRetired = "RETIRED"
+)
+
+// Chip replacement statuses
+const (
+	ChipReplacementPending  = "PENDING"
+	ChipReplacementApproved = "APPROVED"
+	ChipReplacementRejected = "REJECTED"
+)
+
+// NFCChip maps a chip ID to the product it is, or was, embedded in
+type NFCChip struct {
+	ChipID        string `json:"chipId"`
+	ProductID     string `json:"productId"`
+	Status        string `json:"status"`
+	IndexedAt     string `json:"indexedAt"`
+	RetiredAt     string `json:"retiredAt,omitempty" metadata:",optional"`
+	ReplacedBy    string `json:"replacedBy,omitempty" metadata:",optional"` // Chip ID of the replacement
+	SchemaVersion int    `json:"schemaVersion"`
+}
+
+// ChipReplacement is a service center's request to replace a failed NFC chip, which
+// only takes effect once the brand approves it
+type ChipReplacement struct {
+	ProductID     string `json:"productId"`
+	OldChipID     string `json:"oldChipId"`
+	NewChipID     string `json:"newChipId"`
+	ServiceCenter string `json:"serviceCenter"`
+	Justification string `json:"justification"`
+	RequestedBy   string `json:"requestedBy"`
+	RequestedAt   string `json:"requestedAt"`
+	Status        string `json:"status"`
+	DecidedBy     string `json:"decidedBy,omitempty" metadata:",optional"`
+	DecidedAt     string `json:"decidedAt,omitempty" metadata:",optional"`
+	DecisionNote  string `json:"decisionNote,omitempty" metadata:",optional"`
+	SchemaVersion int    `json:"schemaVersion"`
+}
+
+// getNFCChip reads the index entry of a chip, or nil if the chip is not indexed
+func getNFCChip(ctx contractapi.TransactionContextInterface, chipID string) (*NFCChip, error) {
+	chipJSON, err := ctx.GetStub().GetState(nfcChipKeyPrefix + chipID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read NFC chip index: %v", err)
+	}
+	if chipJSON == nil {
+		return nil, nil
+	}
+
+	var chip NFCChip
+	err = json.Unmarshal(chipJSON, &chip)
+	if err != nil {
+		return nil, err
+	}
+	chip.upgradeSchema()
+
+	return &chip, nil
+}
+
+// putNFCChip stores the index entry of a chip
+func putNFCChip(ctx contractapi.TransactionContextInterface, chip *NFCChip) error {
+	chipJSON, err := json.Marshal(chip)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState(nfcChipKeyPrefix+chip.ChipID, chipJSON)
+	if err != nil {
+		return fmt.Errorf("failed to store NFC chip index: %v", err)
+	}
+	return nil
+}
+
+// indexNFCChip records the chip of a new birth certificate. A chip ID already used
+// by a product, including a retired one, is rejected.
+func indexNFCChip(ctx contractapi.TransactionContextInterface, chipID string, productID string) error {
+	if chipID == "" {
+		return nil
+	}
+
+	existing, err := getNFCChip(ctx, chipID)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return newError(ErrAlreadyExists, "NFC chip %s is already registered to product %s", chipID, existing.ProductID)
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	return putNFCChip(ctx, &NFCChip{
+		ChipID:        chipID,
+		ProductID:     productID,
+		Status:        NFCChipActive,
+		IndexedAt:     now.UTC().Format(time.RFC3339),
+		SchemaVersion: CurrentSchemaVersion,
+	})
+}
+
+// getChipReplacement reads a replacement request by the new chip's ID
+func getChipReplacement(ctx contractapi.TransactionContextInterface, newChipID string) (*ChipReplacement, error) {
+	replacementJSON, err := ctx.GetStub().GetState(chipReplacementKeyPrefix + newChipID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read chip replacement: %v", err)
+	}
+	if replacementJSON == nil {
+		return nil, newError(ErrNotFound, "no replacement by chip %s was requested", newChipID)
+	}
+
+	var replacement ChipReplacement
+	err = json.Unmarshal(replacementJSON, &replacement)
+	if err != nil {
+		return nil, err
+	}
+	replacement.upgradeSchema()
+
+	return &replacement, nil
+}
+
+// putChipReplacement stores a replacement request
+func putChipReplacement(ctx contractapi.TransactionContextInterface, replacement *ChipReplacement) error {
+	replacementJSON, err := json.Marshal(replacement)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState(chipReplacementKeyPrefix+replacement.NewChipID, replacementJSON)
+	if err != nil {
+		return fmt.Errorf("failed to store chip replacement: %v", err)
+	}
+	return nil
+}
+
+// ReplaceNFCChip asks the brand to replace the failed NFC chip of a product. The caller
+// needs the ADD_SERVICE_RECORD permission. The certificate keeps the old chip until
+// ApproveNFCChipReplacement.
+func (o *OwnershipContract) ReplaceNFCChip(ctx contractapi.TransactionContextInterface,
+	productID string, oldChipID string, newChipID string, serviceCenter string, justification string) error {
+
+	if err := validateAll(
+		validateID("productID", productID),
+		validateRequired("oldChipID", oldChipID, maxNameLength),
+		validateID("newChipID", newChipID),
+		validateName("serviceCenter", serviceCenter),
+		validateRequired("justification", justification, maxTextLength),
+	); err != nil {
+		return err
+	}
+
+	caller, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to get caller identity: %v", err)
+	}
+	roleContract := &RoleManagementContract{}
+	hasPermission, err := roleContract.CheckPermission(ctx, caller, "ADD_SERVICE_RECORD")
+	if err != nil || !hasPermission {
+		return newError(ErrPermissionDenied, "caller %s does not have permission to replace NFC chips", caller)
+	}
+
+	supplyChain := &SupplyChainContract{}
+	product, err := supplyChain.GetProduct(ctx, productID)
+	if err != nil {
+		return err
+	}
+	if product.Status == ProductStatusDestroyed || product.Status == ProductStatusWrittenOff {
+		return newError(ErrInvalidState, "product %s is %s", productID, product.Status)
+	}
+
+	certificate, err := o.GetBirthCertificate(ctx, productID)
+	if err != nil {
+		return err
+	}
+	if certificate.Authenticity.NFCChipID != oldChipID {
+		return newError(ErrInvalidArgument, "chip %s is not the current chip of product %s", oldChipID, productID)
+	}
+
+	// A chip ID can only ever be used once
+	existing, err := getNFCChip(ctx, newChipID)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return newError(ErrAlreadyExists, "NFC chip %s is already registered to product %s", newChipID, existing.ProductID)
+	}
+	requested, err := ctx.GetStub().GetState(chipReplacementKeyPrefix + newChipID)
+	if err != nil {
+		return err
+	}
+	if requested != nil {
+		return newError(ErrAlreadyExists, "a replacement by chip %s was already requested", newChipID)
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	replacement := &ChipReplacement{
+		ProductID:     productID,
+		OldChipID:     oldChipID,
+		NewChipID:     newChipID,
+		ServiceCenter: serviceCenter,
+		Justification: justification,
+		RequestedBy:   caller,
+		RequestedAt:   now.UTC().Format(time.RFC3339),
+		Status:        ChipReplacementPending,
+		SchemaVersion: CurrentSchemaVersion,
+	}
+	err = putChipReplacement(ctx, replacement)
+	if err != nil {
+		return err
+	}
+
+	return emitEvent(ctx, ChaincodeEvent{
+		EventType:  EventNFCChipReplacementRequested,
+		EntityType: EventEntityProduct,
+		EntityID:   productID,
+		Attributes: map[string]interface{}{
+			"oldChipId":     oldChipID,
+			"newChipId":     newChipID,
+			"serviceCenter": serviceCenter,
+		},
+	})
+}
+
+// decideChipReplacement checks that the caller is the brand and the request is pending
+func decideChipReplacement(ctx contractapi.TransactionContextInterface,
+	newChipID string) (*ChipReplacement, string, error) {
+
+	caller, err := requireSuperAdmin(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+
+	replacement, err := getChipReplacement(ctx, newChipID)
+	if err != nil {
+		return nil, "", err
+	}
+	if replacement.Status != ChipReplacementPending {
+		return nil, "", newError(ErrInvalidState, "the replacement by chip %s is already %s", newChipID, replacement.Status)
+	}
+
+	return replacement, caller, nil
+}
+
+// ApproveNFCChipReplacement puts the new chip on the product's birth certificate and
+// retires the old one for good. The old chip's public keys are removed; the brand
+// registers the new chip's key with RegisterChipPublicKey.
+func (o *OwnershipContract) ApproveNFCChipReplacement(ctx contractapi.TransactionContextInterface,
+	newChipID string) error {
+
+	if err := validateID("newChipID", newChipID); err != nil {
+		return err
+	}
+
+	replacement, caller, err := decideChipReplacement(ctx, newChipID)
+	if err != nil {
+		return err
+	}
+
+	certificate, err := o.GetBirthCertificate(ctx, replacement.ProductID)
+	if err != nil {
+		return err
+	}
+	if certificate.Authenticity.NFCChipID != replacement.OldChipID {
+		return newError(ErrInvalidState, "chip %s is no longer the current chip of product %s",
+			replacement.OldChipID, replacement.ProductID)
+	}
+	existing, err := getNFCChip(ctx, newChipID)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return newError(ErrAlreadyExists, "NFC chip %s is already registered to product %s", newChipID, existing.ProductID)
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	timestamp := now.UTC().Format(time.RFC3339)
+
+	// Chips issued before the index existed are indexed as they are retired
+	oldChip, err := getNFCChip(ctx, replacement.OldChipID)
+	if err != nil {
+		return err
+	}
+	if oldChip == nil {
+		oldChip = &NFCChip{
+			ChipID:        replacement.OldChipID,
+			ProductID:     replacement.ProductID,
+			IndexedAt:     timestamp,
+			SchemaVersion: CurrentSchemaVersion,
+		}
+	}
+	oldChip.Status = NFCChipRetired
+	oldChip.RetiredAt = timestamp
+	oldChip.ReplacedBy = newChipID
+	err = putNFCChip(ctx, oldChip)
+	if err != nil {
+		return err
+	}
+	err = putNFCChip(ctx, &NFCChip{
+		ChipID:        newChipID,
+		ProductID:     replacement.ProductID,
+		Status:        NFCChipActive,
+		IndexedAt:     timestamp,
+		SchemaVersion: CurrentSchemaVersion,
+	})
+	if err != nil {
+		return err
+	}
+
+	authenticity := &certificate.Authenticity
+	authenticity.RetiredNFCChipIDs = append(authenticity.RetiredNFCChipIDs, replacement.OldChipID)
+	authenticity.NFCChipID = newChipID
+	authenticity.NFCChipPublicKeys = nil
+	err = recommitCertificate(ctx, certificate, "NFC chip replaced")
+	if err != nil {
+		return err
+	}
+
+	replacement.Status = ChipReplacementApproved
+	replacement.DecidedBy = caller
+	replacement.DecidedAt = timestamp
+	err = putChipReplacement(ctx, replacement)
+	if err != nil {
+		return err
+	}
+
+	return emitEvent(ctx, ChaincodeEvent{
+		EventType:  EventNFCChipReplaced,
+		EntityType: EventEntityProduct,
+		EntityID:   replacement.ProductID,
+		Attributes: map[string]interface{}{
+			"oldChipId":       replacement.OldChipID,
+			"newChipId":       newChipID,
+			"serviceCenter":   replacement.ServiceCenter,
+			"certificateHash": certificate.CertificateHash,
+		},
+	})
+}
+
+// RejectNFCChipReplacement refuses a chip replacement; the new chip ID cannot be requested again
+func (o *OwnershipContract) RejectNFCChipReplacement(ctx contractapi.TransactionContextInterface,
+	newChipID string, note string) error {
+
+	if err := validateAll(
+		validateID("newChipID", newChipID),
+		validateRequired("note", note, maxTextLength),
+	); err != nil {
+		return err
+	}
+
+	replacement, caller, err := decideChipReplacement(ctx, newChipID)
+	if err != nil {
+		return err
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	replacement.Status = ChipReplacementRejected
+	replacement.DecidedBy = caller
+	replacement.DecidedAt = now.UTC().Format(time.RFC3339)
+	replacement.DecisionNote = note
+	err = putChipReplacement(ctx, replacement)
+	if err != nil {
+		return err
+	}
+
+	return emitEvent(ctx, ChaincodeEvent{
+		EventType:  EventNFCChipReplacementRejected,
+		EntityType: EventEntityProduct,
+		EntityID:   replacement.ProductID,
+		Attributes: map[string]interface{}{
+			"oldChipId": replacement.OldChipID,
+			"newChipId": newChipID,
+		},
+	})
+}
+
+// GetChipReplacement returns a chip replacement request by the new chip's ID
+func (o *OwnershipContract) GetChipReplacement(ctx contractapi.TransactionContextInterface,
+	newChipID string) (*ChipReplacement, error) {
+
+	if err := validateID("newChipID", newChipID); err != nil {
+		return nil, err
+	}
+
+	return getChipReplacement(ctx, newChipID)
+}
+
+// GetNFCChip returns the product a scanned chip ID belongs to. A RETIRED chip was
+// replaced and must not be accepted as proof of authenticity.
+func (o *OwnershipContract) GetNFCChip(ctx contractapi.TransactionContextInterface,
+	chipID string) (*NFCChip, error) {
+
+	if err := validateRequired("chipID", chipID, maxNameLength); err != nil {
+		return nil, err
+	}
+
+	chip, err := getNFCChip(ctx, chipID)
+	if err != nil {
+		return nil, err
+	}
+	if chip == nil {
+		return nil, newError(ErrNotFound, "NFC chip %s is not registered", chipID)
+	}
+
+	return chip, nil
+}
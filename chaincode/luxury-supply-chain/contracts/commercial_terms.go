@@ -0,0 +1,432 @@
+package contracts
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Commercial terms of a transfer are kept in a private data collection of the two
+// organizations, see collections_config.json. The public transfer records only carry
+// the SHA256 of the private value.
+const (
+	commercialCollectionPrefix  = "commercial_"
+	commercialTermsKeyPrefix    = "commercial_terms_"
+	commercialTermsTransientKey = "commercialTerms"
+	minCommercialSaltLength     = 16
+)
+
+// CommercialTerms are the prices agreed for a product, batch or material transfer
+type CommercialTerms struct {
+	TransferID     string  `json:"transferId"`
+	MaterialID     string  `json:"materialId,omitempty" metadata:",optional"` // Set for material transfers
+	From           string  `json:"from"`
+	To             string  `json:"to"`
+	UnitCost       float64 `json:"unitCost"`                                     // Sender's cost per unit
+	WholesalePrice float64 `json:"wholesalePrice"`                               // Price per unit charged to the receiver
+	Currency       string  `json:"currency"`                                     // One of the allowed currencies, see CurrencyConfig
+	PaymentTerms   string  `json:"paymentTerms,omitempty" metadata:",optional"`  // e.g. NET30
+	ContractTerms  string  `json:"contractTerms,omitempty" metadata:",optional"` // e.g. the supply agreement and its clauses
+	Salt           string  `json:"salt"`                                         // Chosen by the sender so the hash cannot be guessed
+	RecordedAt     string  `json:"recordedAt"`
+	SchemaVersion  int     `json:"schemaVersion"`
+}
+
+// commercialCollection returns the private data collection shared by two organizations
+func commercialCollection(orgA string, orgB string) string {
+	orgs := []string{orgA, orgB}
+	sort.Strings(orgs)
+	return commercialCollectionPrefix + orgs[0] + "_" + orgs[1]
+}
+
+// findMaterialTransfer returns the inventory of material held by org and the index of
+// the transfer's record in it
+func findMaterialTransfer(ctx contractapi.TransactionContextInterface,
+	materialID string, org string, transferID string) (*MaterialInventory, int, error) {
+
+	inventoryKey := fmt.Sprintf("material_inventory_%s_%s", materialID, org)
+	inventoryJSON, err := ctx.GetStub().GetState(inventoryKey)
+	if err != nil {
+		return nil, 0, err
+	}
+	if inventoryJSON == nil {
+		return nil, 0, newError(ErrNotFound, "material %s not found in %s's inventory", materialID, org)
+	}
+
+	var inventory MaterialInventory
+	err = json.Unmarshal(inventoryJSON, &inventory)
+	if err != nil {
+		return nil, 0, err
+	}
+	for i, transfer := range inventory.Transfers {
+		if transfer.TransferID == transferID {
+			return &inventory, i, nil
+		}
+	}
+	return nil, 0, newError(ErrNotFound, "material transfer %s not found for %s", transferID, org)
+}
+
+// readCommercialTerms reads and validates the terms passed in the transient field "commercialTerms"
+func readCommercialTerms(ctx contractapi.TransactionContextInterface) (*CommercialTerms, error) {
+	transientMap, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read transient data: %v", err)
+	}
+	termsInput, ok := transientMap[commercialTermsTransientKey]
+	if !ok {
+		return nil, newError(ErrInvalidArgument, "transient field %s is required", commercialTermsTransientKey)
+	}
+	var terms CommercialTerms
+	if err := validateJSON("commercialTerms", string(termsInput), &terms); err != nil {
+		return nil, err
+	}
+	if err := validateAll(
+		validateQuantity("unitCost", terms.UnitCost),
+		validateQuantity("wholesalePrice", terms.WholesalePrice),
+		validateText("paymentTerms", terms.PaymentTerms, maxNameLength),
+		validateText("contractTerms", terms.ContractTerms, maxTextLength),
+		validateRequired("salt", terms.Salt, maxNameLength),
+	); err != nil {
+		return nil, err
+	}
+	currencies, err := getCurrencyConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := currencies.checkCurrency("currency", terms.Currency); err != nil {
+		return nil, err
+	}
+	if len(terms.Salt) < minCommercialSaltLength {
+		return nil, newError(ErrInvalidArgument, "salt must have at least %d characters", minCommercialSaltLength)
+	}
+	return &terms, nil
+}
+
+// SetMaterialTransferTerms stores the prices of a material transfer privately for the
+// sender and receiver. The terms are passed in the transient field "commercialTerms"
+// and can be replaced until the receiver has confirmed the transfer.
+func (s *SupplyChainContract) SetMaterialTransferTerms(ctx contractapi.TransactionContextInterface,
+	transferID string, materialID string) error {
+
+	if err := validateAll(
+		validateID("transferID", transferID),
+		validateID("materialID", materialID),
+	); err != nil {
+		return err
+	}
+
+	terms, err := readCommercialTerms(ctx)
+	if err != nil {
+		return err
+	}
+
+	sender, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to get caller identity: %v", err)
+	}
+
+	// Only the sender prices its transfer, and only before the receipt is confirmed
+	senderInventory, senderIndex, err := findMaterialTransfer(ctx, materialID, sender, transferID)
+	if err != nil {
+		return err
+	}
+	record := senderInventory.Transfers[senderIndex]
+	if record.From != sender {
+		return newError(ErrPermissionDenied, "only the sender %s can set the terms of transfer %s", record.From, transferID)
+	}
+	if record.Verified {
+		return newError(ErrInvalidState, "material transfer %s is already confirmed", transferID)
+	}
+	receiverInventory, receiverIndex, err := findMaterialTransfer(ctx, materialID, record.To, transferID)
+	if err != nil {
+		return err
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	terms.TransferID = transferID
+	terms.MaterialID = materialID
+	terms.From = sender
+	terms.To = record.To
+	terms.RecordedAt = now.UTC().Format(time.RFC3339)
+	terms.SchemaVersion = CurrentSchemaVersion
+	termsJSON, err := json.Marshal(terms)
+	if err != nil {
+		return err
+	}
+
+	collection := commercialCollection(sender, record.To)
+	err = ctx.GetStub().PutPrivateData(collection, commercialTermsKeyPrefix+materialID+"_"+transferID, termsJSON)
+	if err != nil {
+		return fmt.Errorf("failed to store commercial terms: %v", err)
+	}
+
+	// Commit to the terms on both public transfer records
+	digest := sha256.Sum256(termsJSON)
+	commitment := hex.EncodeToString(digest[:])
+	senderInventory.Transfers[senderIndex].CommercialTermsHash = commitment
+	err = putMaterialInventory(ctx, fmt.Sprintf("material_inventory_%s_%s", materialID, sender), senderInventory)
+	if err != nil {
+		return err
+	}
+	receiverInventory.Transfers[receiverIndex].CommercialTermsHash = commitment
+	err = putMaterialInventory(ctx, fmt.Sprintf("material_inventory_%s_%s", materialID, record.To), receiverInventory)
+	if err != nil {
+		return err
+	}
+
+	return emitEvent(ctx, ChaincodeEvent{
+		EventType:  EventMaterialTransferTermsSet,
+		EntityType: EventEntityMaterial,
+		EntityID:   materialID,
+		Attributes: map[string]interface{}{
+			"transferId":          transferID,
+			"from":                sender,
+			"to":                  record.To,
+			"commercialTermsHash": commitment,
+		},
+	})
+}
+
+// GetMaterialTransferTerms returns the prices of a material transfer to its sender or receiver
+func (s *SupplyChainContract) GetMaterialTransferTerms(ctx contractapi.TransactionContextInterface,
+	transferID string, materialID string) (*CommercialTerms, error) {
+
+	if err := validateAll(
+		validateID("transferID", transferID),
+		validateID("materialID", materialID),
+	); err != nil {
+		return nil, err
+	}
+
+	caller, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get caller identity: %v", err)
+	}
+
+	// Only the two parties have the transfer in their inventories
+	inventory, index, err := findMaterialTransfer(ctx, materialID, caller, transferID)
+	if err != nil {
+		return nil, err
+	}
+	record := inventory.Transfers[index]
+	if record.From != caller && record.To != caller {
+		return nil, newError(ErrPermissionDenied, "caller %s is not a party to material transfer %s", caller, transferID)
+	}
+
+	termsJSON, err := ctx.GetStub().GetPrivateData(commercialCollection(record.From, record.To),
+		commercialTermsKeyPrefix+materialID+"_"+transferID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read commercial terms: %v", err)
+	}
+	if termsJSON == nil {
+		return nil, newError(ErrNotFound, "no commercial terms recorded for material transfer %s", transferID)
+	}
+
+	var terms CommercialTerms
+	err = json.Unmarshal(termsJSON, &terms)
+	if err != nil {
+		return nil, err
+	}
+	terms.upgradeSchema()
+
+	return &terms, nil
+}
+
+// VerifyMaterialTransferTerms checks that the private terms of a material transfer match
+// the hash committed on the sender's public transfer record. Any organization can call
+// it, since peers outside the collection keep the hashes of its private data.
+func (s *SupplyChainContract) VerifyMaterialTransferTerms(ctx contractapi.TransactionContextInterface,
+	transferID string, materialID string, fromOrganization string) (bool, error) {
+
+	if err := validateAll(
+		validateID("transferID", transferID),
+		validateID("materialID", materialID),
+		validateID("fromOrganization", fromOrganization),
+	); err != nil {
+		return false, err
+	}
+
+	inventory, index, err := findMaterialTransfer(ctx, materialID, fromOrganization, transferID)
+	if err != nil {
+		return false, err
+	}
+	record := inventory.Transfers[index]
+	if record.From != fromOrganization {
+		return false, newError(ErrInvalidArgument, "%s is not the sender of material transfer %s", fromOrganization, transferID)
+	}
+	if record.CommercialTermsHash == "" {
+		return false, newError(ErrNotFound, "no commercial terms recorded for material transfer %s", transferID)
+	}
+	commitment, err := hex.DecodeString(record.CommercialTermsHash)
+	if err != nil {
+		return false, fmt.Errorf("invalid commercial terms hash: %v", err)
+	}
+
+	privateHash, err := ctx.GetStub().GetPrivateDataHash(commercialCollection(record.From, record.To),
+		commercialTermsKeyPrefix+materialID+"_"+transferID)
+	if err != nil {
+		return false, fmt.Errorf("failed to read commercial terms hash: %v", err)
+	}
+
+	return bytes.Equal(privateHash, commitment), nil
+}
+
+// transferTermsKey returns the private data key of a product or batch transfer's terms.
+// IDs cannot contain '_', so it cannot collide with the key of a material transfer.
+func transferTermsKey(transferID string) string {
+	return commercialTermsKeyPrefix + transferID
+}
+
+// StoreTransferPrivateDetails stores the commercial terms of a transfer, e.g. the
+// purchase price and contract terms, privately for the sender and receiver and anchors
+// their hash on the public transfer. The terms are passed in the transient field
+// "commercialTerms" and can be replaced until the receiver has confirmed the transfer.
+// A material transfer ID stores the terms as SetMaterialTransferTerms does.
+func (s *SupplyChainContract) StoreTransferPrivateDetails(ctx contractapi.TransactionContextInterface,
+	transferID string) error {
+
+	if err := validateID("transferID", transferID); err != nil {
+		return err
+	}
+
+	sender, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to get caller identity: %v", err)
+	}
+	transfer, err := s.GetTransfer(ctx, transferID)
+	if hasErrorCode(err, ErrNotFound) {
+		materialID, err := materialOfTransfer(ctx, sender, transferID)
+		if err != nil {
+			return err
+		}
+		return s.SetMaterialTransferTerms(ctx, transferID, materialID)
+	}
+	if err != nil {
+		return err
+	}
+
+	terms, err := readCommercialTerms(ctx)
+	if err != nil {
+		return err
+	}
+	if transfer.From != sender {
+		return newError(ErrPermissionDenied, "only the sender %s can set the terms of transfer %s", transfer.From, transferID)
+	}
+	if transfer.ConsensusDetails.ReceiverConfirmed || !isOpenTransfer(transfer) {
+		return newError(ErrInvalidState, "transfer %s is already %s", transferID, transfer.Status)
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	terms.TransferID = transferID
+	terms.From = sender
+	terms.To = transfer.To
+	terms.RecordedAt = now.UTC().Format(time.RFC3339)
+	terms.SchemaVersion = CurrentSchemaVersion
+	termsJSON, err := json.Marshal(terms)
+	if err != nil {
+		return err
+	}
+
+	err = ctx.GetStub().PutPrivateData(commercialCollection(sender, transfer.To), transferTermsKey(transferID), termsJSON)
+	if err != nil {
+		return fmt.Errorf("failed to store commercial terms: %v", err)
+	}
+
+	digest := sha256.Sum256(termsJSON)
+	transfer.CommercialTermsHash = hex.EncodeToString(digest[:])
+	err = putTransfer(ctx, transfer)
+	if err != nil {
+		return err
+	}
+
+	event := transferEvent(EventTransferTermsSet, transfer, "")
+	event.Attributes["commercialTermsHash"] = transfer.CommercialTermsHash
+	return emitEvent(ctx, event)
+}
+
+// GetTransferPrivateDetails returns the commercial terms of a product, batch or material
+// transfer to its sender or receiver
+func (s *SupplyChainContract) GetTransferPrivateDetails(ctx contractapi.TransactionContextInterface,
+	transferID string) (*CommercialTerms, error) {
+
+	if err := validateID("transferID", transferID); err != nil {
+		return nil, err
+	}
+
+	caller, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get caller identity: %v", err)
+	}
+	transfer, err := s.GetTransfer(ctx, transferID)
+	if hasErrorCode(err, ErrNotFound) {
+		materialID, err := materialOfTransfer(ctx, caller, transferID)
+		if err != nil {
+			return nil, err
+		}
+		return s.GetMaterialTransferTerms(ctx, transferID, materialID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if transfer.From != caller && transfer.To != caller {
+		return nil, newError(ErrPermissionDenied, "caller %s is not a party to transfer %s", caller, transferID)
+	}
+
+	termsJSON, err := ctx.GetStub().GetPrivateData(commercialCollection(transfer.From, transfer.To), transferTermsKey(transferID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read commercial terms: %v", err)
+	}
+	if termsJSON == nil {
+		return nil, newError(ErrNotFound, "no commercial terms recorded for transfer %s", transferID)
+	}
+
+	var terms CommercialTerms
+	err = json.Unmarshal(termsJSON, &terms)
+	if err != nil {
+		return nil, err
+	}
+	terms.upgradeSchema()
+
+	return &terms, nil
+}
+
+// VerifyTransferPrivateDetails checks that the private terms of a product or batch
+// transfer match the hash anchored on it. Any organization can call it.
+func (s *SupplyChainContract) VerifyTransferPrivateDetails(ctx contractapi.TransactionContextInterface,
+	transferID string) (bool, error) {
+
+	if err := validateID("transferID", transferID); err != nil {
+		return false, err
+	}
+
+	transfer, err := s.GetTransfer(ctx, transferID)
+	if err != nil {
+		return false, err
+	}
+	if transfer.CommercialTermsHash == "" {
+		return false, newError(ErrNotFound, "no commercial terms recorded for transfer %s", transferID)
+	}
+	commitment, err := hex.DecodeString(transfer.CommercialTermsHash)
+	if err != nil {
+		return false, fmt.Errorf("invalid commercial terms hash: %v", err)
+	}
+
+	privateHash, err := ctx.GetStub().GetPrivateDataHash(commercialCollection(transfer.From, transfer.To), transferTermsKey(transferID))
+	if err != nil {
+		return false, fmt.Errorf("failed to read commercial terms hash: %v", err)
+	}
+
+	return bytes.Equal(privateHash, commitment), nil
+}
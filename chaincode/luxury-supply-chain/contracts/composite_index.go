@@ -0,0 +1,243 @@
+package contracts
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Composite key indexes of one object type by an attribute, built with CreateCompositeKey.
+// Records keep their prefixed keys, which every lookup by ID uses; an index lists the
+// IDs of the records with a given attribute value, so a query reads only the matching
+// entries of its object type, on LevelDB as well as CouchDB.
+const (
+	productBrandIndex   = "product~brand"   // brand, productID
+	transferStatusIndex = "transfer~status" // status, transferID
+	transferItemIndex   = "transfer~item"   // productID or batchID, transferID
+	inventoryOwnerIndex = "inventory~owner" // owner, materialID
+	ownershipOwnerIndex = "ownership~owner" // ownerHash, productID
+)
+
+// CurrentIndexVersion is bumped whenever an index is added or changes its attributes,
+// so MigrateNamespace indexes the existing records again
+const CurrentIndexVersion = 2
+
+// productIndexKeys returns the composite index keys of a product
+func productIndexKeys(stub shim.ChaincodeStubInterface, product *Product) ([]string, error) {
+	if product.Brand == "" {
+		return nil, nil
+	}
+	key, err := stub.CreateCompositeKey(productBrandIndex, []string{product.Brand, product.ID})
+	if err != nil {
+		return nil, err
+	}
+	return []string{key}, nil
+}
+
+// transferIndexKeys returns the composite index keys of a transfer
+func transferIndexKeys(stub shim.ChaincodeStubInterface, transfer *Transfer) ([]string, error) {
+	statusKey, err := stub.CreateCompositeKey(transferStatusIndex, []string{string(transfer.Status), transfer.ID})
+	if err != nil {
+		return nil, err
+	}
+	itemKey, err := stub.CreateCompositeKey(transferItemIndex, []string{transfer.ProductID, transfer.ID})
+	if err != nil {
+		return nil, err
+	}
+	return []string{statusKey, itemKey}, nil
+}
+
+// inventoryIndexKeys returns the composite index keys of a material inventory
+func inventoryIndexKeys(stub shim.ChaincodeStubInterface, inventory *MaterialInventory) ([]string, error) {
+	key, err := stub.CreateCompositeKey(inventoryOwnerIndex, []string{inventory.Owner, inventory.MaterialID})
+	if err != nil {
+		return nil, err
+	}
+	return []string{key}, nil
+}
+
+// ownershipIndexKeys returns the composite index keys of an ownership record
+func ownershipIndexKeys(stub shim.ChaincodeStubInterface, ownership *Ownership) ([]string, error) {
+	if ownership.OwnerHash == "" {
+		return nil, nil
+	}
+	key, err := stub.CreateCompositeKey(ownershipOwnerIndex, []string{ownership.OwnerHash, ownership.ProductID})
+	if err != nil {
+		return nil, err
+	}
+	return []string{key}, nil
+}
+
+// trackCompositeIndex moves the index entries of the record stored under key from its
+// previous attribute values to those of record, or removes them if record is nil.
+// Like the dashboard counters, the previous values are those of the record's last
+// write in this transaction, since a transaction does not read its own writes.
+func trackCompositeIndex[T any](ctx contractapi.TransactionContextInterface, key string,
+	record *T, indexKeys func(shim.ChaincodeStubInterface, *T) ([]string, error)) error {
+
+	stub := ctx.GetStub()
+	var current []string
+	if record != nil {
+		var err error
+		current, err = indexKeys(stub, record)
+		if err != nil {
+			return fmt.Errorf("failed to build index keys of %s: %v", key, err)
+		}
+	}
+
+	tc, buffered := ctx.(*TransactionContext)
+	previous, written := []string(nil), false
+	if buffered {
+		previous, written = tc.indexEntries[key]
+	}
+	if !written {
+		stored, err := stub.GetState(key)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %v", key, err)
+		}
+		if stored != nil {
+			var old T
+			if err := json.Unmarshal(stored, &old); err == nil {
+				previous, _ = indexKeys(stub, &old)
+			}
+		}
+	}
+	if buffered {
+		if tc.indexEntries == nil {
+			tc.indexEntries = make(map[string][]string)
+		}
+		tc.indexEntries[key] = current
+	}
+
+	for _, indexKey := range previous {
+		if containsString(current, indexKey) {
+			continue
+		}
+		if err := stub.DelState(indexKey); err != nil {
+			return fmt.Errorf("failed to update index: %v", err)
+		}
+	}
+	var added []string
+	for _, indexKey := range current {
+		if !containsString(previous, indexKey) {
+			added = append(added, indexKey)
+		}
+	}
+	return writeIndexEntries(stub, added)
+}
+
+// writeIndexEntries stores composite index entries. Empty values are deletes in
+// Fabric, so an entry holds the ID of its record, the last attribute of its key.
+func writeIndexEntries(stub shim.ChaincodeStubInterface, indexKeys []string) error {
+	for _, indexKey := range indexKeys {
+		_, attributes, err := stub.SplitCompositeKey(indexKey)
+		if err != nil || len(attributes) == 0 {
+			return fmt.Errorf("invalid index key %q", indexKey)
+		}
+		if err := stub.PutState(indexKey, []byte(attributes[len(attributes)-1])); err != nil {
+			return fmt.Errorf("failed to update index: %v", err)
+		}
+	}
+	return nil
+}
+
+// reindexRecord writes the index entries of a record read by MigrateNamespace
+func reindexRecord(stub shim.ChaincodeStubInterface, record schemaRecord) error {
+	var indexKeys []string
+	var err error
+	switch r := record.(type) {
+	case *Product:
+		indexKeys, err = productIndexKeys(stub, r)
+	case *Transfer:
+		indexKeys, err = transferIndexKeys(stub, r)
+	case *MaterialInventory:
+		indexKeys, err = inventoryIndexKeys(stub, r)
+	case *Ownership:
+		indexKeys, err = ownershipIndexKeys(stub, r)
+	}
+	if err != nil {
+		return err
+	}
+	return writeIndexEntries(stub, indexKeys)
+}
+
+// getIndexedIDs returns the IDs of the records whose leading index attributes equal
+// attributes, in key order
+func getIndexedIDs(ctx contractapi.TransactionContextInterface, index string, attributes ...string) ([]string, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(index, attributes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query index %s: %v", index, err)
+	}
+	defer resultsIterator.Close()
+
+	ids := []string{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, string(queryResponse.Value))
+	}
+	return ids, nil
+}
+
+// GetTransfersByStatus returns the transfers in a status, read from the status index
+func (s *SupplyChainContract) GetTransfersByStatus(ctx contractapi.TransactionContextInterface,
+	status string) ([]*Transfer, error) {
+
+	if err := validateEnum("status", status, transferStatuses...); err != nil {
+		return nil, err
+	}
+
+	transferIDs, err := getIndexedIDs(ctx, transferStatusIndex, status)
+	if err != nil {
+		return nil, err
+	}
+	transfers := []*Transfer{}
+	for _, transferID := range transferIDs {
+		transfer, err := s.GetTransfer(ctx, transferID)
+		if err != nil {
+			logFor(ctx).Warn("skipping unreadable indexed transfer", "transferId", transferID, "error", err)
+			continue
+		}
+		transfers = append(transfers, transfer)
+	}
+	return transfers, nil
+}
+
+// GetMaterialInventoriesByOwner returns an organization's material inventories, read
+// from the owner index
+func (s *SupplyChainContract) GetMaterialInventoriesByOwner(ctx contractapi.TransactionContextInterface,
+	owner string) ([]*MaterialInventory, error) {
+
+	if err := validateID("owner", owner); err != nil {
+		return nil, err
+	}
+
+	materialIDs, err := getIndexedIDs(ctx, inventoryOwnerIndex, owner)
+	if err != nil {
+		return nil, err
+	}
+	inventories := []*MaterialInventory{}
+	for _, materialID := range materialIDs {
+		inventory, err := s.GetMaterialInventory(ctx, materialID, owner)
+		if err != nil {
+			logFor(ctx).Warn("skipping unreadable indexed inventory", "materialId", materialID, "error", err)
+			continue
+		}
+		inventories = append(inventories, inventory)
+	}
+	return inventories, nil
+}
+
+// containsString reports whether values contains value
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,169 @@
+package contracts
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Points in a product's life at which its condition is photographed
+const (
+	ConditionContextReceipt      = "RECEIPT"       // Custodian receiving the product in the supply chain
+	ConditionContextPreRepair    = "PRE_REPAIR"    // Service center before a repair
+	ConditionContextPostRepair   = "POST_REPAIR"   // Service center after a repair
+	ConditionContextResaleIntake = "RESALE_INTAKE" // Retailer taking the product in for resale
+)
+
+// Condition photo records are stored as condition_photos_<productID>_<txTime>_<txID>,
+// so a product's records are one key range in the order they were taken
+const (
+	conditionPhotosKeyPrefix = "condition_photos_"
+	maxConditionPhotos       = 20
+)
+
+// ConditionPhotoRecord is a set of photos of a product taken at one point in its life.
+// The photos are stored off-chain and referenced by content hash.
+type ConditionPhotoRecord struct {
+	ProductID     string   `json:"productId"`
+	Context       string   `json:"context"`
+	PhotoHashes   []string `json:"photoHashes"` // e.g. IPFS hashes
+	RecordedBy    string   `json:"recordedBy"`  // MSP ID
+	RecordedAt    string   `json:"recordedAt"`  // Transaction time, RFC3339
+	TxID          string   `json:"txId"`
+	SchemaVersion int      `json:"schemaVersion"`
+}
+
+// AddConditionPhotos records photos of a product's condition. photoHashes is
+// comma-separated. At RECEIPT the caller must hold the product; the other contexts
+// need the ADD_SERVICE_RECORD permission.
+func (o *OwnershipContract) AddConditionPhotos(ctx contractapi.TransactionContextInterface,
+	productID string, context string, photoHashes string) error {
+
+	if err := validateAll(
+		validateID("productID", productID),
+		validateEnum("context", context, ConditionContextReceipt, ConditionContextPreRepair,
+			ConditionContextPostRepair, ConditionContextResaleIntake),
+		validateRequired("photoHashes", photoHashes, maxTextLength),
+	); err != nil {
+		return err
+	}
+
+	hashes := strings.Split(photoHashes, ",")
+	if len(hashes) > maxConditionPhotos {
+		return newError(ErrInvalidArgument, "at most %d photos can be recorded at once", maxConditionPhotos)
+	}
+	for i := range hashes {
+		hashes[i] = strings.TrimSpace(hashes[i])
+		if err := validateID("photo hash", hashes[i]); err != nil {
+			return err
+		}
+	}
+
+	supplyChain := &SupplyChainContract{}
+	product, err := supplyChain.GetProduct(ctx, productID)
+	if err != nil {
+		return err
+	}
+
+	caller, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to get caller identity: %v", err)
+	}
+
+	// CHECK PERMISSION - Receipt photos come from the custodian, the others from service centers and retailers
+	roleContract := &RoleManagementContract{}
+	if context == ConditionContextReceipt {
+		hasPermission, err := roleContract.CheckPermission(ctx, caller, "CONFIRM_RECEIVED")
+		if err != nil || !hasPermission || product.CurrentOwner != caller {
+			return newError(ErrPermissionDenied, "only the holder of product %s can record its condition at receipt", productID)
+		}
+	} else {
+		hasPermission, err := roleContract.CheckPermission(ctx, caller, "ADD_SERVICE_RECORD")
+		if err != nil || !hasPermission {
+			return newError(ErrPermissionDenied, "caller %s does not have permission to record %s photos", caller, context)
+		}
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	txID := ctx.GetStub().GetTxID()
+	record := ConditionPhotoRecord{
+		ProductID:     productID,
+		Context:       context,
+		PhotoHashes:   hashes,
+		RecordedBy:    caller,
+		RecordedAt:    now.UTC().Format(time.RFC3339),
+		TxID:          txID,
+		SchemaVersion: CurrentSchemaVersion,
+	}
+	recordJSON, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	// The ledger log's fixed-width time layout keeps the keys in time order
+	key := conditionPhotosKeyPrefix + productID + "_" + now.UTC().Format(ledgerLogTimeLayout) + "_" + txID
+	err = ctx.GetStub().PutState(key, recordJSON)
+	if err != nil {
+		return fmt.Errorf("failed to store condition photos: %v", err)
+	}
+
+	return emitEvent(ctx, ChaincodeEvent{
+		EventType:  EventConditionPhotosAdded,
+		EntityType: EventEntityProduct,
+		EntityID:   productID,
+		Attributes: map[string]interface{}{
+			"context": context,
+			"photos":  len(hashes),
+		},
+	})
+}
+
+// GetConditionPhotos returns a product's condition photo records, oldest first, limited
+// to one context unless context is empty
+func (o *OwnershipContract) GetConditionPhotos(ctx contractapi.TransactionContextInterface,
+	productID string, context string) ([]*ConditionPhotoRecord, error) {
+
+	if err := validateID("productID", productID); err != nil {
+		return nil, err
+	}
+	if context != "" {
+		if err := validateEnum("context", context, ConditionContextReceipt, ConditionContextPreRepair,
+			ConditionContextPostRepair, ConditionContextResaleIntake); err != nil {
+			return nil, err
+		}
+	}
+
+	prefix := conditionPhotosKeyPrefix + productID + "_"
+	resultsIterator, err := ctx.GetStub().GetStateByRange(prefix, prefix+"~")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query condition photos: %v", err)
+	}
+	defer resultsIterator.Close()
+
+	records := []*ConditionPhotoRecord{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var record ConditionPhotoRecord
+		err = json.Unmarshal(queryResponse.Value, &record)
+		if err != nil {
+			return nil, err
+		}
+		record.upgradeSchema()
+		if context != "" && record.Context != context {
+			continue
+		}
+		records = append(records, &record)
+	}
+
+	return records, nil
+}
@@ -3,6 +3,8 @@ package contracts
 import (
 	"encoding/json"
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/hyperledger/fabric-contract-api-go/contractapi"
@@ -22,17 +24,79 @@ func NewConsensusIntegration(chaincodeName string, channelName string) *Consensu
 	}
 }
 
+// Defaults used until an admin stores a ConsensusConfig
+const (
+	DefaultConsensusChaincodeName = "2check-consensus"
+	DefaultConsensusChannelName   = "luxury-supply-chain"
+)
+
+// consensusConfigKey holds the ConsensusConfig
+const consensusConfigKey = "config_consensus"
+
+// ConsensusConfig names the consensus chaincode and its channel for this deployment
+type ConsensusConfig struct {
+	ConsensusChaincodeName string `json:"consensusChaincodeName"`
+	ChannelName            string `json:"channelName"`
+	UpdatedBy              string `json:"updatedBy,omitempty" metadata:",optional"`
+	UpdatedAt              string `json:"updatedAt,omitempty" metadata:",optional"`
+	SchemaVersion          int    `json:"schemaVersion"`
+}
+
+// getConsensusConfig reads the stored ConsensusConfig, falling back to the defaults
+func getConsensusConfig(ctx contractapi.TransactionContextInterface) (*ConsensusConfig, error) {
+	configJSON, err := ctx.GetStub().GetState(consensusConfigKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read consensus config: %v", err)
+	}
+	if configJSON == nil {
+		return &ConsensusConfig{
+			ConsensusChaincodeName: DefaultConsensusChaincodeName,
+			ChannelName:            DefaultConsensusChannelName,
+			SchemaVersion:          CurrentSchemaVersion,
+		}, nil
+	}
+
+	var config ConsensusConfig
+	err = json.Unmarshal(configJSON, &config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse consensus config: %v", err)
+	}
+	config.upgradeSchema()
+
+	return &config, nil
+}
+
+// LoadConsensusIntegration creates a consensus integration helper from the on-chain config
+func LoadConsensusIntegration(ctx contractapi.TransactionContextInterface) (*ConsensusIntegration, error) {
+	config, err := getConsensusConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return NewConsensusIntegration(config.ConsensusChaincodeName, config.ChannelName), nil
+}
+
 // SubmitToConsensus submits a supply chain transfer to 2-Check consensus
 func (ci *ConsensusIntegration) SubmitToConsensus(ctx contractapi.TransactionContextInterface,
 	transfer *Transfer) error {
 
+	flags, err := getFeatureFlags(ctx)
+	if err != nil {
+		return err
+	}
+
 	// Prepare metadata for consensus transaction
 	metadata := map[string]string{
 		"transferId":   transfer.ID,
 		"productId":    transfer.ProductID,
 		"transferType": string(transfer.TransferType),
 		"initiatedAt":  transfer.InitiatedAt,
+		"autoConfirm":  strconv.FormatBool(flags.EnableAutoConfirm),
 	}
+	policy, err := getConsensusPolicy(ctx, transfer.From, transfer.To, string(transfer.TransferType))
+	if err != nil {
+		return err
+	}
+	policy.addConsensusMetadata(metadata)
 	
 	// Add batch info if present
 	if transfer.Metadata != nil {
@@ -79,7 +143,7 @@ func (ci *ConsensusIntegration) SubmitToConsensus(ctx contractapi.TransactionCon
 	// Invoke consensus chaincode
 	response := ctx.GetStub().InvokeChaincode(ci.ConsensusChaincodeName, args, ci.ChannelName)
 	if response.Status != 200 {
-		return fmt.Errorf("failed to submit to consensus: %s", response.Message)
+		return wrapError(errorFromMessage(response.Message), "failed to submit to consensus")
 	}
 
 	return nil
@@ -97,7 +161,7 @@ func (ci *ConsensusIntegration) NotifyConsensusOfSent(ctx contractapi.Transactio
 
 	response := ctx.GetStub().InvokeChaincode(ci.ConsensusChaincodeName, args, ci.ChannelName)
 	if response.Status != 200 {
-		return fmt.Errorf("failed to confirm sent in consensus: %s", response.Message)
+		return wrapError(errorFromMessage(response.Message), "failed to confirm sent in consensus")
 	}
 
 	return nil
@@ -115,7 +179,221 @@ func (ci *ConsensusIntegration) NotifyConsensusOfReceived(ctx contractapi.Transa
 
 	response := ctx.GetStub().InvokeChaincode(ci.ConsensusChaincodeName, args, ci.ChannelName)
 	if response.Status != 200 {
-		return fmt.Errorf("failed to confirm received in consensus: %s", response.Message)
+		return wrapError(errorFromMessage(response.Message), "failed to confirm received in consensus")
+	}
+
+	return nil
+}
+
+// NotifyConsensusOfHandoff confirms sent in consensus for a transfer the sender handed
+// to carrier, making its consensus transaction three-party
+func (ci *ConsensusIntegration) NotifyConsensusOfHandoff(ctx contractapi.TransactionContextInterface,
+	transferID string, sender string, carrier string) error {
+
+	args := [][]byte{
+		[]byte("ConfirmHandoff"),
+		[]byte(transferID),
+		[]byte(sender),
+		[]byte(carrier),
+	}
+
+	response := ctx.GetStub().InvokeChaincode(ci.ConsensusChaincodeName, args, ci.ChannelName)
+	if response.Status != 200 {
+		return wrapError(errorFromMessage(response.Message), "failed to record handoff in consensus")
+	}
+
+	return nil
+}
+
+// NotifyConsensusOfDelivery notifies consensus that the carrier delivered a transfer
+func (ci *ConsensusIntegration) NotifyConsensusOfDelivery(ctx contractapi.TransactionContextInterface,
+	transferID string, carrier string) error {
+
+	args := [][]byte{
+		[]byte("ConfirmDelivered"),
+		[]byte(transferID),
+		[]byte(carrier),
+	}
+
+	response := ctx.GetStub().InvokeChaincode(ci.ConsensusChaincodeName, args, ci.ChannelName)
+	if response.Status != 200 {
+		return wrapError(errorFromMessage(response.Message), "failed to confirm delivery in consensus")
+	}
+
+	return nil
+}
+
+// NotifyConsensusOfDeclaredValue passes the sender's declared value to consensus
+func (ci *ConsensusIntegration) NotifyConsensusOfDeclaredValue(ctx contractapi.TransactionContextInterface,
+	transferID string, sender string, value *DeclaredValue) error {
+
+	args := [][]byte{
+		[]byte("SetDeclaredValue"),
+		[]byte(transferID),
+		[]byte(sender),
+		[]byte(value.Amount),
+		[]byte(value.Currency),
+	}
+
+	response := ctx.GetStub().InvokeChaincode(ci.ConsensusChaincodeName, args, ci.ChannelName)
+	if response.Status != 200 {
+		return wrapError(errorFromMessage(response.Message), "failed to declare value in consensus")
+	}
+
+	return nil
+}
+
+// NotifyConsensusOfPriority passes the sender's transfer priority to consensus
+func (ci *ConsensusIntegration) NotifyConsensusOfPriority(ctx contractapi.TransactionContextInterface,
+	transferID string, sender string, priority string) error {
+
+	args := [][]byte{
+		[]byte("SetPriority"),
+		[]byte(transferID),
+		[]byte(sender),
+		[]byte(priority),
+	}
+
+	response := ctx.GetStub().InvokeChaincode(ci.ConsensusChaincodeName, args, ci.ChannelName)
+	if response.Status != 200 {
+		return wrapError(errorFromMessage(response.Message), "failed to set priority in consensus")
+	}
+
+	return nil
+}
+
+// NotifyConsensusOfCancellation passes a cancellation by the sender, or a rejection by
+// the receiver when rejected is set, to consensus
+func (ci *ConsensusIntegration) NotifyConsensusOfCancellation(ctx contractapi.TransactionContextInterface,
+	transferID string, party string, reason string, rejected bool) error {
+
+	function := "CancelTransaction"
+	if rejected {
+		function = "RejectTransaction"
+	}
+	args := [][]byte{
+		[]byte(function),
+		[]byte(transferID),
+		[]byte(party),
+		[]byte(reason),
+	}
+
+	response := ctx.GetStub().InvokeChaincode(ci.ConsensusChaincodeName, args, ci.ChannelName)
+	if response.Status != 200 {
+		return wrapError(errorFromMessage(response.Message), "failed to cancel in consensus")
+	}
+
+	return nil
+}
+
+// NotifyConsensusOfDispute raises a dispute on a transfer's consensus transaction,
+// asking for quantity items to be resent or returned
+func (ci *ConsensusIntegration) NotifyConsensusOfDispute(ctx contractapi.TransactionContextInterface,
+	transferID string, initiator string, reason string, quantity int) error {
+
+	args := [][]byte{
+		[]byte("RaiseDispute"),
+		[]byte(transferID),
+		[]byte(initiator),
+		[]byte(reason),
+		[]byte(strconv.Itoa(quantity)),
+	}
+
+	response := ctx.GetStub().InvokeChaincode(ci.ConsensusChaincodeName, args, ci.ChannelName)
+	if response.Status != 200 {
+		return wrapError(errorFromMessage(response.Message), "failed to raise dispute in consensus")
+	}
+
+	return nil
+}
+
+// NotifyConsensusOfSensorEvidence cites anchored sensor data, as hash=reference pairs,
+// in the receiver's DEFECTIVE dispute of a transfer
+func (ci *ConsensusIntegration) NotifyConsensusOfSensorEvidence(ctx contractapi.TransactionContextInterface,
+	transferID string, receiver string, anchors []string) error {
+
+	args := [][]byte{
+		[]byte("SubmitSensorEvidence"),
+		[]byte(transferID),
+		[]byte(receiver),
+		[]byte(strings.Join(anchors, ",")),
+	}
+
+	response := ctx.GetStub().InvokeChaincode(ci.ConsensusChaincodeName, args, ci.ChannelName)
+	if response.Status != 200 {
+		return wrapError(errorFromMessage(response.Message), "failed to submit sensor evidence to consensus")
+	}
+
+	return nil
+}
+
+// NotifyConsensusOfTimeout asks consensus to validate a transfer that timed out in the
+// supply chain, which times out its transaction and applies the trust penalties once
+// the consensus deadline has passed. It returns the state consensus leaves the
+// transaction in, TIMEOUT once the deadline has passed.
+func (ci *ConsensusIntegration) NotifyConsensusOfTimeout(ctx contractapi.TransactionContextInterface,
+	transferID string) (string, error) {
+
+	args := [][]byte{
+		[]byte("ValidateTransactionState"),
+		[]byte(transferID),
+	}
+
+	response := ctx.GetStub().InvokeChaincode(ci.ConsensusChaincodeName, args, ci.ChannelName)
+	if response.Status != 200 {
+		return "", wrapError(errorFromMessage(response.Message), "failed to validate timeout in consensus")
+	}
+
+	var consensusTransaction struct {
+		State string `json:"state"`
+	}
+	if err := json.Unmarshal(response.Payload, &consensusTransaction); err != nil {
+		return "", fmt.Errorf("failed to read consensus transaction %s: %v", transferID, err)
+	}
+
+	return consensusTransaction.State, nil
+}
+
+// ReportTrustEvent applies a trust score penalty for a supply chain event, such as
+// TRANSIT_DAMAGE, to a party in consensus
+func (ci *ConsensusIntegration) ReportTrustEvent(ctx contractapi.TransactionContextInterface,
+	partyID string, event string) error {
+
+	eventData, err := json.Marshal(map[string]string{
+		"partyID": partyID,
+		"event":   event,
+	})
+	if err != nil {
+		return err
+	}
+
+	args := [][]byte{
+		[]byte("UpdateTrustFromEvent"),
+		eventData,
+	}
+
+	response := ctx.GetStub().InvokeChaincode(ci.ConsensusChaincodeName, args, ci.ChannelName)
+	if response.Status != 200 {
+		return wrapError(errorFromMessage(response.Message), "failed to update trust score in consensus")
+	}
+
+	return nil
+}
+
+// NotifyConsensusOfRole records an organization's role in consensus, where it selects
+// the rate at which the organization's trust score decays while it is idle
+func (ci *ConsensusIntegration) NotifyConsensusOfRole(ctx contractapi.TransactionContextInterface,
+	mspID string, role OrganizationRole) error {
+
+	args := [][]byte{
+		[]byte("SetPartyRole"),
+		[]byte(mspID),
+		[]byte(role),
+	}
+
+	response := ctx.GetStub().InvokeChaincode(ci.ConsensusChaincodeName, args, ci.ChannelName)
+	if response.Status != 200 {
+		return wrapError(errorFromMessage(response.Message), "failed to record role in consensus")
 	}
 
 	return nil
@@ -132,7 +410,7 @@ func (ci *ConsensusIntegration) GetConsensusStatus(ctx contractapi.TransactionCo
 
 	response := ctx.GetStub().InvokeChaincode(ci.ConsensusChaincodeName, args, ci.ChannelName)
 	if response.Status != 200 {
-		return nil, fmt.Errorf("failed to get consensus status: %s", response.Message)
+		return nil, wrapError(errorFromMessage(response.Message), "failed to get consensus status")
 	}
 
 	var consensusTransaction map[string]interface{}
@@ -155,7 +433,7 @@ func (ci *ConsensusIntegration) GetTrustScore(ctx contractapi.TransactionContext
 
 	response := ctx.GetStub().InvokeChaincode(ci.ConsensusChaincodeName, args, ci.ChannelName)
 	if response.Status != 200 {
-		return 0, fmt.Errorf("failed to get trust score: %s", response.Message)
+		return 0, wrapError(errorFromMessage(response.Message), "failed to get trust score")
 	}
 
 	var trustScore struct {
@@ -169,12 +447,42 @@ func (ci *ConsensusIntegration) GetTrustScore(ctx contractapi.TransactionContext
 	return trustScore.Score, nil
 }
 
+// GetTrustTier retrieves a party's trust tier from consensus chaincode
+func (ci *ConsensusIntegration) GetTrustTier(ctx contractapi.TransactionContextInterface,
+	partyID string) (TrustTier, error) {
+
+	args := [][]byte{
+		[]byte("GetTrustTier"),
+		[]byte(partyID),
+	}
+
+	response := ctx.GetStub().InvokeChaincode(ci.ConsensusChaincodeName, args, ci.ChannelName)
+	if response.Status != 200 {
+		return "", wrapError(errorFromMessage(response.Message), "failed to get trust tier")
+	}
+
+	tier := TrustTier(response.Payload)
+	switch tier {
+	case TrustTierProbation, TrustTierStandard, TrustTierTrusted, TrustTierPlatinum:
+		return tier, nil
+	}
+	return "", fmt.Errorf("unexpected trust tier %q from consensus", tier)
+}
+
 // Enhanced SupplyChainContract methods with consensus integration
 
 // InitiateBatchTransferWithConsensus creates a batch transfer and submits to 2-Check consensus
 func (s *SupplyChainContract) InitiateBatchTransferWithConsensus(ctx contractapi.TransactionContextInterface,
 	transferID string, batchID string, to string) error {
 	
+	if err := validateAll(
+		validateID("transferID", transferID),
+		validateID("batchID", batchID),
+		validateID("to", to),
+	); err != nil {
+		return err
+	}
+
 	// First create the batch transfer
 	err := s.TransferBatch(ctx, transferID, batchID, to)
 	if err != nil {
@@ -188,12 +496,15 @@ func (s *SupplyChainContract) InitiateBatchTransferWithConsensus(ctx contractapi
 	}
 	
 	// Submit to consensus chaincode
-	consensus := NewConsensusIntegration("2check-consensus", "luxury-supply-chain")
+	consensus, err := LoadConsensusIntegration(ctx)
+	if err != nil {
+		return err
+	}
 	err = consensus.SubmitToConsensus(ctx, transfer)
 	if err != nil {
 		// Rollback transfer creation if consensus submission fails
 		ctx.GetStub().DelState("transfer_" + transferID)
-		return fmt.Errorf("failed to submit batch transfer to consensus: %v", err)
+		return wrapError(err, "failed to submit batch transfer to consensus")
 	}
 	
 	return nil
@@ -203,6 +514,14 @@ func (s *SupplyChainContract) InitiateBatchTransferWithConsensus(ctx contractapi
 func (s *SupplyChainContract) InitiateTransferWithConsensus(ctx contractapi.TransactionContextInterface,
 	transferID string, productID string, to string, transferTypeStr string) error {
 
+	if err := validateAll(
+		validateID("transferID", transferID),
+		validateID("productID", productID),
+		validateID("to", to),
+	); err != nil {
+		return err
+	}
+
 	// Convert string to TransferType
 	var transferType TransferType
 	switch transferTypeStr {
@@ -212,6 +531,12 @@ func (s *SupplyChainContract) InitiateTransferWithConsensus(ctx contractapi.Tran
 		transferType = TransferTypeOwnership
 	case "RETURN":
 		transferType = TransferTypeReturn
+	case "DONATION":
+		transferType = TransferTypeDonation
+	case "LOGISTICS":
+		transferType = TransferTypeLogistics
+	case "CONSIGNMENT":
+		transferType = TransferTypeConsignment
 	default:
 		transferType = TransferTypeSupplyChain // Default to supply chain
 	}
@@ -229,21 +554,46 @@ func (s *SupplyChainContract) InitiateTransferWithConsensus(ctx contractapi.Tran
 	}
 
 	// Submit to consensus chaincode
-	consensus := NewConsensusIntegration("2check-consensus", "luxury-supply-chain")
+	consensus, err := LoadConsensusIntegration(ctx)
+	if err != nil {
+		return err
+	}
 	err = consensus.SubmitToConsensus(ctx, transfer)
 	if err != nil {
 		// Rollback transfer creation if consensus submission fails
 		ctx.GetStub().DelState("transfer_" + transferID)
-		return fmt.Errorf("failed to submit to consensus: %v", err)
+		return wrapError(err, "failed to submit to consensus")
 	}
 
 	return nil
 }
 
+// SetDeclaredValueWithConsensus declares a transfer's value and records it on the
+// consensus transaction as well
+func (s *SupplyChainContract) SetDeclaredValueWithConsensus(ctx contractapi.TransactionContextInterface,
+	transferID string, amount string, currency string) error {
+
+	transfer, err := s.declareTransferValue(ctx, transferID, amount, currency)
+	if err != nil {
+		return err
+	}
+
+	// Both records must agree, so a failed notification fails the transaction
+	consensus, err := LoadConsensusIntegration(ctx)
+	if err != nil {
+		return err
+	}
+	return consensus.NotifyConsensusOfDeclaredValue(ctx, transferID, transfer.From, transfer.DeclaredValue)
+}
+
 // ConfirmSentWithConsensus confirms sent and updates consensus
 func (s *SupplyChainContract) ConfirmSentWithConsensus(ctx contractapi.TransactionContextInterface,
 	transferID string) error {
 
+	if err := validateID("transferID", transferID); err != nil {
+		return err
+	}
+
 	// Get sender identity
 	sender, err := ctx.GetClientIdentity().GetMSPID()
 	if err != nil {
@@ -257,11 +607,14 @@ func (s *SupplyChainContract) ConfirmSentWithConsensus(ctx contractapi.Transacti
 	}
 
 	// Then notify consensus
-	consensus := NewConsensusIntegration("2check-consensus", "luxury-supply-chain")
+	consensus, err := LoadConsensusIntegration(ctx)
+	if err != nil {
+		return err
+	}
 	err = consensus.NotifyConsensusOfSent(ctx, transferID, sender)
 	if err != nil {
 		// Log error but don't rollback - consensus will handle timeout
-		fmt.Printf("Warning: Failed to notify consensus of sent confirmation: %v\n", err)
+		logFor(ctx).Warn("failed to notify consensus of sent confirmation", "transferId", transferID, "error", err)
 	}
 
 	return nil
@@ -271,6 +624,10 @@ func (s *SupplyChainContract) ConfirmSentWithConsensus(ctx contractapi.Transacti
 func (s *SupplyChainContract) ConfirmReceivedWithConsensus(ctx contractapi.TransactionContextInterface,
 	transferID string) error {
 
+	if err := validateID("transferID", transferID); err != nil {
+		return err
+	}
+
 	// Get receiver identity
 	receiver, err := ctx.GetClientIdentity().GetMSPID()
 	if err != nil {
@@ -284,11 +641,14 @@ func (s *SupplyChainContract) ConfirmReceivedWithConsensus(ctx contractapi.Trans
 	}
 
 	// Then notify consensus
-	consensus := NewConsensusIntegration("2check-consensus", "luxury-supply-chain")
+	consensus, err := LoadConsensusIntegration(ctx)
+	if err != nil {
+		return err
+	}
 	err = consensus.NotifyConsensusOfReceived(ctx, transferID, receiver)
 	if err != nil {
 		// Log error but don't rollback
-		fmt.Printf("Warning: Failed to notify consensus of receipt confirmation: %v\n", err)
+		logFor(ctx).Warn("failed to notify consensus of receipt confirmation", "transferId", transferID, "error", err)
 	}
 
 	return nil
@@ -298,6 +658,10 @@ func (s *SupplyChainContract) ConfirmReceivedWithConsensus(ctx contractapi.Trans
 func (s *SupplyChainContract) GetTransferWithConsensusStatus(ctx contractapi.TransactionContextInterface,
 	transferID string) (map[string]interface{}, error) {
 
+	if err := validateID("transferID", transferID); err != nil {
+		return nil, err
+	}
+
 	// Get transfer from supply chain
 	transfer, err := s.GetTransfer(ctx, transferID)
 	if err != nil {
@@ -305,7 +669,10 @@ func (s *SupplyChainContract) GetTransferWithConsensusStatus(ctx contractapi.Tra
 	}
 
 	// Get consensus status
-	consensus := NewConsensusIntegration("2check-consensus", "luxury-supply-chain")
+	consensus, err := LoadConsensusIntegration(ctx)
+	if err != nil {
+		return nil, err
+	}
 	consensusStatus, err := consensus.GetConsensusStatus(ctx, transferID)
 	if err != nil {
 		// Return transfer without consensus status if unavailable
@@ -329,7 +696,14 @@ func (s *SupplyChainContract) GetTransferWithConsensusStatus(ctx contractapi.Tra
 func (s *SupplyChainContract) GetPartyTrustScore(ctx contractapi.TransactionContextInterface,
 	partyID string) (map[string]interface{}, error) {
 
-	consensus := NewConsensusIntegration("2check-consensus", "luxury-supply-chain")
+	if err := validateID("partyID", partyID); err != nil {
+		return nil, err
+	}
+
+	consensus, err := LoadConsensusIntegration(ctx)
+	if err != nil {
+		return nil, err
+	}
 	score, err := consensus.GetTrustScore(ctx, partyID)
 	if err != nil {
 		return nil, err
@@ -348,7 +722,14 @@ func (s *SupplyChainContract) GetPartyTrustScore(ctx contractapi.TransactionCont
 func (s *SupplyChainContract) CreateReturnTransferAfterDispute(ctx contractapi.TransactionContextInterface,
 	disputeID string) error {
 	
-	consensus := NewConsensusIntegration("2check-consensus", "luxury-supply-chain")
+	if err := validateID("disputeID", disputeID); err != nil {
+		return err
+	}
+
+	consensus, err := LoadConsensusIntegration(ctx)
+	if err != nil {
+		return err
+	}
 	
 	// Get dispute resolution from consensus
 	args := [][]byte{
@@ -358,18 +739,18 @@ func (s *SupplyChainContract) CreateReturnTransferAfterDispute(ctx contractapi.T
 	
 	response := ctx.GetStub().InvokeChaincode(consensus.ConsensusChaincodeName, args, consensus.ChannelName)
 	if response.Status != 200 {
-		return fmt.Errorf("failed to get dispute resolution: %s", response.Message)
+		return wrapError(errorFromMessage(response.Message), "failed to get dispute resolution")
 	}
 	
 	var resolution map[string]interface{}
-	err := json.Unmarshal(response.Payload, &resolution)
+	err = json.Unmarshal(response.Payload, &resolution)
 	if err != nil {
 		return err
 	}
 	
 	// Check if action is already completed
 	if actionCompleted, ok := resolution["actionCompleted"].(bool); ok && actionCompleted {
-		return fmt.Errorf("return transfer already created for dispute %s", disputeID)
+		return newError(ErrInvalidState, "return transfer already created for dispute %s", disputeID)
 	}
 	
 	// Check if action is required
@@ -391,7 +772,7 @@ func (s *SupplyChainContract) CreateReturnTransferAfterDispute(ctx contractapi.T
 	
 	response = ctx.GetStub().InvokeChaincode(consensus.ConsensusChaincodeName, args, consensus.ChannelName)
 	if response.Status != 200 {
-		return fmt.Errorf("failed to get original transaction: %s", response.Message)
+		return wrapError(errorFromMessage(response.Message), "failed to get original transaction")
 	}
 	
 	var originalTx map[string]interface{}
@@ -422,7 +803,7 @@ func (s *SupplyChainContract) CreateReturnTransferAfterDispute(ctx contractapi.T
 		from = resolution["loser"].(string)  // Supplier sends new materials
 		to = winner  // Manufacturer receives replacement
 	default:
-		return fmt.Errorf("unknown required action: %s", requiredAction)
+		return newError(ErrInvalidArgument, "unknown required action: %s", requiredAction)
 	}
 	
 	// Create new transfer ID
@@ -431,6 +812,7 @@ func (s *SupplyChainContract) CreateReturnTransferAfterDispute(ctx contractapi.T
 	// Create transfer with metadata about dispute
 	currentTime := time.Now().Format(time.RFC3339)
 	transfer := Transfer{
+		SchemaVersion: CurrentSchemaVersion,
 		ID:           transferID,
 		ProductID:    itemId, // Use the actual materialId from original transaction
 		From:         from,
@@ -456,12 +838,7 @@ func (s *SupplyChainContract) CreateReturnTransferAfterDispute(ctx contractapi.T
 		},
 	}
 	
-	transferJSON, err := json.Marshal(transfer)
-	if err != nil {
-		return err
-	}
-	
-	err = ctx.GetStub().PutState("transfer_"+transferID, transferJSON)
+	err = putTransfer(ctx, &transfer)
 	if err != nil {
 		return err
 	}
@@ -481,24 +858,51 @@ func (s *SupplyChainContract) CreateReturnTransferAfterDispute(ctx contractapi.T
 	
 	response = ctx.GetStub().InvokeChaincode(consensus.ConsensusChaincodeName, args, consensus.ChannelName)
 	if response.Status != 200 {
-		return fmt.Errorf("failed to mark action completed: %s", response.Message)
+		return wrapError(errorFromMessage(response.Message), "failed to mark action completed")
 	}
 	
-	return nil
+	event := transferEvent(EventDisputeResolutionTransfer, &transfer, "")
+	event.Attributes["disputeId"] = disputeID
+	event.Attributes["requiredAction"] = requiredAction
+	event.Attributes["quantity"] = actionQuantity
+	return emitEvent(ctx, event)
 }
 
 // SubmitMaterialTransferToConsensus submits material transfers to consensus
 func (s *SupplyChainContract) SubmitMaterialTransferToConsensus(ctx contractapi.TransactionContextInterface,
 	transferID string, materialID string, from string, to string, quantity float64) error {
 	
-	consensus := NewConsensusIntegration("2check-consensus", "luxury-supply-chain")
+	if err := validateAll(
+		validateID("transferID", transferID),
+		validateID("materialID", materialID),
+		validateID("from", from),
+		validateID("to", to),
+		validateQuantity("quantity", quantity),
+	); err != nil {
+		return err
+	}
+
+	consensus, err := LoadConsensusIntegration(ctx)
+	if err != nil {
+		return err
+	}
+	flags, err := getFeatureFlags(ctx)
+	if err != nil {
+		return err
+	}
 	
 	// Create metadata for the transfer
 	metadata := map[string]string{
-		"type":       "MATERIAL",
-		"materialId": materialID,
-		"quantity":   fmt.Sprintf("%.2f", quantity),
+		"type":        "MATERIAL",
+		"materialId":  materialID,
+		"quantity":    fmt.Sprintf("%.2f", quantity),
+		"autoConfirm": strconv.FormatBool(flags.EnableAutoConfirm),
+	}
+	policy, err := getConsensusPolicy(ctx, from, to, "")
+	if err != nil {
+		return err
 	}
+	policy.addConsensusMetadata(metadata)
 	
 	metadataJSON, err := json.Marshal(metadata)
 	if err != nil {
@@ -519,7 +923,7 @@ func (s *SupplyChainContract) SubmitMaterialTransferToConsensus(ctx contractapi.
 	
 	response := ctx.GetStub().InvokeChaincode(consensus.ConsensusChaincodeName, args, consensus.ChannelName)
 	if response.Status != 200 {
-		return fmt.Errorf("failed to submit material transfer to consensus: %s", response.Message)
+		return wrapError(errorFromMessage(response.Message), "failed to submit material transfer to consensus")
 	}
 	
 	return nil
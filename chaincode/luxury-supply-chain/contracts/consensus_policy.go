@@ -0,0 +1,234 @@
+package contracts
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Consensus policies are stored as consensus_policy_pair_<sender>_<receiver> or
+// consensus_policy_type_<transferType>. MSP IDs cannot contain '_', so the keys are
+// unambiguous.
+const (
+	consensusPolicyKeyPrefix = "consensus_policy_"
+	maxPolicyHours           = 30 * 24
+)
+
+// ConsensusPolicy overrides the confirmation windows of transfers from Sender to
+// Receiver, or of every transfer of TransferType. Zero settings keep the defaults.
+type ConsensusPolicy struct {
+	Sender                string  `json:"sender,omitempty" metadata:",optional"` // With Receiver, the organization pair it applies to
+	Receiver              string  `json:"receiver,omitempty" metadata:",optional"`
+	TransferType          string  `json:"transferType,omitempty" metadata:",optional"`          // Or the transfer type it applies to
+	TransferTimeoutHours  int     `json:"transferTimeoutHours,omitempty" metadata:",optional"`  // consensusDetails.timeoutAt, 24 by default
+	ConsensusTimeoutHours int     `json:"consensusTimeoutHours,omitempty" metadata:",optional"` // Consensus ValidateTransaction, 48 by default
+	AutoConfirmThreshold  float64 `json:"autoConfirmThreshold,omitempty" metadata:",optional"`  // Sender trust score for auto-confirmation, 0.95 by default
+	EscalationHours       int     `json:"escalationHours,omitempty" metadata:",optional"`       // Deadline of dispute resolution actions, 72 by default
+	UpdatedBy             string  `json:"updatedBy,omitempty" metadata:",optional"`
+	UpdatedAt             string  `json:"updatedAt,omitempty" metadata:",optional"`
+	SchemaVersion         int     `json:"schemaVersion"`
+}
+
+// consensusPolicyKey returns the key of the policy for an organization pair, or for a
+// transfer type when sender and receiver are empty
+func consensusPolicyKey(sender string, receiver string, transferType string) string {
+	if sender != "" {
+		return consensusPolicyKeyPrefix + "pair_" + sender + "_" + receiver
+	}
+	return consensusPolicyKeyPrefix + "type_" + transferType
+}
+
+// validateConsensusPolicyScope checks that a policy names either an organization pair
+// or a transfer type
+func validateConsensusPolicyScope(sender string, receiver string, transferType string) error {
+	if sender != "" || receiver != "" {
+		if transferType != "" {
+			return newError(ErrInvalidArgument, "a consensus policy applies to an organization pair or a transfer type, not both")
+		}
+		return validateAll(
+			validateID("sender", sender),
+			validateID("receiver", receiver),
+		)
+	}
+	return validateEnum("transferType", transferType, string(TransferTypeSupplyChain), string(TransferTypeOwnership),
+		string(TransferTypeReturn), string(TransferTypeDonation), string(TransferTypeLogistics), string(TransferTypeConsignment))
+}
+
+// getConsensusPolicy returns the policy for transfers from sender to receiver: the
+// pair's, else the transfer type's, else an empty policy that keeps every default.
+// Material transfers pass an empty transferType.
+func getConsensusPolicy(ctx contractapi.TransactionContextInterface,
+	sender string, receiver string, transferType string) (*ConsensusPolicy, error) {
+
+	keys := []string{consensusPolicyKey(sender, receiver, "")}
+	if transferType != "" {
+		keys = append(keys, consensusPolicyKey("", "", transferType))
+	}
+	for _, key := range keys {
+		policyJSON, err := ctx.GetStub().GetState(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read consensus policy: %v", err)
+		}
+		if policyJSON == nil {
+			continue
+		}
+		var policy ConsensusPolicy
+		err = json.Unmarshal(policyJSON, &policy)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse consensus policy: %v", err)
+		}
+		policy.upgradeSchema()
+		return &policy, nil
+	}
+	return &ConsensusPolicy{SchemaVersion: CurrentSchemaVersion}, nil
+}
+
+// transferTimeout returns how long a transfer has to be confirmed. An urgent transfer
+// keeps the urgent timeout unless the policy's is shorter.
+func (p *ConsensusPolicy) transferTimeout(urgent bool) time.Duration {
+	timeout := transferTimeout
+	if urgent {
+		timeout = urgentTransferTimeout
+	}
+	if p.TransferTimeoutHours > 0 {
+		policyTimeout := time.Duration(p.TransferTimeoutHours) * time.Hour
+		if !urgent || policyTimeout < timeout {
+			timeout = policyTimeout
+		}
+	}
+	return timeout
+}
+
+// addConsensusMetadata passes the policy's consensus settings to a consensus
+// transaction's metadata. Unset settings are left out, so consensus keeps its defaults.
+func (p *ConsensusPolicy) addConsensusMetadata(metadata map[string]string) {
+	if p.ConsensusTimeoutHours > 0 {
+		metadata["timeoutHours"] = strconv.Itoa(p.ConsensusTimeoutHours)
+	}
+	if p.AutoConfirmThreshold > 0 {
+		metadata["autoConfirmThreshold"] = strconv.FormatFloat(p.AutoConfirmThreshold, 'f', -1, 64)
+	}
+	if p.EscalationHours > 0 {
+		metadata["escalationHours"] = strconv.Itoa(p.EscalationHours)
+	}
+}
+
+// SetConsensusPolicy stores the policy for an organization pair or a transfer type,
+// replacing the previous one, e.g.
+// {"sender":"CraftWorkshopMSP","receiver":"LuxuryRetailMSP","transferTimeoutHours":72,"consensusTimeoutHours":96}
+// or {"transferType":"RETURN","escalationHours":168}. A pair's policy takes precedence
+// over its transfer type's. It applies to transfers initiated afterwards.
+func (a *AdminContract) SetConsensusPolicy(ctx contractapi.TransactionContextInterface,
+	policyJSON string) error {
+
+	var policy ConsensusPolicy
+	if err := validateJSON("policyJSON", policyJSON, &policy); err != nil {
+		return err
+	}
+	if err := validateConsensusPolicyScope(policy.Sender, policy.Receiver, policy.TransferType); err != nil {
+		return err
+	}
+	for field, hours := range map[string]int{
+		"transferTimeoutHours":  policy.TransferTimeoutHours,
+		"consensusTimeoutHours": policy.ConsensusTimeoutHours,
+		"escalationHours":       policy.EscalationHours,
+	} {
+		if hours < 0 || hours > maxPolicyHours {
+			return newError(ErrInvalidArgument, "%s must be between 1 and %d, or 0 for the default", field, maxPolicyHours)
+		}
+	}
+	if policy.AutoConfirmThreshold != 0 && (policy.AutoConfirmThreshold < 0.5 || policy.AutoConfirmThreshold > 1) {
+		return newError(ErrInvalidArgument, "autoConfirmThreshold must be between 0.5 and 1, or 0 for the default")
+	}
+	if policy.TransferTimeoutHours == 0 && policy.ConsensusTimeoutHours == 0 &&
+		policy.AutoConfirmThreshold == 0 && policy.EscalationHours == 0 {
+		return newError(ErrInvalidArgument, "a consensus policy needs at least one setting, use RemoveConsensusPolicy to restore the defaults")
+	}
+
+	caller, err := requireSuperAdmin(ctx)
+	if err != nil {
+		return err
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	policy.UpdatedBy = caller
+	policy.UpdatedAt = now.UTC().Format(time.RFC3339)
+	policy.SchemaVersion = CurrentSchemaVersion
+	storedJSON, err := json.Marshal(policy)
+	if err != nil {
+		return err
+	}
+	key := consensusPolicyKey(policy.Sender, policy.Receiver, policy.TransferType)
+	err = ctx.GetStub().PutState(key, storedJSON)
+	if err != nil {
+		return fmt.Errorf("failed to store consensus policy: %v", err)
+	}
+
+	return emitEvent(ctx, ChaincodeEvent{
+		EventType:  EventConsensusPolicyUpdated,
+		EntityType: EventEntityConfig,
+		EntityID:   key,
+		Attributes: map[string]interface{}{
+			"transferTimeoutHours":  policy.TransferTimeoutHours,
+			"consensusTimeoutHours": policy.ConsensusTimeoutHours,
+			"autoConfirmThreshold":  policy.AutoConfirmThreshold,
+			"escalationHours":       policy.EscalationHours,
+		},
+	})
+}
+
+// RemoveConsensusPolicy deletes the policy of an organization pair, or of a transfer
+// type when sender and receiver are empty. Transfers initiated afterwards fall back to
+// the transfer type's policy or the defaults.
+func (a *AdminContract) RemoveConsensusPolicy(ctx contractapi.TransactionContextInterface,
+	sender string, receiver string, transferType string) error {
+
+	if err := validateConsensusPolicyScope(sender, receiver, transferType); err != nil {
+		return err
+	}
+
+	if _, err := requireSuperAdmin(ctx); err != nil {
+		return err
+	}
+
+	key := consensusPolicyKey(sender, receiver, transferType)
+	existing, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return fmt.Errorf("failed to read consensus policy: %v", err)
+	}
+	if existing == nil {
+		return newError(ErrNotFound, "consensus policy %s does not exist", key)
+	}
+	err = ctx.GetStub().DelState(key)
+	if err != nil {
+		return fmt.Errorf("failed to delete consensus policy: %v", err)
+	}
+
+	return emitEvent(ctx, ChaincodeEvent{
+		EventType:  EventConsensusPolicyRemoved,
+		EntityType: EventEntityConfig,
+		EntityID:   key,
+	})
+}
+
+// GetConsensusPolicy returns the policy that applies to transfers of transferType from
+// sender to receiver. A policy without a scope means the defaults apply.
+func (a *AdminContract) GetConsensusPolicy(ctx contractapi.TransactionContextInterface,
+	sender string, receiver string, transferType string) (*ConsensusPolicy, error) {
+
+	if err := validateAll(
+		validateID("sender", sender),
+		validateID("receiver", receiver),
+		validateConsensusPolicyScope("", "", transferType),
+	); err != nil {
+		return nil, err
+	}
+
+	return getConsensusPolicy(ctx, sender, receiver, transferType)
+}
@@ -0,0 +1,164 @@
+package contracts
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Consignment records that a product or batch was placed with a retailer on
+// consignment. The consignor stays its owner until the retailer sells it; meanwhile
+// the item's CurrentLocation names the retailer.
+type Consignment struct {
+	TransferID     string `json:"transferId"`                               // The CONSIGNMENT transfer
+	Consignor      string `json:"consignor"`                                // MSP ID of the owner
+	Consignee      string `json:"consignee"`                                // MSP ID of the retailer holding the item
+	ConsignedAt    string `json:"consignedAt"`                              // When the retailer confirmed receipt
+	SettledAt      string `json:"settledAt,omitempty" metadata:",optional"` // When the retailer sold or bought the product
+	SettlementTxID string `json:"settlementTxId,omitempty" metadata:",optional"`
+}
+
+// active reports whether the item is still held on consignment
+func (c *Consignment) active() bool {
+	return c != nil && c.SettledAt == ""
+}
+
+// heldBy reports whether org holds the item on consignment
+func (c *Consignment) heldBy(org string) bool {
+	return c.active() && c.Consignee == org
+}
+
+// itemHolder returns who physically holds an item: the consignee while it is on
+// consignment, otherwise its owner
+func itemHolder(owner string, consignment *Consignment) string {
+	if consignment.active() {
+		return consignment.Consignee
+	}
+	return owner
+}
+
+// checkConsignee rejects a CONSIGNMENT transfer unless it goes to an active retailer
+func checkConsignee(ctx contractapi.TransactionContextInterface, consignee string) error {
+	roleContract := &RoleManagementContract{}
+	info, err := roleContract.GetOrganizationInfo(ctx, consignee)
+	if err != nil || info.Role != RoleRetailer || !info.IsActive {
+		return newError(ErrInvalidArgument, "%s is not a registered retailer and cannot take goods on consignment", consignee)
+	}
+	return nil
+}
+
+// checkConsignedShipment rejects shipping an item on consignment, except by the
+// consignee back to the consignor. Items not on consignment are left to the usual
+// ownership check.
+func checkConsignedShipment(consignment *Consignment, itemID string, sender string, to string,
+	transferType TransferType) error {
+
+	if !consignment.active() {
+		return nil
+	}
+	if consignment.Consignee != sender || consignment.Consignor != to || transferType == TransferTypeConsignment {
+		return newError(ErrInvalidState, "%s is on consignment at %s and can only be returned to %s",
+			itemID, consignment.Consignee, consignment.Consignor)
+	}
+	return nil
+}
+
+// newConsignment builds the consignment a completed CONSIGNMENT transfer starts
+func newConsignment(transfer *Transfer) *Consignment {
+	return &Consignment{
+		TransferID:  transfer.ID,
+		Consignor:   transfer.From,
+		Consignee:   transfer.To,
+		ConsignedAt: transfer.CompletedAt,
+	}
+}
+
+// receiveProduct moves a received product to the receiver. A CONSIGNMENT transfer only
+// changes its location; any other receipt, including consigned goods coming back to the
+// consignor, makes the receiver its owner and ends a consignment.
+func receiveProduct(product *Product, transfer *Transfer) {
+	product.CurrentLocation = transfer.To
+	if transfer.TransferType == TransferTypeConsignment {
+		product.Consignment = newConsignment(transfer)
+		return
+	}
+	product.CurrentOwner = transfer.To
+	if product.Consignment.active() {
+		product.Consignment = nil
+	}
+}
+
+// receiveBatchHolding is receiveProduct for the batch record
+func receiveBatchHolding(batch *ProductBatch, transfer *Transfer) {
+	batch.CurrentLocation = transfer.To
+	if transfer.TransferType == TransferTypeConsignment {
+		batch.Consignment = newConsignment(transfer)
+		return
+	}
+	batch.CurrentOwner = transfer.To
+	if batch.Consignment.active() {
+		batch.Consignment = nil
+	}
+}
+
+// settleConsignment makes the retailer holding a consigned product its owner, at the
+// moment it sells the product or buys it from the consignor
+func settleConsignment(ctx contractapi.TransactionContextInterface, product *Product) error {
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	product.CurrentOwner = product.Consignment.Consignee
+	product.Consignment.SettledAt = now.UTC().Format(time.RFC3339)
+	product.Consignment.SettlementTxID = ctx.GetStub().GetTxID()
+	return nil
+}
+
+// SettleConsignmentSale lets the retailer holding a product on consignment buy it from
+// the consignor, e.g. to sell it outside the chaincode or keep it as stock of its own.
+// TakeOwnership settles a consigned product the same way when the retailer sells it to
+// a customer.
+func (s *SupplyChainContract) SettleConsignmentSale(ctx contractapi.TransactionContextInterface,
+	productID string) error {
+
+	if err := validateID("productID", productID); err != nil {
+		return err
+	}
+
+	caller, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to get caller identity: %v", err)
+	}
+	product, err := s.GetProduct(ctx, productID)
+	if err != nil {
+		return err
+	}
+	if !product.Consignment.active() {
+		return newError(ErrInvalidState, "product %s is not on consignment", productID)
+	}
+	if !product.Consignment.heldBy(caller) {
+		return newError(ErrPermissionDenied, "only %s, which holds product %s on consignment, can settle it", product.Consignment.Consignee, productID)
+	}
+	if product.Status != ProductStatusInStore {
+		return newError(ErrInvalidState, "product %s is %s, not %s", productID, product.Status, ProductStatusInStore)
+	}
+
+	if err := settleConsignment(ctx, product); err != nil {
+		return err
+	}
+	if err := putProduct(ctx, product); err != nil {
+		return err
+	}
+
+	return emitEvent(ctx, ChaincodeEvent{
+		EventType:  EventConsignmentSettled,
+		EntityType: EventEntityProduct,
+		EntityID:   productID,
+		Attributes: map[string]interface{}{
+			"consignor":  product.Consignment.Consignor,
+			"consignee":  product.Consignment.Consignee,
+			"transferId": product.Consignment.TransferID,
+		},
+	})
+}
@@ -0,0 +1,81 @@
+package contracts
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// TransactionContext is the context every contract function runs with. Besides the stub
+// and client identity, it buffers the transaction's changes to the dashboard counters so
+// each organization gets one delta per transaction, written by flushStats, and the
+// anomalies flagged so far for the transaction's event.
+type TransactionContext struct {
+	contractapi.TransactionContext
+	statsDeltas  map[string]*OrganizationStats // Counter change per organization
+	statsSources map[string]statsContribution  // Contribution of each record written, by key
+	anomalyIDs   []string                      // Flagged by flagAnomaly
+	indexEntries map[string][]string           // Composite index keys of each record written, by key
+}
+
+// The contracts create a TransactionContext per call and flush its counters after
+// every successful function; cross-contract calls share the caller's context.
+
+func (s *SupplyChainContract) GetTransactionContextHandler() contractapi.SettableTransactionContextInterface {
+	return new(TransactionContext)
+}
+
+func (s *SupplyChainContract) GetAfterTransaction() interface{} {
+	return flushStats
+}
+
+func (o *OwnershipContract) GetTransactionContextHandler() contractapi.SettableTransactionContextInterface {
+	return new(TransactionContext)
+}
+
+func (o *OwnershipContract) GetAfterTransaction() interface{} {
+	return flushStats
+}
+
+func (r *RoleManagementContract) GetTransactionContextHandler() contractapi.SettableTransactionContextInterface {
+	return new(TransactionContext)
+}
+
+func (r *RoleManagementContract) GetAfterTransaction() interface{} {
+	return flushStats
+}
+
+func (p *PrivacyContract) GetTransactionContextHandler() contractapi.SettableTransactionContextInterface {
+	return new(TransactionContext)
+}
+
+func (p *PrivacyContract) GetAfterTransaction() interface{} {
+	return flushStats
+}
+
+func (a *AdminContract) GetTransactionContextHandler() contractapi.SettableTransactionContextInterface {
+	return new(TransactionContext)
+}
+
+func (a *AdminContract) GetAfterTransaction() interface{} {
+	return flushStats
+}
+
+func (r *ResaleContract) GetTransactionContextHandler() contractapi.SettableTransactionContextInterface {
+	return new(TransactionContext)
+}
+
+func (r *ResaleContract) GetAfterTransaction() interface{} {
+	return flushStats
+}
+
+// txTime returns the timestamp of the current transaction, which is the same on every
+// endorser, for comparisons with expiry dates
+func txTime(ctx contractapi.TransactionContextInterface) (time.Time, error) {
+	timestamp, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to read transaction timestamp: %v", err)
+	}
+	return timestamp.AsTime(), nil
+}
@@ -0,0 +1,395 @@
+package contracts
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Counterfeit reports are stored as counterfeit_report_<reportID>. Open reports are
+// queued for the brand as counterfeit_queue_<reportedAt>_<reportID>, in report order,
+// and reports not dismissed are indexed by product type and location for the
+// hot-spot counts.
+const (
+	counterfeitReportKeyPrefix = "counterfeit_report_"
+	counterfeitQueueKeyPrefix  = "counterfeit_queue_"
+	counterfeitSpotIndex       = "counterfeit~spot" // productType, locationHint, reportID
+	maxCounterfeitQueuePage    = 100
+)
+
+// unknownProductType is the product type of a report whose serial number matches no batch
+const unknownProductType = "UNKNOWN"
+
+// Counterfeit report statuses
+const (
+	CounterfeitReportOpen      = "OPEN"
+	CounterfeitReportConfirmed = "CONFIRMED" // The brand found the item to be a counterfeit
+	CounterfeitReportDismissed = "DISMISSED" // Genuine, or not enough to go on
+)
+
+// CounterfeitReport is a suspected counterfeit reported by anyone who scanned or saw
+// it. The serial number is the one printed on the suspect item, so it may belong to a
+// genuine product or to none at all.
+type CounterfeitReport struct {
+	ReportID         string `json:"reportId"`
+	SerialNumber     string `json:"serialNumber"`
+	LocationHint     string `json:"locationHint"`                                    // Where the item was seen, e.g. a city or market
+	EvidenceHash     string `json:"evidenceHash"`                                    // e.g. IPFS hash of photos of the item
+	MatchedProductID string `json:"matchedProductId,omitempty" metadata:",optional"` // Genuine product carrying the serial number
+	ProductType      string `json:"productType"`                                     // From the serial's batch, or UNKNOWN
+	Status           string `json:"status"`
+	ReportedBy       string `json:"reportedBy"` // MSP ID of the submitting organization or gateway
+	ReportedAt       string `json:"reportedAt"`
+	TxID             string `json:"txId"`
+	ResolvedBy       string `json:"resolvedBy,omitempty" metadata:",optional"`
+	ResolvedAt       string `json:"resolvedAt,omitempty" metadata:",optional"`
+	ResolutionNote   string `json:"resolutionNote,omitempty" metadata:",optional"`
+	SchemaVersion    int    `json:"schemaVersion"`
+}
+
+// CounterfeitHotspot counts the open and confirmed counterfeit reports of a product
+// type at one location
+type CounterfeitHotspot struct {
+	ProductType  string `json:"productType"`
+	LocationHint string `json:"locationHint"`
+	Reports      int    `json:"reports"`
+}
+
+// counterfeitReportIndexKeys returns the hot-spot index key of a report, or none once
+// it is dismissed
+func counterfeitReportIndexKeys(stub shim.ChaincodeStubInterface, report *CounterfeitReport) ([]string, error) {
+	if report.Status == CounterfeitReportDismissed {
+		return nil, nil
+	}
+	key, err := stub.CreateCompositeKey(counterfeitSpotIndex, []string{report.ProductType, report.LocationHint, report.ReportID})
+	if err != nil {
+		return nil, err
+	}
+	return []string{key}, nil
+}
+
+func getCounterfeitReport(ctx contractapi.TransactionContextInterface, reportID string) (*CounterfeitReport, error) {
+	reportJSON, err := ctx.GetStub().GetState(counterfeitReportKeyPrefix + reportID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read counterfeit report: %v", err)
+	}
+	if reportJSON == nil {
+		return nil, newError(ErrNotFound, "counterfeit report %s does not exist", reportID)
+	}
+
+	var report CounterfeitReport
+	err = json.Unmarshal(reportJSON, &report)
+	if err != nil {
+		return nil, err
+	}
+	report.upgradeSchema()
+	return &report, nil
+}
+
+// putCounterfeitReport stores a report, keeps it in the brand's queue while it is open
+// and in the hot-spot index until it is dismissed
+func putCounterfeitReport(ctx contractapi.TransactionContextInterface, report *CounterfeitReport) error {
+	key := counterfeitReportKeyPrefix + report.ReportID
+	if err := trackCompositeIndex(ctx, key, report, counterfeitReportIndexKeys); err != nil {
+		return err
+	}
+	reportJSON, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState(key, reportJSON)
+	if err != nil {
+		return fmt.Errorf("failed to store counterfeit report: %v", err)
+	}
+
+	reportedAt, err := time.Parse(time.RFC3339, report.ReportedAt)
+	if err != nil {
+		return newError(ErrInvalidState, "counterfeit report %s has an invalid report time", report.ReportID)
+	}
+	queueKey := counterfeitQueueKeyPrefix + reportedAt.UTC().Format(ledgerLogTimeLayout) + "_" + report.ReportID
+	if report.Status == CounterfeitReportOpen {
+		// Empty values are deletes in Fabric, so the entry holds the report ID
+		err = ctx.GetStub().PutState(queueKey, []byte(report.ReportID))
+	} else {
+		err = ctx.GetStub().DelState(queueKey)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update the counterfeit report queue: %v", err)
+	}
+	return nil
+}
+
+// matchCounterfeitSerial looks up the genuine product and the product type behind a
+// reported serial number. Batch serial numbers are <batchID>-<uniqueIdentifier>, so a
+// made-up unique identifier still reveals the product type of a copied batch number.
+func matchCounterfeitSerial(ctx contractapi.TransactionContextInterface, serialNumber string) (string, string, error) {
+	separator := strings.LastIndex(serialNumber, "-")
+	if separator <= 0 {
+		return "", unknownProductType, nil
+	}
+	batchID, uniqueIdentifier := serialNumber[:separator], serialNumber[separator+1:]
+
+	supplyChain := &SupplyChainContract{}
+	batch, err := supplyChain.GetBatch(ctx, batchID)
+	if hasErrorCode(err, ErrNotFound) || hasErrorCode(err, ErrInvalidArgument) {
+		return "", unknownProductType, nil
+	}
+	if err != nil {
+		return "", "", err
+	}
+
+	productID := ""
+	if uniqueIdentifier != "" {
+		indexed, err := ctx.GetStub().GetState(productIdentifierKey(batchID, uniqueIdentifier))
+		if err != nil {
+			return "", "", fmt.Errorf("failed to read product identifier index: %v", err)
+		}
+		productID = string(indexed)
+	}
+	return productID, batch.ProductType, nil
+}
+
+// requireBrand rejects callers other than the brand (super admin)
+func requireBrand(ctx contractapi.TransactionContextInterface, action string) (string, error) {
+	caller, err := requireSuperAdmin(ctx)
+	if err != nil {
+		return "", err
+	}
+	return caller, nil
+}
+
+// ReportSuspectedCounterfeit records a suspected counterfeit for the brand to
+// investigate and returns the report ID. Anyone may report: serialNumber is the one on
+// the suspect item, locationHint where it was seen and evidenceHash the hash of photos
+// or other evidence kept off-chain.
+func (o *OwnershipContract) ReportSuspectedCounterfeit(ctx contractapi.TransactionContextInterface,
+	serialNumber string, locationHint string, evidenceHash string) (string, error) {
+
+	locationHint = strings.TrimSpace(locationHint)
+	if err := validateAll(
+		validateID("serialNumber", serialNumber),
+		validateName("locationHint", locationHint),
+		validateID("evidenceHash", evidenceHash),
+	); err != nil {
+		return "", err
+	}
+
+	caller, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return "", fmt.Errorf("failed to get caller identity: %v", err)
+	}
+	now, err := txTime(ctx)
+	if err != nil {
+		return "", err
+	}
+	matchedProductID, productType, err := matchCounterfeitSerial(ctx, serialNumber)
+	if err != nil {
+		return "", err
+	}
+
+	txID := ctx.GetStub().GetTxID()
+	digest := sha256.Sum256([]byte(txID + "|" + serialNumber))
+	report := &CounterfeitReport{
+		ReportID:         "CF-" + hex.EncodeToString(digest[:8]),
+		SerialNumber:     serialNumber,
+		LocationHint:     locationHint,
+		EvidenceHash:     evidenceHash,
+		MatchedProductID: matchedProductID,
+		ProductType:      productType,
+		Status:           CounterfeitReportOpen,
+		ReportedBy:       caller,
+		ReportedAt:       now.UTC().Format(time.RFC3339),
+		TxID:             txID,
+		SchemaVersion:    CurrentSchemaVersion,
+	}
+	if err := putCounterfeitReport(ctx, report); err != nil {
+		return "", err
+	}
+	if matchedProductID != "" {
+		logFor(ctx).Warn("counterfeit reported under a genuine serial number", "reportId", report.ReportID,
+			"serialNumber", serialNumber, "productId", matchedProductID)
+	}
+
+	err = emitEvent(ctx, ChaincodeEvent{
+		EventType:  EventCounterfeitReported,
+		EntityType: EventEntityCounterfeit,
+		EntityID:   report.ReportID,
+		ToState:    CounterfeitReportOpen,
+		Attributes: map[string]interface{}{
+			"serialNumber":     serialNumber,
+			"productType":      productType,
+			"locationHint":     locationHint,
+			"matchedProductId": matchedProductID,
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	return report.ReportID, nil
+}
+
+// GetCounterfeitReport returns a counterfeit report, for the brand and the reporter
+func (o *OwnershipContract) GetCounterfeitReport(ctx contractapi.TransactionContextInterface,
+	reportID string) (*CounterfeitReport, error) {
+
+	if err := validateID("reportID", reportID); err != nil {
+		return nil, err
+	}
+	report, err := getCounterfeitReport(ctx, reportID)
+	if err != nil {
+		return nil, err
+	}
+	caller, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get caller identity: %v", err)
+	}
+	if caller != report.ReportedBy {
+		if _, err := requireBrand(ctx, "read other organizations' counterfeit reports"); err != nil {
+			return nil, err
+		}
+	}
+	return report, nil
+}
+
+// ListCounterfeitReports returns up to 100 open counterfeit reports, oldest first. Only
+// the brand reads its investigation queue.
+func (o *OwnershipContract) ListCounterfeitReports(ctx contractapi.TransactionContextInterface) ([]*CounterfeitReport, error) {
+	if _, err := requireBrand(ctx, "read the counterfeit report queue"); err != nil {
+		return nil, err
+	}
+
+	resultsIterator, err := ctx.GetStub().GetStateByRange(counterfeitQueueKeyPrefix, counterfeitQueueKeyPrefix+"~")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query the counterfeit report queue: %v", err)
+	}
+	defer resultsIterator.Close()
+
+	reports := []*CounterfeitReport{}
+	for resultsIterator.HasNext() && len(reports) < maxCounterfeitQueuePage {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		report, err := getCounterfeitReport(ctx, string(queryResponse.Value))
+		if err != nil {
+			logFor(ctx).Warn("skipping unreadable queued counterfeit report", "key", queryResponse.Key, "error", err)
+			continue
+		}
+		reports = append(reports, report)
+	}
+
+	return reports, nil
+}
+
+// ResolveCounterfeitReport closes an open report as CONFIRMED or DISMISSED after the
+// brand's investigation. Dismissed reports no longer count towards the hot-spots.
+func (o *OwnershipContract) ResolveCounterfeitReport(ctx contractapi.TransactionContextInterface,
+	reportID string, decision string, note string) error {
+
+	if err := validateAll(
+		validateID("reportID", reportID),
+		validateEnum("decision", decision, CounterfeitReportConfirmed, CounterfeitReportDismissed),
+		validateRequired("note", note, maxTextLength),
+	); err != nil {
+		return err
+	}
+	caller, err := requireBrand(ctx, "resolve counterfeit reports")
+	if err != nil {
+		return err
+	}
+
+	report, err := getCounterfeitReport(ctx, reportID)
+	if err != nil {
+		return err
+	}
+	if report.Status != CounterfeitReportOpen {
+		return newError(ErrInvalidState, "counterfeit report %s is already %s", reportID, report.Status)
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	report.Status = decision
+	report.ResolvedBy = caller
+	report.ResolvedAt = now.UTC().Format(time.RFC3339)
+	report.ResolutionNote = note
+	if err := putCounterfeitReport(ctx, report); err != nil {
+		return err
+	}
+
+	return emitEvent(ctx, ChaincodeEvent{
+		EventType:  EventCounterfeitReportResolved,
+		EntityType: EventEntityCounterfeit,
+		EntityID:   reportID,
+		FromState:  CounterfeitReportOpen,
+		ToState:    decision,
+		Attributes: map[string]interface{}{
+			"serialNumber":     report.SerialNumber,
+			"productType":      report.ProductType,
+			"locationHint":     report.LocationHint,
+			"matchedProductId": report.MatchedProductID,
+		},
+	})
+}
+
+// GetCounterfeitHotspots counts the open and confirmed counterfeit reports by product
+// type and location, most reported first. An empty productType counts every type.
+// Only the brand reads them.
+func (o *OwnershipContract) GetCounterfeitHotspots(ctx contractapi.TransactionContextInterface,
+	productType string) ([]*CounterfeitHotspot, error) {
+
+	if err := validateText("productType", productType, maxNameLength); err != nil {
+		return nil, err
+	}
+	if _, err := requireBrand(ctx, "read counterfeit hot-spots"); err != nil {
+		return nil, err
+	}
+
+	attributes := []string{}
+	if productType != "" {
+		attributes = append(attributes, productType)
+	}
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(counterfeitSpotIndex, attributes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query index %s: %v", counterfeitSpotIndex, err)
+	}
+	defer resultsIterator.Close()
+
+	hotspots := []*CounterfeitHotspot{}
+	byKey := make(map[string]*CounterfeitHotspot)
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		_, keyAttributes, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+		if err != nil || len(keyAttributes) != 3 {
+			logFor(ctx).Warn("skipping invalid counterfeit index entry", "key", queryResponse.Key)
+			continue
+		}
+
+		spot := keyAttributes[0] + "\x00" + keyAttributes[1]
+		hotspot, ok := byKey[spot]
+		if !ok {
+			hotspot = &CounterfeitHotspot{ProductType: keyAttributes[0], LocationHint: keyAttributes[1]}
+			byKey[spot] = hotspot
+			hotspots = append(hotspots, hotspot)
+		}
+		hotspot.Reports++
+	}
+
+	// Entries come in key order, so equal counts keep type and location order
+	sort.SliceStable(hotspots, func(i, j int) bool {
+		return hotspots[i].Reports > hotspots[j].Reports
+	})
+	return hotspots, nil
+}
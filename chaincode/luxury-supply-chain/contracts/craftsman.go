@@ -0,0 +1,396 @@
+package contracts
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Craftsmen are registered per manufacturer as craftsman_<org>_<craftsmanID>. Each product
+// credited to a craftsman is indexed as crafted_<org>_<craftsmanID>_<productID>.
+const (
+	craftsmanKeyPrefix        = "craftsman_"
+	craftedProductKeyPrefix   = "crafted_"
+	maxCraftsmenPerProduct    = 10
+	productionTeamDescription = "%s Production Team" // Credited when a product names no craftsman
+)
+
+// Craftsman is an artisan registered by a manufacturer
+type Craftsman struct {
+	ID            string   `json:"id"`
+	Organization  string   `json:"organization"` // MSP ID of the manufacturer
+	Name          string   `json:"name"`
+	Atelier       string   `json:"atelier,omitempty" metadata:",optional"`
+	Specialties   []string `json:"specialties"` // e.g. saddle stitching, edge painting
+	IsActive      bool     `json:"isActive"`
+	RegisteredAt  string   `json:"registeredAt"`
+	UpdatedAt     string   `json:"updatedAt"`
+	SchemaVersion int      `json:"schemaVersion"`
+}
+
+// CraftsmanAnalytics summarizes the products credited to a craftsman
+type CraftsmanAnalytics struct {
+	CraftsmanID   string         `json:"craftsmanId"`
+	Organization  string         `json:"organization"`
+	Products      int            `json:"products"`
+	Batches       int            `json:"batches"`
+	ByProductType map[string]int `json:"byProductType"`
+	ByBrand       map[string]int `json:"byBrand"`
+	ByStatus      map[string]int `json:"byStatus"` // Current status, e.g. how many were returned
+}
+
+// craftsmanKey returns the ledger key of a registered craftsman
+func craftsmanKey(orgMSPID string, craftsmanID string) string {
+	return craftsmanKeyPrefix + orgMSPID + "_" + craftsmanID
+}
+
+// craftedProductKey returns the index key crediting a product to a craftsman
+func craftedProductKey(orgMSPID string, craftsmanID string, productID string) string {
+	return craftedProductKeyPrefix + orgMSPID + "_" + craftsmanID + "_" + productID
+}
+
+// craftsmanCredit describes craftsmen on a birth certificate, e.g. "Anna Rossi (Atelier Firenze)"
+func craftsmanCredit(craftsmen []*Craftsman) string {
+	credits := make([]string, len(craftsmen))
+	for i, craftsman := range craftsmen {
+		credits[i] = craftsman.Name
+		if craftsman.Atelier != "" {
+			credits[i] += " (" + craftsman.Atelier + ")"
+		}
+	}
+	return strings.Join(credits, ", ")
+}
+
+// RegisterCraftsman adds a craftsman to the calling manufacturer's registry. specialties
+// is comma-separated. Registering an existing craftsman updates and reactivates it.
+func (s *SupplyChainContract) RegisterCraftsman(ctx contractapi.TransactionContextInterface,
+	craftsmanID string, name string, atelier string, specialties string) error {
+
+	if err := validateAll(
+		validateID("craftsmanID", craftsmanID),
+		validateName("name", name),
+		validateText("atelier", atelier, maxNameLength),
+		validateText("specialties", specialties, maxTextLength),
+	); err != nil {
+		return err
+	}
+
+	manufacturer, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to get manufacturer identity: %v", err)
+	}
+
+	// CHECK PERMISSION - Only manufacturers maintain a craftsman registry
+	roleContract := &RoleManagementContract{}
+	hasPermission, err := roleContract.CheckPermission(ctx, manufacturer, "CREATE_BATCH")
+	if err != nil || !hasPermission {
+		return newError(ErrPermissionDenied, "caller %s does not have permission to register craftsmen", manufacturer)
+	}
+
+	txNow, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	now := txNow.UTC().Format(time.RFC3339)
+	existing, err := ctx.GetStub().GetState(craftsmanKey(manufacturer, craftsmanID))
+	if err != nil {
+		return fmt.Errorf("failed to read craftsman: %v", err)
+	}
+	craftsman := &Craftsman{
+		ID:           craftsmanID,
+		Organization: manufacturer,
+		RegisteredAt: now,
+	}
+	if existing != nil {
+		if err := json.Unmarshal(existing, craftsman); err != nil {
+			return err
+		}
+	}
+	craftsman.Name = name
+	craftsman.Atelier = atelier
+	craftsman.Specialties = []string{}
+	for _, specialty := range strings.Split(specialties, ",") {
+		if specialty = strings.TrimSpace(specialty); specialty != "" {
+			craftsman.Specialties = append(craftsman.Specialties, specialty)
+		}
+	}
+	craftsman.IsActive = true
+	craftsman.UpdatedAt = now
+	craftsman.SchemaVersion = CurrentSchemaVersion
+
+	if err := putCraftsman(ctx, craftsman); err != nil {
+		return err
+	}
+
+	return emitEvent(ctx, ChaincodeEvent{
+		EventType:  EventCraftsmanRegistered,
+		EntityType: EventEntityCraftsman,
+		EntityID:   craftsmanID,
+		Attributes: map[string]interface{}{
+			"organization": manufacturer,
+			"atelier":      atelier,
+		},
+	})
+}
+
+// DeactivateCraftsman stops crediting a craftsman in new batches, e.g. after they
+// left the manufacturer. Products already credited keep the attribution.
+func (s *SupplyChainContract) DeactivateCraftsman(ctx contractapi.TransactionContextInterface,
+	craftsmanID string) error {
+
+	if err := validateID("craftsmanID", craftsmanID); err != nil {
+		return err
+	}
+
+	manufacturer, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to get manufacturer identity: %v", err)
+	}
+
+	roleContract := &RoleManagementContract{}
+	hasPermission, err := roleContract.CheckPermission(ctx, manufacturer, "CREATE_BATCH")
+	if err != nil || !hasPermission {
+		return newError(ErrPermissionDenied, "caller %s does not have permission to deactivate craftsmen", manufacturer)
+	}
+
+	craftsman, err := getCraftsman(ctx, manufacturer, craftsmanID)
+	if err != nil {
+		return err
+	}
+	if !craftsman.IsActive {
+		return newError(ErrInvalidState, "craftsman %s is already inactive", craftsmanID)
+	}
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	craftsman.IsActive = false
+	craftsman.UpdatedAt = now.UTC().Format(time.RFC3339)
+
+	if err := putCraftsman(ctx, craftsman); err != nil {
+		return err
+	}
+
+	return emitEvent(ctx, ChaincodeEvent{
+		EventType:  EventCraftsmanDeactivated,
+		EntityType: EventEntityCraftsman,
+		EntityID:   craftsmanID,
+		Attributes: map[string]interface{}{
+			"organization": manufacturer,
+		},
+	})
+}
+
+// GetCraftsman returns a craftsman registered by a manufacturer
+func (s *SupplyChainContract) GetCraftsman(ctx contractapi.TransactionContextInterface,
+	orgMSPID string, craftsmanID string) (*Craftsman, error) {
+
+	if err := validateAll(
+		validateID("orgMSPID", orgMSPID),
+		validateID("craftsmanID", craftsmanID),
+	); err != nil {
+		return nil, err
+	}
+
+	return getCraftsman(ctx, orgMSPID, craftsmanID)
+}
+
+// GetCraftsmen returns a manufacturer's craftsman registry, including inactive craftsmen
+func (s *SupplyChainContract) GetCraftsmen(ctx contractapi.TransactionContextInterface,
+	orgMSPID string) ([]*Craftsman, error) {
+
+	if err := validateID("orgMSPID", orgMSPID); err != nil {
+		return nil, err
+	}
+
+	prefix := craftsmanKey(orgMSPID, "")
+	resultsIterator, err := ctx.GetStub().GetStateByRange(prefix, prefix+"~")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query craftsmen: %v", err)
+	}
+	defer resultsIterator.Close()
+
+	craftsmen := []*Craftsman{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var craftsman Craftsman
+		err = json.Unmarshal(queryResponse.Value, &craftsman)
+		if err != nil {
+			return nil, err
+		}
+		craftsman.upgradeSchema()
+		craftsmen = append(craftsmen, &craftsman)
+	}
+
+	return craftsmen, nil
+}
+
+// GetCraftsmanAnalytics counts the products credited to a craftsman by batch, product
+// type, brand and current status
+func (s *SupplyChainContract) GetCraftsmanAnalytics(ctx contractapi.TransactionContextInterface,
+	orgMSPID string, craftsmanID string) (*CraftsmanAnalytics, error) {
+
+	craftsman, err := s.GetCraftsman(ctx, orgMSPID, craftsmanID)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := craftedProductKey(orgMSPID, craftsmanID, "")
+	resultsIterator, err := ctx.GetStub().GetStateByRange(prefix, prefix+"~")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query crafted products: %v", err)
+	}
+	defer resultsIterator.Close()
+
+	analytics := &CraftsmanAnalytics{
+		CraftsmanID:   craftsman.ID,
+		Organization:  craftsman.Organization,
+		ByProductType: make(map[string]int),
+		ByBrand:       make(map[string]int),
+		ByStatus:      make(map[string]int),
+	}
+	batches := make(map[string]bool)
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		product, err := s.GetProduct(ctx, string(queryResponse.Value))
+		if err != nil {
+			logFor(ctx).Warn("skipping unreadable crafted product", "key", queryResponse.Key, "error", err)
+			continue
+		}
+		analytics.Products++
+		batches[product.BatchID] = true
+		analytics.ByProductType[product.Type]++
+		analytics.ByBrand[product.Brand]++
+		analytics.ByStatus[string(product.Status)]++
+	}
+	analytics.Batches = len(batches)
+
+	return analytics, nil
+}
+
+// getCraftsman reads a registered craftsman
+func getCraftsman(ctx contractapi.TransactionContextInterface, orgMSPID string, craftsmanID string) (*Craftsman, error) {
+	craftsmanJSON, err := ctx.GetStub().GetState(craftsmanKey(orgMSPID, craftsmanID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read craftsman: %v", err)
+	}
+	if craftsmanJSON == nil {
+		return nil, newError(ErrNotFound, "craftsman %s is not registered by %s", craftsmanID, orgMSPID)
+	}
+
+	var craftsman Craftsman
+	err = json.Unmarshal(craftsmanJSON, &craftsman)
+	if err != nil {
+		return nil, err
+	}
+	craftsman.upgradeSchema()
+
+	return &craftsman, nil
+}
+
+// putCraftsman stores a craftsman record
+func putCraftsman(ctx contractapi.TransactionContextInterface, craftsman *Craftsman) error {
+	craftsmanJSON, err := json.Marshal(craftsman)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState(craftsmanKey(craftsman.Organization, craftsman.ID), craftsmanJSON)
+	if err != nil {
+		return fmt.Errorf("failed to store craftsman: %v", err)
+	}
+	return nil
+}
+
+// parseCraftsmenAttribution resolves the craftsmen credited for each of count products.
+// craftsmenJSON is a JSON array with one list of craftsman IDs per product, e.g.
+// [["C-001"],["C-001","C-007"]]. An empty list, or an empty craftsmenJSON for all
+// products, credits the manufacturer's production team.
+func parseCraftsmenAttribution(ctx contractapi.TransactionContextInterface,
+	manufacturer string, craftsmenJSON string, count int) ([][]*Craftsman, error) {
+
+	var craftsmanIDs [][]string
+	if craftsmenJSON != "" {
+		if err := validateJSON("craftsmen", craftsmenJSON, &craftsmanIDs); err != nil {
+			return nil, err
+		}
+		if craftsmanIDs == nil {
+			craftsmanIDs = [][]string{}
+		}
+	}
+	return attributeCraftsmen(ctx, manufacturer, craftsmanIDs, count)
+}
+
+// attributeCraftsmen resolves the craftsmen credited for each of count products from
+// one list of craftsman IDs per product. Nil craftsmanIDs credits the production team
+// for all products.
+func attributeCraftsmen(ctx contractapi.TransactionContextInterface,
+	manufacturer string, craftsmanIDs [][]string, count int) ([][]*Craftsman, error) {
+
+	attribution := make([][]*Craftsman, count)
+	if craftsmanIDs == nil {
+		return attribution, nil
+	}
+	if len(craftsmanIDs) != count {
+		return nil, newError(ErrInvalidArgument, "craftsmen lists %d products, expected %d", len(craftsmanIDs), count)
+	}
+
+	registry := make(map[string]*Craftsman)
+	for i, ids := range craftsmanIDs {
+		if len(ids) > maxCraftsmenPerProduct {
+			return nil, newError(ErrInvalidArgument, "at most %d craftsmen can be credited per product", maxCraftsmenPerProduct)
+		}
+		for _, id := range ids {
+			craftsman, ok := registry[id]
+			if !ok {
+				if err := validateID("craftsman id", id); err != nil {
+					return nil, err
+				}
+				var err error
+				craftsman, err = getCraftsman(ctx, manufacturer, id)
+				if err != nil {
+					return nil, err
+				}
+				if !craftsman.IsActive {
+					return nil, newError(ErrInvalidState, "craftsman %s is inactive", id)
+				}
+				registry[id] = craftsman
+			}
+			attribution[i] = append(attribution[i], craftsman)
+		}
+	}
+
+	return attribution, nil
+}
+
+// creditCraftsmen records the craftsmen of a product on its birth certificate and in
+// their crafted-product indexes
+func creditCraftsmen(ctx contractapi.TransactionContextInterface, manufacturer string,
+	certificate *DigitalBirthCertificate, craftsmen []*Craftsman) error {
+
+	if len(craftsmen) == 0 {
+		certificate.Craftsman = fmt.Sprintf(productionTeamDescription, manufacturer)
+		return nil
+	}
+
+	certificate.Craftsman = craftsmanCredit(craftsmen)
+	for _, craftsman := range craftsmen {
+		certificate.CraftsmanIDs = append(certificate.CraftsmanIDs, craftsman.ID)
+		key := craftedProductKey(manufacturer, craftsman.ID, certificate.ProductID)
+		err := ctx.GetStub().PutState(key, []byte(certificate.ProductID))
+		if err != nil {
+			return fmt.Errorf("failed to index crafted product: %v", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,294 @@
+package contracts
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// currencyConfigKey holds the CurrencyConfig
+const currencyConfigKey = "config_currencies"
+
+// Amounts are decimal strings so values convert without float rounding
+var (
+	currencyPattern = regexp.MustCompile(`^[A-Z]{3}$`)
+	amountPattern   = regexp.MustCompile(`^[0-9]{1,15}(\.[0-9]{1,4})?$`)
+)
+
+// CurrencyConfig lists the currencies values may be declared in and the currency
+// they are converted to for reporting
+type CurrencyConfig struct {
+	Allowed           []string `json:"allowed"` // ISO 4217 codes
+	ReportingCurrency string   `json:"reportingCurrency"`
+	UpdatedBy         string   `json:"updatedBy,omitempty" metadata:",optional"`
+	UpdatedAt         string   `json:"updatedAt,omitempty" metadata:",optional"`
+	SchemaVersion     int      `json:"schemaVersion"`
+}
+
+// FXSnapshot is the oracle rate a declared value was converted at. The pair is the
+// oracle's FX_RATE data key; Rate is units of the second currency per unit of the first.
+type FXSnapshot struct {
+	Pair        string `json:"pair"` // e.g. USD-EUR
+	Rate        string `json:"rate"`
+	ObservedAt  string `json:"observedAt"`
+	OracleID    string `json:"oracleId"`
+	PayloadHash string `json:"payloadHash"` // Of the oracle's signed message, see ReferenceData
+}
+
+// DeclaredValue is the value a sender declares for goods, e.g. for insurance and customs
+type DeclaredValue struct {
+	Amount            string      `json:"amount"` // Decimal string, e.g. 12500.00
+	Currency          string      `json:"currency"`
+	ReportingAmount   string      `json:"reportingAmount,omitempty" metadata:",optional"` // Rounded to 2 decimals
+	ReportingCurrency string      `json:"reportingCurrency"`
+	FXSnapshot        *FXSnapshot `json:"fxSnapshot,omitempty" metadata:",optional"` // Nil when no conversion was needed or no rate was available
+	DeclaredBy        string      `json:"declaredBy"`
+	DeclaredAt        string      `json:"declaredAt"`
+}
+
+// defaultCurrencyConfig applies until a super admin sets the currencies
+func defaultCurrencyConfig() *CurrencyConfig {
+	return &CurrencyConfig{
+		Allowed:           []string{"EUR", "USD", "GBP", "CHF", "JPY", "CNY", "HKD", "SGD", "AED"},
+		ReportingCurrency: "EUR",
+		SchemaVersion:     CurrentSchemaVersion,
+	}
+}
+
+// getCurrencyConfig reads the stored CurrencyConfig, falling back to the defaults
+func getCurrencyConfig(ctx contractapi.TransactionContextInterface) (*CurrencyConfig, error) {
+	configJSON, err := ctx.GetStub().GetState(currencyConfigKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read currency config: %v", err)
+	}
+	if configJSON == nil {
+		return defaultCurrencyConfig(), nil
+	}
+
+	var config CurrencyConfig
+	err = json.Unmarshal(configJSON, &config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse currency config: %v", err)
+	}
+	config.upgradeSchema()
+
+	return &config, nil
+}
+
+// checkCurrency returns an error unless currency is allowed
+func (c *CurrencyConfig) checkCurrency(field string, currency string) error {
+	for _, allowed := range c.Allowed {
+		if currency == allowed {
+			return nil
+		}
+	}
+	return newError(ErrInvalidArgument, "%s %q is not an allowed currency, must be one of %s",
+		field, currency, strings.Join(c.Allowed, ", "))
+}
+
+// validateAmount checks a decimal amount greater than zero
+func validateAmount(field string, value string) error {
+	if !amountPattern.MatchString(value) || strings.Trim(value, "0.") == "" {
+		return newError(ErrInvalidArgument, "%s must be a positive decimal number, got %q", field, value)
+	}
+	return nil
+}
+
+// newDeclaredValue validates a declared value and converts it to the reporting currency
+// at the latest oracle rate, quoted either way round
+func newDeclaredValue(ctx contractapi.TransactionContextInterface,
+	amount string, currency string, declaredBy string) (*DeclaredValue, error) {
+
+	config, err := getCurrencyConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateAll(
+		validateAmount("amount", amount),
+		config.checkCurrency("currency", currency),
+	); err != nil {
+		return nil, err
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+	value := &DeclaredValue{
+		Amount:            amount,
+		Currency:          currency,
+		ReportingCurrency: config.ReportingCurrency,
+		DeclaredBy:        declaredBy,
+		DeclaredAt:        now.UTC().Format(time.RFC3339),
+	}
+
+	converted, _ := new(big.Rat).SetString(amount)
+	if currency == config.ReportingCurrency {
+		value.ReportingAmount = converted.FloatString(2)
+		return value, nil
+	}
+
+	for _, inverted := range []bool{false, true} {
+		pair := currency + "-" + config.ReportingCurrency
+		if inverted {
+			pair = config.ReportingCurrency + "-" + currency
+		}
+		rate, err := getReferenceData(ctx, OracleDataFXRate, pair)
+		if err != nil {
+			return nil, err
+		}
+		if rate == nil {
+			continue
+		}
+		rateValue, ok := new(big.Rat).SetString(rate.Value)
+		if !ok || rateValue.Sign() <= 0 {
+			return nil, newError(ErrInvalidState, "oracle rate %s for %s is not a positive number", rate.Value, pair)
+		}
+
+		if inverted {
+			converted.Quo(converted, rateValue)
+		} else {
+			converted.Mul(converted, rateValue)
+		}
+		value.ReportingAmount = converted.FloatString(2)
+		value.FXSnapshot = &FXSnapshot{
+			Pair:        pair,
+			Rate:        rate.Value,
+			ObservedAt:  rate.ObservedAt,
+			OracleID:    rate.OracleID,
+			PayloadHash: rate.PayloadHash,
+		}
+		break
+	}
+
+	return value, nil
+}
+
+// SetCurrencyConfig replaces the comma-separated allowed currencies and the reporting
+// currency, which must be one of them. Values declared earlier are kept.
+func (a *AdminContract) SetCurrencyConfig(ctx contractapi.TransactionContextInterface,
+	allowedCurrencies string, reportingCurrency string) error {
+
+	if err := validateRequired("allowedCurrencies", allowedCurrencies, maxTextLength); err != nil {
+		return err
+	}
+	allowed := strings.Split(allowedCurrencies, ",")
+	for i := range allowed {
+		allowed[i] = strings.TrimSpace(allowed[i])
+		if !currencyPattern.MatchString(allowed[i]) {
+			return newError(ErrInvalidArgument, "currency %q must be a 3-letter ISO 4217 code", allowed[i])
+		}
+	}
+
+	config := &CurrencyConfig{
+		Allowed:           allowed,
+		ReportingCurrency: reportingCurrency,
+		SchemaVersion:     CurrentSchemaVersion,
+	}
+	if err := config.checkCurrency("reportingCurrency", reportingCurrency); err != nil {
+		return err
+	}
+
+	caller, err := requireSuperAdmin(ctx)
+	if err != nil {
+		return err
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	config.UpdatedBy = caller
+	config.UpdatedAt = now.UTC().Format(time.RFC3339)
+	configJSON, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState(currencyConfigKey, configJSON)
+	if err != nil {
+		return fmt.Errorf("failed to store currency config: %v", err)
+	}
+
+	return emitEvent(ctx, ChaincodeEvent{
+		EventType:  EventCurrencyConfigUpdated,
+		EntityType: EventEntityConfig,
+		EntityID:   currencyConfigKey,
+		Attributes: map[string]interface{}{
+			"allowed":           strings.Join(allowed, ","),
+			"reportingCurrency": reportingCurrency,
+		},
+	})
+}
+
+// GetCurrencyConfig returns the allowed currencies and the reporting currency
+func (a *AdminContract) GetCurrencyConfig(ctx contractapi.TransactionContextInterface) (*CurrencyConfig, error) {
+	return getCurrencyConfig(ctx)
+}
+
+// SetDeclaredValue records the value the sender declares for the goods of a transfer,
+// converted to the reporting currency at the latest oracle rate. It can be replaced
+// until the transfer is sent.
+func (s *SupplyChainContract) SetDeclaredValue(ctx contractapi.TransactionContextInterface,
+	transferID string, amount string, currency string) error {
+
+	_, err := s.declareTransferValue(ctx, transferID, amount, currency)
+	return err
+}
+
+// declareTransferValue implements SetDeclaredValue and returns the updated transfer,
+// since a transaction does not read its own writes
+func (s *SupplyChainContract) declareTransferValue(ctx contractapi.TransactionContextInterface,
+	transferID string, amount string, currency string) (*Transfer, error) {
+
+	if err := validateID("transferID", transferID); err != nil {
+		return nil, err
+	}
+
+	transfer, err := s.GetTransfer(ctx, transferID)
+	if err != nil {
+		return nil, err
+	}
+
+	sender, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get sender identity: %v", err)
+	}
+	if transfer.From != sender {
+		return nil, newError(ErrPermissionDenied, "only the sender can declare the value of a transfer")
+	}
+	if transfer.Status != TransferStatusInitiated {
+		return nil, newError(ErrInvalidState, "the value must be declared before the transfer is sent, status is %s", transfer.Status)
+	}
+
+	value, err := newDeclaredValue(ctx, amount, currency, sender)
+	if err != nil {
+		return nil, err
+	}
+	transfer.DeclaredValue = value
+
+	err = putTransfer(ctx, transfer)
+	if err != nil {
+		return nil, err
+	}
+
+	attributes := map[string]interface{}{
+		"amount":            amount,
+		"currency":          currency,
+		"reportingCurrency": value.ReportingCurrency,
+	}
+	if value.ReportingAmount != "" {
+		attributes["reportingAmount"] = value.ReportingAmount
+	}
+
+	return transfer, emitEvent(ctx, ChaincodeEvent{
+		EventType:  EventDeclaredValueSet,
+		EntityType: EventEntityTransfer,
+		EntityID:   transferID,
+		Attributes: attributes,
+	})
+}
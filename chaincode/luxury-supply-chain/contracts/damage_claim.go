@@ -0,0 +1,425 @@
+package contracts
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Damage claims are stored as damage_claim_<claimID>
+const (
+	damageClaimKeyPrefix = "damage_claim_"
+	maxDamagePhotos      = 20
+)
+
+// Damage claim statuses
+const (
+	DamageClaimOpen      = "OPEN"
+	DamageClaimResponded = "RESPONDED" // The carrier has answered
+	DamageClaimResolved  = "RESOLVED"
+)
+
+// Damage claim resolutions
+const (
+	DamageResolutionRepair   = "REPAIR"    // Damaged products go back to production for repair
+	DamageResolutionReplace  = "REPLACE"   // Damaged products are destroyed and replaced by the sender
+	DamageResolutionWriteOff = "WRITE_OFF" // Damaged products are destroyed
+	DamageResolutionRejected = "REJECTED"  // The carrier is not liable
+)
+
+// DamageClaim is a receiver's claim against the carrier for goods damaged in transit
+type DamageClaim struct {
+	ClaimID              string   `json:"claimId"`
+	TransferID           string   `json:"transferId"`
+	Sender               string   `json:"sender"`
+	Receiver             string   `json:"receiver"`
+	Carrier              string   `json:"carrier"`
+	ProductIDs           []string `json:"productIds"` // Damaged products of the transfer
+	Description          string   `json:"description"`
+	PhotoHashes          []string `json:"photoHashes"` // e.g. IPFS hashes
+	Status               string   `json:"status"`
+	CarrierResponse      string   `json:"carrierResponse,omitempty" metadata:",optional"`
+	Resolution           string   `json:"resolution,omitempty" metadata:",optional"`
+	ReplacementProductID string   `json:"replacementProductId,omitempty" metadata:",optional"` // For REPLACE, when known
+	TrustEvent           string   `json:"trustEvent,omitempty" metadata:",optional"`           // Reported to consensus for the carrier
+	OpenedAt             string   `json:"openedAt"`
+	RespondedAt          string   `json:"respondedAt,omitempty" metadata:",optional"`
+	ResolvedBy           string   `json:"resolvedBy,omitempty" metadata:",optional"`
+	ResolvedAt           string   `json:"resolvedAt,omitempty" metadata:",optional"`
+	SchemaVersion        int      `json:"schemaVersion"`
+}
+
+// damageReport is the receiver's input to ConfirmReceivedWithDamage
+type damageReport struct {
+	ProductIDs  []string `json:"productIds"`
+	Description string   `json:"description"`
+	PhotoHashes []string `json:"photoHashes"`
+}
+
+// getDamageClaim reads a damage claim
+func getDamageClaim(ctx contractapi.TransactionContextInterface, claimID string) (*DamageClaim, error) {
+	claimJSON, err := ctx.GetStub().GetState(damageClaimKeyPrefix + claimID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read damage claim: %v", err)
+	}
+	if claimJSON == nil {
+		return nil, newError(ErrNotFound, "damage claim %s does not exist", claimID)
+	}
+
+	var claim DamageClaim
+	err = json.Unmarshal(claimJSON, &claim)
+	if err != nil {
+		return nil, err
+	}
+	claim.upgradeSchema()
+
+	return &claim, nil
+}
+
+// putDamageClaim stores a damage claim
+func putDamageClaim(ctx contractapi.TransactionContextInterface, claim *DamageClaim) error {
+	claimJSON, err := json.Marshal(claim)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState(damageClaimKeyPrefix+claim.ClaimID, claimJSON)
+	if err != nil {
+		return fmt.Errorf("failed to store damage claim: %v", err)
+	}
+	return nil
+}
+
+// transferProductIDs returns the products moved by a transfer
+func (s *SupplyChainContract) transferProductIDs(ctx contractapi.TransactionContextInterface,
+	transfer *Transfer) ([]string, error) {
+
+	if transfer.Metadata != nil {
+		if batchType, ok := transfer.Metadata["type"].(string); ok && batchType == "BATCH" {
+			batch, err := s.GetBatch(ctx, transfer.ProductID)
+			if err != nil {
+				return nil, err
+			}
+			return batch.ProductIDs, nil
+		}
+	}
+	return []string{transfer.ProductID}, nil
+}
+
+// ConfirmReceivedWithDamage confirms receipt of a transfer and opens a damage claim
+// against the carrier that delivered it. damageJSON lists the damaged productIds, a
+// description and the photoHashes. The claim's event replaces TransferCompleted.
+func (s *SupplyChainContract) ConfirmReceivedWithDamage(ctx contractapi.TransactionContextInterface,
+	transferID string, claimID string, carrierMSPID string, damageJSON string) error {
+
+	var report damageReport
+	if err := validateAll(
+		validateID("transferID", transferID),
+		validateID("claimID", claimID),
+		validateID("carrierMSPID", carrierMSPID),
+		validateJSON("damage", damageJSON, &report),
+	); err != nil {
+		return err
+	}
+	if err := validateRequired("description", report.Description, maxTextLength); err != nil {
+		return err
+	}
+	if len(report.PhotoHashes) == 0 || len(report.PhotoHashes) > maxDamagePhotos {
+		return newError(ErrInvalidArgument, "a damage claim needs between 1 and %d photos", maxDamagePhotos)
+	}
+	for _, hash := range report.PhotoHashes {
+		if err := validateID("photo hash", hash); err != nil {
+			return err
+		}
+	}
+
+	existing, err := ctx.GetStub().GetState(damageClaimKeyPrefix + claimID)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return newError(ErrAlreadyExists, "damage claim %s already exists", claimID)
+	}
+
+	if err := checkCarrier(ctx, carrierMSPID); err != nil {
+		return err
+	}
+
+	// Read the transfer before the receipt changes it; a transaction does not read its own writes
+	transfer, err := s.GetTransfer(ctx, transferID)
+	if err != nil {
+		return err
+	}
+	if transfer.ConsensusDetails.Carrier != "" && transfer.ConsensusDetails.Carrier != carrierMSPID {
+		return newError(ErrInvalidArgument, "transfer %s was handed off to carrier %s", transferID, transfer.ConsensusDetails.Carrier)
+	}
+	transferred, err := s.transferProductIDs(ctx, transfer)
+	if err != nil {
+		return err
+	}
+	inTransfer := make(map[string]bool, len(transferred))
+	for _, productID := range transferred {
+		inTransfer[productID] = true
+	}
+	if len(report.ProductIDs) == 0 {
+		return newError(ErrInvalidArgument, "at least one damaged product is required")
+	}
+	for _, productID := range report.ProductIDs {
+		if !inTransfer[productID] {
+			return newError(ErrInvalidArgument, "product %s is not part of transfer %s", productID, transferID)
+		}
+	}
+
+	// Checks the caller is the receiver and completes the transfer
+	err = s.ConfirmReceived(ctx, transferID)
+	if err != nil {
+		return err
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	claim := &DamageClaim{
+		ClaimID:       claimID,
+		TransferID:    transferID,
+		Sender:        transfer.From,
+		Receiver:      transfer.To,
+		Carrier:       carrierMSPID,
+		ProductIDs:    report.ProductIDs,
+		Description:   report.Description,
+		PhotoHashes:   report.PhotoHashes,
+		Status:        DamageClaimOpen,
+		OpenedAt:      now.UTC().Format(time.RFC3339),
+		SchemaVersion: CurrentSchemaVersion,
+	}
+	err = putDamageClaim(ctx, claim)
+	if err != nil {
+		return err
+	}
+
+	return emitEvent(ctx, ChaincodeEvent{
+		EventType:  EventDamageClaimOpened,
+		EntityType: EventEntityTransfer,
+		EntityID:   transferID,
+		ToState:    string(TransferStatusCompleted),
+		Attributes: map[string]interface{}{
+			"claimId":  claimID,
+			"carrier":  carrierMSPID,
+			"products": len(report.ProductIDs),
+		},
+	})
+}
+
+// RespondToDamageClaim records the carrier's answer to a claim, e.g. accepting
+// liability or disputing it, before the brand resolves it
+func (s *SupplyChainContract) RespondToDamageClaim(ctx contractapi.TransactionContextInterface,
+	claimID string, response string) error {
+
+	if err := validateAll(
+		validateID("claimID", claimID),
+		validateRequired("response", response, maxTextLength),
+	); err != nil {
+		return err
+	}
+
+	caller, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to get caller identity: %v", err)
+	}
+
+	roleContract := &RoleManagementContract{}
+	hasPermission, err := roleContract.CheckPermission(ctx, caller, "RESPOND_DAMAGE_CLAIM")
+	if err != nil || !hasPermission {
+		return newError(ErrPermissionDenied, "caller %s does not have permission to respond to damage claims", caller)
+	}
+
+	claim, err := getDamageClaim(ctx, claimID)
+	if err != nil {
+		return err
+	}
+	if claim.Carrier != caller {
+		return newError(ErrPermissionDenied, "damage claim %s is against %s", claimID, claim.Carrier)
+	}
+	if claim.Status == DamageClaimResolved {
+		return newError(ErrInvalidState, "damage claim %s is already resolved", claimID)
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	previousStatus := claim.Status
+	claim.CarrierResponse = response
+	claim.RespondedAt = now.UTC().Format(time.RFC3339)
+	claim.Status = DamageClaimResponded
+	err = putDamageClaim(ctx, claim)
+	if err != nil {
+		return err
+	}
+
+	return emitEvent(ctx, ChaincodeEvent{
+		EventType:  EventDamageClaimResponded,
+		EntityType: EventEntityTransfer,
+		EntityID:   claim.TransferID,
+		FromState:  previousStatus,
+		ToState:    claim.Status,
+		Attributes: map[string]interface{}{
+			"claimId": claimID,
+			"carrier": claim.Carrier,
+		},
+	})
+}
+
+// ResolveDamageClaim settles a claim. REPAIR moves the damaged products back to
+// production, REPLACE and WRITE_OFF destroy them, and all three lower the carrier's
+// trust score in consensus. REJECTED leaves the products and the carrier unchanged.
+func (s *SupplyChainContract) ResolveDamageClaim(ctx contractapi.TransactionContextInterface,
+	claimID string, resolution string, replacementProductID string) error {
+
+	if err := validateAll(
+		validateID("claimID", claimID),
+		validateEnum("resolution", resolution, DamageResolutionRepair, DamageResolutionReplace,
+			DamageResolutionWriteOff, DamageResolutionRejected),
+	); err != nil {
+		return err
+	}
+	if replacementProductID != "" {
+		if resolution != DamageResolutionReplace {
+			return newError(ErrInvalidArgument, "a replacement product only applies to %s", DamageResolutionReplace)
+		}
+		if err := validateID("replacementProductID", replacementProductID); err != nil {
+			return err
+		}
+	}
+
+	caller, err := requireSuperAdmin(ctx)
+	if err != nil {
+		return err
+	}
+
+	claim, err := getDamageClaim(ctx, claimID)
+	if err != nil {
+		return err
+	}
+	if claim.Status == DamageClaimResolved {
+		return newError(ErrInvalidState, "damage claim %s is already resolved as %s", claimID, claim.Resolution)
+	}
+
+	var status ProductStatus
+	switch resolution {
+	case DamageResolutionRepair:
+		status = ProductStatusInProduction
+		claim.TrustEvent = "TRANSIT_DAMAGE"
+	case DamageResolutionReplace, DamageResolutionWriteOff:
+		status = ProductStatusDestroyed
+		claim.TrustEvent = "TRANSIT_LOSS"
+	}
+
+	if status != "" {
+		for _, productID := range claim.ProductIDs {
+			product, err := s.GetProduct(ctx, productID)
+			if err != nil {
+				return err
+			}
+			if product.CurrentOwner != claim.Receiver {
+				return newError(ErrInvalidState, "product %s is no longer held by %s", productID, claim.Receiver)
+			}
+			if err := setProductStatus(product, status); err != nil {
+				return err
+			}
+			if err := putProduct(ctx, product); err != nil {
+				return err
+			}
+		}
+
+		consensus, err := LoadConsensusIntegration(ctx)
+		if err != nil {
+			return err
+		}
+		err = consensus.ReportTrustEvent(ctx, claim.Carrier, claim.TrustEvent)
+		if err != nil {
+			return err
+		}
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	previousStatus := claim.Status
+	claim.Status = DamageClaimResolved
+	claim.Resolution = resolution
+	claim.ReplacementProductID = replacementProductID
+	claim.ResolvedBy = caller
+	claim.ResolvedAt = now.UTC().Format(time.RFC3339)
+	err = putDamageClaim(ctx, claim)
+	if err != nil {
+		return err
+	}
+
+	event := ChaincodeEvent{
+		EventType:  EventDamageClaimResolved,
+		EntityType: EventEntityTransfer,
+		EntityID:   claim.TransferID,
+		FromState:  previousStatus,
+		ToState:    claim.Status,
+		Attributes: map[string]interface{}{
+			"claimId":    claimID,
+			"carrier":    claim.Carrier,
+			"resolution": resolution,
+		},
+	}
+	if claim.TrustEvent != "" {
+		event.Attributes["trustEvent"] = claim.TrustEvent
+	}
+	return emitEvent(ctx, event)
+}
+
+// GetDamageClaim returns a damage claim
+func (s *SupplyChainContract) GetDamageClaim(ctx contractapi.TransactionContextInterface,
+	claimID string) (*DamageClaim, error) {
+
+	if err := validateID("claimID", claimID); err != nil {
+		return nil, err
+	}
+
+	return getDamageClaim(ctx, claimID)
+}
+
+// GetDamageClaimsByCarrier returns the damage claims against a carrier
+func (s *SupplyChainContract) GetDamageClaimsByCarrier(ctx contractapi.TransactionContextInterface,
+	carrierMSPID string) ([]*DamageClaim, error) {
+
+	if err := validateID("carrierMSPID", carrierMSPID); err != nil {
+		return nil, err
+	}
+
+	resultsIterator, err := ctx.GetStub().GetStateByRange(damageClaimKeyPrefix, damageClaimKeyPrefix+"~")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query damage claims: %v", err)
+	}
+	defer resultsIterator.Close()
+
+	claims := []*DamageClaim{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var claim DamageClaim
+		err = json.Unmarshal(queryResponse.Value, &claim)
+		if err != nil {
+			return nil, err
+		}
+		if claim.Carrier != carrierMSPID {
+			continue
+		}
+		claim.upgradeSchema()
+		claims = append(claims, &claim)
+	}
+
+	return claims, nil
+}
@@ -0,0 +1,179 @@
+package contracts
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// lateDeliveryTrustEvent is the consensus trust event reported for the sender of a
+// transfer received after its expected delivery date
+const lateDeliveryTrustEvent = "LATE_DELIVERY"
+
+// DeliverySLA is how a transfer fares against its expected delivery date
+type DeliverySLA struct {
+	TransferID           string `json:"transferId"`
+	ExpectedDeliveryDate string `json:"expectedDeliveryDate"`
+	DeliveredAt          string `json:"deliveredAt,omitempty" metadata:",optional"` // Empty until the receiver confirms receipt
+	Late                 bool   `json:"late"`                                       // Received after the date, or still open past it
+	LateByMinutes        int    `json:"lateByMinutes,omitempty" metadata:",optional"`
+	ReportedAt           string `json:"reportedAt,omitempty" metadata:",optional"` // When LATE_DELIVERY was passed to consensus
+}
+
+// deliveryLateness returns how long after its expected delivery date a transfer was
+// received, or by asOf if it is still open. It is not positive for transfers on time,
+// those without a date and those cancelled or timed out.
+func deliveryLateness(transfer *Transfer, asOf time.Time) time.Duration {
+	if transfer.ExpectedDeliveryDate == "" {
+		return 0
+	}
+	if transfer.Status != TransferStatusCompleted && !isOpenTransfer(transfer) {
+		return 0
+	}
+	expected, err := time.Parse(time.RFC3339, transfer.ExpectedDeliveryDate)
+	if err != nil {
+		return 0
+	}
+	if transfer.Status == TransferStatusCompleted {
+		asOf, err = time.Parse(time.RFC3339, transfer.CompletedAt)
+		if err != nil {
+			return 0
+		}
+	}
+	return asOf.Sub(expected)
+}
+
+// reportLateDelivery passes a LATE_DELIVERY trust event for the sender to consensus
+// when a received transfer arrived after its expected delivery date, and records that
+// it did so the sender is penalized once. It reports whether it passed the event.
+func reportLateDelivery(ctx contractapi.TransactionContextInterface, transfer *Transfer) (bool, error) {
+	if transfer.Status != TransferStatusCompleted || transfer.LateDeliveryReportedAt != "" {
+		return false, nil
+	}
+	now, err := txTime(ctx)
+	if err != nil {
+		return false, err
+	}
+	if deliveryLateness(transfer, now) <= 0 {
+		return false, nil
+	}
+
+	consensus, err := LoadConsensusIntegration(ctx)
+	if err != nil {
+		return false, err
+	}
+	err = consensus.ReportTrustEvent(ctx, transfer.From, lateDeliveryTrustEvent)
+	if err != nil {
+		return false, err
+	}
+	transfer.LateDeliveryReportedAt = now.UTC().Format(time.RFC3339)
+	return true, nil
+}
+
+// SetExpectedDeliveryDate records the date by which the sender promises the receiver
+// will have a transfer. The sender calls it before sending; an empty date removes it.
+// Receipt after the date lowers the sender's consensus trust score, see CheckDeliverySLA.
+func (s *SupplyChainContract) SetExpectedDeliveryDate(ctx contractapi.TransactionContextInterface,
+	transferID string, expectedDeliveryDate string) error {
+
+	if err := validateID("transferID", transferID); err != nil {
+		return err
+	}
+
+	transfer, err := s.GetTransfer(ctx, transferID)
+	if err != nil {
+		return err
+	}
+
+	sender, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to get sender identity: %v", err)
+	}
+	if transfer.From != sender {
+		return newError(ErrPermissionDenied, "only the sender can set the expected delivery date of a transfer")
+	}
+	if transfer.Status != TransferStatusInitiated {
+		return newError(ErrInvalidState, "the expected delivery date must be set before the transfer is sent, status is %s", transfer.Status)
+	}
+
+	expected := ""
+	if expectedDeliveryDate != "" {
+		parsed, err := time.Parse(time.RFC3339, expectedDeliveryDate)
+		if err != nil {
+			return newError(ErrInvalidArgument, "expectedDeliveryDate must be an RFC3339 time")
+		}
+		now, err := txTime(ctx)
+		if err != nil {
+			return err
+		}
+		if !parsed.After(now) {
+			return newError(ErrInvalidArgument, "expectedDeliveryDate must be in the future")
+		}
+		expected = parsed.UTC().Format(time.RFC3339)
+	}
+	transfer.ExpectedDeliveryDate = expected
+
+	err = putTransfer(ctx, transfer)
+	if err != nil {
+		return err
+	}
+
+	event := transferEvent(EventExpectedDeliveryDateSet, transfer, "")
+	event.Attributes["expectedDeliveryDate"] = expected
+	return emitEvent(ctx, event)
+}
+
+// CheckDeliverySLA returns how a transfer fares against its expected delivery date.
+// ConfirmReceived reports a late receipt to consensus itself; if consensus could not be
+// reached then, CheckDeliverySLA reports it. Any organization may call it.
+func (s *SupplyChainContract) CheckDeliverySLA(ctx contractapi.TransactionContextInterface,
+	transferID string) (*DeliverySLA, error) {
+
+	if err := validateID("transferID", transferID); err != nil {
+		return nil, err
+	}
+
+	transfer, err := s.GetTransfer(ctx, transferID)
+	if err != nil {
+		return nil, err
+	}
+	if transfer.ExpectedDeliveryDate == "" {
+		return nil, newError(ErrInvalidState, "transfer %s has no expected delivery date", transferID)
+	}
+
+	reported, err := reportLateDelivery(ctx, transfer)
+	if err != nil {
+		return nil, wrapError(err, "failed to report late delivery")
+	}
+	if reported {
+		err = putTransfer(ctx, transfer)
+		if err != nil {
+			return nil, err
+		}
+		event := transferEvent(EventLateDeliveryReported, transfer, "")
+		event.Attributes["expectedDeliveryDate"] = transfer.ExpectedDeliveryDate
+		event.Attributes["completedAt"] = transfer.CompletedAt
+		if err := emitEvent(ctx, event); err != nil {
+			return nil, err
+		}
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sla := &DeliverySLA{
+		TransferID:           transferID,
+		ExpectedDeliveryDate: transfer.ExpectedDeliveryDate,
+		ReportedAt:           transfer.LateDeliveryReportedAt,
+	}
+	if transfer.Status == TransferStatusCompleted {
+		sla.DeliveredAt = transfer.CompletedAt
+	}
+	if lateness := deliveryLateness(transfer, now); lateness > 0 {
+		sla.Late = true
+		sla.LateByMinutes = int(lateness / time.Minute)
+	}
+	return sla, nil
+}
@@ -0,0 +1,150 @@
+package contracts
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// provenanceNoteExDisplay is the type of the note added when a display unit is converted
+const provenanceNoteExDisplay = "EX_DISPLAY"
+
+// DemoUnit records a product's use as a retail display unit
+type DemoUnit struct {
+	MarkedBy    string `json:"markedBy"` // Holder MSP
+	MarkedAt    string `json:"markedAt"`
+	Reason      string `json:"reason,omitempty" metadata:",optional"` // e.g. window display
+	ConvertedBy string `json:"convertedBy,omitempty" metadata:",optional"`
+	ConvertedAt string `json:"convertedAt,omitempty" metadata:",optional"`
+	Condition   string `json:"condition,omitempty" metadata:",optional"` // Assessed at conversion
+}
+
+// getHeldProduct returns a product the caller holds
+func (s *SupplyChainContract) getHeldProduct(ctx contractapi.TransactionContextInterface,
+	productID string) (*Product, string, error) {
+
+	product, err := s.GetProduct(ctx, productID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	caller, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get caller identity: %v", err)
+	}
+	if product.CurrentOwner != caller {
+		return nil, "", newError(ErrPermissionDenied, "only the holder of product %s can change its display status", productID)
+	}
+
+	return product, caller, nil
+}
+
+// MarkAsDemoUnit takes a product in store out of sale to use it as a display unit.
+// It cannot be shipped or sold until ConvertDemoToSellable.
+func (s *SupplyChainContract) MarkAsDemoUnit(ctx contractapi.TransactionContextInterface,
+	productID string, reason string) error {
+
+	if err := validateAll(
+		validateID("productID", productID),
+		validateText("reason", reason, maxNameLength),
+	); err != nil {
+		return err
+	}
+
+	product, caller, err := s.getHeldProduct(ctx, productID)
+	if err != nil {
+		return err
+	}
+	if product.Status != ProductStatusInStore {
+		return newError(ErrInvalidState, "only products in store can become display units, status is %s", product.Status)
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	previousStatus := product.Status
+	if err := setProductStatus(product, ProductStatusDemo); err != nil {
+		return err
+	}
+	product.DemoUnit = &DemoUnit{
+		MarkedBy: caller,
+		MarkedAt: now.UTC().Format(time.RFC3339),
+		Reason:   reason,
+	}
+
+	err = putProduct(ctx, product)
+	if err != nil {
+		return err
+	}
+
+	return emitEvent(ctx, ChaincodeEvent{
+		EventType:  EventProductMarkedDemo,
+		EntityType: EventEntityProduct,
+		EntityID:   productID,
+		FromState:  string(previousStatus),
+		ToState:    string(product.Status),
+	})
+}
+
+// ConvertDemoToSellable returns a display unit to sale with its assessed condition,
+// which buyers see in an EX_DISPLAY provenance note
+func (s *SupplyChainContract) ConvertDemoToSellable(ctx contractapi.TransactionContextInterface,
+	productID string, condition string) error {
+
+	if err := validateAll(
+		validateID("productID", productID),
+		validateName("condition", condition),
+	); err != nil {
+		return err
+	}
+
+	product, caller, err := s.getHeldProduct(ctx, productID)
+	if err != nil {
+		return err
+	}
+	if product.Status != ProductStatusDemo || product.DemoUnit == nil {
+		return newError(ErrInvalidState, "product %s is not a display unit", productID)
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	timestamp := now.UTC().Format(time.RFC3339)
+	previousStatus := product.Status
+	if err := setProductStatus(product, ProductStatusInStore); err != nil {
+		return err
+	}
+	product.DemoUnit.ConvertedBy = caller
+	product.DemoUnit.ConvertedAt = timestamp
+	product.DemoUnit.Condition = condition
+
+	markedOn := product.DemoUnit.MarkedAt
+	if markedAt, err := time.Parse(time.RFC3339, markedOn); err == nil {
+		markedOn = markedAt.Format("2006-01-02")
+	}
+	product.ProvenanceNotes = append(product.ProvenanceNotes, ProvenanceNote{
+		Type: provenanceNoteExDisplay,
+		Note: fmt.Sprintf("Display unit at %s from %s to %s, condition: %s",
+			product.DemoUnit.MarkedBy, markedOn, now.UTC().Format("2006-01-02"), condition),
+		RecordedAt: timestamp,
+	})
+
+	err = putProduct(ctx, product)
+	if err != nil {
+		return err
+	}
+
+	return emitEvent(ctx, ChaincodeEvent{
+		EventType:  EventDemoUnitConverted,
+		EntityType: EventEntityProduct,
+		EntityID:   productID,
+		FromState:  string(previousStatus),
+		ToState:    string(product.Status),
+		Attributes: map[string]interface{}{
+			"condition": condition,
+		},
+	})
+}
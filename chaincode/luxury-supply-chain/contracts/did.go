@@ -0,0 +1,228 @@
+package contracts
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Supported DID methods
+var didPattern = regexp.MustCompile(`^did:(web|fabric):[A-Za-z0-9.%-]+(:[A-Za-z0-9.%-]+)*$`)
+
+// maxDIDKeys limits the keys registered for one DID
+const maxDIDKeys = 10
+
+// DIDVerificationKey is a public key of an organization's DID
+type DIDVerificationKey struct {
+	ID           string `json:"id"`           // <did>#<fragment>
+	Type         string `json:"type"`         // Ed25519 or ECDSA-P256, derived from the key
+	PublicKeyPEM string `json:"publicKeyPem"` // PKIX "PUBLIC KEY" block
+}
+
+// DIDSignatureVerification is the result of checking a DID-signed payload
+type DIDSignatureVerification struct {
+	Valid bool   `json:"valid"`
+	KeyID string `json:"keyId"`
+	DID   string `json:"did"`
+	MSPID string `json:"mspId"` // Organization that registered the DID
+}
+
+// didIndexKey maps a DID to the organization that registered it
+func didIndexKey(did string) string {
+	return "did_index_" + did
+}
+
+// RegisterDID sets the calling organization's DID and its verification keys, replacing
+// any previous registration. keysJSON is a list of {"id": "<did>#key-1", "publicKeyPem": "..."}.
+func (r *RoleManagementContract) RegisterDID(ctx contractapi.TransactionContextInterface,
+	did string, keysJSON string) error {
+
+	var keys []DIDVerificationKey
+	if err := validateAll(
+		validateDID("did", did),
+		validateJSON("keysJSON", keysJSON, &keys),
+	); err != nil {
+		return err
+	}
+	if len(keys) == 0 || len(keys) > maxDIDKeys {
+		return newError(ErrInvalidArgument, "a DID needs between 1 and %d keys", maxDIDKeys)
+	}
+	seen := make(map[string]bool)
+	for i := range keys {
+		if !strings.HasPrefix(keys[i].ID, did+"#") || len(keys[i].ID) == len(did)+1 {
+			return newError(ErrInvalidArgument, "key id %q must be %s#<fragment>", keys[i].ID, did)
+		}
+		if seen[keys[i].ID] {
+			return newError(ErrInvalidArgument, "duplicate key id %s", keys[i].ID)
+		}
+		seen[keys[i].ID] = true
+		keyType, _, err := parsePublicKeyPEM(keys[i].PublicKeyPEM)
+		if err != nil {
+			return newError(ErrInvalidArgument, "key %s: %v", keys[i].ID, err)
+		}
+		keys[i].Type = keyType
+	}
+
+	caller, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to get caller identity: %v", err)
+	}
+
+	orgInfo, err := r.GetOrganizationInfo(ctx, caller)
+	if err != nil {
+		return err
+	}
+	if !orgInfo.IsActive {
+		return newError(ErrPermissionDenied, "organization %s is not active", caller)
+	}
+
+	owner, err := ctx.GetStub().GetState(didIndexKey(did))
+	if err != nil {
+		return fmt.Errorf("failed to read DID index: %v", err)
+	}
+	if owner != nil && string(owner) != caller {
+		return newError(ErrAlreadyExists, "DID %s is registered by another organization", did)
+	}
+	if orgInfo.DID != "" && orgInfo.DID != did {
+		if err := ctx.GetStub().DelState(didIndexKey(orgInfo.DID)); err != nil {
+			return fmt.Errorf("failed to remove previous DID: %v", err)
+		}
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	orgInfo.DID = did
+	orgInfo.VerificationKeys = keys
+	orgInfo.DIDUpdatedAt = now.UTC().Format(time.RFC3339)
+
+	orgJSON, err := json.Marshal(orgInfo)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState("org_role_"+caller, orgJSON)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState(didIndexKey(did), []byte(caller))
+	if err != nil {
+		return err
+	}
+
+	return emitEvent(ctx, ChaincodeEvent{
+		EventType:  EventOrganizationDIDRegistered,
+		EntityType: EventEntityOrganization,
+		EntityID:   caller,
+		Attributes: map[string]interface{}{
+			"did":  did,
+			"keys": len(keys),
+		},
+	})
+}
+
+// ResolveDID returns the organization that registered a DID, with its keys
+func (r *RoleManagementContract) ResolveDID(ctx contractapi.TransactionContextInterface,
+	did string) (*OrganizationInfo, error) {
+
+	if err := validateDID("did", did); err != nil {
+		return nil, err
+	}
+
+	owner, err := ctx.GetStub().GetState(didIndexKey(did))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read DID index: %v", err)
+	}
+	if owner == nil {
+		return nil, newError(ErrNotFound, "DID %s is not registered", did)
+	}
+
+	return r.GetOrganizationInfo(ctx, string(owner))
+}
+
+// VerifyDIDSignature checks a base64 signature over payload made with a registered DID key
+func (r *RoleManagementContract) VerifyDIDSignature(ctx contractapi.TransactionContextInterface,
+	keyID string, payload string, signature string) (*DIDSignatureVerification, error) {
+
+	if err := validateAll(
+		validateRequired("keyID", keyID, maxNameLength),
+		validateRequired("payload", payload, maxJSONLength),
+		validateRequired("signature", signature, maxNameLength),
+	); err != nil {
+		return nil, err
+	}
+
+	orgInfo, valid, err := checkDIDSignature(ctx, keyID, []byte(payload), signature)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DIDSignatureVerification{
+		Valid: valid,
+		KeyID: keyID,
+		DID:   orgInfo.DID,
+		MSPID: orgInfo.MSPID,
+	}, nil
+}
+
+// verifyDIDSignature checks a signature made with a registered DID key and returns the
+// signing organization. Contracts accepting DID-signed payloads call it before trusting them.
+func verifyDIDSignature(ctx contractapi.TransactionContextInterface,
+	keyID string, payload []byte, signature string) (*OrganizationInfo, error) {
+
+	orgInfo, valid, err := checkDIDSignature(ctx, keyID, payload, signature)
+	if err != nil {
+		return nil, err
+	}
+	if !valid {
+		return nil, newError(ErrPermissionDenied, "signature does not match key %s of an active organization", keyID)
+	}
+	return orgInfo, nil
+}
+
+// checkDIDSignature resolves the organization owning keyID and reports whether signature
+// is valid over payload
+func checkDIDSignature(ctx contractapi.TransactionContextInterface,
+	keyID string, payload []byte, signature string) (*OrganizationInfo, bool, error) {
+
+	did := strings.SplitN(keyID, "#", 2)[0]
+	roleContract := &RoleManagementContract{}
+	orgInfo, err := roleContract.ResolveDID(ctx, did)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var key *DIDVerificationKey
+	for i := range orgInfo.VerificationKeys {
+		if orgInfo.VerificationKeys[i].ID == keyID {
+			key = &orgInfo.VerificationKeys[i]
+		}
+	}
+	if key == nil {
+		return nil, false, newError(ErrNotFound, "key %s is not registered", keyID)
+	}
+
+	signatureBytes, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return nil, false, newError(ErrInvalidArgument, "signature is not valid base64")
+	}
+	_, publicKey, err := parsePublicKeyPEM(key.PublicKeyPEM)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return orgInfo, orgInfo.IsActive && verifySignature(publicKey, payload, signatureBytes), nil
+}
+
+// validateDID checks a did:web or did:fabric identifier
+func validateDID(field string, value string) error {
+	if len(value) > maxNameLength || !didPattern.MatchString(value) {
+		return newError(ErrInvalidArgument, "%s %q is not a did:web or did:fabric identifier", field, value)
+	}
+	return nil
+}
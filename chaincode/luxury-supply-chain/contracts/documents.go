@@ -0,0 +1,294 @@
+package contracts
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// ProductDocument is a file about a product kept on IPFS and anchored on its birth
+// certificate, e.g. an invoice, a care guide or an appraisal report
+type ProductDocument struct {
+	CID          string `json:"cid"`
+	DocumentType string `json:"documentType"`
+	AddedBy      string `json:"addedBy"` // MSP ID
+	AddedAt      string `json:"addedAt"`
+}
+
+// parseCIDs splits a comma-separated list of photo CIDs and validates each one
+func parseCIDs(field string, list string) ([]string, error) {
+	if err := validateRequired(field, list, maxTextLength); err != nil {
+		return nil, err
+	}
+	cids := strings.Split(list, ",")
+	if len(cids) > maxConditionPhotos {
+		return nil, newError(ErrInvalidArgument, "at most %d photos can be recorded at once", maxConditionPhotos)
+	}
+	for i := range cids {
+		cids[i] = strings.TrimSpace(cids[i])
+		if err := validateCID("photo CID", cids[i]); err != nil {
+			return nil, err
+		}
+	}
+	return cids, nil
+}
+
+// documentLeaves lists every file anchored for a product as a Merkle leaf, in a fixed
+// order: certificate photos, then documents, then the photos of each service record.
+// Each leaf names where the file is anchored, so a file cannot be moved unnoticed.
+func documentLeaves(certificate *DigitalBirthCertificate, ownership *Ownership) []string {
+	leaves := []string{}
+	for _, cid := range certificate.InitialPhotos {
+		leaves = append(leaves, "photo|"+cid)
+	}
+	for _, document := range certificate.Documents {
+		leaves = append(leaves, "document|"+document.DocumentType+"|"+document.CID)
+	}
+	if ownership != nil {
+		for _, record := range ownership.ServiceHistory {
+			for _, cid := range record.Photos {
+				leaves = append(leaves, "service|"+record.ID+"|"+cid)
+			}
+		}
+	}
+	return leaves
+}
+
+// documentMerkleRoot is the hex SHA-256 Merkle root of the leaves. Leaves are hashed,
+// each level hashes pairs of nodes concatenated, and an odd last node is carried up
+// unchanged. No leaves give an empty root.
+func documentMerkleRoot(leaves []string) string {
+	if len(leaves) == 0 {
+		return ""
+	}
+	level := make([][]byte, len(leaves))
+	for i, leaf := range leaves {
+		digest := sha256.Sum256([]byte(leaf))
+		level[i] = digest[:]
+	}
+	for len(level) > 1 {
+		next := make([][]byte, 0, (len(level)+1)/2)
+		for i := 0; i < len(level); i += 2 {
+			if i+1 == len(level) {
+				next = append(next, level[i])
+				continue
+			}
+			digest := sha256.Sum256(append(append([]byte{}, level[i]...), level[i+1]...))
+			next = append(next, digest[:])
+		}
+		level = next
+	}
+	return hex.EncodeToString(level[0])
+}
+
+// recommitDocuments recomputes a certificate's document root over its files and the
+// service photos of the product's ownership, if any, and stores the certificate as a
+// new revision
+func recommitDocuments(ctx contractapi.TransactionContextInterface, certificate *DigitalBirthCertificate,
+	ownership *Ownership, reason string) error {
+
+	if ownership == nil {
+		ownershipContract := &OwnershipContract{}
+		var err error
+		ownership, err = ownershipContract.GetOwnership(ctx, certificate.ProductID)
+		if err != nil && !hasErrorCode(err, ErrNotFound) {
+			return err
+		}
+	}
+	certificate.DocumentRoot = documentMerkleRoot(documentLeaves(certificate, ownership))
+	return recommitCertificate(ctx, certificate, reason)
+}
+
+// checkCertificateEditor lets the product's manufacturer, its holder, the organization
+// at its location, e.g. the retailer that sold it, and the brand anchor files on its
+// birth certificate
+func checkCertificateEditor(ctx contractapi.TransactionContextInterface, certificate *DigitalBirthCertificate) (string, error) {
+	caller, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return "", fmt.Errorf("failed to get caller identity: %v", err)
+	}
+	if caller == certificate.ManufacturingPlace {
+		return caller, nil
+	}
+	supplyChain := &SupplyChainContract{}
+	product, err := supplyChain.GetProduct(ctx, certificate.ProductID)
+	if err != nil {
+		return "", err
+	}
+	if caller == itemHolder(product.CurrentOwner, product.Consignment) || caller == product.CurrentLocation {
+		return caller, nil
+	}
+	if _, err := requireSuperAdmin(ctx); err != nil {
+		return "", newError(ErrPermissionDenied, "only the manufacturer, holder, location or brand of product %s can add files to its certificate", certificate.ProductID)
+	}
+	return caller, nil
+}
+
+// AddCertificatePhotos appends photos, given as comma-separated IPFS CIDs, to a birth
+// certificate's initial photos
+func (o *OwnershipContract) AddCertificatePhotos(ctx contractapi.TransactionContextInterface,
+	productID string, photoCIDs string) error {
+
+	if err := validateID("productID", productID); err != nil {
+		return err
+	}
+	cids, err := parseCIDs("photoCIDs", photoCIDs)
+	if err != nil {
+		return err
+	}
+
+	certificate, err := o.GetBirthCertificate(ctx, productID)
+	if err != nil {
+		return err
+	}
+	if _, err := checkCertificateEditor(ctx, certificate); err != nil {
+		return err
+	}
+	for _, cid := range cids {
+		for _, existing := range certificate.InitialPhotos {
+			if existing == cid {
+				return newError(ErrAlreadyExists, "photo %s is already on the certificate of product %s", cid, productID)
+			}
+		}
+	}
+
+	certificate.InitialPhotos = append(certificate.InitialPhotos, cids...)
+	if err := recommitDocuments(ctx, certificate, nil, "photos added"); err != nil {
+		return err
+	}
+
+	return emitEvent(ctx, ChaincodeEvent{
+		EventType:  EventCertificatePhotosAdded,
+		EntityType: EventEntityProduct,
+		EntityID:   productID,
+		Attributes: map[string]interface{}{
+			"photos":          len(cids),
+			"documentRoot":    certificate.DocumentRoot,
+			"certificateHash": certificate.CertificateHash,
+		},
+	})
+}
+
+// AddProductDocument anchors a document, given by its IPFS CID, on a birth certificate
+func (o *OwnershipContract) AddProductDocument(ctx contractapi.TransactionContextInterface,
+	productID string, documentType string, cid string) error {
+
+	if err := validateAll(
+		validateID("productID", productID),
+		validateName("documentType", documentType),
+		validateCID("cid", cid),
+	); err != nil {
+		return err
+	}
+
+	certificate, err := o.GetBirthCertificate(ctx, productID)
+	if err != nil {
+		return err
+	}
+	caller, err := checkCertificateEditor(ctx, certificate)
+	if err != nil {
+		return err
+	}
+	for _, document := range certificate.Documents {
+		if document.CID == cid {
+			return newError(ErrAlreadyExists, "document %s is already on the certificate of product %s", cid, productID)
+		}
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	certificate.Documents = append(certificate.Documents, ProductDocument{
+		CID:          cid,
+		DocumentType: documentType,
+		AddedBy:      caller,
+		AddedAt:      now.UTC().Format(time.RFC3339),
+	})
+	if err := recommitDocuments(ctx, certificate, nil, "document added"); err != nil {
+		return err
+	}
+
+	return emitEvent(ctx, ChaincodeEvent{
+		EventType:  EventProductDocumentAdded,
+		EntityType: EventEntityProduct,
+		EntityID:   productID,
+		Attributes: map[string]interface{}{
+			"documentType":    documentType,
+			"cid":             cid,
+			"documentRoot":    certificate.DocumentRoot,
+			"certificateHash": certificate.CertificateHash,
+		},
+	})
+}
+
+// AddServicePhotos appends photos, given as comma-separated IPFS CIDs, to a service
+// record. Like AddServiceRecord it needs the ADD_SERVICE_RECORD permission.
+func (o *OwnershipContract) AddServicePhotos(ctx contractapi.TransactionContextInterface,
+	productID string, serviceID string, photoCIDs string) error {
+
+	if err := validateAll(
+		validateID("productID", productID),
+		validateID("serviceID", serviceID),
+	); err != nil {
+		return err
+	}
+	cids, err := parseCIDs("photoCIDs", photoCIDs)
+	if err != nil {
+		return err
+	}
+
+	caller, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to get caller identity: %v", err)
+	}
+	roleContract := &RoleManagementContract{}
+	hasPermission, err := roleContract.CheckPermission(ctx, caller, "ADD_SERVICE_RECORD")
+	if err != nil || !hasPermission {
+		return newError(ErrPermissionDenied, "caller %s does not have permission to add service photos", caller)
+	}
+
+	ownership, err := o.GetOwnership(ctx, productID)
+	if err != nil {
+		return err
+	}
+	record := findServiceRecord(ownership, serviceID)
+	if record == nil || record.Type == stolenReportRecordType {
+		return newError(ErrNotFound, "product %s has no service record %s", productID, serviceID)
+	}
+	for _, cid := range cids {
+		for _, existing := range record.Photos {
+			if existing == cid {
+				return newError(ErrAlreadyExists, "photo %s is already on service record %s", cid, serviceID)
+			}
+		}
+	}
+	record.Photos = append(record.Photos, cids...)
+	if err := putOwnership(ctx, ownership); err != nil {
+		return err
+	}
+
+	certificate, err := o.GetBirthCertificate(ctx, productID)
+	if err != nil {
+		return err
+	}
+	if err := recommitDocuments(ctx, certificate, ownership, "service photos added"); err != nil {
+		return err
+	}
+
+	return emitEvent(ctx, ChaincodeEvent{
+		EventType:  EventServicePhotosAdded,
+		EntityType: EventEntityOwnership,
+		EntityID:   productID,
+		Attributes: map[string]interface{}{
+			"serviceId":       serviceID,
+			"photos":          len(cids),
+			"documentRoot":    certificate.DocumentRoot,
+			"certificateHash": certificate.CertificateHash,
+		},
+	})
+}
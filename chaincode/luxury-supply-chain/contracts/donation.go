@@ -0,0 +1,88 @@
+package contracts
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// provenanceNoteDonation is the type of the note added when a donated product is resold
+const provenanceNoteDonation = "DONATION"
+
+// Donation records that the brand gave a product to a registered charity
+type Donation struct {
+	TransferID  string `json:"transferId"` // The DONATION transfer
+	Donor       string `json:"donor"`      // Brand MSP
+	Charity     string `json:"charity"`    // Charity MSP
+	CharityName string `json:"charityName"`
+	DonatedAt   string `json:"donatedAt"` // When the charity confirmed receipt
+}
+
+// ProvenanceNote is a remark on a product's history that buyers see when verifying it
+type ProvenanceNote struct {
+	Type       string `json:"type"` // DONATION
+	Note       string `json:"note"`
+	RecordedAt string `json:"recordedAt"`
+}
+
+// checkDonation rejects a DONATION transfer unless the brand sends it to an active charity
+func checkDonation(ctx contractapi.TransactionContextInterface, charity string) error {
+	if _, err := requireSuperAdmin(ctx); err != nil {
+		return newError(ErrPermissionDenied, "only the brand can donate products")
+	}
+
+	roleContract := &RoleManagementContract{}
+	charityInfo, err := roleContract.GetOrganizationInfo(ctx, charity)
+	if err != nil || charityInfo.Role != RoleCharity || !charityInfo.IsActive {
+		return newError(ErrInvalidArgument, "%s is not a registered charity", charity)
+	}
+	return nil
+}
+
+// recordDonation marks a product received through a DONATION transfer as donated
+func recordDonation(ctx contractapi.TransactionContextInterface, product *Product, transfer *Transfer) error {
+	if transfer.TransferType != TransferTypeDonation {
+		return nil
+	}
+
+	roleContract := &RoleManagementContract{}
+	charityInfo, err := roleContract.GetOrganizationInfo(ctx, transfer.To)
+	if err != nil {
+		return wrapError(err, "failed to get charity info")
+	}
+
+	product.Donation = &Donation{
+		TransferID:  transfer.ID,
+		Donor:       transfer.From,
+		Charity:     transfer.To,
+		CharityName: charityInfo.Name,
+		DonatedAt:   transfer.CompletedAt,
+	}
+	return nil
+}
+
+// addDonationNote adds the donation provenance note the first time a donated product
+// is resold at recordedAt, and reports whether it did
+func addDonationNote(product *Product, recordedAt string) bool {
+	if product.Donation == nil {
+		return false
+	}
+	for _, note := range product.ProvenanceNotes {
+		if note.Type == provenanceNoteDonation {
+			return false
+		}
+	}
+
+	donatedOn := product.Donation.DonatedAt
+	if donatedAt, err := time.Parse(time.RFC3339, donatedOn); err == nil {
+		donatedOn = donatedAt.Format("2006-01-02")
+	}
+	product.ProvenanceNotes = append(product.ProvenanceNotes, ProvenanceNote{
+		Type: provenanceNoteDonation,
+		Note: fmt.Sprintf("Donated by %s to %s on %s and resold since",
+			product.Brand, product.Donation.CharityName, donatedOn),
+		RecordedAt: recordedAt,
+	})
+	return true
+}
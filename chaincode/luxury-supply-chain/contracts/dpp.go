@@ -0,0 +1,449 @@
+package contracts
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// dppSchemaVersion versions the layout of DigitalProductPassport
+const dppSchemaVersion = "1.0"
+
+const (
+	maxRepairabilityScore   = 10  // As on the EU repairability index
+	maxServiceLifeYears     = 100 // For expected lifetime and spare part availability
+	maxSubstancesOfConcern  = 50
+	dppMaterialShareDecimal = 100 // Shares are rounded to 2 decimals
+)
+
+// SustainabilityInfo holds the ESPR sustainability fields of a product, or of all
+// products of a batch that have none of their own
+type SustainabilityInfo struct {
+	CarbonFootprintKgCO2e  float64  `json:"carbonFootprintKgCO2e"`  // Cradle to gate
+	RecycledContentPercent float64  `json:"recycledContentPercent"` // Share of recycled material by weight
+	RepairabilityScore     float64  `json:"repairabilityScore"`     // 0 to 10
+	ExpectedLifetimeYears  int      `json:"expectedLifetimeYears"`
+	SparePartsYears        int      `json:"sparePartsYears"` // How long spare parts stay available after sale
+	RecyclingInstructions  string   `json:"recyclingInstructions,omitempty" metadata:",optional"`
+	TakeBackScheme         string   `json:"takeBackScheme,omitempty" metadata:",optional"`      // e.g. the brand's return program
+	SubstancesOfConcern    []string `json:"substancesOfConcern,omitempty" metadata:",optional"` // REACH SVHC names or CAS numbers
+	UpdatedBy              string   `json:"updatedBy,omitempty" metadata:",optional"`
+	UpdatedAt              string   `json:"updatedAt,omitempty" metadata:",optional"`
+}
+
+// DigitalProductPassport is the EU Digital Product Passport projection of a product
+// under the Ecodesign for Sustainable Products Regulation (ESPR)
+type DigitalProductPassport struct {
+	PassportID       string          `json:"passportId"` // The product's EPC, as in the EPCIS export
+	DPPSchemaVersion string          `json:"dppSchemaVersion"`
+	IssuedAt         string          `json:"issuedAt"`
+	Product          DPPProduct      `json:"product"`
+	Operator         DPPOperator     `json:"economicOperator"`
+	Materials        []DPPMaterial   `json:"materials"`
+	Environment      *DPPEnvironment `json:"environment,omitempty" metadata:",optional"`
+	Circularity      DPPCircularity  `json:"circularity"`
+	Compliance       DPPCompliance   `json:"compliance"`
+}
+
+// DPPProduct identifies the product of a passport
+type DPPProduct struct {
+	ProductID          string `json:"productId"`
+	Name               string `json:"name"`
+	Brand              string `json:"brand"`
+	Category           string `json:"category"` // The product type
+	SerialNumber       string `json:"serialNumber"`
+	BatchID            string `json:"batchId,omitempty" metadata:",optional"`
+	Variant            string `json:"variant,omitempty" metadata:",optional"`
+	ManufacturingDate  string `json:"manufacturingDate,omitempty" metadata:",optional"`
+	ManufacturingPlace string `json:"manufacturingPlace,omitempty" metadata:",optional"`
+}
+
+// DPPOperator names the brand placing the product on the market and its manufacturer
+type DPPOperator struct {
+	Brand        string `json:"brand"`
+	Manufacturer string `json:"manufacturer,omitempty" metadata:",optional"` // MSP ID
+}
+
+// DPPMaterial is one material of the product's composition
+type DPPMaterial struct {
+	Type            string   `json:"type"`
+	Supplier        string   `json:"supplier"`
+	Batch           string   `json:"batch,omitempty" metadata:",optional"`
+	SharePercent    float64  `json:"sharePercent,omitempty" metadata:",optional"`    // Of the quantity of all materials used
+	CountryOfOrigin string   `json:"countryOfOrigin,omitempty" metadata:",optional"` // From its origin certificate or deepest declared source
+	Certification   string   `json:"certification,omitempty" metadata:",optional"`   // Origin certificate scheme and number
+	FootprintKgCO2e float64  `json:"footprintKgCO2e,omitempty" metadata:",optional"`
+	Certifications  []string `json:"certifications,omitempty" metadata:",optional"` // Sustainability certifications, e.g. LWG
+}
+
+// DPPEnvironment holds the product's environmental footprint
+type DPPEnvironment struct {
+	CarbonFootprintKgCO2e  float64 `json:"carbonFootprintKgCO2e"`
+	RecycledContentPercent float64 `json:"recycledContentPercent"`
+}
+
+// DPPCircularity holds how the product is repaired and recycled
+type DPPCircularity struct {
+	RepairabilityScore    float64          `json:"repairabilityScore,omitempty" metadata:",optional"`
+	ExpectedLifetimeYears int              `json:"expectedLifetimeYears,omitempty" metadata:",optional"`
+	SparePartsYears       int              `json:"sparePartsYears,omitempty" metadata:",optional"`
+	RecyclingInstructions string           `json:"recyclingInstructions,omitempty" metadata:",optional"`
+	TakeBackScheme        string           `json:"takeBackScheme,omitempty" metadata:",optional"`
+	RepairEvents          []DPPRepairEvent `json:"repairEvents"`
+}
+
+// DPPRepairEvent is a repair or maintenance from the product's service history.
+// Technicians and descriptions stay in the service record.
+type DPPRepairEvent struct {
+	Date          string `json:"date"`
+	Type          string `json:"type"`
+	ServiceCenter string `json:"serviceCenter"`
+	Warranty      bool   `json:"warranty"`
+}
+
+// isRepairServiceRecord reports whether a service record is work on the product, not
+// a theft report, recovery or authentication entry
+func isRepairServiceRecord(record ServiceRecord) bool {
+	switch record.Type {
+	case stolenReportRecordType, "recovered", authenticationServiceType:
+		return false
+	}
+	return true
+}
+
+// DPPCompliance holds the substances and certificate a passport vouches with
+type DPPCompliance struct {
+	SubstancesOfConcern []string `json:"substancesOfConcern"`
+	CertificateHash     string   `json:"certificateHash,omitempty" metadata:",optional"` // Current revision of the birth certificate
+	CertificateRevoked  bool     `json:"certificateRevoked"`
+}
+
+// parseSustainabilityInfo validates the sustainability fields given as JSON
+func parseSustainabilityInfo(sustainabilityJSON string) (*SustainabilityInfo, error) {
+	var info SustainabilityInfo
+	if err := validateJSON("sustainabilityJSON", sustainabilityJSON, &info); err != nil {
+		return nil, err
+	}
+	if err := validateAll(
+		validateText("recyclingInstructions", info.RecyclingInstructions, maxTextLength),
+		validateText("takeBackScheme", info.TakeBackScheme, maxNameLength),
+	); err != nil {
+		return nil, err
+	}
+	switch {
+	case info.CarbonFootprintKgCO2e < 0:
+		return nil, newError(ErrInvalidArgument, "carbonFootprintKgCO2e cannot be negative")
+	case info.RecycledContentPercent < 0 || info.RecycledContentPercent > 100:
+		return nil, newError(ErrInvalidArgument, "recycledContentPercent must be between 0 and 100")
+	case info.RepairabilityScore < 0 || info.RepairabilityScore > maxRepairabilityScore:
+		return nil, newError(ErrInvalidArgument, "repairabilityScore must be between 0 and %d", maxRepairabilityScore)
+	case info.ExpectedLifetimeYears < 0 || info.ExpectedLifetimeYears > maxServiceLifeYears:
+		return nil, newError(ErrInvalidArgument, "expectedLifetimeYears must be between 0 and %d", maxServiceLifeYears)
+	case info.SparePartsYears < 0 || info.SparePartsYears > maxServiceLifeYears:
+		return nil, newError(ErrInvalidArgument, "sparePartsYears must be between 0 and %d", maxServiceLifeYears)
+	case len(info.SubstancesOfConcern) > maxSubstancesOfConcern:
+		return nil, newError(ErrInvalidArgument, "at most %d substances of concern can be listed", maxSubstancesOfConcern)
+	}
+	for _, substance := range info.SubstancesOfConcern {
+		if err := validateName("substance of concern", substance); err != nil {
+			return nil, err
+		}
+	}
+	return &info, nil
+}
+
+// checkSustainabilityEditor lets the manufacturer of a product or batch and the brand
+// set its sustainability fields
+func checkSustainabilityEditor(ctx contractapi.TransactionContextInterface, manufacturer string) (string, error) {
+	caller, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return "", fmt.Errorf("failed to get caller identity: %v", err)
+	}
+	if caller == manufacturer {
+		return caller, nil
+	}
+	if _, err := requireSuperAdmin(ctx); err != nil {
+		return "", newError(ErrPermissionDenied, "only the manufacturer or the brand can set sustainability information")
+	}
+	return caller, nil
+}
+
+// productManufacturer returns the organization that made a product: its batch's
+// manufacturer, or the place on its birth certificate
+func (s *SupplyChainContract) productManufacturer(ctx contractapi.TransactionContextInterface,
+	product *Product) (string, *DigitalBirthCertificate, error) {
+
+	ownershipContract := &OwnershipContract{}
+	certificate, err := ownershipContract.GetBirthCertificate(ctx, product.ID)
+	if err != nil && !hasErrorCode(err, ErrNotFound) {
+		return "", nil, err
+	}
+	if product.BatchID != "" {
+		batch, err := s.GetBatch(ctx, product.BatchID)
+		if err != nil {
+			return "", nil, err
+		}
+		return batch.Manufacturer, certificate, nil
+	}
+	if certificate != nil {
+		return certificate.ManufacturingPlace, certificate, nil
+	}
+	return "", nil, nil
+}
+
+// SetProductSustainability records a product's sustainability fields for its Digital
+// Product Passport, e.g. {"carbonFootprintKgCO2e":18.5,"recycledContentPercent":30,
+// "repairabilityScore":7.5,"expectedLifetimeYears":15,"sparePartsYears":10,
+// "recyclingInstructions":"Return to any boutique"}. They replace earlier values and
+// override those of its batch. The manufacturer or the brand may set them.
+func (s *SupplyChainContract) SetProductSustainability(ctx contractapi.TransactionContextInterface,
+	productID string, sustainabilityJSON string) error {
+
+	if err := validateID("productID", productID); err != nil {
+		return err
+	}
+	info, err := parseSustainabilityInfo(sustainabilityJSON)
+	if err != nil {
+		return err
+	}
+
+	product, err := s.GetProduct(ctx, productID)
+	if err != nil {
+		return err
+	}
+	manufacturer, _, err := s.productManufacturer(ctx, product)
+	if err != nil {
+		return err
+	}
+	caller, err := checkSustainabilityEditor(ctx, manufacturer)
+	if err != nil {
+		return err
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	info.UpdatedBy = caller
+	info.UpdatedAt = now.UTC().Format(time.RFC3339)
+	product.Sustainability = info
+	if err := putProduct(ctx, product); err != nil {
+		return err
+	}
+
+	return emitEvent(ctx, ChaincodeEvent{
+		EventType:  EventSustainabilityInfoSet,
+		EntityType: EventEntityProduct,
+		EntityID:   productID,
+		Attributes: map[string]interface{}{
+			"carbonFootprintKgCO2e":  info.CarbonFootprintKgCO2e,
+			"recycledContentPercent": info.RecycledContentPercent,
+			"repairabilityScore":     info.RepairabilityScore,
+		},
+	})
+}
+
+// SetBatchSustainability records the sustainability fields shared by the products of
+// a batch, in the format of SetProductSustainability. Products with fields of their
+// own keep them. The batch's manufacturer or the brand may set them.
+func (s *SupplyChainContract) SetBatchSustainability(ctx contractapi.TransactionContextInterface,
+	batchID string, sustainabilityJSON string) error {
+
+	if err := validateID("batchID", batchID); err != nil {
+		return err
+	}
+	info, err := parseSustainabilityInfo(sustainabilityJSON)
+	if err != nil {
+		return err
+	}
+
+	batch, err := s.GetBatch(ctx, batchID)
+	if err != nil {
+		return err
+	}
+	caller, err := checkSustainabilityEditor(ctx, batch.Manufacturer)
+	if err != nil {
+		return err
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	info.UpdatedBy = caller
+	info.UpdatedAt = now.UTC().Format(time.RFC3339)
+	batch.Sustainability = info
+	if err := putBatch(ctx, batch); err != nil {
+		return err
+	}
+
+	return emitEvent(ctx, ChaincodeEvent{
+		EventType:  EventSustainabilityInfoSet,
+		EntityType: EventEntityBatch,
+		EntityID:   batchID,
+		Attributes: map[string]interface{}{
+			"carbonFootprintKgCO2e":  info.CarbonFootprintKgCO2e,
+			"recycledContentPercent": info.RecycledContentPercent,
+			"repairabilityScore":     info.RepairabilityScore,
+		},
+	})
+}
+
+// dppMaterials lists a product's materials with their share of the quantity used and
+// their origin, read from the supplier's inventory of each material
+func (s *SupplyChainContract) dppMaterials(ctx contractapi.TransactionContextInterface, product *Product) []DPPMaterial {
+	total := 0.0
+	for _, material := range product.Materials {
+		total += material.QuantityUsed
+	}
+
+	materials := []DPPMaterial{}
+	for _, material := range product.Materials {
+		entry := DPPMaterial{
+			Type:            material.Type,
+			Supplier:        material.Supplier,
+			Batch:           material.Batch,
+			FootprintKgCO2e: material.FootprintKgCO2e,
+			Certifications:  material.Certifications,
+		}
+		if total > 0 {
+			entry.SharePercent = math.Round(material.QuantityUsed/total*100*dppMaterialShareDecimal) / dppMaterialShareDecimal
+		}
+
+		inventory, err := s.GetMaterialInventory(ctx, material.ID, material.Supplier)
+		if err != nil {
+			logFor(ctx).Warn("supplier inventory unavailable for product passport", "materialId", material.ID, "supplier", material.Supplier, "error", err)
+			materials = append(materials, entry)
+			continue
+		}
+		if inventory.Certification != nil {
+			entry.CountryOfOrigin = inventory.Certification.CountryOfOrigin
+			entry.Certification = inventory.Certification.Scheme + " " + inventory.Certification.CertificateNumber
+		} else {
+			deepestTier := 0
+			for _, source := range inventory.UpstreamSources {
+				if source.Tier > deepestTier && source.Country != "" {
+					deepestTier = source.Tier
+					entry.CountryOfOrigin = source.Country
+				}
+			}
+		}
+		materials = append(materials, entry)
+	}
+	return materials
+}
+
+// GetDigitalProductPassport assembles the EU Digital Product Passport of a product
+// from its record, birth certificate, materials, service history and the sustainability
+// fields set for it or its batch. The passport is public, like the product it
+// describes; owners and technicians are left out.
+func (s *SupplyChainContract) GetDigitalProductPassport(ctx contractapi.TransactionContextInterface,
+	productID string) (*DigitalProductPassport, error) {
+
+	if err := validateID("productID", productID); err != nil {
+		return nil, err
+	}
+	product, err := s.GetProduct(ctx, productID)
+	if err != nil {
+		return nil, err
+	}
+	manufacturer, certificate, err := s.productManufacturer(ctx, product)
+	if err != nil {
+		return nil, err
+	}
+	epc, err := newEPCISExport(ctx).productEPC(product)
+	if err != nil {
+		return nil, err
+	}
+	now, err := txTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	passport := &DigitalProductPassport{
+		PassportID:       epc,
+		DPPSchemaVersion: dppSchemaVersion,
+		IssuedAt:         now.UTC().Format(time.RFC3339),
+		Product: DPPProduct{
+			ProductID:    product.ID,
+			Name:         product.Name,
+			Brand:        product.Brand,
+			Category:     product.Type,
+			SerialNumber: product.SerialNumber,
+			BatchID:      product.BatchID,
+			Variant:      product.Variant,
+		},
+		Operator: DPPOperator{
+			Brand:        product.Brand,
+			Manufacturer: manufacturer,
+		},
+		Materials: s.dppMaterials(ctx, product),
+		Circularity: DPPCircularity{
+			RepairEvents: []DPPRepairEvent{},
+		},
+		Compliance: DPPCompliance{
+			SubstancesOfConcern: []string{},
+			CertificateRevoked:  product.CertificateRevoked,
+		},
+	}
+	if certificate != nil {
+		passport.Product.ManufacturingDate = certificate.ManufacturingDate
+		passport.Product.ManufacturingPlace = certificate.ManufacturingPlace
+		passport.Compliance.CertificateHash = certificate.CertificateHash
+	}
+
+	info := product.Sustainability
+	if info == nil && product.BatchID != "" {
+		batch, err := s.GetBatch(ctx, product.BatchID)
+		if err != nil {
+			return nil, err
+		}
+		info = batch.Sustainability
+	}
+	if info != nil {
+		passport.Environment = &DPPEnvironment{
+			CarbonFootprintKgCO2e:  info.CarbonFootprintKgCO2e,
+			RecycledContentPercent: info.RecycledContentPercent,
+		}
+		passport.Circularity.RepairabilityScore = info.RepairabilityScore
+		passport.Circularity.ExpectedLifetimeYears = info.ExpectedLifetimeYears
+		passport.Circularity.SparePartsYears = info.SparePartsYears
+		passport.Circularity.RecyclingInstructions = info.RecyclingInstructions
+		passport.Circularity.TakeBackScheme = info.TakeBackScheme
+		if info.SubstancesOfConcern != nil {
+			passport.Compliance.SubstancesOfConcern = info.SubstancesOfConcern
+		}
+	}
+	// Without a declared footprint, the one computed from the materials is reported
+	if footprint := product.Footprint; footprint != nil && footprint.MaterialsKgCO2e > 0 {
+		if passport.Environment == nil {
+			passport.Environment = &DPPEnvironment{}
+		}
+		if passport.Environment.CarbonFootprintKgCO2e == 0 {
+			passport.Environment.CarbonFootprintKgCO2e = footprint.MaterialsKgCO2e
+		}
+	}
+
+	ownershipContract := &OwnershipContract{}
+	ownership, err := ownershipContract.GetOwnership(ctx, productID)
+	if err != nil && !hasErrorCode(err, ErrNotFound) {
+		return nil, err
+	}
+	if ownership != nil {
+		for _, record := range ownership.ServiceHistory {
+			if !isRepairServiceRecord(record) {
+				continue
+			}
+			passport.Circularity.RepairEvents = append(passport.Circularity.RepairEvents, DPPRepairEvent{
+				Date:          record.Date,
+				Type:          record.Type,
+				ServiceCenter: record.ServiceCenter,
+				Warranty:      record.Warranty,
+			})
+		}
+	}
+
+	return passport, nil
+}
@@ -0,0 +1,277 @@
+package contracts
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Duty records are stored as duty_<receiver>_<transferID>_<jurisdiction>, so an
+// organization's inbound shipments are one key range
+const (
+	dutyKeyPrefix      = "duty_"
+	maxDutyDocuments   = 20
+	dutyStatusPaid     = "PAID"
+	dutyStatusDeferred = "DEFERRED"
+	dutyStatusDue      = "OUTSTANDING"
+)
+
+// DutyRecord holds the import duty and tax treatment of a transfer in one jurisdiction
+type DutyRecord struct {
+	TransferID     string   `json:"transferId"`
+	Receiver       string   `json:"receiver"`     // Importing organization, the transfer's To
+	Jurisdiction   string   `json:"jurisdiction"` // e.g. CH, EU, US
+	RateBand       string   `json:"rateBand"`     // e.g. HS 4202.21 at 3%
+	DutyPaid       bool     `json:"dutyPaid"`
+	DutyDeferred   bool     `json:"dutyDeferred"` // e.g. bonded warehouse or deferment account
+	TaxPaid        bool     `json:"taxPaid"`
+	TaxDeferred    bool     `json:"taxDeferred"`
+	Status         string   `json:"status"`         // PAID, DEFERRED or OUTSTANDING, see status
+	DocumentHashes []string `json:"documentHashes"` // Customs declarations, invoices, receipts
+	RecordedBy     string   `json:"recordedBy"`
+	RecordedAt     string   `json:"recordedAt"`
+	UpdatedBy      string   `json:"updatedBy"`
+	UpdatedAt      string   `json:"updatedAt"`
+	SchemaVersion  int      `json:"schemaVersion"`
+}
+
+// InboundDutyStatus summarizes the duty records of an organization's inbound shipments.
+// A shipment is outstanding while any duty or tax in any jurisdiction is neither paid
+// nor deferred, deferred while any is deferred, and paid otherwise.
+type InboundDutyStatus struct {
+	Organization         string         `json:"organization"`
+	Shipments            int            `json:"shipments"` // Transfers with at least one duty record
+	Paid                 int            `json:"paid"`
+	Deferred             int            `json:"deferred"`
+	Outstanding          int            `json:"outstanding"`
+	OutstandingTransfers []string       `json:"outstandingTransfers"`
+	ByJurisdiction       map[string]int `json:"byJurisdiction"` // Records per jurisdiction
+}
+
+// levyStatus is the status of one duty or tax
+func levyStatus(paid bool, deferred bool) string {
+	switch {
+	case paid:
+		return dutyStatusPaid
+	case deferred:
+		return dutyStatusDeferred
+	default:
+		return dutyStatusDue
+	}
+}
+
+// status is the combined status of the record's duty and tax
+func (d *DutyRecord) status() string {
+	duty := levyStatus(d.DutyPaid, d.DutyDeferred)
+	tax := levyStatus(d.TaxPaid, d.TaxDeferred)
+	if duty == dutyStatusDue || tax == dutyStatusDue {
+		return dutyStatusDue
+	}
+	if duty == dutyStatusDeferred || tax == dutyStatusDeferred {
+		return dutyStatusDeferred
+	}
+	return dutyStatusPaid
+}
+
+// RecordTransferDuty creates or replaces the duty record of a transfer in a jurisdiction.
+// dutyJSON holds rateBand, the dutyPaid, dutyDeferred, taxPaid and taxDeferred flags and
+// documentHashes. Only customs and logistics organizations maintain duty records.
+func (s *SupplyChainContract) RecordTransferDuty(ctx contractapi.TransactionContextInterface,
+	transferID string, jurisdiction string, dutyJSON string) error {
+
+	var input DutyRecord
+	if err := validateAll(
+		validateID("transferID", transferID),
+		validateID("jurisdiction", jurisdiction),
+		validateJSON("duty", dutyJSON, &input),
+	); err != nil {
+		return err
+	}
+	if err := validateName("rateBand", input.RateBand); err != nil {
+		return err
+	}
+	if input.DutyPaid && input.DutyDeferred || input.TaxPaid && input.TaxDeferred {
+		return newError(ErrInvalidArgument, "a duty or tax cannot be both paid and deferred")
+	}
+	if len(input.DocumentHashes) > maxDutyDocuments {
+		return newError(ErrInvalidArgument, "at most %d documents can be attached", maxDutyDocuments)
+	}
+	for _, hash := range input.DocumentHashes {
+		if err := validateID("document hash", hash); err != nil {
+			return err
+		}
+	}
+
+	caller, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to get caller identity: %v", err)
+	}
+
+	// CHECK PERMISSION - Only customs and logistics organizations record duties
+	roleContract := &RoleManagementContract{}
+	hasPermission, err := roleContract.CheckPermission(ctx, caller, "RECORD_DUTY")
+	if err != nil || !hasPermission {
+		return newError(ErrPermissionDenied, "caller %s does not have permission to record duties", caller)
+	}
+
+	transfer, err := s.GetTransfer(ctx, transferID)
+	if err != nil {
+		return err
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	timestamp := now.UTC().Format(time.RFC3339)
+
+	key := dutyKeyPrefix + transfer.To + "_" + transferID + "_" + jurisdiction
+	record := DutyRecord{
+		TransferID:     transferID,
+		Receiver:       transfer.To,
+		Jurisdiction:   jurisdiction,
+		RateBand:       input.RateBand,
+		DutyPaid:       input.DutyPaid,
+		DutyDeferred:   input.DutyDeferred,
+		TaxPaid:        input.TaxPaid,
+		TaxDeferred:    input.TaxDeferred,
+		DocumentHashes: input.DocumentHashes,
+		RecordedBy:     caller,
+		RecordedAt:     timestamp,
+		UpdatedBy:      caller,
+		UpdatedAt:      timestamp,
+		SchemaVersion:  CurrentSchemaVersion,
+	}
+	if record.DocumentHashes == nil {
+		record.DocumentHashes = []string{}
+	}
+	record.Status = record.status()
+
+	// An update keeps who created the record
+	existingJSON, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return err
+	}
+	if existingJSON != nil {
+		var existing DutyRecord
+		err = json.Unmarshal(existingJSON, &existing)
+		if err != nil {
+			return err
+		}
+		record.RecordedBy = existing.RecordedBy
+		record.RecordedAt = existing.RecordedAt
+	}
+
+	recordJSON, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState(key, recordJSON)
+	if err != nil {
+		return fmt.Errorf("failed to store duty record: %v", err)
+	}
+
+	return emitEvent(ctx, ChaincodeEvent{
+		EventType:  EventTransferDutyRecorded,
+		EntityType: EventEntityTransfer,
+		EntityID:   transferID,
+		Attributes: map[string]interface{}{
+			"jurisdiction": jurisdiction,
+			"receiver":     transfer.To,
+			"status":       record.Status,
+		},
+	})
+}
+
+// getDutyRecords reads the duty records under a key prefix
+func getDutyRecords(ctx contractapi.TransactionContextInterface, prefix string) ([]*DutyRecord, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByRange(prefix, prefix+"~")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query duty records: %v", err)
+	}
+	defer resultsIterator.Close()
+
+	records := []*DutyRecord{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var record DutyRecord
+		err = json.Unmarshal(queryResponse.Value, &record)
+		if err != nil {
+			return nil, err
+		}
+		record.upgradeSchema()
+		records = append(records, &record)
+	}
+
+	return records, nil
+}
+
+// GetTransferDuties returns the duty records of a transfer, ordered by jurisdiction
+func (s *SupplyChainContract) GetTransferDuties(ctx contractapi.TransactionContextInterface,
+	transferID string) ([]*DutyRecord, error) {
+
+	transfer, err := s.GetTransfer(ctx, transferID)
+	if err != nil {
+		return nil, err
+	}
+
+	return getDutyRecords(ctx, dutyKeyPrefix+transfer.To+"_"+transferID+"_")
+}
+
+// GetInboundDutyStatus summarizes the duty status of the shipments an organization receives
+func (s *SupplyChainContract) GetInboundDutyStatus(ctx contractapi.TransactionContextInterface,
+	orgMSPID string) (*InboundDutyStatus, error) {
+
+	if err := validateID("orgMSPID", orgMSPID); err != nil {
+		return nil, err
+	}
+
+	records, err := getDutyRecords(ctx, dutyKeyPrefix+orgMSPID+"_")
+	if err != nil {
+		return nil, err
+	}
+
+	summary := &InboundDutyStatus{
+		Organization:         orgMSPID,
+		OutstandingTransfers: []string{},
+		ByJurisdiction:       make(map[string]int),
+	}
+
+	// A transfer takes the least settled status of its jurisdictions
+	shipments := make(map[string]string)
+	for _, record := range records {
+		summary.ByJurisdiction[record.Jurisdiction]++
+		status := record.status()
+		previous, seen := shipments[record.TransferID]
+		if !seen || previous == dutyStatusPaid || status == dutyStatusDue {
+			shipments[record.TransferID] = status
+		}
+	}
+
+	transferIDs := make([]string, 0, len(shipments))
+	for transferID := range shipments {
+		transferIDs = append(transferIDs, transferID)
+	}
+	sort.Strings(transferIDs)
+	for _, transferID := range transferIDs {
+		summary.Shipments++
+		switch shipments[transferID] {
+		case dutyStatusPaid:
+			summary.Paid++
+		case dutyStatusDeferred:
+			summary.Deferred++
+		default:
+			summary.Outstanding++
+			summary.OutstandingTransfers = append(summary.OutstandingTransfers, transferID)
+		}
+	}
+
+	return summary, nil
+}
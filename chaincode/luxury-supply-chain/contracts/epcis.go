@@ -0,0 +1,697 @@
+package contracts
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// A brand's GS1 company prefix is stored as gs1_prefix_<brand>
+const gs1CompanyPrefixKeyPrefix = "gs1_prefix_"
+
+const (
+	epcisContext        = "https://ref.gs1.org/standards/epcis/epcis-context.jsonld"
+	epcisSchemaVersion  = "2.0"
+	epcisTimeZoneOffset = "+00:00" // Event times are exported in UTC
+	maxEPCISLogReads    = 10000
+)
+
+// Identifiers without a GS1 scheme, i.e. organizations, batches and the products of
+// brands without a company prefix, are private URNs under this namespace
+const privateURNPrefix = "urn:lsc:"
+
+// EPCIS event types and actions the export produces
+const (
+	EPCISObjectEvent      = "ObjectEvent"
+	EPCISAggregationEvent = "AggregationEvent"
+	EPCISActionAdd        = "ADD"
+	EPCISActionObserve    = "OBSERVE"
+)
+
+// CBV 2.0 business steps and dispositions, and the private step for customer-to-customer
+// ownership transfers, which CBV has no term for
+const (
+	bizStepCommissioning     = "commissioning"
+	bizStepPacking           = "packing"
+	bizStepShipping          = "shipping"
+	bizStepReceiving         = "receiving"
+	bizStepRetailSelling     = "retail_selling"
+	bizStepOwnershipTransfer = privateURNPrefix + "bizstep:ownership_transfer"
+	dispositionActive        = "active"
+	dispositionInProgress    = "in_progress"
+	dispositionInTransit     = "in_transit"
+	dispositionRetailSold    = "retail_sold"
+	epcisOwningParty         = "owning_party"
+)
+
+// gs1CompanyPrefixPattern matches GS1 company prefixes, 6 to 12 digits
+var gs1CompanyPrefixPattern = regexp.MustCompile(`^[0-9]{6,12}$`)
+
+// GS1CompanyPrefix is the company prefix GS1 assigned to a brand, used to build the
+// SGTINs of its products in the EPCIS export
+type GS1CompanyPrefix struct {
+	Brand         string `json:"brand"`
+	CompanyPrefix string `json:"companyPrefix"`
+	UpdatedBy     string `json:"updatedBy"`
+	UpdatedAt     string `json:"updatedAt"`
+	SchemaVersion int    `json:"schemaVersion"`
+}
+
+// EPCISDocument is a GS1 EPCIS 2.0 JSON-LD document
+type EPCISDocument struct {
+	Context       []string  `json:"@context"`
+	Type          string    `json:"type"` // EPCISDocument
+	SchemaVersion string    `json:"schemaVersion"`
+	CreationDate  string    `json:"creationDate"`
+	EPCISBody     EPCISBody `json:"epcisBody"`
+}
+
+// EPCISBody holds the events of an EPCISDocument, oldest first
+type EPCISBody struct {
+	EventList []*EPCISEvent `json:"eventList"`
+}
+
+// EPCISEvent is an EPCIS ObjectEvent or AggregationEvent. Object events list their
+// products in EPCList; aggregation events name the batch in ParentID and its products
+// in ChildEPCs.
+type EPCISEvent struct {
+	Type                string              `json:"type"`
+	EventID             string              `json:"eventID"` // Derived from the event's contents, so repeated exports agree
+	EventTime           string              `json:"eventTime"`
+	EventTimeZoneOffset string              `json:"eventTimeZoneOffset"`
+	EPCList             []string            `json:"epcList,omitempty" metadata:",optional"`
+	ParentID            string              `json:"parentID,omitempty" metadata:",optional"`
+	ChildEPCs           []string            `json:"childEPCs,omitempty" metadata:",optional"`
+	Action              string              `json:"action"`
+	BizStep             string              `json:"bizStep"`
+	Disposition         string              `json:"disposition"`
+	ReadPoint           *EPCISLocation      `json:"readPoint,omitempty" metadata:",optional"`
+	BizLocation         *EPCISLocation      `json:"bizLocation,omitempty" metadata:",optional"`
+	SourceList          []*EPCISSource      `json:"sourceList,omitempty" metadata:",optional"`
+	DestinationList     []*EPCISDestination `json:"destinationList,omitempty" metadata:",optional"`
+}
+
+// EPCISLocation is a read point or business location, here the organization's URN
+type EPCISLocation struct {
+	ID string `json:"id"`
+}
+
+// EPCISSource is the party goods leave
+type EPCISSource struct {
+	Type   string `json:"type"`
+	Source string `json:"source"`
+}
+
+// EPCISDestination is the party goods go to
+type EPCISDestination struct {
+	Type        string `json:"type"`
+	Destination string `json:"destination"`
+}
+
+// organizationURN identifies an organization in EPCIS events
+func organizationURN(mspID string) string {
+	return privateURNPrefix + "org:" + url.PathEscape(mspID)
+}
+
+// batchURN identifies a batch as the parent of aggregation events
+func batchURN(batchID string) string {
+	return privateURNPrefix + "batch:" + url.PathEscape(batchID)
+}
+
+// epcisLocation returns the location of an organization, or nil when it is unknown
+func epcisLocation(mspID string) *EPCISLocation {
+	if mspID == "" {
+		return nil
+	}
+	return &EPCISLocation{ID: organizationURN(mspID)}
+}
+
+// epcisTime converts a stored RFC3339 time to an EPCIS event time in UTC. Times not
+// set yet, e.g. PENDING confirmations, are reported as invalid.
+func epcisTime(value string) (string, bool) {
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return "", false
+	}
+	return parsed.UTC().Format(time.RFC3339), true
+}
+
+// epcisEventID returns a name-based UUID URN, version 8, from the SHA256 of parts
+func epcisEventID(parts ...string) string {
+	digest := sha256.Sum256([]byte(strings.Join(parts, "|")))
+	id := digest[:16]
+	id[6] = id[6]&0x0f | 0x80
+	id[8] = id[8]&0x3f | 0x80
+	hexID := hex.EncodeToString(id)
+	return "urn:uuid:" + hexID[0:8] + "-" + hexID[8:12] + "-" + hexID[12:16] + "-" + hexID[16:20] + "-" + hexID[20:32]
+}
+
+// newEPCISEvent builds an event of the given type and identifies it by its kind,
+// source record, time and items
+func newEPCISEvent(eventType string, kind string, recordID string, eventTime string,
+	parentID string, epcs []string) *EPCISEvent {
+
+	event := &EPCISEvent{
+		Type:                eventType,
+		EventID:             epcisEventID(kind, recordID, eventTime, parentID, strings.Join(epcs, ",")),
+		EventTime:           eventTime,
+		EventTimeZoneOffset: epcisTimeZoneOffset,
+	}
+	if eventType == EPCISAggregationEvent {
+		event.ParentID = parentID
+		event.ChildEPCs = epcs
+	} else {
+		event.EPCList = epcs
+	}
+	return event
+}
+
+// epcisExport builds EPCIS events from the ledger records, reading each brand's
+// company prefix once
+type epcisExport struct {
+	ctx      contractapi.TransactionContextInterface
+	prefixes map[string]string
+}
+
+func newEPCISExport(ctx contractapi.TransactionContextInterface) *epcisExport {
+	return &epcisExport{ctx: ctx, prefixes: make(map[string]string)}
+}
+
+// companyPrefix returns the GS1 company prefix of a brand, or "" when it has none
+func (e *epcisExport) companyPrefix(brand string) (string, error) {
+	if prefix, ok := e.prefixes[brand]; ok {
+		return prefix, nil
+	}
+	prefixJSON, err := e.ctx.GetStub().GetState(gs1CompanyPrefixKeyPrefix + brand)
+	if err != nil {
+		return "", fmt.Errorf("failed to read GS1 company prefix: %v", err)
+	}
+	prefix := ""
+	if prefixJSON != nil {
+		var record GS1CompanyPrefix
+		if err := json.Unmarshal(prefixJSON, &record); err != nil {
+			return "", err
+		}
+		prefix = record.CompanyPrefix
+	}
+	e.prefixes[brand] = prefix
+	return prefix, nil
+}
+
+// productEPC returns the EPC of a product. With a company prefix it is an SGTIN whose
+// item reference is derived from the brand and product type, indicator digit 0, and
+// whose serial is the product's serial number; without one it is a private URN of the
+// brand and serial number.
+func (e *epcisExport) productEPC(product *Product) (string, error) {
+	serialNumber := product.SerialNumber
+	if serialNumber == "" {
+		serialNumber = product.ID
+	}
+	prefix, err := e.companyPrefix(product.Brand)
+	if err != nil {
+		return "", err
+	}
+	if prefix == "" {
+		return privateURNPrefix + "product:" + url.PathEscape(product.Brand) + ":" + url.PathEscape(serialNumber), nil
+	}
+
+	// The company prefix and item reference share 12 digits
+	digits := 12 - len(prefix)
+	modulus := uint64(1)
+	for i := 0; i < digits; i++ {
+		modulus *= 10
+	}
+	digest := sha256.Sum256([]byte(product.Brand + "|" + product.Type))
+	itemReference := fmt.Sprintf("0%0*d", digits, binary.BigEndian.Uint64(digest[:8])%modulus)
+	return "urn:epc:id:sgtin:" + prefix + "." + itemReference + "." + url.PathEscape(serialNumber), nil
+}
+
+// productEPCs returns the EPCs of products, skipping products that no longer exist
+func (e *epcisExport) productEPCs(productIDs []string) ([]string, error) {
+	supplyChain := &SupplyChainContract{}
+	epcs := []string{}
+	for _, productID := range productIDs {
+		product, err := supplyChain.GetProduct(e.ctx, productID)
+		if err != nil {
+			logFor(e.ctx).Warn("skipping unreadable product in EPCIS export", "productId", productID, "error", err)
+			continue
+		}
+		epc, err := e.productEPC(product)
+		if err != nil {
+			return nil, err
+		}
+		epcs = append(epcs, epc)
+	}
+	return epcs, nil
+}
+
+// commissioningEvents reports products made by a manufacturer and, for batch products,
+// their packing into the batch
+func commissioningEvents(eventTime string, manufacturer string, batchID string, epcs []string) []*EPCISEvent {
+	commissioning := newEPCISEvent(EPCISObjectEvent, bizStepCommissioning, batchID, eventTime, "", epcs)
+	commissioning.Action = EPCISActionAdd
+	commissioning.BizStep = bizStepCommissioning
+	commissioning.Disposition = dispositionActive
+	commissioning.ReadPoint = epcisLocation(manufacturer)
+	commissioning.BizLocation = epcisLocation(manufacturer)
+	if batchID == "" {
+		return []*EPCISEvent{commissioning}
+	}
+
+	packing := newEPCISEvent(EPCISAggregationEvent, bizStepPacking, batchID, eventTime, batchURN(batchID), epcs)
+	packing.Action = EPCISActionAdd
+	packing.BizStep = bizStepPacking
+	packing.Disposition = dispositionInProgress
+	packing.ReadPoint = epcisLocation(manufacturer)
+	packing.BizLocation = epcisLocation(manufacturer)
+	return []*EPCISEvent{commissioning, packing}
+}
+
+// transferEPCISEvent reports a B2B transfer being shipped by its sender or received
+// by its receiver. Batch transfers are aggregation events of the batch.
+func transferEPCISEvent(transfer *Transfer, bizStep string, eventTime string, epcs []string) *EPCISEvent {
+	var event *EPCISEvent
+	if batchType, _ := transfer.Metadata["type"].(string); batchType == "BATCH" {
+		event = newEPCISEvent(EPCISAggregationEvent, bizStep, transfer.ID, eventTime, batchURN(transfer.ProductID), epcs)
+	} else {
+		event = newEPCISEvent(EPCISObjectEvent, bizStep, transfer.ID, eventTime, "", epcs)
+	}
+	event.Action = EPCISActionObserve
+	event.BizStep = bizStep
+	event.SourceList = []*EPCISSource{{Type: epcisOwningParty, Source: organizationURN(transfer.From)}}
+	event.DestinationList = []*EPCISDestination{{Type: epcisOwningParty, Destination: organizationURN(transfer.To)}}
+	if bizStep == bizStepShipping {
+		event.Disposition = dispositionInTransit
+		event.ReadPoint = epcisLocation(transfer.From)
+	} else {
+		event.Disposition = dispositionInProgress
+		event.ReadPoint = epcisLocation(transfer.To)
+		event.BizLocation = epcisLocation(transfer.To)
+	}
+	return event
+}
+
+// transferEPCISEvents reports the confirmed steps of a transfer: shipping once the
+// sender confirmed, receiving once it completed
+func transferEPCISEvents(transfer *Transfer, epcs []string) []*EPCISEvent {
+	events := []*EPCISEvent{}
+	if sentAt, ok := epcisTime(transfer.ConsensusDetails.SenderTimestamp); ok && transfer.ConsensusDetails.SenderConfirmed {
+		events = append(events, transferEPCISEvent(transfer, bizStepShipping, sentAt, epcs))
+	}
+	if transfer.Status == TransferStatusCompleted {
+		receivedAt, ok := epcisTime(transfer.ConsensusDetails.ReceiverTimestamp)
+		if !ok {
+			receivedAt, ok = epcisTime(transfer.CompletedAt)
+		}
+		if ok {
+			events = append(events, transferEPCISEvent(transfer, bizStepReceiving, receivedAt, epcs))
+		}
+	}
+	return events
+}
+
+// saleEPCISEvent reports a product sold to a customer at a retailer. Customers are
+// known by hash only and stay out of the export.
+func saleEPCISEvent(productID string, retailer string, eventTime string, epc string) *EPCISEvent {
+	event := newEPCISEvent(EPCISObjectEvent, bizStepRetailSelling, productID, eventTime, "", []string{epc})
+	event.Action = EPCISActionObserve
+	event.BizStep = bizStepRetailSelling
+	event.Disposition = dispositionRetailSold
+	event.ReadPoint = epcisLocation(retailer)
+	event.BizLocation = epcisLocation(retailer)
+	if retailer != "" {
+		event.SourceList = []*EPCISSource{{Type: epcisOwningParty, Source: organizationURN(retailer)}}
+	}
+	return event
+}
+
+// ownershipTransferEPCISEvent reports a product passed from one customer to another
+func ownershipTransferEPCISEvent(productID string, eventTime string, epc string) *EPCISEvent {
+	event := newEPCISEvent(EPCISObjectEvent, bizStepOwnershipTransfer, productID, eventTime, "", []string{epc})
+	event.Action = EPCISActionObserve
+	event.BizStep = bizStepOwnershipTransfer
+	event.Disposition = dispositionRetailSold
+	return event
+}
+
+// involvesOrganization reports whether an organization reads, holds, ships or
+// receives the goods of an event
+func (event *EPCISEvent) involvesOrganization(mspID string) bool {
+	urn := organizationURN(mspID)
+	if (event.ReadPoint != nil && event.ReadPoint.ID == urn) || (event.BizLocation != nil && event.BizLocation.ID == urn) {
+		return true
+	}
+	for _, source := range event.SourceList {
+		if source.Source == urn {
+			return true
+		}
+	}
+	for _, destination := range event.DestinationList {
+		if destination.Destination == urn {
+			return true
+		}
+	}
+	return false
+}
+
+// newEPCISDocument wraps events, sorted by time, in an EPCIS document
+func newEPCISDocument(ctx contractapi.TransactionContextInterface, events []*EPCISEvent) (*EPCISDocument, error) {
+	now, err := txTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].EventTime < events[j].EventTime
+	})
+	return &EPCISDocument{
+		Context:       []string{epcisContext},
+		Type:          "EPCISDocument",
+		SchemaVersion: epcisSchemaVersion,
+		CreationDate:  now.UTC().Format(time.RFC3339),
+		EPCISBody:     EPCISBody{EventList: events},
+	}, nil
+}
+
+// SetGS1CompanyPrefix records the GS1 company prefix of a brand, so the EPCIS export
+// identifies its products by SGTIN. Exports made earlier keep the private URNs they
+// used. Only the brand (super admin) may set it.
+func (s *SupplyChainContract) SetGS1CompanyPrefix(ctx contractapi.TransactionContextInterface,
+	brand string, companyPrefix string) error {
+
+	if err := validateName("brand", brand); err != nil {
+		return err
+	}
+	if !gs1CompanyPrefixPattern.MatchString(companyPrefix) {
+		return newError(ErrInvalidArgument, "companyPrefix must be a GS1 company prefix of 6 to 12 digits, got %q", companyPrefix)
+	}
+
+	caller, err := requireSuperAdmin(ctx)
+	if err != nil {
+		return err
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	record := &GS1CompanyPrefix{
+		Brand:         brand,
+		CompanyPrefix: companyPrefix,
+		UpdatedBy:     caller,
+		UpdatedAt:     now.UTC().Format(time.RFC3339),
+		SchemaVersion: CurrentSchemaVersion,
+	}
+	recordJSON, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	key := gs1CompanyPrefixKeyPrefix + brand
+	err = ctx.GetStub().PutState(key, recordJSON)
+	if err != nil {
+		return fmt.Errorf("failed to store GS1 company prefix: %v", err)
+	}
+
+	return emitEvent(ctx, ChaincodeEvent{
+		EventType:  EventGS1CompanyPrefixSet,
+		EntityType: EventEntityConfig,
+		EntityID:   key,
+		Attributes: map[string]interface{}{
+			"brand":         brand,
+			"companyPrefix": companyPrefix,
+		},
+	})
+}
+
+// GetGS1CompanyPrefix returns the GS1 company prefix recorded for a brand
+func (s *SupplyChainContract) GetGS1CompanyPrefix(ctx contractapi.TransactionContextInterface,
+	brand string) (*GS1CompanyPrefix, error) {
+
+	if err := validateName("brand", brand); err != nil {
+		return nil, err
+	}
+
+	recordJSON, err := ctx.GetStub().GetState(gs1CompanyPrefixKeyPrefix + brand)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read GS1 company prefix: %v", err)
+	}
+	if recordJSON == nil {
+		return nil, newError(ErrNotFound, "brand %s has no GS1 company prefix", brand)
+	}
+
+	var record GS1CompanyPrefix
+	err = json.Unmarshal(recordJSON, &record)
+	if err != nil {
+		return nil, err
+	}
+	record.upgradeSchema()
+
+	return &record, nil
+}
+
+// GetEPCISEvents exports the supply chain history of a product as an EPCIS 2.0
+// document: its commissioning and packing into its batch, the shipping and receipt of
+// its transfers and of its batch's, its retail sale and the transfers between
+// customers since. Like GetProductHistory, it is readable by every organization.
+func (s *SupplyChainContract) GetEPCISEvents(ctx contractapi.TransactionContextInterface,
+	productID string) (*EPCISDocument, error) {
+
+	if err := validateID("productID", productID); err != nil {
+		return nil, err
+	}
+	product, err := s.GetProduct(ctx, productID)
+	if err != nil {
+		return nil, err
+	}
+	export := newEPCISExport(ctx)
+	epc, err := export.productEPC(product)
+	if err != nil {
+		return nil, err
+	}
+	ownershipContract := &OwnershipContract{}
+
+	// Batch products are made by the batch's manufacturer, others where their
+	// certificate says
+	itemIDs := []string{productID}
+	manufacturer := ""
+	if product.BatchID != "" {
+		batch, err := s.GetBatch(ctx, product.BatchID)
+		if err != nil {
+			return nil, err
+		}
+		manufacturer = batch.Manufacturer
+		itemIDs = append(itemIDs, batch.ID)
+	} else if certificate, err := ownershipContract.GetBirthCertificate(ctx, productID); err == nil {
+		manufacturer = certificate.ManufacturingPlace
+	}
+
+	events := []*EPCISEvent{}
+	if createdAt, ok := epcisTime(product.CreatedAt); ok {
+		events = append(events, commissioningEvents(createdAt, manufacturer, product.BatchID, []string{epc})...)
+	}
+
+	for _, itemID := range itemIDs {
+		transferIDs, err := getIndexedIDs(ctx, transferItemIndex, itemID)
+		if err != nil {
+			return nil, err
+		}
+		for _, transferID := range transferIDs {
+			transfer, err := s.GetTransfer(ctx, transferID)
+			if err != nil {
+				logFor(ctx).Warn("skipping unreadable indexed transfer", "transferId", transferID, "error", err)
+				continue
+			}
+			events = append(events, transferEPCISEvents(transfer, []string{epc})...)
+		}
+	}
+
+	ownership, err := ownershipContract.GetOwnership(ctx, productID)
+	if err != nil && !hasErrorCode(err, ErrNotFound) {
+		return nil, err
+	}
+	if ownership != nil {
+		// The first owner bought the product; each later owner received it from the previous one
+		soldAt := ownership.OwnershipDate
+		if len(ownership.PreviousOwners) > 0 {
+			soldAt = ownership.PreviousOwners[0].OwnershipDate
+		}
+		if eventTime, ok := epcisTime(soldAt); ok {
+			events = append(events, saleEPCISEvent(productID, product.CurrentLocation, eventTime, epc))
+		}
+		for _, previous := range ownership.PreviousOwners {
+			if eventTime, ok := epcisTime(previous.TransferDate); ok {
+				events = append(events, ownershipTransferEPCISEvent(productID, eventTime, epc))
+			}
+		}
+	}
+
+	return newEPCISDocument(ctx, events)
+}
+
+// GetEPCISEventsByTimeRange exports the batch creations, transfer shipments and
+// receipts, retail sales and ownership transfers recorded in the ledger log from
+// fromTime up to, not including, toTime, both RFC3339, as an EPCIS 2.0 document.
+// At most 10000 log entries are read per call. The brand receives every event, other
+// organizations the events they take part in.
+func (s *SupplyChainContract) GetEPCISEventsByTimeRange(ctx contractapi.TransactionContextInterface,
+	fromTime string, toTime string) (*EPCISDocument, error) {
+
+	from, err := time.Parse(time.RFC3339, fromTime)
+	if err != nil {
+		return nil, newError(ErrInvalidArgument, "fromTime must be an RFC3339 time: %v", err)
+	}
+	to, err := time.Parse(time.RFC3339, toTime)
+	if err != nil {
+		return nil, newError(ErrInvalidArgument, "toTime must be an RFC3339 time: %v", err)
+	}
+	if !from.Before(to) {
+		return nil, newError(ErrInvalidArgument, "fromTime must be before toTime")
+	}
+
+	caller, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get caller identity: %v", err)
+	}
+	_, notBrand := requireSuperAdmin(ctx)
+	isBrand := notBrand == nil
+
+	startKey := ledgerLogKeyPrefix + from.UTC().Format(ledgerLogTimeLayout)
+	endKey := ledgerLogKeyPrefix + to.UTC().Format(ledgerLogTimeLayout)
+	resultsIterator, err := ctx.GetStub().GetStateByRange(startKey, endKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query ledger log: %v", err)
+	}
+	defer resultsIterator.Close()
+
+	export := newEPCISExport(ctx)
+	events := []*EPCISEvent{}
+	for read := 0; resultsIterator.HasNext(); read++ {
+		if read == maxEPCISLogReads {
+			logFor(ctx).Warn("EPCIS export stopped at its read limit", "fromTime", fromTime, "toTime", toTime)
+			break
+		}
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var entry LedgerLogEntry
+		if err := json.Unmarshal(queryResponse.Value, &entry); err != nil {
+			return nil, err
+		}
+		loggedAt, err := time.Parse(ledgerLogTimeLayout, strings.TrimPrefix(queryResponse.Key, ledgerLogKeyPrefix)[:len(ledgerLogTimeLayout)])
+		if err != nil {
+			logFor(ctx).Warn("skipping ledger log entry with an invalid time", "key", queryResponse.Key)
+			continue
+		}
+		eventTime := loggedAt.UTC().Format(time.RFC3339)
+
+		var entryEvents []*EPCISEvent
+		switch entry.EventType {
+		case EventBatchCreated, EventBatchProductsAppended:
+			entryEvents, err = s.batchCommissioningEvents(ctx, export, entry.EntityID, eventTime)
+		case EventTransferSentConfirmed, EventTransferCompleted:
+			entryEvents, err = s.transferStepEvents(ctx, export, entry.EntityID, entry.EventType, eventTime)
+		case EventOwnershipTaken, EventOwnershipTransferred:
+			var product *Product
+			product, err = s.GetProduct(ctx, entry.EntityID)
+			if err != nil {
+				break
+			}
+			var epc string
+			epc, err = export.productEPC(product)
+			if err != nil {
+				break
+			}
+			if entry.EventType == EventOwnershipTaken {
+				entryEvents = []*EPCISEvent{saleEPCISEvent(product.ID, product.CurrentLocation, eventTime, epc)}
+			} else {
+				entryEvents = []*EPCISEvent{ownershipTransferEPCISEvent(product.ID, eventTime, epc)}
+			}
+		}
+		if err != nil {
+			if hasErrorCode(err, ErrNotFound) {
+				logFor(ctx).Warn("skipping ledger log entry of a missing record", "key", queryResponse.Key, "error", err)
+				continue
+			}
+			return nil, err
+		}
+
+		for _, event := range entryEvents {
+			if isBrand || event.involvesOrganization(caller) {
+				events = append(events, event)
+			}
+		}
+	}
+
+	return newEPCISDocument(ctx, events)
+}
+
+// batchCommissioningEvents reports the products a batch creation or append made, i.e.
+// the batch's products created in that transaction's second
+func (s *SupplyChainContract) batchCommissioningEvents(ctx contractapi.TransactionContextInterface,
+	export *epcisExport, batchID string, eventTime string) ([]*EPCISEvent, error) {
+
+	batch, err := s.GetBatch(ctx, batchID)
+	if err != nil {
+		return nil, err
+	}
+	productIDs := []string{}
+	for _, productID := range batch.ProductIDs {
+		product, err := s.GetProduct(ctx, productID)
+		if err != nil {
+			logFor(ctx).Warn("skipping unreadable product in EPCIS export", "productId", productID, "error", err)
+			continue
+		}
+		if createdAt, ok := epcisTime(product.CreatedAt); ok && createdAt == eventTime {
+			productIDs = append(productIDs, productID)
+		}
+	}
+	if len(productIDs) == 0 {
+		return nil, nil
+	}
+	epcs, err := export.productEPCs(productIDs)
+	if err != nil {
+		return nil, err
+	}
+	return commissioningEvents(eventTime, batch.Manufacturer, batch.ID, epcs), nil
+}
+
+// transferStepEvents reports a transfer confirmed sent or completed. The products of
+// a batch transfer are those in the batch at export time.
+func (s *SupplyChainContract) transferStepEvents(ctx contractapi.TransactionContextInterface,
+	export *epcisExport, transferID string, eventType string, eventTime string) ([]*EPCISEvent, error) {
+
+	transfer, err := s.GetTransfer(ctx, transferID)
+	if err != nil {
+		return nil, err
+	}
+	var productIDs []string
+	if batchType, _ := transfer.Metadata["type"].(string); batchType == "BATCH" {
+		batch, err := s.GetBatch(ctx, transfer.ProductID)
+		if err != nil {
+			return nil, err
+		}
+		productIDs = batch.ProductIDs
+	} else {
+		productIDs = []string{transfer.ProductID}
+	}
+	epcs, err := export.productEPCs(productIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	bizStep := bizStepShipping
+	if eventType == EventTransferCompleted {
+		bizStep = bizStepReceiving
+	}
+	return []*EPCISEvent{transferEPCISEvent(transfer, bizStep, eventTime, epcs)}, nil
+}
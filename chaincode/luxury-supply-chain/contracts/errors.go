@@ -0,0 +1,63 @@
+package contracts
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrorCode classifies a chaincode failure so clients don't have to match on message text
+type ErrorCode string
+
+const (
+	ErrNotFound              ErrorCode = "NOT_FOUND"
+	ErrPermissionDenied      ErrorCode = "PERMISSION_DENIED"
+	ErrInvalidState          ErrorCode = "INVALID_STATE"
+	ErrInsufficientInventory ErrorCode = "INSUFFICIENT_INVENTORY"
+	ErrAlreadyExists         ErrorCode = "ALREADY_EXISTS"
+	ErrInvalidArgument       ErrorCode = "INVALID_ARGUMENT"
+	ErrConflict              ErrorCode = "CONFLICT"
+)
+
+// ChaincodeError is a failure with a machine-readable code.
+// It is serialized as "[CODE] message", which is what the peer returns to the gateway.
+type ChaincodeError struct {
+	Code    ErrorCode
+	Message string
+}
+
+func (e *ChaincodeError) Error() string {
+	return fmt.Sprintf("[%s] %s", e.Code, e.Message)
+}
+
+// newError creates a coded error
+func newError(code ErrorCode, format string, args ...interface{}) error {
+	return &ChaincodeError{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// wrapError adds context to err, keeping its code if it has one
+func wrapError(err error, format string, args ...interface{}) error {
+	context := fmt.Sprintf(format, args...)
+	var coded *ChaincodeError
+	if errors.As(err, &coded) {
+		return &ChaincodeError{Code: coded.Code, Message: context + ": " + coded.Message}
+	}
+	return fmt.Errorf("%s: %v", context, err)
+}
+
+// hasErrorCode reports whether err carries code
+func hasErrorCode(err error, code ErrorCode) bool {
+	var coded *ChaincodeError
+	return errors.As(err, &coded) && coded.Code == code
+}
+
+// errorFromMessage rebuilds an error from another chaincode's response message,
+// recovering its code when the message is in "[CODE] message" form
+func errorFromMessage(message string) error {
+	if strings.HasPrefix(message, "[") {
+		if end := strings.Index(message, "] "); end > 1 {
+			return &ChaincodeError{Code: ErrorCode(message[1:end]), Message: message[end+2:]}
+		}
+	}
+	return errors.New(message)
+}
@@ -0,0 +1,300 @@
+package contracts
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// EventSchemaVersion is bumped whenever the ChaincodeEvent layout changes
+const EventSchemaVersion = 1
+
+// Entity types referenced by events
+const (
+	EventEntityProduct      = "PRODUCT"
+	EventEntityBatch        = "BATCH"
+	EventEntityTransfer     = "TRANSFER"
+	EventEntityMaterial     = "MATERIAL"
+	EventEntityOwnership    = "OWNERSHIP"
+	EventEntityOrganization = "ORGANIZATION"
+	EventEntityConfig       = "CONFIG"
+	EventEntityCraftsman    = "CRAFTSMAN"
+	EventEntityAnomaly      = "ANOMALY"
+	EventEntityTemplate     = "TEMPLATE"
+	EventEntityCounterfeit  = "COUNTERFEIT"
+)
+
+// Event types, one per state change. Each is emitted under its own name and its
+// attributes are listed in the README; adding or renaming one is a contract change.
+const (
+	// Batches (entity BATCH)
+	EventBatchCreated           = "BatchCreated"
+	EventBatchProductsAppended  = "BatchProductsAppended"
+	EventBatchFinalized         = "BatchFinalized"
+	EventBatchApproved          = "BatchApproved"
+	EventBatchLocationUpdated   = "BatchLocationUpdated"
+	EventBatchManifestGenerated = "BatchManifestGenerated"
+	EventBatchesMerged          = "BatchesMerged"
+
+	// B2B transfers (entity TRANSFER)
+	EventTransferInitiated         = "TransferInitiated"
+	EventBatchTransferInitiated    = "BatchTransferInitiated"
+	EventTransferSentConfirmed     = "TransferSentConfirmed"
+	EventShippingEvidenceAttached  = "ShippingEvidenceAttached"
+	EventTransferHandedOff         = "TransferHandedOffToCarrier"
+	EventTransferCarrierDelivered  = "TransferCarrierDelivered"
+	EventTransferCompleted         = "TransferCompleted"
+	EventTransferPartiallyReceived = "TransferPartiallyReceived"
+	EventPaymentTermsSet           = "PaymentTermsSet"
+	EventReturnProcessed           = "ReturnProcessed"
+	EventDisputeResolutionTransfer = "DisputeResolutionTransferCreated"
+	EventTransferDutyRecorded      = "TransferDutyRecorded"
+	EventTradeDocumentAttached     = "TradeDocumentAttached"
+	EventDeclaredValueSet          = "DeclaredValueSet"
+	EventTransferPriorityChanged   = "TransferPriorityChanged"
+	EventTransferScheduled         = "TransferScheduled"
+	EventExpectedDeliveryDateSet   = "ExpectedDeliveryDateSet"
+	EventLateDeliveryReported      = "LateDeliveryReported"
+	EventTransferCancelled         = "TransferCancelled"
+	EventTransferRejected          = "TransferRejected"
+	EventTransferTermsSet          = "TransferTermsSet"
+	EventTransitCheckpointAdded    = "TransitCheckpointAdded"
+	EventSensorDataAnchored        = "SensorDataAnchored"
+	EventSensorEvidenceCited       = "SensorEvidenceCited"
+	EventTransfersExpired          = "TransfersExpired"
+	EventDamageClaimOpened         = "DamageClaimOpened"
+	EventDamageClaimResponded      = "DamageClaimResponded"
+	EventDamageClaimResolved       = "DamageClaimResolved"
+
+	// Transfers of products registered stolen (entity TRANSFER)
+	EventStolenProductMovementAttempt = "StolenProductMovementAttempt"
+
+	// Materials (entity MATERIAL)
+	EventMaterialInventoryCreated       = "MaterialInventoryCreated"
+	EventMaterialTransferInitiated      = "MaterialTransferInitiated"
+	EventMaterialReceiptConfirmed       = "MaterialReceiptConfirmed"
+	EventReturnTransferReceiptConfirmed = "ReturnTransferReceiptConfirmed"
+	EventMaterialTransferStatusUpdated  = "MaterialTransferStatusUpdated"
+	EventSourcingDeclarationSubmitted   = "SourcingDeclarationSubmitted"
+	EventUpstreamSourcesDeclared        = "UpstreamSourcesDeclared"
+	EventMaterialTransferTermsSet       = "MaterialTransferTermsSet"
+	EventMaterialReserved               = "MaterialReserved"
+	EventMaterialReservationReleased    = "MaterialReservationReleased"
+	EventMaterialReservationConsumed    = "MaterialReservationConsumed"
+	EventMaterialEmissionsRecorded      = "MaterialEmissionsRecorded"
+
+	// Products (entity PRODUCT)
+	EventBirthCertificateCreated = "BirthCertificateCreated"
+	EventBirthCertificateAmended = "BirthCertificateAmended"
+	EventBirthCertificateRevoked = "BirthCertificateRevoked"
+	EventChipKeyRegistered       = "ChipKeyRegistered"
+	EventChipVerificationFailed  = "ChipVerificationFailed"
+	EventOwnershipTaken          = "OwnershipTaken"
+	EventProductReportedStolen   = "ProductReportedStolen"
+	EventProductRecovered        = "ProductRecovered"
+	EventCustomerReturnProcessed = "CustomerReturnProcessed"
+	EventConditionPhotosAdded    = "ConditionPhotosAdded"
+	EventProductMarkedDemo       = "ProductMarkedDemo"
+	EventDemoUnitConverted       = "DemoUnitConverted"
+	EventSecurityFeaturesAmended = "SecurityFeaturesAmended"
+	EventProductDamaged          = "ProductDamaged"
+	EventProductDestroyed        = "ProductDestroyed"
+
+	// Digital passports (entity PRODUCT)
+	EventPassportMintRequested = "PassportMintRequested"
+	EventPassportAnchored      = "PassportAnchored"
+
+	// Sustainability (entity PRODUCT or BATCH)
+	EventSustainabilityInfoSet = "SustainabilityInfoSet"
+
+	// Write-offs (entity PRODUCT or MATERIAL)
+	EventWriteOffRequested = "WriteOffRequested"
+	EventWriteOffApproved  = "WriteOffApproved"
+	EventWriteOffRejected  = "WriteOffRejected"
+
+	// QR code reissues (entity PRODUCT or BATCH)
+	EventIdentifierReissueRequested = "IdentifierReissueRequested"
+	EventIdentifierReissued         = "IdentifierReissued"
+	EventIdentifierReissueRejected  = "IdentifierReissueRejected"
+
+	// NFC chip replacements (entity PRODUCT)
+	EventNFCChipReplacementRequested = "NFCChipReplacementRequested"
+	EventNFCChipReplaced             = "NFCChipReplaced"
+	EventNFCChipReplacementRejected  = "NFCChipReplacementRejected"
+
+	// Customer ownership (entity OWNERSHIP)
+	EventTransferCodeGenerated = "TransferCodeGenerated"
+	EventOwnershipTransferred  = "OwnershipTransferred"
+	EventServiceRecordAdded    = "ServiceRecordAdded"
+
+	// Warranty claims (entity OWNERSHIP)
+	EventWarrantyClaimFiled    = "WarrantyClaimFiled"
+	EventWarrantyClaimResolved = "WarrantyClaimResolved"
+
+	// Insurance (entity PRODUCT); loss claims are opened by ProductReportedStolen
+	// and ProductDestroyed, which then carry an insuranceClaimId attribute
+	EventInsurancePolicyRegistered = "InsurancePolicyRegistered"
+	EventInsuranceClaimClosed      = "InsuranceClaimClosed"
+
+	// Two-phase customer ownership transfers (entity OWNERSHIP)
+	EventTransferCodeRedeemed       = "TransferCodeRedeemed"
+	EventOwnershipTransferCancelled = "OwnershipTransferCancelled"
+
+	// Certified pre-owned resale (entity PRODUCT)
+	EventResaleCertificationRequested = "ResaleCertificationRequested"
+	EventProductCertifiedPreOwned     = "ProductCertifiedPreOwned"
+	EventResaleCertificationRejected  = "ResaleCertificationRejected"
+	EventAppraisalRecorded            = "AppraisalRecorded"
+
+	// Document anchoring (entity PRODUCT; ServicePhotosAdded entity OWNERSHIP)
+	EventCertificatePhotosAdded = "CertificatePhotosAdded"
+	EventProductDocumentAdded   = "ProductDocumentAdded"
+	EventServicePhotosAdded     = "ServicePhotosAdded"
+
+	// Consignment (entity PRODUCT)
+	EventConsignmentSettled = "ConsignmentSettled"
+
+	// Organizations (entity ORGANIZATION)
+	EventOrganizationRoleAssigned    = "OrganizationRoleAssigned"
+	EventOrganizationDIDRegistered   = "OrganizationDIDRegistered"
+	EventOrganizationStatsCompacted  = "OrganizationStatsCompacted"
+	EventOrganizationStatsRecounted  = "OrganizationStatsRecounted"
+	EventScheduledTransfersActivated = "ScheduledTransfersActivated"
+
+	// Craftsmen (entity CRAFTSMAN)
+	EventCraftsmanRegistered  = "CraftsmanRegistered"
+	EventCraftsmanDeactivated = "CraftsmanDeactivated"
+
+	// Product templates (entity TEMPLATE)
+	EventProductTemplateCreated = "ProductTemplateCreated"
+
+	// Anomalies (entity ANOMALY)
+	EventAnomalyReviewed = "AnomalyReviewed"
+
+	// Counterfeit reports (entity COUNTERFEIT)
+	EventCounterfeitReported       = "CounterfeitReported"
+	EventCounterfeitReportResolved = "CounterfeitReportResolved"
+
+	// Configuration (entity CONFIG)
+	EventConsensusConfigUpdated    = "ConsensusConfigUpdated"
+	EventPaymentConfigUpdated      = "PaymentConfigUpdated"
+	EventFeatureFlagsUpdated       = "FeatureFlagsUpdated"
+	EventCheckpointAnchored        = "CheckpointAnchored"
+	EventOracleRegistered          = "OracleRegistered"
+	EventOracleDeactivated         = "OracleDeactivated"
+	EventOracleDataSubmitted       = "OracleDataSubmitted"
+	EventRegulatedMaterialsUpdated = "RegulatedMaterialsUpdated"
+	EventCurrencyConfigUpdated     = "CurrencyConfigUpdated"
+	EventTransferFlowRulesUpdated  = "TransferFlowRulesUpdated"
+	EventConsensusPolicyUpdated    = "ConsensusPolicyUpdated"
+	EventConsensusPolicyRemoved    = "ConsensusPolicyRemoved"
+	EventOwnershipRulesUpdated     = "OwnershipRulesUpdated"
+	EventWarrantyTermsUpdated      = "WarrantyTermsUpdated"
+	EventGS1CompanyPrefixSet       = "GS1CompanyPrefixSet"
+	EventTradeDocumentRulesUpdated = "TradeDocumentRulesUpdated"
+)
+
+// ChaincodeEvent is the payload of every event emitted by the supply chain contracts.
+// It carries identifiers and the state change only, never whole ledger records,
+// so consumers don't depend on the internal struct layouts.
+type ChaincodeEvent struct {
+	SchemaVersion int                    `json:"schemaVersion"`
+	EventType     string                 `json:"eventType"`
+	EntityType    string                 `json:"entityType"`
+	EntityID      string                 `json:"entityId"`
+	FromState     string                 `json:"fromState,omitempty"`
+	ToState       string                 `json:"toState,omitempty"`
+	Actor         string                 `json:"actor,omitempty"` // MSP ID of the invoking organization
+	TxID          string                 `json:"txId"`
+	Timestamp     string                 `json:"timestamp"`
+	Attributes    map[string]interface{} `json:"attributes,omitempty"`
+}
+
+// emitEvent stamps the common fields, records the event in the ledger log for
+// checkpoints and sets it on the transaction.
+// Fabric keeps only the last event set in a transaction.
+func emitEvent(ctx contractapi.TransactionContextInterface, event ChaincodeEvent) error {
+	event.SchemaVersion = EventSchemaVersion
+	event.TxID = ctx.GetStub().GetTxID()
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	event.Timestamp = now.UTC().Format(time.RFC3339)
+	if event.Actor == "" {
+		if mspID, err := ctx.GetClientIdentity().GetMSPID(); err == nil {
+			event.Actor = mspID
+		}
+	}
+	// Fabric keeps one event per transaction, so flagged anomalies ride on it
+	if tc, ok := ctx.(*TransactionContext); ok && len(tc.anomalyIDs) > 0 {
+		if event.Attributes == nil {
+			event.Attributes = map[string]interface{}{}
+		}
+		event.Attributes["anomalies"] = tc.anomalyIDs
+	}
+
+	eventJSON, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	if err := recordLedgerLogEntry(ctx, event, eventJSON); err != nil {
+		return err
+	}
+
+	return ctx.GetStub().SetEvent(event.EventType, eventJSON)
+}
+
+// transferEvent builds the event for a B2B transfer state change
+func transferEvent(eventType string, transfer *Transfer, fromState TransferStatus) ChaincodeEvent {
+	event := ChaincodeEvent{
+		EventType:  eventType,
+		EntityType: EventEntityTransfer,
+		EntityID:   transfer.ID,
+		FromState:  string(fromState),
+		ToState:    string(transfer.Status),
+		Attributes: map[string]interface{}{
+			"itemId":       transfer.ProductID,
+			"from":         transfer.From,
+			"to":           transfer.To,
+			"transferType": transfer.TransferType,
+		},
+	}
+	if isUrgentTransfer(transfer) {
+		event.Attributes["priority"] = transfer.Priority
+	}
+	return event
+}
+
+// batchCreatedEvent builds the event for a new batch, complete or still assembling
+func batchCreatedEvent(batch *ProductBatch) ChaincodeEvent {
+	attributes := map[string]interface{}{
+		"manufacturer": batch.Manufacturer,
+		"brand":        batch.Brand,
+		"productType":  batch.ProductType,
+		"quantity":     batch.Quantity,
+	}
+	if len(batch.Variants) > 0 {
+		codes := make([]string, len(batch.Variants))
+		for i, variant := range batch.Variants {
+			codes[i] = variant.Code
+		}
+		attributes["variants"] = codes
+	}
+	if waiver, err := batchExpiryWaiver(batch); err == nil && waiver != nil {
+		materialIDs := make([]string, len(waiver.Materials))
+		for i, material := range waiver.Materials {
+			materialIDs[i] = material.MaterialID
+		}
+		attributes["expiryWaivedMaterials"] = materialIDs
+	}
+
+	return ChaincodeEvent{
+		EventType:  EventBatchCreated,
+		EntityType: EventEntityBatch,
+		EntityID:   batch.ID,
+		ToState:    string(batch.Status),
+		Attributes: attributes,
+	}
+}
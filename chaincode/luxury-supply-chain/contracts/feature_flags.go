@@ -0,0 +1,55 @@
+package contracts
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// featureFlagsKey holds the FeatureFlags
+const featureFlagsKey = "config_feature_flags"
+
+// FeatureFlags toggles optional contract behavior for this network
+type FeatureFlags struct {
+	EnableAutoConfirm    bool   `json:"enableAutoConfirm"`    // Consensus may confirm transfers from high-trust senders on their own
+	RequireBrandApproval bool   `json:"requireBrandApproval"` // Batches need ApproveBatch before leaving their manufacturer
+	UpdatedBy            string `json:"updatedBy,omitempty" metadata:",optional"`
+	UpdatedAt            string `json:"updatedAt,omitempty" metadata:",optional"`
+	SchemaVersion        int    `json:"schemaVersion"`
+}
+
+// featureFlagsUpdate is the input of SetFeatureFlags, omitted flags keep their value
+type featureFlagsUpdate struct {
+	EnableAutoConfirm    *bool `json:"enableAutoConfirm"`
+	RequireBrandApproval *bool `json:"requireBrandApproval"`
+}
+
+// defaultFeatureFlags matches the behavior before feature flags existed
+func defaultFeatureFlags() *FeatureFlags {
+	return &FeatureFlags{
+		EnableAutoConfirm:    true,
+		RequireBrandApproval: false,
+		SchemaVersion:        CurrentSchemaVersion,
+	}
+}
+
+// getFeatureFlags reads the stored FeatureFlags, falling back to the defaults
+func getFeatureFlags(ctx contractapi.TransactionContextInterface) (*FeatureFlags, error) {
+	flagsJSON, err := ctx.GetStub().GetState(featureFlagsKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read feature flags: %v", err)
+	}
+	if flagsJSON == nil {
+		return defaultFeatureFlags(), nil
+	}
+
+	var flags FeatureFlags
+	err = json.Unmarshal(flagsJSON, &flags)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse feature flags: %v", err)
+	}
+	flags.upgradeSchema()
+
+	return &flags, nil
+}
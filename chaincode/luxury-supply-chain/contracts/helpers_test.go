@@ -0,0 +1,100 @@
+package contracts
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+	"github.com/stretchr/testify/require"
+)
+
+// testIdentity is the client identity of the organization calling a contract in a test
+type testIdentity struct {
+	mspID string
+}
+
+func (i *testIdentity) GetID() (string, error)    { return "x509::CN=user1::" + i.mspID, nil }
+func (i *testIdentity) GetMSPID() (string, error) { return i.mspID, nil }
+
+func (i *testIdentity) GetAttributeValue(attrName string) (string, bool, error) {
+	return "", false, nil
+}
+
+func (i *testIdentity) AssertAttributeValue(attrName, attrValue string) error {
+	return fmt.Errorf("attribute %s not found", attrName)
+}
+
+func (i *testIdentity) GetX509Certificate() (*x509.Certificate, error) {
+	return nil, nil
+}
+
+// testLedger is a mock ledger the tests call contract functions against directly,
+// one transaction at a time
+type testLedger struct {
+	t    *testing.T
+	stub *shimtest.MockStub
+	txs  int
+}
+
+// newTestLedger returns an empty ledger with the organization roles initialized
+func newTestLedger(t *testing.T) *testLedger {
+	ledger := &testLedger{t: t, stub: shimtest.NewMockStub("luxury-supply-chain", nil)}
+	require.NoError(t, (&SupplyChainContract{}).InitLedger(ledger.as("LuxeBagsMSP")))
+	return ledger
+}
+
+// as starts a new transaction called by the organization mspID and returns its context
+func (l *testLedger) as(mspID string) *TransactionContext {
+	l.txs++
+	l.stub.MockTransactionStart(fmt.Sprintf("tx%04d", l.txs))
+	seed := []byte(fmt.Sprintf("test seed %04d....", l.txs))
+	l.stub.TransientMap = map[string][]byte{disclosureSeedTransientKey: seed, tokenSeedTransientKey: seed}
+
+	ctx := new(TransactionContext)
+	ctx.SetStub(l.stub)
+	ctx.SetClientIdentity(&testIdentity{mspID: mspID})
+	return ctx
+}
+
+// put writes a record to the ledger outside of any contract function
+func (l *testLedger) put(key string, record interface{}) {
+	recordJSON, err := json.Marshal(record)
+	require.NoError(l.t, err)
+	require.NoError(l.t, l.stub.PutState(key, recordJSON))
+}
+
+// get reads a record written by a contract function
+func (l *testLedger) get(key string, record interface{}) {
+	recordJSON := l.stub.State[key]
+	require.NotNil(l.t, recordJSON, "no record under %s", key)
+	require.NoError(l.t, json.Unmarshal(recordJSON, record))
+}
+
+// keys returns the keys of the ledger in range [startKey, endKey)
+func (l *testLedger) keys(startKey string, endKey string) []string {
+	iterator, err := l.stub.GetStateByRange(startKey, endKey)
+	require.NoError(l.t, err)
+	defer iterator.Close()
+
+	keys := []string{}
+	for iterator.HasNext() {
+		kv, err := iterator.Next()
+		require.NoError(l.t, err)
+		keys = append(keys, kv.Key)
+	}
+	return keys
+}
+
+// failingStub fails every state read, as a peer whose ledger cannot be read
+type failingStub struct {
+	*shimtest.MockStub
+}
+
+func (s *failingStub) GetState(key string) ([]byte, error) {
+	return nil, fmt.Errorf("ledger unavailable")
+}
+
+var _ shim.ChaincodeStubInterface = (*failingStub)(nil)
@@ -0,0 +1,343 @@
+package contracts
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Reissue requests are stored as reissue_<reissueID>
+const reissueKeyPrefix = "reissue_"
+
+// Reissue request statuses
+const (
+	ReissuePending  = "PENDING"
+	ReissueApproved = "APPROVED"
+	ReissueRejected = "REJECTED"
+)
+
+// IdentifierReissue is a request to replace the QR code of a damaged label. The holder
+// requests it and the brand approves it, so nobody can relabel goods on their own.
+type IdentifierReissue struct {
+	ReissueID     string `json:"reissueId"`
+	ItemType      string `json:"itemType"` // PRODUCT or BATCH
+	ItemID        string `json:"itemId"`
+	RequestedBy   string `json:"requestedBy"`
+	Reason        string `json:"reason"` // e.g. label torn in transit
+	Status        string `json:"status"`
+	RequestedAt   string `json:"requestedAt"`
+	DecidedBy     string `json:"decidedBy,omitempty" metadata:",optional"`
+	DecidedAt     string `json:"decidedAt,omitempty" metadata:",optional"`
+	DecisionNote  string `json:"decisionNote,omitempty" metadata:",optional"`
+	Sequence      int    `json:"sequence,omitempty" metadata:",optional"` // Of the new code, once approved
+	SchemaVersion int    `json:"schemaVersion"`
+}
+
+// getIdentifierReissue reads a reissue request
+func getIdentifierReissue(ctx contractapi.TransactionContextInterface, reissueID string) (*IdentifierReissue, error) {
+	reissueJSON, err := ctx.GetStub().GetState(reissueKeyPrefix + reissueID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read reissue request: %v", err)
+	}
+	if reissueJSON == nil {
+		return nil, newError(ErrNotFound, "reissue request %s does not exist", reissueID)
+	}
+
+	var reissue IdentifierReissue
+	err = json.Unmarshal(reissueJSON, &reissue)
+	if err != nil {
+		return nil, err
+	}
+	reissue.upgradeSchema()
+
+	return &reissue, nil
+}
+
+// putIdentifierReissue stores a reissue request
+func putIdentifierReissue(ctx contractapi.TransactionContextInterface, reissue *IdentifierReissue) error {
+	reissueJSON, err := json.Marshal(reissue)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState(reissueKeyPrefix+reissue.ReissueID, reissueJSON)
+	if err != nil {
+		return fmt.Errorf("failed to store reissue request: %v", err)
+	}
+	return nil
+}
+
+// requestReissue stores a pending reissue request by the holder of the item or the brand
+func requestReissue(ctx contractapi.TransactionContextInterface,
+	reissueID string, itemType string, itemID string, holder string, reason string) error {
+
+	existing, err := ctx.GetStub().GetState(reissueKeyPrefix + reissueID)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return newError(ErrAlreadyExists, "reissue request %s already exists", reissueID)
+	}
+
+	caller, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to get caller identity: %v", err)
+	}
+	if caller != holder {
+		if _, err := requireSuperAdmin(ctx); err != nil {
+			return newError(ErrPermissionDenied, "only the holder of %s or the brand can request a new QR code", itemID)
+		}
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	reissue := &IdentifierReissue{
+		ReissueID:     reissueID,
+		ItemType:      itemType,
+		ItemID:        itemID,
+		RequestedBy:   caller,
+		Reason:        reason,
+		Status:        ReissuePending,
+		RequestedAt:   now.UTC().Format(time.RFC3339),
+		SchemaVersion: CurrentSchemaVersion,
+	}
+	err = putIdentifierReissue(ctx, reissue)
+	if err != nil {
+		return err
+	}
+
+	entityType := EventEntityProduct
+	if itemType == "BATCH" {
+		entityType = EventEntityBatch
+	}
+	return emitEvent(ctx, ChaincodeEvent{
+		EventType:  EventIdentifierReissueRequested,
+		EntityType: entityType,
+		EntityID:   itemID,
+		Attributes: map[string]interface{}{
+			"reissueId": reissueID,
+			"reason":    reason,
+		},
+	})
+}
+
+// RegenerateBatchQRCode asks the brand for a new QR code for a batch whose label is
+// damaged. The current code stays valid until ApproveIdentifierReissue.
+func (s *SupplyChainContract) RegenerateBatchQRCode(ctx contractapi.TransactionContextInterface,
+	reissueID string, batchID string, reason string) error {
+
+	if err := validateAll(
+		validateID("reissueID", reissueID),
+		validateID("batchID", batchID),
+		validateRequired("reason", reason, maxNameLength),
+	); err != nil {
+		return err
+	}
+
+	batch, err := s.GetBatch(ctx, batchID)
+	if err != nil {
+		return err
+	}
+
+	return requestReissue(ctx, reissueID, "BATCH", batchID, batch.CurrentOwner, reason)
+}
+
+// ReissueProductIdentifier asks the brand for a new QR code for a product whose label
+// is damaged. The current code stays valid until ApproveIdentifierReissue.
+func (s *SupplyChainContract) ReissueProductIdentifier(ctx contractapi.TransactionContextInterface,
+	reissueID string, productID string, reason string) error {
+
+	if err := validateAll(
+		validateID("reissueID", reissueID),
+		validateID("productID", productID),
+		validateRequired("reason", reason, maxNameLength),
+	); err != nil {
+		return err
+	}
+
+	product, err := s.GetProduct(ctx, productID)
+	if err != nil {
+		return err
+	}
+	if product.Status == ProductStatusDestroyed || product.Status == ProductStatusWrittenOff {
+		return newError(ErrInvalidState, "product %s is %s", productID, product.Status)
+	}
+
+	return requestReissue(ctx, reissueID, "PRODUCT", productID, product.CurrentOwner, reason)
+}
+
+// decideIdentifierReissue checks that the caller is the brand and the request is pending
+func decideIdentifierReissue(ctx contractapi.TransactionContextInterface,
+	reissueID string) (*IdentifierReissue, string, error) {
+
+	caller, err := requireSuperAdmin(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+
+	reissue, err := getIdentifierReissue(ctx, reissueID)
+	if err != nil {
+		return nil, "", err
+	}
+	if reissue.Status != ReissuePending {
+		return nil, "", newError(ErrInvalidState, "reissue request %s is already %s", reissueID, reissue.Status)
+	}
+
+	return reissue, caller, nil
+}
+
+// nextQRSequence returns the sequence of the code replacing current. Batch codes from
+// before signed codes cannot be parsed and are replaced by sequence 1.
+func nextQRSequence(current string) int {
+	payload, err := parseQRCode(current)
+	if err != nil {
+		return 1
+	}
+	return payload.Sequence + 1
+}
+
+// ApproveIdentifierReissue issues the new QR code of a reissue request. The old code is
+// kept on the certificate or batch so scans of it are rejected as replaced.
+func (s *SupplyChainContract) ApproveIdentifierReissue(ctx contractapi.TransactionContextInterface,
+	reissueID string) error {
+
+	if err := validateID("reissueID", reissueID); err != nil {
+		return err
+	}
+
+	reissue, caller, err := decideIdentifierReissue(ctx, reissueID)
+	if err != nil {
+		return err
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	timestamp := now.UTC().Format(time.RFC3339)
+
+	entityType := EventEntityProduct
+	if reissue.ItemType == "BATCH" {
+		entityType = EventEntityBatch
+		batch, err := s.GetBatch(ctx, reissue.ItemID)
+		if err != nil {
+			return err
+		}
+		reissue.Sequence = nextQRSequence(batch.QRCode)
+		code, err := issueQRCode(ctx, "", batch.ID, reissue.Sequence)
+		if err != nil {
+			return err
+		}
+		batch.RetiredQRCodes = append(batch.RetiredQRCodes, RetiredCode{
+			Code:      batch.QRCode,
+			RetiredAt: timestamp,
+			ReissueID: reissueID,
+		})
+		batch.QRCode = code
+		err = putBatch(ctx, batch)
+		if err != nil {
+			return err
+		}
+	} else {
+		ownershipContract := &OwnershipContract{}
+		certificate, err := ownershipContract.GetBirthCertificate(ctx, reissue.ItemID)
+		if err != nil {
+			return err
+		}
+		product, err := s.GetProduct(ctx, reissue.ItemID)
+		if err != nil {
+			return err
+		}
+		authenticity := &certificate.Authenticity
+		reissue.Sequence = nextQRSequence(authenticity.QRCodeData)
+		code, err := issueQRCode(ctx, product.ID, product.BatchID, reissue.Sequence)
+		if err != nil {
+			return err
+		}
+		authenticity.RetiredQRCodes = append(authenticity.RetiredQRCodes, RetiredCode{
+			Code:      authenticity.QRCodeData,
+			RetiredAt: timestamp,
+			ReissueID: reissueID,
+		})
+		authenticity.QRCodeData = code
+		err = recommitCertificate(ctx, certificate, "identifiers reissued")
+		if err != nil {
+			return err
+		}
+	}
+
+	reissue.Status = ReissueApproved
+	reissue.DecidedBy = caller
+	reissue.DecidedAt = timestamp
+	err = putIdentifierReissue(ctx, reissue)
+	if err != nil {
+		return err
+	}
+
+	return emitEvent(ctx, ChaincodeEvent{
+		EventType:  EventIdentifierReissued,
+		EntityType: entityType,
+		EntityID:   reissue.ItemID,
+		Attributes: map[string]interface{}{
+			"reissueId": reissueID,
+			"sequence":  reissue.Sequence,
+		},
+	})
+}
+
+// RejectIdentifierReissue refuses a reissue request; the current code stays valid
+func (s *SupplyChainContract) RejectIdentifierReissue(ctx contractapi.TransactionContextInterface,
+	reissueID string, note string) error {
+
+	if err := validateAll(
+		validateID("reissueID", reissueID),
+		validateRequired("note", note, maxTextLength),
+	); err != nil {
+		return err
+	}
+
+	reissue, caller, err := decideIdentifierReissue(ctx, reissueID)
+	if err != nil {
+		return err
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	reissue.Status = ReissueRejected
+	reissue.DecidedBy = caller
+	reissue.DecidedAt = now.UTC().Format(time.RFC3339)
+	reissue.DecisionNote = note
+	err = putIdentifierReissue(ctx, reissue)
+	if err != nil {
+		return err
+	}
+
+	entityType := EventEntityProduct
+	if reissue.ItemType == "BATCH" {
+		entityType = EventEntityBatch
+	}
+	return emitEvent(ctx, ChaincodeEvent{
+		EventType:  EventIdentifierReissueRejected,
+		EntityType: entityType,
+		EntityID:   reissue.ItemID,
+		Attributes: map[string]interface{}{
+			"reissueId": reissueID,
+		},
+	})
+}
+
+// GetIdentifierReissue returns a reissue request
+func (s *SupplyChainContract) GetIdentifierReissue(ctx contractapi.TransactionContextInterface,
+	reissueID string) (*IdentifierReissue, error) {
+
+	if err := validateID("reissueID", reissueID); err != nil {
+		return nil, err
+	}
+
+	return getIdentifierReissue(ctx, reissueID)
+}
@@ -0,0 +1,354 @@
+package contracts
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+	insurancePolicyKeyPrefix = "insurance_policy_"
+	insuranceClaimKeyPrefix  = "insurance_claim_"
+)
+
+// Losses an insurance claim can be opened for
+const (
+	InsuranceLossTheft       = "THEFT"       // Opened by ReportStolen
+	InsuranceLossDestruction = "DESTRUCTION" // Opened by MarkProductDestroyed
+)
+
+// Insurance claim states
+const (
+	InsuranceClaimOpen    = "OPEN"
+	InsuranceClaimSettled = "SETTLED"
+	InsuranceClaimDenied  = "DENIED"
+)
+
+// InsurancePolicy attests that an insurer covers a product. The policy itself, with
+// the insured value and the policyholder, stays off-chain; only its hash is recorded.
+type InsurancePolicy struct {
+	ProductID     string   `json:"productId"`
+	InsurerID     string   `json:"insurerId"` // MSP ID of the insurer
+	PolicyHash    string   `json:"policyHash"`
+	RegisteredAt  string   `json:"registeredAt"`
+	UpdatedAt     string   `json:"updatedAt"` // Last renewal, when the policy hash was replaced
+	ClaimIDs      []string `json:"claimIds"`  // Loss claims opened against the policy, oldest first
+	SchemaVersion int      `json:"schemaVersion"`
+}
+
+// InsuranceClaim is a loss claim against an insurance policy. It is opened with a theft
+// or destruction report and closed by the insurer.
+type InsuranceClaim struct {
+	ClaimID             string `json:"claimId"`
+	ProductID           string `json:"productId"`
+	InsurerID           string `json:"insurerId"`
+	PolicyHash          string `json:"policyHash"` // Policy in force when the loss was reported
+	LossType            string `json:"lossType"`
+	LossReference       string `json:"lossReference"` // Police report ID or destruction evidence hash
+	OpenedBy            string `json:"openedBy"`
+	OpenedAt            string `json:"openedAt"`
+	Status              string `json:"status"`
+	SettlementReference string `json:"settlementReference,omitempty" metadata:",optional"` // Insurer's payment or decision reference
+	ClosedAt            string `json:"closedAt,omitempty" metadata:",optional"`
+	SchemaVersion       int    `json:"schemaVersion"`
+}
+
+func insurancePolicyKey(productID string, insurerID string) string {
+	return insurancePolicyKeyPrefix + productID + "_" + insurerID
+}
+
+// requireInsurer rejects callers without the INSURER role acting for another insurer
+func requireInsurer(ctx contractapi.TransactionContextInterface, insurerID string, permission string) error {
+	caller, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to get caller identity: %v", err)
+	}
+	roleContract := &RoleManagementContract{}
+	hasPermission, err := roleContract.CheckPermission(ctx, caller, permission)
+	if err != nil || !hasPermission || caller != insurerID {
+		return newError(ErrPermissionDenied, "only insurer %s can do this", insurerID)
+	}
+	return nil
+}
+
+// requireInsuranceReader lets the insurer and the brand read insurance records
+func requireInsuranceReader(ctx contractapi.TransactionContextInterface, insurerID string) error {
+	caller, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to get caller identity: %v", err)
+	}
+	if caller == insurerID {
+		return nil
+	}
+	if _, err := requireSuperAdmin(ctx); err != nil {
+		return newError(ErrPermissionDenied, "only the insurer or the brand can read insurance records")
+	}
+	return nil
+}
+
+func getInsurancePolicy(ctx contractapi.TransactionContextInterface, productID string, insurerID string) (*InsurancePolicy, error) {
+	policyJSON, err := ctx.GetStub().GetState(insurancePolicyKey(productID, insurerID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read insurance policy: %v", err)
+	}
+	if policyJSON == nil {
+		return nil, newError(ErrNotFound, "product %s has no policy with insurer %s", productID, insurerID)
+	}
+
+	var policy InsurancePolicy
+	err = json.Unmarshal(policyJSON, &policy)
+	if err != nil {
+		return nil, err
+	}
+	policy.upgradeSchema()
+	return &policy, nil
+}
+
+func putInsurancePolicy(ctx contractapi.TransactionContextInterface, policy *InsurancePolicy) error {
+	policyJSON, err := json.Marshal(policy)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState(insurancePolicyKey(policy.ProductID, policy.InsurerID), policyJSON)
+	if err != nil {
+		return fmt.Errorf("failed to store insurance policy: %v", err)
+	}
+	return nil
+}
+
+func getInsuranceClaim(ctx contractapi.TransactionContextInterface, claimID string) (*InsuranceClaim, error) {
+	claimJSON, err := ctx.GetStub().GetState(insuranceClaimKeyPrefix + claimID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read insurance claim: %v", err)
+	}
+	if claimJSON == nil {
+		return nil, newError(ErrNotFound, "insurance claim %s does not exist", claimID)
+	}
+
+	var claim InsuranceClaim
+	err = json.Unmarshal(claimJSON, &claim)
+	if err != nil {
+		return nil, err
+	}
+	claim.upgradeSchema()
+	return &claim, nil
+}
+
+func putInsuranceClaim(ctx contractapi.TransactionContextInterface, claim *InsuranceClaim) error {
+	claimJSON, err := json.Marshal(claim)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState(insuranceClaimKeyPrefix+claim.ClaimID, claimJSON)
+	if err != nil {
+		return fmt.Errorf("failed to store insurance claim: %v", err)
+	}
+	return nil
+}
+
+// openInsuranceClaim opens a loss claim against the product's policy with insurerID
+// and returns its ID. It fails if the product has no such policy or the policy already
+// has an open claim. The claim ID is derived from the transaction.
+func openInsuranceClaim(ctx contractapi.TransactionContextInterface, productID string, insurerID string,
+	lossType string, lossReference string) (string, error) {
+
+	if err := validateID("insurerID", insurerID); err != nil {
+		return "", err
+	}
+	policy, err := getInsurancePolicy(ctx, productID, insurerID)
+	if err != nil {
+		return "", err
+	}
+	for _, claimID := range policy.ClaimIDs {
+		existing, err := getInsuranceClaim(ctx, claimID)
+		if err != nil {
+			return "", err
+		}
+		if existing.Status == InsuranceClaimOpen {
+			return "", newError(ErrAlreadyExists, "insurance claim %s is still open for product %s", claimID, productID)
+		}
+	}
+
+	caller, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return "", fmt.Errorf("failed to get caller identity: %v", err)
+	}
+	now, err := txTime(ctx)
+	if err != nil {
+		return "", err
+	}
+	txID := ctx.GetStub().GetTxID()
+	if len(txID) > 16 {
+		txID = txID[:16]
+	}
+	claim := &InsuranceClaim{
+		ClaimID:       fmt.Sprintf("IC-%s-%s", productID, txID),
+		ProductID:     productID,
+		InsurerID:     insurerID,
+		PolicyHash:    policy.PolicyHash,
+		LossType:      lossType,
+		LossReference: lossReference,
+		OpenedBy:      caller,
+		OpenedAt:      now.UTC().Format(time.RFC3339),
+		Status:        InsuranceClaimOpen,
+		SchemaVersion: CurrentSchemaVersion,
+	}
+	if err := putInsuranceClaim(ctx, claim); err != nil {
+		return "", err
+	}
+	policy.ClaimIDs = append(policy.ClaimIDs, claim.ClaimID)
+	if err := putInsurancePolicy(ctx, policy); err != nil {
+		return "", err
+	}
+	return claim.ClaimID, nil
+}
+
+// RegisterInsurancePolicy records that insurerID covers a product under the policy with
+// the given hash. Only the insurer itself, an organization with the INSURER role, may
+// register it. Registering again renews the policy: the hash is replaced and its claims
+// are kept.
+func (o *OwnershipContract) RegisterInsurancePolicy(ctx contractapi.TransactionContextInterface,
+	productID string, insurerID string, policyHash string) error {
+
+	if err := validateAll(
+		validateID("productID", productID),
+		validateID("insurerID", insurerID),
+		validateID("policyHash", policyHash),
+	); err != nil {
+		return err
+	}
+	if err := requireInsurer(ctx, insurerID, "REGISTER_INSURANCE_POLICY"); err != nil {
+		return err
+	}
+
+	supplyChain := &SupplyChainContract{}
+	product, err := supplyChain.GetProduct(ctx, productID)
+	if err != nil {
+		return err
+	}
+	switch product.Status {
+	case ProductStatusStolen, ProductStatusDestroyed, ProductStatusWrittenOff:
+		return newError(ErrInvalidState, "product %s is %s and cannot be insured", productID, product.Status)
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	nowStr := now.UTC().Format(time.RFC3339)
+	policy, err := getInsurancePolicy(ctx, productID, insurerID)
+	renewed := err == nil
+	if err != nil && !hasErrorCode(err, ErrNotFound) {
+		return err
+	}
+	if !renewed {
+		policy = &InsurancePolicy{
+			ProductID:     productID,
+			InsurerID:     insurerID,
+			RegisteredAt:  nowStr,
+			ClaimIDs:      []string{},
+			SchemaVersion: CurrentSchemaVersion,
+		}
+	}
+	policy.PolicyHash = policyHash
+	policy.UpdatedAt = nowStr
+	if err := putInsurancePolicy(ctx, policy); err != nil {
+		return err
+	}
+
+	return emitEvent(ctx, ChaincodeEvent{
+		EventType:  EventInsurancePolicyRegistered,
+		EntityType: EventEntityProduct,
+		EntityID:   productID,
+		Attributes: map[string]interface{}{
+			"insurerId":  insurerID,
+			"policyHash": policyHash,
+			"renewed":    renewed,
+		},
+	})
+}
+
+// GetInsurancePolicy returns an insurer's policy on a product, for the insurer and the brand
+func (o *OwnershipContract) GetInsurancePolicy(ctx contractapi.TransactionContextInterface,
+	productID string, insurerID string) (*InsurancePolicy, error) {
+
+	if err := validateAll(
+		validateID("productID", productID),
+		validateID("insurerID", insurerID),
+	); err != nil {
+		return nil, err
+	}
+	if err := requireInsuranceReader(ctx, insurerID); err != nil {
+		return nil, err
+	}
+	return getInsurancePolicy(ctx, productID, insurerID)
+}
+
+// GetInsuranceClaim returns a loss claim, for its insurer and the brand
+func (o *OwnershipContract) GetInsuranceClaim(ctx contractapi.TransactionContextInterface,
+	claimID string) (*InsuranceClaim, error) {
+
+	if err := validateID("claimID", claimID); err != nil {
+		return nil, err
+	}
+	claim, err := getInsuranceClaim(ctx, claimID)
+	if err != nil {
+		return nil, err
+	}
+	if err := requireInsuranceReader(ctx, claim.InsurerID); err != nil {
+		return nil, err
+	}
+	return claim, nil
+}
+
+// CloseInsuranceClaim lets the insurer close an open loss claim as SETTLED or DENIED,
+// with the reference of its payment or decision
+func (o *OwnershipContract) CloseInsuranceClaim(ctx contractapi.TransactionContextInterface,
+	claimID string, outcome string, settlementReference string) error {
+
+	if err := validateAll(
+		validateID("claimID", claimID),
+		validateEnum("outcome", outcome, InsuranceClaimSettled, InsuranceClaimDenied),
+		validateRequired("settlementReference", settlementReference, maxNameLength),
+	); err != nil {
+		return err
+	}
+
+	claim, err := getInsuranceClaim(ctx, claimID)
+	if err != nil {
+		return err
+	}
+	if err := requireInsurer(ctx, claim.InsurerID, "SETTLE_INSURANCE_CLAIM"); err != nil {
+		return err
+	}
+	if claim.Status != InsuranceClaimOpen {
+		return newError(ErrInvalidState, "insurance claim %s is already %s", claimID, claim.Status)
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	claim.Status = outcome
+	claim.SettlementReference = settlementReference
+	claim.ClosedAt = now.UTC().Format(time.RFC3339)
+	if err := putInsuranceClaim(ctx, claim); err != nil {
+		return err
+	}
+
+	return emitEvent(ctx, ChaincodeEvent{
+		EventType:  EventInsuranceClaimClosed,
+		EntityType: EventEntityProduct,
+		EntityID:   claim.ProductID,
+		FromState:  InsuranceClaimOpen,
+		ToState:    claim.Status,
+		Attributes: map[string]interface{}{
+			"claimId":             claimID,
+			"insurerId":           claim.InsurerID,
+			"lossType":            claim.LossType,
+			"settlementReference": settlementReference,
+		},
+	})
+}
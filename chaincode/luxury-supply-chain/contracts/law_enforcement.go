@@ -0,0 +1,265 @@
+package contracts
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// stolenReportRecordType is the service record type ReportStolen files the police
+// report under
+const stolenReportRecordType = "stolen_report"
+
+// PoliceReport is a police report filed with a stolen product
+type PoliceReport struct {
+	ReportID   string `json:"reportId"`
+	ReportedAt string `json:"reportedAt"`
+}
+
+// StolenProductDetail is what law enforcement sees of a product reported stolen. It
+// identifies the item, never its owners: customers only appear as the customer label.
+type StolenProductDetail struct {
+	ProductID       string                 `json:"productId"`
+	Brand           string                 `json:"brand"`
+	Name            string                 `json:"name"`
+	Type            string                 `json:"type"`
+	SerialNumber    string                 `json:"serialNumber"`
+	NFCChipID       string                 `json:"nfcChipId,omitempty" metadata:",optional"`
+	Status          ProductStatus          `json:"status"`
+	IsStolen        bool                   `json:"isStolen"`
+	StolenDate      string                 `json:"stolenDate"`
+	RecoveredDate   string                 `json:"recoveredDate"`
+	PoliceReports   []PoliceReport         `json:"policeReports"`
+	Registry        []*StolenRegistryEntry `json:"registry"`      // Current stolen registry entries, with movement attempts
+	CurrentHolder   string                 `json:"currentHolder"` // MSP ID, or customer once sold
+	CurrentLocation string                 `json:"currentLocation"`
+}
+
+// CustodyRecord is one change of custody of a stolen product. Organizations are named
+// by MSP ID, customers by the customer label.
+type CustodyRecord struct {
+	Date             string `json:"date"` // Completion, or initiation while the transfer is open
+	From             string `json:"from"`
+	To               string `json:"to"`
+	TransferID       string `json:"transferId,omitempty" metadata:",optional"` // B2B transfers only
+	TransferType     string `json:"transferType"`                              // B2B transfer type, PURCHASE or the customer transfer type
+	Status           string `json:"status,omitempty" metadata:",optional"`     // B2B transfer status
+	Carrier          string `json:"carrier,omitempty" metadata:",optional"`
+	LastGeoHash      string `json:"lastGeoHash,omitempty" metadata:",optional"` // Last transit checkpoint of the transfer
+	LastCheckpointAt string `json:"lastCheckpointAt,omitempty" metadata:",optional"`
+}
+
+// StolenProductTrail is the custody chain of a stolen product up to its last known holder
+type StolenProductTrail struct {
+	ProductID       string          `json:"productId"`
+	SerialNumber    string          `json:"serialNumber"`
+	CurrentHolder   string          `json:"currentHolder"`
+	CurrentLocation string          `json:"currentLocation"`
+	Custody         []CustodyRecord `json:"custody"` // Oldest first
+}
+
+// requireLawEnforcement rejects callers without the LAW_ENFORCEMENT role
+func requireLawEnforcement(ctx contractapi.TransactionContextInterface) error {
+	caller, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to get caller identity: %v", err)
+	}
+
+	roleContract := &RoleManagementContract{}
+	hasPermission, err := roleContract.CheckPermission(ctx, caller, "QUERY_STOLEN_GOODS")
+	if err != nil || !hasPermission {
+		return newError(ErrPermissionDenied, "caller %s does not have permission to query stolen goods", caller)
+	}
+	return nil
+}
+
+// reportedStolenProduct returns a product that was reported stolen, with the police
+// reports filed for it. Products never reported stolen are not disclosed.
+func (o *OwnershipContract) reportedStolenProduct(ctx contractapi.TransactionContextInterface,
+	productID string) (*Product, *Ownership, []PoliceReport, error) {
+
+	supplyChain := &SupplyChainContract{}
+	product, err := supplyChain.GetProduct(ctx, productID)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	ownership, err := o.GetOwnership(ctx, productID)
+	if err != nil && !hasErrorCode(err, ErrNotFound) {
+		return nil, nil, nil, err
+	}
+	reports := []PoliceReport{}
+	if ownership != nil {
+		for _, record := range ownership.ServiceHistory {
+			if record.Type == stolenReportRecordType {
+				reports = append(reports, PoliceReport{ReportID: record.ID, ReportedAt: record.Date})
+			}
+		}
+	}
+
+	if len(reports) == 0 && !product.IsStolen && product.Status != ProductStatusStolen {
+		return nil, nil, nil, newError(ErrInvalidState, "product %s was never reported stolen", productID)
+	}
+	return product, ownership, reports, nil
+}
+
+// GetStolenProductDetail returns the identifiers, police reports and current holder of
+// a product reported stolen. Only organizations with the LAW_ENFORCEMENT role may call it.
+func (o *OwnershipContract) GetStolenProductDetail(ctx contractapi.TransactionContextInterface,
+	productID string) (*StolenProductDetail, error) {
+
+	if err := validateID("productID", productID); err != nil {
+		return nil, err
+	}
+	if err := requireLawEnforcement(ctx); err != nil {
+		return nil, err
+	}
+
+	product, _, reports, err := o.reportedStolenProduct(ctx, productID)
+	if err != nil {
+		return nil, err
+	}
+
+	identifiers, err := productIdentifiers(ctx, product)
+	if err != nil {
+		return nil, err
+	}
+	registry, err := stolenRegistryMatches(ctx, product)
+	if err != nil {
+		return nil, err
+	}
+
+	return &StolenProductDetail{
+		ProductID:       product.ID,
+		Brand:           product.Brand,
+		Name:            product.Name,
+		Type:            product.Type,
+		SerialNumber:    product.SerialNumber,
+		NFCChipID:       identifiers[StolenIdentifierChip],
+		Status:          product.Status,
+		IsStolen:        product.IsStolen,
+		StolenDate:      product.StolenDate,
+		RecoveredDate:   product.RecoveredDate,
+		PoliceReports:   reports,
+		Registry:        registry,
+		CurrentHolder:   product.CurrentOwner,
+		CurrentLocation: product.CurrentLocation,
+	}, nil
+}
+
+// GetStolenProductTrail returns the custody chain of a product reported stolen: its
+// transfers between organizations, with the last transit checkpoint of each, and its
+// sales between customers without their owner hashes. Only organizations with the
+// LAW_ENFORCEMENT role may call it.
+func (o *OwnershipContract) GetStolenProductTrail(ctx contractapi.TransactionContextInterface,
+	productID string) (*StolenProductTrail, error) {
+
+	if err := validateID("productID", productID); err != nil {
+		return nil, err
+	}
+	if err := requireLawEnforcement(ctx); err != nil {
+		return nil, err
+	}
+
+	product, ownership, _, err := o.reportedStolenProduct(ctx, productID)
+	if err != nil {
+		return nil, err
+	}
+
+	supplyChain := &SupplyChainContract{}
+	transfers, err := supplyChain.GetTransfersByProduct(ctx, productID)
+	if err != nil {
+		return nil, err
+	}
+
+	custody := []CustodyRecord{}
+	for _, transfer := range transfers {
+		record := CustodyRecord{
+			Date:         transfer.InitiatedAt,
+			From:         transfer.From,
+			To:           transfer.To,
+			TransferID:   transfer.ID,
+			TransferType: string(transfer.TransferType),
+			Status:       string(transfer.Status),
+			Carrier:      transfer.ConsensusDetails.Carrier,
+		}
+		if transfer.Status == TransferStatusCompleted {
+			record.Date = transfer.CompletedAt
+		}
+		checkpoint, err := lastTransitCheckpoint(ctx, transfer.ID)
+		if err != nil {
+			return nil, err
+		}
+		if checkpoint != nil {
+			record.LastGeoHash = checkpoint.GeoHash
+			record.LastCheckpointAt = checkpoint.RecordedAt
+		}
+		custody = append(custody, record)
+	}
+
+	// Customer sales: each previous owner bought the product and passed it on
+	if ownership != nil {
+		acquiredAt, acquiredBy := ownership.OwnershipDate, "PURCHASE"
+		if len(ownership.PreviousOwners) > 0 {
+			acquiredAt = ownership.PreviousOwners[0].OwnershipDate
+		}
+		custody = append(custody, CustodyRecord{Date: acquiredAt, To: customerOwner, TransferType: acquiredBy})
+		for _, previous := range ownership.PreviousOwners {
+			custody = append(custody, CustodyRecord{
+				Date:         previous.TransferDate,
+				From:         customerOwner,
+				To:           customerOwner,
+				TransferType: previous.TransferType,
+			})
+		}
+	}
+
+	sort.SliceStable(custody, func(i, j int) bool {
+		return custody[i].Date < custody[j].Date
+	})
+	// The first sale is by whoever last received the product
+	for i := range custody {
+		if custody[i].From == "" && i > 0 {
+			custody[i].From = custody[i-1].To
+		}
+	}
+
+	return &StolenProductTrail{
+		ProductID:       product.ID,
+		SerialNumber:    product.SerialNumber,
+		CurrentHolder:   product.CurrentOwner,
+		CurrentLocation: product.CurrentLocation,
+		Custody:         custody,
+	}, nil
+}
+
+// lastTransitCheckpoint returns the checkpoint last recorded for a transfer, or nil
+func lastTransitCheckpoint(ctx contractapi.TransactionContextInterface, transferID string) (*TransitCheckpoint, error) {
+	prefix := transitCheckpointKeyPrefix + transferID + "_"
+	resultsIterator, err := ctx.GetStub().GetStateByRange(prefix, prefix+"~")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query checkpoints: %v", err)
+	}
+	defer resultsIterator.Close()
+
+	var last *TransitCheckpoint
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var checkpoint TransitCheckpoint
+		err = json.Unmarshal(queryResponse.Value, &checkpoint)
+		if err != nil {
+			return nil, err
+		}
+		last = &checkpoint
+	}
+	if last != nil {
+		last.upgradeSchema()
+	}
+	return last, nil
+}
@@ -0,0 +1,113 @@
+package contracts
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// LogLevel orders log severities
+type LogLevel int
+
+const (
+	LogLevelDebug LogLevel = iota
+	LogLevelInfo
+	LogLevelWarn
+	LogLevelError
+)
+
+var logLevelNames = map[LogLevel]string{
+	LogLevelDebug: "DEBUG",
+	LogLevelInfo:  "INFO",
+	LogLevelWarn:  "WARN",
+	LogLevelError: "ERROR",
+}
+
+// minLogLevel is read once at startup from CHAINCODE_LOG_LEVEL, falling back to
+// CORE_CHAINCODE_LOGGING_LEVEL, and defaults to INFO
+var minLogLevel = parseLogLevel(os.Getenv("CHAINCODE_LOG_LEVEL"), os.Getenv("CORE_CHAINCODE_LOGGING_LEVEL"))
+
+var (
+	logMu     sync.Mutex
+	logOutput io.Writer = os.Stderr
+)
+
+// parseLogLevel returns the level named by the first non-empty value
+func parseLogLevel(values ...string) LogLevel {
+	for _, value := range values {
+		switch strings.ToUpper(strings.TrimSpace(value)) {
+		case "":
+			continue
+		case "DEBUG":
+			return LogLevelDebug
+		case "INFO":
+			return LogLevelInfo
+		case "WARN", "WARNING":
+			return LogLevelWarn
+		case "ERROR":
+			return LogLevelError
+		}
+	}
+	return LogLevelInfo
+}
+
+// txLogger writes logfmt lines tagged with the transaction ID and invoked function,
+// e.g. ts=... level=WARN txId=3f2a... function=SupplyChainContract:ConfirmSent msg="..." transferId=T1
+type txLogger struct {
+	txID     string
+	function string
+}
+
+// logFor returns the logger for the current transaction
+func logFor(ctx contractapi.TransactionContextInterface) *txLogger {
+	stub := ctx.GetStub()
+	function, _ := stub.GetFunctionAndParameters()
+	return &txLogger{txID: stub.GetTxID(), function: function}
+}
+
+// Debug, Info, Warn and Error log msg with alternating key/value pairs
+func (l *txLogger) Debug(msg string, keyvals ...interface{}) { l.log(LogLevelDebug, msg, keyvals) }
+func (l *txLogger) Info(msg string, keyvals ...interface{})  { l.log(LogLevelInfo, msg, keyvals) }
+func (l *txLogger) Warn(msg string, keyvals ...interface{})  { l.log(LogLevelWarn, msg, keyvals) }
+func (l *txLogger) Error(msg string, keyvals ...interface{}) { l.log(LogLevelError, msg, keyvals) }
+
+func (l *txLogger) log(level LogLevel, msg string, keyvals []interface{}) {
+	if level < minLogLevel {
+		return
+	}
+
+	var line strings.Builder
+	line.WriteString("ts=" + time.Now().UTC().Format(time.RFC3339Nano))
+	line.WriteString(" level=" + logLevelNames[level])
+	writeLogField(&line, "txId", l.txID)
+	writeLogField(&line, "function", l.function)
+	writeLogField(&line, "msg", msg)
+	for i := 0; i < len(keyvals); i += 2 {
+		var value interface{} = "(missing)"
+		if i+1 < len(keyvals) {
+			value = keyvals[i+1]
+		}
+		writeLogField(&line, fmt.Sprint(keyvals[i]), fmt.Sprint(value))
+	}
+	line.WriteByte('\n')
+
+	logMu.Lock()
+	defer logMu.Unlock()
+	io.WriteString(logOutput, line.String())
+}
+
+// writeLogField appends key=value, quoting values that would break the line apart
+func writeLogField(line *strings.Builder, key string, value string) {
+	line.WriteString(" " + key + "=")
+	if value == "" || strings.ContainsAny(value, " \"=\t\n\r") {
+		line.WriteString(strconv.Quote(value))
+		return
+	}
+	line.WriteString(value)
+}
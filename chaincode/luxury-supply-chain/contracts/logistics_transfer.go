@@ -0,0 +1,144 @@
+package contracts
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// checkCarrier checks that carrierID is an active organization with the CARRIER role
+func checkCarrier(ctx contractapi.TransactionContextInterface, carrierID string) error {
+	roleContract := &RoleManagementContract{}
+	carrier, err := roleContract.GetOrganizationInfo(ctx, carrierID)
+	if err != nil || carrier.Role != RoleCarrier || !carrier.IsActive {
+		return newError(ErrInvalidArgument, "%s is not a registered carrier", carrierID)
+	}
+	return nil
+}
+
+// HandoffToCarrier records that the sender of a LOGISTICS transfer handed the goods to
+// carrierID. It takes the place of ConfirmSent: the carrier then confirms delivery with
+// CarrierDeliver, and only then can the receiver confirm receipt. Ownership stays with
+// the sender until the receipt.
+func (s *SupplyChainContract) HandoffToCarrier(ctx contractapi.TransactionContextInterface,
+	transferID string, carrierID string) error {
+
+	if err := validateAll(
+		validateID("transferID", transferID),
+		validateID("carrierID", carrierID),
+	); err != nil {
+		return err
+	}
+
+	sender, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to get sender identity: %v", err)
+	}
+	transfer, err := s.GetTransfer(ctx, transferID)
+	if err != nil {
+		return err
+	}
+	if transfer.From != sender {
+		return newError(ErrPermissionDenied, "only the sender can hand off transfer %s", transferID)
+	}
+	if transfer.TransferType != TransferTypeLogistics {
+		return newError(ErrInvalidArgument, "transfer %s is not a LOGISTICS transfer, use ConfirmSent", transferID)
+	}
+	if carrierID == transfer.From || carrierID == transfer.To {
+		return newError(ErrInvalidArgument, "the carrier of transfer %s must not be its sender or receiver", transferID)
+	}
+	if err := checkCarrier(ctx, carrierID); err != nil {
+		return err
+	}
+	if err := checkSenderEvidence(transfer); err != nil {
+		return err
+	}
+
+	previousStatus := transfer.Status
+	if err := setTransferStatus(transfer, TransferStatusPending); err != nil {
+		return err
+	}
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	transfer.ConsensusDetails.SenderConfirmed = true
+	transfer.ConsensusDetails.SenderTimestamp = now.UTC().Format(time.RFC3339)
+	transfer.ConsensusDetails.Carrier = carrierID
+
+	err = putTransfer(ctx, transfer)
+	if err != nil {
+		return err
+	}
+
+	// Transfers initiated without consensus have no consensus transaction to update
+	consensus, err := LoadConsensusIntegration(ctx)
+	if err != nil {
+		return err
+	}
+	err = consensus.NotifyConsensusOfHandoff(ctx, transferID, sender, carrierID)
+	if err != nil && !hasErrorCode(err, ErrNotFound) {
+		return err
+	}
+
+	event := transferEvent(EventTransferHandedOff, transfer, previousStatus)
+	event.Attributes["carrier"] = carrierID
+	return emitEvent(ctx, event)
+}
+
+// CarrierDeliver lets the carrier a LOGISTICS transfer was handed off to confirm it
+// delivered the goods to the receiver
+func (s *SupplyChainContract) CarrierDeliver(ctx contractapi.TransactionContextInterface,
+	transferID string) error {
+
+	if err := validateID("transferID", transferID); err != nil {
+		return err
+	}
+
+	carrier, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to get carrier identity: %v", err)
+	}
+	transfer, err := s.GetTransfer(ctx, transferID)
+	if err != nil {
+		return err
+	}
+	if transfer.ConsensusDetails.Carrier == "" {
+		return newError(ErrInvalidState, "transfer %s was not handed off to a carrier", transferID)
+	}
+	if transfer.ConsensusDetails.Carrier != carrier {
+		return newError(ErrPermissionDenied, "only the carrier can confirm delivery of transfer %s", transferID)
+	}
+	if transfer.Status != TransferStatusPending {
+		return newError(ErrInvalidState, "transfer %s is %s, not in transit", transferID, transfer.Status)
+	}
+	if transfer.ConsensusDetails.CarrierConfirmed {
+		return newError(ErrInvalidState, "transfer %s was already delivered", transferID)
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	transfer.ConsensusDetails.CarrierConfirmed = true
+	transfer.ConsensusDetails.CarrierTimestamp = now.UTC().Format(time.RFC3339)
+
+	err = putTransfer(ctx, transfer)
+	if err != nil {
+		return err
+	}
+
+	consensus, err := LoadConsensusIntegration(ctx)
+	if err != nil {
+		return err
+	}
+	err = consensus.NotifyConsensusOfDelivery(ctx, transferID, carrier)
+	if err != nil && !hasErrorCode(err, ErrNotFound) {
+		return err
+	}
+
+	event := transferEvent(EventTransferCarrierDelivered, transfer, "")
+	event.Attributes["carrier"] = carrier
+	return emitEvent(ctx, event)
+}
@@ -0,0 +1,203 @@
+package contracts
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Origin certification schemes for regulated materials
+const (
+	CertificationCITES     = "CITES"     // CITES export permit for protected species, e.g. crocodile or python skins
+	CertificationKimberley = "KIMBERLEY" // Kimberley Process certificate for rough diamonds
+)
+
+// regulatedMaterialsKey holds the RegulatedMaterials
+const regulatedMaterialsKey = "config_regulated_materials"
+
+// RegulatedMaterials maps material types to the certification scheme their inventories need
+type RegulatedMaterials struct {
+	Types         map[string]string `json:"types"` // Lower-case material type to scheme
+	UpdatedBy     string            `json:"updatedBy,omitempty" metadata:",optional"`
+	UpdatedAt     string            `json:"updatedAt,omitempty" metadata:",optional"`
+	SchemaVersion int               `json:"schemaVersion"`
+}
+
+// OriginCertification is the permit or certificate a regulated material was sourced under
+type OriginCertification struct {
+	Scheme            string `json:"scheme"`
+	CertificateNumber string `json:"certificateNumber"`
+	IssuingAuthority  string `json:"issuingAuthority"`                            // e.g. the exporting country's CITES management authority
+	CountryOfOrigin   string `json:"countryOfOrigin"`                             // ISO 3166 alpha-2 code
+	Species           string `json:"species,omitempty" metadata:",optional"`      // Scientific name, for CITES permits
+	IssuedAt          string `json:"issuedAt"`                                    // RFC3339
+	ExpiresAt         string `json:"expiresAt"`                                   // RFC3339, batches cannot use the material afterwards
+	DocumentHash      string `json:"documentHash,omitempty" metadata:",optional"` // SHA256 of the scanned certificate
+}
+
+// defaultRegulatedMaterials applies until a super admin sets the list
+func defaultRegulatedMaterials() *RegulatedMaterials {
+	return &RegulatedMaterials{
+		Types: map[string]string{
+			"exotic leather":    CertificationCITES,
+			"crocodile leather": CertificationCITES,
+			"alligator leather": CertificationCITES,
+			"python leather":    CertificationCITES,
+			"diamond":           CertificationKimberley,
+		},
+		SchemaVersion: CurrentSchemaVersion,
+	}
+}
+
+// getRegulatedMaterials reads the stored RegulatedMaterials, falling back to the defaults
+func getRegulatedMaterials(ctx contractapi.TransactionContextInterface) (*RegulatedMaterials, error) {
+	regulatedJSON, err := ctx.GetStub().GetState(regulatedMaterialsKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read regulated materials: %v", err)
+	}
+	if regulatedJSON == nil {
+		return defaultRegulatedMaterials(), nil
+	}
+
+	var regulated RegulatedMaterials
+	err = json.Unmarshal(regulatedJSON, &regulated)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse regulated materials: %v", err)
+	}
+	regulated.upgradeSchema()
+
+	return &regulated, nil
+}
+
+// requiredScheme returns the certification scheme a material type needs, "" if it is not regulated
+func (r *RegulatedMaterials) requiredScheme(materialType string) string {
+	return r.Types[strings.ToLower(strings.TrimSpace(materialType))]
+}
+
+// SetRegulatedMaterials replaces the regulated material types, e.g.
+// {"python leather":"CITES","diamond":"KIMBERLEY"}. Material types match case-insensitively.
+func (a *AdminContract) SetRegulatedMaterials(ctx contractapi.TransactionContextInterface,
+	typesJSON string) error {
+
+	var types map[string]string
+	if err := validateJSON("typesJSON", typesJSON, &types); err != nil {
+		return err
+	}
+	normalized := make(map[string]string, len(types))
+	for materialType, scheme := range types {
+		if err := validateAll(
+			validateName("material type", materialType),
+			validateEnum("scheme", scheme, CertificationCITES, CertificationKimberley),
+		); err != nil {
+			return err
+		}
+		normalized[strings.ToLower(strings.TrimSpace(materialType))] = scheme
+	}
+
+	caller, err := requireSuperAdmin(ctx)
+	if err != nil {
+		return err
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	regulated := &RegulatedMaterials{
+		Types:         normalized,
+		UpdatedBy:     caller,
+		UpdatedAt:     now.UTC().Format(time.RFC3339),
+		SchemaVersion: CurrentSchemaVersion,
+	}
+	regulatedJSON, err := json.Marshal(regulated)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState(regulatedMaterialsKey, regulatedJSON)
+	if err != nil {
+		return fmt.Errorf("failed to store regulated materials: %v", err)
+	}
+
+	materialTypes := make([]string, 0, len(normalized))
+	for materialType := range normalized {
+		materialTypes = append(materialTypes, materialType)
+	}
+	sort.Strings(materialTypes)
+
+	return emitEvent(ctx, ChaincodeEvent{
+		EventType:  EventRegulatedMaterialsUpdated,
+		EntityType: EventEntityConfig,
+		EntityID:   regulatedMaterialsKey,
+		Attributes: map[string]interface{}{
+			"materialTypes": strings.Join(materialTypes, ","),
+		},
+	})
+}
+
+// GetRegulatedMaterials returns the regulated material types in effect
+func (a *AdminContract) GetRegulatedMaterials(ctx contractapi.TransactionContextInterface) (*RegulatedMaterials, error) {
+	return getRegulatedMaterials(ctx)
+}
+
+// parseOriginCertification validates the certification of a new inventory of a material
+// that needs scheme. It must be valid at now.
+func parseOriginCertification(certificationJSON string, scheme string, now time.Time) (*OriginCertification, error) {
+	var certification OriginCertification
+	if err := validateJSON("certificationJSON", certificationJSON, &certification); err != nil {
+		return nil, err
+	}
+	if err := validateAll(
+		validateEnum("certification scheme", certification.Scheme, scheme),
+		validateName("certificateNumber", certification.CertificateNumber),
+		validateName("issuingAuthority", certification.IssuingAuthority),
+		validateRequired("countryOfOrigin", certification.CountryOfOrigin, 2),
+		validateText("species", certification.Species, maxNameLength),
+		validateText("documentHash", certification.DocumentHash, maxNameLength),
+	); err != nil {
+		return nil, err
+	}
+	if scheme == CertificationCITES && certification.Species == "" {
+		return nil, newError(ErrInvalidArgument, "CITES permits must name the species")
+	}
+
+	issued, err := time.Parse(time.RFC3339, certification.IssuedAt)
+	if err != nil {
+		return nil, newError(ErrInvalidArgument, "issuedAt must be an RFC3339 time")
+	}
+	expires, err := time.Parse(time.RFC3339, certification.ExpiresAt)
+	if err != nil {
+		return nil, newError(ErrInvalidArgument, "expiresAt must be an RFC3339 time")
+	}
+	if !expires.After(issued) {
+		return nil, newError(ErrInvalidArgument, "certificate %s expires before it was issued", certification.CertificateNumber)
+	}
+	if !expires.After(now) {
+		return nil, newError(ErrInvalidState, "certificate %s expired at %s", certification.CertificateNumber, certification.ExpiresAt)
+	}
+
+	return &certification, nil
+}
+
+// checkMaterialCertification rejects using a regulated material whose certificate is
+// missing, of the wrong scheme or expired at now
+func checkMaterialCertification(inventory *MaterialInventory, regulated *RegulatedMaterials, now time.Time) error {
+	scheme := regulated.requiredScheme(inventory.Type)
+	if scheme == "" {
+		return nil
+	}
+	certification := inventory.Certification
+	if certification == nil || certification.Scheme != scheme {
+		return newError(ErrInvalidState, "material %s is %s and needs a %s certificate", inventory.MaterialID, inventory.Type, scheme)
+	}
+	expires, err := time.Parse(time.RFC3339, certification.ExpiresAt)
+	if err != nil || !expires.After(now) {
+		return newError(ErrInvalidState, "%s certificate %s of material %s expired at %s",
+			scheme, certification.CertificateNumber, inventory.MaterialID, certification.ExpiresAt)
+	}
+	return nil
+}
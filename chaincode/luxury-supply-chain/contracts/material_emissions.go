@@ -0,0 +1,220 @@
+package contracts
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Sustainability certification schemes a material can hold next to its origin certificate
+const (
+	SustainabilitySchemeLWG  = "LWG"  // Leather Working Group audited tannery
+	SustainabilitySchemeRJC  = "RJC"  // Responsible Jewellery Council, e.g. chain of custody for gold
+	SustainabilitySchemeGOTS = "GOTS" // Global Organic Textile Standard
+	SustainabilitySchemeRWS  = "RWS"  // Responsible Wool Standard
+)
+
+var sustainabilitySchemes = []string{SustainabilitySchemeLWG, SustainabilitySchemeRJC, SustainabilitySchemeGOTS, SustainabilitySchemeRWS}
+
+const (
+	maxSustainabilityCertifications = 10
+	footprintDecimals               = 1000 // Footprints are rounded to grams of CO2e
+)
+
+// MaterialEmissions is the emission data a supplier recorded for a material
+type MaterialEmissions struct {
+	EmissionFactorKgCO2e float64                       `json:"emissionFactorKgCO2e"`                       // Per unit of quantity, cradle to the supplier's gate
+	Unit                 string                        `json:"unit,omitempty" metadata:",optional"`        // Unit of quantity the factor applies to, e.g. m2 or g
+	Methodology          string                        `json:"methodology,omitempty" metadata:",optional"` // e.g. ISO 14067 or the GHG Protocol product standard
+	Certifications       []SustainabilityCertification `json:"certifications,omitempty" metadata:",optional"`
+	RecordedBy           string                        `json:"recordedBy"`
+	RecordedAt           string                        `json:"recordedAt"`
+}
+
+// SustainabilityCertification is a certificate a material's supplier holds, such as
+// an LWG tannery rating or an RJC chain-of-custody certificate
+type SustainabilityCertification struct {
+	Scheme            string `json:"scheme"`
+	CertificateNumber string `json:"certificateNumber"`
+	Rating            string `json:"rating,omitempty" metadata:",optional"` // e.g. GOLD for an LWG audit
+	ExpiresAt         string `json:"expiresAt"`                             // RFC3339, products made afterwards do not carry it
+}
+
+// ProductFootprint is the carbon footprint of a product's materials, computed when the
+// product is created
+type ProductFootprint struct {
+	MaterialsKgCO2e float64  `json:"materialsKgCO2e"`                               // Sum of each material's quantity times its emission factor
+	MissingFactors  []string `json:"missingFactors,omitempty" metadata:",optional"` // Materials without an emission factor, left out of the sum
+	Certifications  []string `json:"certifications,omitempty" metadata:",optional"` // Valid material certifications, e.g. "LWG GOLD LWG-1234"
+}
+
+// label names a certification on products and certificates
+func (c SustainabilityCertification) label() string {
+	if c.Rating == "" {
+		return c.Scheme + " " + c.CertificateNumber
+	}
+	return c.Scheme + " " + c.Rating + " " + c.CertificateNumber
+}
+
+// parseMaterialEmissions validates the emission data of a material given as JSON.
+// Certifications must be valid at now.
+func parseMaterialEmissions(emissionsJSON string, now time.Time) (*MaterialEmissions, error) {
+	var emissions MaterialEmissions
+	if err := validateJSON("emissionsJSON", emissionsJSON, &emissions); err != nil {
+		return nil, err
+	}
+	if err := validateAll(
+		validateText("unit", emissions.Unit, maxIDLength),
+		validateText("methodology", emissions.Methodology, maxNameLength),
+	); err != nil {
+		return nil, err
+	}
+	if emissions.EmissionFactorKgCO2e <= 0 {
+		return nil, newError(ErrInvalidArgument, "emissionFactorKgCO2e must be positive")
+	}
+	if len(emissions.Certifications) > maxSustainabilityCertifications {
+		return nil, newError(ErrInvalidArgument, "at most %d certifications can be recorded", maxSustainabilityCertifications)
+	}
+
+	for _, certification := range emissions.Certifications {
+		if err := validateAll(
+			validateEnum("certification scheme", certification.Scheme, sustainabilitySchemes...),
+			validateName("certificateNumber", certification.CertificateNumber),
+			validateText("rating", certification.Rating, maxIDLength),
+		); err != nil {
+			return nil, err
+		}
+		expires, err := time.Parse(time.RFC3339, certification.ExpiresAt)
+		if err != nil {
+			return nil, newError(ErrInvalidArgument, "expiresAt must be an RFC3339 time")
+		}
+		if !expires.After(now) {
+			return nil, newError(ErrInvalidState, "certificate %s expired at %s", certification.CertificateNumber, certification.ExpiresAt)
+		}
+	}
+	return &emissions, nil
+}
+
+// RecordEmissionsData records the emission factor and sustainability certifications of
+// a material the caller supplied, e.g. {"emissionFactorKgCO2e":17.2,"unit":"m2",
+// "methodology":"ISO 14067","certifications":[{"scheme":"LWG","certificateNumber":
+// "LWG-1234","rating":"GOLD","expiresAt":"2027-01-01T00:00:00Z"}]}. Calling it again
+// replaces the data; products already made keep the footprint they were created with.
+func (s *SupplyChainContract) RecordEmissionsData(ctx contractapi.TransactionContextInterface,
+	materialID string, emissionsJSON string) error {
+
+	if err := validateID("materialID", materialID); err != nil {
+		return err
+	}
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	emissions, err := parseMaterialEmissions(emissionsJSON, now)
+	if err != nil {
+		return err
+	}
+
+	supplier, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to get supplier identity: %v", err)
+	}
+
+	// CHECK PERMISSION - Only the supplier of the material can record its emissions
+	roleContract := &RoleManagementContract{}
+	hasPermission, err := roleContract.CheckPermission(ctx, supplier, "CREATE_MATERIAL")
+	if err != nil || !hasPermission {
+		return newError(ErrPermissionDenied, "caller %s does not have permission to record emissions data", supplier)
+	}
+	inventory, err := s.GetMaterialInventory(ctx, materialID, supplier)
+	if err != nil {
+		return err
+	}
+	if inventory.Supplier != supplier {
+		return newError(ErrPermissionDenied, "material %s was supplied by %s, not %s", materialID, inventory.Supplier, supplier)
+	}
+
+	emissions.RecordedBy = supplier
+	emissions.RecordedAt = now.UTC().Format(time.RFC3339)
+	inventory.Emissions = emissions
+	err = putMaterialInventory(ctx, fmt.Sprintf("material_inventory_%s_%s", materialID, supplier), inventory)
+	if err != nil {
+		return err
+	}
+
+	schemes := []string{}
+	for _, certification := range emissions.Certifications {
+		schemes = append(schemes, certification.Scheme)
+	}
+	return emitEvent(ctx, ChaincodeEvent{
+		EventType:  EventMaterialEmissionsRecorded,
+		EntityType: EventEntityMaterial,
+		EntityID:   materialID,
+		Attributes: map[string]interface{}{
+			"materialType":         inventory.Type,
+			"emissionFactorKgCO2e": emissions.EmissionFactorKgCO2e,
+			"certificationSchemes": schemes,
+		},
+	})
+}
+
+// materialEmissions returns the emission data of an inventory's material, which lives
+// on the supplier's own inventory, or nil if the supplier recorded none
+func (s *SupplyChainContract) materialEmissions(ctx contractapi.TransactionContextInterface,
+	inventory *MaterialInventory) (*MaterialEmissions, error) {
+
+	if inventory.Owner == inventory.Supplier {
+		return inventory.Emissions, nil
+	}
+	supplierInventory, err := s.GetMaterialInventory(ctx, inventory.MaterialID, inventory.Supplier)
+	if err != nil {
+		if hasErrorCode(err, ErrNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return supplierInventory.Emissions, nil
+}
+
+// applyMaterialEmissions copies a material's emission factor and the certifications
+// still valid at now onto its usage in a batch
+func applyMaterialEmissions(usage *MaterialUsage, emissions *MaterialEmissions, now time.Time) {
+	if emissions == nil {
+		return
+	}
+	usage.EmissionFactorKgCO2e = emissions.EmissionFactorKgCO2e
+	for _, certification := range emissions.Certifications {
+		expires, err := time.Parse(time.RFC3339, certification.ExpiresAt)
+		if err == nil && expires.After(now) {
+			usage.Certifications = append(usage.Certifications, certification.label())
+		}
+	}
+}
+
+// productFootprint sums the footprint of a product's materials, each of which already
+// carries its own
+func productFootprint(materials []Material) *ProductFootprint {
+	footprint := &ProductFootprint{}
+	seen := make(map[string]bool)
+	for _, material := range materials {
+		if material.EmissionFactorKgCO2e == 0 {
+			footprint.MissingFactors = append(footprint.MissingFactors, material.ID)
+		}
+		footprint.MaterialsKgCO2e += material.FootprintKgCO2e
+		for _, certification := range material.Certifications {
+			if !seen[certification] {
+				seen[certification] = true
+				footprint.Certifications = append(footprint.Certifications, certification)
+			}
+		}
+	}
+	footprint.MaterialsKgCO2e = roundFootprint(footprint.MaterialsKgCO2e)
+	return footprint
+}
+
+// roundFootprint rounds a footprint in kg CO2e to grams
+func roundFootprint(kgCO2e float64) float64 {
+	return math.Round(kgCO2e*footprintDecimals) / footprintDecimals
+}
@@ -0,0 +1,112 @@
+package contracts
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// ExpiredMaterialUse is one expired lot a batch was made from under a waiver
+type ExpiredMaterialUse struct {
+	MaterialID string  `json:"materialId"`
+	Batch      string  `json:"batch"`
+	ExpiryDate string  `json:"expiryDate"`
+	Quantity   float64 `json:"quantity"`
+}
+
+// ExpiryWaiver records who accepted the use of expired material lots in a batch. It is
+// signed by the submitter of the batch transaction, identified by SignerID and TxID.
+type ExpiryWaiver struct {
+	Materials []ExpiredMaterialUse `json:"materials"`
+	WaivedBy  string               `json:"waivedBy"` // MSP ID
+	SignerID  string               `json:"signerId"` // X.509 identity of the submitting client
+	TxID      string               `json:"txId"`
+	WaivedAt  string               `json:"waivedAt"`
+}
+
+// parseExpiryDate reads the optional shelf life end of a new material lot, which must
+// be later than now
+func parseExpiryDate(expiryDate string, now time.Time) (string, error) {
+	if expiryDate == "" {
+		return "", nil
+	}
+	expires, err := time.Parse(time.RFC3339, expiryDate)
+	if err != nil {
+		return "", newError(ErrInvalidArgument, "expiryDate must be an RFC3339 time")
+	}
+	if !expires.After(now) {
+		return "", newError(ErrInvalidArgument, "expiryDate %s has already passed", expiryDate)
+	}
+	return expires.UTC().Format(time.RFC3339), nil
+}
+
+// materialExpired reports whether a material lot's shelf life ended at now. Lots
+// without an expiry date do not expire.
+func materialExpired(inventory *MaterialInventory, now time.Time) bool {
+	if inventory.ExpiryDate == "" {
+		return false
+	}
+	expires, err := time.Parse(time.RFC3339, inventory.ExpiryDate)
+	return err != nil || !expires.After(now)
+}
+
+// newExpiryWaiver signs the use of expired lots with the caller's identity
+func newExpiryWaiver(ctx contractapi.TransactionContextInterface, materials []ExpiredMaterialUse,
+	now time.Time) (*ExpiryWaiver, error) {
+
+	mspID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get caller identity: %v", err)
+	}
+	signerID, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get caller identity: %v", err)
+	}
+	return &ExpiryWaiver{
+		Materials: materials,
+		WaivedBy:  mspID,
+		SignerID:  signerID,
+		TxID:      ctx.GetStub().GetTxID(),
+		WaivedAt:  now.UTC().Format(time.RFC3339),
+	}, nil
+}
+
+// batchExpiryWaiver returns the waiver stored in a batch's metadata, or nil
+func batchExpiryWaiver(batch *ProductBatch) (*ExpiryWaiver, error) {
+	waiverJSON := batch.Metadata["expiryWaiver"]
+	if waiverJSON == "" {
+		return nil, nil
+	}
+	var waiver ExpiryWaiver
+	if err := json.Unmarshal([]byte(waiverJSON), &waiver); err != nil {
+		return nil, fmt.Errorf("failed to read expiry waiver of batch %s: %v", batch.ID, err)
+	}
+	return &waiver, nil
+}
+
+// CheckExpiredMaterials lists an organization's material lots whose shelf life has
+// ended while stock is still available or reserved, read from the owner index.
+// Batches cannot use these lots unless the manufacturer signs an expiry waiver, see
+// CreateBatch.
+func (s *SupplyChainContract) CheckExpiredMaterials(ctx contractapi.TransactionContextInterface,
+	owner string) ([]*MaterialInventory, error) {
+
+	inventories, err := s.GetMaterialInventoriesByOwner(ctx, owner)
+	if err != nil {
+		return nil, err
+	}
+	now, err := txTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	expired := []*MaterialInventory{}
+	for _, inventory := range inventories {
+		if inventory.Available+inventory.Reserved > 0 && materialExpired(inventory, now) {
+			expired = append(expired, inventory)
+		}
+	}
+	return expired, nil
+}
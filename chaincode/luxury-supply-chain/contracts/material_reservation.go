@@ -0,0 +1,291 @@
+package contracts
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Reservations are stored as material_reservation_<reservationID>
+const reservationKeyPrefix = "material_reservation_"
+
+// Reservation statuses
+const (
+	ReservationActive   = "ACTIVE"
+	ReservationReleased = "RELEASED"
+	ReservationConsumed = "CONSUMED"
+)
+
+// MaterialReservation sets aside a quantity of an organization's material for planned
+// production. The quantity moves from the inventory's Available to its Reserved stock
+// until the reservation is released or consumed.
+type MaterialReservation struct {
+	ReservationID string  `json:"reservationId"`
+	MaterialID    string  `json:"materialId"`
+	Owner         string  `json:"owner"`
+	Quantity      float64 `json:"quantity"`
+	Purpose       string  `json:"purpose,omitempty" metadata:",optional"` // e.g. the planned batch
+	Status        string  `json:"status"`
+	ReservedAt    string  `json:"reservedAt"`
+	ClosedAt      string  `json:"closedAt,omitempty" metadata:",optional"`
+	QuantityUsed  float64 `json:"quantityUsed,omitempty" metadata:",optional"` // Consumed quantity, the rest was released
+	ConsumedBy    string  `json:"consumedBy,omitempty" metadata:",optional"`   // Batch ID when consumed by CreateBatch
+	SchemaVersion int     `json:"schemaVersion"`
+}
+
+// getReservation reads a material reservation
+func getReservation(ctx contractapi.TransactionContextInterface, reservationID string) (*MaterialReservation, error) {
+	reservationJSON, err := ctx.GetStub().GetState(reservationKeyPrefix + reservationID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read reservation: %v", err)
+	}
+	if reservationJSON == nil {
+		return nil, newError(ErrNotFound, "reservation %s does not exist", reservationID)
+	}
+
+	var reservation MaterialReservation
+	err = json.Unmarshal(reservationJSON, &reservation)
+	if err != nil {
+		return nil, err
+	}
+	reservation.upgradeSchema()
+
+	return &reservation, nil
+}
+
+// putReservation stores a material reservation
+func putReservation(ctx contractapi.TransactionContextInterface, reservation *MaterialReservation) error {
+	reservationJSON, err := json.Marshal(reservation)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState(reservationKeyPrefix+reservation.ReservationID, reservationJSON)
+	if err != nil {
+		return fmt.Errorf("failed to store reservation: %v", err)
+	}
+	return nil
+}
+
+// reservationEvent builds the event for a reservation state change
+func reservationEvent(eventType string, reservation *MaterialReservation, fromState string) ChaincodeEvent {
+	attributes := map[string]interface{}{
+		"reservationId": reservation.ReservationID,
+		"owner":         reservation.Owner,
+		"quantity":      reservation.Quantity,
+	}
+	if reservation.Status == ReservationConsumed {
+		attributes["quantityUsed"] = reservation.QuantityUsed
+	}
+	return ChaincodeEvent{
+		EventType:  eventType,
+		EntityType: EventEntityMaterial,
+		EntityID:   reservation.MaterialID,
+		FromState:  fromState,
+		ToState:    reservation.Status,
+		Attributes: attributes,
+	}
+}
+
+// ReserveMaterial sets aside quantity of the caller's available material for planned
+// production, e.g. a batch scheduled for next week. Reserved stock cannot be
+// transferred, written off or used by batches other than the one consuming the
+// reservation.
+func (s *SupplyChainContract) ReserveMaterial(ctx contractapi.TransactionContextInterface,
+	reservationID string, materialID string, quantity float64, purpose string) error {
+
+	if err := validateAll(
+		validateID("reservationID", reservationID),
+		validateID("materialID", materialID),
+		validateQuantity("quantity", quantity),
+		validateText("purpose", purpose, maxTextLength),
+	); err != nil {
+		return err
+	}
+
+	caller, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to get caller identity: %v", err)
+	}
+	roleContract := &RoleManagementContract{}
+	hasPermission, err := roleContract.CheckPermission(ctx, caller, "CREATE_BATCH")
+	if err != nil || !hasPermission {
+		return newError(ErrPermissionDenied, "caller %s does not have permission to reserve materials", caller)
+	}
+
+	existing, err := ctx.GetStub().GetState(reservationKeyPrefix + reservationID)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return newError(ErrAlreadyExists, "reservation %s already exists", reservationID)
+	}
+
+	inventory, err := s.GetMaterialInventory(ctx, materialID, caller)
+	if err != nil {
+		return err
+	}
+	if inventory.Available < quantity {
+		return newError(ErrInsufficientInventory, "insufficient material %s: need %.2f, have %.2f", materialID, quantity, inventory.Available)
+	}
+	inventory.Available -= quantity
+	inventory.Reserved += quantity
+	err = putMaterialInventory(ctx, inventory.ID, inventory)
+	if err != nil {
+		return err
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	reservation := &MaterialReservation{
+		ReservationID: reservationID,
+		MaterialID:    materialID,
+		Owner:         caller,
+		Quantity:      quantity,
+		Purpose:       purpose,
+		Status:        ReservationActive,
+		ReservedAt:    now.UTC().Format(time.RFC3339),
+		SchemaVersion: CurrentSchemaVersion,
+	}
+	err = putReservation(ctx, reservation)
+	if err != nil {
+		return err
+	}
+
+	return emitEvent(ctx, reservationEvent(EventMaterialReserved, reservation, ""))
+}
+
+// ReleaseReservation returns the quantity of an active reservation to the owner's
+// available stock
+func (s *SupplyChainContract) ReleaseReservation(ctx contractapi.TransactionContextInterface,
+	reservationID string) error {
+
+	if err := validateID("reservationID", reservationID); err != nil {
+		return err
+	}
+
+	reservation, inventory, err := s.activeReservation(ctx, reservationID)
+	if err != nil {
+		return err
+	}
+	inventory.Reserved -= reservation.Quantity
+	inventory.Available += reservation.Quantity
+	err = putMaterialInventory(ctx, inventory.ID, inventory)
+	if err != nil {
+		return err
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	reservation.Status = ReservationReleased
+	reservation.ClosedAt = now.UTC().Format(time.RFC3339)
+	err = putReservation(ctx, reservation)
+	if err != nil {
+		return err
+	}
+
+	return emitEvent(ctx, reservationEvent(EventMaterialReservationReleased, reservation, ReservationActive))
+}
+
+// ConsumeReservation records quantity of a reservation as used in production outside
+// a batch, e.g. for samples or repairs. Any unused remainder returns to the available
+// stock and the reservation is closed.
+func (s *SupplyChainContract) ConsumeReservation(ctx contractapi.TransactionContextInterface,
+	reservationID string, quantity float64) error {
+
+	if err := validateAll(
+		validateID("reservationID", reservationID),
+		validateQuantity("quantity", quantity),
+	); err != nil {
+		return err
+	}
+
+	reservation, inventory, err := s.activeReservation(ctx, reservationID)
+	if err != nil {
+		return err
+	}
+	if err := consumeReservation(ctx, reservation, inventory, quantity, ""); err != nil {
+		return err
+	}
+	err = putMaterialInventory(ctx, inventory.ID, inventory)
+	if err != nil {
+		return err
+	}
+	err = putReservation(ctx, reservation)
+	if err != nil {
+		return err
+	}
+
+	return emitEvent(ctx, reservationEvent(EventMaterialReservationConsumed, reservation, ReservationActive))
+}
+
+// GetMaterialReservation returns a reservation to its owner
+func (s *SupplyChainContract) GetMaterialReservation(ctx contractapi.TransactionContextInterface,
+	reservationID string) (*MaterialReservation, error) {
+
+	if err := validateID("reservationID", reservationID); err != nil {
+		return nil, err
+	}
+
+	caller, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get caller identity: %v", err)
+	}
+	reservation, err := getReservation(ctx, reservationID)
+	if err != nil {
+		return nil, err
+	}
+	if reservation.Owner != caller {
+		return nil, newError(ErrPermissionDenied, "reservation %s belongs to %s", reservationID, reservation.Owner)
+	}
+	return reservation, nil
+}
+
+// activeReservation returns one of the caller's active reservations with the inventory
+// it holds stock of
+func (s *SupplyChainContract) activeReservation(ctx contractapi.TransactionContextInterface,
+	reservationID string) (*MaterialReservation, *MaterialInventory, error) {
+
+	reservation, err := s.GetMaterialReservation(ctx, reservationID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if reservation.Status != ReservationActive {
+		return nil, nil, newError(ErrInvalidState, "reservation %s is already %s", reservationID, reservation.Status)
+	}
+	inventory, err := s.GetMaterialInventory(ctx, reservation.MaterialID, reservation.Owner)
+	if err != nil {
+		return nil, nil, err
+	}
+	return reservation, inventory, nil
+}
+
+// consumeReservation uses quantity of an active reservation from inventory, releases
+// the rest and closes the reservation. The caller stores both records.
+func consumeReservation(ctx contractapi.TransactionContextInterface, reservation *MaterialReservation,
+	inventory *MaterialInventory, quantity float64, batchID string) error {
+
+	if quantity > reservation.Quantity {
+		return newError(ErrInsufficientInventory, "reservation %s holds %.2f of material %s, need %.2f",
+			reservation.ReservationID, reservation.Quantity, reservation.MaterialID, quantity)
+	}
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	inventory.Reserved -= reservation.Quantity
+	inventory.Available += reservation.Quantity - quantity
+	inventory.Used += quantity
+
+	reservation.Status = ReservationConsumed
+	reservation.QuantityUsed = quantity
+	reservation.ConsumedBy = batchID
+	reservation.ClosedAt = now.UTC().Format(time.RFC3339)
+	return nil
+}
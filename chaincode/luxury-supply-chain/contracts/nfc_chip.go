@@ -0,0 +1,303 @@
+package contracts
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Limits for NFC chip keys and the challenges they sign
+const (
+	maxChipPublicKeys    = 4
+	minChipChallengeSize = 8 // bytes
+	maxChipChallengeSize = 64
+)
+
+// Failed chip verifications are stored as chip_verification_failure_<productID>_<txTime>_<txID>,
+// so a product's failures are one key range in the order they happened
+const chipVerificationFailureKeyPrefix = "chip_verification_failure_"
+
+// ChipVerificationFailure records a challenge a product's registered chip keys did not
+// verify, e.g. a cloned tag presented at a point of sale
+type ChipVerificationFailure struct {
+	ProductID     string `json:"productId"`
+	Challenge     string `json:"challenge"`  // Hex challenge the signature was checked against
+	Signature     string `json:"signature"`  // Base64 response that failed
+	VerifiedBy    string `json:"verifiedBy"` // MSP ID of the verifier
+	FailedAt      string `json:"failedAt"`
+	TxID          string `json:"txId"`
+	SchemaVersion int    `json:"schemaVersion"`
+}
+
+// RegisterChipPublicKey binds the public key of a product's secure NFC chip to its birth
+// certificate, for certificates issued before the chip was programmed (e.g. during batch
+// creation). Only the manufacturer can do so, while the product has not left production,
+// or the brand for the first key of a chip fitted by ApproveNFCChipReplacement.
+// The disclosure root and certificate hash are recomputed to commit to the key.
+func (o *OwnershipContract) RegisterChipPublicKey(ctx contractapi.TransactionContextInterface,
+	productID string, publicKeyPEM string) error {
+
+	if err := validateAll(
+		validateID("productID", productID),
+		validateRequired("publicKeyPEM", publicKeyPEM, maxTextLength),
+	); err != nil {
+		return err
+	}
+
+	supplyChain := &SupplyChainContract{}
+	product, err := supplyChain.GetProduct(ctx, productID)
+	if err != nil {
+		return err
+	}
+
+	caller, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to get caller identity: %v", err)
+	}
+
+	certificate, err := o.GetBirthCertificate(ctx, productID)
+	if err != nil {
+		return err
+	}
+
+	replacedChip := len(certificate.Authenticity.RetiredNFCChipIDs) > 0 && len(certificate.Authenticity.NFCChipPublicKeys) == 0
+	_, notBrand := requireSuperAdmin(ctx)
+	if !replacedChip || notBrand != nil {
+		if product.CurrentOwner != caller {
+			return newError(ErrPermissionDenied, "only the manufacturer holding product %s can register its chip", productID)
+		}
+
+		roleContract := &RoleManagementContract{}
+		hasPermission, err := roleContract.CheckPermission(ctx, caller, "CREATE_BIRTH_CERTIFICATE")
+		if err != nil || !hasPermission {
+			return newError(ErrPermissionDenied, "caller %s does not have permission to register NFC chips", caller)
+		}
+
+		if product.Status != ProductStatusCreated && product.Status != ProductStatusInProduction {
+			return newError(ErrInvalidState, "chip keys can only be registered during production, status is %s", product.Status)
+		}
+	}
+
+	certificate.Authenticity.NFCChipPublicKeys = append(certificate.Authenticity.NFCChipPublicKeys, publicKeyPEM)
+	if err := validateChipPublicKeys(certificate.Authenticity.NFCChipPublicKeys); err != nil {
+		return err
+	}
+
+	err = recommitCertificate(ctx, certificate, "NFC chip key registered")
+	if err != nil {
+		return err
+	}
+
+	return emitEvent(ctx, ChaincodeEvent{
+		EventType:  EventChipKeyRegistered,
+		EntityType: EventEntityProduct,
+		EntityID:   productID,
+		Attributes: map[string]interface{}{
+			"certificateHash": certificate.CertificateHash,
+			"chipKeys":        len(certificate.Authenticity.NFCChipPublicKeys),
+		},
+	})
+}
+
+// VerifyChipSignature checks that a product's NFC chip signed the given challenge,
+// which proves the scanned chip is the one bound to the ledger record. challenge is the
+// hex-encoded random challenge sent to the chip and signature the chip's base64 response.
+// Verifiers must use a fresh challenge per scan, otherwise a recorded response can be replayed.
+// When submitted rather than evaluated, a failed check is logged against the product.
+func (o *OwnershipContract) VerifyChipSignature(ctx contractapi.TransactionContextInterface,
+	productID string, challenge string, signature string) (bool, error) {
+
+	if err := validateAll(
+		validateID("productID", productID),
+		validateRequired("challenge", challenge, 2*maxChipChallengeSize),
+		validateRequired("signature", signature, maxNameLength),
+	); err != nil {
+		return false, err
+	}
+
+	challengeBytes, err := hex.DecodeString(challenge)
+	if err != nil || len(challengeBytes) < minChipChallengeSize {
+		return false, newError(ErrInvalidArgument, "challenge must be at least %d hex-encoded bytes", minChipChallengeSize)
+	}
+	signatureBytes, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return false, newError(ErrInvalidArgument, "signature is not valid base64")
+	}
+
+	certificate, err := o.GetBirthCertificate(ctx, productID)
+	if err != nil {
+		return false, err
+	}
+	if len(certificate.Authenticity.NFCChipPublicKeys) == 0 {
+		return false, newError(ErrInvalidState, "no NFC chip key is registered for product %s", productID)
+	}
+
+	for _, publicKeyPEM := range certificate.Authenticity.NFCChipPublicKeys {
+		_, publicKey, err := parsePublicKeyPEM(publicKeyPEM)
+		if err != nil {
+			return false, err
+		}
+		if verifySignature(publicKey, challengeBytes, signatureBytes) {
+			return true, nil
+		}
+	}
+
+	if err := recordChipVerificationFailure(ctx, productID, challenge, signature); err != nil {
+		return false, err
+	}
+	return false, nil
+}
+
+// recordChipVerificationFailure logs a failed chip verification against the product
+// and emits ChipVerificationFailed. Evaluated calls discard the write.
+func recordChipVerificationFailure(ctx contractapi.TransactionContextInterface,
+	productID string, challenge string, signature string) error {
+
+	caller, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to get caller identity: %v", err)
+	}
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	txID := ctx.GetStub().GetTxID()
+	failure := ChipVerificationFailure{
+		ProductID:     productID,
+		Challenge:     challenge,
+		Signature:     signature,
+		VerifiedBy:    caller,
+		FailedAt:      now.UTC().Format(time.RFC3339),
+		TxID:          txID,
+		SchemaVersion: CurrentSchemaVersion,
+	}
+	failureJSON, err := json.Marshal(failure)
+	if err != nil {
+		return err
+	}
+
+	key := chipVerificationFailureKeyPrefix + productID + "_" + now.UTC().Format(ledgerLogTimeLayout) + "_" + txID
+	err = ctx.GetStub().PutState(key, failureJSON)
+	if err != nil {
+		return fmt.Errorf("failed to record chip verification failure: %v", err)
+	}
+
+	return emitEvent(ctx, ChaincodeEvent{
+		EventType:  EventChipVerificationFailed,
+		EntityType: EventEntityProduct,
+		EntityID:   productID,
+		Attributes: map[string]interface{}{
+			"verifiedBy": caller,
+		},
+	})
+}
+
+// GetChipVerificationFailures returns the failed chip verifications of a product, oldest
+// first. The brand and the organization holding or locating the product may read them.
+func (o *OwnershipContract) GetChipVerificationFailures(ctx contractapi.TransactionContextInterface,
+	productID string) ([]*ChipVerificationFailure, error) {
+
+	if err := validateID("productID", productID); err != nil {
+		return nil, err
+	}
+
+	caller, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get caller identity: %v", err)
+	}
+	supplyChain := &SupplyChainContract{}
+	product, err := supplyChain.GetProduct(ctx, productID)
+	if err != nil {
+		return nil, err
+	}
+	if caller != itemHolder(product.CurrentOwner, product.Consignment) && caller != product.CurrentLocation {
+		if _, err := requireSuperAdmin(ctx); err != nil {
+			return nil, newError(ErrPermissionDenied, "only the holder, location or brand of product %s can read its chip verification failures", productID)
+		}
+	}
+
+	prefix := chipVerificationFailureKeyPrefix + productID + "_"
+	resultsIterator, err := ctx.GetStub().GetStateByRange(prefix, prefix+"~")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query chip verification failures: %v", err)
+	}
+	defer resultsIterator.Close()
+
+	failures := []*ChipVerificationFailure{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var failure ChipVerificationFailure
+		err = json.Unmarshal(queryResponse.Value, &failure)
+		if err != nil {
+			return nil, err
+		}
+		failure.upgradeSchema()
+		failures = append(failures, &failure)
+	}
+
+	return failures, nil
+}
+
+// parseBatchChipKeys reads the chip keys of a batch's products: a JSON array with one
+// PEM public key per product, or "" for a product whose chip has no key yet. An empty
+// chipKeysJSON registers none. A key can only belong to one chip.
+func parseBatchChipKeys(chipKeysJSON string, count int) ([]string, error) {
+	var keys []string
+	if chipKeysJSON != "" {
+		if err := validateJSON("chipKeys", chipKeysJSON, &keys); err != nil {
+			return nil, err
+		}
+	}
+	return checkBatchChipKeys(keys, count)
+}
+
+// checkBatchChipKeys checks the chip keys of a batch's products, one per product as in
+// parseBatchChipKeys, and returns them. Nil keys registers none.
+func checkBatchChipKeys(keys []string, count int) ([]string, error) {
+	if keys == nil {
+		return make([]string, count), nil
+	}
+	if len(keys) != count {
+		return nil, newError(ErrInvalidArgument, "chipKeys lists %d products, expected %d", len(keys), count)
+	}
+	seen := make(map[string]bool)
+	for i, key := range keys {
+		if key == "" {
+			continue
+		}
+		if _, _, err := parsePublicKeyPEM(key); err != nil {
+			return nil, newError(ErrInvalidArgument, "chip key of product %d: %v", i+1, err)
+		}
+		if seen[key] {
+			return nil, newError(ErrAlreadyExists, "chip key of product %d is listed twice", i+1)
+		}
+		seen[key] = true
+	}
+	return keys, nil
+}
+
+// validateChipPublicKeys checks the chip keys of a birth certificate
+func validateChipPublicKeys(keys []string) error {
+	if len(keys) > maxChipPublicKeys {
+		return newError(ErrInvalidArgument, "at most %d NFC chip keys can be registered", maxChipPublicKeys)
+	}
+	seen := make(map[string]bool)
+	for i, key := range keys {
+		if _, _, err := parsePublicKeyPEM(key); err != nil {
+			return newError(ErrInvalidArgument, "NFC chip key %d: %v", i+1, err)
+		}
+		if seen[key] {
+			return newError(ErrAlreadyExists, "NFC chip key %d is already registered", i+1)
+		}
+		seen[key] = true
+	}
+	return nil
+}
@@ -0,0 +1,357 @@
+package contracts
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Reference data types that oracles can provide
+const (
+	OracleDataFXRate     = "FX_RATE"     // dataKey e.g. EUR-USD, value the rate as a decimal string
+	OracleDataTariffCode = "TARIFF_CODE" // dataKey e.g. a product type, value its HS code
+	// Shipment telemetry anchored on transfers, see RecordSensorReading
+	OracleDataSensorTelemetry = "SENSOR_TELEMETRY"
+)
+
+// Ledger prefixes of oracles and the latest value they submitted per data key
+const (
+	oracleKeyPrefix        = "oracle_"
+	referenceDataKeyPrefix = "reference_data_"
+)
+
+// Oracle is an external data source allowed to submit signed reference data
+type Oracle struct {
+	ID            string   `json:"id"`
+	Name          string   `json:"name"`
+	DataTypes     []string `json:"dataTypes"`
+	PublicKeyPEM  string   `json:"publicKeyPem"`
+	KeyType       string   `json:"keyType"`
+	IsActive      bool     `json:"isActive"`
+	RegisteredBy  string   `json:"registeredBy"`
+	RegisteredAt  string   `json:"registeredAt"`
+	SchemaVersion int      `json:"schemaVersion"`
+}
+
+// ReferenceData is the latest value an oracle submitted for a data type and key.
+// The oracle signed "oracleId|dataType|dataKey|value|observedAt".
+type ReferenceData struct {
+	DataType      string `json:"dataType"`
+	DataKey       string `json:"dataKey"`
+	Value         string `json:"value"`
+	ObservedAt    string `json:"observedAt"` // When the oracle observed the value, RFC3339
+	OracleID      string `json:"oracleId"`
+	Signature     string `json:"signature"`   // Base64, verifiable with the oracle's key
+	PayloadHash   string `json:"payloadHash"` // SHA256 of the signed message
+	SubmittedBy   string `json:"submittedBy"` // MSP that relayed the submission
+	SubmittedAt   string `json:"submittedAt"`
+	TxID          string `json:"txId"`
+	SchemaVersion int    `json:"schemaVersion"`
+}
+
+// RegisterOracle adds or replaces an oracle trusted for the comma-separated dataTypes.
+// Re-registering an oracle rotates its key and reactivates it.
+func (a *AdminContract) RegisterOracle(ctx contractapi.TransactionContextInterface,
+	oracleID string, name string, dataTypes string, publicKeyPEM string) error {
+
+	if err := validateAll(
+		validateID("oracleID", oracleID),
+		validateName("name", name),
+		validateRequired("dataTypes", dataTypes, maxNameLength),
+		validateRequired("publicKeyPEM", publicKeyPEM, maxTextLength),
+	); err != nil {
+		return err
+	}
+
+	types := strings.Split(dataTypes, ",")
+	for i := range types {
+		types[i] = strings.TrimSpace(types[i])
+		if err := validateEnum("dataTypes", types[i], OracleDataFXRate, OracleDataTariffCode, OracleDataSensorTelemetry); err != nil {
+			return err
+		}
+	}
+	keyType, _, err := parsePublicKeyPEM(publicKeyPEM)
+	if err != nil {
+		return newError(ErrInvalidArgument, "publicKeyPEM: %v", err)
+	}
+
+	caller, err := requireSuperAdmin(ctx)
+	if err != nil {
+		return err
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	oracle := Oracle{
+		ID:            oracleID,
+		Name:          name,
+		DataTypes:     types,
+		PublicKeyPEM:  publicKeyPEM,
+		KeyType:       keyType,
+		IsActive:      true,
+		RegisteredBy:  caller,
+		RegisteredAt:  now.UTC().Format(time.RFC3339),
+		SchemaVersion: CurrentSchemaVersion,
+	}
+	oracleJSON, err := json.Marshal(oracle)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState(oracleKeyPrefix+oracleID, oracleJSON)
+	if err != nil {
+		return fmt.Errorf("failed to store oracle: %v", err)
+	}
+
+	return emitEvent(ctx, ChaincodeEvent{
+		EventType:  EventOracleRegistered,
+		EntityType: EventEntityConfig,
+		EntityID:   oracleKeyPrefix + oracleID,
+		Attributes: map[string]interface{}{
+			"dataTypes": strings.Join(types, ","),
+			"keyType":   keyType,
+		},
+	})
+}
+
+// DeactivateOracle stops accepting submissions from an oracle, e.g. after a key leak.
+// Values it submitted earlier stay readable.
+func (a *AdminContract) DeactivateOracle(ctx contractapi.TransactionContextInterface,
+	oracleID string) error {
+
+	if err := validateID("oracleID", oracleID); err != nil {
+		return err
+	}
+
+	if _, err := requireSuperAdmin(ctx); err != nil {
+		return err
+	}
+
+	oracle, err := a.GetOracle(ctx, oracleID)
+	if err != nil {
+		return err
+	}
+	if !oracle.IsActive {
+		return newError(ErrInvalidState, "oracle %s is already inactive", oracleID)
+	}
+	oracle.IsActive = false
+
+	oracleJSON, err := json.Marshal(oracle)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState(oracleKeyPrefix+oracleID, oracleJSON)
+	if err != nil {
+		return fmt.Errorf("failed to store oracle: %v", err)
+	}
+
+	return emitEvent(ctx, ChaincodeEvent{
+		EventType:  EventOracleDeactivated,
+		EntityType: EventEntityConfig,
+		EntityID:   oracleKeyPrefix + oracleID,
+	})
+}
+
+// GetOracle returns a registered oracle
+func (a *AdminContract) GetOracle(ctx contractapi.TransactionContextInterface,
+	oracleID string) (*Oracle, error) {
+
+	if err := validateID("oracleID", oracleID); err != nil {
+		return nil, err
+	}
+
+	oracleJSON, err := ctx.GetStub().GetState(oracleKeyPrefix + oracleID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read oracle: %v", err)
+	}
+	if oracleJSON == nil {
+		return nil, newError(ErrNotFound, "oracle %s does not exist", oracleID)
+	}
+
+	var oracle Oracle
+	err = json.Unmarshal(oracleJSON, &oracle)
+	if err != nil {
+		return nil, err
+	}
+	oracle.upgradeSchema()
+
+	return &oracle, nil
+}
+
+// SubmitOracleData records a value signed by an oracle. Any organization can relay the
+// submission; the signature makes it attributable to the oracle. A value must be observed
+// after the one it replaces, so old signed values cannot be replayed.
+func (a *AdminContract) SubmitOracleData(ctx contractapi.TransactionContextInterface,
+	oracleID string, dataType string, dataKey string, value string, observedAt string,
+	signature string) error {
+
+	if err := validateAll(
+		validateID("oracleID", oracleID),
+		validateEnum("dataType", dataType, OracleDataFXRate, OracleDataTariffCode),
+		validateID("dataKey", dataKey),
+		validateName("value", value),
+		validateRequired("observedAt", observedAt, maxNameLength),
+		validateRequired("signature", signature, maxNameLength),
+	); err != nil {
+		return err
+	}
+	if strings.Contains(value, "|") {
+		return newError(ErrInvalidArgument, "value may not contain '|'")
+	}
+	observed, err := time.Parse(time.RFC3339, observedAt)
+	if err != nil {
+		return newError(ErrInvalidArgument, "observedAt must be an RFC3339 time")
+	}
+
+	message := strings.Join([]string{oracleID, dataType, dataKey, value, observedAt}, "|")
+	if err := verifyOracleSignature(ctx, oracleID, dataType, message, signature); err != nil {
+		return err
+	}
+
+	previous, err := getReferenceData(ctx, dataType, dataKey)
+	if err != nil {
+		return err
+	}
+	if previous != nil {
+		previousObserved, _ := time.Parse(time.RFC3339, previous.ObservedAt)
+		if !observed.After(previousObserved) {
+			return newError(ErrConflict, "%s %s already has a value observed at %s", dataType, dataKey, previous.ObservedAt)
+		}
+	}
+
+	submitter, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to get caller identity: %v", err)
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	payloadHash := sha256.Sum256([]byte(message))
+	data := ReferenceData{
+		DataType:      dataType,
+		DataKey:       dataKey,
+		Value:         value,
+		ObservedAt:    observedAt,
+		OracleID:      oracleID,
+		Signature:     signature,
+		PayloadHash:   hex.EncodeToString(payloadHash[:]),
+		SubmittedBy:   submitter,
+		SubmittedAt:   now.UTC().Format(time.RFC3339),
+		TxID:          ctx.GetStub().GetTxID(),
+		SchemaVersion: CurrentSchemaVersion,
+	}
+	dataJSON, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState(referenceDataKey(dataType, dataKey), dataJSON)
+	if err != nil {
+		return fmt.Errorf("failed to store reference data: %v", err)
+	}
+
+	return emitEvent(ctx, ChaincodeEvent{
+		EventType:  EventOracleDataSubmitted,
+		EntityType: EventEntityConfig,
+		EntityID:   referenceDataKey(dataType, dataKey),
+		Attributes: map[string]interface{}{
+			"oracleId":   oracleID,
+			"value":      value,
+			"observedAt": observedAt,
+		},
+	})
+}
+
+// verifyOracleSignature checks that an active oracle registered for dataType signed
+// message. Fields of signed messages are joined with '|'.
+func verifyOracleSignature(ctx contractapi.TransactionContextInterface, oracleID string, dataType string,
+	message string, signature string) error {
+
+	adminContract := &AdminContract{}
+	oracle, err := adminContract.GetOracle(ctx, oracleID)
+	if err != nil {
+		return err
+	}
+	if !oracle.IsActive {
+		return newError(ErrPermissionDenied, "oracle %s is inactive", oracleID)
+	}
+	registered := false
+	for _, registeredType := range oracle.DataTypes {
+		registered = registered || registeredType == dataType
+	}
+	if !registered {
+		return newError(ErrPermissionDenied, "oracle %s is not registered for %s", oracleID, dataType)
+	}
+
+	signatureBytes, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return newError(ErrInvalidArgument, "signature is not valid base64")
+	}
+	_, publicKey, err := parsePublicKeyPEM(oracle.PublicKeyPEM)
+	if err != nil {
+		return err
+	}
+	if !verifySignature(publicKey, []byte(message), signatureBytes) {
+		return newError(ErrPermissionDenied, "signature does not match oracle %s", oracleID)
+	}
+	return nil
+}
+
+// GetOracleData returns the latest value for a data type and key
+func (a *AdminContract) GetOracleData(ctx contractapi.TransactionContextInterface,
+	dataType string, dataKey string) (*ReferenceData, error) {
+
+	if err := validateAll(
+		validateEnum("dataType", dataType, OracleDataFXRate, OracleDataTariffCode),
+		validateID("dataKey", dataKey),
+	); err != nil {
+		return nil, err
+	}
+
+	data, err := getReferenceData(ctx, dataType, dataKey)
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		return nil, newError(ErrNotFound, "no %s value for %s", dataType, dataKey)
+	}
+	return data, nil
+}
+
+// getReferenceData reads the latest oracle value for a data type and key, nil if there is
+// none. Contracts that value or declare goods use it so their figures trace back to an oracle.
+func getReferenceData(ctx contractapi.TransactionContextInterface,
+	dataType string, dataKey string) (*ReferenceData, error) {
+
+	dataJSON, err := ctx.GetStub().GetState(referenceDataKey(dataType, dataKey))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read reference data: %v", err)
+	}
+	if dataJSON == nil {
+		return nil, nil
+	}
+
+	var data ReferenceData
+	err = json.Unmarshal(dataJSON, &data)
+	if err != nil {
+		return nil, err
+	}
+	data.upgradeSchema()
+
+	return &data, nil
+}
+
+// referenceDataKey returns the ledger key of the latest value for a data type and key
+func referenceDataKey(dataType string, dataKey string) string {
+	return referenceDataKeyPrefix + dataType + "_" + dataKey
+}
@@ -0,0 +1,386 @@
+package contracts
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Dashboard counters are kept per organization. A transaction that changes what an
+// organization owns or has pending writes its change as a delta under
+// stats_<org>_<txID>, so concurrent transactions of one organization never conflict
+// on a shared counter. Reads add the deltas to the base record stats_<org>, and
+// CompactOrganizationStats folds them into it.
+const organizationStatsKeyPrefix = "stats_"
+
+// customerOwner is the CurrentOwner of products sold to a customer
+const customerOwner = "customer"
+
+// OrganizationStats holds the counters behind GetDashboardStats, or a change to them
+type OrganizationStats struct {
+	OrgMSPID                  string  `json:"orgMspId"`
+	Products                  int     `json:"products"`               // Products the organization holds
+	Batches                   int     `json:"batches"`                // Batches the organization holds
+	PendingTransfers          int     `json:"pendingTransfers"`       // Open transfers it sends or receives
+	UrgentPendingTransfers    int     `json:"urgentPendingTransfers"` // Those of them marked URGENT
+	Materials                 int     `json:"materials"`              // Material inventories it owns
+	AvailableMaterialQuantity float64 `json:"availableMaterialQuantity"`
+	DemoUnits                 int     `json:"demoUnits"`    // Held products in DEMO status
+	DamagedUnits              int     `json:"damagedUnits"` // Held products in DAMAGED status
+	UpdatedAt                 string  `json:"updatedAt"`
+	SchemaVersion             int     `json:"schemaVersion"`
+}
+
+// add adds sign times the counters of other
+func (s *OrganizationStats) add(other OrganizationStats, sign int) {
+	s.Products += sign * other.Products
+	s.Batches += sign * other.Batches
+	s.PendingTransfers += sign * other.PendingTransfers
+	s.UrgentPendingTransfers += sign * other.UrgentPendingTransfers
+	s.Materials += sign * other.Materials
+	s.AvailableMaterialQuantity += float64(sign) * other.AvailableMaterialQuantity
+	s.DemoUnits += sign * other.DemoUnits
+	s.DamagedUnits += sign * other.DamagedUnits
+}
+
+// isZero reports whether all counters are zero
+func (s *OrganizationStats) isZero() bool {
+	return s.Products == 0 && s.Batches == 0 && s.PendingTransfers == 0 && s.UrgentPendingTransfers == 0 &&
+		s.Materials == 0 && s.AvailableMaterialQuantity == 0 && s.DemoUnits == 0 &&
+		s.DamagedUnits == 0
+}
+
+// statsContribution is what one ledger record adds to the counters of each organization
+type statsContribution map[string]OrganizationStats
+
+// organizationStatsKey returns the key of an organization's base counters
+func organizationStatsKey(orgMSPID string) string {
+	return organizationStatsKeyPrefix + orgMSPID
+}
+
+// organizationStatsDeltaKey returns the key of a transaction's change to an organization's counters
+func organizationStatsDeltaKey(orgMSPID string, txID string) string {
+	return organizationStatsKeyPrefix + orgMSPID + "_" + txID
+}
+
+func productStats(product *Product) statsContribution {
+	if product.CurrentOwner == "" || product.CurrentOwner == customerOwner {
+		return nil
+	}
+	stats := OrganizationStats{Products: 1}
+	switch product.Status {
+	case ProductStatusDemo:
+		stats.DemoUnits = 1
+	case ProductStatusDamaged:
+		stats.DamagedUnits = 1
+	}
+	return statsContribution{product.CurrentOwner: stats}
+}
+
+func batchStats(batch *ProductBatch) statsContribution {
+	// A merged batch lives on in the batch its products were merged into
+	if batch.CurrentOwner == "" || batch.Status == BatchStatusMerged {
+		return nil
+	}
+	return statsContribution{batch.CurrentOwner: {Batches: 1}}
+}
+
+// transferStats counts the transfers listed by GetPendingTransfers
+func transferStats(transfer *Transfer) statsContribution {
+	if !isOpenTransfer(transfer) || isDisputeReturn(transfer) {
+		return nil
+	}
+	stats := OrganizationStats{PendingTransfers: 1}
+	if isUrgentTransfer(transfer) {
+		stats.UrgentPendingTransfers = 1
+	}
+	contribution := statsContribution{}
+	for _, org := range []string{transfer.From, transfer.To} {
+		if org != "" {
+			contribution[org] = stats
+		}
+	}
+	return contribution
+}
+
+func inventoryStats(inventory *MaterialInventory) statsContribution {
+	if inventory.Owner == "" {
+		return nil
+	}
+	return statsContribution{inventory.Owner: {Materials: 1, AvailableMaterialQuantity: inventory.Available}}
+}
+
+// trackRecordStats records the counter change of writing record under key. The record's
+// previous contribution is read from the ledger on its first write in the transaction.
+func trackRecordStats[T any](ctx contractapi.TransactionContextInterface, key string,
+	record *T, contribution func(*T) statsContribution) error {
+
+	tc, ok := ctx.(*TransactionContext)
+	if !ok {
+		// Only contexts created by the contracts buffer counter changes
+		return nil
+	}
+	if tc.statsSources == nil {
+		tc.statsSources = make(map[string]statsContribution)
+		tc.statsDeltas = make(map[string]*OrganizationStats)
+	}
+
+	before, written := tc.statsSources[key]
+	if !written {
+		stored, err := ctx.GetStub().GetState(key)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %v", key, err)
+		}
+		if stored != nil {
+			var previous T
+			if err := json.Unmarshal(stored, &previous); err == nil {
+				before = contribution(&previous)
+			}
+		}
+	}
+	after := contribution(record)
+	tc.statsSources[key] = after
+
+	// Always in this order, floating point sums must be the same on every endorser
+	tc.addStatsDelta(before, -1)
+	tc.addStatsDelta(after, 1)
+	return nil
+}
+
+// addStatsDelta adds sign times a contribution to the transaction's counter changes
+func (tc *TransactionContext) addStatsDelta(contribution statsContribution, sign int) {
+	for org, count := range contribution {
+		if tc.statsDeltas[org] == nil {
+			tc.statsDeltas[org] = &OrganizationStats{}
+		}
+		tc.statsDeltas[org].add(count, sign)
+	}
+}
+
+// flushStats writes the counter changes buffered by the transaction. Contracts run it
+// after every function that succeeded.
+func flushStats(ctx contractapi.TransactionContextInterface) error {
+	tc, ok := ctx.(*TransactionContext)
+	if !ok || len(tc.statsDeltas) == 0 {
+		return nil
+	}
+
+	orgs := make([]string, 0, len(tc.statsDeltas))
+	for org := range tc.statsDeltas {
+		orgs = append(orgs, org)
+	}
+	sort.Strings(orgs)
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	txID := ctx.GetStub().GetTxID()
+	for _, org := range orgs {
+		delta := tc.statsDeltas[org]
+		if delta.isZero() {
+			continue
+		}
+		delta.OrgMSPID = org
+		delta.UpdatedAt = now.UTC().Format(time.RFC3339)
+		delta.SchemaVersion = CurrentSchemaVersion
+
+		deltaJSON, err := json.Marshal(delta)
+		if err != nil {
+			return err
+		}
+		if err := ctx.GetStub().PutState(organizationStatsDeltaKey(org, txID), deltaJSON); err != nil {
+			return fmt.Errorf("failed to store organization stats: %v", err)
+		}
+	}
+	tc.statsDeltas = nil
+	tc.statsSources = nil
+	return nil
+}
+
+// getOrganizationStats returns an organization's counters and the keys of the deltas
+// not yet folded into its base record
+func getOrganizationStats(ctx contractapi.TransactionContextInterface,
+	orgMSPID string) (*OrganizationStats, []string, error) {
+
+	stats := &OrganizationStats{OrgMSPID: orgMSPID, SchemaVersion: CurrentSchemaVersion}
+	baseJSON, err := ctx.GetStub().GetState(organizationStatsKey(orgMSPID))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read organization stats: %v", err)
+	}
+	if baseJSON != nil {
+		if err := json.Unmarshal(baseJSON, stats); err != nil {
+			return nil, nil, err
+		}
+		stats.upgradeSchema()
+	}
+
+	deltaPrefix := organizationStatsDeltaKey(orgMSPID, "")
+	resultsIterator, err := ctx.GetStub().GetStateByRange(deltaPrefix, deltaPrefix+"~")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to query organization stats: %v", err)
+	}
+	defer resultsIterator.Close()
+
+	var deltaKeys []string
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, nil, err
+		}
+		var delta OrganizationStats
+		if err := json.Unmarshal(queryResponse.Value, &delta); err != nil {
+			return nil, nil, err
+		}
+		stats.add(delta, 1)
+		if delta.UpdatedAt > stats.UpdatedAt {
+			stats.UpdatedAt = delta.UpdatedAt
+		}
+		deltaKeys = append(deltaKeys, queryResponse.Key)
+	}
+
+	return stats, deltaKeys, nil
+}
+
+// putOrganizationStats replaces an organization's base counters and removes the folded deltas
+func putOrganizationStats(ctx contractapi.TransactionContextInterface,
+	stats *OrganizationStats, deltaKeys []string) error {
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	stats.UpdatedAt = now.UTC().Format(time.RFC3339)
+	stats.SchemaVersion = CurrentSchemaVersion
+	statsJSON, err := json.Marshal(stats)
+	if err != nil {
+		return err
+	}
+	if err := ctx.GetStub().PutState(organizationStatsKey(stats.OrgMSPID), statsJSON); err != nil {
+		return fmt.Errorf("failed to store organization stats: %v", err)
+	}
+	for _, key := range deltaKeys {
+		if err := ctx.GetStub().DelState(key); err != nil {
+			return fmt.Errorf("failed to remove %s: %v", key, err)
+		}
+	}
+	return nil
+}
+
+// CompactOrganizationStats folds an organization's counter deltas into its base record,
+// which keeps GetDashboardStats reading a handful of keys. The organization itself or a
+// super admin calls it periodically.
+func (a *AdminContract) CompactOrganizationStats(ctx contractapi.TransactionContextInterface,
+	orgMSPID string) (*OrganizationStats, error) {
+
+	if err := checkOrganizationStatsCaller(ctx, orgMSPID); err != nil {
+		return nil, err
+	}
+
+	stats, deltaKeys, err := getOrganizationStats(ctx, orgMSPID)
+	if err != nil {
+		return nil, err
+	}
+	if err := putOrganizationStats(ctx, stats, deltaKeys); err != nil {
+		return nil, err
+	}
+
+	return stats, emitEvent(ctx, ChaincodeEvent{
+		EventType:  EventOrganizationStatsCompacted,
+		EntityType: EventEntityOrganization,
+		EntityID:   orgMSPID,
+		Attributes: map[string]interface{}{
+			"deltas": len(deltaKeys),
+		},
+	})
+}
+
+// RecountOrganizationStats recomputes an organization's counters by scanning the ledger.
+// It initializes the counters of data written before they existed and repairs them
+// after a manual state fix, at the cost of the full scans the counters otherwise avoid.
+func (a *AdminContract) RecountOrganizationStats(ctx contractapi.TransactionContextInterface,
+	orgMSPID string) (*OrganizationStats, error) {
+
+	if err := checkOrganizationStatsCaller(ctx, orgMSPID); err != nil {
+		return nil, err
+	}
+
+	_, deltaKeys, err := getOrganizationStats(ctx, orgMSPID)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &OrganizationStats{OrgMSPID: orgMSPID}
+	supplyChain := &SupplyChainContract{}
+	products, err := allProducts(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, product := range products {
+		stats.add(productStats(product)[orgMSPID], 1)
+	}
+	batches, err := supplyChain.GetBatchesByOrganization(ctx, orgMSPID)
+	if err != nil {
+		return nil, err
+	}
+	stats.Batches = len(batches)
+	pendingTransfers, err := supplyChain.GetPendingTransfers(ctx, orgMSPID)
+	if err != nil {
+		return nil, err
+	}
+	stats.PendingTransfers = len(pendingTransfers)
+	for _, transfer := range pendingTransfers {
+		if isUrgentTransfer(transfer) {
+			stats.UrgentPendingTransfers++
+		}
+	}
+	inventories, err := allMaterialInventories(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, inventory := range inventories {
+		stats.add(inventoryStats(inventory)[orgMSPID], 1)
+	}
+
+	if err := putOrganizationStats(ctx, stats, deltaKeys); err != nil {
+		return nil, err
+	}
+
+	return stats, emitEvent(ctx, ChaincodeEvent{
+		EventType:  EventOrganizationStatsRecounted,
+		EntityType: EventEntityOrganization,
+		EntityID:   orgMSPID,
+		Attributes: map[string]interface{}{
+			"products":               stats.Products,
+			"batches":                stats.Batches,
+			"pendingTransfers":       stats.PendingTransfers,
+			"urgentPendingTransfers": stats.UrgentPendingTransfers,
+			"materials":              stats.Materials,
+			"demoUnits":              stats.DemoUnits,
+			"damagedUnits":           stats.DamagedUnits,
+		},
+	})
+}
+
+// checkOrganizationStatsCaller allows an organization and super admins to maintain its counters
+func checkOrganizationStatsCaller(ctx contractapi.TransactionContextInterface, orgMSPID string) error {
+	if err := validateID("orgMSPID", orgMSPID); err != nil {
+		return err
+	}
+
+	caller, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to get caller identity: %v", err)
+	}
+	if caller == orgMSPID {
+		return nil
+	}
+
+	if _, err := requireSuperAdmin(ctx); err != nil {
+		return newError(ErrPermissionDenied, "caller %s does not have permission to maintain the stats of %s", caller, orgMSPID)
+	}
+	return nil
+}
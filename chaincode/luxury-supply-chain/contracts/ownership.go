@@ -6,7 +6,6 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
-	"strings"
 	"time"
 
 	"github.com/hyperledger/fabric-contract-api-go/contractapi"
@@ -21,20 +20,28 @@ type OwnershipContract struct {
 func (o *OwnershipContract) CreateDigitalBirthCertificate(ctx contractapi.TransactionContextInterface,
 	productID string, manufacturingPlace string, craftsman string, authenticityJSON string) error {
 
+	if err := validateAll(
+		validateID("productID", productID),
+		validateName("manufacturingPlace", manufacturingPlace),
+		validateText("craftsman", craftsman, maxNameLength),
+	); err != nil {
+		return err
+	}
+
 	// Check if certificate already exists
 	certKey := "cert_" + productID
 	existingCert, _ := ctx.GetStub().GetState(certKey)
 	if existingCert != nil {
-		return fmt.Errorf("digital birth certificate already exists for product %s", productID)
+		return newError(ErrAlreadyExists, "digital birth certificate already exists for product %s", productID)
 	}
 
 	// Get product to verify it exists
-	productJSON, err := ctx.GetStub().GetState(productID)
+	productJSON, err := getProductState(ctx, productID)
 	if err != nil {
 		return err
 	}
 	if productJSON == nil {
-		return fmt.Errorf("product %s does not exist", productID)
+		return newError(ErrNotFound, "product %s does not exist", productID)
 	}
 
 	var product Product
@@ -53,21 +60,28 @@ func (o *OwnershipContract) CreateDigitalBirthCertificate(ctx contractapi.Transa
 		return err
 	}
 	if product.CurrentOwner != creator {
-		return fmt.Errorf("only the manufacturer can create birth certificate")
+		return newError(ErrPermissionDenied, "only the manufacturer can create birth certificate")
 	}
 	
 	// CHECK PERMISSION - Only manufacturers can create birth certificates
 	roleContract := &RoleManagementContract{}
 	hasPermission, err := roleContract.CheckPermission(ctx, creator, "CREATE_BIRTH_CERTIFICATE")
 	if err != nil || !hasPermission {
-		return fmt.Errorf("caller %s does not have permission to create birth certificates", creator)
+		return newError(ErrPermissionDenied, "caller %s does not have permission to create birth certificates", creator)
 	}
 
 	// Parse authenticity details
 	var authenticity AuthenticityDetails
-	err = json.Unmarshal([]byte(authenticityJSON), &authenticity)
+	if err := validateJSON("authenticity details", authenticityJSON, &authenticity); err != nil {
+		return err
+	}
+	if err := validateChipPublicKeys(authenticity.NFCChipPublicKeys); err != nil {
+		return err
+	}
+	// The QR code is always issued here, so it can be checked with VerifyQRPayload
+	authenticity.QRCodeData, err = issueQRCode(ctx, productID, product.BatchID, 1)
 	if err != nil {
-		return fmt.Errorf("invalid authenticity details: %v", err)
+		return err
 	}
 
 	// Create material records from product materials
@@ -85,6 +99,7 @@ func (o *OwnershipContract) CreateDigitalBirthCertificate(ctx contractapi.Transa
 
 	// Create certificate
 	certificate := DigitalBirthCertificate{
+		SchemaVersion: CurrentSchemaVersion,
 		ProductID:          productID,
 		Brand:              product.Brand,
 		ManufacturingDate:  time.Now().Format(time.RFC3339),
@@ -92,7 +107,15 @@ func (o *OwnershipContract) CreateDigitalBirthCertificate(ctx contractapi.Transa
 		Craftsman:          craftsman,
 		Materials:          materialRecords,
 		Authenticity:       authenticity,
-		InitialPhotos:      []string{}, // Will be added via separate function
+		InitialPhotos:      []string{}, // Added with AddCertificatePhotos
+		Revision:           1,
+		Sustainability:     product.Footprint,
+	}
+
+	// Commit to salted per-field hashes for selective disclosure
+	err = prepareCertificateDisclosure(ctx, &certificate)
+	if err != nil {
+		return err
 	}
 
 	// Calculate certificate hash
@@ -110,16 +133,31 @@ func (o *OwnershipContract) CreateDigitalBirthCertificate(ctx contractapi.Transa
 	if err != nil {
 		return err
 	}
+	err = indexNFCChip(ctx, authenticity.NFCChipID, productID)
+	if err != nil {
+		return err
+	}
 
 	// Update product status
-	product.Status = ProductStatusInProduction
-	productJSON, _ = json.Marshal(product)
-	ctx.GetStub().PutState(productID, productJSON)
+	previousStatus := product.Status
+	if err := setProductStatus(&product, ProductStatusInProduction); err != nil {
+		return err
+	}
+	if err := putProduct(ctx, &product); err != nil {
+		return err
+	}
 
 	// Emit event
-	ctx.GetStub().SetEvent("BirthCertificateCreated", certJSON)
-
-	return nil
+	return emitEvent(ctx, ChaincodeEvent{
+		EventType:  EventBirthCertificateCreated,
+		EntityType: EventEntityProduct,
+		EntityID:   productID,
+		FromState:  string(previousStatus),
+		ToState:    string(product.Status),
+		Attributes: map[string]interface{}{
+			"certificateHash": certificate.CertificateHash,
+		},
+	})
 }
 
 // RecoverStolen allows the owner to mark a stolen product as recovered
@@ -127,6 +165,15 @@ func (o *OwnershipContract) CreateDigitalBirthCertificate(ctx contractapi.Transa
 func (o *OwnershipContract) RecoverStolen(ctx contractapi.TransactionContextInterface,
 	productID string, ownerHash string, securityHash string, recoveryProof string) error {
 
+	if err := validateAll(
+		validateID("productID", productID),
+		validateID("ownerHash", ownerHash),
+		validateID("securityHash", securityHash),
+		validateText("recoveryProof", recoveryProof, maxTextLength),
+	); err != nil {
+		return err
+	}
+
 	// Get ownership
 	ownership, err := o.GetOwnership(ctx, productID)
 	if err != nil {
@@ -135,17 +182,17 @@ func (o *OwnershipContract) RecoverStolen(ctx contractapi.TransactionContextInte
 
 	// Verify owner hash matches
 	if ownership.OwnerHash != ownerHash {
-		return fmt.Errorf("ownership verification failed")
+		return newError(ErrPermissionDenied, "ownership verification failed")
 	}
 
 	// Verify security hash matches (password:PIN verification)
 	if ownership.SecurityHash != securityHash {
-		return fmt.Errorf("security verification failed - invalid password or PIN")
+		return newError(ErrPermissionDenied, "security verification failed - invalid password or PIN")
 	}
 
 	// Check if product is actually stolen
 	if ownership.Status != OwnershipStatusReported {
-		return fmt.Errorf("product is not reported as stolen")
+		return newError(ErrInvalidState, "product is not reported as stolen")
 	}
 
 	// Update ownership status back to active
@@ -164,42 +211,61 @@ func (o *OwnershipContract) RecoverStolen(ctx contractapi.TransactionContextInte
 	ownership.ServiceHistory = append(ownership.ServiceHistory, recoveryRecord)
 
 	// Update ownership
-	ownershipJSON, err := json.Marshal(ownership)
-	if err != nil {
-		return err
-	}
-
-	ownershipKey := "ownership_" + productID
-	err = ctx.GetStub().PutState(ownershipKey, ownershipJSON)
+	err = putOwnership(ctx, ownership)
 	if err != nil {
 		return err
 	}
 
 	// Update product status
-	productJSON, _ := ctx.GetStub().GetState(productID)
+	productJSON, _ := getProductState(ctx, productID)
 	var product Product
 	json.Unmarshal(productJSON, &product)
 	// Ensure Materials is never nil
 	if product.Materials == nil {
 		product.Materials = []Material{}
 	}
-	product.Status = ProductStatusSold
+	previousStatus := product.Status
+	if err := setProductStatus(&product, ProductStatusSold); err != nil {
+		return err
+	}
 	product.IsStolen = false
 	product.RecoveredDate = time.Now().Format(time.RFC3339)
-	productJSON, _ = json.Marshal(product)
-	ctx.GetStub().PutState(productID, productJSON)
+	if err := putProduct(ctx, &product); err != nil {
+		return err
+	}
+	if err := unregisterStolenProduct(ctx, &product); err != nil {
+		return err
+	}
 
 	// Emit event
-	ctx.GetStub().SetEvent("ProductRecovered", ownershipJSON)
-
-	return nil
+	return emitEvent(ctx, ChaincodeEvent{
+		EventType:  EventProductRecovered,
+		EntityType: EventEntityProduct,
+		EntityID:   productID,
+		FromState:  string(previousStatus),
+		ToState:    string(product.Status),
+	})
 }
 
 // GenerateTransferCode generates a temporary code for ownership transfer
 // Called by backend after authenticating the customer off-chain
 // Now requires security hash (password+PIN) verification
+// transferType is sale, resale, gift or inheritance; documentHash identifies the document
+// the brand's rule for that type may require, see SetOwnershipTransferRules
+// A twoPhase code is only accepted by RedeemTransferCode, never by TransferOwnership
 func (o *OwnershipContract) GenerateTransferCode(ctx contractapi.TransactionContextInterface,
-	productID string, currentOwnerHash string, securityHash string) (string, error) {
+	productID string, currentOwnerHash string, securityHash string, transferType string, documentHash string,
+	twoPhase bool) (string, error) {
+
+	if err := validateAll(
+		validateID("productID", productID),
+		validateID("currentOwnerHash", currentOwnerHash),
+		validateID("securityHash", securityHash),
+		validateEnum("transferType", transferType, ownershipTransferTypes...),
+		validateText("documentHash", documentHash, maxNameLength),
+	); err != nil {
+		return "", err
+	}
 
 	// Get ownership
 	ownership, err := o.GetOwnership(ctx, productID)
@@ -209,12 +275,34 @@ func (o *OwnershipContract) GenerateTransferCode(ctx contractapi.TransactionCont
 
 	// Verify owner hash matches
 	if ownership.OwnerHash != currentOwnerHash {
-		return "", fmt.Errorf("ownership verification failed")
+		return "", newError(ErrPermissionDenied, "ownership verification failed")
 	}
 
 	// Verify security hash (password + PIN) matches
 	if ownership.SecurityHash != securityHash {
-		return "", fmt.Errorf("security verification failed - incorrect password or PIN")
+		return "", newError(ErrPermissionDenied, "security verification failed - incorrect password or PIN")
+	}
+
+	// A redeemed code must be finalized or cancelled first
+	if ownership.PendingTransfer != nil {
+		return "", newError(ErrInvalidState, "a transfer of product %s awaits finalization", productID)
+	}
+
+	// Apply the brand's rule for the transfer type
+	productJSON, err := getProductState(ctx, productID)
+	if err != nil {
+		return "", err
+	}
+	var product Product
+	if err := json.Unmarshal(productJSON, &product); err != nil {
+		return "", err
+	}
+	rules, err := getOwnershipTransferRules(ctx, product.Brand)
+	if err != nil {
+		return "", err
+	}
+	if rules.Rules[transferType].RequiresDocumentHash && documentHash == "" {
+		return "", newError(ErrInvalidArgument, "%s transfers of %s products require a documentHash", transferType, product.Brand)
 	}
 
 	// Generate random transfer code
@@ -226,15 +314,28 @@ func (o *OwnershipContract) GenerateTransferCode(ctx contractapi.TransactionCont
 	// Update ownership with transfer code
 	ownership.TransferCode = code
 	ownership.TransferExpiry = expiry
+	ownership.TransferCodeType = transferType
+	ownership.TransferDocumentHash = documentHash
+	ownership.TransferTwoPhase = twoPhase
 	ownership.Status = OwnershipStatusTransferring
 
-	ownershipJSON, err := json.Marshal(ownership)
+	err = putOwnership(ctx, ownership)
 	if err != nil {
 		return "", err
 	}
 
-	ownershipKey := "ownership_" + productID
-	err = ctx.GetStub().PutState(ownershipKey, ownershipJSON)
+	// The code itself is a secret for the new owner and stays off the event stream
+	err = emitEvent(ctx, ChaincodeEvent{
+		EventType:  EventTransferCodeGenerated,
+		EntityType: EventEntityOwnership,
+		EntityID:   productID,
+		ToState:    string(ownership.Status),
+		Attributes: map[string]interface{}{
+			"expiresAt":    expiry,
+			"transferType": transferType,
+			"twoPhase":     twoPhase,
+		},
+	})
 	if err != nil {
 		return "", err
 	}
@@ -245,8 +346,19 @@ func (o *OwnershipContract) GenerateTransferCode(ctx contractapi.TransactionCont
 // TransferOwnership transfers ownership using the transfer code
 // Called by backend after authenticating the new customer off-chain
 // New owner provides their own security hash (password+PIN)
+// and confirms the transfer type the code was generated for
 func (o *OwnershipContract) TransferOwnership(ctx contractapi.TransactionContextInterface,
-	productID string, transferCode string, newOwnerHash string, newSecurityHash string) error {
+	productID string, transferCode string, newOwnerHash string, newSecurityHash string, transferType string) error {
+
+	if err := validateAll(
+		validateID("productID", productID),
+		validateID("transferCode", transferCode),
+		validateID("newOwnerHash", newOwnerHash),
+		validateID("newSecurityHash", newSecurityHash),
+		validateEnum("transferType", transferType, ownershipTransferTypes...),
+	); err != nil {
+		return err
+	}
 
 	// Get ownership
 	ownership, err := o.GetOwnership(ctx, productID)
@@ -256,65 +368,47 @@ func (o *OwnershipContract) TransferOwnership(ctx contractapi.TransactionContext
 
 	// Verify transfer code
 	if ownership.TransferCode != transferCode {
-		return fmt.Errorf("invalid transfer code")
+		return newError(ErrInvalidArgument, "invalid transfer code")
+	}
+	if err := checkCodeTransferType(ownership, transferType); err != nil {
+		return err
+	}
+	if ownership.TransferTwoPhase {
+		return newError(ErrInvalidState, "the transfer code is two-phase and must be redeemed with RedeemTransferCode")
 	}
 
 	// Check expiry
 	if ownership.TransferExpiry == "" || time.Now().Format(time.RFC3339) > ownership.TransferExpiry {
-		return fmt.Errorf("transfer code has expired")
-	}
-
-	// Record previous owner
-	prevOwner := PreviousOwner{
-		OwnerHash:     ownership.OwnerHash,
-		OwnershipDate: ownership.OwnershipDate,
-		TransferDate:  time.Now().Format(time.RFC3339),
-		TransferType:  "sale",
+		return newError(ErrInvalidState, "transfer code has expired")
 	}
-	ownership.PreviousOwners = append(ownership.PreviousOwners, prevOwner)
-
-	// Update ownership
-	ownership.OwnerHash = newOwnerHash
-	ownership.SecurityHash = newSecurityHash  // New owner's security hash
-	ownership.OwnershipDate = time.Now().Format(time.RFC3339)
-	ownership.TransferCode = ""
-	ownership.TransferExpiry = ""
-	ownership.Status = OwnershipStatusActive
 
-	// Store updated ownership
-	ownershipJSON, err := json.Marshal(ownership)
+	transferredAt, err := txTime(ctx)
 	if err != nil {
 		return err
 	}
 
-	ownershipKey := "ownership_" + productID
-	err = ctx.GetStub().PutState(ownershipKey, ownershipJSON)
+	// Record the previous owner and make the new owner, with their own security hash, current
+	event, err := changeOwner(ctx, ownership, newOwnerHash, newSecurityHash, transferredAt.UTC().Format(time.RFC3339))
 	if err != nil {
 		return err
 	}
-
-	// Update product
-	productJSON, _ := ctx.GetStub().GetState(productID)
-	var product Product
-	json.Unmarshal(productJSON, &product)
-	// Ensure Materials is never nil
-	if product.Materials == nil {
-		product.Materials = []Material{}
-	}
-	product.OwnershipHash = newOwnerHash
-	productJSON, _ = json.Marshal(product)
-	ctx.GetStub().PutState(productID, productJSON)
-
-	// Emit event
-	ctx.GetStub().SetEvent("OwnershipTransferred", ownershipJSON)
-
-	return nil
+	return emitEvent(ctx, event)
 }
 
 // ReportStolen marks a product as stolen
 // Called by backend after customer authentication and verification
+// With an insurerID, it also opens a theft claim against the owner's policy with that insurer
 func (o *OwnershipContract) ReportStolen(ctx contractapi.TransactionContextInterface,
-	productID string, ownerHash string, securityHash string, policeReportID string) error {
+	productID string, ownerHash string, securityHash string, policeReportID string, insurerID string) error {
+
+	if err := validateAll(
+		validateID("productID", productID),
+		validateID("ownerHash", ownerHash),
+		validateID("securityHash", securityHash),
+		validateText("policeReportID", policeReportID, maxNameLength),
+	); err != nil {
+		return err
+	}
 
 	// Get ownership
 	ownership, err := o.GetOwnership(ctx, productID)
@@ -324,16 +418,17 @@ func (o *OwnershipContract) ReportStolen(ctx contractapi.TransactionContextInter
 
 	// Verify owner hash matches
 	if ownership.OwnerHash != ownerHash {
-		return fmt.Errorf("ownership verification failed")
+		return newError(ErrPermissionDenied, "ownership verification failed")
 	}
 
 	// Verify security hash matches (password:PIN verification)
 	if ownership.SecurityHash != securityHash {
-		return fmt.Errorf("security verification failed - invalid password or PIN")
+		return newError(ErrPermissionDenied, "security verification failed - invalid password or PIN")
 	}
 
-	// Update ownership status
+	// Update ownership status; a stolen report voids a redeemed transfer code
 	ownership.Status = OwnershipStatusReported
+	ownership.PendingTransfer = nil
 
 	// Store police report reference in metadata
 	if ownership.ServiceHistory == nil {
@@ -344,7 +439,7 @@ func (o *OwnershipContract) ReportStolen(ctx contractapi.TransactionContextInter
 		ID:            policeReportID,
 		Date:          time.Now().Format(time.RFC3339),
 		ServiceCenter: "Police Report",
-		Type:          "stolen_report",
+		Type:          stolenReportRecordType,
 		Description:   "Product reported stolen",
 		Technician:    "N/A",
 		Warranty:      false,
@@ -352,44 +447,64 @@ func (o *OwnershipContract) ReportStolen(ctx contractapi.TransactionContextInter
 	ownership.ServiceHistory = append(ownership.ServiceHistory, stolenRecord)
 
 	// Update ownership
-	ownershipJSON, err := json.Marshal(ownership)
-	if err != nil {
-		return err
-	}
-
-	ownershipKey := "ownership_" + productID
-	err = ctx.GetStub().PutState(ownershipKey, ownershipJSON)
+	err = putOwnership(ctx, ownership)
 	if err != nil {
 		return err
 	}
 
 	// Update product status
-	productJSON, _ := ctx.GetStub().GetState(productID)
+	productJSON, _ := getProductState(ctx, productID)
 	var product Product
 	json.Unmarshal(productJSON, &product)
 	// Ensure Materials is never nil
 	if product.Materials == nil {
 		product.Materials = []Material{}
 	}
-	product.Status = ProductStatusStolen
+	previousStatus := product.Status
+	if err := setProductStatus(&product, ProductStatusStolen); err != nil {
+		return err
+	}
 	product.IsStolen = true
 	product.StolenDate = time.Now().Format(time.RFC3339)
 	product.RecoveredDate = "N/A" // Clear any previous recovery date
-	productJSON, _ = json.Marshal(product)
-	ctx.GetStub().PutState(productID, productJSON)
+	if err := putProduct(ctx, &product); err != nil {
+		return err
+	}
+	if err := registerStolenProduct(ctx, &product); err != nil {
+		return err
+	}
 
 	// Emit high priority event
-	ctx.GetStub().SetEvent("ProductReportedStolen", ownershipJSON)
-
-	return nil
+	event := ChaincodeEvent{
+		EventType:  EventProductReportedStolen,
+		EntityType: EventEntityProduct,
+		EntityID:   productID,
+		FromState:  string(previousStatus),
+		ToState:    string(product.Status),
+	}
+	if insurerID != "" {
+		claimID, err := openInsuranceClaim(ctx, productID, insurerID, InsuranceLossTheft, policeReportID)
+		if err != nil {
+			return err
+		}
+		event.Attributes = map[string]interface{}{
+			"insurerId":        insurerID,
+			"insuranceClaimId": claimID,
+		}
+	}
+	return emitEvent(ctx, event)
 }
 
 // VerifyAuthenticity allows anyone to verify if a product is authentic
 func (o *OwnershipContract) VerifyAuthenticity(ctx contractapi.TransactionContextInterface,
 	productID string) (map[string]interface{}, error) {
 
+	if err := validateID("productID", productID); err != nil {
+		return nil, err
+	}
+
 	// Get product
-	productJSON, err := ctx.GetStub().GetState(productID)
+	productJSON, err := getProductState(ctx, productID)
 	if err != nil {
 		return nil, err
 	}
@@ -423,6 +538,19 @@ func (o *OwnershipContract) VerifyAuthenticity(ctx contractapi.TransactionContex
 	var certificate DigitalBirthCertificate
 	json.Unmarshal(certJSON, &certificate)
 
+	// A revoked certificate no longer vouches for the product
+	if certificate.Revocation != nil {
+		return map[string]interface{}{
+			"authentic":        false,
+			"revoked":          true,
+			"revocationReason": certificate.Revocation.ReasonCode,
+			"revokedAt":        certificate.Revocation.RevokedAt,
+			"productId":        productID,
+			"brand":            product.Brand,
+			"status":           product.Status,
+		}, nil
+	}
+
 	// Check if stolen
 	if product.Status == ProductStatusStolen {
 		return map[string]interface{}{
@@ -435,12 +563,33 @@ func (o *OwnershipContract) VerifyAuthenticity(ctx contractapi.TransactionContex
 	// Return verification result
 	result := map[string]interface{}{
 		"authentic":         true,
+		"revoked":           false,
 		"productId":         productID,
 		"brand":             product.Brand,
 		"status":           product.Status,
 		"hasOwner":         product.OwnershipHash != "",
 		"manufacturingDate": certificate.ManufacturingDate,
 		"certificateHash":  certificate.CertificateHash,
+		"chipKeyRegistered": len(certificate.Authenticity.NFCChipPublicKeys) > 0,
+		"securityFeatures":  certificate.Authenticity.SecurityFeatures, // Including retrofitted ones
+	}
+	if len(product.ProvenanceNotes) > 0 {
+		result["provenanceNotes"] = product.ProvenanceNotes
+	}
+	result["certifiedPreOwned"] = product.CertifiedPreOwned
+	if ownership, err := o.GetOwnership(ctx, productID); err == nil && ownership.Warranty != nil {
+		now, err := txTime(ctx)
+		if err != nil {
+			return nil, err
+		}
+		result["warrantyValid"] = ownership.Warranty.valid(now)
+		result["warrantyEndDate"] = ownership.Warranty.EndDate
+	}
+	if product.CertifiedPreOwned && len(certificate.ResaleCertifications) > 0 {
+		latest := certificate.ResaleCertifications[len(certificate.ResaleCertifications)-1]
+		result["certificateRevision"] = latest.Revision
+		result["cpoCertifiedBy"] = latest.CertifiedBy
+		result["cpoCertifiedAt"] = latest.CertifiedAt
 	}
 
 	return result, nil
@@ -451,6 +600,17 @@ func (o *OwnershipContract) AddServiceRecord(ctx contractapi.TransactionContextI
 	productID string, serviceID string, serviceCenter string, serviceType string,
 	description string, technician string, warranty bool) error {
 
+	if err := validateAll(
+		validateID("productID", productID),
+		validateID("serviceID", serviceID),
+		validateName("serviceCenter", serviceCenter),
+		validateName("serviceType", serviceType),
+		validateText("description", description, maxTextLength),
+		validateText("technician", technician, maxNameLength),
+	); err != nil {
+		return err
+	}
+
 	// Get ownership
 	ownership, err := o.GetOwnership(ctx, productID)
 	if err != nil {
@@ -467,7 +627,7 @@ func (o *OwnershipContract) AddServiceRecord(ctx contractapi.TransactionContextI
 	roleContract := &RoleManagementContract{}
 	hasPermission, err := roleContract.CheckPermission(ctx, caller, "ADD_SERVICE_RECORD")
 	if err != nil || !hasPermission {
-		return fmt.Errorf("caller %s does not have permission to add service records", caller)
+		return newError(ErrPermissionDenied, "caller %s does not have permission to add service records", caller)
 	}
 	
 	record := ServiceRecord{
@@ -483,26 +643,55 @@ func (o *OwnershipContract) AddServiceRecord(ctx contractapi.TransactionContextI
 	ownership.ServiceHistory = append(ownership.ServiceHistory, record)
 
 	// Update ownership
-	ownershipJSON, err := json.Marshal(ownership)
+	err = putOwnership(ctx, ownership)
 	if err != nil {
 		return err
 	}
 
-	ownershipKey := "ownership_" + productID
-	return ctx.GetStub().PutState(ownershipKey, ownershipJSON)
+	event := ChaincodeEvent{
+		EventType:  EventServiceRecordAdded,
+		EntityType: EventEntityOwnership,
+		EntityID:   productID,
+		Attributes: map[string]interface{}{
+			"serviceId":   serviceID,
+			"serviceType": serviceType,
+			"warranty":    warranty,
+		},
+	}
+
+	// An authentication answers the request an ownership transfer raised
+	if serviceType == authenticationServiceType {
+		supplyChain := &SupplyChainContract{}
+		product, err := supplyChain.GetProduct(ctx, productID)
+		if err != nil {
+			return err
+		}
+		if product.AuthenticationRequest != nil {
+			product.AuthenticationRequest = nil
+			if err := putProduct(ctx, product); err != nil {
+				return err
+			}
+			event.Attributes["authenticationRequestCleared"] = true
+		}
+	}
+	return emitEvent(ctx, event)
 }
 
 // GetOwnership retrieves ownership information
 func (o *OwnershipContract) GetOwnership(ctx contractapi.TransactionContextInterface,
 	productID string) (*Ownership, error) {
 
+	if err := validateID("productID", productID); err != nil {
+		return nil, err
+	}
+
 	ownershipKey := "ownership_" + productID
 	ownershipJSON, err := ctx.GetStub().GetState(ownershipKey)
 	if err != nil {
 		return nil, err
 	}
 	if ownershipJSON == nil {
-		return nil, fmt.Errorf("ownership record not found for product %s", productID)
+		return nil, newError(ErrNotFound, "ownership record not found for product %s", productID)
 	}
 
 	var ownership Ownership
@@ -510,6 +699,7 @@ func (o *OwnershipContract) GetOwnership(ctx contractapi.TransactionContextInter
 	if err != nil {
 		return nil, err
 	}
+	ownership.upgradeSchema()
 
 	return &ownership, nil
 }
@@ -518,13 +708,17 @@ func (o *OwnershipContract) GetOwnership(ctx contractapi.TransactionContextInter
 func (o *OwnershipContract) GetBirthCertificate(ctx contractapi.TransactionContextInterface,
 	productID string) (*DigitalBirthCertificate, error) {
 
+	if err := validateID("productID", productID); err != nil {
+		return nil, err
+	}
+
 	certKey := "cert_" + productID
 	certJSON, err := ctx.GetStub().GetState(certKey)
 	if err != nil {
 		return nil, err
 	}
 	if certJSON == nil {
-		return nil, fmt.Errorf("birth certificate not found for product %s", productID)
+		return nil, newError(ErrNotFound, "birth certificate not found for product %s", productID)
 	}
 
 	var certificate DigitalBirthCertificate
@@ -532,6 +726,7 @@ func (o *OwnershipContract) GetBirthCertificate(ctx contractapi.TransactionConte
 	if err != nil {
 		return nil, err
 	}
+	certificate.upgradeSchema()
 
 	// Ensure Materials is never nil (empty array instead)
 	if certificate.Materials == nil {
@@ -541,11 +736,95 @@ func (o *OwnershipContract) GetBirthCertificate(ctx contractapi.TransactionConte
 	return &certificate, nil
 }
 
+// Default and maximum number of certificates per GetCertificatesByBatch page
+const (
+	defaultCertificatePageSize = 100
+	maxCertificatePageSize     = 500
+)
+
+// BatchCertificatePage is one page of the birth certificates of a batch's products
+type BatchCertificatePage struct {
+	BatchID      string                     `json:"batchId"`
+	Total        int                        `json:"total"` // Products in the batch
+	Certificates []*DigitalBirthCertificate `json:"certificates"`
+	Missing      []string                   `json:"missing"`  // Products of the page without a certificate
+	Bookmark     string                     `json:"bookmark"` // Pass to the next call, empty on the last page
+}
+
+// GetCertificatesByBatch returns the birth certificates of a batch's products in batch
+// order, e.g. for intake verification. Start with an empty bookmark and pass each
+// page's bookmark to the next call.
+func (o *OwnershipContract) GetCertificatesByBatch(ctx contractapi.TransactionContextInterface,
+	batchID string, pageSize int, bookmark string) (*BatchCertificatePage, error) {
+
+	supplyChain := &SupplyChainContract{}
+	batch, err := supplyChain.GetBatch(ctx, batchID)
+	if err != nil {
+		return nil, err
+	}
+	if pageSize <= 0 {
+		pageSize = defaultCertificatePageSize
+	}
+	if pageSize > maxCertificatePageSize {
+		pageSize = maxCertificatePageSize
+	}
+
+	// The bookmark is the first product of the next page
+	start := 0
+	if bookmark != "" {
+		start = -1
+		for i, productID := range batch.ProductIDs {
+			if productID == bookmark {
+				start = i
+				break
+			}
+		}
+		if start < 0 {
+			return nil, newError(ErrInvalidArgument, "invalid bookmark")
+		}
+	}
+	end := start + pageSize
+	if end > len(batch.ProductIDs) {
+		end = len(batch.ProductIDs)
+	}
+
+	page := &BatchCertificatePage{
+		BatchID:      batchID,
+		Total:        len(batch.ProductIDs),
+		Certificates: []*DigitalBirthCertificate{},
+		Missing:      []string{},
+	}
+	for _, productID := range batch.ProductIDs[start:end] {
+		certificate, err := o.GetBirthCertificate(ctx, productID)
+		if err != nil {
+			if hasErrorCode(err, ErrNotFound) {
+				page.Missing = append(page.Missing, productID)
+				continue
+			}
+			return nil, err
+		}
+		page.Certificates = append(page.Certificates, certificate)
+	}
+	if end < len(batch.ProductIDs) {
+		page.Bookmark = batch.ProductIDs[end]
+	}
+
+	return page, nil
+}
+
 // GetOwnerSpecificInfo returns detailed info only for the authenticated owner
 // Called by backend after verifying customer identity off-chain
+// Every call is recorded in the owner data access log with its purpose code
 func (o *OwnershipContract) GetOwnerSpecificInfo(ctx contractapi.TransactionContextInterface,
-	productID string, ownerHash string) (map[string]interface{}, error) {
+	productID string, ownerHash string, purposeCode string) (map[string]interface{}, error) {
 	
+	if err := validateAll(
+		validateID("productID", productID),
+		validateID("ownerHash", ownerHash),
+	); err != nil {
+		return nil, err
+	}
+
 	// Get ownership record
 	ownership, err := o.GetOwnership(ctx, productID)
 	if err != nil {
@@ -554,11 +833,17 @@ func (o *OwnershipContract) GetOwnerSpecificInfo(ctx contractapi.TransactionCont
 	
 	// Verify ownership hash matches
 	if ownership.OwnerHash != ownerHash {
-		return nil, fmt.Errorf("ownership verification failed")
+		return nil, newError(ErrPermissionDenied, "ownership verification failed")
+	}
+	
+	// Log access for transparency reporting
+	err = recordOwnerDataAccess(ctx, productID, "GetOwnerSpecificInfo", purposeCode)
+	if err != nil {
+		return nil, err
 	}
 	
 	// Get full product details
-	productJSON, err := ctx.GetStub().GetState(productID)
+	productJSON, err := getProductState(ctx, productID)
 	if err != nil {
 		return nil, err
 	}
@@ -629,34 +914,37 @@ func (o *OwnershipContract) generateRandomCode(length int) string {
 
 // ============= MISSING OWNERSHIP FUNCTIONS =============
 
-// GetProductsByOwner retrieves all products owned by a specific owner hash
+// GetProductsByOwner retrieves all products owned by a specific owner hash, read from
+// the owner index
 func (o *OwnershipContract) GetProductsByOwner(ctx contractapi.TransactionContextInterface,
 	ownerHash string) ([]*Product, error) {
 	
-	// Query all ownership records
-	resultsIterator, err := ctx.GetStub().GetStateByRange("ownership_", "ownership_~")
+	if err := validateID("ownerHash", ownerHash); err != nil {
+		return nil, err
+	}
+
+	productIDs, err := getIndexedIDs(ctx, ownershipOwnerIndex, ownerHash)
 	if err != nil {
-		return nil, fmt.Errorf("failed to query ownership records: %v", err)
+		return nil, err
 	}
-	defer resultsIterator.Close()
 	
 	var ownedProducts []*Product
-	for resultsIterator.HasNext() {
-		queryResponse, err := resultsIterator.Next()
-		if err != nil {
-			return nil, err
+	for _, productID := range productIDs {
+		ownershipJSON, err := ctx.GetStub().GetState("ownership_" + productID)
+		if err != nil || ownershipJSON == nil {
+			continue
 		}
 		
 		var ownership Ownership
-		err = json.Unmarshal(queryResponse.Value, &ownership)
+		err = json.Unmarshal(ownershipJSON, &ownership)
 		if err != nil {
 			continue
 		}
 		
-		// Check if this ownership matches the owner hash
-		if ownership.OwnerHash == ownerHash && ownership.Status == OwnershipStatusActive {
+		// Only active ownerships count, not products being transferred
+		if ownership.Status == OwnershipStatusActive {
 			// Get the product
-			productJSON, err := ctx.GetStub().GetState(ownership.ProductID)
+			productJSON, err := getProductState(ctx, ownership.ProductID)
 			if err != nil || productJSON == nil {
 				continue
 			}
@@ -682,7 +970,7 @@ func (o *OwnershipContract) GetProductsByOwner(ctx contractapi.TransactionContex
 // GetStolenProducts retrieves all products marked as stolen
 func (o *OwnershipContract) GetStolenProducts(ctx contractapi.TransactionContextInterface) ([]*Product, error) {
 	// Query all products
-	resultsIterator, err := ctx.GetStub().GetStateByRange("", "")
+	resultsIterator, err := ctx.GetStub().GetStateByRange(productKeyPrefix, productKeyPrefix+"~")
 	if err != nil {
 		return nil, fmt.Errorf("failed to query products: %v", err)
 	}
@@ -695,23 +983,12 @@ func (o *OwnershipContract) GetStolenProducts(ctx contractapi.TransactionContext
 			return nil, err
 		}
 		
-		// Skip non-product entries
-		key := queryResponse.Key
-		if strings.HasPrefix(key, "transfer_") || strings.HasPrefix(key, "batch_") || 
-		   strings.HasPrefix(key, "ownership_") || strings.HasPrefix(key, "cert_") ||
-		   strings.HasPrefix(key, "material_") || strings.HasPrefix(key, "org_") {
-			continue
-		}
-		
 		var product Product
 		err = json.Unmarshal(queryResponse.Value, &product)
 		if err != nil {
 			continue
 		}
-		// Ensure Materials is never nil
-		if product.Materials == nil {
-			product.Materials = []Material{}
-		}
+		product.upgradeSchema()
 		
 		// Check if product is stolen
 		if product.IsStolen || product.Status == ProductStatusStolen {
@@ -730,10 +1007,11 @@ type OwnershipHistoryRecord struct {
 	TotalOwners    int             `json:"totalOwners"`
 	ProductStatus  string          `json:"productStatus"`
 	IsStolen       bool            `json:"isStolen"`
-	StolenDate     string          `json:"stolenDate,omitempty"`
-	RecoveredDate  string          `json:"recoveredDate,omitempty"`
+	StolenDate     string          `json:"stolenDate,omitempty" metadata:",optional"`
+	RecoveredDate  string          `json:"recoveredDate,omitempty" metadata:",optional"`
 	ServiceHistory []ServiceRecord `json:"serviceHistory"`
 	TotalServices  int             `json:"totalServices"`
+	Donation       *Donation       `json:"donation,omitempty" metadata:",optional"` // Set when the product was donated to a charity
 }
 
 // CurrentOwnerInfo represents current owner information
@@ -745,22 +1023,33 @@ type CurrentOwnerInfo struct {
 }
 
 // GetOwnershipHistory retrieves the complete ownership history for a product
+// Every call is recorded in the owner data access log with its purpose code
 func (o *OwnershipContract) GetOwnershipHistory(ctx contractapi.TransactionContextInterface,
-	productID string) (*OwnershipHistoryRecord, error) {
+	productID string, purposeCode string) (*OwnershipHistoryRecord, error) {
 	
+	if err := validateID("productID", productID); err != nil {
+		return nil, err
+	}
+
 	// Get current ownership
 	ownership, err := o.GetOwnership(ctx, productID)
 	if err != nil {
 		return nil, err
 	}
 	
+	// Log access for transparency reporting
+	err = recordOwnerDataAccess(ctx, productID, "GetOwnershipHistory", purposeCode)
+	if err != nil {
+		return nil, err
+	}
+	
 	// Get product details
-	productJSON, err := ctx.GetStub().GetState(productID)
+	productJSON, err := getProductState(ctx, productID)
 	if err != nil {
 		return nil, err
 	}
 	if productJSON == nil {
-		return nil, fmt.Errorf("product %s not found", productID)
+		return nil, newError(ErrNotFound, "product %s not found", productID)
 	}
 	
 	var product Product
@@ -797,6 +1086,7 @@ func (o *OwnershipContract) GetOwnershipHistory(ctx contractapi.TransactionConte
 	if product.RecoveredDate != "" {
 		history.RecoveredDate = product.RecoveredDate
 	}
+	history.Donation = product.Donation
 	
 	return history, nil
 }
@@ -839,7 +1129,7 @@ func (o *OwnershipContract) GetProductsWithOwnership(ctx contractapi.Transaction
 		}
 		
 		// Get product details
-		productJSON, err := ctx.GetStub().GetState(ownership.ProductID)
+		productJSON, err := getProductState(ctx, ownership.ProductID)
 		if err != nil || productJSON == nil {
 			continue
 		}
@@ -0,0 +1,440 @@
+package contracts
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Customer ownership transfer types, recorded in PreviousOwner.TransferType
+const (
+	OwnershipTransferSale        = "sale"   // Private sale between customers
+	OwnershipTransferResale      = "resale" // Through a reseller or marketplace
+	OwnershipTransferGift        = "gift"
+	OwnershipTransferInheritance = "inheritance"
+)
+
+var ownershipTransferTypes = []string{
+	OwnershipTransferSale, OwnershipTransferResale, OwnershipTransferGift, OwnershipTransferInheritance,
+}
+
+// Ownership transfer rules are stored per brand as customer_transfer_rules_<brand>
+const ownershipTransferRulesKeyPrefix = "customer_transfer_rules_"
+
+// OwnershipTransferRule is what a brand requires of one type of ownership transfer
+type OwnershipTransferRule struct {
+	RequiresDocumentHash  bool `json:"requiresDocumentHash"`  // e.g. a notarized will or deed of gift
+	RequestAuthentication bool `json:"requestAuthentication"` // Flags the product for the authentication service
+}
+
+// OwnershipTransferRules are a brand's rules by transfer type. Types without a rule
+// need nothing beyond the transfer code.
+type OwnershipTransferRules struct {
+	Brand         string                           `json:"brand"`
+	Rules         map[string]OwnershipTransferRule `json:"rules"`
+	UpdatedBy     string                           `json:"updatedBy,omitempty" metadata:",optional"`
+	UpdatedAt     string                           `json:"updatedAt,omitempty" metadata:",optional"`
+	SchemaVersion int                              `json:"schemaVersion"`
+}
+
+// authenticationServiceType is the AddServiceRecord type that clears an AuthenticationRequest
+const authenticationServiceType = "authentication"
+
+// AuthenticationRequest flags a product for the authentication service after an
+// ownership transfer whose rule asks for it. An authentication service record clears it.
+type AuthenticationRequest struct {
+	TransferType string `json:"transferType"`
+	RequestedAt  string `json:"requestedAt"`
+}
+
+// defaultOwnershipTransferRules apply to a brand until it sets its own: inheritances
+// need a notarized document and resales are authenticated
+func defaultOwnershipTransferRules(brand string) *OwnershipTransferRules {
+	return &OwnershipTransferRules{
+		Brand: brand,
+		Rules: map[string]OwnershipTransferRule{
+			OwnershipTransferInheritance: {RequiresDocumentHash: true},
+			OwnershipTransferResale:      {RequestAuthentication: true},
+		},
+		SchemaVersion: CurrentSchemaVersion,
+	}
+}
+
+// getOwnershipTransferRules reads a brand's ownership transfer rules, falling back to the defaults
+func getOwnershipTransferRules(ctx contractapi.TransactionContextInterface, brand string) (*OwnershipTransferRules, error) {
+	rulesJSON, err := ctx.GetStub().GetState(ownershipTransferRulesKeyPrefix + brand)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ownership transfer rules: %v", err)
+	}
+	if rulesJSON == nil {
+		return defaultOwnershipTransferRules(brand), nil
+	}
+
+	var rules OwnershipTransferRules
+	err = json.Unmarshal(rulesJSON, &rules)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse ownership transfer rules: %v", err)
+	}
+	rules.upgradeSchema()
+
+	return &rules, nil
+}
+
+// codeTransferType returns the transfer type of an ownership's transfer code. Codes
+// generated before transfer types existed are sales.
+func codeTransferType(ownership *Ownership) string {
+	if ownership.TransferCodeType == "" {
+		return OwnershipTransferSale
+	}
+	return ownership.TransferCodeType
+}
+
+// checkCodeTransferType rejects a transfer code presented for another transfer type
+// than the one it was generated for, so the new owner confirms what they receive
+func checkCodeTransferType(ownership *Ownership, transferType string) error {
+	if codeType := codeTransferType(ownership); transferType != codeType {
+		return newError(ErrInvalidArgument, "the transfer code is for transfer type %s, not %s", codeType, transferType)
+	}
+	return nil
+}
+
+// ownershipFinalizeWindow is how long the previous owner has to finalize or cancel a
+// redeemed transfer code before the new owner may finalize it alone
+const ownershipFinalizeWindow = 72 * time.Hour
+
+// PendingOwnershipTransfer is a transfer code redeemed by a new owner and not yet
+// finalized, see RedeemTransferCode
+type PendingOwnershipTransfer struct {
+	NewOwnerHash    string `json:"newOwnerHash"`
+	NewSecurityHash string `json:"newSecurityHash"`
+	RedeemedAt      string `json:"redeemedAt"`
+	FinalizableAt   string `json:"finalizableAt"` // From then on the new owner may finalize
+}
+
+// changeOwner records the current owner as a previous owner, with the transfer type and
+// document of the transfer code, and makes the new owner current. It applies the brand's
+// rule for the transfer type to the product and returns the OwnershipTransferred event.
+func changeOwner(ctx contractapi.TransactionContextInterface, ownership *Ownership,
+	newOwnerHash string, newSecurityHash string, transferDate string) (ChaincodeEvent, error) {
+
+	transferType := codeTransferType(ownership)
+	ownership.PreviousOwners = append(ownership.PreviousOwners, PreviousOwner{
+		OwnerHash:     ownership.OwnerHash,
+		OwnershipDate: ownership.OwnershipDate,
+		TransferDate:  transferDate,
+		TransferType:  transferType,
+		DocumentHash:  ownership.TransferDocumentHash,
+	})
+	ownership.OwnerHash = newOwnerHash
+	ownership.SecurityHash = newSecurityHash
+	ownership.OwnershipDate = transferDate
+	ownership.TransferCode = ""
+	ownership.TransferExpiry = ""
+	ownership.TransferCodeType = ""
+	ownership.TransferDocumentHash = ""
+	ownership.TransferTwoPhase = false
+	ownership.PendingTransfer = nil
+	ownership.Status = OwnershipStatusActive
+
+	err := putOwnership(ctx, ownership)
+	if err != nil {
+		return ChaincodeEvent{}, err
+	}
+
+	productJSON, err := getProductState(ctx, ownership.ProductID)
+	if err != nil {
+		return ChaincodeEvent{}, err
+	}
+	var product Product
+	if err := json.Unmarshal(productJSON, &product); err != nil {
+		return ChaincodeEvent{}, err
+	}
+	if product.Materials == nil {
+		product.Materials = []Material{}
+	}
+	product.OwnershipHash = newOwnerHash
+	provenanceNoted := addDonationNote(&product, transferDate)
+
+	rules, err := getOwnershipTransferRules(ctx, product.Brand)
+	if err != nil {
+		return ChaincodeEvent{}, err
+	}
+	authenticationRequested := rules.Rules[transferType].RequestAuthentication
+	if authenticationRequested {
+		product.AuthenticationRequest = &AuthenticationRequest{
+			TransferType: transferType,
+			RequestedAt:  transferDate,
+		}
+	}
+	if err := putProduct(ctx, &product); err != nil {
+		return ChaincodeEvent{}, err
+	}
+
+	// Owner hashes stay off the event stream
+	event := ChaincodeEvent{
+		EventType:  EventOwnershipTransferred,
+		EntityType: EventEntityOwnership,
+		EntityID:   ownership.ProductID,
+		ToState:    string(ownership.Status),
+		Attributes: map[string]interface{}{
+			"previousOwners": len(ownership.PreviousOwners),
+			"transferType":   transferType,
+		},
+	}
+	if provenanceNoted {
+		event.Attributes["provenanceNote"] = provenanceNoteDonation
+	}
+	if authenticationRequested {
+		event.Attributes["authenticationRequested"] = true
+	}
+	return event, nil
+}
+
+// RedeemTransferCode is the first phase of a two-phase ownership transfer. The new
+// owner presents the code like in TransferOwnership, but ownership only passes once the
+// previous owner calls FinalizeOwnershipTransfer, or once ownershipFinalizeWindow has
+// passed without the previous owner cancelling. A code stolen in transit can so be
+// caught before the product changes hands. transferType must be the code's. It accepts
+// any code; one generated as twoPhase is accepted only here.
+func (o *OwnershipContract) RedeemTransferCode(ctx contractapi.TransactionContextInterface,
+	productID string, transferCode string, newOwnerHash string, newSecurityHash string, transferType string) error {
+
+	if err := validateAll(
+		validateID("productID", productID),
+		validateID("transferCode", transferCode),
+		validateID("newOwnerHash", newOwnerHash),
+		validateID("newSecurityHash", newSecurityHash),
+		validateEnum("transferType", transferType, ownershipTransferTypes...),
+	); err != nil {
+		return err
+	}
+
+	ownership, err := o.GetOwnership(ctx, productID)
+	if err != nil {
+		return err
+	}
+	if ownership.Status != OwnershipStatusTransferring || ownership.PendingTransfer != nil {
+		return newError(ErrInvalidState, "product %s has no transfer code to redeem", productID)
+	}
+	if ownership.TransferCode != transferCode {
+		return newError(ErrInvalidArgument, "invalid transfer code")
+	}
+	if err := checkCodeTransferType(ownership, transferType); err != nil {
+		return err
+	}
+	if newOwnerHash == ownership.OwnerHash {
+		return newError(ErrInvalidArgument, "the new owner is the current owner")
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	expiry, err := time.Parse(time.RFC3339, ownership.TransferExpiry)
+	if err != nil || !now.Before(expiry) {
+		return newError(ErrInvalidState, "transfer code has expired")
+	}
+
+	// The code is used up, so it cannot be redeemed a second time or with TransferOwnership.
+	// Its transfer type and document stay for the finalization.
+	ownership.TransferCode = ""
+	ownership.TransferExpiry = ""
+	ownership.PendingTransfer = &PendingOwnershipTransfer{
+		NewOwnerHash:    newOwnerHash,
+		NewSecurityHash: newSecurityHash,
+		RedeemedAt:      now.UTC().Format(time.RFC3339),
+		FinalizableAt:   now.Add(ownershipFinalizeWindow).UTC().Format(time.RFC3339),
+	}
+	err = putOwnership(ctx, ownership)
+	if err != nil {
+		return err
+	}
+
+	// Owner hashes stay off the event stream
+	return emitEvent(ctx, ChaincodeEvent{
+		EventType:  EventTransferCodeRedeemed,
+		EntityType: EventEntityOwnership,
+		EntityID:   productID,
+		ToState:    string(ownership.Status),
+		Attributes: map[string]interface{}{
+			"finalizableAt": ownership.PendingTransfer.FinalizableAt,
+			"transferType":  transferType,
+		},
+	})
+}
+
+// FinalizeOwnershipTransfer completes a redeemed transfer code. The previous owner
+// may finalize it at once with their owner and security hashes; after the pending
+// transfer's finalizableAt, the new owner may finalize it with theirs.
+func (o *OwnershipContract) FinalizeOwnershipTransfer(ctx contractapi.TransactionContextInterface,
+	productID string, ownerHash string, securityHash string) error {
+
+	if err := validateAll(
+		validateID("productID", productID),
+		validateID("ownerHash", ownerHash),
+		validateID("securityHash", securityHash),
+	); err != nil {
+		return err
+	}
+
+	ownership, err := o.GetOwnership(ctx, productID)
+	if err != nil {
+		return err
+	}
+	pending := ownership.PendingTransfer
+	if pending == nil || ownership.Status != OwnershipStatusTransferring {
+		return newError(ErrInvalidState, "product %s has no redeemed transfer to finalize", productID)
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	finalizedBy := "PREVIOUS_OWNER"
+	switch {
+	case ownerHash == ownership.OwnerHash && securityHash == ownership.SecurityHash:
+	case ownerHash == pending.NewOwnerHash && securityHash == pending.NewSecurityHash:
+		finalizableAt, err := time.Parse(time.RFC3339, pending.FinalizableAt)
+		if err != nil {
+			return err
+		}
+		if now.Before(finalizableAt) {
+			return newError(ErrInvalidState, "the new owner can finalize the transfer from %s", pending.FinalizableAt)
+		}
+		finalizedBy = "TIMEOUT"
+	default:
+		return newError(ErrPermissionDenied, "ownership verification failed")
+	}
+
+	event, err := changeOwner(ctx, ownership, pending.NewOwnerHash, pending.NewSecurityHash,
+		now.UTC().Format(time.RFC3339))
+	if err != nil {
+		return err
+	}
+	event.Attributes["finalizedBy"] = finalizedBy
+	return emitEvent(ctx, event)
+}
+
+// CancelOwnershipTransfer lets the current owner withdraw a transfer code, redeemed or
+// not, e.g. when the new owner is not the buyer it was given to. A redeemed transfer can
+// be cancelled until it is finalized.
+func (o *OwnershipContract) CancelOwnershipTransfer(ctx contractapi.TransactionContextInterface,
+	productID string, ownerHash string, securityHash string) error {
+
+	if err := validateAll(
+		validateID("productID", productID),
+		validateID("ownerHash", ownerHash),
+		validateID("securityHash", securityHash),
+	); err != nil {
+		return err
+	}
+
+	ownership, err := o.GetOwnership(ctx, productID)
+	if err != nil {
+		return err
+	}
+	if ownership.OwnerHash != ownerHash || ownership.SecurityHash != securityHash {
+		return newError(ErrPermissionDenied, "ownership verification failed")
+	}
+	if ownership.Status != OwnershipStatusTransferring {
+		return newError(ErrInvalidState, "product %s has no ownership transfer in progress", productID)
+	}
+
+	redeemed := ownership.PendingTransfer != nil
+	ownership.TransferCode = ""
+	ownership.TransferExpiry = ""
+	ownership.TransferCodeType = ""
+	ownership.TransferDocumentHash = ""
+	ownership.TransferTwoPhase = false
+	ownership.PendingTransfer = nil
+	ownership.Status = OwnershipStatusActive
+	err = putOwnership(ctx, ownership)
+	if err != nil {
+		return err
+	}
+
+	return emitEvent(ctx, ChaincodeEvent{
+		EventType:  EventOwnershipTransferCancelled,
+		EntityType: EventEntityOwnership,
+		EntityID:   productID,
+		FromState:  string(OwnershipStatusTransferring),
+		ToState:    string(ownership.Status),
+		Attributes: map[string]interface{}{
+			"redeemed": redeemed,
+		},
+	})
+}
+
+// SetOwnershipTransferRules replaces a brand's rules for customer ownership transfers,
+// e.g. {"inheritance":{"requiresDocumentHash":true},"resale":{"requestAuthentication":true}}.
+// Only the brand (super admin) may set them.
+func (o *OwnershipContract) SetOwnershipTransferRules(ctx contractapi.TransactionContextInterface,
+	brand string, rulesJSON string) error {
+
+	var rules map[string]OwnershipTransferRule
+	if err := validateAll(
+		validateName("brand", brand),
+		validateJSON("rulesJSON", rulesJSON, &rules),
+	); err != nil {
+		return err
+	}
+	for transferType := range rules {
+		if err := validateEnum("transfer type", transferType, ownershipTransferTypes...); err != nil {
+			return err
+		}
+	}
+
+	caller, err := requireSuperAdmin(ctx)
+	if err != nil {
+		return err
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	stored := &OwnershipTransferRules{
+		Brand:         brand,
+		Rules:         rules,
+		UpdatedBy:     caller,
+		UpdatedAt:     now.UTC().Format(time.RFC3339),
+		SchemaVersion: CurrentSchemaVersion,
+	}
+	storedJSON, err := json.Marshal(stored)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState(ownershipTransferRulesKeyPrefix+brand, storedJSON)
+	if err != nil {
+		return fmt.Errorf("failed to store ownership transfer rules: %v", err)
+	}
+
+	transferTypes := make([]string, 0, len(rules))
+	for transferType := range rules {
+		transferTypes = append(transferTypes, transferType)
+	}
+	sort.Strings(transferTypes)
+
+	return emitEvent(ctx, ChaincodeEvent{
+		EventType:  EventOwnershipRulesUpdated,
+		EntityType: EventEntityConfig,
+		EntityID:   ownershipTransferRulesKeyPrefix + brand,
+		Attributes: map[string]interface{}{
+			"transferTypes": strings.Join(transferTypes, ","),
+		},
+	})
+}
+
+// GetOwnershipTransferRules returns the ownership transfer rules in effect for a brand
+func (o *OwnershipContract) GetOwnershipTransferRules(ctx contractapi.TransactionContextInterface,
+	brand string) (*OwnershipTransferRules, error) {
+
+	if err := validateName("brand", brand); err != nil {
+		return nil, err
+	}
+	return getOwnershipTransferRules(ctx, brand)
+}
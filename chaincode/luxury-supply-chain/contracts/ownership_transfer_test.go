@@ -0,0 +1,56 @@
+package contracts
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// newOwnedProduct stores product P1, sold to ownerA with security hash secA
+func newOwnedProduct(t *testing.T) *testLedger {
+	ledger := newTestLedger(t)
+	ledger.put(productKey("P1"), Product{
+		ID: "P1", Brand: "LuxeBags", Type: "Handbag", CurrentOwner: "LuxuryRetailMSP",
+		Status: ProductStatusSold, Materials: []Material{}, OwnershipHash: "ownerA",
+		SchemaVersion: CurrentSchemaVersion,
+	})
+	ledger.put("ownership_P1", Ownership{
+		ProductID: "P1", OwnerHash: "ownerA", SecurityHash: "secA", Status: OwnershipStatusActive,
+		ServiceHistory: []ServiceRecord{}, PreviousOwners: []PreviousOwner{}, SchemaVersion: CurrentSchemaVersion,
+	})
+	return ledger
+}
+
+func TestTwoPhaseCodeIsRejectedByTransferOwnership(t *testing.T) {
+	ledger := newOwnedProduct(t)
+	contract := &OwnershipContract{}
+
+	code, err := contract.GenerateTransferCode(ledger.as("LuxuryRetailMSP"), "P1", "ownerA", "secA", "sale", "", true)
+	require.NoError(t, err)
+
+	err = contract.TransferOwnership(ledger.as("LuxuryRetailMSP"), "P1", code, "ownerB", "secB", "sale")
+	require.True(t, hasErrorCode(err, ErrInvalidState), "got %v", err)
+	var ownership Ownership
+	ledger.get("ownership_P1", &ownership)
+	require.Equal(t, "ownerA", ownership.OwnerHash)
+
+	require.NoError(t, contract.RedeemTransferCode(ledger.as("LuxuryRetailMSP"), "P1", code, "ownerB", "secB", "sale"))
+	require.NoError(t, contract.FinalizeOwnershipTransfer(ledger.as("LuxuryRetailMSP"), "P1", "ownerA", "secA"))
+	var finalized Ownership
+	ledger.get("ownership_P1", &finalized)
+	require.Equal(t, "ownerB", finalized.OwnerHash)
+	require.False(t, finalized.TransferTwoPhase)
+}
+
+func TestSinglePhaseCodeIsAcceptedByTransferOwnership(t *testing.T) {
+	ledger := newOwnedProduct(t)
+	contract := &OwnershipContract{}
+
+	code, err := contract.GenerateTransferCode(ledger.as("LuxuryRetailMSP"), "P1", "ownerA", "secA", "sale", "", false)
+	require.NoError(t, err)
+	require.NoError(t, contract.TransferOwnership(ledger.as("LuxuryRetailMSP"), "P1", code, "ownerB", "secB", "sale"))
+
+	var ownership Ownership
+	ledger.get("ownership_P1", &ownership)
+	require.Equal(t, "ownerB", ownership.OwnerHash)
+}
@@ -0,0 +1,159 @@
+package contracts
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Default and maximum number of records per page of the GetAll functions
+const (
+	defaultPageSize = 100
+	maxPageSize     = 1000
+)
+
+// PaginatedResult is the paging state of a page of records. Fabric only serves
+// paginated range scans to read-only transactions, so the paged functions are
+// evaluated, not submitted.
+type PaginatedResult struct {
+	FetchedRecordsCount int    `json:"fetchedRecordsCount"`
+	Bookmark            string `json:"bookmark"` // Pass to the next call, empty on the last page
+}
+
+// ProductPage is one page of GetAllProducts
+type ProductPage struct {
+	Records []*Product `json:"records"`
+	PaginatedResult
+}
+
+// BatchPage is one page of GetAllBatches
+type BatchPage struct {
+	Records []*ProductBatch `json:"records"`
+	PaginatedResult
+}
+
+// MaterialInventoryPage is one page of GetAllMaterialInventories
+type MaterialInventoryPage struct {
+	Records []*MaterialInventory `json:"records"`
+	PaginatedResult
+}
+
+// scanPage reads one page of the keys under prefix, starting at bookmark, and passes
+// each value to add
+func scanPage(ctx contractapi.TransactionContextInterface, prefix string, pageSize int,
+	bookmark string, add func(value []byte) error) (*PaginatedResult, error) {
+
+	if pageSize <= 0 {
+		pageSize = defaultPageSize
+	}
+	if pageSize > maxPageSize {
+		pageSize = maxPageSize
+	}
+
+	resultsIterator, metadata, err := ctx.GetStub().GetStateByRangeWithPagination(prefix, prefix+"~", int32(pageSize), bookmark)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query %s range: %v", prefix, err)
+	}
+	defer resultsIterator.Close()
+
+	if err := addAll(resultsIterator, add); err != nil {
+		return nil, err
+	}
+
+	result := &PaginatedResult{}
+	if metadata != nil {
+		result.FetchedRecordsCount = int(metadata.FetchedRecordsCount)
+		// A short page is the last one
+		if result.FetchedRecordsCount == pageSize {
+			result.Bookmark = metadata.Bookmark
+		}
+	}
+	return result, nil
+}
+
+// addAll passes the value of every remaining entry of a range scan to add
+func addAll(resultsIterator shim.StateQueryIteratorInterface, add func(value []byte) error) error {
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return err
+		}
+		if err := add(queryResponse.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addProduct returns a scan callback that appends the products it reads to products,
+// skipping unreadable ones
+func addProduct(products *[]*Product) func(value []byte) error {
+	return func(value []byte) error {
+		var product Product
+		if err := json.Unmarshal(value, &product); err != nil {
+			return nil
+		}
+		product.upgradeSchema()
+		*products = append(*products, &product)
+		return nil
+	}
+}
+
+// addBatch returns a scan callback that appends the batches it reads to batches,
+// skipping unreadable ones
+func addBatch(batches *[]*ProductBatch) func(value []byte) error {
+	return func(value []byte) error {
+		var batch ProductBatch
+		if err := json.Unmarshal(value, &batch); err != nil {
+			return nil
+		}
+		batch.upgradeSchema()
+		*batches = append(*batches, &batch)
+		return nil
+	}
+}
+
+// addMaterialInventory returns a scan callback that appends the inventories it reads
+// to inventories
+func addMaterialInventory(inventories *[]*MaterialInventory) func(value []byte) error {
+	return func(value []byte) error {
+		var inventory MaterialInventory
+		if err := json.Unmarshal(value, &inventory); err != nil {
+			return err
+		}
+		inventory.upgradeSchema()
+		*inventories = append(*inventories, &inventory)
+		return nil
+	}
+}
+
+// scanAll reads every key under prefix, for submitted transactions, which cannot page
+func scanAll(ctx contractapi.TransactionContextInterface, prefix string, add func(value []byte) error) error {
+	resultsIterator, err := ctx.GetStub().GetStateByRange(prefix, prefix+"~")
+	if err != nil {
+		return fmt.Errorf("failed to query %s range: %v", prefix, err)
+	}
+	defer resultsIterator.Close()
+
+	return addAll(resultsIterator, add)
+}
+
+// allProducts reads every product
+func allProducts(ctx contractapi.TransactionContextInterface) ([]*Product, error) {
+	var products []*Product
+	if err := scanAll(ctx, productKeyPrefix, addProduct(&products)); err != nil {
+		return nil, err
+	}
+	return products, nil
+}
+
+// allMaterialInventories reads every material inventory
+func allMaterialInventories(ctx contractapi.TransactionContextInterface) ([]*MaterialInventory, error) {
+	var inventories []*MaterialInventory
+	if err := scanAll(ctx, "material_inventory_", addMaterialInventory(&inventories)); err != nil {
+		return nil, err
+	}
+	return inventories, nil
+}
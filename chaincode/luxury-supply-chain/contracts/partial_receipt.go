@@ -0,0 +1,215 @@
+package contracts
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// disputeQuantityMismatch is the consensus dispute reason of a partial receipt
+const disputeQuantityMismatch = "QUANTITY_MISMATCH"
+
+// ConfirmReceivedWithQuantity confirms receipt of part of a batch transfer, e.g. 80 of
+// 100 units, given the IDs of the products that arrived as a JSON array. The batch is
+// split: it keeps the received products, which change hands as with ConfirmReceived,
+// and the products that did not arrive move to a new batch, remainderBatchID, which
+// stays with the sender. The transfer becomes DISPUTED and a QUANTITY_MISMATCH dispute
+// for the missing quantity is raised in consensus.
+func (s *SupplyChainContract) ConfirmReceivedWithQuantity(ctx contractapi.TransactionContextInterface,
+	transferID string, receivedProductIDsJSON string, remainderBatchID string) error {
+
+	var receivedProductIDs []string
+	if err := validateAll(
+		validateID("transferID", transferID),
+		validateJSON("receivedProductIds", receivedProductIDsJSON, &receivedProductIDs),
+		validateID("remainderBatchID", remainderBatchID),
+	); err != nil {
+		return err
+	}
+	for i, productID := range receivedProductIDs {
+		if err := validateID("receivedProductIds", productID); err != nil {
+			return err
+		}
+		if containsString(receivedProductIDs[:i], productID) {
+			return newError(ErrInvalidArgument, "product %s is listed twice in receivedProductIds", productID)
+		}
+	}
+
+	transfer, err := s.GetTransfer(ctx, transferID)
+	if err != nil {
+		return err
+	}
+	receiver, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to get receiver identity: %v", err)
+	}
+	if transfer.To != receiver {
+		return newError(ErrPermissionDenied, "only the receiver can confirm receipt")
+	}
+	if batchType, _ := transfer.Metadata["type"].(string); batchType != "BATCH" {
+		return newError(ErrInvalidArgument, "transfer %s is not a batch transfer", transferID)
+	}
+	if !transfer.ConsensusDetails.SenderConfirmed {
+		return newError(ErrInvalidState, "sender must confirm sent before receiver can confirm receipt")
+	}
+	if transfer.TransferType == TransferTypeLogistics && !transfer.ConsensusDetails.CarrierConfirmed {
+		return newError(ErrInvalidState, "carrier must confirm delivery before receiver can confirm receipt")
+	}
+	if err := checkTransferEffective(ctx, transfer); err != nil {
+		return err
+	}
+	if err := s.checkTradeDocuments(ctx, transfer); err != nil {
+		return err
+	}
+	// Delivery versus payment settles the full amount, which a partial receipt cannot
+	if transfer.Payment != nil && transfer.Payment.Status == PaymentStatusPending {
+		return newError(ErrInvalidState, "transfer %s has payment terms and can only be received in full", transferID)
+	}
+
+	existing, err := ctx.GetStub().GetState("batch_" + remainderBatchID)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return newError(ErrAlreadyExists, "batch %s already exists", remainderBatchID)
+	}
+
+	batch, err := s.GetBatch(ctx, transfer.ProductID)
+	if err != nil {
+		return wrapError(err, "failed to get batch")
+	}
+	var shipped []*Product
+	for _, productID := range batch.ProductIDs {
+		product, err := s.GetProduct(ctx, productID)
+		if err != nil {
+			continue
+		}
+		if movesWithBatch(product, transfer.From) {
+			shipped = append(shipped, product)
+		}
+	}
+	quantityReceived := len(receivedProductIDs)
+	if quantityReceived < 1 || quantityReceived >= len(shipped) {
+		return newError(ErrInvalidArgument, "receivedProductIds must list between 1 and %d products, use ConfirmReceived for a full receipt", len(shipped)-1)
+	}
+	var missing []*Product
+	var missingIDs []string
+	for _, product := range shipped {
+		if !containsString(receivedProductIDs, product.ID) {
+			missing = append(missing, product)
+			missingIDs = append(missingIDs, product.ID)
+		}
+	}
+	if len(shipped)-len(missing) != quantityReceived {
+		for _, productID := range receivedProductIDs {
+			if !containsString(batch.ProductIDs, productID) {
+				return newError(ErrInvalidArgument, "product %s is not in batch %s", productID, batch.ID)
+			}
+		}
+		return newError(ErrInvalidArgument, "receivedProductIds lists products of batch %s that were not shipped, e.g. sold or on display", batch.ID)
+	}
+
+	previousStatus := transfer.Status
+	if err := setTransferStatus(transfer, TransferStatusDisputed); err != nil {
+		return err
+	}
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	timestamp := now.UTC().Format(time.RFC3339)
+
+	var keptIDs []string
+	for _, productID := range batch.ProductIDs {
+		if !containsString(missingIDs, productID) {
+			keptIDs = append(keptIDs, productID)
+		}
+	}
+
+	remainder := &ProductBatch{
+		SchemaVersion:   CurrentSchemaVersion,
+		ID:              remainderBatchID,
+		Manufacturer:    batch.Manufacturer,
+		Brand:           batch.Brand,
+		ProductType:     batch.ProductType,
+		Quantity:        len(missingIDs),
+		ProductIDs:      missingIDs,
+		MaterialsUsed:   mergedMaterialUsage(batch, missingIDs),
+		ManufactureDate: batch.ManufactureDate,
+		QRCode:          fmt.Sprintf("QR-%s-%d", remainderBatchID, now.Unix()),
+		CurrentOwner:    transfer.From,
+		CurrentLocation: batch.CurrentLocation,
+		Status:          BatchStatusInTransit,
+		Metadata: map[string]string{
+			"splitFrom":   batch.ID,
+			"transferId":  transferID,
+			"disputeType": disputeQuantityMismatch,
+			"splitAt":     timestamp,
+		},
+		BrandApprovedBy: batch.BrandApprovedBy,
+		BrandApprovedAt: batch.BrandApprovedAt,
+	}
+	if err := putBatch(ctx, remainder); err != nil {
+		return err
+	}
+	// The missing products belong to the remainder batch from now on, and are verified
+	// with its QR code
+	for _, product := range missing {
+		if product.UniqueIdentifier != "" {
+			err := ctx.GetStub().DelState(productIdentifierKey(product.BatchID, product.UniqueIdentifier))
+			if err != nil {
+				return fmt.Errorf("failed to update product identifier index: %v", err)
+			}
+		}
+		if product.Metadata == nil {
+			product.Metadata = make(map[string]interface{})
+		}
+		product.Metadata["splitFrom"] = batch.ID
+		product.BatchID = remainderBatchID
+		if err := indexProductIdentifier(ctx, product); err != nil {
+			return err
+		}
+		if err := putProduct(ctx, product); err != nil {
+			return err
+		}
+	}
+
+	batch.MaterialsUsed = mergedMaterialUsage(batch, keptIDs)
+	batch.ProductIDs = keptIDs
+	batch.Quantity = len(keptIDs)
+	roleContract := &RoleManagementContract{}
+	receiverRole, err := roleContract.GetOrganizationRole(ctx, receiver)
+	if err != nil {
+		return wrapError(err, "failed to get receiver role")
+	}
+	err = s.receiveBatch(ctx, transfer, batch, receiverRole)
+	if err != nil {
+		return err
+	}
+
+	transfer.ConsensusDetails.ReceiverConfirmed = true
+	transfer.ConsensusDetails.ReceiverTimestamp = timestamp
+	transfer.Metadata["quantityReceived"] = quantityReceived
+	transfer.Metadata["remainderBatchId"] = remainderBatchID
+	err = putTransfer(ctx, transfer)
+	if err != nil {
+		return err
+	}
+
+	// Transfers initiated without consensus have no consensus transaction to dispute
+	consensus, err := LoadConsensusIntegration(ctx)
+	if err != nil {
+		return err
+	}
+	err = consensus.NotifyConsensusOfDispute(ctx, transferID, receiver, disputeQuantityMismatch, len(missingIDs))
+	if err != nil && !hasErrorCode(err, ErrNotFound) {
+		return err
+	}
+
+	event := transferEvent(EventTransferPartiallyReceived, transfer, previousStatus)
+	event.Attributes["quantityReceived"] = quantityReceived
+	event.Attributes["quantityDisputed"] = len(missingIDs)
+	event.Attributes["remainderBatchId"] = remainderBatchID
+	return emitEvent(ctx, event)
+}
@@ -0,0 +1,254 @@
+package contracts
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// A minted token is indexed as passport_token_<chain>_<contractAddress>_<tokenId>,
+// holding the product ID, so one token cannot be anchored on two products
+const passportTokenKeyPrefix = "passport_token_"
+
+// States of a product's digital passport
+const (
+	PassportStatusRequested = "REQUESTED" // Waiting for the bridge to mint the token
+	PassportStatusMinted    = "MINTED"
+)
+
+// DigitalPassport links a product to the ERC-721 token that is its consumer-facing
+// twin on an external chain
+type DigitalPassport struct {
+	Status          string `json:"status"`
+	Chain           string `json:"chain"`        // e.g. ethereum:mainnet
+	MetadataHash    string `json:"metadataHash"` // SHA256 of the canonical PassportMetadata at the request
+	RequestedBy     string `json:"requestedBy"`
+	RequestedAt     string `json:"requestedAt"`
+	ContractAddress string `json:"contractAddress,omitempty" metadata:",optional"` // Set by RecordPassportAnchor
+	TokenID         string `json:"tokenId,omitempty" metadata:",optional"`
+	MintTxHash      string `json:"mintTxHash,omitempty" metadata:",optional"` // Minting transaction on the external chain
+	AnchoredBy      string `json:"anchoredBy,omitempty" metadata:",optional"`
+	AnchoredAt      string `json:"anchoredAt,omitempty" metadata:",optional"`
+}
+
+// PassportMetadata is the public description of a product its token commits to. It
+// holds no owner data. Marshaled as JSON, its fields keep this order, so the hash is
+// the same wherever it is recomputed.
+type PassportMetadata struct {
+	ProductID         string `json:"productId"`
+	Brand             string `json:"brand"`
+	Name              string `json:"name"`
+	ProductType       string `json:"productType"`
+	SerialNumber      string `json:"serialNumber"`
+	BatchID           string `json:"batchId"`
+	ManufacturingDate string `json:"manufacturingDate"`
+	CertificateHash   string `json:"certificateHash"` // Revision of the birth certificate the token vouches for
+}
+
+// passportMetadata builds the canonical metadata of a product and its hash
+func passportMetadata(ctx contractapi.TransactionContextInterface, product *Product) (*PassportMetadata, string, error) {
+	ownershipContract := &OwnershipContract{}
+	certificate, err := ownershipContract.GetBirthCertificate(ctx, product.ID)
+	if err != nil {
+		return nil, "", err
+	}
+	metadata := &PassportMetadata{
+		ProductID:         product.ID,
+		Brand:             product.Brand,
+		Name:              product.Name,
+		ProductType:       product.Type,
+		SerialNumber:      product.SerialNumber,
+		BatchID:           product.BatchID,
+		ManufacturingDate: certificate.ManufacturingDate,
+		CertificateHash:   certificate.CertificateHash,
+	}
+	metadataJSON, err := json.Marshal(metadata)
+	if err != nil {
+		return nil, "", err
+	}
+	hash := sha256.Sum256(metadataJSON)
+	return metadata, hex.EncodeToString(hash[:]), nil
+}
+
+// checkPassportMintable rejects a token for a product that was already given one,
+// is stolen or destroyed, or whose certificate was revoked
+func checkPassportMintable(ctx contractapi.TransactionContextInterface, product *Product) error {
+	if product.Passport != nil {
+		return newError(ErrAlreadyExists, "product %s already has a passport %s on %s",
+			product.ID, product.Passport.Status, product.Passport.Chain)
+	}
+	if product.IsStolen || product.Status == ProductStatusStolen {
+		return newError(ErrInvalidState, "product %s is reported stolen", product.ID)
+	}
+	if product.Status == ProductStatusDestroyed || product.Status == ProductStatusWrittenOff {
+		return newError(ErrInvalidState, "product %s is %s", product.ID, product.Status)
+	}
+	if err := checkCertificateNotRevoked(product); err != nil {
+		return err
+	}
+	return checkStolenProduct(ctx, product)
+}
+
+// GetPassportMetadata returns the canonical metadata a product's passport token
+// commits to, for the bridge to publish with the token
+func (o *OwnershipContract) GetPassportMetadata(ctx contractapi.TransactionContextInterface,
+	productID string) (*PassportMetadata, error) {
+
+	if err := validateID("productID", productID); err != nil {
+		return nil, err
+	}
+	supplyChain := &SupplyChainContract{}
+	product, err := supplyChain.GetProduct(ctx, productID)
+	if err != nil {
+		return nil, err
+	}
+	metadata, _, err := passportMetadata(ctx, product)
+	return metadata, err
+}
+
+// RequestPassportMint asks the bridge to mint a product's ERC-721 passport on chain,
+// e.g. ethereum:mainnet. The PassportMintRequested event carries the hash of the
+// canonical metadata the token must commit to. A product gets one passport, and none
+// while it is stolen, destroyed or its certificate revoked. Only the brand (super
+// admin) may request one.
+func (o *OwnershipContract) RequestPassportMint(ctx contractapi.TransactionContextInterface,
+	productID string, chain string) error {
+
+	if err := validateAll(
+		validateID("productID", productID),
+		validateID("chain", chain),
+	); err != nil {
+		return err
+	}
+
+	caller, err := requireSuperAdmin(ctx)
+	if err != nil {
+		return err
+	}
+
+	supplyChain := &SupplyChainContract{}
+	product, err := supplyChain.GetProduct(ctx, productID)
+	if err != nil {
+		return err
+	}
+	if err := checkPassportMintable(ctx, product); err != nil {
+		return err
+	}
+	_, metadataHash, err := passportMetadata(ctx, product)
+	if err != nil {
+		return err
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	product.Passport = &DigitalPassport{
+		Status:       PassportStatusRequested,
+		Chain:        chain,
+		MetadataHash: metadataHash,
+		RequestedBy:  caller,
+		RequestedAt:  now.UTC().Format(time.RFC3339),
+	}
+	if err := putProduct(ctx, product); err != nil {
+		return err
+	}
+
+	return emitEvent(ctx, ChaincodeEvent{
+		EventType:  EventPassportMintRequested,
+		EntityType: EventEntityProduct,
+		EntityID:   productID,
+		ToState:    PassportStatusRequested,
+		Attributes: map[string]interface{}{
+			"chain":        chain,
+			"metadataHash": metadataHash,
+			"brand":        product.Brand,
+			"serialNumber": product.SerialNumber,
+		},
+	})
+}
+
+// RecordPassportAnchor records the token the bridge minted for a requested passport:
+// its contract, token ID and minting transaction on the requested chain. metadataHash
+// must be the requested hash, so the token commits to the metadata the brand approved.
+// Only the brand (super admin), whose bridge relays the mint, may record it.
+func (o *OwnershipContract) RecordPassportAnchor(ctx contractapi.TransactionContextInterface,
+	productID string, metadataHash string, contractAddress string, tokenID string, mintTxHash string) error {
+
+	if err := validateAll(
+		validateID("productID", productID),
+		validateRequired("metadataHash", metadataHash, maxNameLength),
+		validateID("contractAddress", contractAddress),
+		validateID("tokenID", tokenID),
+		validateID("mintTxHash", mintTxHash),
+	); err != nil {
+		return err
+	}
+
+	caller, err := requireSuperAdmin(ctx)
+	if err != nil {
+		return err
+	}
+
+	supplyChain := &SupplyChainContract{}
+	product, err := supplyChain.GetProduct(ctx, productID)
+	if err != nil {
+		return err
+	}
+	passport := product.Passport
+	if passport == nil {
+		return newError(ErrNotFound, "no passport was requested for product %s", productID)
+	}
+	if passport.Status != PassportStatusRequested {
+		return newError(ErrInvalidState, "the passport of product %s is already %s", productID, passport.Status)
+	}
+	if metadataHash != passport.MetadataHash {
+		return newError(ErrConflict, "metadata hash mismatch: requested %s", passport.MetadataHash)
+	}
+
+	tokenKey := passportTokenKeyPrefix + passport.Chain + "_" + contractAddress + "_" + tokenID
+	existing, err := ctx.GetStub().GetState(tokenKey)
+	if err != nil {
+		return fmt.Errorf("failed to read passport token index: %v", err)
+	}
+	if existing != nil {
+		return newError(ErrAlreadyExists, "token %s of %s is already the passport of product %s",
+			tokenID, contractAddress, string(existing))
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	passport.Status = PassportStatusMinted
+	passport.ContractAddress = contractAddress
+	passport.TokenID = tokenID
+	passport.MintTxHash = mintTxHash
+	passport.AnchoredBy = caller
+	passport.AnchoredAt = now.UTC().Format(time.RFC3339)
+	if err := putProduct(ctx, product); err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState(tokenKey, []byte(productID))
+	if err != nil {
+		return fmt.Errorf("failed to index passport token: %v", err)
+	}
+
+	return emitEvent(ctx, ChaincodeEvent{
+		EventType:  EventPassportAnchored,
+		EntityType: EventEntityProduct,
+		EntityID:   productID,
+		FromState:  PassportStatusRequested,
+		ToState:    PassportStatusMinted,
+		Attributes: map[string]interface{}{
+			"chain":           passport.Chain,
+			"contractAddress": contractAddress,
+			"tokenId":         tokenID,
+			"mintTxHash":      mintTxHash,
+		},
+	})
+}
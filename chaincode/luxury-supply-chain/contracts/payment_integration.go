@@ -0,0 +1,149 @@
+package contracts
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// DefaultPaymentTransferFunction matches the ERC-20 style token chaincodes in fabric-samples
+const DefaultPaymentTransferFunction = "Transfer"
+
+// paymentConfigKey holds the PaymentConfig
+const paymentConfigKey = "config_payment"
+
+// PaymentConfig names the token chaincode that settles delivery-versus-payment transfers.
+// The token chaincode must be installed on the same channel, so the payment is written in
+// the same transaction as the receipt and both commit or fail together.
+type PaymentConfig struct {
+	TokenChaincodeName string `json:"tokenChaincodeName"` // Empty when payments are not configured
+	TransferFunction   string `json:"transferFunction"`   // Called with (payee, amount) as the paying client
+	UpdatedBy          string `json:"updatedBy,omitempty" metadata:",optional"`
+	UpdatedAt          string `json:"updatedAt,omitempty" metadata:",optional"`
+	SchemaVersion      int    `json:"schemaVersion"`
+}
+
+// getPaymentConfig reads the stored PaymentConfig. Without one, payments are disabled.
+func getPaymentConfig(ctx contractapi.TransactionContextInterface) (*PaymentConfig, error) {
+	configJSON, err := ctx.GetStub().GetState(paymentConfigKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read payment config: %v", err)
+	}
+	if configJSON == nil {
+		return &PaymentConfig{
+			TransferFunction: DefaultPaymentTransferFunction,
+			SchemaVersion:    CurrentSchemaVersion,
+		}, nil
+	}
+
+	var config PaymentConfig
+	err = json.Unmarshal(configJSON, &config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse payment config: %v", err)
+	}
+	config.upgradeSchema()
+
+	return &config, nil
+}
+
+// SetPaymentTerms attaches delivery-versus-payment terms to a transfer. The receiver
+// pays amount token units to payee when it confirms receipt, and the receipt fails
+// if the payment does. Only the sender can set terms, before confirming sent.
+func (s *SupplyChainContract) SetPaymentTerms(ctx contractapi.TransactionContextInterface,
+	transferID string, amount int, payee string) error {
+
+	if err := validateAll(
+		validateID("transferID", transferID),
+		validateQuantity("amount", float64(amount)),
+		validateRequired("payee", payee, maxTextLength),
+	); err != nil {
+		return err
+	}
+
+	config, err := getPaymentConfig(ctx)
+	if err != nil {
+		return err
+	}
+	if config.TokenChaincodeName == "" {
+		return newError(ErrInvalidState, "no payment chaincode is configured")
+	}
+
+	transfer, err := s.GetTransfer(ctx, transferID)
+	if err != nil {
+		return err
+	}
+
+	sender, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to get sender identity: %v", err)
+	}
+	if transfer.From != sender {
+		return newError(ErrPermissionDenied, "only the sender can set payment terms")
+	}
+	if transfer.Status != TransferStatusInitiated {
+		return newError(ErrInvalidState, "payment terms must be set before the transfer is sent, status is %s", transfer.Status)
+	}
+
+	transfer.Payment = &PaymentTerms{
+		Amount: amount,
+		Payee:  payee,
+		Status: PaymentStatusPending,
+	}
+
+	err = putTransfer(ctx, transfer)
+	if err != nil {
+		return err
+	}
+
+	return emitEvent(ctx, ChaincodeEvent{
+		EventType:  EventPaymentTermsSet,
+		EntityType: EventEntityTransfer,
+		EntityID:   transferID,
+		ToState:    string(PaymentStatusPending),
+		Attributes: map[string]interface{}{
+			"amount": amount,
+		},
+	})
+}
+
+// settlePayment pays a transfer's pending payment terms through the token chaincode,
+// as part of the receiver's ConfirmReceived transaction. The settlement is reported in
+// the TransferCompleted event, since Fabric keeps only one event per transaction.
+func settlePayment(ctx contractapi.TransactionContextInterface, transfer *Transfer) error {
+	if transfer.Payment == nil || transfer.Payment.Status != PaymentStatusPending {
+		return nil
+	}
+
+	config, err := getPaymentConfig(ctx)
+	if err != nil {
+		return err
+	}
+	if config.TokenChaincodeName == "" {
+		return newError(ErrInvalidState, "transfer %s requires payment but no payment chaincode is configured", transfer.ID)
+	}
+
+	args := [][]byte{
+		[]byte(config.TransferFunction),
+		[]byte(transfer.Payment.Payee),
+		[]byte(strconv.Itoa(transfer.Payment.Amount)),
+	}
+
+	// An empty channel name invokes the token chaincode on this channel, within this transaction
+	response := ctx.GetStub().InvokeChaincode(config.TokenChaincodeName, args, "")
+	if response.Status != 200 {
+		return newError(ErrInvalidState, "payment for transfer %s failed: %s", transfer.ID, response.Message)
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	transfer.Payment.Status = PaymentStatusSettled
+	transfer.Payment.SettledAt = now.UTC().Format(time.RFC3339)
+	transfer.Payment.SettlementTxID = ctx.GetStub().GetTxID()
+
+	return nil
+}
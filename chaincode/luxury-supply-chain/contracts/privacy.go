@@ -0,0 +1,1160 @@
+package contracts
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// PrivacyContract exposes privacy-preserving views and aggregated analytics
+type PrivacyContract struct {
+	contractapi.Contract
+}
+
+// Analytics bucket periods
+const (
+	AnalyticsPeriodWeek  = "WEEK"
+	AnalyticsPeriodMonth = "MONTH"
+)
+
+// Small-cohort protection for aggregate queries
+const (
+	// Counts between 1 and minAnalyticsCohort-1 are suppressed
+	minAnalyticsCohort = 5
+	// Scale of the Laplace noise added to counts when noise is requested
+	analyticsNoiseScale = 2.0
+)
+
+// AnonymizedTransfer is a transfer step with the parties reduced to their roles
+type AnonymizedTransfer struct {
+	FromRole     string         `json:"fromRole"`
+	ToRole       string         `json:"toRole"`
+	TransferType TransferType   `json:"transferType"`
+	InitiatedAt  string         `json:"initiatedAt"`
+	CompletedAt  string         `json:"completedAt,omitempty" metadata:",optional"`
+	Status       TransferStatus `json:"status"`
+}
+
+// GetPublicProductInfo returns only public information about a product
+func (p *PrivacyContract) GetPublicProductInfo(ctx contractapi.TransactionContextInterface,
+	productID string) (map[string]interface{}, error) {
+
+	supplyChain := &SupplyChainContract{}
+	return supplyChain.GetPublicProductInfo(ctx, productID)
+}
+
+// GetTransferHistory returns a product's B2B transfers without identifying the organizations
+func (p *PrivacyContract) GetTransferHistory(ctx contractapi.TransactionContextInterface,
+	productID string) ([]*AnonymizedTransfer, error) {
+
+	if err := validateID("productID", productID); err != nil {
+		return nil, err
+	}
+
+	supplyChain := &SupplyChainContract{}
+	exists, err := supplyChain.ProductExists(ctx, productID)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, newError(ErrNotFound, "product %s does not exist", productID)
+	}
+
+	transfers, err := supplyChain.GetTransfersByProduct(ctx, productID)
+	if err != nil {
+		return nil, err
+	}
+
+	roleContract := &RoleManagementContract{}
+	roleOf := func(mspID string) string {
+		role, err := roleContract.GetOrganizationRole(ctx, mspID)
+		if err != nil || role == "" {
+			return "UNKNOWN"
+		}
+		return string(role)
+	}
+
+	history := []*AnonymizedTransfer{}
+	for _, transfer := range transfers {
+		history = append(history, &AnonymizedTransfer{
+			FromRole:     roleOf(transfer.From),
+			ToRole:       roleOf(transfer.To),
+			TransferType: transfer.TransferType,
+			InitiatedAt:  transfer.InitiatedAt,
+			CompletedAt:  transfer.CompletedAt,
+			Status:       transfer.Status,
+		})
+	}
+
+	sort.Slice(history, func(i, j int) bool {
+		return history[i].InitiatedAt < history[j].InitiatedAt
+	})
+
+	return history, nil
+}
+
+// VerifyOwnershipWithoutReveal checks an owner hash against the current owner
+// without returning any ownership details
+func (p *PrivacyContract) VerifyOwnershipWithoutReveal(ctx contractapi.TransactionContextInterface,
+	productID string, ownerHash string) (bool, error) {
+
+	if err := validateAll(
+		validateID("productID", productID),
+		validateID("ownerHash", ownerHash),
+	); err != nil {
+		return false, err
+	}
+
+	ownershipContract := &OwnershipContract{}
+	ownership, err := ownershipContract.GetOwnership(ctx, productID)
+	if err != nil {
+		if hasErrorCode(err, ErrNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return ownership.OwnerHash == ownerHash && ownership.Status == OwnershipStatusActive, nil
+}
+
+// BrandAnalytics is an aggregated, owner-free view of a brand's products
+type BrandAnalytics struct {
+	Brand         string            `json:"brand"`
+	Period        string            `json:"period"`
+	TotalProducts int               `json:"totalProducts"`
+	StatusCounts  map[string]int    `json:"statusCounts"`
+	SoldCount     int               `json:"soldCount"`
+	StolenCount   int               `json:"stolenCount"`
+	Buckets       []AnalyticsBucket `json:"buckets"`
+	Suppressed    bool              `json:"suppressed"` // Whole result withheld, cohort too small
+	Noisy         bool              `json:"noisy"`
+	MinCohort     int               `json:"minCohort"`
+	GeneratedAt   string            `json:"generatedAt"`
+}
+
+// AnalyticsBucket holds event counts for a single week or month
+type AnalyticsBucket struct {
+	Period     string `json:"period"` // 2024-W05 or 2024-03
+	Created    int    `json:"created"`
+	Sold       int    `json:"sold"`
+	Returned   int    `json:"returned"`
+	Stolen     int    `json:"stolen"`
+	Transfers  int    `json:"transfers"`
+	Suppressed bool   `json:"suppressed"` // One or more counts withheld
+}
+
+// GetBrandAnalytics returns a snapshot of the brand's products plus event counts
+// bucketed per week or month so dashboards can chart trends directly.
+// Counts below the minimum cohort are suppressed, and addNoise perturbs the
+// remaining counts so aggregates can't be used to single out individual owners.
+func (p *PrivacyContract) GetBrandAnalytics(ctx contractapi.TransactionContextInterface,
+	brand string, period string, addNoise bool) (*BrandAnalytics, error) {
+
+	if err := validateName("brand", brand); err != nil {
+		return nil, err
+	}
+
+	if period == "" {
+		period = AnalyticsPeriodMonth
+	}
+	period = strings.ToUpper(period)
+	if period != AnalyticsPeriodWeek && period != AnalyticsPeriodMonth {
+		return nil, newError(ErrInvalidArgument, "invalid analytics period: %s", period)
+	}
+
+	supplyChain := &SupplyChainContract{}
+	queryString := fmt.Sprintf(`{"selector":{"brand":"%s","serialNumber":{"$exists":true}},`+
+		`"use_index":["_design/indexProductBrandDoc","indexProductBrand"]}`, brand)
+	products, err := supplyChain.queryProducts(ctx, queryString)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query products for brand %s: %v", brand, err)
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	analytics := &BrandAnalytics{
+		Brand:        brand,
+		Period:       period,
+		StatusCounts: make(map[string]int),
+		Buckets:      []AnalyticsBucket{},
+		Noisy:        addNoise,
+		MinCohort:    minAnalyticsCohort,
+		GeneratedAt:  now.UTC().Format(time.RFC3339),
+	}
+
+	buckets := make(map[string]*AnalyticsBucket)
+	bucketFor := func(timestamp string) *AnalyticsBucket {
+		key, ok := analyticsBucketKey(timestamp, period)
+		if !ok {
+			return nil
+		}
+		bucket, exists := buckets[key]
+		if !exists {
+			bucket = &AnalyticsBucket{Period: key}
+			buckets[key] = bucket
+		}
+		return bucket
+	}
+
+	// Track the brand's items so transfers can be attributed to it
+	brandItems := make(map[string]bool)
+
+	for _, product := range products {
+		analytics.TotalProducts++
+		analytics.StatusCounts[string(product.Status)]++
+		brandItems[product.ID] = true
+		if product.BatchID != "" {
+			brandItems[product.BatchID] = true
+		}
+
+		if bucket := bucketFor(product.CreatedAt); bucket != nil {
+			bucket.Created++
+		}
+
+		if product.IsStolen || product.Status == ProductStatusStolen {
+			analytics.StolenCount++
+		}
+		if bucket := bucketFor(product.StolenDate); bucket != nil {
+			bucket.Stolen++
+		}
+
+		if product.Metadata != nil {
+			if returnDate, ok := product.Metadata["lastReturnDate"].(string); ok {
+				if bucket := bucketFor(returnDate); bucket != nil {
+					bucket.Returned++
+				}
+			}
+		}
+
+		// The first retail sale is the ownership date of the first owner
+		ownershipJSON, err := ctx.GetStub().GetState("ownership_" + product.ID)
+		if err != nil || ownershipJSON == nil {
+			continue
+		}
+		var ownership Ownership
+		if err := json.Unmarshal(ownershipJSON, &ownership); err != nil {
+			continue
+		}
+		analytics.SoldCount++
+		saleDate := ownership.OwnershipDate
+		if len(ownership.PreviousOwners) > 0 {
+			saleDate = ownership.PreviousOwners[0].OwnershipDate
+		}
+		if bucket := bucketFor(saleDate); bucket != nil {
+			bucket.Sold++
+		}
+	}
+
+	// Count transfers of the brand's products and batches
+	resultsIterator, err := ctx.GetStub().GetStateByRange("transfer_", "transfer_~")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query transfers: %v", err)
+	}
+	defer resultsIterator.Close()
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var transfer Transfer
+		if err := json.Unmarshal(queryResponse.Value, &transfer); err != nil {
+			continue
+		}
+		if !brandItems[transfer.ProductID] {
+			continue
+		}
+		if bucket := bucketFor(transfer.InitiatedAt); bucket != nil {
+			bucket.Transfers++
+		}
+	}
+
+	keys := make([]string, 0, len(buckets))
+	for key := range buckets {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		analytics.Buckets = append(analytics.Buckets, *buckets[key])
+	}
+
+	protectBrandAnalytics(analytics, addNoise)
+
+	return analytics, nil
+}
+
+// protectBrandAnalytics applies small-cohort suppression and optional noise
+func protectBrandAnalytics(analytics *BrandAnalytics, addNoise bool) {
+	// A brand with too few products gets no breakdown at all
+	if analytics.TotalProducts < minAnalyticsCohort {
+		analytics.Suppressed = true
+		analytics.TotalProducts = 0
+		analytics.SoldCount = 0
+		analytics.StolenCount = 0
+		analytics.StatusCounts = make(map[string]int)
+		analytics.Buckets = []AnalyticsBucket{}
+		return
+	}
+
+	protect := func(label string, count int) (int, bool) {
+		if count > 0 && count < minAnalyticsCohort {
+			return 0, true
+		}
+		if addNoise {
+			count = noisyCount(analytics.Brand+"|"+analytics.Period+"|"+label, count)
+		}
+		return count, false
+	}
+
+	analytics.TotalProducts, _ = protect("total", analytics.TotalProducts)
+	analytics.SoldCount, _ = protect("sold", analytics.SoldCount)
+	analytics.StolenCount, _ = protect("stolen", analytics.StolenCount)
+
+	for status, count := range analytics.StatusCounts {
+		protected, suppressed := protect("status|"+status, count)
+		if suppressed {
+			delete(analytics.StatusCounts, status)
+			continue
+		}
+		analytics.StatusCounts[status] = protected
+	}
+
+	for i := range analytics.Buckets {
+		bucket := &analytics.Buckets[i]
+		counts := []*int{&bucket.Created, &bucket.Sold, &bucket.Returned, &bucket.Stolen, &bucket.Transfers}
+		for j, count := range counts {
+			protected, suppressed := protect(fmt.Sprintf("%s|%d", bucket.Period, j), *count)
+			*count = protected
+			if suppressed {
+				bucket.Suppressed = true
+			}
+		}
+	}
+}
+
+// noisyCount adds Laplace noise to a count. The noise is derived from the seed
+// alone, the brand, period and count label, so repeating a query returns the
+// same value instead of fresh noise that averages out.
+func noisyCount(seed string, count int) int {
+	digest := sha256.Sum256([]byte(seed))
+	// Uniform value in (-0.5, 0.5)
+	u := (float64(binary.BigEndian.Uint64(digest[:8])>>11)+0.5)/float64(uint64(1)<<53) - 0.5
+
+	sign := 1.0
+	if u < 0 {
+		sign = -1.0
+	}
+	noise := -analyticsNoiseScale * sign * math.Log(1-2*math.Abs(u))
+
+	noisy := int(math.Round(float64(count) + noise))
+	if noisy < 0 {
+		return 0
+	}
+	return noisy
+}
+
+// analyticsBucketKey maps an RFC3339 timestamp to its week or month bucket
+func analyticsBucketKey(timestamp string, period string) (string, bool) {
+	if timestamp == "" || timestamp == "N/A" || timestamp == "PENDING" {
+		return "", false
+	}
+	t, err := time.Parse(time.RFC3339, timestamp)
+	if err != nil {
+		return "", false
+	}
+	t = t.UTC()
+
+	if period == AnalyticsPeriodWeek {
+		year, week := t.ISOWeek()
+		return fmt.Sprintf("%04d-W%02d", year, week), true
+	}
+	return t.Format("2006-01"), true
+}
+
+// SupplyChainFlowStats aggregates transfers between roles without naming parties
+type SupplyChainFlowStats struct {
+	FromDate       string     `json:"fromDate"`
+	ToDate         string     `json:"toDate"`
+	TotalTransfers int        `json:"totalTransfers"`
+	Flows          []RoleFlow `json:"flows"`
+	GeneratedAt    string     `json:"generatedAt"`
+}
+
+// RoleFlow holds transfer counts and volumes for one role pair and item type
+type RoleFlow struct {
+	FromRole   string  `json:"fromRole"`
+	ToRole     string  `json:"toRole"`
+	ItemType   string  `json:"itemType"` // PRODUCT, BATCH or MATERIAL
+	Count      int     `json:"count"`
+	Completed  int     `json:"completed"`
+	Volume     float64 `json:"volume"`
+	Suppressed bool    `json:"suppressed"` // Too few transfers to report safely
+}
+
+// GetSupplyChainFlowStats returns transfer counts and volumes between role pairs
+// (supplier→manufacturer, manufacturer→retailer, ...) initiated within the given
+// RFC3339 range. Either bound may be empty for an open range.
+func (p *PrivacyContract) GetSupplyChainFlowStats(ctx contractapi.TransactionContextInterface,
+	fromDate string, toDate string) (*SupplyChainFlowStats, error) {
+
+	var from, to time.Time
+	var err error
+	if fromDate != "" {
+		from, err = time.Parse(time.RFC3339, fromDate)
+		if err != nil {
+			return nil, newError(ErrInvalidArgument, "invalid fromDate: %v", err)
+		}
+	}
+	if toDate != "" {
+		to, err = time.Parse(time.RFC3339, toDate)
+		if err != nil {
+			return nil, newError(ErrInvalidArgument, "invalid toDate: %v", err)
+		}
+	}
+	inRange := func(timestamp string) bool {
+		t, err := time.Parse(time.RFC3339, timestamp)
+		if err != nil {
+			return false
+		}
+		if fromDate != "" && t.Before(from) {
+			return false
+		}
+		if toDate != "" && t.After(to) {
+			return false
+		}
+		return true
+	}
+
+	// Resolve each organization's role once
+	roleContract := &RoleManagementContract{}
+	roles := make(map[string]string)
+	roleOf := func(mspID string) string {
+		if role, ok := roles[mspID]; ok {
+			return role
+		}
+		role, err := roleContract.GetOrganizationRole(ctx, mspID)
+		if err != nil || role == "" {
+			roles[mspID] = "UNKNOWN"
+		} else {
+			roles[mspID] = string(role)
+		}
+		return roles[mspID]
+	}
+
+	flows := make(map[string]*RoleFlow)
+	record := func(sender, receiver, itemType string, volume float64, completed bool) {
+		fromRole, toRole := roleOf(sender), roleOf(receiver)
+		key := fromRole + "|" + toRole + "|" + itemType
+		flow, ok := flows[key]
+		if !ok {
+			flow = &RoleFlow{FromRole: fromRole, ToRole: toRole, ItemType: itemType}
+			flows[key] = flow
+		}
+		flow.Count++
+		flow.Volume += volume
+		if completed {
+			flow.Completed++
+		}
+	}
+
+	// Product and batch transfers
+	resultsIterator, err := ctx.GetStub().GetStateByRange("transfer_", "transfer_~")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query transfers: %v", err)
+	}
+	defer resultsIterator.Close()
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var transfer Transfer
+		if err := json.Unmarshal(queryResponse.Value, &transfer); err != nil {
+			continue
+		}
+		if !inRange(transfer.InitiatedAt) {
+			continue
+		}
+
+		itemType := "PRODUCT"
+		volume := 1.0
+		if batchType, ok := transfer.Metadata["type"].(string); ok && batchType == "BATCH" {
+			itemType = "BATCH"
+			if qty, ok := transfer.Metadata["quantity"].(float64); ok {
+				volume = qty
+			}
+		}
+		record(transfer.From, transfer.To, itemType, volume, transfer.Status == TransferStatusCompleted)
+	}
+
+	// Material transfers are recorded on both inventories; count the sender's copy only
+	inventoryIterator, err := ctx.GetStub().GetStateByRange("material_inventory_", "material_inventory_~")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query material inventories: %v", err)
+	}
+	defer inventoryIterator.Close()
+
+	for inventoryIterator.HasNext() {
+		queryResponse, err := inventoryIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var inventory MaterialInventory
+		if err := json.Unmarshal(queryResponse.Value, &inventory); err != nil {
+			continue
+		}
+		for _, materialTransfer := range inventory.Transfers {
+			if materialTransfer.From != inventory.Owner || !inRange(materialTransfer.TransferDate) {
+				continue
+			}
+			record(materialTransfer.From, materialTransfer.To, "MATERIAL",
+				materialTransfer.Quantity, materialTransfer.Verified)
+		}
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &SupplyChainFlowStats{
+		FromDate:    fromDate,
+		ToDate:      toDate,
+		Flows:       []RoleFlow{},
+		GeneratedAt: now.UTC().Format(time.RFC3339),
+	}
+
+	keys := make([]string, 0, len(flows))
+	for key := range flows {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		flow := *flows[key]
+		stats.TotalTransfers += flow.Count
+
+		// A role pair with few transfers can identify the two parties involved
+		if flow.Count < minAnalyticsCohort {
+			flow.Count = 0
+			flow.Completed = 0
+			flow.Volume = 0
+			flow.Suppressed = true
+		}
+		stats.Flows = append(stats.Flows, flow)
+	}
+
+	return stats, nil
+}
+
+// Purpose codes accepted when reading owner-specific data
+var ownerDataPurposeCodes = []string{
+	"CUSTOMER_REQUEST",
+	"CUSTOMER_SUPPORT",
+	"SERVICE",
+	"FRAUD_INVESTIGATION",
+	"LAW_ENFORCEMENT",
+	"LEGAL_OBLIGATION",
+}
+
+// OwnerDataAccessEntry records a single read of owner-specific data
+type OwnerDataAccessEntry struct {
+	ProductID     string `json:"productId"`
+	Accessor      string `json:"accessor"` // Caller organization MSP ID
+	Function      string `json:"function"`
+	PurposeCode   string `json:"purposeCode"`
+	TxID          string `json:"txId"`
+	Timestamp     string `json:"timestamp"`
+	SchemaVersion int    `json:"schemaVersion"`
+}
+
+// recordOwnerDataAccess appends an access-log entry for a read of owner data.
+// Each entry gets its own key so concurrent reads never conflict.
+func recordOwnerDataAccess(ctx contractapi.TransactionContextInterface,
+	productID string, function string, purposeCode string) error {
+
+	purposeCode = strings.ToUpper(purposeCode)
+	validPurpose := false
+	for _, code := range ownerDataPurposeCodes {
+		if code == purposeCode {
+			validPurpose = true
+			break
+		}
+	}
+	if !validPurpose {
+		return newError(ErrInvalidArgument, "invalid purpose code %q, must be one of %s", purposeCode, strings.Join(ownerDataPurposeCodes, ", "))
+	}
+
+	accessor, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to get caller identity: %v", err)
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	txID := ctx.GetStub().GetTxID()
+	entry := OwnerDataAccessEntry{
+		SchemaVersion: CurrentSchemaVersion,
+		ProductID:     productID,
+		Accessor:      accessor,
+		Function:      function,
+		PurposeCode:   purposeCode,
+		TxID:          txID,
+		Timestamp:     now.UTC().Format(time.RFC3339),
+	}
+
+	entryJSON, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().PutState(fmt.Sprintf("access_log_%s_%s", productID, txID), entryJSON)
+}
+
+// GetOwnerDataAccessLog returns every recorded access to a product's owner data.
+// Restricted to the brand (super admin) acting as privacy officer.
+func (p *PrivacyContract) GetOwnerDataAccessLog(ctx contractapi.TransactionContextInterface,
+	productID string) ([]*OwnerDataAccessEntry, error) {
+
+	if err := validateID("productID", productID); err != nil {
+		return nil, err
+	}
+
+	if _, err := requireSuperAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	prefix := fmt.Sprintf("access_log_%s_", productID)
+	resultsIterator, err := ctx.GetStub().GetStateByRange(prefix, prefix+"~")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query access log: %v", err)
+	}
+	defer resultsIterator.Close()
+
+	entries := []*OwnerDataAccessEntry{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var entry OwnerDataAccessEntry
+		if err := json.Unmarshal(queryResponse.Value, &entry); err != nil {
+			continue
+		}
+		entry.upgradeSchema()
+		entries = append(entries, &entry)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Timestamp < entries[j].Timestamp
+	})
+
+	return entries, nil
+}
+
+// Verification token lifetime bounds
+const (
+	defaultVerificationTokenTTL = 24 * time.Hour
+	maxVerificationTokenTTL     = 30 * 24 * time.Hour
+)
+
+// Verification tokens are derived from a random seed the client passes in the transient
+// field "tokenSeed", so every endorser issues the same token and nobody else can predict it
+const (
+	tokenSeedTransientKey = "tokenSeed"
+	minTokenSeedLength    = 16
+	verificationTokenSize = 24 // Hex characters
+)
+
+// VerificationToken is the stored form of a pseudonymous ownership token.
+// Neither the token nor the owner hash is stored, only a binding digest.
+type VerificationToken struct {
+	ProductID     string `json:"productId"`
+	OwnerProof    string `json:"ownerProof"` // SHA256(token + ownerHash)
+	IssuedAt      string `json:"issuedAt"`
+	ExpiresAt     string `json:"expiresAt"`
+	SchemaVersion int    `json:"schemaVersion"`
+}
+
+// IssueVerificationToken issues a random, time-limited token the owner can hand
+// to a third party, who presents it to VerifyByToken to confirm current ownership.
+// ttlSeconds <= 0 uses the 24 hour default. The token is derived from the transient
+// tokenSeed, see verificationTokenFromSeed.
+// Called by backend after authenticating the customer off-chain
+func (p *PrivacyContract) IssueVerificationToken(ctx contractapi.TransactionContextInterface,
+	productID string, ownerHash string, securityHash string, ttlSeconds int) (string, error) {
+
+	if err := validateAll(
+		validateID("productID", productID),
+		validateID("ownerHash", ownerHash),
+		validateID("securityHash", securityHash),
+	); err != nil {
+		return "", err
+	}
+
+	ownershipContract := &OwnershipContract{}
+	ownership, err := ownershipContract.GetOwnership(ctx, productID)
+	if err != nil {
+		return "", err
+	}
+
+	// Verify owner hash matches
+	if ownership.OwnerHash != ownerHash {
+		return "", newError(ErrPermissionDenied, "ownership verification failed")
+	}
+
+	// Verify security hash (password + PIN) matches
+	if ownership.SecurityHash != securityHash {
+		return "", newError(ErrPermissionDenied, "security verification failed - incorrect password or PIN")
+	}
+
+	if ownership.Status != OwnershipStatusActive {
+		return "", newError(ErrInvalidState, "cannot issue verification token while ownership is %s", ownership.Status)
+	}
+
+	ttl := defaultVerificationTokenTTL
+	if ttlSeconds > 0 {
+		ttl = time.Duration(ttlSeconds) * time.Second
+	}
+	if ttl > maxVerificationTokenTTL {
+		return "", newError(ErrInvalidArgument, "token ttl exceeds maximum of %v", maxVerificationTokenTTL)
+	}
+
+	token, err := verificationTokenFromSeed(ctx, productID)
+	if err != nil {
+		return "", err
+	}
+	now, err := txTime(ctx)
+	if err != nil {
+		return "", err
+	}
+	now = now.UTC()
+	record := VerificationToken{
+		SchemaVersion: CurrentSchemaVersion,
+		ProductID:     productID,
+		OwnerProof:    verificationOwnerProof(token, ownerHash),
+		IssuedAt:      now.Format(time.RFC3339),
+		ExpiresAt:     now.Add(ttl).Format(time.RFC3339),
+	}
+
+	recordJSON, err := json.Marshal(record)
+	if err != nil {
+		return "", err
+	}
+
+	err = ctx.GetStub().PutState(verificationTokenKey(token), recordJSON)
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// VerifyByToken confirms that the holder who issued the token still owns the
+// product, without revealing the owner hash to the verifier
+func (p *PrivacyContract) VerifyByToken(ctx contractapi.TransactionContextInterface,
+	token string) (map[string]interface{}, error) {
+
+	if err := validateID("token", token); err != nil {
+		return nil, err
+	}
+
+	recordJSON, err := ctx.GetStub().GetState(verificationTokenKey(token))
+	if err != nil {
+		return nil, err
+	}
+	if recordJSON == nil {
+		return map[string]interface{}{
+			"valid":  false,
+			"reason": "Unknown verification token",
+		}, nil
+	}
+
+	var record VerificationToken
+	err = json.Unmarshal(recordJSON, &record)
+	if err != nil {
+		return nil, err
+	}
+	record.upgradeSchema()
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if now.UTC().Format(time.RFC3339) > record.ExpiresAt {
+		return map[string]interface{}{
+			"valid":  false,
+			"reason": "Verification token has expired",
+		}, nil
+	}
+
+	ownershipContract := &OwnershipContract{}
+	ownership, err := ownershipContract.GetOwnership(ctx, record.ProductID)
+	if err != nil {
+		return map[string]interface{}{
+			"valid":  false,
+			"reason": "Product no longer has a registered owner",
+		}, nil
+	}
+
+	// The proof only matches while the issuing owner still holds the product
+	currentOwner := verificationOwnerProof(token, ownership.OwnerHash) == record.OwnerProof
+	result := map[string]interface{}{
+		"valid":          currentOwner,
+		"productId":      record.ProductID,
+		"currentOwner":   currentOwner,
+		"ownershipState": ownership.Status,
+		"expiresAt":      record.ExpiresAt,
+	}
+	if !currentOwner {
+		result["reason"] = "Token holder is no longer the owner"
+	}
+
+	return result, nil
+}
+
+// verificationTokenKey stores tokens under their digest so the raw token never hits the ledger
+func verificationTokenKey(token string) string {
+	digest := sha256.Sum256([]byte(token))
+	return "verify_token_" + hex.EncodeToString(digest[:])
+}
+
+// verificationTokenFromSeed derives a verification token from the client's transient
+// tokenSeed, the product and the transaction ID. A random token would differ on every
+// endorser, whose write sets then never match.
+func verificationTokenFromSeed(ctx contractapi.TransactionContextInterface, productID string) (string, error) {
+	transientMap, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return "", fmt.Errorf("failed to read transient data: %v", err)
+	}
+	seed := transientMap[tokenSeedTransientKey]
+	if len(seed) < minTokenSeedLength {
+		return "", newError(ErrInvalidArgument, "transient field %s must hold at least %d random bytes",
+			tokenSeedTransientKey, minTokenSeedLength)
+	}
+
+	mac := hmac.New(sha256.New, seed)
+	mac.Write([]byte(productID + "|" + ctx.GetStub().GetTxID()))
+	return strings.ToUpper(hex.EncodeToString(mac.Sum(nil)))[:verificationTokenSize], nil
+}
+
+// verificationOwnerProof binds a token to an owner hash
+func verificationOwnerProof(token string, ownerHash string) string {
+	digest := sha256.Sum256([]byte(token + ownerHash))
+	return hex.EncodeToString(digest[:])
+}
+
+// Birth certificate fields that can be disclosed individually, in commitment order
+var certificateDisclosureFields = []string{
+	"productId",
+	"brand",
+	"manufacturingDate",
+	"manufacturingPlace",
+	"craftsman",
+	"materials",
+	"authenticity",
+	"initialPhotos",
+}
+
+// Disclosure salts are kept in a private data collection of the brand, see
+// collections_config.json, so withheld fields cannot be brute-forced from public
+// state. They are derived from a random seed the client passes in the transient
+// field "disclosureSeed", which endorsers agree on but nobody else can predict.
+const (
+	certificateDisclosureCollection = "certificateDisclosure"
+	disclosureSaltKeyPrefix         = "disclosure_salt_"
+	disclosureSeedTransientKey      = "disclosureSeed"
+	minDisclosureSeedLength         = 16
+)
+
+// Fields each requester role may see
+var certificateDisclosurePolicy = map[string][]string{
+	"PUBLIC":   {"productId", "brand", "manufacturingDate"},
+	"RESELLER": {"productId", "brand", "manufacturingDate", "materials", "authenticity"},
+	"OWNER":    certificateDisclosureFields,
+	"RETAILER": certificateDisclosureFields,
+	"BRAND":    certificateDisclosureFields,
+}
+
+// CertificateDisclosureSalts stores the per-field salts behind a certificate's disclosure root
+type CertificateDisclosureSalts struct {
+	ProductID     string            `json:"productId"`
+	Salts         map[string]string `json:"salts"`
+	SchemaVersion int               `json:"schemaVersion"`
+}
+
+// CertificateDisclosure is a partial birth certificate with per-field proofs
+type CertificateDisclosure struct {
+	ProductID       string            `json:"productId"`
+	RequesterRole   string            `json:"requesterRole"`
+	CertificateHash string            `json:"certificateHash"`
+	DisclosureRoot  string            `json:"disclosureRoot"`
+	FieldOrder      []string          `json:"fieldOrder"`
+	Fields          []DisclosedField  `json:"fields"`
+	WithheldLeaves  map[string]string `json:"withheldLeaves"` // Leaf hashes of fields not disclosed
+}
+
+// DisclosedField carries a field value with the salt needed to recompute its leaf hash
+type DisclosedField struct {
+	Name     string `json:"name"`
+	Value    string `json:"value"` // JSON encoding of the value, as hashed
+	Salt     string `json:"salt"`
+	LeafHash string `json:"leafHash"` // SHA256(name|salt|value)
+}
+
+// prepareCertificateDisclosure derives per-field salts, stores them and sets the
+// certificate's disclosure root. Must run before the certificate hash is computed
+// so the hash also commits to the root.
+func prepareCertificateDisclosure(ctx contractapi.TransactionContextInterface,
+	certificate *DigitalBirthCertificate) error {
+
+	transientMap, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return fmt.Errorf("failed to read transient data: %v", err)
+	}
+	seed := transientMap[disclosureSeedTransientKey]
+	if len(seed) < minDisclosureSeedLength {
+		return newError(ErrInvalidArgument, "transient field %s must hold at least %d random bytes",
+			disclosureSeedTransientKey, minDisclosureSeedLength)
+	}
+
+	salts := make(map[string]string)
+	for _, field := range certificateDisclosureFields {
+		mac := hmac.New(sha256.New, seed)
+		mac.Write([]byte(certificate.ProductID + "|" + field))
+		salts[field] = hex.EncodeToString(mac.Sum(nil)[:16])
+	}
+
+	values, err := certificateFieldValues(certificate)
+	if err != nil {
+		return err
+	}
+
+	leaves := make(map[string]string)
+	for _, field := range certificateDisclosureFields {
+		leaves[field] = disclosureLeafHash(field, salts[field], values[field])
+	}
+	certificate.DisclosureRoot = disclosureRoot(leaves)
+
+	saltsJSON, err := json.Marshal(CertificateDisclosureSalts{
+		SchemaVersion: CurrentSchemaVersion,
+		ProductID:     certificate.ProductID,
+		Salts:         salts,
+	})
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().PutPrivateData(certificateDisclosureCollection, disclosureSaltKeyPrefix+certificate.ProductID, saltsJSON)
+}
+
+// certificateDisclosureRole returns the requester role of the caller. The current
+// owner proves ownership with its owner and security hashes; organizations get the
+// role of their MSP, and anyone else is PUBLIC.
+func certificateDisclosureRole(ctx contractapi.TransactionContextInterface,
+	productID string, ownerHash string, securityHash string) (string, error) {
+
+	if ownerHash != "" || securityHash != "" {
+		ownershipContract := &OwnershipContract{}
+		ownership, err := ownershipContract.GetOwnership(ctx, productID)
+		if err != nil {
+			return "", err
+		}
+		if ownership.OwnerHash != ownerHash || ownership.SecurityHash != securityHash ||
+			ownership.Status != OwnershipStatusActive {
+			return "", newError(ErrPermissionDenied, "ownership verification failed")
+		}
+		return "OWNER", nil
+	}
+
+	caller, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return "", fmt.Errorf("failed to get caller identity: %v", err)
+	}
+	roleContract := &RoleManagementContract{}
+	callerRole, err := roleContract.GetOrganizationRole(ctx, caller)
+	if err != nil {
+		if hasErrorCode(err, ErrNotFound) {
+			return "PUBLIC", nil
+		}
+		return "", err
+	}
+	switch callerRole {
+	case RoleSuperAdmin:
+		return "BRAND", nil
+	case RoleRetailer:
+		return "RETAILER", nil
+	}
+	return "RESELLER", nil
+}
+
+// GetCertificateDisclosure returns only the requested birth certificate fields
+// (comma-separated, empty for everything the role may see) with salted per-field
+// hashes that recompute to the disclosure root committed in the certificate.
+// A reseller can therefore verify materials without seeing the craftsman or site.
+// The owner passes its owner and security hashes, organizations pass them empty.
+func (p *PrivacyContract) GetCertificateDisclosure(ctx contractapi.TransactionContextInterface,
+	productID string, fieldsMask string, ownerHash string, securityHash string) (*CertificateDisclosure, error) {
+
+	if err := validateAll(
+		validateID("productID", productID),
+		validateText("fieldsMask", fieldsMask, maxTextLength),
+		validateText("ownerHash", ownerHash, maxIDLength),
+		validateText("securityHash", securityHash, maxIDLength),
+	); err != nil {
+		return nil, err
+	}
+
+	requesterRole, err := certificateDisclosureRole(ctx, productID, ownerHash, securityHash)
+	if err != nil {
+		return nil, err
+	}
+	allowed := certificateDisclosurePolicy[requesterRole]
+
+	allowedSet := make(map[string]bool)
+	for _, field := range allowed {
+		allowedSet[field] = true
+	}
+
+	requested := make(map[string]bool)
+	if strings.TrimSpace(fieldsMask) == "" {
+		requested = allowedSet
+	} else {
+		for _, field := range strings.Split(fieldsMask, ",") {
+			field = strings.TrimSpace(field)
+			if !allowedSet[field] {
+				return nil, newError(ErrPermissionDenied, "field %s cannot be disclosed to %s", field, requesterRole)
+			}
+			requested[field] = true
+		}
+	}
+
+	ownershipContract := &OwnershipContract{}
+	certificate, err := ownershipContract.GetBirthCertificate(ctx, productID)
+	if err != nil {
+		return nil, err
+	}
+	if certificate.DisclosureRoot == "" {
+		return nil, newError(ErrInvalidState, "birth certificate for product %s predates selective disclosure", productID)
+	}
+
+	saltsJSON, err := ctx.GetStub().GetPrivateData(certificateDisclosureCollection, disclosureSaltKeyPrefix+productID)
+	if err != nil {
+		return nil, err
+	}
+	if saltsJSON == nil {
+		// Certificates issued before the salts moved to the collection kept them in public state
+		saltsJSON, err = ctx.GetStub().GetState(disclosureSaltKeyPrefix + productID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if saltsJSON == nil {
+		return nil, newError(ErrNotFound, "disclosure salts not found for product %s", productID)
+	}
+	var salts CertificateDisclosureSalts
+	err = json.Unmarshal(saltsJSON, &salts)
+	if err != nil {
+		return nil, err
+	}
+	salts.upgradeSchema()
+
+	values, err := certificateFieldValues(certificate)
+	if err != nil {
+		return nil, err
+	}
+
+	disclosure := &CertificateDisclosure{
+		ProductID:       productID,
+		RequesterRole:   requesterRole,
+		CertificateHash: certificate.CertificateHash,
+		DisclosureRoot:  certificate.DisclosureRoot,
+		FieldOrder:      certificateDisclosureFields,
+		Fields:          []DisclosedField{},
+		WithheldLeaves:  make(map[string]string),
+	}
+
+	for _, field := range certificateDisclosureFields {
+		leaf := disclosureLeafHash(field, salts.Salts[field], values[field])
+		if !requested[field] {
+			disclosure.WithheldLeaves[field] = leaf
+			continue
+		}
+		disclosure.Fields = append(disclosure.Fields, DisclosedField{
+			Name:     field,
+			Value:    string(values[field]),
+			Salt:     salts.Salts[field],
+			LeafHash: leaf,
+		})
+	}
+
+	return disclosure, nil
+}
+
+// certificateFieldValues returns the canonical JSON encoding of each disclosable field
+func certificateFieldValues(certificate *DigitalBirthCertificate) (map[string]json.RawMessage, error) {
+	materials := certificate.Materials
+	if materials == nil {
+		materials = []MaterialRecord{}
+	}
+	photos := certificate.InitialPhotos
+	if photos == nil {
+		photos = []string{}
+	}
+
+	raw := map[string]interface{}{
+		"productId":          certificate.ProductID,
+		"brand":              certificate.Brand,
+		"manufacturingDate":  certificate.ManufacturingDate,
+		"manufacturingPlace": certificate.ManufacturingPlace,
+		"craftsman":          certificate.Craftsman,
+		"materials":          materials,
+		"authenticity":       certificate.Authenticity,
+		"initialPhotos":      photos,
+	}
+
+	values := make(map[string]json.RawMessage)
+	for field, value := range raw {
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		values[field] = encoded
+	}
+
+	return values, nil
+}
+
+// disclosureLeafHash hashes a single salted certificate field
+func disclosureLeafHash(field string, salt string, value []byte) string {
+	digest := sha256.Sum256([]byte(field + "|" + salt + "|" + string(value)))
+	return hex.EncodeToString(digest[:])
+}
+
+// disclosureRoot combines leaf hashes in commitment order
+func disclosureRoot(leaves map[string]string) string {
+	var combined strings.Builder
+	for _, field := range certificateDisclosureFields {
+		combined.WriteString(leaves[field])
+	}
+	digest := sha256.Sum256([]byte(combined.String()))
+	return hex.EncodeToString(digest[:])
+}
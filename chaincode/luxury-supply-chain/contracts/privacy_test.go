@@ -0,0 +1,164 @@
+package contracts
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyOwnershipWithoutReveal(t *testing.T) {
+	ledger := newTestLedger(t)
+	ledger.put("ownership_P1", Ownership{ProductID: "P1", OwnerHash: "owner1", SecurityHash: "secret1", Status: OwnershipStatusActive})
+	ledger.put("ownership_P2", Ownership{ProductID: "P2", OwnerHash: "owner2", SecurityHash: "secret2", Status: OwnershipStatusReported})
+
+	tests := []struct {
+		name      string
+		productID string
+		ownerHash string
+		want      bool
+	}{
+		{"current owner", "P1", "owner1", true},
+		{"other owner", "P1", "owner2", false},
+		{"reported stolen", "P2", "owner2", false},
+		{"no ownership record", "P3", "owner1", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := (&PrivacyContract{}).VerifyOwnershipWithoutReveal(ledger.as("LuxuryRetailMSP"), tt.productID, tt.ownerHash)
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestVerifyOwnershipWithoutRevealReturnsLedgerErrors(t *testing.T) {
+	ledger := newTestLedger(t)
+	ctx := ledger.as("LuxuryRetailMSP")
+	ctx.SetStub(&failingStub{ledger.stub})
+
+	_, err := (&PrivacyContract{}).VerifyOwnershipWithoutReveal(ctx, "P1", "owner1")
+	require.Error(t, err)
+	require.False(t, hasErrorCode(err, ErrNotFound))
+}
+
+func TestProtectBrandAnalyticsSuppressesSmallCohorts(t *testing.T) {
+	analytics := &BrandAnalytics{
+		Brand:         "LuxeBags",
+		TotalProducts: minAnalyticsCohort - 1,
+		StatusCounts:  map[string]int{"CREATED": minAnalyticsCohort - 1},
+		Buckets:       []AnalyticsBucket{{Period: "2024-03", Created: minAnalyticsCohort - 1}},
+	}
+	protectBrandAnalytics(analytics, false)
+
+	require.True(t, analytics.Suppressed)
+	require.Zero(t, analytics.TotalProducts)
+	require.Empty(t, analytics.StatusCounts)
+	require.Empty(t, analytics.Buckets)
+
+	analytics = &BrandAnalytics{
+		Brand:         "LuxeBags",
+		TotalProducts: 40,
+		StatusCounts:  map[string]int{"CREATED": 38, "STOLEN": 2},
+		Buckets:       []AnalyticsBucket{{Period: "2024-03", Created: 40, Sold: 1}},
+	}
+	protectBrandAnalytics(analytics, false)
+
+	require.False(t, analytics.Suppressed)
+	require.Equal(t, 40, analytics.TotalProducts)
+	require.Equal(t, map[string]int{"CREATED": 38}, analytics.StatusCounts)
+	require.True(t, analytics.Buckets[0].Suppressed)
+	require.Equal(t, 40, analytics.Buckets[0].Created)
+	require.Zero(t, analytics.Buckets[0].Sold)
+}
+
+func TestProtectBrandAnalyticsNoiseIsRepeatable(t *testing.T) {
+	query := func() *BrandAnalytics {
+		analytics := &BrandAnalytics{
+			Brand:         "LuxeBags",
+			Period:        AnalyticsPeriodMonth,
+			TotalProducts: 120,
+			SoldCount:     60,
+			StatusCounts:  map[string]int{"CREATED": 120},
+			Buckets:       []AnalyticsBucket{{Period: "2024-03", Created: 120, Sold: 60}},
+		}
+		protectBrandAnalytics(analytics, true)
+		return analytics
+	}
+
+	first := query()
+	for i := 0; i < 10; i++ {
+		require.Equal(t, first, query(), "a repeated query must return the same noisy counts")
+	}
+	require.Equal(t, noisyCount("LuxeBags|MONTH|total", 120), first.TotalProducts)
+	require.NotEqual(t, noisyCount("LuxeBags|MONTH|total", 120), noisyCount("LuxeBags|WEEK|total", 120))
+}
+
+func TestCertificateDisclosureRole(t *testing.T) {
+	ledger := newTestLedger(t)
+	ledger.put("ownership_P1", Ownership{ProductID: "P1", OwnerHash: "owner1", SecurityHash: "secret1", Status: OwnershipStatusActive})
+
+	tests := []struct {
+		name         string
+		caller       string
+		ownerHash    string
+		securityHash string
+		want         string
+		wantErr      ErrorCode
+	}{
+		{"owner with both hashes", "UnknownMSP", "owner1", "secret1", "OWNER", ""},
+		{"owner hash without security hash", "LuxuryRetailMSP", "owner1", "", "", ErrPermissionDenied},
+		{"wrong security hash", "LuxuryRetailMSP", "owner1", "secret2", "", ErrPermissionDenied},
+		{"brand", "LuxeBagsMSP", "", "", "BRAND", ""},
+		{"retailer", "LuxuryRetailMSP", "", "", "RETAILER", ""},
+		{"other organization", "ItalianLeatherMSP", "", "", "RESELLER", ""},
+		{"unregistered organization", "UnknownMSP", "", "", "PUBLIC", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := certificateDisclosureRole(ledger.as(tt.caller), "P1", tt.ownerHash, tt.securityHash)
+			if tt.wantErr != "" {
+				require.True(t, hasErrorCode(err, tt.wantErr), "got %v", err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestIssueVerificationTokenIsTheSameOnEveryEndorser(t *testing.T) {
+	// Each endorser runs the proposal against its own copy of the ledger, with the
+	// same transaction ID, timestamp and transient seed
+	endorse := func(reference *testLedger) (*testLedger, string) {
+		ledger := newTestLedger(t)
+		ledger.put("ownership_P1", Ownership{ProductID: "P1", OwnerHash: "owner1", SecurityHash: "secret1", Status: OwnershipStatusActive})
+		ctx := ledger.as("LuxuryRetailMSP")
+		if reference != nil {
+			ledger.stub.TxTimestamp = reference.stub.TxTimestamp
+		}
+		token, err := (&PrivacyContract{}).IssueVerificationToken(ctx, "P1", "owner1", "secret1", 3600)
+		require.NoError(t, err)
+		return ledger, token
+	}
+
+	first, firstToken := endorse(nil)
+	second, secondToken := endorse(first)
+	require.Equal(t, firstToken, secondToken)
+	require.Len(t, firstToken, verificationTokenSize)
+
+	key := verificationTokenKey(firstToken)
+	require.NotNil(t, first.stub.State[key])
+	require.Equal(t, string(first.stub.State[key]), string(second.stub.State[key]))
+	require.Equal(t, first.keys("verify_token_", "verify_token_~"), second.keys("verify_token_", "verify_token_~"))
+}
+
+func TestIssueVerificationTokenRequiresSeed(t *testing.T) {
+	ledger := newTestLedger(t)
+	ledger.put("ownership_P1", Ownership{ProductID: "P1", OwnerHash: "owner1", SecurityHash: "secret1", Status: OwnershipStatusActive})
+	ctx := ledger.as("LuxuryRetailMSP")
+	ledger.stub.TransientMap[tokenSeedTransientKey] = []byte("short")
+
+	_, err := (&PrivacyContract{}).IssueVerificationToken(ctx, "P1", "owner1", "secret1", 0)
+	require.True(t, hasErrorCode(err, ErrInvalidArgument), "got %v", err)
+	require.Empty(t, ledger.keys("verify_token_", "verify_token_~"))
+}
@@ -0,0 +1,158 @@
+package contracts
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// ConditionReport records who took a product out of sale as damaged or destroyed and
+// the evidence they gave
+type ConditionReport struct {
+	ReportedBy   string `json:"reportedBy"` // MSP ID
+	ReportedAt   string `json:"reportedAt"`
+	Reason       string `json:"reason"`
+	EvidenceHash string `json:"evidenceHash"` // e.g. IPFS hash of photos or an assessor's report
+	TxID         string `json:"txId"`
+}
+
+// newConditionReport validates a damage or destruction report and checks the caller may
+// file it: the product's holder, or the brand for any product, e.g. one returned by a
+// customer
+func (s *SupplyChainContract) newConditionReport(ctx contractapi.TransactionContextInterface,
+	productID string, reason string, evidenceHash string) (*Product, *ConditionReport, error) {
+
+	if err := validateAll(
+		validateID("productID", productID),
+		validateRequired("reason", reason, maxTextLength),
+		validateID("evidenceHash", evidenceHash),
+	); err != nil {
+		return nil, nil, err
+	}
+
+	caller, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get caller identity: %v", err)
+	}
+	product, err := s.GetProduct(ctx, productID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if product.CurrentOwner != caller {
+		if _, err := requireSuperAdmin(ctx); err != nil {
+			return nil, nil, newError(ErrPermissionDenied, "only the holder of product %s or the brand can report its condition", productID)
+		}
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	return product, &ConditionReport{
+		ReportedBy:   caller,
+		ReportedAt:   now.UTC().Format(time.RFC3339),
+		Reason:       reason,
+		EvidenceHash: evidenceHash,
+		TxID:         ctx.GetStub().GetTxID(),
+	}, nil
+}
+
+// conditionEvent builds the event for a product marked damaged or destroyed, carrying
+// what an insurer needs to open a claim without reading the product
+func conditionEvent(eventType string, product *Product, previousStatus ProductStatus,
+	report *ConditionReport) ChaincodeEvent {
+
+	return ChaincodeEvent{
+		EventType:  eventType,
+		EntityType: EventEntityProduct,
+		EntityID:   product.ID,
+		FromState:  string(previousStatus),
+		ToState:    string(product.Status),
+		Attributes: map[string]interface{}{
+			"reason":       report.Reason,
+			"evidenceHash": report.EvidenceHash,
+			"reportedBy":   report.ReportedBy,
+			"holder":       product.CurrentOwner,
+			"brand":        product.Brand,
+			"serialNumber": product.SerialNumber,
+			"batchId":      product.BatchID,
+		},
+	}
+}
+
+// MarkProductDamaged takes a damaged product out of sale. It is no longer shipped with
+// its batch or sold, and is later destroyed with MarkProductDestroyed or written off.
+// evidenceHash references the photos or assessment kept off-chain.
+func (s *SupplyChainContract) MarkProductDamaged(ctx contractapi.TransactionContextInterface,
+	productID string, reason string, evidenceHash string) error {
+
+	product, report, err := s.newConditionReport(ctx, productID, reason, evidenceHash)
+	if err != nil {
+		return err
+	}
+	if product.Status == ProductStatusDamaged {
+		return newError(ErrInvalidState, "product %s is already marked damaged", productID)
+	}
+
+	previousStatus := product.Status
+	if err := setProductStatus(product, ProductStatusDamaged); err != nil {
+		return err
+	}
+	product.Damage = report
+	product.CertifiedPreOwned = false // The certification vouched for the condition at inspection
+	err = putProduct(ctx, product)
+	if err != nil {
+		return err
+	}
+
+	return emitEvent(ctx, conditionEvent(EventProductDamaged, product, previousStatus, report))
+}
+
+// MarkProductDestroyed records that a product was destroyed. The product leaves its
+// batch's sellable quantity, so a batch whose other products are all sold is sold out.
+// With an insurerID, a destruction claim is opened against the product's policy with
+// that insurer, referencing the evidence.
+func (s *SupplyChainContract) MarkProductDestroyed(ctx contractapi.TransactionContextInterface,
+	productID string, reason string, evidenceHash string, insurerID string) error {
+
+	product, report, err := s.newConditionReport(ctx, productID, reason, evidenceHash)
+	if err != nil {
+		return err
+	}
+	if product.Status == ProductStatusDestroyed {
+		return newError(ErrInvalidState, "product %s is already destroyed", productID)
+	}
+
+	previousStatus := product.Status
+	if err := setProductStatus(product, ProductStatusDestroyed); err != nil {
+		return err
+	}
+	product.Destruction = report
+	err = putProduct(ctx, product)
+	if err != nil {
+		return err
+	}
+
+	var batchStatus BatchStatus
+	if product.BatchID != "" {
+		batchStatus, err = s.updateBatchStatus(ctx, product)
+		if err != nil {
+			return err
+		}
+	}
+
+	event := conditionEvent(EventProductDestroyed, product, previousStatus, report)
+	if batchStatus != "" {
+		event.Attributes["batchStatus"] = batchStatus
+	}
+	if insurerID != "" {
+		claimID, err := openInsuranceClaim(ctx, productID, insurerID, InsuranceLossDestruction, evidenceHash)
+		if err != nil {
+			return err
+		}
+		event.Attributes["insurerId"] = insurerID
+		event.Attributes["insuranceClaimId"] = claimID
+	}
+	return emitEvent(ctx, event)
+}
@@ -0,0 +1,253 @@
+package contracts
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Product templates are stored as template_<templateID>, outside the product_ range
+const productTemplateKeyPrefix = "template_"
+
+// maxTemplateMaterials caps the material lines of a bill of materials
+const maxTemplateMaterials = 50
+
+// templateQuantityTolerance absorbs rounding when batch materials are compared with a
+// template
+const templateQuantityTolerance = 1e-6
+
+// TemplateMaterial is one line of a bill of materials
+type TemplateMaterial struct {
+	MaterialType    string  `json:"materialType"`
+	QuantityPerUnit float64 `json:"quantityPerUnit"`
+}
+
+// ProductTemplate is a manufacturer's bill of materials for one product model. Batches
+// created from it consume the listed material types in proportion to their quantity.
+type ProductTemplate struct {
+	TemplateID    string             `json:"templateId"`
+	Name          string             `json:"name"`
+	Brand         string             `json:"brand"`
+	ProductType   string             `json:"productType"`
+	Materials     []TemplateMaterial `json:"materials"`
+	Owner         string             `json:"owner"` // Manufacturer that created and uses it
+	CreatedAt     string             `json:"createdAt"`
+	SchemaVersion int                `json:"schemaVersion"`
+}
+
+// getProductTemplate reads a product template
+func getProductTemplate(ctx contractapi.TransactionContextInterface, templateID string) (*ProductTemplate, error) {
+	templateJSON, err := ctx.GetStub().GetState(productTemplateKeyPrefix + templateID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read template: %v", err)
+	}
+	if templateJSON == nil {
+		return nil, newError(ErrNotFound, "template %s does not exist", templateID)
+	}
+
+	var template ProductTemplate
+	err = json.Unmarshal(templateJSON, &template)
+	if err != nil {
+		return nil, err
+	}
+	template.upgradeSchema()
+
+	return &template, nil
+}
+
+// CreateProductTemplate stores a bill of materials for batches of one product model.
+// materialsJSON lists the material types and the quantity each unit needs, e.g.
+// [{"materialType":"leather","quantityPerUnit":1.2},{"materialType":"thread","quantityPerUnit":0.05}].
+// Templates cannot be changed; a revised model gets a new template ID.
+func (s *SupplyChainContract) CreateProductTemplate(ctx contractapi.TransactionContextInterface,
+	templateID string, name string, brand string, productType string, materialsJSON string) error {
+
+	var materials []TemplateMaterial
+	if err := validateAll(
+		validateID("templateID", templateID),
+		validateName("name", name),
+		validateName("brand", brand),
+		validateName("productType", productType),
+		validateJSON("materials", materialsJSON, &materials),
+	); err != nil {
+		return err
+	}
+	if len(materials) == 0 {
+		return newError(ErrInvalidArgument, "a template needs at least one material")
+	}
+	if len(materials) > maxTemplateMaterials {
+		return newError(ErrInvalidArgument, "a template has at most %d materials", maxTemplateMaterials)
+	}
+	var materialTypes []string
+	for _, material := range materials {
+		if err := validateAll(
+			validateName("material type", material.MaterialType),
+			validateQuantity("quantity per unit", material.QuantityPerUnit),
+		); err != nil {
+			return err
+		}
+		materialType := strings.ToLower(material.MaterialType)
+		if containsString(materialTypes, materialType) {
+			return newError(ErrInvalidArgument, "material type %s is listed twice", material.MaterialType)
+		}
+		materialTypes = append(materialTypes, materialType)
+	}
+
+	manufacturer, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to get manufacturer identity: %v", err)
+	}
+	roleContract := &RoleManagementContract{}
+	hasPermission, err := roleContract.CheckPermission(ctx, manufacturer, "CREATE_BATCH")
+	if err != nil || !hasPermission {
+		return newError(ErrPermissionDenied, "caller %s does not have permission to create templates", manufacturer)
+	}
+
+	existing, err := ctx.GetStub().GetState(productTemplateKeyPrefix + templateID)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return newError(ErrAlreadyExists, "template %s already exists", templateID)
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	template := &ProductTemplate{
+		TemplateID:    templateID,
+		Name:          name,
+		Brand:         brand,
+		ProductType:   productType,
+		Materials:     materials,
+		Owner:         manufacturer,
+		CreatedAt:     now.UTC().Format(time.RFC3339),
+		SchemaVersion: CurrentSchemaVersion,
+	}
+	templateJSON, err := json.Marshal(template)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState(productTemplateKeyPrefix+templateID, templateJSON)
+	if err != nil {
+		return fmt.Errorf("failed to store template: %v", err)
+	}
+
+	return emitEvent(ctx, ChaincodeEvent{
+		EventType:  EventProductTemplateCreated,
+		EntityType: EventEntityTemplate,
+		EntityID:   templateID,
+		Attributes: map[string]interface{}{
+			"owner":         manufacturer,
+			"brand":         brand,
+			"productType":   productType,
+			"materialTypes": materialTypes,
+		},
+	})
+}
+
+// GetTemplate returns a product template
+func (s *SupplyChainContract) GetTemplate(ctx contractapi.TransactionContextInterface,
+	templateID string) (*ProductTemplate, error) {
+
+	if err := validateID("templateID", templateID); err != nil {
+		return nil, err
+	}
+	return getProductTemplate(ctx, templateID)
+}
+
+// batchTemplate returns the template a new batch is made from, which must belong to
+// the manufacturer and describe the batch's brand and product type
+func batchTemplate(ctx contractapi.TransactionContextInterface, templateID string, manufacturer string,
+	brand string, productType string) (*ProductTemplate, error) {
+
+	template, err := getProductTemplate(ctx, templateID)
+	if err != nil {
+		return nil, err
+	}
+	if template.Owner != manufacturer {
+		return nil, newError(ErrPermissionDenied, "template %s belongs to %s", templateID, template.Owner)
+	}
+	if template.Brand != brand || template.ProductType != productType {
+		return nil, newError(ErrInvalidArgument, "template %s is for %s %s, not %s %s",
+			templateID, template.Brand, template.ProductType, brand, productType)
+	}
+	return template, nil
+}
+
+// selectTemplateMaterials picks the manufacturer's lots that cover a template for
+// quantity units. Lots closest to expiry are used first; expired lots and regulated
+// lots without a valid certificate are skipped.
+func (s *SupplyChainContract) selectTemplateMaterials(ctx contractapi.TransactionContextInterface,
+	template *ProductTemplate, manufacturer string, quantity int, regulated *RegulatedMaterials,
+	now time.Time) ([]materialInput, error) {
+
+	inventories, err := s.GetMaterialInventoriesByOwner(ctx, manufacturer)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(inventories, func(i, j int) bool {
+		a, b := inventories[i].ExpiryDate, inventories[j].ExpiryDate
+		if a == "" || b == "" {
+			return b == "" && a != ""
+		}
+		return a < b
+	})
+
+	var selected []materialInput
+	for _, material := range template.Materials {
+		needed := material.QuantityPerUnit * float64(quantity)
+		for _, inventory := range inventories {
+			if needed <= templateQuantityTolerance {
+				break
+			}
+			if !strings.EqualFold(inventory.Type, material.MaterialType) || inventory.Available <= 0 {
+				continue
+			}
+			if materialExpired(inventory, now) || checkMaterialCertification(inventory, regulated, now) != nil {
+				continue
+			}
+			used := math.Min(needed, inventory.Available)
+			selected = append(selected, materialInput{ID: inventory.MaterialID, Quantity: used})
+			needed -= used
+		}
+		if needed > templateQuantityTolerance {
+			return nil, newError(ErrInsufficientInventory, "insufficient %s for template %s: %.2f more needed",
+				material.MaterialType, template.TemplateID, needed)
+		}
+	}
+	return selected, nil
+}
+
+// checkTemplateUsage verifies that a batch of quantity units uses exactly the material
+// types and quantities of its template
+func checkTemplateUsage(template *ProductTemplate, materialsUsed []MaterialUsage, quantity int) error {
+	used := make(map[string]float64)
+	for _, usage := range materialsUsed {
+		used[strings.ToLower(usage.MaterialType)] += usage.QuantityUsed
+	}
+	for _, material := range template.Materials {
+		materialType := strings.ToLower(material.MaterialType)
+		expected := material.QuantityPerUnit * float64(quantity)
+		if math.Abs(used[materialType]-expected) > templateQuantityTolerance*math.Max(1, expected) {
+			return newError(ErrInvalidArgument, "template %s needs %.2f %s for %d units, the materials give %.2f",
+				template.TemplateID, expected, material.MaterialType, quantity, used[materialType])
+		}
+		delete(used, materialType)
+	}
+	if len(used) > 0 {
+		extra := make([]string, 0, len(used))
+		for materialType := range used {
+			extra = append(extra, materialType)
+		}
+		sort.Strings(extra)
+		return newError(ErrInvalidArgument, "template %s does not use %s", template.TemplateID, strings.Join(extra, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,195 @@
+package contracts
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// QR codes are "LSCQR1.<payload>.<tag>", both parts unpadded base64url. The tag is an
+// HMAC of the payload keyed by the issuing transaction, so a valid code cannot be built
+// from a product ID alone; VerifyQRPayload compares it with the code on the certificate.
+const (
+	qrCodePrefix     = "LSCQR"
+	QRPayloadVersion = 1
+	maxQRCodeLength  = 1024
+)
+
+// QRPayload is the content of a product's QR code, or of a batch's when ProductID is empty
+type QRPayload struct {
+	Version   int    `json:"v"`
+	ProductID string `json:"pid,omitempty" metadata:",optional"`
+	BatchID   string `json:"bid,omitempty" metadata:",optional"`
+	IssuedAt  int64  `json:"iat"` // Unix seconds of the issuing transaction
+	Sequence  int    `json:"seq"` // Increases when a product is re-tagged, older codes are rejected
+}
+
+// QRVerification is the result of VerifyQRPayload
+type QRVerification struct {
+	Valid     bool          `json:"valid"`
+	Reason    string        `json:"reason,omitempty" metadata:",optional"` // Why the code was rejected
+	ProductID string        `json:"productId,omitempty" metadata:",optional"`
+	BatchID   string        `json:"batchId,omitempty" metadata:",optional"`
+	IssuedAt  string        `json:"issuedAt,omitempty" metadata:",optional"`
+	Sequence  int           `json:"sequence,omitempty" metadata:",optional"`
+	Status    ProductStatus `json:"status,omitempty" metadata:",optional"` // Current product status, e.g. STOLEN
+}
+
+// RetiredCode is a QR code that was replaced, see ReissueProductIdentifier
+type RetiredCode struct {
+	Code      string `json:"code"`
+	RetiredAt string `json:"retiredAt"`
+	ReissueID string `json:"reissueId"` // Request that replaced it
+}
+
+// issueQRCode builds and tags the QR code of a product, or of a batch when productID is
+// empty, for the current transaction
+func issueQRCode(ctx contractapi.TransactionContextInterface,
+	productID string, batchID string, sequence int) (string, error) {
+
+	timestamp, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return "", fmt.Errorf("failed to read transaction timestamp: %v", err)
+	}
+
+	payloadJSON, err := json.Marshal(QRPayload{
+		Version:   QRPayloadVersion,
+		ProductID: productID,
+		BatchID:   batchID,
+		IssuedAt:  timestamp.GetSeconds(),
+		Sequence:  sequence,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	header := fmt.Sprintf("%s%d", qrCodePrefix, QRPayloadVersion)
+	payload := base64.RawURLEncoding.EncodeToString(payloadJSON)
+	subject := productID
+	if subject == "" {
+		subject = batchID
+	}
+	key := sha256.Sum256([]byte(ctx.GetStub().GetTxID() + "|" + subject))
+	mac := hmac.New(sha256.New, key[:])
+	mac.Write([]byte(header + "." + payload))
+	tag := base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+
+	return header + "." + payload + "." + tag, nil
+}
+
+// parseQRCode decodes the payload of a QR code without checking its tag
+func parseQRCode(code string) (*QRPayload, error) {
+	parts := strings.Split(code, ".")
+	if len(parts) != 3 || parts[0] != fmt.Sprintf("%s%d", qrCodePrefix, QRPayloadVersion) {
+		return nil, fmt.Errorf("unsupported QR code format")
+	}
+
+	payloadJSON, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("QR payload is not valid base64url")
+	}
+	var payload QRPayload
+	if err := json.Unmarshal(payloadJSON, &payload); err != nil {
+		return nil, fmt.Errorf("QR payload is not valid JSON")
+	}
+	if payload.Version != QRPayloadVersion || (payload.ProductID == "" && payload.BatchID == "") {
+		return nil, fmt.Errorf("QR payload is incomplete")
+	}
+
+	return &payload, nil
+}
+
+// qrMismatchReason explains why a scanned code differs from the issued one
+func qrMismatchReason(entity string, code string, scanned *QRPayload, issued string, retired []RetiredCode) string {
+	for _, old := range retired {
+		if hmac.Equal([]byte(code), []byte(old.Code)) {
+			return fmt.Sprintf("QR code was retired on %s and replaced by a newer one", old.RetiredAt)
+		}
+	}
+	current, err := parseQRCode(issued)
+	if err == nil && scanned.Sequence < current.Sequence {
+		return "QR code was replaced by a newer one"
+	}
+	return "QR code does not match the code issued for the " + entity
+}
+
+// VerifyQRPayload checks a scanned QR code against the code issued for its product or
+// batch. Forged codes and codes replaced by a newer one are reported as invalid with a reason.
+func (o *OwnershipContract) VerifyQRPayload(ctx contractapi.TransactionContextInterface,
+	code string) (*QRVerification, error) {
+
+	if err := validateRequired("code", code, maxQRCodeLength); err != nil {
+		return nil, err
+	}
+
+	payload, err := parseQRCode(code)
+	if err != nil {
+		return &QRVerification{Valid: false, Reason: err.Error()}, nil
+	}
+
+	result := &QRVerification{
+		ProductID: payload.ProductID,
+		BatchID:   payload.BatchID,
+		IssuedAt:  time.Unix(payload.IssuedAt, 0).UTC().Format(time.RFC3339),
+		Sequence:  payload.Sequence,
+	}
+	if payload.ProductID == "" {
+		return o.verifyBatchQRCode(ctx, code, payload, result)
+	}
+	if validateID("productID", payload.ProductID) != nil {
+		result.Reason = "QR payload names an invalid product"
+		return result, nil
+	}
+
+	certificate, err := o.GetBirthCertificate(ctx, payload.ProductID)
+	if err != nil {
+		result.Reason = "no birth certificate exists for the product"
+		return result, nil
+	}
+
+	issued := certificate.Authenticity.QRCodeData
+	if !hmac.Equal([]byte(code), []byte(issued)) {
+		result.Reason = qrMismatchReason("product", code, payload, issued, certificate.Authenticity.RetiredQRCodes)
+		return result, nil
+	}
+
+	supplyChain := &SupplyChainContract{}
+	product, err := supplyChain.GetProduct(ctx, payload.ProductID)
+	if err != nil {
+		return nil, err
+	}
+
+	result.Valid = true
+	result.Status = product.Status
+	return result, nil
+}
+
+// verifyBatchQRCode checks a scanned batch QR code against the batch's current code
+func (o *OwnershipContract) verifyBatchQRCode(ctx contractapi.TransactionContextInterface,
+	code string, payload *QRPayload, result *QRVerification) (*QRVerification, error) {
+
+	if validateID("batchID", payload.BatchID) != nil {
+		result.Reason = "QR payload names an invalid batch"
+		return result, nil
+	}
+
+	supplyChain := &SupplyChainContract{}
+	batch, err := supplyChain.GetBatch(ctx, payload.BatchID)
+	if err != nil {
+		result.Reason = "the batch does not exist"
+		return result, nil
+	}
+	if !hmac.Equal([]byte(code), []byte(batch.QRCode)) {
+		result.Reason = qrMismatchReason("batch", code, payload, batch.QRCode, batch.RetiredQRCodes)
+		return result, nil
+	}
+
+	result.Valid = true
+	return result, nil
+}
@@ -0,0 +1,357 @@
+package contracts
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// ResaleContract handles brand-certified resale of pre-owned products
+type ResaleContract struct {
+	contractapi.Contract
+}
+
+// resaleCertificationKeyPrefix keys the resale certification request of a product
+const resaleCertificationKeyPrefix = "resale_cert_"
+
+// ResaleCertificationStatus is the state of a resale certification request
+type ResaleCertificationStatus string
+
+const (
+	ResaleCertificationRequested ResaleCertificationStatus = "REQUESTED"
+	ResaleCertificationCertified ResaleCertificationStatus = "CERTIFIED"
+	ResaleCertificationRejected  ResaleCertificationStatus = "REJECTED"
+)
+
+// ResaleCertificationRequest is an owner's request to have a product authenticated and
+// certified pre-owned (CPO) before listing it for resale. A product has one request at
+// a time; a new one replaces a decided one.
+type ResaleCertificationRequest struct {
+	ProductID            string                    `json:"productId"`
+	Status               ResaleCertificationStatus `json:"status"`
+	OwnerHash            string                    `json:"ownerHash"` // Requesting owner, so a request does not outlive a sale
+	RequestedAt          string                    `json:"requestedAt"`
+	DecidedBy            string                    `json:"decidedBy,omitempty" metadata:",optional"` // MSP ID of the retailer or service center
+	DecidedAt            string                    `json:"decidedAt,omitempty" metadata:",optional"`
+	InspectionReportHash string                    `json:"inspectionReportHash,omitempty" metadata:",optional"` // e.g. IPFS hash of the inspection report
+	CertificateRevision  int                       `json:"certificateRevision,omitempty" metadata:",optional"`  // Set when certified
+	Reason               string                    `json:"reason,omitempty" metadata:",optional"`               // Set when rejected
+	SchemaVersion        int                       `json:"schemaVersion"`
+}
+
+// ResaleCertification is a CPO certification recorded on the birth certificate. Each
+// one is a new revision of the certificate. The product's latest appraisal is carried
+// along if it was still valid at certification.
+type ResaleCertification struct {
+	Revision             int    `json:"revision"` // Certificate revision the certification created
+	CertifiedBy          string `json:"certifiedBy"`
+	CertifiedAt          string `json:"certifiedAt"`
+	InspectionReportHash string `json:"inspectionReportHash"`
+	TxID                 string `json:"txId"`
+	ConditionGrade       string `json:"conditionGrade,omitempty" metadata:",optional"`
+	AppraisalHash        string `json:"appraisalHash,omitempty" metadata:",optional"`
+}
+
+// RequestResaleCertification lets a product's owner ask for brand-certified resale. A
+// retailer or service center then authenticates the product and calls CertifyForResale
+// or RejectResaleCertification.
+func (r *ResaleContract) RequestResaleCertification(ctx contractapi.TransactionContextInterface,
+	productID string, ownerHash string, securityHash string) error {
+
+	if err := validateAll(
+		validateID("productID", productID),
+		validateID("ownerHash", ownerHash),
+		validateID("securityHash", securityHash),
+	); err != nil {
+		return err
+	}
+
+	ownershipContract := &OwnershipContract{}
+	ownership, err := ownershipContract.GetOwnership(ctx, productID)
+	if err != nil {
+		return err
+	}
+	if ownership.OwnerHash != ownerHash || ownership.SecurityHash != securityHash {
+		return newError(ErrPermissionDenied, "ownership verification failed")
+	}
+	if ownership.Status != OwnershipStatusActive {
+		return newError(ErrInvalidState, "ownership of product %s is %s", productID, ownership.Status)
+	}
+
+	supplyChain := &SupplyChainContract{}
+	product, err := supplyChain.GetProduct(ctx, productID)
+	if err != nil {
+		return err
+	}
+	if product.Status != ProductStatusSold {
+		return newError(ErrInvalidState, "product %s is %s, not %s", productID, product.Status, ProductStatusSold)
+	}
+	if err := checkStolenProduct(ctx, product); err != nil {
+		return err
+	}
+	if err := checkCertificateNotRevoked(product); err != nil {
+		return err
+	}
+
+	existing, err := getResaleCertificationRequest(ctx, productID)
+	if err != nil && !hasErrorCode(err, ErrNotFound) {
+		return err
+	}
+	if existing != nil && existing.Status == ResaleCertificationRequested && existing.OwnerHash == ownerHash {
+		return newError(ErrAlreadyExists, "product %s already has a resale certification request pending", productID)
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	request := &ResaleCertificationRequest{
+		ProductID:     productID,
+		Status:        ResaleCertificationRequested,
+		OwnerHash:     ownerHash,
+		RequestedAt:   now.UTC().Format(time.RFC3339),
+		SchemaVersion: CurrentSchemaVersion,
+	}
+	if err := putResaleCertificationRequest(ctx, request); err != nil {
+		return err
+	}
+
+	return emitEvent(ctx, ChaincodeEvent{
+		EventType:  EventResaleCertificationRequested,
+		EntityType: EventEntityProduct,
+		EntityID:   productID,
+		ToState:    string(request.Status),
+		Attributes: map[string]interface{}{
+			"brand":        product.Brand,
+			"serialNumber": product.SerialNumber,
+		},
+	})
+}
+
+// CertifyForResale records that a retailer or service center authenticated a product
+// with a pending resale certification request. The product becomes certified pre-owned
+// and the birth certificate gains a revision carrying the inspection report hash.
+func (r *ResaleContract) CertifyForResale(ctx contractapi.TransactionContextInterface,
+	productID string, inspectionReportHash string) error {
+
+	if err := validateAll(
+		validateID("productID", productID),
+		validateID("inspectionReportHash", inspectionReportHash),
+	); err != nil {
+		return err
+	}
+
+	caller, request, product, err := r.pendingResaleCertification(ctx, productID)
+	if err != nil {
+		return err
+	}
+	if err := checkStolenProduct(ctx, product); err != nil {
+		return err
+	}
+	if err := checkCertificateNotRevoked(product); err != nil {
+		return err
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	decidedAt := now.UTC().Format(time.RFC3339)
+
+	ownershipContract := &OwnershipContract{}
+	certificate, err := ownershipContract.GetBirthCertificate(ctx, productID)
+	if err != nil {
+		return err
+	}
+	certification := ResaleCertification{
+		Revision:             certificateRevision(certificate) + 1,
+		CertifiedBy:          caller,
+		CertifiedAt:          decidedAt,
+		InspectionReportHash: inspectionReportHash,
+		TxID:                 ctx.GetStub().GetTxID(),
+	}
+	appraisal, err := latestAppraisal(ctx, productID)
+	if err != nil {
+		return err
+	}
+	if appraisal != nil && appraisal.valid(now) {
+		certification.ConditionGrade = appraisal.ConditionGrade
+		certification.AppraisalHash = appraisal.AppraisalHash
+	}
+	revision := certification.Revision
+	certificate.ResaleCertifications = append(certificate.ResaleCertifications, certification)
+	if err := recommitCertificate(ctx, certificate, "certified pre-owned"); err != nil {
+		return err
+	}
+
+	// The inspection is an authentication, so it also answers a request from a transfer
+	authenticationRequestCleared := product.AuthenticationRequest != nil
+	product.AuthenticationRequest = nil
+	product.CertifiedPreOwned = true
+	if err := putProduct(ctx, product); err != nil {
+		return err
+	}
+
+	request.Status = ResaleCertificationCertified
+	request.DecidedBy = caller
+	request.DecidedAt = decidedAt
+	request.InspectionReportHash = inspectionReportHash
+	request.CertificateRevision = revision
+	if err := putResaleCertificationRequest(ctx, request); err != nil {
+		return err
+	}
+
+	event := ChaincodeEvent{
+		EventType:  EventProductCertifiedPreOwned,
+		EntityType: EventEntityProduct,
+		EntityID:   productID,
+		FromState:  string(ResaleCertificationRequested),
+		ToState:    string(request.Status),
+		Attributes: map[string]interface{}{
+			"certifiedBy":                  caller,
+			"inspectionReportHash":         inspectionReportHash,
+			"certificateRevision":          revision,
+			"certificateHash":              certificate.CertificateHash,
+			"authenticationRequestCleared": authenticationRequestCleared,
+		},
+	}
+	if certification.ConditionGrade != "" {
+		event.Attributes["conditionGrade"] = certification.ConditionGrade
+	}
+	return emitEvent(ctx, event)
+}
+
+// RejectResaleCertification closes a resale certification request when the product
+// fails authentication or inspection. The owner may request certification again.
+func (r *ResaleContract) RejectResaleCertification(ctx contractapi.TransactionContextInterface,
+	productID string, inspectionReportHash string, reason string) error {
+
+	if err := validateAll(
+		validateID("productID", productID),
+		validateID("inspectionReportHash", inspectionReportHash),
+		validateRequired("reason", reason, maxTextLength),
+	); err != nil {
+		return err
+	}
+
+	caller, request, _, err := r.pendingResaleCertification(ctx, productID)
+	if err != nil {
+		return err
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	request.Status = ResaleCertificationRejected
+	request.DecidedBy = caller
+	request.DecidedAt = now.UTC().Format(time.RFC3339)
+	request.InspectionReportHash = inspectionReportHash
+	request.Reason = reason
+	if err := putResaleCertificationRequest(ctx, request); err != nil {
+		return err
+	}
+
+	return emitEvent(ctx, ChaincodeEvent{
+		EventType:  EventResaleCertificationRejected,
+		EntityType: EventEntityProduct,
+		EntityID:   productID,
+		FromState:  string(ResaleCertificationRequested),
+		ToState:    string(request.Status),
+		Attributes: map[string]interface{}{
+			"rejectedBy":           caller,
+			"inspectionReportHash": inspectionReportHash,
+			"reason":               reason,
+		},
+	})
+}
+
+// GetResaleCertificationRequest returns a product's latest resale certification request
+func (r *ResaleContract) GetResaleCertificationRequest(ctx contractapi.TransactionContextInterface,
+	productID string) (*ResaleCertificationRequest, error) {
+
+	if err := validateID("productID", productID); err != nil {
+		return nil, err
+	}
+	return getResaleCertificationRequest(ctx, productID)
+}
+
+// pendingResaleCertification checks the caller may authenticate products for resale
+// and returns the product's pending request. A request made by a previous owner is
+// no longer pending.
+func (r *ResaleContract) pendingResaleCertification(ctx contractapi.TransactionContextInterface,
+	productID string) (string, *ResaleCertificationRequest, *Product, error) {
+
+	caller, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("failed to get caller identity: %v", err)
+	}
+	roleContract := &RoleManagementContract{}
+	hasPermission, err := roleContract.CheckPermission(ctx, caller, "ADD_SERVICE_RECORD")
+	if err != nil || !hasPermission {
+		return "", nil, nil, newError(ErrPermissionDenied, "caller %s does not have permission to certify products for resale", caller)
+	}
+
+	request, err := getResaleCertificationRequest(ctx, productID)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	if request.Status != ResaleCertificationRequested {
+		return "", nil, nil, newError(ErrInvalidState, "resale certification of product %s is %s", productID, request.Status)
+	}
+
+	ownershipContract := &OwnershipContract{}
+	ownership, err := ownershipContract.GetOwnership(ctx, productID)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	if ownership.OwnerHash != request.OwnerHash {
+		return "", nil, nil, newError(ErrInvalidState, "product %s changed owner since resale certification was requested", productID)
+	}
+
+	supplyChain := &SupplyChainContract{}
+	product, err := supplyChain.GetProduct(ctx, productID)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	if product.Status != ProductStatusSold {
+		return "", nil, nil, newError(ErrInvalidState, "product %s is %s, not %s", productID, product.Status, ProductStatusSold)
+	}
+	return caller, request, product, nil
+}
+
+func getResaleCertificationRequest(ctx contractapi.TransactionContextInterface,
+	productID string) (*ResaleCertificationRequest, error) {
+
+	requestJSON, err := ctx.GetStub().GetState(resaleCertificationKeyPrefix + productID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read resale certification request: %v", err)
+	}
+	if requestJSON == nil {
+		return nil, newError(ErrNotFound, "product %s has no resale certification request", productID)
+	}
+
+	var request ResaleCertificationRequest
+	err = json.Unmarshal(requestJSON, &request)
+	if err != nil {
+		return nil, err
+	}
+	request.upgradeSchema()
+	return &request, nil
+}
+
+func putResaleCertificationRequest(ctx contractapi.TransactionContextInterface,
+	request *ResaleCertificationRequest) error {
+
+	requestJSON, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState(resaleCertificationKeyPrefix+request.ProductID, requestJSON)
+	if err != nil {
+		return fmt.Errorf("failed to store resale certification request: %v", err)
+	}
+	return nil
+}
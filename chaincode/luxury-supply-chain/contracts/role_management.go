@@ -53,6 +53,7 @@ func (r *RoleManagementContract) InitializeRoles(ctx contractapi.TransactionCont
 	
 	// Store organization roles
 	for _, org := range organizations {
+		org.SchemaVersion = CurrentSchemaVersion
 		orgKey := "org_role_" + org.MSPID
 		orgJSON, err := json.Marshal(org)
 		if err != nil {
@@ -67,6 +68,7 @@ func (r *RoleManagementContract) InitializeRoles(ctx contractapi.TransactionCont
 	
 	// Also make LuxeBags the warehouse since it has dual role
 	warehouseOrg := OrganizationInfo{
+		SchemaVersion: CurrentSchemaVersion,
 		MSPID:      "LuxeBagsMSP",
 		Name:       "LuxeBags Warehouse",
 		Role:       RoleWarehouse,
@@ -94,6 +96,13 @@ func (r *RoleManagementContract) InitializeRoles(ctx contractapi.TransactionCont
 func (r *RoleManagementContract) AssignRole(ctx contractapi.TransactionContextInterface,
 	targetMSPID string, role string, organizationName string) error {
 	
+	if err := validateAll(
+		validateID("targetMSPID", targetMSPID),
+		validateName("organizationName", organizationName),
+	); err != nil {
+		return err
+	}
+
 	// Get caller identity
 	callerMSP, err := ctx.GetClientIdentity().GetMSPID()
 	if err != nil {
@@ -103,11 +112,11 @@ func (r *RoleManagementContract) AssignRole(ctx contractapi.TransactionContextIn
 	// Check if caller is super admin
 	callerOrg, err := r.GetOrganizationInfo(ctx, callerMSP)
 	if err != nil {
-		return fmt.Errorf("failed to get caller organization info: %v", err)
+		return wrapError(err, "failed to get caller organization info")
 	}
 	
 	if callerOrg.Role != RoleSuperAdmin {
-		return fmt.Errorf("only super admin can assign organization roles")
+		return newError(ErrPermissionDenied, "only super admin can assign organization roles")
 	}
 	
 	// Parse the role
@@ -121,18 +130,33 @@ func (r *RoleManagementContract) AssignRole(ctx contractapi.TransactionContextIn
 		orgRole = RoleWarehouse
 	case "RETAILER":
 		orgRole = RoleRetailer
+	case "CUSTOMS":
+		orgRole = RoleCustoms
+	case "LOGISTICS":
+		orgRole = RoleLogistics
+	case "CHARITY":
+		orgRole = RoleCharity
+	case "CARRIER":
+		orgRole = RoleCarrier
+	case "LAW_ENFORCEMENT":
+		orgRole = RoleLawEnforcement
+	case "INSURER":
+		orgRole = RoleInsurer
+	case "APPRAISER":
+		orgRole = RoleAppraiser
 	case "SUPER_ADMIN":
 		// Only allow super admin to assign super admin role with extra check
 		if callerMSP != "LuxeBagsMSP" {
-			return fmt.Errorf("cannot assign super admin role")
+			return newError(ErrPermissionDenied, "cannot assign super admin role")
 		}
 		orgRole = RoleSuperAdmin
 	default:
-		return fmt.Errorf("invalid role: %s", role)
+		return newError(ErrInvalidArgument, "invalid role: %s", role)
 	}
 	
 	// Create or update organization info
 	orgInfo := OrganizationInfo{
+		SchemaVersion: CurrentSchemaVersion,
 		MSPID:      targetMSPID,
 		Name:       organizationName,
 		Role:       orgRole,
@@ -140,6 +164,13 @@ func (r *RoleManagementContract) AssignRole(ctx contractapi.TransactionContextIn
 		AssignedAt: time.Now().Format(time.RFC3339),
 		IsActive:   true,
 	}
+
+	// A role change keeps the DID the organization registered itself
+	if existing, err := r.GetOrganizationInfo(ctx, targetMSPID); err == nil {
+		orgInfo.DID = existing.DID
+		orgInfo.VerificationKeys = existing.VerificationKeys
+		orgInfo.DIDUpdatedAt = existing.DIDUpdatedAt
+	}
 	
 	// Store organization role
 	orgKey := "org_role_" + targetMSPID
@@ -153,16 +184,32 @@ func (r *RoleManagementContract) AssignRole(ctx contractapi.TransactionContextIn
 		return err
 	}
 	
-	// Emit event
-	ctx.GetStub().SetEvent("OrganizationRoleAssigned", orgJSON)
+	// The role only tunes trust decay in consensus, so a failure must not block the assignment
+	consensus, err := LoadConsensusIntegration(ctx)
+	if err != nil {
+		return err
+	}
+	if err := consensus.NotifyConsensusOfRole(ctx, targetMSPID, orgRole); err != nil {
+		logFor(ctx).Warn("failed to record role in consensus", "mspId", targetMSPID, "error", err)
+	}
 	
-	return nil
+	// Emit event
+	return emitEvent(ctx, ChaincodeEvent{
+		EventType:  EventOrganizationRoleAssigned,
+		EntityType: EventEntityOrganization,
+		EntityID:   targetMSPID,
+		ToState:    string(orgRole),
+	})
 }
 
 // RevokeRole deactivates an organization's role
 func (r *RoleManagementContract) RevokeRole(ctx contractapi.TransactionContextInterface,
 	targetMSPID string) error {
 	
+	if err := validateID("targetMSPID", targetMSPID); err != nil {
+		return err
+	}
+
 	// Get caller identity
 	callerMSP, err := ctx.GetClientIdentity().GetMSPID()
 	if err != nil {
@@ -172,16 +219,16 @@ func (r *RoleManagementContract) RevokeRole(ctx contractapi.TransactionContextIn
 	// Check if caller is super admin
 	callerOrg, err := r.GetOrganizationInfo(ctx, callerMSP)
 	if err != nil {
-		return fmt.Errorf("failed to get caller organization info: %v", err)
+		return wrapError(err, "failed to get caller organization info")
 	}
 	
 	if callerOrg.Role != RoleSuperAdmin {
-		return fmt.Errorf("only super admin can revoke organization roles")
+		return newError(ErrPermissionDenied, "only super admin can revoke organization roles")
 	}
 	
 	// Cannot revoke super admin's own role
 	if targetMSPID == "LuxeBagsMSP" {
-		return fmt.Errorf("cannot revoke super admin role")
+		return newError(ErrPermissionDenied, "cannot revoke super admin role")
 	}
 	
 	// Get target organization
@@ -207,6 +254,10 @@ func (r *RoleManagementContract) RevokeRole(ctx contractapi.TransactionContextIn
 func (r *RoleManagementContract) GetOrganizationInfo(ctx contractapi.TransactionContextInterface,
 	mspID string) (*OrganizationInfo, error) {
 	
+	if err := validateID("mspID", mspID); err != nil {
+		return nil, err
+	}
+
 	orgKey := "org_role_" + mspID
 	orgJSON, err := ctx.GetStub().GetState(orgKey)
 	if err != nil {
@@ -214,7 +265,7 @@ func (r *RoleManagementContract) GetOrganizationInfo(ctx contractapi.Transaction
 	}
 	
 	if orgJSON == nil {
-		return nil, fmt.Errorf("organization %s not found", mspID)
+		return nil, newError(ErrNotFound, "organization %s not found", mspID)
 	}
 	
 	var orgInfo OrganizationInfo
@@ -222,6 +273,7 @@ func (r *RoleManagementContract) GetOrganizationInfo(ctx contractapi.Transaction
 	if err != nil {
 		return nil, err
 	}
+	orgInfo.upgradeSchema()
 	
 	return &orgInfo, nil
 }
@@ -230,6 +282,10 @@ func (r *RoleManagementContract) GetOrganizationInfo(ctx contractapi.Transaction
 func (r *RoleManagementContract) GetOrganizationRole(ctx contractapi.TransactionContextInterface,
 	mspID string) (OrganizationRole, error) {
 	
+	if err := validateID("mspID", mspID); err != nil {
+		return "", err
+	}
+
 	orgInfo, err := r.GetOrganizationInfo(ctx, mspID)
 	if err != nil {
 		// Check secondary role for LuxeBags (warehouse)
@@ -242,7 +298,7 @@ func (r *RoleManagementContract) GetOrganizationRole(ctx contractapi.Transaction
 				return secondaryOrg.Role, nil
 			}
 		}
-		return "", fmt.Errorf("organization role not found for %s", mspID)
+		return "", newError(ErrNotFound, "organization role not found for %s", mspID)
 	}
 	
 	return orgInfo.Role, nil
@@ -293,10 +349,24 @@ func (r *RoleManagementContract) GetOrganizationsByRole(ctx contractapi.Transact
 		targetRole = RoleWarehouse
 	case "RETAILER":
 		targetRole = RoleRetailer
+	case "CUSTOMS":
+		targetRole = RoleCustoms
+	case "LOGISTICS":
+		targetRole = RoleLogistics
+	case "CHARITY":
+		targetRole = RoleCharity
+	case "CARRIER":
+		targetRole = RoleCarrier
+	case "LAW_ENFORCEMENT":
+		targetRole = RoleLawEnforcement
+	case "INSURER":
+		targetRole = RoleInsurer
+	case "APPRAISER":
+		targetRole = RoleAppraiser
 	case "SUPER_ADMIN":
 		targetRole = RoleSuperAdmin
 	default:
-		return nil, fmt.Errorf("invalid role: %s", role)
+		return nil, newError(ErrInvalidArgument, "invalid role: %s", role)
 	}
 	
 	// Get all organizations
@@ -333,13 +403,17 @@ func (r *RoleManagementContract) GetOrganizationsByRole(ctx contractapi.Transact
 func (r *RoleManagementContract) CheckPermission(ctx contractapi.TransactionContextInterface,
 	mspID string, action string) (bool, error) {
 	
+	if err := validateID("mspID", mspID); err != nil {
+		return false, err
+	}
+
 	orgInfo, err := r.GetOrganizationInfo(ctx, mspID)
 	if err != nil {
 		return false, err
 	}
 	
 	if !orgInfo.IsActive {
-		return false, fmt.Errorf("organization %s is not active", mspID)
+		return false, newError(ErrPermissionDenied, "organization %s is not active", mspID)
 	}
 	
 	// Define permissions based on roles
@@ -381,12 +455,36 @@ func (r *RoleManagementContract) CheckPermission(ctx contractapi.TransactionCont
 			"VERIFY_PRODUCT",
 			"ADD_SERVICE_RECORD",
 		},
+		RoleCustoms: {
+			"RECORD_DUTY",
+		},
+		RoleLogistics: {
+			"RECORD_DUTY",
+		},
+		RoleCharity: {
+			"CONFIRM_RECEIVED",
+			"VIEW_INVENTORY",
+			"TAKE_OWNERSHIP",
+		},
+		RoleCarrier: {
+			"RESPOND_DAMAGE_CLAIM",
+		},
+		RoleLawEnforcement: {
+			"QUERY_STOLEN_GOODS",
+		},
+		RoleInsurer: {
+			"REGISTER_INSURANCE_POLICY",
+			"SETTLE_INSURANCE_CLAIM",
+		},
+		RoleAppraiser: {
+			"ADD_APPRAISAL",
+		},
 	}
 	
 	// Check if role has permission
 	rolePermissions, exists := permissions[orgInfo.Role]
 	if !exists {
-		return false, fmt.Errorf("unknown role: %s", orgInfo.Role)
+		return false, newError(ErrInvalidState, "unknown role: %s", orgInfo.Role)
 	}
 	
 	// Super admin can do everything
@@ -402,4 +500,19 @@ func (r *RoleManagementContract) CheckPermission(ctx contractapi.TransactionCont
 	}
 	
 	return false, nil
-}
\ No newline at end of file
+}
+// requireSuperAdmin returns the caller's MSP ID, or a PERMISSION_DENIED error unless
+// the caller is the super admin organization, the brand
+func requireSuperAdmin(ctx contractapi.TransactionContextInterface) (string, error) {
+	caller, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return "", fmt.Errorf("failed to get caller identity: %v", err)
+	}
+
+	roleContract := &RoleManagementContract{}
+	callerRole, err := roleContract.GetOrganizationRole(ctx, caller)
+	if err != nil || callerRole != RoleSuperAdmin {
+		return "", newError(ErrPermissionDenied, "caller %s is not the super admin organization", caller)
+	}
+	return caller, nil
+}
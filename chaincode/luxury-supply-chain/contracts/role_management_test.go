@@ -0,0 +1,20 @@
+package contracts
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequireSuperAdmin(t *testing.T) {
+	ledger := newTestLedger(t)
+
+	caller, err := requireSuperAdmin(ledger.as("LuxeBagsMSP"))
+	require.NoError(t, err)
+	require.Equal(t, "LuxeBagsMSP", caller)
+
+	for _, mspID := range []string{"LuxuryRetailMSP", "CraftWorkshopMSP", "UnknownMSP"} {
+		_, err := requireSuperAdmin(ledger.as(mspID))
+		require.True(t, hasErrorCode(err, ErrPermissionDenied), "%s: got %v", mspID, err)
+	}
+}
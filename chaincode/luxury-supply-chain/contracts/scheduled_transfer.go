@@ -0,0 +1,177 @@
+package contracts
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Scheduled transfers are indexed per receiver as scheduled_<receiver>_<effectiveAt>_<transferID>.
+// effectiveAt is RFC3339 in UTC, so the entries due at a time are a key range.
+const scheduledTransferKeyPrefix = "scheduled_"
+
+// maxActivatedTransfers limits the transfers one ActivateScheduledTransfers call activates
+const maxActivatedTransfers = 100
+
+// scheduledTransferKey returns the index key of a scheduled transfer
+func scheduledTransferKey(receiver string, effectiveAt string, transferID string) string {
+	return scheduledTransferKeyPrefix + receiver + "_" + effectiveAt + "_" + transferID
+}
+
+// checkTransferEffective rejects the receipt of a scheduled transfer before its effective date
+func checkTransferEffective(ctx contractapi.TransactionContextInterface, transfer *Transfer) error {
+	if transfer.EffectiveAt == "" {
+		return nil
+	}
+
+	effectiveAt, err := time.Parse(time.RFC3339, transfer.EffectiveAt)
+	if err != nil {
+		return newError(ErrInvalidState, "transfer %s has an invalid effective date", transfer.ID)
+	}
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	if now.Before(effectiveAt) {
+		return newError(ErrInvalidState, "transfer %s cannot be received before %s", transfer.ID, transfer.EffectiveAt)
+	}
+	return nil
+}
+
+// ScheduleTransfer sets the date from which the receiver may confirm receipt of a
+// transfer, e.g. for stock embargoed until a launch, and counts the transfer's timeout
+// from it. The sender calls it before sending; an empty effectiveAt removes the date again.
+func (s *SupplyChainContract) ScheduleTransfer(ctx contractapi.TransactionContextInterface,
+	transferID string, effectiveAt string) error {
+
+	if err := validateID("transferID", transferID); err != nil {
+		return err
+	}
+
+	transfer, err := s.GetTransfer(ctx, transferID)
+	if err != nil {
+		return err
+	}
+
+	sender, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to get sender identity: %v", err)
+	}
+	if transfer.From != sender {
+		return newError(ErrPermissionDenied, "only the sender can schedule a transfer")
+	}
+	if transfer.Status != TransferStatusInitiated {
+		return newError(ErrInvalidState, "a transfer must be scheduled before it is sent, status is %s", transfer.Status)
+	}
+
+	effective := ""
+	if effectiveAt != "" {
+		parsed, err := time.Parse(time.RFC3339, effectiveAt)
+		if err != nil {
+			return newError(ErrInvalidArgument, "effectiveAt must be an RFC3339 time")
+		}
+		now, err := txTime(ctx)
+		if err != nil {
+			return err
+		}
+		if !parsed.After(now) {
+			return newError(ErrInvalidArgument, "effectiveAt must be in the future")
+		}
+		effective = parsed.UTC().Format(time.RFC3339)
+	}
+
+	if transfer.EffectiveAt != "" {
+		err = ctx.GetStub().DelState(scheduledTransferKey(transfer.To, transfer.EffectiveAt, transferID))
+		if err != nil {
+			return fmt.Errorf("failed to update scheduled transfer index: %v", err)
+		}
+	}
+	if effective != "" {
+		// Empty values are deletes in Fabric, so the entry holds the transfer ID
+		err = ctx.GetStub().PutState(scheduledTransferKey(transfer.To, effective, transferID), []byte(transferID))
+		if err != nil {
+			return fmt.Errorf("failed to update scheduled transfer index: %v", err)
+		}
+	}
+	transfer.EffectiveAt = effective
+	transfer.ActivatedAt = ""
+	transfer.ConsensusDetails.TimeoutAt, err = transferTimeoutAt(ctx, transfer)
+	if err != nil {
+		return err
+	}
+
+	err = putTransfer(ctx, transfer)
+	if err != nil {
+		return err
+	}
+
+	event := transferEvent(EventTransferScheduled, transfer, "")
+	event.Attributes["effectiveAt"] = effective
+	return emitEvent(ctx, event)
+}
+
+// ActivateScheduledTransfers marks the scheduled transfers to an organization whose
+// effective date has passed as actionable, up to 100 per call, and returns their IDs.
+// Any organization may run it, e.g. from a scheduler; receipt of a transfer does not
+// wait for it.
+func (s *SupplyChainContract) ActivateScheduledTransfers(ctx contractapi.TransactionContextInterface,
+	receiver string) ([]string, error) {
+
+	if err := validateID("receiver", receiver); err != nil {
+		return nil, err
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+	timestamp := now.UTC().Format(time.RFC3339)
+
+	prefix := scheduledTransferKeyPrefix + receiver + "_"
+	resultsIterator, err := ctx.GetStub().GetStateByRange(prefix, prefix+timestamp+"~")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query scheduled transfers: %v", err)
+	}
+	defer resultsIterator.Close()
+
+	activated := []string{}
+	for resultsIterator.HasNext() && len(activated) < maxActivatedTransfers {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		err = ctx.GetStub().DelState(queryResponse.Key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to update scheduled transfer index: %v", err)
+		}
+
+		transfer, err := s.GetTransfer(ctx, string(queryResponse.Value))
+		if err != nil {
+			logFor(ctx).Warn("skipping unreadable scheduled transfer", "key", queryResponse.Key, "error", err)
+			continue
+		}
+		// Transfers already received or cancelled only leave the index
+		if !isOpenTransfer(transfer) || transfer.ActivatedAt != "" {
+			continue
+		}
+		transfer.ActivatedAt = timestamp
+		err = putTransfer(ctx, transfer)
+		if err != nil {
+			return nil, err
+		}
+		activated = append(activated, transfer.ID)
+	}
+
+	if len(activated) == 0 {
+		return activated, nil
+	}
+	return activated, emitEvent(ctx, ChaincodeEvent{
+		EventType:  EventScheduledTransfersActivated,
+		EntityType: EventEntityOrganization,
+		EntityID:   receiver,
+		Attributes: map[string]interface{}{
+			"transferIds": activated,
+		},
+	})
+}
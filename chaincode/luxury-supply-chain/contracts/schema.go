@@ -0,0 +1,496 @@
+package contracts
+
+// CurrentSchemaVersion is the layout written by this chaincode version.
+// Records stored before schema versioning have no schemaVersion and read as 0.
+const CurrentSchemaVersion = 1
+
+// schemaRecord is a persisted type that can upgrade itself from an older layout.
+// upgradeSchema is applied after every unmarshal of a stored record and by
+// AdminContract.MigrateNamespace; it reports whether the record changed.
+type schemaRecord interface {
+	upgradeSchema() bool
+}
+
+// Version 1 guarantees that collections are empty rather than null, which older
+// records did not, so readers no longer need to patch them up individually.
+
+func (p *Product) upgradeSchema() bool {
+	if p.SchemaVersion >= CurrentSchemaVersion {
+		return false
+	}
+	if p.Materials == nil {
+		p.Materials = []Material{}
+	}
+	if p.Metadata == nil {
+		p.Metadata = make(map[string]interface{})
+	}
+	p.SchemaVersion = CurrentSchemaVersion
+	return true
+}
+
+func (b *ProductBatch) upgradeSchema() bool {
+	if b.SchemaVersion >= CurrentSchemaVersion {
+		return false
+	}
+	if b.ProductIDs == nil {
+		b.ProductIDs = []string{}
+	}
+	if b.MaterialsUsed == nil {
+		b.MaterialsUsed = []MaterialUsage{}
+	}
+	if b.Metadata == nil {
+		b.Metadata = make(map[string]string)
+	}
+	b.SchemaVersion = CurrentSchemaVersion
+	return true
+}
+
+func (t *Transfer) upgradeSchema() bool {
+	if t.SchemaVersion >= CurrentSchemaVersion {
+		return false
+	}
+	t.SchemaVersion = CurrentSchemaVersion
+	return true
+}
+
+func (c *DigitalBirthCertificate) upgradeSchema() bool {
+	if c.SchemaVersion >= CurrentSchemaVersion {
+		return false
+	}
+	if c.Materials == nil {
+		c.Materials = []MaterialRecord{}
+	}
+	if c.InitialPhotos == nil {
+		c.InitialPhotos = []string{}
+	}
+	c.SchemaVersion = CurrentSchemaVersion
+	return true
+}
+
+func (o *Ownership) upgradeSchema() bool {
+	if o.SchemaVersion >= CurrentSchemaVersion {
+		return false
+	}
+	if o.ServiceHistory == nil {
+		o.ServiceHistory = []ServiceRecord{}
+	}
+	if o.PreviousOwners == nil {
+		o.PreviousOwners = []PreviousOwner{}
+	}
+	o.SchemaVersion = CurrentSchemaVersion
+	return true
+}
+
+func (i *MaterialInventory) upgradeSchema() bool {
+	if i.SchemaVersion >= CurrentSchemaVersion {
+		return false
+	}
+	if i.Transfers == nil {
+		i.Transfers = []MaterialTransferRecord{}
+	}
+	i.SchemaVersion = CurrentSchemaVersion
+	return true
+}
+
+func (o *OrganizationInfo) upgradeSchema() bool {
+	if o.SchemaVersion >= CurrentSchemaVersion {
+		return false
+	}
+	o.SchemaVersion = CurrentSchemaVersion
+	return true
+}
+
+func (e *OwnerDataAccessEntry) upgradeSchema() bool {
+	if e.SchemaVersion >= CurrentSchemaVersion {
+		return false
+	}
+	e.SchemaVersion = CurrentSchemaVersion
+	return true
+}
+
+func (v *VerificationToken) upgradeSchema() bool {
+	if v.SchemaVersion >= CurrentSchemaVersion {
+		return false
+	}
+	v.SchemaVersion = CurrentSchemaVersion
+	return true
+}
+
+func (s *CertificateDisclosureSalts) upgradeSchema() bool {
+	if s.SchemaVersion >= CurrentSchemaVersion {
+		return false
+	}
+	if s.Salts == nil {
+		s.Salts = make(map[string]string)
+	}
+	s.SchemaVersion = CurrentSchemaVersion
+	return true
+}
+
+func (c *ConsensusConfig) upgradeSchema() bool {
+	if c.SchemaVersion >= CurrentSchemaVersion {
+		return false
+	}
+	c.SchemaVersion = CurrentSchemaVersion
+	return true
+}
+
+func (f *FeatureFlags) upgradeSchema() bool {
+	if f.SchemaVersion >= CurrentSchemaVersion {
+		return false
+	}
+	f.SchemaVersion = CurrentSchemaVersion
+	return true
+}
+
+func (c *PaymentConfig) upgradeSchema() bool {
+	if c.SchemaVersion >= CurrentSchemaVersion {
+		return false
+	}
+	c.SchemaVersion = CurrentSchemaVersion
+	return true
+}
+
+func (e *LedgerLogEntry) upgradeSchema() bool {
+	if e.SchemaVersion >= CurrentSchemaVersion {
+		return false
+	}
+	e.SchemaVersion = CurrentSchemaVersion
+	return true
+}
+
+func (c *Checkpoint) upgradeSchema() bool {
+	if c.SchemaVersion >= CurrentSchemaVersion {
+		return false
+	}
+	c.SchemaVersion = CurrentSchemaVersion
+	return true
+}
+
+func (o *Oracle) upgradeSchema() bool {
+	if o.SchemaVersion >= CurrentSchemaVersion {
+		return false
+	}
+	if o.DataTypes == nil {
+		o.DataTypes = []string{}
+	}
+	o.SchemaVersion = CurrentSchemaVersion
+	return true
+}
+
+func (d *ReferenceData) upgradeSchema() bool {
+	if d.SchemaVersion >= CurrentSchemaVersion {
+		return false
+	}
+	d.SchemaVersion = CurrentSchemaVersion
+	return true
+}
+
+func (s *OrganizationStats) upgradeSchema() bool {
+	if s.SchemaVersion >= CurrentSchemaVersion {
+		return false
+	}
+	s.SchemaVersion = CurrentSchemaVersion
+	return true
+}
+
+func (r *RegulatedMaterials) upgradeSchema() bool {
+	if r.SchemaVersion >= CurrentSchemaVersion {
+		return false
+	}
+	if r.Types == nil {
+		r.Types = map[string]string{}
+	}
+	r.SchemaVersion = CurrentSchemaVersion
+	return true
+}
+
+func (d *SourcingDeclaration) upgradeSchema() bool {
+	if d.SchemaVersion >= CurrentSchemaVersion {
+		return false
+	}
+	if d.Smelters == nil {
+		d.Smelters = []Smelter{}
+	}
+	d.SchemaVersion = CurrentSchemaVersion
+	return true
+}
+
+func (c *Craftsman) upgradeSchema() bool {
+	if c.SchemaVersion >= CurrentSchemaVersion {
+		return false
+	}
+	if c.Specialties == nil {
+		c.Specialties = []string{}
+	}
+	c.SchemaVersion = CurrentSchemaVersion
+	return true
+}
+
+func (r *ConditionPhotoRecord) upgradeSchema() bool {
+	if r.SchemaVersion >= CurrentSchemaVersion {
+		return false
+	}
+	if r.PhotoHashes == nil {
+		r.PhotoHashes = []string{}
+	}
+	r.SchemaVersion = CurrentSchemaVersion
+	return true
+}
+
+func (t *CommercialTerms) upgradeSchema() bool {
+	if t.SchemaVersion >= CurrentSchemaVersion {
+		return false
+	}
+	t.SchemaVersion = CurrentSchemaVersion
+	return true
+}
+
+func (d *DutyRecord) upgradeSchema() bool {
+	if d.SchemaVersion >= CurrentSchemaVersion {
+		return false
+	}
+	if d.DocumentHashes == nil {
+		d.DocumentHashes = []string{}
+	}
+	d.SchemaVersion = CurrentSchemaVersion
+	return true
+}
+
+func (c *CurrencyConfig) upgradeSchema() bool {
+	if c.SchemaVersion >= CurrentSchemaVersion {
+		return false
+	}
+	if c.Allowed == nil {
+		c.Allowed = []string{}
+	}
+	c.SchemaVersion = CurrentSchemaVersion
+	return true
+}
+
+func (d *DamageClaim) upgradeSchema() bool {
+	if d.SchemaVersion >= CurrentSchemaVersion {
+		return false
+	}
+	if d.ProductIDs == nil {
+		d.ProductIDs = []string{}
+	}
+	if d.PhotoHashes == nil {
+		d.PhotoHashes = []string{}
+	}
+	d.SchemaVersion = CurrentSchemaVersion
+	return true
+}
+
+func (w *WriteOff) upgradeSchema() bool {
+	if w.SchemaVersion >= CurrentSchemaVersion {
+		return false
+	}
+	w.SchemaVersion = CurrentSchemaVersion
+	return true
+}
+
+func (r *IdentifierReissue) upgradeSchema() bool {
+	if r.SchemaVersion >= CurrentSchemaVersion {
+		return false
+	}
+	r.SchemaVersion = CurrentSchemaVersion
+	return true
+}
+
+func (c *NFCChip) upgradeSchema() bool {
+	if c.SchemaVersion >= CurrentSchemaVersion {
+		return false
+	}
+	c.SchemaVersion = CurrentSchemaVersion
+	return true
+}
+
+func (r *ChipReplacement) upgradeSchema() bool {
+	if r.SchemaVersion >= CurrentSchemaVersion {
+		return false
+	}
+	r.SchemaVersion = CurrentSchemaVersion
+	return true
+}
+
+func (r *TransferFlowRules) upgradeSchema() bool {
+	if r.SchemaVersion >= CurrentSchemaVersion {
+		return false
+	}
+	if r.Flows == nil {
+		r.Flows = map[string]map[string][]string{}
+	}
+	r.SchemaVersion = CurrentSchemaVersion
+	return true
+}
+
+func (a *Anomaly) upgradeSchema() bool {
+	if a.SchemaVersion >= CurrentSchemaVersion {
+		return false
+	}
+	a.SchemaVersion = CurrentSchemaVersion
+	return true
+}
+
+func (n *ReceiptNorm) upgradeSchema() bool {
+	if n.SchemaVersion >= CurrentSchemaVersion {
+		return false
+	}
+	n.SchemaVersion = CurrentSchemaVersion
+	return true
+}
+
+func (r *MaterialReservation) upgradeSchema() bool {
+	if r.SchemaVersion >= CurrentSchemaVersion {
+		return false
+	}
+	r.SchemaVersion = CurrentSchemaVersion
+	return true
+}
+
+func (t *ProductTemplate) upgradeSchema() bool {
+	if t.SchemaVersion >= CurrentSchemaVersion {
+		return false
+	}
+	if t.Materials == nil {
+		t.Materials = []TemplateMaterial{}
+	}
+	t.SchemaVersion = CurrentSchemaVersion
+	return true
+}
+
+func (c *TransitCheckpoint) upgradeSchema() bool {
+	if c.SchemaVersion >= CurrentSchemaVersion {
+		return false
+	}
+	c.SchemaVersion = CurrentSchemaVersion
+	return true
+}
+
+func (a *SensorAnchor) upgradeSchema() bool {
+	if a.SchemaVersion >= CurrentSchemaVersion {
+		return false
+	}
+	a.SchemaVersion = CurrentSchemaVersion
+	return true
+}
+
+func (e *StolenRegistryEntry) upgradeSchema() bool {
+	if e.SchemaVersion >= CurrentSchemaVersion {
+		return false
+	}
+	e.SchemaVersion = CurrentSchemaVersion
+	return true
+}
+
+func (r *OwnershipTransferRules) upgradeSchema() bool {
+	if r.SchemaVersion >= CurrentSchemaVersion {
+		return false
+	}
+	if r.Rules == nil {
+		r.Rules = map[string]OwnershipTransferRule{}
+	}
+	r.SchemaVersion = CurrentSchemaVersion
+	return true
+}
+
+func (p *ConsensusPolicy) upgradeSchema() bool {
+	if p.SchemaVersion >= CurrentSchemaVersion {
+		return false
+	}
+	p.SchemaVersion = CurrentSchemaVersion
+	return true
+}
+
+func (r *ResaleCertificationRequest) upgradeSchema() bool {
+	if r.SchemaVersion >= CurrentSchemaVersion {
+		return false
+	}
+	r.SchemaVersion = CurrentSchemaVersion
+	return true
+}
+
+func (w *WarrantyTerms) upgradeSchema() bool {
+	if w.SchemaVersion >= CurrentSchemaVersion {
+		return false
+	}
+	w.SchemaVersion = CurrentSchemaVersion
+	return true
+}
+
+func (p *InsurancePolicy) upgradeSchema() bool {
+	if p.SchemaVersion >= CurrentSchemaVersion {
+		return false
+	}
+	if p.ClaimIDs == nil {
+		p.ClaimIDs = []string{}
+	}
+	p.SchemaVersion = CurrentSchemaVersion
+	return true
+}
+
+func (c *InsuranceClaim) upgradeSchema() bool {
+	if c.SchemaVersion >= CurrentSchemaVersion {
+		return false
+	}
+	c.SchemaVersion = CurrentSchemaVersion
+	return true
+}
+
+func (a *AppraisalRecord) upgradeSchema() bool {
+	if a.SchemaVersion >= CurrentSchemaVersion {
+		return false
+	}
+	a.SchemaVersion = CurrentSchemaVersion
+	return true
+}
+
+func (f *ChipVerificationFailure) upgradeSchema() bool {
+	if f.SchemaVersion >= CurrentSchemaVersion {
+		return false
+	}
+	f.SchemaVersion = CurrentSchemaVersion
+	return true
+}
+
+func (r *CounterfeitReport) upgradeSchema() bool {
+	if r.SchemaVersion >= CurrentSchemaVersion {
+		return false
+	}
+	r.SchemaVersion = CurrentSchemaVersion
+	return true
+}
+
+func (v *VerificationScan) upgradeSchema() bool {
+	if v.SchemaVersion >= CurrentSchemaVersion {
+		return false
+	}
+	v.SchemaVersion = CurrentSchemaVersion
+	return true
+}
+
+func (g *GS1CompanyPrefix) upgradeSchema() bool {
+	if g.SchemaVersion >= CurrentSchemaVersion {
+		return false
+	}
+	g.SchemaVersion = CurrentSchemaVersion
+	return true
+}
+
+func (r *TradeDocumentRules) upgradeSchema() bool {
+	if r.SchemaVersion >= CurrentSchemaVersion {
+		return false
+	}
+	if r.OrganizationCountries == nil {
+		r.OrganizationCountries = map[string]string{}
+	}
+	if r.Corridors == nil {
+		r.Corridors = map[string][]string{}
+	}
+	if r.DefaultRequired == nil {
+		r.DefaultRequired = []string{}
+	}
+	r.SchemaVersion = CurrentSchemaVersion
+	return true
+}
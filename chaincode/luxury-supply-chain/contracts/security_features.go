@@ -0,0 +1,120 @@
+package contracts
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// maxAmendedFeatures limits the security features added by one amendment
+const maxAmendedFeatures = 10
+
+// SecurityFeatureAmendment records security features retrofitted to a product after its
+// birth certificate was issued, e.g. a hologram added to older inventory
+type SecurityFeatureAmendment struct {
+	AmendmentID string   `json:"amendmentId"`
+	Features    []string `json:"features"`
+	Installer   string   `json:"installer"`   // Workshop or service center that fitted them
+	InstalledAt string   `json:"installedAt"` // RFC3339
+	RecordedBy  string   `json:"recordedBy"`
+	RecordedAt  string   `json:"recordedAt"`
+}
+
+// AmendSecurityFeatures adds retrofitted security features to a product's birth
+// certificate (brand only). featuresJSON is a JSON array of feature names; they are
+// appended to securityFeatures, which verifiers should expect on the unit from then on.
+func (o *OwnershipContract) AmendSecurityFeatures(ctx contractapi.TransactionContextInterface,
+	productID string, amendmentID string, featuresJSON string, installer string, installedAt string) error {
+
+	var features []string
+	if err := validateAll(
+		validateID("productID", productID),
+		validateID("amendmentID", amendmentID),
+		validateJSON("features", featuresJSON, &features),
+		validateName("installer", installer),
+	); err != nil {
+		return err
+	}
+	if len(features) == 0 || len(features) > maxAmendedFeatures {
+		return newError(ErrInvalidArgument, "features must list 1 to %d security features", maxAmendedFeatures)
+	}
+	for i, feature := range features {
+		if err := validateName(fmt.Sprintf("features[%d]", i), feature); err != nil {
+			return err
+		}
+	}
+
+	caller, err := requireSuperAdmin(ctx)
+	if err != nil {
+		return err
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	installed, err := time.Parse(time.RFC3339, installedAt)
+	if err != nil {
+		return newError(ErrInvalidArgument, "installedAt must be an RFC3339 time")
+	}
+	if installed.After(now) {
+		return newError(ErrInvalidArgument, "installedAt cannot be in the future")
+	}
+
+	supplyChain := &SupplyChainContract{}
+	product, err := supplyChain.GetProduct(ctx, productID)
+	if err != nil {
+		return err
+	}
+	if product.Status == ProductStatusDestroyed || product.Status == ProductStatusWrittenOff {
+		return newError(ErrInvalidState, "product %s is %s", productID, product.Status)
+	}
+
+	certificate, err := o.GetBirthCertificate(ctx, productID)
+	if err != nil {
+		return err
+	}
+	authenticity := &certificate.Authenticity
+	for _, amendment := range authenticity.FeatureAmendments {
+		if amendment.AmendmentID == amendmentID {
+			return newError(ErrAlreadyExists, "amendment %s already exists for product %s", amendmentID, productID)
+		}
+	}
+	existing := make(map[string]bool)
+	for _, feature := range authenticity.SecurityFeatures {
+		existing[feature] = true
+	}
+	for _, feature := range features {
+		if existing[feature] {
+			return newError(ErrAlreadyExists, "product %s already has security feature %q", productID, feature)
+		}
+		existing[feature] = true
+	}
+
+	authenticity.SecurityFeatures = append(authenticity.SecurityFeatures, features...)
+	authenticity.FeatureAmendments = append(authenticity.FeatureAmendments, SecurityFeatureAmendment{
+		AmendmentID: amendmentID,
+		Features:    features,
+		Installer:   installer,
+		InstalledAt: installed.UTC().Format(time.RFC3339),
+		RecordedBy:  caller,
+		RecordedAt:  now.UTC().Format(time.RFC3339),
+	})
+	err = recommitCertificate(ctx, certificate, "security features upgraded")
+	if err != nil {
+		return err
+	}
+
+	return emitEvent(ctx, ChaincodeEvent{
+		EventType:  EventSecurityFeaturesAmended,
+		EntityType: EventEntityProduct,
+		EntityID:   productID,
+		Attributes: map[string]interface{}{
+			"amendmentId":     amendmentID,
+			"features":        features,
+			"installer":       installer,
+			"certificateHash": certificate.CertificateHash,
+		},
+	})
+}
@@ -0,0 +1,375 @@
+package contracts
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Sensor anchors are stored as sensor_anchor_<transferID>_<anchorID>. The anchor ID is
+// the SHA256 of the message the oracle signed, so a signed payload is anchored once.
+const (
+	sensorAnchorKeyPrefix = "sensor_anchor_"
+	maxCitedSensorAnchors = 50
+)
+
+// Kinds of sensor anchors
+const (
+	SensorAnchorReading = "READING" // One reading, see RecordSensorReading
+	SensorAnchorBatch   = "BATCH"   // Merkle root of off-chain readings, see RecordSensorBatchHash
+)
+
+// Sensor reading types and the unit of their values
+const (
+	SensorReadingTemperature = "TEMPERATURE" // °C
+	SensorReadingHumidity    = "HUMIDITY"    // % relative humidity
+	SensorReadingShock       = "SHOCK"       // Peak acceleration in g
+	SensorReadingLight       = "LIGHT"       // lux, e.g. a case opened in transit
+)
+
+// SensorAnchor is shipment telemetry a logistics oracle signed for a transfer: a single
+// reading, or the Merkle root of a series of readings kept off-chain
+type SensorAnchor struct {
+	AnchorID      string `json:"anchorId"` // SHA256 of the signed message
+	TransferID    string `json:"transferId"`
+	Kind          string `json:"kind"`
+	OracleID      string `json:"oracleId"`
+	SensorID      string `json:"sensorId"`
+	ReadingType   string `json:"readingType,omitempty" metadata:",optional"` // READING only
+	Value         string `json:"value,omitempty" metadata:",optional"`       // READING only, decimal
+	MerkleRoot    string `json:"merkleRoot,omitempty" metadata:",optional"`  // BATCH only
+	ReadingCount  int    `json:"readingCount,omitempty" metadata:",optional"`
+	FromTime      string `json:"fromTime,omitempty" metadata:",optional"` // BATCH only, first reading
+	ObservedAt    string `json:"observedAt"`                              // The reading, or the last reading of a batch
+	Signature     string `json:"signature"`                               // Base64, verifiable with the oracle's key
+	SubmittedBy   string `json:"submittedBy"`                             // MSP that relayed the submission
+	SubmittedAt   string `json:"submittedAt"`
+	TxID          string `json:"txId"`
+	SchemaVersion int    `json:"schemaVersion"`
+}
+
+// sensorAnchorKey returns the ledger key of a transfer's sensor anchor
+func sensorAnchorKey(transferID string, anchorID string) string {
+	return sensorAnchorKeyPrefix + transferID + "_" + anchorID
+}
+
+// parseSensorTime reads a time of a sensor anchor, which must fall between the
+// transfer's initiation and the transaction time
+func parseSensorTime(field string, value string, transfer *Transfer, now time.Time) (time.Time, error) {
+	observed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, newError(ErrInvalidArgument, "%s must be an RFC3339 time", field)
+	}
+	if observed.After(now.Add(checkpointClockSkew)) {
+		return time.Time{}, newError(ErrInvalidArgument, "%s %s is in the future", field, value)
+	}
+	if initiatedAt, err := time.Parse(time.RFC3339, transfer.InitiatedAt); err == nil && observed.Before(initiatedAt) {
+		return time.Time{}, newError(ErrInvalidArgument, "%s %s is before transfer %s was initiated", field, value, transfer.ID)
+	}
+	return observed, nil
+}
+
+// anchorSensorData verifies the oracle's signature over message and stores the anchor
+func (s *SupplyChainContract) anchorSensorData(ctx contractapi.TransactionContextInterface,
+	anchor *SensorAnchor, message string) error {
+
+	if err := verifyOracleSignature(ctx, anchor.OracleID, OracleDataSensorTelemetry, message, anchor.Signature); err != nil {
+		return err
+	}
+
+	digest := sha256.Sum256([]byte(message))
+	anchor.AnchorID = hex.EncodeToString(digest[:])
+	key := sensorAnchorKey(anchor.TransferID, anchor.AnchorID)
+	existing, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return newError(ErrAlreadyExists, "sensor data %s is already anchored", anchor.AnchorID)
+	}
+
+	submitter, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to get caller identity: %v", err)
+	}
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	anchor.SubmittedBy = submitter
+	anchor.SubmittedAt = now.UTC().Format(time.RFC3339)
+	anchor.TxID = ctx.GetStub().GetTxID()
+	anchor.SchemaVersion = CurrentSchemaVersion
+
+	anchorJSON, err := json.Marshal(anchor)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState(key, anchorJSON)
+	if err != nil {
+		return fmt.Errorf("failed to store sensor data: %v", err)
+	}
+
+	event := ChaincodeEvent{
+		EventType:  EventSensorDataAnchored,
+		EntityType: EventEntityTransfer,
+		EntityID:   anchor.TransferID,
+		Attributes: map[string]interface{}{
+			"anchorId":   anchor.AnchorID,
+			"kind":       anchor.Kind,
+			"oracleId":   anchor.OracleID,
+			"sensorId":   anchor.SensorID,
+			"observedAt": anchor.ObservedAt,
+		},
+	}
+	if anchor.Kind == SensorAnchorReading {
+		event.Attributes["readingType"] = anchor.ReadingType
+	} else {
+		event.Attributes["readingCount"] = anchor.ReadingCount
+	}
+	return emitEvent(ctx, event)
+}
+
+// sensorTransfer returns a transfer that sensor data can be anchored on
+func (s *SupplyChainContract) sensorTransfer(ctx contractapi.TransactionContextInterface,
+	transferID string) (*Transfer, error) {
+
+	transfer, err := s.GetTransfer(ctx, transferID)
+	if err != nil {
+		return nil, err
+	}
+	if transfer.Status == TransferStatusCancelled {
+		return nil, newError(ErrInvalidState, "transfer %s is cancelled", transferID)
+	}
+	return transfer, nil
+}
+
+// RecordSensorReading anchors one reading of a shipment's sensor on a transfer. The
+// logistics oracle signs "oracleId|SENSOR_TELEMETRY|transferId|sensorId|READING|readingType|value|observedAt"
+// and any organization may relay it. Readings may be recorded after delivery, e.g.
+// when the tracker is read out.
+func (s *SupplyChainContract) RecordSensorReading(ctx contractapi.TransactionContextInterface,
+	transferID string, oracleID string, sensorID string, readingType string, value string,
+	observedAt string, signature string) error {
+
+	if err := validateAll(
+		validateID("transferID", transferID),
+		validateID("oracleID", oracleID),
+		validateID("sensorID", sensorID),
+		validateEnum("readingType", readingType, SensorReadingTemperature, SensorReadingHumidity,
+			SensorReadingShock, SensorReadingLight),
+		validateRequired("signature", signature, maxNameLength),
+	); err != nil {
+		return err
+	}
+	if _, err := strconv.ParseFloat(value, 64); err != nil || strings.Contains(value, "|") {
+		return newError(ErrInvalidArgument, "value must be a decimal number")
+	}
+
+	transfer, err := s.sensorTransfer(ctx, transferID)
+	if err != nil {
+		return err
+	}
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	if _, err := parseSensorTime("observedAt", observedAt, transfer, now); err != nil {
+		return err
+	}
+
+	message := strings.Join([]string{oracleID, OracleDataSensorTelemetry, transferID, sensorID,
+		SensorAnchorReading, readingType, value, observedAt}, "|")
+	return s.anchorSensorData(ctx, &SensorAnchor{
+		TransferID:  transferID,
+		Kind:        SensorAnchorReading,
+		OracleID:    oracleID,
+		SensorID:    sensorID,
+		ReadingType: readingType,
+		Value:       value,
+		ObservedAt:  observedAt,
+		Signature:   signature,
+	}, message)
+}
+
+// RecordSensorBatchHash anchors the Merkle root of readingCount readings a sensor took
+// from fromTime to toTime, kept off-chain. The logistics oracle signs
+// "oracleId|SENSOR_TELEMETRY|transferId|sensorId|BATCH|merkleRoot|readingCount|fromTime|toTime".
+func (s *SupplyChainContract) RecordSensorBatchHash(ctx contractapi.TransactionContextInterface,
+	transferID string, oracleID string, sensorID string, merkleRoot string, readingCount int,
+	fromTime string, toTime string, signature string) error {
+
+	if err := validateAll(
+		validateID("transferID", transferID),
+		validateID("oracleID", oracleID),
+		validateID("sensorID", sensorID),
+		validateID("merkleRoot", merkleRoot),
+		validateRequired("signature", signature, maxNameLength),
+	); err != nil {
+		return err
+	}
+	if readingCount <= 0 {
+		return newError(ErrInvalidArgument, "readingCount must be positive")
+	}
+
+	transfer, err := s.sensorTransfer(ctx, transferID)
+	if err != nil {
+		return err
+	}
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	from, err := parseSensorTime("fromTime", fromTime, transfer, now)
+	if err != nil {
+		return err
+	}
+	to, err := parseSensorTime("toTime", toTime, transfer, now)
+	if err != nil {
+		return err
+	}
+	if to.Before(from) {
+		return newError(ErrInvalidArgument, "toTime is before fromTime")
+	}
+
+	message := strings.Join([]string{oracleID, OracleDataSensorTelemetry, transferID, sensorID,
+		SensorAnchorBatch, merkleRoot, strconv.Itoa(readingCount), fromTime, toTime}, "|")
+	return s.anchorSensorData(ctx, &SensorAnchor{
+		TransferID:   transferID,
+		Kind:         SensorAnchorBatch,
+		OracleID:     oracleID,
+		SensorID:     sensorID,
+		MerkleRoot:   merkleRoot,
+		ReadingCount: readingCount,
+		FromTime:     fromTime,
+		ObservedAt:   toTime,
+		Signature:    signature,
+	}, message)
+}
+
+// GetSensorData returns the sensor anchors of a transfer in the order they were
+// observed, to the sender, the receiver and the brand
+func (s *SupplyChainContract) GetSensorData(ctx contractapi.TransactionContextInterface,
+	transferID string) ([]*SensorAnchor, error) {
+
+	if err := validateID("transferID", transferID); err != nil {
+		return nil, err
+	}
+
+	caller, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get caller identity: %v", err)
+	}
+	transfer, err := s.GetTransfer(ctx, transferID)
+	if err != nil {
+		return nil, err
+	}
+	if transfer.From != caller && transfer.To != caller {
+		if _, err := requireSuperAdmin(ctx); err != nil {
+			return nil, newError(ErrPermissionDenied, "only the parties of transfer %s and the brand can read its sensor data", transferID)
+		}
+	}
+
+	prefix := sensorAnchorKeyPrefix + transferID + "_"
+	resultsIterator, err := ctx.GetStub().GetStateByRange(prefix, prefix+"~")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query sensor data: %v", err)
+	}
+	defer resultsIterator.Close()
+
+	anchors := []*SensorAnchor{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var anchor SensorAnchor
+		err = json.Unmarshal(queryResponse.Value, &anchor)
+		if err != nil {
+			return nil, err
+		}
+		anchor.upgradeSchema()
+		anchors = append(anchors, &anchor)
+	}
+	// Keys are ordered by anchor ID, a hash
+	sort.SliceStable(anchors, func(i, j int) bool {
+		return anchors[i].ObservedAt < anchors[j].ObservedAt
+	})
+
+	return anchors, nil
+}
+
+// CiteSensorEvidence adds sensor data anchored on a transfer to the receiver's
+// DEFECTIVE dispute of it in consensus. anchorIDs is comma-separated. Consensus
+// records each anchor as SENSOR_DATA evidence with its ledger key as reference.
+func (s *SupplyChainContract) CiteSensorEvidence(ctx contractapi.TransactionContextInterface,
+	transferID string, anchorIDs string) error {
+
+	if err := validateAll(
+		validateID("transferID", transferID),
+		validateRequired("anchorIDs", anchorIDs, maxJSONLength),
+	); err != nil {
+		return err
+	}
+
+	caller, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to get caller identity: %v", err)
+	}
+	transfer, err := s.GetTransfer(ctx, transferID)
+	if err != nil {
+		return err
+	}
+	if transfer.To != caller {
+		return newError(ErrPermissionDenied, "only the receiver of transfer %s can cite its sensor data", transferID)
+	}
+
+	ids := strings.Split(anchorIDs, ",")
+	if len(ids) > maxCitedSensorAnchors {
+		return newError(ErrInvalidArgument, "at most %d anchors can be cited at once", maxCitedSensorAnchors)
+	}
+	anchors := make([]string, 0, len(ids))
+	for _, anchorID := range ids {
+		anchorID = strings.TrimSpace(anchorID)
+		if err := validateID("anchor ID", anchorID); err != nil {
+			return err
+		}
+		key := sensorAnchorKey(transferID, anchorID)
+		anchorJSON, err := ctx.GetStub().GetState(key)
+		if err != nil {
+			return fmt.Errorf("failed to read sensor data: %v", err)
+		}
+		if anchorJSON == nil {
+			return newError(ErrNotFound, "sensor data %s is not anchored on transfer %s", anchorID, transferID)
+		}
+		anchors = append(anchors, anchorID+"="+key)
+	}
+
+	// Consensus checks that the transaction is disputed as DEFECTIVE
+	consensus, err := LoadConsensusIntegration(ctx)
+	if err != nil {
+		return err
+	}
+	err = consensus.NotifyConsensusOfSensorEvidence(ctx, transferID, caller, anchors)
+	if err != nil {
+		return err
+	}
+
+	return emitEvent(ctx, ChaincodeEvent{
+		EventType:  EventSensorEvidenceCited,
+		EntityType: EventEntityTransfer,
+		EntityID:   transferID,
+		Attributes: map[string]interface{}{
+			"receiver": caller,
+			"anchors":  len(anchors),
+		},
+	})
+}
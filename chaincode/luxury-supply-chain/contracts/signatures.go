@@ -0,0 +1,53 @@
+package contracts
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+)
+
+// Public key types accepted for signatures verified in chaincode
+const (
+	KeyTypeEd25519   = "Ed25519"
+	KeyTypeECDSAP256 = "ECDSA-P256"
+)
+
+// parsePublicKeyPEM decodes a PKIX "PUBLIC KEY" block and returns its key type
+func parsePublicKeyPEM(publicKeyPEM string) (string, interface{}, error) {
+	block, _ := pem.Decode([]byte(publicKeyPEM))
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return "", nil, fmt.Errorf("key must be a PEM \"PUBLIC KEY\" block")
+	}
+	publicKey, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid public key: %v", err)
+	}
+
+	switch key := publicKey.(type) {
+	case ed25519.PublicKey:
+		return KeyTypeEd25519, key, nil
+	case *ecdsa.PublicKey:
+		if key.Curve != elliptic.P256() {
+			return "", nil, fmt.Errorf("only P-256 ECDSA keys are supported")
+		}
+		return KeyTypeECDSAP256, key, nil
+	}
+	return "", nil, fmt.Errorf("unsupported key type %T", publicKey)
+}
+
+// verifySignature checks signature over payload with a key from parsePublicKeyPEM.
+// Ed25519 keys sign the payload itself, ECDSA keys its SHA256 digest in ASN.1 DER form.
+func verifySignature(publicKey interface{}, payload []byte, signature []byte) bool {
+	switch key := publicKey.(type) {
+	case ed25519.PublicKey:
+		return ed25519.Verify(key, payload, signature)
+	case *ecdsa.PublicKey:
+		digest := sha256.Sum256(payload)
+		return ecdsa.VerifyASN1(key, digest[:], signature)
+	}
+	return false
+}
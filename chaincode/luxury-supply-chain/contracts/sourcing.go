@@ -0,0 +1,449 @@
+package contracts
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Kinds of sourcing declarations a supplier can submit for a material
+const (
+	DeclarationConflictMinerals    = "CONFLICT_MINERALS"    // Smelters of tin, tantalum, tungsten and gold, e.g. from an RMI CMRT
+	DeclarationResponsibleSourcing = "RESPONSIBLE_SOURCING" // Audited sourcing standard, e.g. LWG or RJC
+)
+
+// Reasons an in-stock material fails the sourcing compliance check
+const (
+	SourcingGapMissing = "NO_DECLARATION"
+	SourcingGapExpired = "EXPIRED"
+)
+
+// Declarations are stored as sourcing_declaration_<materialID>_<declarationID>, so the
+// declarations of a material are one key range
+const sourcingDeclarationKeyPrefix = "sourcing_declaration_"
+
+// Smelter is a smelter or refiner in a material's supply chain
+type Smelter struct {
+	ID      string `json:"id"` // RMI smelter identification number, e.g. CID001234
+	Name    string `json:"name"`
+	Country string `json:"country"`                              // ISO 3166 alpha-2 code
+	Metal   string `json:"metal,omitempty" metadata:",optional"` // For conflict minerals: tin, tantalum, tungsten or gold
+}
+
+// SourcingDeclaration is a supplier's statement about where a material was sourced,
+// backed by an audit report kept off-chain
+type SourcingDeclaration struct {
+	ID              string    `json:"id"`
+	MaterialID      string    `json:"materialId"`
+	Supplier        string    `json:"supplier"` // MSP ID of the declaring supplier
+	DeclarationType string    `json:"declarationType"`
+	Smelters        []Smelter `json:"smelters"`
+	AuditReportHash string    `json:"auditReportHash"` // SHA256 of the audit report
+	Auditor         string    `json:"auditor,omitempty" metadata:",optional"`
+	ValidFrom       string    `json:"validFrom"`  // RFC3339
+	ValidUntil      string    `json:"validUntil"` // RFC3339
+	SubmittedAt     string    `json:"submittedAt"`
+	TxID            string    `json:"txId"`
+	SchemaVersion   int       `json:"schemaVersion"`
+}
+
+// SourcingComplianceGap is an in-stock material without a current sourcing declaration
+type SourcingComplianceGap struct {
+	MaterialID     string  `json:"materialId"`
+	MaterialType   string  `json:"materialType"`
+	Supplier       string  `json:"supplier"`
+	Available      float64 `json:"available"`
+	Reason         string  `json:"reason"`
+	LastValidUntil string  `json:"lastValidUntil,omitempty" metadata:",optional"` // End of the latest expired declaration
+}
+
+// sourcingDeclarationKey returns the ledger key of a declaration
+func sourcingDeclarationKey(materialID string, declarationID string) string {
+	return sourcingDeclarationKeyPrefix + materialID + "_" + declarationID
+}
+
+// validAt reports whether the declaration covers t
+func (d *SourcingDeclaration) validAt(t time.Time) bool {
+	from, err := time.Parse(time.RFC3339, d.ValidFrom)
+	if err != nil || t.Before(from) {
+		return false
+	}
+	until, err := time.Parse(time.RFC3339, d.ValidUntil)
+	return err == nil && t.Before(until)
+}
+
+// SubmitSourcingDeclaration records a sourcing declaration for a material the caller
+// supplied. declarationJSON holds declarationType, smelters, auditReportHash, auditor,
+// validFrom and validUntil. A new declaration is submitted when the previous one expires.
+func (s *SupplyChainContract) SubmitSourcingDeclaration(ctx contractapi.TransactionContextInterface,
+	declarationID string, materialID string, declarationJSON string) error {
+
+	var declaration SourcingDeclaration
+	if err := validateAll(
+		validateID("declarationID", declarationID),
+		validateID("materialID", materialID),
+		validateJSON("declarationJSON", declarationJSON, &declaration),
+	); err != nil {
+		return err
+	}
+	if err := validateAll(
+		validateEnum("declarationType", declaration.DeclarationType, DeclarationConflictMinerals, DeclarationResponsibleSourcing),
+		validateRequired("auditReportHash", declaration.AuditReportHash, maxNameLength),
+		validateText("auditor", declaration.Auditor, maxNameLength),
+	); err != nil {
+		return err
+	}
+	if declaration.DeclarationType == DeclarationConflictMinerals && len(declaration.Smelters) == 0 {
+		return newError(ErrInvalidArgument, "conflict minerals declarations must list the smelters")
+	}
+	for _, smelter := range declaration.Smelters {
+		if err := validateAll(
+			validateID("smelter id", smelter.ID),
+			validateName("smelter name", smelter.Name),
+			validateRequired("smelter country", smelter.Country, 2),
+			validateText("smelter metal", smelter.Metal, maxNameLength),
+		); err != nil {
+			return err
+		}
+	}
+
+	validFrom, err := time.Parse(time.RFC3339, declaration.ValidFrom)
+	if err != nil {
+		return newError(ErrInvalidArgument, "validFrom must be an RFC3339 time")
+	}
+	validUntil, err := time.Parse(time.RFC3339, declaration.ValidUntil)
+	if err != nil {
+		return newError(ErrInvalidArgument, "validUntil must be an RFC3339 time")
+	}
+	if !validUntil.After(validFrom) {
+		return newError(ErrInvalidArgument, "validUntil must be after validFrom")
+	}
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	if !validUntil.After(now) {
+		return newError(ErrInvalidArgument, "declaration %s expired at %s", declarationID, declaration.ValidUntil)
+	}
+
+	supplier, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to get supplier identity: %v", err)
+	}
+
+	// CHECK PERMISSION - Only the supplier of the material can declare its sourcing
+	roleContract := &RoleManagementContract{}
+	hasPermission, err := roleContract.CheckPermission(ctx, supplier, "CREATE_MATERIAL")
+	if err != nil || !hasPermission {
+		return newError(ErrPermissionDenied, "caller %s does not have permission to declare material sourcing", supplier)
+	}
+	inventory, err := s.GetMaterialInventory(ctx, materialID, supplier)
+	if err != nil {
+		return err
+	}
+	if inventory.Supplier != supplier {
+		return newError(ErrPermissionDenied, "material %s was supplied by %s, not %s", materialID, inventory.Supplier, supplier)
+	}
+
+	key := sourcingDeclarationKey(materialID, declarationID)
+	existing, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return fmt.Errorf("failed to read sourcing declaration: %v", err)
+	}
+	if existing != nil {
+		return newError(ErrAlreadyExists, "sourcing declaration %s already exists for material %s", declarationID, materialID)
+	}
+
+	declaration.ID = declarationID
+	declaration.MaterialID = materialID
+	declaration.Supplier = supplier
+	declaration.SubmittedAt = now.UTC().Format(time.RFC3339)
+	declaration.TxID = ctx.GetStub().GetTxID()
+	declaration.SchemaVersion = CurrentSchemaVersion
+	if declaration.Smelters == nil {
+		declaration.Smelters = []Smelter{}
+	}
+
+	declarationBytes, err := json.Marshal(declaration)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState(key, declarationBytes)
+	if err != nil {
+		return fmt.Errorf("failed to store sourcing declaration: %v", err)
+	}
+
+	return emitEvent(ctx, ChaincodeEvent{
+		EventType:  EventSourcingDeclarationSubmitted,
+		EntityType: EventEntityMaterial,
+		EntityID:   materialID,
+		Attributes: map[string]interface{}{
+			"declarationId":   declarationID,
+			"declarationType": declaration.DeclarationType,
+			"smelters":        len(declaration.Smelters),
+			"validUntil":      declaration.ValidUntil,
+		},
+	})
+}
+
+// GetSourcingDeclarations returns every sourcing declaration submitted for a material,
+// including expired ones
+func (s *SupplyChainContract) GetSourcingDeclarations(ctx contractapi.TransactionContextInterface,
+	materialID string) ([]*SourcingDeclaration, error) {
+
+	if err := validateID("materialID", materialID); err != nil {
+		return nil, err
+	}
+
+	prefix := sourcingDeclarationKey(materialID, "")
+	resultsIterator, err := ctx.GetStub().GetStateByRange(prefix, prefix+"~")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query sourcing declarations: %v", err)
+	}
+	defer resultsIterator.Close()
+
+	declarations := []*SourcingDeclaration{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var declaration SourcingDeclaration
+		err = json.Unmarshal(queryResponse.Value, &declaration)
+		if err != nil {
+			return nil, err
+		}
+		declaration.upgradeSchema()
+		declarations = append(declarations, &declaration)
+	}
+
+	return declarations, nil
+}
+
+// GetSourcingComplianceGaps lists the materials an organization has in stock that lack
+// a sourcing declaration from their supplier covering the transaction time
+func (s *SupplyChainContract) GetSourcingComplianceGaps(ctx contractapi.TransactionContextInterface,
+	orgMSPID string) ([]*SourcingComplianceGap, error) {
+
+	if err := validateID("orgMSPID", orgMSPID); err != nil {
+		return nil, err
+	}
+	now, err := txTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	queryString := fmt.Sprintf(`{"selector":{"owner":"%s","materialId":{"$exists":true},"available":{"$gt":0}},`+
+		`"use_index":["_design/indexMaterialOwnerDoc","indexMaterialOwner"]}`, orgMSPID)
+	resultsIterator, err := ctx.GetStub().GetQueryResult(queryString)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query material inventories: %v", err)
+	}
+	defer resultsIterator.Close()
+
+	declarationsByMaterial := make(map[string][]*SourcingDeclaration)
+	gaps := []*SourcingComplianceGap{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var inventory MaterialInventory
+		err = json.Unmarshal(queryResponse.Value, &inventory)
+		if err != nil {
+			continue
+		}
+
+		declarations, ok := declarationsByMaterial[inventory.MaterialID]
+		if !ok {
+			declarations, err = s.GetSourcingDeclarations(ctx, inventory.MaterialID)
+			if err != nil {
+				return nil, err
+			}
+			declarationsByMaterial[inventory.MaterialID] = declarations
+		}
+
+		gap := &SourcingComplianceGap{
+			MaterialID:   inventory.MaterialID,
+			MaterialType: inventory.Type,
+			Supplier:     inventory.Supplier,
+			Available:    inventory.Available,
+			Reason:       SourcingGapMissing,
+		}
+		current := false
+		var lastExpiry time.Time
+		for _, declaration := range declarations {
+			if declaration.Supplier != inventory.Supplier {
+				continue
+			}
+			if declaration.validAt(now) {
+				current = true
+				break
+			}
+			validUntil, err := time.Parse(time.RFC3339, declaration.ValidUntil)
+			if err == nil && !validUntil.After(now) && validUntil.After(lastExpiry) {
+				lastExpiry = validUntil
+				gap.Reason = SourcingGapExpired
+				gap.LastValidUntil = declaration.ValidUntil
+			}
+		}
+		if !current {
+			gaps = append(gaps, gap)
+		}
+	}
+
+	return gaps, nil
+}
+
+// maxUpstreamSources caps the upstream sources declared on one material inventory
+const maxUpstreamSources = 50
+
+// UpstreamSource is a source behind a material's supplier, such as the hide farm
+// supplying a tannery or the mine supplying a refinery
+type UpstreamSource struct {
+	ID            string `json:"id"`
+	Name          string `json:"name"`
+	Role          string `json:"role"`                                         // e.g. hide farm, tannery, refinery, mine
+	Country       string `json:"country"`                                      // ISO 3166 alpha-2 code
+	SuppliesID    string `json:"suppliesId,omitempty" metadata:",optional"`    // Upstream source this one supplies, empty for the supplier itself
+	Tier          int    `json:"tier"`                                         // 2 for the supplier's own sources, 3 for theirs, and so on
+	Certification string `json:"certification,omitempty" metadata:",optional"` // e.g. an LWG audit or farm assurance reference
+}
+
+// MaterialProvenance is the supply chain of a material up from its owner
+type MaterialProvenance struct {
+	MaterialID      string           `json:"materialId"`
+	MaterialType    string           `json:"materialType"`
+	Owner           string           `json:"owner"`
+	Supplier        string           `json:"supplier"` // Tier 1
+	UpstreamSources []UpstreamSource `json:"upstreamSources"`
+	DeclaredAt      string           `json:"declaredAt,omitempty" metadata:",optional"`
+}
+
+// DeclareUpstreamSources records the sources behind a material the caller supplied.
+// sourcesJSON lists id, name, role, country, certification and suppliesId, where
+// suppliesId names a source listed before it. Calling it again replaces the list.
+func (s *SupplyChainContract) DeclareUpstreamSources(ctx contractapi.TransactionContextInterface,
+	materialID string, sourcesJSON string) error {
+
+	var sources []UpstreamSource
+	if err := validateAll(
+		validateID("materialID", materialID),
+		validateJSON("sourcesJSON", sourcesJSON, &sources),
+	); err != nil {
+		return err
+	}
+	if len(sources) > maxUpstreamSources {
+		return newError(ErrInvalidArgument, "at most %d upstream sources can be declared", maxUpstreamSources)
+	}
+
+	// Tiers follow from suppliesId. Referencing only earlier sources rules out cycles.
+	tiers := make(map[string]int, len(sources))
+	maxTier := 0
+	for i := range sources {
+		source := &sources[i]
+		if err := validateAll(
+			validateID("source id", source.ID),
+			validateName("source name", source.Name),
+			validateName("source role", source.Role),
+			validateRequired("source country", source.Country, 2),
+			validateText("source certification", source.Certification, maxNameLength),
+		); err != nil {
+			return err
+		}
+		if _, ok := tiers[source.ID]; ok {
+			return newError(ErrInvalidArgument, "upstream source %s is listed twice", source.ID)
+		}
+		source.Tier = 2
+		if source.SuppliesID != "" {
+			suppliedTier, ok := tiers[source.SuppliesID]
+			if !ok {
+				return newError(ErrInvalidArgument, "upstream source %s supplies %s, which is not listed before it", source.ID, source.SuppliesID)
+			}
+			source.Tier = suppliedTier + 1
+		}
+		tiers[source.ID] = source.Tier
+		if source.Tier > maxTier {
+			maxTier = source.Tier
+		}
+	}
+
+	supplier, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to get supplier identity: %v", err)
+	}
+
+	// CHECK PERMISSION - Only the supplier of the material can declare its sources
+	roleContract := &RoleManagementContract{}
+	hasPermission, err := roleContract.CheckPermission(ctx, supplier, "CREATE_MATERIAL")
+	if err != nil || !hasPermission {
+		return newError(ErrPermissionDenied, "caller %s does not have permission to declare upstream sources", supplier)
+	}
+	inventory, err := s.GetMaterialInventory(ctx, materialID, supplier)
+	if err != nil {
+		return err
+	}
+	if inventory.Supplier != supplier {
+		return newError(ErrPermissionDenied, "material %s was supplied by %s, not %s", materialID, inventory.Supplier, supplier)
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	inventory.UpstreamSources = sources
+	inventory.UpstreamDeclaredAt = now.UTC().Format(time.RFC3339)
+	err = putMaterialInventory(ctx, fmt.Sprintf("material_inventory_%s_%s", materialID, supplier), inventory)
+	if err != nil {
+		return err
+	}
+
+	return emitEvent(ctx, ChaincodeEvent{
+		EventType:  EventUpstreamSourcesDeclared,
+		EntityType: EventEntityMaterial,
+		EntityID:   materialID,
+		Attributes: map[string]interface{}{
+			"sources": len(sources),
+			"maxTier": maxTier,
+		},
+	})
+}
+
+// GetMaterialProvenance returns the supplier of an organization's material and the
+// upstream sources the supplier declared for it
+func (s *SupplyChainContract) GetMaterialProvenance(ctx contractapi.TransactionContextInterface,
+	materialID string, organization string) (*MaterialProvenance, error) {
+
+	inventory, err := s.GetMaterialInventory(ctx, materialID, organization)
+	if err != nil {
+		return nil, err
+	}
+
+	provenance := &MaterialProvenance{
+		MaterialID:      materialID,
+		MaterialType:    inventory.Type,
+		Owner:           inventory.Owner,
+		Supplier:        inventory.Supplier,
+		UpstreamSources: []UpstreamSource{},
+	}
+
+	// The declaration lives on the supplier's own inventory of the material
+	supplierInventory := inventory
+	if inventory.Supplier != organization {
+		supplierInventory, err = s.GetMaterialInventory(ctx, materialID, inventory.Supplier)
+		if err != nil {
+			logFor(ctx).Warn("supplier inventory unavailable for provenance", "materialId", materialID, "supplier", inventory.Supplier, "error", err)
+			return provenance, nil
+		}
+	}
+	if supplierInventory.UpstreamSources != nil {
+		provenance.UpstreamSources = supplierInventory.UpstreamSources
+	}
+	provenance.DeclaredAt = supplierInventory.UpstreamDeclaredAt
+
+	return provenance, nil
+}
@@ -0,0 +1,122 @@
+package contracts
+
+// productStatusTransitions lists the statuses a product may move to from each status.
+// Staying in the same status is always allowed. DESTROYED and WRITTEN_OFF are final. A DEMO
+// unit cannot be shipped or sold until it is converted back to IN_STORE. A DAMAGED
+// product is never sold again; it is destroyed or written off.
+var productStatusTransitions = map[ProductStatus][]ProductStatus{
+	ProductStatusCreated:      {ProductStatusInProduction, ProductStatusInTransit, ProductStatusInStore, ProductStatusDamaged, ProductStatusDestroyed, ProductStatusWrittenOff},
+	ProductStatusInProduction: {ProductStatusInTransit, ProductStatusInStore, ProductStatusDamaged, ProductStatusDestroyed, ProductStatusWrittenOff},
+	ProductStatusInTransit:    {ProductStatusInProduction, ProductStatusInStore, ProductStatusDamaged, ProductStatusDestroyed, ProductStatusWrittenOff},
+	ProductStatusInStore:      {ProductStatusInProduction, ProductStatusInTransit, ProductStatusSold, ProductStatusDemo, ProductStatusDamaged, ProductStatusDestroyed, ProductStatusWrittenOff},
+	ProductStatusDemo:         {ProductStatusInStore, ProductStatusDamaged, ProductStatusDestroyed, ProductStatusWrittenOff},
+	ProductStatusDamaged:      {ProductStatusDestroyed, ProductStatusWrittenOff},
+	ProductStatusSold:         {ProductStatusStolen, ProductStatusInStore, ProductStatusDestroyed}, // Theft, customer return
+	ProductStatusStolen:       {ProductStatusSold},                                                 // Recovered by its owner
+	ProductStatusDestroyed:    {},
+	ProductStatusWrittenOff:   {},
+}
+
+// batchStatusTransitions lists the statuses a batch may move to from each status.
+// Products can be sold individually wherever the batch is, so PARTIAL and SOLD_OUT
+// are reachable from every finalized status; a sold-out batch only changes on returns.
+// MERGED is final: the products left in a merged batch belong to the merge result.
+var batchStatusTransitions = map[BatchStatus][]BatchStatus{
+	BatchStatusAssembling:  {BatchStatusCreated},
+	BatchStatusCreated:     {BatchStatusInTransit, BatchStatusAtWarehouse, BatchStatusAtRetailer, BatchStatusPartial, BatchStatusSold, BatchStatusMerged},
+	BatchStatusInTransit:   {BatchStatusCreated, BatchStatusAtWarehouse, BatchStatusAtRetailer, BatchStatusPartial, BatchStatusSold, BatchStatusMerged},
+	BatchStatusAtWarehouse: {BatchStatusCreated, BatchStatusInTransit, BatchStatusAtRetailer, BatchStatusPartial, BatchStatusSold, BatchStatusMerged},
+	BatchStatusAtRetailer:  {BatchStatusCreated, BatchStatusInTransit, BatchStatusAtWarehouse, BatchStatusPartial, BatchStatusSold, BatchStatusMerged},
+	BatchStatusPartial:     {BatchStatusCreated, BatchStatusInTransit, BatchStatusAtWarehouse, BatchStatusAtRetailer, BatchStatusSold, BatchStatusMerged},
+	BatchStatusSold:        {BatchStatusPartial, BatchStatusAtRetailer},
+	BatchStatusMerged:      {},
+}
+
+// transferStatusTransitions is the 2-Check lifecycle of a transfer: the sender confirms
+// (PENDING), then the receiver (COMPLETED). COMPLETED, CANCELLED, DISPUTED and TIMED_OUT
+// are final.
+var transferStatusTransitions = map[TransferStatus][]TransferStatus{
+	TransferStatusInitiated: {TransferStatusPending, TransferStatusCancelled, TransferStatusDisputed, TransferStatusTimedOut},
+	TransferStatusPending:   {TransferStatusCompleted, TransferStatusCancelled, TransferStatusDisputed, TransferStatusTimedOut},
+	TransferStatusCompleted: {},
+	TransferStatusCancelled: {},
+	TransferStatusDisputed:  {},
+	TransferStatusTimedOut:  {},
+}
+
+// setProductStatus moves a product to status if productStatusTransitions allows it
+func setProductStatus(product *Product, status ProductStatus) error {
+	return setStatus("product", product.ID, &product.Status, status, productStatusTransitions)
+}
+
+// setBatchStatus moves a batch to status if batchStatusTransitions allows it
+func setBatchStatus(batch *ProductBatch, status BatchStatus) error {
+	return setStatus("batch", batch.ID, &batch.Status, status, batchStatusTransitions)
+}
+
+// setTransferStatus moves a transfer to status if transferStatusTransitions allows it.
+// Unlike products and batches, a transfer cannot "move" to the status it is in, so a
+// second ConfirmSent or ConfirmReceived is rejected.
+func setTransferStatus(transfer *Transfer, status TransferStatus) error {
+	allowed, known := transferStatusTransitions[transfer.Status]
+	if transfer.Status == status || (known && len(allowed) == 0) {
+		return newError(ErrInvalidState, "transfer %s is already %s", transfer.ID, transfer.Status)
+	}
+	return setStatus("transfer", transfer.ID, &transfer.Status, status, transferStatusTransitions)
+}
+
+// setStatus is the only place product, batch and transfer statuses change after creation.
+// Records in a status missing from the table predate it and may move anywhere.
+func setStatus[S ~string](entity string, id string, current *S, next S, transitions map[S][]S) error {
+	if *current == next {
+		return nil
+	}
+	if !canTransition(*current, next, transitions) {
+		return newError(ErrInvalidState, "%s %s cannot change from %s to %s", entity, id, *current, next)
+	}
+	*current = next
+	return nil
+}
+
+// canTransition reports whether the transition table allows moving from one status to another
+func canTransition[S ~string](from S, to S, transitions map[S][]S) bool {
+	if from == to {
+		return true
+	}
+	allowed, known := transitions[from]
+	if !known {
+		return true
+	}
+	for _, candidate := range allowed {
+		if candidate == to {
+			return true
+		}
+	}
+	return false
+}
+
+// receivedProductStatus is the status of a product received by an organization with role
+func receivedProductStatus(role OrganizationRole) ProductStatus {
+	switch role {
+	case RoleRetailer, RoleCharity:
+		return ProductStatusInStore
+	case RoleManufacturer:
+		return ProductStatusInProduction
+	default:
+		return ProductStatusInTransit
+	}
+}
+
+// receivedBatchStatus is the status of a batch received by an organization with role
+func receivedBatchStatus(role OrganizationRole) BatchStatus {
+	switch role {
+	case RoleRetailer:
+		return BatchStatusAtRetailer
+	case RoleWarehouse:
+		return BatchStatusAtWarehouse
+	case RoleManufacturer:
+		return BatchStatusCreated
+	default:
+		return BatchStatusInTransit
+	}
+}
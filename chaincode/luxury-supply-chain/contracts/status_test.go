@@ -0,0 +1,80 @@
+package contracts
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetTransferStatusFollowsTransitionTable(t *testing.T) {
+	// Every status accepted as an argument has a row, so none may move anywhere
+	require.Len(t, transferStatusTransitions, len(transferStatuses))
+
+	for _, fromValue := range transferStatuses {
+		from := TransferStatus(fromValue)
+		allowed, known := transferStatusTransitions[from]
+		require.True(t, known, "no transition row for %s", from)
+
+		for _, toValue := range transferStatuses {
+			to := TransferStatus(toValue)
+			want := false
+			for _, candidate := range allowed {
+				want = want || candidate == to
+			}
+
+			t.Run(fromValue+" to "+toValue, func(t *testing.T) {
+				transfer := &Transfer{ID: "T1", Status: from}
+				err := setTransferStatus(transfer, to)
+				if want {
+					require.NoError(t, err)
+					require.Equal(t, to, transfer.Status)
+				} else {
+					require.True(t, hasErrorCode(err, ErrInvalidState), "got %v", err)
+					require.Equal(t, from, transfer.Status)
+				}
+			})
+		}
+	}
+}
+
+func TestSecondConfirmationIsRejected(t *testing.T) {
+	ledger := newTestLedger(t)
+	ledger.put("transfer_T1", Transfer{
+		ID:           "T1",
+		ProductID:    "P1",
+		From:         "LuxeBagsMSP",
+		To:           "LuxuryRetailMSP",
+		TransferType: TransferTypeSupplyChain,
+		Status:       TransferStatusPending,
+		ConsensusDetails: ConsensusInfo{
+			SenderConfirmed: true,
+		},
+	})
+	ledger.put("transfer_T2", Transfer{
+		ID:           "T2",
+		ProductID:    "P2",
+		From:         "LuxeBagsMSP",
+		To:           "LuxuryRetailMSP",
+		TransferType: TransferTypeSupplyChain,
+		Status:       TransferStatusCompleted,
+		ConsensusDetails: ConsensusInfo{
+			SenderConfirmed:   true,
+			ReceiverConfirmed: true,
+		},
+	})
+	contract := &SupplyChainContract{}
+
+	err := contract.ConfirmSent(ledger.as("LuxeBagsMSP"), "T1")
+	require.True(t, hasErrorCode(err, ErrInvalidState), "second ConfirmSent: got %v", err)
+	require.Contains(t, err.Error(), "already PENDING")
+
+	err = contract.ConfirmReceived(ledger.as("LuxuryRetailMSP"), "T2")
+	require.True(t, hasErrorCode(err, ErrInvalidState), "second ConfirmReceived: got %v", err)
+	require.Contains(t, err.Error(), "already COMPLETED")
+
+	var transfer Transfer
+	ledger.get("transfer_T1", &transfer)
+	require.Equal(t, TransferStatusPending, transfer.Status)
+	ledger.get("transfer_T2", &transfer)
+	require.Equal(t, TransferStatusCompleted, transfer.Status)
+}
@@ -0,0 +1,276 @@
+package contracts
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Stolen products are registered by serial number as stolen_serial_<serialNumber> and by
+// NFC chip as stolen_chip_<chipID>, so they are recognized under any product record or
+// QR code that carries the same serial number or chip
+const (
+	stolenSerialKeyPrefix = "stolen_serial_"
+	stolenChipKeyPrefix   = "stolen_chip_"
+)
+
+// Identifier types of the stolen registry
+const (
+	StolenIdentifierSerial = "SERIAL_NUMBER"
+	StolenIdentifierChip   = "NFC_CHIP"
+)
+
+var stolenIdentifierTypes = []string{StolenIdentifierSerial, StolenIdentifierChip}
+
+// StolenRegistryEntry registers one identifier of a product reported stolen. It is
+// removed when the owner recovers the product.
+type StolenRegistryEntry struct {
+	IdentifierType   string `json:"identifierType"`
+	Identifier       string `json:"identifier"`
+	ProductID        string `json:"productId"`
+	Brand            string `json:"brand"`
+	ReportedAt       string `json:"reportedAt"`
+	MovementAttempts int    `json:"movementAttempts"` // Transfers initiated since, see StolenProductMovementAttempt
+	LastAttemptAt    string `json:"lastAttemptAt,omitempty" metadata:",optional"`
+	LastAttemptBy    string `json:"lastAttemptBy,omitempty" metadata:",optional"` // MSP ID of the sender
+	SchemaVersion    int    `json:"schemaVersion"`
+}
+
+// stolenRegistryKey returns the ledger key of a stolen registry entry
+func stolenRegistryKey(identifierType string, identifier string) string {
+	if identifierType == StolenIdentifierChip {
+		return stolenChipKeyPrefix + identifier
+	}
+	return stolenSerialKeyPrefix + identifier
+}
+
+// getStolenRegistryEntry reads a stolen registry entry, or nil if the identifier is not registered
+func getStolenRegistryEntry(ctx contractapi.TransactionContextInterface,
+	identifierType string, identifier string) (*StolenRegistryEntry, error) {
+
+	entryJSON, err := ctx.GetStub().GetState(stolenRegistryKey(identifierType, identifier))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read stolen registry: %v", err)
+	}
+	if entryJSON == nil {
+		return nil, nil
+	}
+
+	var entry StolenRegistryEntry
+	err = json.Unmarshal(entryJSON, &entry)
+	if err != nil {
+		return nil, err
+	}
+	entry.upgradeSchema()
+
+	return &entry, nil
+}
+
+// putStolenRegistryEntry stores a stolen registry entry
+func putStolenRegistryEntry(ctx contractapi.TransactionContextInterface, entry *StolenRegistryEntry) error {
+	entryJSON, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState(stolenRegistryKey(entry.IdentifierType, entry.Identifier), entryJSON)
+	if err != nil {
+		return fmt.Errorf("failed to store stolen registry entry: %v", err)
+	}
+	return nil
+}
+
+// productIdentifiers returns the serial number and current NFC chip ID of a product by
+// identifier type, leaving out those it does not have. Callers range over
+// stolenIdentifierTypes, so the ledger is written in the same order on every peer.
+func productIdentifiers(ctx contractapi.TransactionContextInterface, product *Product) (map[string]string, error) {
+	identifiers := make(map[string]string)
+	if product.SerialNumber != "" {
+		identifiers[StolenIdentifierSerial] = product.SerialNumber
+	}
+
+	ownershipContract := &OwnershipContract{}
+	certificate, err := ownershipContract.GetBirthCertificate(ctx, product.ID)
+	if err != nil && !hasErrorCode(err, ErrNotFound) {
+		return nil, err
+	}
+	if err == nil && certificate.Authenticity.NFCChipID != "" {
+		identifiers[StolenIdentifierChip] = certificate.Authenticity.NFCChipID
+	}
+	return identifiers, nil
+}
+
+// registerStolenProduct registers the identifiers of a product reported stolen
+func registerStolenProduct(ctx contractapi.TransactionContextInterface, product *Product) error {
+	identifiers, err := productIdentifiers(ctx, product)
+	if err != nil {
+		return err
+	}
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, identifierType := range stolenIdentifierTypes {
+		identifier, ok := identifiers[identifierType]
+		if !ok {
+			continue
+		}
+		err = putStolenRegistryEntry(ctx, &StolenRegistryEntry{
+			IdentifierType: identifierType,
+			Identifier:     identifier,
+			ProductID:      product.ID,
+			Brand:          product.Brand,
+			ReportedAt:     now.UTC().Format(time.RFC3339),
+			SchemaVersion:  CurrentSchemaVersion,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unregisterStolenProduct removes the registry entries of a recovered product. An entry
+// another product registered for the same identifier stays.
+func unregisterStolenProduct(ctx contractapi.TransactionContextInterface, product *Product) error {
+	identifiers, err := productIdentifiers(ctx, product)
+	if err != nil {
+		return err
+	}
+
+	for _, identifierType := range stolenIdentifierTypes {
+		identifier, ok := identifiers[identifierType]
+		if !ok {
+			continue
+		}
+		entry, err := getStolenRegistryEntry(ctx, identifierType, identifier)
+		if err != nil {
+			return err
+		}
+		if entry == nil || entry.ProductID != product.ID {
+			continue
+		}
+		err = ctx.GetStub().DelState(stolenRegistryKey(identifierType, identifier))
+		if err != nil {
+			return fmt.Errorf("failed to update stolen registry: %v", err)
+		}
+	}
+	return nil
+}
+
+// stolenRegistryMatches returns the registry entries of a product's serial number and chip
+func stolenRegistryMatches(ctx contractapi.TransactionContextInterface, product *Product) ([]*StolenRegistryEntry, error) {
+	identifiers, err := productIdentifiers(ctx, product)
+	if err != nil {
+		return nil, err
+	}
+
+	matches := []*StolenRegistryEntry{}
+	for _, identifierType := range stolenIdentifierTypes {
+		identifier, ok := identifiers[identifierType]
+		if !ok {
+			continue
+		}
+		entry, err := getStolenRegistryEntry(ctx, identifierType, identifier)
+		if err != nil {
+			return nil, err
+		}
+		if entry != nil {
+			matches = append(matches, entry)
+		}
+	}
+	return matches, nil
+}
+
+// checkStolenProduct rejects an operation on a product whose serial number or chip is
+// registered stolen
+func checkStolenProduct(ctx contractapi.TransactionContextInterface, product *Product) error {
+	matches, err := stolenRegistryMatches(ctx, product)
+	if err != nil {
+		return err
+	}
+	if len(matches) > 0 {
+		return newError(ErrInvalidState, "product %s is registered stolen by %s %s",
+			product.ID, matches[0].IdentifierType, matches[0].Identifier)
+	}
+	return nil
+}
+
+// flagStolenTransfer checks the products a new transfer ships against the stolen
+// registry. Matches are recorded as movement attempts on their entries and listed in
+// the transfer's stolenFlags, which the caller reports with StolenProductMovementAttempt.
+func (s *SupplyChainContract) flagStolenTransfer(ctx contractapi.TransactionContextInterface, transfer *Transfer) error {
+	productIDs, err := s.transferProductIDs(ctx, transfer)
+	if err != nil {
+		return err
+	}
+
+	var flagged []*StolenRegistryEntry
+	for _, productID := range productIDs {
+		product, err := s.GetProduct(ctx, productID)
+		if err != nil {
+			return err
+		}
+		// Products of a batch that were sold or stay behind do not ship with it
+		if product.CurrentOwner != transfer.From {
+			continue
+		}
+		matches, err := stolenRegistryMatches(ctx, product)
+		if err != nil {
+			return err
+		}
+		flagged = append(flagged, matches...)
+	}
+	if len(flagged) == 0 {
+		return nil
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	transfer.StolenFlags = []string{}
+	for _, entry := range flagged {
+		entry.MovementAttempts++
+		entry.LastAttemptAt = now.UTC().Format(time.RFC3339)
+		entry.LastAttemptBy = transfer.From
+		if err := putStolenRegistryEntry(ctx, entry); err != nil {
+			return err
+		}
+		transfer.StolenFlags = append(transfer.StolenFlags, entry.IdentifierType+":"+entry.Identifier)
+	}
+	return nil
+}
+
+// stolenMovementEvent builds the event that replaces a flagged transfer's initiation event
+func stolenMovementEvent(transfer *Transfer, operation string) ChaincodeEvent {
+	event := transferEvent(EventStolenProductMovementAttempt, transfer, "")
+	event.Attributes["operation"] = operation
+	event.Attributes["stolenFlags"] = transfer.StolenFlags
+	return event
+}
+
+// GetStolenRegistryEntry looks up a serial number (SERIAL_NUMBER) or NFC chip ID
+// (NFC_CHIP) in the stolen registry, e.g. for a reseller checking an item offered to it.
+// It fails with NOT_FOUND if the identifier is not registered stolen.
+func (o *OwnershipContract) GetStolenRegistryEntry(ctx contractapi.TransactionContextInterface,
+	identifierType string, identifier string) (*StolenRegistryEntry, error) {
+
+	if err := validateAll(
+		validateEnum("identifierType", identifierType, StolenIdentifierSerial, StolenIdentifierChip),
+		validateRequired("identifier", identifier, maxNameLength),
+	); err != nil {
+		return nil, err
+	}
+
+	entry, err := getStolenRegistryEntry(ctx, identifierType, identifier)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, newError(ErrNotFound, "%s %s is not registered stolen", identifierType, identifier)
+	}
+	return entry, nil
+}
@@ -6,7 +6,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"strconv"
-	"strings"
 	"time"
 
 	"github.com/hyperledger/fabric-contract-api-go/contractapi"
@@ -29,217 +28,644 @@ func (s *SupplyChainContract) InitLedger(ctx contractapi.TransactionContextInter
 	return nil
 }
 
-// CreateBatch creates a batch of products using materials
+// maxBatchChunkSize caps the products written by one AppendBatchProducts call
+const maxBatchChunkSize = 250
+
+// CreateBatch creates a batch of products using materials. optionsJSON sets the
+// optional inputs, see batchOptions: craftsmen credits registered craftsmen per
+// product, and variants splits the batch into variant lines. Expired material lots
+// are rejected unless overrideExpiry is set, which records a waiver signed by the
+// caller on the batch and each of its products. With a templateId the materials
+// follow that bill of materials, see planBatch. chipKeys registers the public key of
+// each product's secure NFC chip.
 func (s *SupplyChainContract) CreateBatch(ctx contractapi.TransactionContextInterface,
-	batchID string, brand string, productType string, quantity int, materialsJSON string) error {
+	batchID string, brand string, productType string, quantity int, materialsJSON string,
+	optionsJSON string) error {
+	
+	if err := validateAll(
+		validateID("batchID", batchID),
+		validateName("brand", brand),
+		validateName("productType", productType),
+		validateQuantity("quantity", float64(quantity)),
+	); err != nil {
+		return err
+	}
+	options, err := parseBatchOptions(optionsJSON)
+	if err != nil {
+		return err
+	}
+
+	plan, err := s.planBatch(ctx, batchID, brand, productType, quantity, materialsJSON, options)
+	if err != nil {
+		return err
+	}
+	batch := plan.batch
+	craftsmen, err := attributeCraftsmen(ctx, batch.Manufacturer, options.Craftsmen, quantity)
+	if err != nil {
+		return err
+	}
+	chipKeys, err := checkBatchChipKeys(options.ChipKeys, quantity)
+	if err != nil {
+		return err
+	}
+	
+	// Everything is checked, consume the materials
+	err = plan.consumeMaterials(ctx)
+	if err != nil {
+		return err
+	}
+	
+	// Generate the products of the batch
+	for i := 1; i <= quantity; i++ {
+		err = s.createBatchProduct(ctx, batch, i, craftsmen[i-1], chipKeys[i-1])
+		if err != nil {
+			return err
+		}
+	}
 	
+	err = putBatch(ctx, batch)
+	if err != nil {
+		return err
+	}
+
+	return emitEvent(ctx, batchCreatedEvent(batch))
+}
+
+// CreateBatchHeader starts a batch too large for one transaction. Materials for the
+// whole quantity are consumed now; products are added with AppendBatchProducts and
+// the batch is sealed with FinalizeBatch. The variants, overrideExpiry and templateId
+// options are fixed here as in CreateBatch; craftsmen and chip keys are passed to
+// AppendBatchProducts.
+func (s *SupplyChainContract) CreateBatchHeader(ctx contractapi.TransactionContextInterface,
+	batchID string, brand string, productType string, quantity int, materialsJSON string,
+	optionsJSON string) error {
+
+	if err := validateAll(
+		validateID("batchID", batchID),
+		validateName("brand", brand),
+		validateName("productType", productType),
+		validateQuantity("quantity", float64(quantity)),
+	); err != nil {
+		return err
+	}
+	options, err := parseBatchOptions(optionsJSON)
+	if err != nil {
+		return err
+	}
+	if options.Craftsmen != nil || options.ChipKeys != nil {
+		return newError(ErrInvalidArgument, "craftsmen and chipKeys are passed to AppendBatchProducts")
+	}
+
+	plan, err := s.planBatch(ctx, batchID, brand, productType, quantity, materialsJSON, options)
+	if err != nil {
+		return err
+	}
+	err = plan.consumeMaterials(ctx)
+	if err != nil {
+		return err
+	}
+	batch := plan.batch
+	batch.Status = BatchStatusAssembling
+
+	err = putBatch(ctx, batch)
+	if err != nil {
+		return err
+	}
+
+	return emitEvent(ctx, batchCreatedEvent(batch))
+}
+
+// AppendBatchProducts creates the next count products, at most maxBatchChunkSize, of a
+// batch started with CreateBatchHeader and returns how many are still missing.
+// craftsmenJSON and chipKeysJSON credit craftsmen and register chip keys for these
+// products as in CreateBatch.
+func (s *SupplyChainContract) AppendBatchProducts(ctx contractapi.TransactionContextInterface,
+	batchID string, count int, craftsmenJSON string, chipKeysJSON string) (int, error) {
+
+	if err := validateAll(
+		validateID("batchID", batchID),
+		validateQuantity("count", float64(count)),
+	); err != nil {
+		return 0, err
+	}
+	if count > maxBatchChunkSize {
+		return 0, newError(ErrInvalidArgument, "count exceeds %d products per call", maxBatchChunkSize)
+	}
+
+	batch, err := s.getAssemblingBatch(ctx, batchID)
+	if err != nil {
+		return 0, err
+	}
+
+	remaining := batch.Quantity - len(batch.ProductIDs)
+	if count > remaining {
+		return 0, newError(ErrInvalidArgument, "batch %s only needs %d more products", batchID, remaining)
+	}
+	craftsmen, err := parseCraftsmenAttribution(ctx, batch.Manufacturer, craftsmenJSON, count)
+	if err != nil {
+		return 0, err
+	}
+	chipKeys, err := parseBatchChipKeys(chipKeysJSON, count)
+	if err != nil {
+		return 0, err
+	}
+
+	next := len(batch.ProductIDs) + 1
+	for i := next; i < next+count; i++ {
+		err = s.createBatchProduct(ctx, batch, i, craftsmen[i-next], chipKeys[i-next])
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	err = putBatch(ctx, batch)
+	if err != nil {
+		return 0, err
+	}
+
+	err = emitEvent(ctx, ChaincodeEvent{
+		EventType:  EventBatchProductsAppended,
+		EntityType: EventEntityBatch,
+		EntityID:   batchID,
+		Attributes: map[string]interface{}{
+			"count":     count,
+			"remaining": remaining - count,
+		},
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return remaining - count, nil
+}
+
+// FinalizeBatch seals a batch once all its products have been appended
+func (s *SupplyChainContract) FinalizeBatch(ctx contractapi.TransactionContextInterface,
+	batchID string) error {
+
+	if err := validateID("batchID", batchID); err != nil {
+		return err
+	}
+
+	batch, err := s.getAssemblingBatch(ctx, batchID)
+	if err != nil {
+		return err
+	}
+	if len(batch.ProductIDs) != batch.Quantity {
+		return newError(ErrInvalidState, "batch %s has %d of %d products", batchID, len(batch.ProductIDs), batch.Quantity)
+	}
+
+	if err := setBatchStatus(batch, BatchStatusCreated); err != nil {
+		return err
+	}
+	err = putBatch(ctx, batch)
+	if err != nil {
+		return err
+	}
+
+	return emitEvent(ctx, ChaincodeEvent{
+		EventType:  EventBatchFinalized,
+		EntityType: EventEntityBatch,
+		EntityID:   batchID,
+		FromState:  string(BatchStatusAssembling),
+		ToState:    string(BatchStatusCreated),
+		Attributes: map[string]interface{}{
+			"quantity": batch.Quantity,
+		},
+	})
+}
+
+// getAssemblingBatch returns a batch still being assembled by the calling manufacturer
+func (s *SupplyChainContract) getAssemblingBatch(ctx contractapi.TransactionContextInterface,
+	batchID string) (*ProductBatch, error) {
+
+	caller, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get caller identity: %v", err)
+	}
+
+	batch, err := s.GetBatch(ctx, batchID)
+	if err != nil {
+		return nil, err
+	}
+	if batch.Manufacturer != caller {
+		return nil, newError(ErrPermissionDenied, "only the manufacturer can assemble batch %s", batchID)
+	}
+	if batch.Status != BatchStatusAssembling {
+		return nil, newError(ErrInvalidState, "batch %s is not being assembled (status %s)", batchID, batch.Status)
+	}
+
+	return batch, nil
+}
+
+// materialInput is a material entry of a batch, optionally taken from a reservation
+// made with ReserveMaterial
+type materialInput struct {
+	ID            string  `json:"id"`
+	Quantity      float64 `json:"quantity"`
+	ReservationID string  `json:"reservationId,omitempty"`
+}
+
+// batchOptions are the optional inputs of CreateBatch and CreateBatchHeader, passed as
+// one optionsJSON object, e.g. {"templateId":"TPL-TOTE","overrideExpiry":true}.
+// Options left out are not applied.
+type batchOptions struct {
+	Craftsmen      [][]string     `json:"craftsmen"`      // Craftsman IDs per product, see attributeCraftsmen
+	Variants       []BatchVariant `json:"variants"`       // Variant lines, see assignBatchVariants
+	OverrideExpiry bool           `json:"overrideExpiry"` // Use expired material lots under a waiver
+	TemplateID     string         `json:"templateId"`     // Bill of materials, see planBatch
+	ChipKeys       []string       `json:"chipKeys"`       // Chip public key per product, see checkBatchChipKeys
+}
+
+// parseBatchOptions reads the optionsJSON of a batch. An empty optionsJSON sets none.
+func parseBatchOptions(optionsJSON string) (*batchOptions, error) {
+	options := &batchOptions{}
+	if optionsJSON == "" {
+		return options, nil
+	}
+	if err := validateJSON("options", optionsJSON, options); err != nil {
+		return nil, err
+	}
+	return options, nil
+}
+
+// batchPlan is a new batch whose permissions and materials planBatch has checked,
+// with the inventory and reservation changes that consume its materials
+type batchPlan struct {
+	batch         *ProductBatch
+	inventoryKeys []string // In the order of the materials
+	inventories   map[string]*MaterialInventory
+	reservations  []*MaterialReservation
+}
+
+// planBatch checks the caller may create the batch and that all its materials can be
+// used, without writing anything. It returns the batch record without products and
+// the changes consumeMaterials applies, so a batch failing on its third material has
+// not touched the first two. With a templateID and no materials the lots are picked
+// to cover the template, and given materials must match it.
+func (s *SupplyChainContract) planBatch(ctx contractapi.TransactionContextInterface,
+	batchID string, brand string, productType string, quantity int, materialsJSON string,
+	options *batchOptions) (*batchPlan, error) {
+
+	variants, err := assignBatchVariants(options.Variants, batchID, quantity)
+	if err != nil {
+		return nil, err
+	}
+
 	// Check if batch already exists
 	existing, err := ctx.GetStub().GetState("batch_" + batchID)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if existing != nil {
-		return fmt.Errorf("batch %s already exists", batchID)
+		return nil, newError(ErrAlreadyExists, "batch %s already exists", batchID)
 	}
 	
 	// Get manufacturer identity
 	manufacturer, err := ctx.GetClientIdentity().GetMSPID()
 	if err != nil {
-		return fmt.Errorf("failed to get manufacturer identity: %v", err)
+		return nil, fmt.Errorf("failed to get manufacturer identity: %v", err)
 	}
 	
 	// CHECK PERMISSION - Only manufacturers can create batches
 	roleContract := &RoleManagementContract{}
 	hasPermission, err := roleContract.CheckPermission(ctx, manufacturer, "CREATE_BATCH")
 	if err != nil || !hasPermission {
-		return fmt.Errorf("caller %s does not have permission to create batches", manufacturer)
-	}
-	
-	// MaterialInput represents input format for materials with quantities
-	type MaterialInput struct {
-		ID       string  `json:"id"`
-		Quantity float64 `json:"quantity"`
+		return nil, newError(ErrPermissionDenied, "caller %s does not have permission to create batches", manufacturer)
 	}
 	
 	// Parse materials with quantities
-	var materials []MaterialInput
+	var materials []materialInput
 	if materialsJSON != "" {
-		err = json.Unmarshal([]byte(materialsJSON), &materials)
-		if err != nil {
-			return fmt.Errorf("invalid materials format: %v", err)
+		if err := validateJSON("materials", materialsJSON, &materials); err != nil {
+			return nil, err
 		}
 	}
+	var reservationIDs []string
+	for _, mat := range materials {
+		if err := validateAll(
+			validateID("material id", mat.ID),
+			validateQuantity("material quantity", mat.Quantity),
+		); err != nil {
+			return nil, err
+		}
+		if mat.ReservationID != "" {
+			if err := validateID("reservation id", mat.ReservationID); err != nil {
+				return nil, err
+			}
+			if containsString(reservationIDs, mat.ReservationID) {
+				return nil, newError(ErrInvalidArgument, "reservation %s is listed twice", mat.ReservationID)
+			}
+			reservationIDs = append(reservationIDs, mat.ReservationID)
+		}
+	}
+	
+	// Regulated materials may only go into batches while their certificates are valid
+	regulated, err := getRegulatedMaterials(ctx)
+	if err != nil {
+		return nil, err
+	}
+	now, err := txTime(ctx)
+	if err != nil {
+		return nil, err
+	}
 	
+	var template *ProductTemplate
+	if templateID := options.TemplateID; templateID != "" {
+		if err := validateID("templateId", templateID); err != nil {
+			return nil, err
+		}
+		template, err = batchTemplate(ctx, templateID, manufacturer, brand, productType)
+		if err != nil {
+			return nil, err
+		}
+		if len(materials) == 0 {
+			materials, err = s.selectTemplateMaterials(ctx, template, manufacturer, quantity, regulated, now)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
 	// Track material usage (initialize to empty array to avoid null)
 	materialsUsed := []MaterialUsage{}
+	var expiredUsed []ExpiredMaterialUse
+	plan := &batchPlan{inventories: make(map[string]*MaterialInventory)}
 	for _, mat := range materials {
-		// Get material inventory
+		// Get material inventory; a material listed twice draws on the same stock
 		inventoryKey := fmt.Sprintf("material_inventory_%s_%s", mat.ID, manufacturer)
-		inventoryJSON, err := ctx.GetStub().GetState(inventoryKey)
-		if err != nil {
-			return err
-		}
-		if inventoryJSON == nil {
-			return fmt.Errorf("material %s not in manufacturer's inventory", mat.ID)
-		}
-		
-		var inventory MaterialInventory
-		err = json.Unmarshal(inventoryJSON, &inventory)
-		if err != nil {
-			return err
+		inventory, ok := plan.inventories[inventoryKey]
+		if !ok {
+			inventoryJSON, err := ctx.GetStub().GetState(inventoryKey)
+			if err != nil {
+				return nil, err
+			}
+			if inventoryJSON == nil {
+				return nil, newError(ErrInsufficientInventory, "material %s not in manufacturer's inventory", mat.ID)
+			}
+			
+			inventory = &MaterialInventory{}
+			err = json.Unmarshal(inventoryJSON, inventory)
+			if err != nil {
+				return nil, err
+			}
+			if err := checkMaterialCertification(inventory, regulated, now); err != nil {
+				return nil, err
+			}
+			plan.inventoryKeys = append(plan.inventoryKeys, inventoryKey)
+			plan.inventories[inventoryKey] = inventory
 		}
 		
 		// Use the specified quantity per batch
 		totalUsage := mat.Quantity
 		
-		if inventory.Available < totalUsage {
-			return fmt.Errorf("insufficient material %s: need %.2f, have %.2f", mat.ID, totalUsage, inventory.Available)
+		// Expired lots need a waiver from the manufacturer
+		if materialExpired(inventory, now) {
+			if !options.OverrideExpiry {
+				return nil, newError(ErrInvalidState, "material %s expired at %s, set overrideExpiry to use it under a waiver", mat.ID, inventory.ExpiryDate)
+			}
+			expiredUsed = append(expiredUsed, ExpiredMaterialUse{
+				MaterialID: mat.ID,
+				Batch:      inventory.Batch,
+				ExpiryDate: inventory.ExpiryDate,
+				Quantity:   totalUsage,
+			})
 		}
 		
-		// Deduct from inventory
-		inventory.Available -= totalUsage
-		inventory.Used += totalUsage
-		
-		// Update inventory
-		updatedInventoryJSON, err := json.Marshal(inventory)
-		if err != nil {
-			return err
-		}
-		err = ctx.GetStub().PutState(inventoryKey, updatedInventoryJSON)
-		if err != nil {
-			return err
+		if mat.ReservationID != "" {
+			// Take the quantity from the reservation and release what the batch does not need
+			reservation, err := s.GetMaterialReservation(ctx, mat.ReservationID)
+			if err != nil {
+				return nil, err
+			}
+			if reservation.MaterialID != mat.ID || reservation.Status != ReservationActive {
+				return nil, newError(ErrInvalidState, "reservation %s is not an active reservation of material %s", mat.ReservationID, mat.ID)
+			}
+			err = consumeReservation(ctx, reservation, inventory, totalUsage, batchID)
+			if err != nil {
+				return nil, err
+			}
+			plan.reservations = append(plan.reservations, reservation)
+		} else {
+			if inventory.Available < totalUsage {
+				return nil, newError(ErrInsufficientInventory, "insufficient material %s: need %.2f, have %.2f", mat.ID, totalUsage, inventory.Available)
+			}
+			
+			// Deduct from the planned inventory
+			inventory.Available -= totalUsage
+			inventory.Used += totalUsage
 		}
 		
 		// Track usage
-		materialsUsed = append(materialsUsed, MaterialUsage{
+		usage := MaterialUsage{
 			MaterialID:   mat.ID,
 			MaterialType: inventory.Type,
 			Supplier:     inventory.Supplier,
 			QuantityUsed: totalUsage,
 			Batch:        inventory.Batch,
-		})
-	}
-	
-	// Generate product IDs for the batch
-	var productIDs []string
-	for i := 1; i <= quantity; i++ {
-		productID := fmt.Sprintf("%s-P%04d", batchID, i)
-		productIDs = append(productIDs, productID)
-		
-		// Create individual product
-		product := Product{
-			ID:               productID,
-			BatchID:          batchID,
-			Brand:            brand,
-			Name:             fmt.Sprintf("%s #%d", productType, i),
-			Type:             productType,
-			SerialNumber:     fmt.Sprintf("%s-%04d", batchID, i),
-			UniqueIdentifier: fmt.Sprintf("%04d", i),
-			CreatedAt:        time.Now().Format(time.RFC3339),
-			CurrentOwner:     manufacturer,
-			CurrentLocation:  manufacturer,
-			Status:           ProductStatusCreated,
-			IsStolen:         false,
-			StolenDate:       "N/A",
-			RecoveredDate:    "N/A",
-			Materials:        []Material{},
-			Metadata:         make(map[string]interface{}),
-		}
-		
-		// Add materials info to product
-		for _, matUsage := range materialsUsed {
-			product.Materials = append(product.Materials, Material{
-				ID:           matUsage.MaterialID,
-				Type:         matUsage.MaterialType,
-				Supplier:     matUsage.Supplier,
-				Batch:        matUsage.Batch,
-				QuantityUsed: matUsage.QuantityUsed / float64(quantity), // Per product
-				Verification: "batch_verified",
-				ReceivedDate: time.Now().Format(time.RFC3339),
-			})
 		}
-		
-		productJSON, err := json.Marshal(product)
+		emissions, err := s.materialEmissions(ctx, inventory)
 		if err != nil {
-			return err
-		}
-		err = ctx.GetStub().PutState(productID, productJSON)
-		if err != nil {
-			return err
-		}
-		
-		// Create birth certificate for each product
-		// Create material records from product materials
-		// Initialize as empty slice to ensure it's never nil
-		materialRecords := []MaterialRecord{}
-		for _, material := range product.Materials {
-			record := MaterialRecord{
-				Type:     material.Type,
-				Source:   material.Source,
-				Supplier: material.Supplier,
-				Batch:    material.Batch,
-			}
-			materialRecords = append(materialRecords, record)
+			return nil, err
 		}
-		
-		// Create certificate
-		certificate := DigitalBirthCertificate{
-			ProductID:          productID,
-			Brand:              product.Brand,
-			ManufacturingDate:  product.CreatedAt,
-			ManufacturingPlace: manufacturer,
-			Craftsman:          fmt.Sprintf("%s Production Team", manufacturer),
-			Materials:          materialRecords,
-			Authenticity:       AuthenticityDetails{
-				NFCChipID:        fmt.Sprintf("NFC-%s", product.SerialNumber),
-				QRCodeData:       fmt.Sprintf("QR-%s", productID),
-				HologramID:       fmt.Sprintf("HOLO-%s", product.SerialNumber),
-				SecurityFeatures: []string{"Anti-counterfeit tag", "Hologram", "NFC chip"},
-			},
-			InitialPhotos:      []string{},
+		applyMaterialEmissions(&usage, emissions, now)
+		materialsUsed = append(materialsUsed, usage)
+	}
+	
+	metadata := make(map[string]string)
+	if template != nil {
+		if err := checkTemplateUsage(template, materialsUsed, quantity); err != nil {
+			return nil, err
 		}
-		
-		// Calculate certificate hash
-		certData, _ := json.Marshal(certificate)
-		hash := sha256.Sum256(certData)
-		certificate.CertificateHash = hex.EncodeToString(hash[:])
-		
-		// Store certificate
-		certKey := "cert_" + productID
-		certJSON, err := json.Marshal(certificate)
+		metadata["templateId"] = options.TemplateID
+	}
+	if len(expiredUsed) > 0 {
+		waiver, err := newExpiryWaiver(ctx, expiredUsed, now)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		
-		err = ctx.GetStub().PutState(certKey, certJSON)
+		waiverJSON, err := json.Marshal(waiver)
 		if err != nil {
-			return err
+			return nil, err
 		}
+		metadata["expiryWaiver"] = string(waiverJSON)
 	}
 	
-	// Create batch record
-	batch := ProductBatch{
+	plan.batch = &ProductBatch{
+		SchemaVersion: CurrentSchemaVersion,
 		ID:              batchID,
 		Manufacturer:    manufacturer,
 		Brand:           brand,
 		ProductType:     productType,
 		Quantity:        quantity,
-		ProductIDs:      productIDs,
+		ProductIDs:      []string{},
 		MaterialsUsed:   materialsUsed,
 		ManufactureDate: time.Now().Format(time.RFC3339),
 		QRCode:          fmt.Sprintf("QR-%s-%d", batchID, time.Now().Unix()),
 		CurrentOwner:    manufacturer,
 		CurrentLocation: manufacturer,
 		Status:          BatchStatusCreated,
-		Metadata:        make(map[string]string),
+		Metadata:        metadata,
+		Variants:        variants,
+	}
+	return plan, nil
+}
+
+// consumeMaterials writes the inventory and reservation changes of a checked batch
+func (p *batchPlan) consumeMaterials(ctx contractapi.TransactionContextInterface) error {
+	for _, inventoryKey := range p.inventoryKeys {
+		if err := putMaterialInventory(ctx, inventoryKey, p.inventories[inventoryKey]); err != nil {
+			return err
+		}
 	}
+	for _, reservation := range p.reservations {
+		if err := putReservation(ctx, reservation); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createBatchProduct stores product number i of the batch with its birth certificate
+// crediting craftsmen and binding chipKey, if any, and adds it to batch.ProductIDs
+func (s *SupplyChainContract) createBatchProduct(ctx contractapi.TransactionContextInterface,
+	batch *ProductBatch, i int, craftsmen []*Craftsman, chipKey string) error {
+
+	productID := fmt.Sprintf("%s-P%04d", batch.ID, i)
+	
+	// Create individual product
+	product := Product{
+		SchemaVersion: CurrentSchemaVersion,
+		ID:               productID,
+		BatchID:          batch.ID,
+		Brand:            batch.Brand,
+		Name:             fmt.Sprintf("%s #%d", batch.ProductType, i),
+		Type:             batch.ProductType,
+		SerialNumber:     batchSerialNumber(batch.ID, i),
+		UniqueIdentifier: fmt.Sprintf("%04d", i),
+		CreatedAt:        time.Now().Format(time.RFC3339),
+		CurrentOwner:     batch.Manufacturer,
+		CurrentLocation:  batch.Manufacturer,
+		Status:           ProductStatusCreated,
+		IsStolen:         false,
+		StolenDate:       "N/A",
+		RecoveredDate:    "N/A",
+		Materials:        []Material{},
+		Metadata:         make(map[string]interface{}),
+	}
+	if variant := variantOf(batch, i); variant != nil {
+		product.Variant = variant.Code
+		product.VariantAttributes = variant.Attributes
+	}
+	waiver, err := batchExpiryWaiver(batch)
+	if err != nil {
+		return err
+	}
+	if waiver != nil {
+		product.Metadata["expiryWaiver"] = waiver
+	}
+	
+	// Add materials info to product
+	for _, matUsage := range batch.MaterialsUsed {
+		quantityUsed := matUsage.QuantityUsed / float64(batch.Quantity) // Per product
+		product.Materials = append(product.Materials, Material{
+			ID:           matUsage.MaterialID,
+			Type:         matUsage.MaterialType,
+			Supplier:     matUsage.Supplier,
+			Batch:        matUsage.Batch,
+			QuantityUsed: quantityUsed,
+			Verification: "batch_verified",
+			ReceivedDate: time.Now().Format(time.RFC3339),
+			EmissionFactorKgCO2e: matUsage.EmissionFactorKgCO2e,
+			FootprintKgCO2e:      roundFootprint(quantityUsed * matUsage.EmissionFactorKgCO2e),
+			Certifications:       matUsage.Certifications,
+		})
+	}
+	product.Footprint = productFootprint(product.Materials)
 	
-	batchJSON, err := json.Marshal(batch)
+	err = putProduct(ctx, &product)
 	if err != nil {
 		return err
 	}
+	err = indexProductIdentifier(ctx, &product)
+	if err != nil {
+		return err
+	}
+	
+	// Create birth certificate for each product
+	// Create material records from product materials
+	// Initialize as empty slice to ensure it's never nil
+	materialRecords := []MaterialRecord{}
+	for _, material := range product.Materials {
+		record := MaterialRecord{
+			Type:     material.Type,
+			Source:   material.Source,
+			Supplier: material.Supplier,
+			Batch:    material.Batch,
+		}
+		materialRecords = append(materialRecords, record)
+	}
 	
-	return ctx.GetStub().PutState("batch_"+batchID, batchJSON)
+	qrCode, err := issueQRCode(ctx, productID, batch.ID, 1)
+	if err != nil {
+		return err
+	}
+
+	// Create certificate
+	certificate := DigitalBirthCertificate{
+		SchemaVersion: CurrentSchemaVersion,
+		ProductID:          productID,
+		Brand:              product.Brand,
+		ManufacturingDate:  product.CreatedAt,
+		ManufacturingPlace: batch.Manufacturer,
+		Materials:          materialRecords,
+		Authenticity:       AuthenticityDetails{
+			NFCChipID:        fmt.Sprintf("NFC-%s", product.SerialNumber),
+			QRCodeData:       qrCode,
+			HologramID:       fmt.Sprintf("HOLO-%s", product.SerialNumber),
+			SecurityFeatures: []string{"Anti-counterfeit tag", "Hologram", "NFC chip"},
+		},
+		InitialPhotos:      []string{},
+		Revision:           1,
+		Sustainability:     product.Footprint,
+	}
+	if chipKey != "" {
+		certificate.Authenticity.NFCChipPublicKeys = []string{chipKey}
+	}
+	err = creditCraftsmen(ctx, batch.Manufacturer, &certificate, craftsmen)
+	if err != nil {
+		return err
+	}
+	
+	// Commit to salted per-field hashes for selective disclosure
+	err = prepareCertificateDisclosure(ctx, &certificate)
+	if err != nil {
+		return err
+	}
+	
+	// Calculate certificate hash
+	certData, _ := json.Marshal(certificate)
+	hash := sha256.Sum256(certData)
+	certificate.CertificateHash = hex.EncodeToString(hash[:])
+	
+	// Store certificate
+	certKey := "cert_" + productID
+	certJSON, err := json.Marshal(certificate)
+	if err != nil {
+		return err
+	}
+	
+	err = ctx.GetStub().PutState(certKey, certJSON)
+	if err != nil {
+		return err
+	}
+	err = indexNFCChip(ctx, certificate.Authenticity.NFCChipID, productID)
+	if err != nil {
+		return err
+	}
+
+	batch.ProductIDs = append(batch.ProductIDs, productID)
+	return nil
 }
 
 // Note: AddMaterial removed - materials are only added during batch creation
@@ -252,14 +678,36 @@ func (s *SupplyChainContract) CreateBatch(ctx contractapi.TransactionContextInte
 // TransferBatch transfers an entire batch between organizations
 func (s *SupplyChainContract) TransferBatch(ctx contractapi.TransactionContextInterface,
 	transferID string, batchID string, to string) error {
+	return s.transferBatch(ctx, transferID, batchID, to, TransferTypeSupplyChain)
+}
+
+// TransferBatchOnConsignment places a batch with a retailer on consignment. The
+// retailer holds and sells its products, but the sender stays their owner until each
+// one is sold, see SettleConsignmentSale.
+func (s *SupplyChainContract) TransferBatchOnConsignment(ctx contractapi.TransactionContextInterface,
+	transferID string, batchID string, to string) error {
+	return s.transferBatch(ctx, transferID, batchID, to, TransferTypeConsignment)
+}
+
+// transferBatch starts a transfer of transferType for an entire batch
+func (s *SupplyChainContract) transferBatch(ctx contractapi.TransactionContextInterface,
+	transferID string, batchID string, to string, transferType TransferType) error {
 	
+	if err := validateAll(
+		validateID("transferID", transferID),
+		validateID("batchID", batchID),
+		validateID("to", to),
+	); err != nil {
+		return err
+	}
+
 	// Get batch
 	batchJSON, err := ctx.GetStub().GetState("batch_" + batchID)
 	if err != nil {
 		return err
 	}
 	if batchJSON == nil {
-		return fmt.Errorf("batch %s does not exist", batchID)
+		return newError(ErrNotFound, "batch %s does not exist", batchID)
 	}
 	
 	var batch ProductBatch
@@ -274,18 +722,42 @@ func (s *SupplyChainContract) TransferBatch(ctx contractapi.TransactionContextIn
 		return err
 	}
 	
-	// Verify sender owns the batch
-	if batch.CurrentOwner != sender {
-		return fmt.Errorf("sender does not own the batch")
+	// Verify sender owns the batch, or holds it on consignment
+	if batch.CurrentOwner != sender && !batch.Consignment.heldBy(sender) {
+		return newError(ErrPermissionDenied, "sender does not own the batch")
+	}
+	if err := checkConsignedShipment(batch.Consignment, batchID, sender, to, transferType); err != nil {
+		return err
+	}
+	if batch.Status == BatchStatusAssembling {
+		return newError(ErrInvalidState, "batch %s has not been finalized", batchID)
+	}
+	if !canTransition(batch.Status, BatchStatusInTransit, batchStatusTransitions) {
+		return newError(ErrInvalidState, "batch %s cannot be shipped in status %s", batchID, batch.Status)
+	}
+	err = s.checkBrandApproval(ctx, batchID, sender)
+	if err != nil {
+		return err
+	}
+	err = checkTransferFlow(ctx, FlowItemBatch, sender, to)
+	if err != nil {
+		return err
+	}
+	if transferType == TransferTypeConsignment {
+		err = checkConsignee(ctx, to)
+		if err != nil {
+			return err
+		}
 	}
 	
 	// Create transfer record
 	transfer := Transfer{
+		SchemaVersion: CurrentSchemaVersion,
 		ID:           transferID,
 		ProductID:    batchID, // Using batch ID as product ID
 		From:         sender,
 		To:           to,
-		TransferType: TransferTypeSupplyChain,
+		TransferType: transferType,
 		InitiatedAt:  time.Now().Format(time.RFC3339),
 		CompletedAt:  "PENDING",
 		Status:       TransferStatusInitiated,
@@ -294,9 +766,13 @@ func (s *SupplyChainContract) TransferBatch(ctx contractapi.TransactionContextIn
 			ReceiverConfirmed: false,
 			SenderTimestamp:   "PENDING",
 			ReceiverTimestamp: "PENDING",
-			TimeoutAt:         time.Now().Add(24 * time.Hour).Format(time.RFC3339),
 		},
 	}
+	transfer.ConsensusDetails.SenderTier = senderTrustTier(ctx, sender)
+	transfer.ConsensusDetails.TimeoutAt, err = transferTimeoutAt(ctx, &transfer)
+	if err != nil {
+		return err
+	}
 	
 	// Store metadata about batch transfer
 	if transfer.Metadata == nil {
@@ -305,30 +781,37 @@ func (s *SupplyChainContract) TransferBatch(ctx contractapi.TransactionContextIn
 	transfer.Metadata["type"] = "BATCH"
 	transfer.Metadata["quantity"] = batch.Quantity
 	transfer.Metadata["productType"] = batch.ProductType
-	
-	transferJSON, err := json.Marshal(transfer)
+	err = s.flagStolenTransfer(ctx, &transfer)
 	if err != nil {
 		return err
 	}
 	
-	err = ctx.GetStub().PutState("transfer_"+transferID, transferJSON)
+	err = putTransfer(ctx, &transfer)
 	if err != nil {
 		return err
 	}
 	
 	// Emit event
-	err = ctx.GetStub().SetEvent("BatchTransferInitiated", transferJSON)
-	if err != nil {
-		return err
+	event := transferEvent(EventBatchTransferInitiated, &transfer, "")
+	if len(transfer.StolenFlags) > 0 {
+		event = stolenMovementEvent(&transfer, "TransferBatch")
 	}
-	
-	return nil
+	event.Attributes["quantity"] = batch.Quantity
+	return emitEvent(ctx, event)
 }
 
 // InitiateTransfer starts a B2B transfer with 2-Check consensus
 func (s *SupplyChainContract) InitiateTransfer(ctx contractapi.TransactionContextInterface,
 	transferID string, productID string, to string, transferTypeStr string) error {
 	
+	if err := validateAll(
+		validateID("transferID", transferID),
+		validateID("productID", productID),
+		validateID("to", to),
+	); err != nil {
+		return err
+	}
+
 	// Convert string to TransferType
 	var transferType TransferType
 	switch transferTypeStr {
@@ -338,6 +821,12 @@ func (s *SupplyChainContract) InitiateTransfer(ctx contractapi.TransactionContex
 		transferType = TransferTypeOwnership
 	case "RETURN":
 		transferType = TransferTypeReturn
+	case "DONATION":
+		transferType = TransferTypeDonation
+	case "LOGISTICS":
+		transferType = TransferTypeLogistics
+	case "CONSIGNMENT":
+		transferType = TransferTypeConsignment
 	default:
 		transferType = TransferTypeSupplyChain
 	}
@@ -345,7 +834,7 @@ func (s *SupplyChainContract) InitiateTransfer(ctx contractapi.TransactionContex
 	// Check if transfer already exists
 	existingTransfer, _ := s.GetTransfer(ctx, transferID)
 	if existingTransfer != nil {
-		return fmt.Errorf("transfer %s already exists", transferID)
+		return newError(ErrAlreadyExists, "transfer %s already exists", transferID)
 	}
 
 	// Get product
@@ -360,13 +849,42 @@ func (s *SupplyChainContract) InitiateTransfer(ctx contractapi.TransactionContex
 		return fmt.Errorf("failed to get sender identity: %v", err)
 	}
 
-	// Verify sender owns the product
-	if product.CurrentOwner != sender {
-		return fmt.Errorf("sender does not own the product")
+	// Verify sender owns the product, or holds it on consignment
+	if product.CurrentOwner != sender && !product.Consignment.heldBy(sender) {
+		return newError(ErrPermissionDenied, "sender does not own the product")
+	}
+	if err := checkConsignedShipment(product.Consignment, productID, sender, to, transferType); err != nil {
+		return err
+	}
+	if !canTransition(product.Status, ProductStatusInTransit, productStatusTransitions) {
+		return newError(ErrInvalidState, "product %s cannot be shipped in status %s", productID, product.Status)
+	}
+	if product.BatchID != "" {
+		err = s.checkBrandApproval(ctx, product.BatchID, sender)
+		if err != nil {
+			return err
+		}
+	}
+	err = checkTransferFlow(ctx, FlowItemProduct, sender, to)
+	if err != nil {
+		return err
+	}
+	if transferType == TransferTypeDonation {
+		err = checkDonation(ctx, to)
+		if err != nil {
+			return err
+		}
+	}
+	if transferType == TransferTypeConsignment {
+		err = checkConsignee(ctx, to)
+		if err != nil {
+			return err
+		}
 	}
 
 	// Create transfer with 2-Check consensus
 	transfer := Transfer{
+		SchemaVersion: CurrentSchemaVersion,
 		ID:           transferID,
 		ProductID:    productID,
 		From:         sender,
@@ -380,34 +898,39 @@ func (s *SupplyChainContract) InitiateTransfer(ctx contractapi.TransactionContex
 			ReceiverConfirmed: false,
 			SenderTimestamp:   "PENDING",
 			ReceiverTimestamp: "PENDING",
-			TimeoutAt: time.Now().Add(24 * time.Hour).Format(time.RFC3339), // 24 hour timeout
 		},
 	}
-
-	transferJSON, err := json.Marshal(transfer)
+	transfer.ConsensusDetails.SenderTier = senderTrustTier(ctx, sender)
+	transfer.ConsensusDetails.TimeoutAt, err = transferTimeoutAt(ctx, &transfer)
 	if err != nil {
 		return err
 	}
-
-	// Store transfer
-	err = ctx.GetStub().PutState("transfer_"+transferID, transferJSON)
+	err = s.flagStolenTransfer(ctx, &transfer)
 	if err != nil {
 		return err
 	}
 
-	// Emit event for 2-Check consensus system
-	err = ctx.GetStub().SetEvent("TransferInitiated", transferJSON)
+	// Store transfer
+	err = putTransfer(ctx, &transfer)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	// Emit event for 2-Check consensus system
+	if len(transfer.StolenFlags) > 0 {
+		return emitEvent(ctx, stolenMovementEvent(&transfer, "InitiateTransfer"))
+	}
+	return emitEvent(ctx, transferEvent(EventTransferInitiated, &transfer, ""))
 }
 
 // ConfirmSent confirms the sender has sent the item (2-Check consensus)
 func (s *SupplyChainContract) ConfirmSent(ctx contractapi.TransactionContextInterface,
 	transferID string) error {
 
+	if err := validateID("transferID", transferID); err != nil {
+		return err
+	}
+
 	transfer, err := s.GetTransfer(ctx, transferID)
 	if err != nil {
 		return err
@@ -421,38 +944,42 @@ func (s *SupplyChainContract) ConfirmSent(ctx contractapi.TransactionContextInte
 
 	// Verify it's the sender confirming
 	if transfer.From != sender {
-		return fmt.Errorf("only the sender can confirm sent")
+		return newError(ErrPermissionDenied, "only the sender can confirm sent")
+	}
+	if transfer.TransferType == TransferTypeLogistics {
+		return newError(ErrInvalidState, "transfer %s is shipped through a carrier, use HandoffToCarrier", transferID)
+	}
+	if err := checkSenderEvidence(transfer); err != nil {
+		return err
+	}
+
+	previousStatus := transfer.Status
+	if err := setTransferStatus(transfer, TransferStatusPending); err != nil {
+		return err
 	}
 
 	// Update consensus info
 	now := time.Now().Format(time.RFC3339)
 	transfer.ConsensusDetails.SenderConfirmed = true
 	transfer.ConsensusDetails.SenderTimestamp = now
-	transfer.Status = TransferStatusPending
 
-	transferJSON, err := json.Marshal(transfer)
-	if err != nil {
-		return err
-	}
-
-	err = ctx.GetStub().PutState("transfer_"+transferID, transferJSON)
+	err = putTransfer(ctx, transfer)
 	if err != nil {
 		return err
 	}
 
 	// Emit event
-	err = ctx.GetStub().SetEvent("TransferSentConfirmed", transferJSON)
-	if err != nil {
-		return err
-	}
-
-	return nil
+	return emitEvent(ctx, transferEvent(EventTransferSentConfirmed, transfer, previousStatus))
 }
 
 // ConfirmReceived confirms the receiver has received the item (2-Check consensus)
 func (s *SupplyChainContract) ConfirmReceived(ctx contractapi.TransactionContextInterface,
 	transferID string) error {
 
+	if err := validateID("transferID", transferID); err != nil {
+		return err
+	}
+
 	transfer, err := s.GetTransfer(ctx, transferID)
 	if err != nil {
 		return err
@@ -466,26 +993,45 @@ func (s *SupplyChainContract) ConfirmReceived(ctx contractapi.TransactionContext
 
 	// Verify it's the receiver confirming
 	if transfer.To != receiver {
-		return fmt.Errorf("only the receiver can confirm receipt")
+		return newError(ErrPermissionDenied, "only the receiver can confirm receipt")
 	}
 
 	// Check if sender has confirmed
 	if !transfer.ConsensusDetails.SenderConfirmed {
-		return fmt.Errorf("sender must confirm sent before receiver can confirm receipt")
+		return newError(ErrInvalidState, "sender must confirm sent before receiver can confirm receipt")
+	}
+	if transfer.TransferType == TransferTypeLogistics && !transfer.ConsensusDetails.CarrierConfirmed {
+		return newError(ErrInvalidState, "carrier must confirm delivery before receiver can confirm receipt")
+	}
+	if err := checkTransferEffective(ctx, transfer); err != nil {
+		return err
+	}
+	if err := s.checkTradeDocuments(ctx, transfer); err != nil {
+		return err
+	}
+
+	previousStatus := transfer.Status
+	if err := setTransferStatus(transfer, TransferStatusCompleted); err != nil {
+		return err
 	}
 
 	// Update consensus info
 	now := time.Now().Format(time.RFC3339)
 	transfer.ConsensusDetails.ReceiverConfirmed = true
 	transfer.ConsensusDetails.ReceiverTimestamp = now
-	transfer.Status = TransferStatusCompleted
 	transfer.CompletedAt = now
 
+	// Delivery versus payment: the receipt only commits together with the payment
+	err = settlePayment(ctx, transfer)
+	if err != nil {
+		return err
+	}
+
 	// Get receiver's role using RoleManagementContract
 	roleContract := &RoleManagementContract{}
 	receiverRole, err := roleContract.GetOrganizationRole(ctx, receiver)
 	if err != nil {
-		return fmt.Errorf("failed to get receiver role: %v", err)
+		return wrapError(err, "failed to get receiver role")
 	}
 
 	// Check if this is a batch transfer
@@ -494,90 +1040,35 @@ func (s *SupplyChainContract) ConfirmReceived(ctx contractapi.TransactionContext
 			// Handle batch transfer
 			batch, err := s.GetBatch(ctx, transfer.ProductID) // ProductID is actually batchID for batch transfers
 			if err != nil {
-				return fmt.Errorf("failed to get batch: %v", err)
-			}
-			
-			// Update batch ownership and location
-			batch.CurrentOwner = transfer.To
-			batch.CurrentLocation = transfer.To
-			
-			// Update batch status based on receiver's role
-			switch receiverRole {
-			case RoleRetailer:
-				batch.Status = BatchStatusAtRetailer
-			case RoleWarehouse:
-				batch.Status = BatchStatusAtWarehouse
-			case RoleManufacturer:
-				batch.Status = BatchStatusCreated
-			default:
-				batch.Status = BatchStatusInTransit
-			}
-			
-			// Save batch
-			batchJSON, err := json.Marshal(batch)
-			if err != nil {
-				return err
+				return wrapError(err, "failed to get batch")
 			}
-			err = ctx.GetStub().PutState("batch_"+batch.ID, batchJSON)
+			err = s.receiveBatch(ctx, transfer, batch, receiverRole)
 			if err != nil {
 				return err
 			}
-			
-			// Update all products in batch
-			for _, productID := range batch.ProductIDs {
-				product, err := s.GetProduct(ctx, productID)
-				if err != nil {
-					continue // Skip if product not found
-				}
-				product.CurrentOwner = transfer.To
-				product.CurrentLocation = transfer.To
-				
-				// Update product status based on receiver's role
-				switch receiverRole {
-				case RoleRetailer:
-					product.Status = ProductStatusInStore
-				case RoleWarehouse:
-					product.Status = ProductStatusInTransit
-				case RoleManufacturer:
-					product.Status = ProductStatusInProduction
-				default:
-					product.Status = ProductStatusInTransit
-				}
-				
-				productJSON, err := json.Marshal(product)
-				if err != nil {
-					continue
-				}
-				ctx.GetStub().PutState(productID, productJSON)
-			}
 		} else {
 			// Handle single product transfer
 			product, err := s.GetProduct(ctx, transfer.ProductID)
 			if err != nil {
 				return err
 			}
+			err = s.checkProductReceiptAnomalies(ctx, product, transfer)
+			if err != nil {
+				return err
+			}
 
-			product.CurrentOwner = transfer.To
-			product.CurrentLocation = transfer.To
+			receiveProduct(product, transfer)
 
 			// Update product status based on receiver's role
-			switch receiverRole {
-			case RoleRetailer:
-				product.Status = ProductStatusInStore
-			case RoleWarehouse:
-				product.Status = ProductStatusInTransit
-			case RoleManufacturer:
-				product.Status = ProductStatusInProduction
-			default:
-				product.Status = ProductStatusInTransit
+			if err := setProductStatus(product, receivedProductStatus(receiverRole)); err != nil {
+				return err
 			}
-
-			// Save product
-			productJSON, err := json.Marshal(product)
-			if err != nil {
+			if err := recordDonation(ctx, product, transfer); err != nil {
 				return err
 			}
-			err = ctx.GetStub().PutState(product.ID, productJSON)
+
+			// Save product
+			err = putProduct(ctx, product)
 			if err != nil {
 				return err
 			}
@@ -588,62 +1079,138 @@ func (s *SupplyChainContract) ConfirmReceived(ctx contractapi.TransactionContext
 		if err != nil {
 			return err
 		}
+		err = s.checkProductReceiptAnomalies(ctx, product, transfer)
+		if err != nil {
+			return err
+		}
 
-		product.CurrentOwner = transfer.To
-		product.CurrentLocation = transfer.To
+		receiveProduct(product, transfer)
 
 		// Update product status based on receiver's role
-		switch receiverRole {
-		case RoleRetailer:
-			product.Status = ProductStatusInStore
-		case RoleWarehouse:
-			product.Status = ProductStatusInTransit
-		case RoleManufacturer:
-			product.Status = ProductStatusInProduction
-		default:
-			product.Status = ProductStatusInTransit
+		if err := setProductStatus(product, receivedProductStatus(receiverRole)); err != nil {
+			return err
 		}
-
-		// Save product
-		productJSON, err := json.Marshal(product)
-		if err != nil {
+		if err := recordDonation(ctx, product, transfer); err != nil {
 			return err
 		}
-		err = ctx.GetStub().PutState(product.ID, productJSON)
+
+		// Save product
+		err = putProduct(ctx, product)
 		if err != nil {
 			return err
 		}
 	}
 
-	// Save transfer
-	transferJSON, err := json.Marshal(transfer)
+	// A receipt after the expected delivery date costs the sender trust. It does not
+	// wait for consensus; CheckDeliverySLA reports it later if consensus is unreachable.
+	lateReported, err := reportLateDelivery(ctx, transfer)
 	if err != nil {
-		return err
+		logFor(ctx).Warn("failed to report late delivery", "transferId", transferID, "error", err)
 	}
-	err = ctx.GetStub().PutState("transfer_"+transferID, transferJSON)
+
+	// Save transfer
+	err = putTransfer(ctx, transfer)
 	if err != nil {
 		return err
 	}
 
 	// Emit event
-	err = ctx.GetStub().SetEvent("TransferCompleted", transferJSON)
+	event := transferEvent(EventTransferCompleted, transfer, previousStatus)
+	if transfer.Payment != nil {
+		event.Attributes["paymentStatus"] = transfer.Payment.Status
+		event.Attributes["paymentAmount"] = transfer.Payment.Amount
+	}
+	if lateReported {
+		event.Attributes["lateDelivery"] = true
+	}
+	return emitEvent(ctx, event)
+}
+
+// receiveBatch hands a received batch and the products that move with it to the receiver
+func (s *SupplyChainContract) receiveBatch(ctx contractapi.TransactionContextInterface,
+	transfer *Transfer, batch *ProductBatch, receiverRole OrganizationRole) error {
+
+	err := checkReceiptAnomalies(ctx, receiptObservation{
+		itemType:   FlowItemBatch,
+		entityID:   batch.ID,
+		transferID: transfer.ID,
+		sender:     transfer.From,
+		receiver:   transfer.To,
+		holder:     itemHolder(batch.CurrentOwner, batch.Consignment),
+		origin:     batch.Manufacturer,
+		quantity:   float64(batch.Quantity),
+		isReturn:   transfer.TransferType == TransferTypeReturn,
+	})
+	if err != nil {
+		return err
+	}
+	
+	// Update batch ownership and location
+	receiveBatchHolding(batch, transfer)
+	
+	// Update batch status based on receiver's role
+	if err := setBatchStatus(batch, receivedBatchStatus(receiverRole)); err != nil {
+		return err
+	}
+	
+	// Save batch
+	err = putBatch(ctx, batch)
 	if err != nil {
 		return err
 	}
-
-	return nil
+	
+	// Update all products in batch
+	for _, productID := range batch.ProductIDs {
+		product, err := s.GetProduct(ctx, productID)
+		if err != nil {
+			continue // Skip if product not found
+		}
+		if !movesWithBatch(product, transfer.From) {
+			continue
+		}
+		receiveProduct(product, transfer)
+		
+		// Update product status based on receiver's role
+		if err := setProductStatus(product, receivedProductStatus(receiverRole)); err != nil {
+			return err
+		}
+		
+		if err := putProduct(ctx, product); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// movesWithBatch reports whether a product of a shipped batch changes hands with it.
+// Products already sold to customers stay with them, display units on the shop floor,
+// and damaged, destroyed or written-off products with the holder that reported them.
+// Consigned products are shipped by the retailer holding them.
+func movesWithBatch(product *Product, sender string) bool {
+	if itemHolder(product.CurrentOwner, product.Consignment) != sender {
+		return false
+	}
+	switch product.Status {
+	case ProductStatusDemo, ProductStatusDamaged, ProductStatusDestroyed, ProductStatusWrittenOff:
+		return false
+	}
+	return true
 }
 
 // GetProduct retrieves a product by ID
 func (s *SupplyChainContract) GetProduct(ctx contractapi.TransactionContextInterface, 
 	productID string) (*Product, error) {
 
-	productJSON, err := ctx.GetStub().GetState(productID)
+	if err := validateID("productID", productID); err != nil {
+		return nil, err
+	}
+
+	productJSON, err := getProductState(ctx, productID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read product: %v", err)
 	}
 	if productJSON == nil {
-		return nil, fmt.Errorf("product %s does not exist", productID)
+		return nil, newError(ErrNotFound, "product %s does not exist", productID)
 	}
 
 	var product Product
@@ -651,6 +1218,7 @@ func (s *SupplyChainContract) GetProduct(ctx contractapi.TransactionContextInter
 	if err != nil {
 		return nil, err
 	}
+	product.upgradeSchema()
 
 	// Ensure Materials is never nil (empty array instead)
 	if product.Materials == nil {
@@ -660,16 +1228,46 @@ func (s *SupplyChainContract) GetProduct(ctx contractapi.TransactionContextInter
 	return &product, nil
 }
 
+// GetProductSummary retrieves a product without its materials and metadata
+func (s *SupplyChainContract) GetProductSummary(ctx contractapi.TransactionContextInterface,
+	productID string) (*ProductSummary, error) {
+
+	product, err := s.GetProduct(ctx, productID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ProductSummary{
+		ID:              product.ID,
+		BatchID:         product.BatchID,
+		Brand:           product.Brand,
+		Name:            product.Name,
+		Type:            product.Type,
+		SerialNumber:    product.SerialNumber,
+		Variant:         product.Variant,
+		Status:          product.Status,
+		CurrentOwner:    product.CurrentOwner,
+		CurrentLocation: product.CurrentLocation,
+		IsStolen:        product.IsStolen,
+		HasOwner:        product.OwnershipHash != "" && product.OwnershipHash != "NONE",
+		Version:         product.Version,
+	}, nil
+}
+
 // GetTransfer retrieves a transfer by ID
 func (s *SupplyChainContract) GetTransfer(ctx contractapi.TransactionContextInterface,
 	transferID string) (*Transfer, error) {
 
+	if err := validateID("transferID", transferID); err != nil {
+		return nil, err
+	}
+
 	transferJSON, err := ctx.GetStub().GetState("transfer_" + transferID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read transfer: %v", err)
 	}
 	if transferJSON == nil {
-		return nil, fmt.Errorf("transfer %s does not exist", transferID)
+		return nil, newError(ErrNotFound, "transfer %s does not exist", transferID)
 	}
 
 	var transfer Transfer
@@ -677,6 +1275,7 @@ func (s *SupplyChainContract) GetTransfer(ctx contractapi.TransactionContextInte
 	if err != nil {
 		return nil, err
 	}
+	transfer.upgradeSchema()
 
 	return &transfer, nil
 }
@@ -685,7 +1284,11 @@ func (s *SupplyChainContract) GetTransfer(ctx contractapi.TransactionContextInte
 func (s *SupplyChainContract) ProductExists(ctx contractapi.TransactionContextInterface,
 	productID string) (bool, error) {
 
-	productJSON, err := ctx.GetStub().GetState(productID)
+	if err := validateID("productID", productID); err != nil {
+		return false, err
+	}
+
+	productJSON, err := getProductState(ctx, productID)
 	if err != nil {
 		return false, fmt.Errorf("failed to read product: %v", err)
 	}
@@ -697,57 +1300,89 @@ func (s *SupplyChainContract) ProductExists(ctx contractapi.TransactionContextIn
 func (s *SupplyChainContract) GetProductHistory(ctx contractapi.TransactionContextInterface,
 	productID string) ([]map[string]interface{}, error) {
 
-	resultsIterator, err := ctx.GetStub().GetHistoryForKey(productID)
-	if err != nil {
+	if err := validateID("productID", productID); err != nil {
 		return nil, err
 	}
-	defer resultsIterator.Close()
 
 	var history []map[string]interface{}
-	for resultsIterator.HasNext() {
-		response, err := resultsIterator.Next()
+	// Older writes may live under the legacy bare-ID key, newer ones under productKey
+	for _, key := range []string{productID, productKey(productID)} {
+		resultsIterator, err := ctx.GetStub().GetHistoryForKey(key)
 		if err != nil {
 			return nil, err
 		}
+		defer resultsIterator.Close()
 
-		var record map[string]interface{}
-		record = make(map[string]interface{})
-		record["txId"] = response.TxId
-		record["timestamp"] = response.Timestamp
-		record["isDelete"] = response.IsDelete
-
-		if !response.IsDelete {
-			var product Product
-			err = json.Unmarshal(response.Value, &product)
+		for resultsIterator.HasNext() {
+			response, err := resultsIterator.Next()
 			if err != nil {
 				return nil, err
 			}
-			// Ensure Materials is never nil
-			if product.Materials == nil {
-				product.Materials = []Material{}
+			// The legacy key is only deleted when the product is moved to its new key
+			if response.IsDelete && key == productID {
+				continue
 			}
-			record["value"] = product
-		}
 
-		history = append(history, record)
+			var record map[string]interface{}
+			record = make(map[string]interface{})
+			record["txId"] = response.TxId
+			record["timestamp"] = response.Timestamp
+			record["isDelete"] = response.IsDelete
+
+			if !response.IsDelete {
+				var product Product
+				err = json.Unmarshal(response.Value, &product)
+				if err != nil {
+					return nil, err
+				}
+				// Ensure Materials is never nil
+				if product.Materials == nil {
+					product.Materials = []Material{}
+				}
+				record["value"] = product
+			}
+
+			history = append(history, record)
+		}
 	}
 
 	return history, nil
 }
 
-// QueryProductsByBrand queries products by brand
+// QueryProductsByBrand returns a brand's products, read from the brand index
 func (s *SupplyChainContract) QueryProductsByBrand(ctx contractapi.TransactionContextInterface,
 	brand string) ([]*Product, error) {
 
-	queryString := fmt.Sprintf(`{"selector":{"brand":"%s"}}`, brand)
-	return s.queryProducts(ctx, queryString)
+	if err := validateName("brand", brand); err != nil {
+		return nil, err
+	}
+
+	productIDs, err := getIndexedIDs(ctx, productBrandIndex, brand)
+	if err != nil {
+		return nil, err
+	}
+	products := []*Product{}
+	for _, productID := range productIDs {
+		product, err := s.GetProduct(ctx, productID)
+		if err != nil {
+			logFor(ctx).Warn("skipping unreadable indexed product", "productId", productID, "error", err)
+			continue
+		}
+		products = append(products, product)
+	}
+	return products, nil
 }
 
 // QueryProductsByStatus queries products by status
 func (s *SupplyChainContract) QueryProductsByStatus(ctx contractapi.TransactionContextInterface,
 	status ProductStatus) ([]*Product, error) {
 
-	queryString := fmt.Sprintf(`{"selector":{"status":"%s"}}`, status)
+	if err := validateEnum("status", string(status), productStatuses...); err != nil {
+		return nil, err
+	}
+
+	queryString := fmt.Sprintf(`{"selector":{"status":"%s","serialNumber":{"$exists":true}},`+
+		`"use_index":["_design/indexProductStatusDoc","indexProductStatus"]}`, status)
 	return s.queryProducts(ctx, queryString)
 }
 
@@ -785,14 +1420,29 @@ func (s *SupplyChainContract) queryProducts(ctx contractapi.TransactionContextIn
 
 // ============= MATERIAL INVENTORY MANAGEMENT =============
 
-// CreateMaterialInventory creates initial material inventory for a supplier
+// CreateMaterialInventory creates initial material inventory for a supplier.
+// certificationJSON is the OriginCertification required for regulated material
+// types and ignored when empty for the others. expiryDate is the RFC3339 end of the
+// lot's shelf life, e.g. for treatments and adhesives, and empty if it keeps.
 func (s *SupplyChainContract) CreateMaterialInventory(ctx contractapi.TransactionContextInterface,
-	materialID string, materialType string, batch string, quantityStr string) error {
-	
+	materialID string, materialType string, batch string, quantityStr string, certificationJSON string,
+	expiryDate string) error {
+	
+	if err := validateAll(
+		validateID("materialID", materialID),
+		validateName("materialType", materialType),
+		validateText("batch", batch, maxIDLength),
+	); err != nil {
+		return err
+	}
+
 	// Parse quantity
 	quantity, err := strconv.ParseFloat(quantityStr, 64)
 	if err != nil {
-		return fmt.Errorf("invalid quantity: %v", err)
+		return newError(ErrInvalidArgument, "invalid quantity: %v", err)
+	}
+	if err := validateQuantity("quantity", quantity); err != nil {
+		return err
 	}
 
 	// Get supplier identity
@@ -805,7 +1455,7 @@ func (s *SupplyChainContract) CreateMaterialInventory(ctx contractapi.Transactio
 	roleContract := &RoleManagementContract{}
 	hasPermission, err := roleContract.CheckPermission(ctx, supplier, "CREATE_MATERIAL")
 	if err != nil || !hasPermission {
-		return fmt.Errorf("caller %s does not have permission to create material inventory", supplier)
+		return newError(ErrPermissionDenied, "caller %s does not have permission to create material inventory", supplier)
 	}
 
 	// Check if inventory already exists
@@ -815,11 +1465,37 @@ func (s *SupplyChainContract) CreateMaterialInventory(ctx contractapi.Transactio
 		return err
 	}
 	if existing != nil {
-		return fmt.Errorf("material inventory %s already exists for %s", materialID, supplier)
+		return newError(ErrAlreadyExists, "material inventory %s already exists for %s", materialID, supplier)
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	expiryDate, err = parseExpiryDate(expiryDate, now)
+	if err != nil {
+		return err
+	}
+
+	// Regulated materials need a valid origin certificate
+	regulated, err := getRegulatedMaterials(ctx)
+	if err != nil {
+		return err
+	}
+	var certification *OriginCertification
+	if scheme := regulated.requiredScheme(materialType); scheme != "" {
+		if certificationJSON == "" {
+			return newError(ErrInvalidArgument, "material type %s requires a %s certificate", materialType, scheme)
+		}
+		certification, err = parseOriginCertification(certificationJSON, scheme, now)
+		if err != nil {
+			return err
+		}
 	}
 
 	// Create new inventory
 	inventory := MaterialInventory{
+		SchemaVersion: CurrentSchemaVersion,
 		ID:            inventoryKey,
 		MaterialID:    materialID,
 		Batch:         batch,
@@ -830,24 +1506,54 @@ func (s *SupplyChainContract) CreateMaterialInventory(ctx contractapi.Transactio
 		Available:     quantity,
 		Used:          0,
 		Transfers:     []MaterialTransferRecord{},
+		Certification: certification,
+		ExpiryDate:    expiryDate,
 	}
 
-	inventoryJSON, err := json.Marshal(inventory)
+	err = putMaterialInventory(ctx, inventoryKey, &inventory)
 	if err != nil {
 		return err
 	}
 
-	return ctx.GetStub().PutState(inventoryKey, inventoryJSON)
+	attributes := map[string]interface{}{
+		"materialType": materialType,
+		"owner":        supplier,
+		"quantity":     quantity,
+	}
+	if certification != nil {
+		attributes["certificationScheme"] = certification.Scheme
+		attributes["certificateNumber"] = certification.CertificateNumber
+	}
+	if expiryDate != "" {
+		attributes["expiryDate"] = expiryDate
+	}
+	return emitEvent(ctx, ChaincodeEvent{
+		EventType:  EventMaterialInventoryCreated,
+		EntityType: EventEntityMaterial,
+		EntityID:   materialID,
+		Attributes: attributes,
+	})
 }
 
 // TransferMaterialInventory transfers material from one organization to another with consensus
 func (s *SupplyChainContract) TransferMaterialInventory(ctx contractapi.TransactionContextInterface,
 	transferID string, materialID string, toOrganization string, quantityStr string) error {
 	
+	if err := validateAll(
+		validateID("transferID", transferID),
+		validateID("materialID", materialID),
+		validateID("toOrganization", toOrganization),
+	); err != nil {
+		return err
+	}
+
 	// Parse quantity
 	quantity, err := strconv.ParseFloat(quantityStr, 64)
 	if err != nil {
-		return fmt.Errorf("invalid quantity: %v", err)
+		return newError(ErrInvalidArgument, "invalid quantity: %v", err)
+	}
+	if err := validateQuantity("quantity", quantity); err != nil {
+		return err
 	}
 
 	// Get sender identity
@@ -860,7 +1566,11 @@ func (s *SupplyChainContract) TransferMaterialInventory(ctx contractapi.Transact
 	roleContract := &RoleManagementContract{}
 	hasPermission, err := roleContract.CheckPermission(ctx, fromOrganization, "TRANSFER_MATERIAL")
 	if err != nil || !hasPermission {
-		return fmt.Errorf("caller %s does not have permission to transfer materials", fromOrganization)
+		return newError(ErrPermissionDenied, "caller %s does not have permission to transfer materials", fromOrganization)
+	}
+	err = checkTransferFlow(ctx, FlowItemMaterial, fromOrganization, toOrganization)
+	if err != nil {
+		return err
 	}
 	
 	// Submit to consensus first
@@ -876,7 +1586,7 @@ func (s *SupplyChainContract) TransferMaterialInventory(ctx contractapi.Transact
 		return err
 	}
 	if senderInventoryJSON == nil {
-		return fmt.Errorf("material %s not found in %s's inventory", materialID, fromOrganization)
+		return newError(ErrNotFound, "material %s not found in %s's inventory", materialID, fromOrganization)
 	}
 
 	var senderInventory MaterialInventory
@@ -887,7 +1597,7 @@ func (s *SupplyChainContract) TransferMaterialInventory(ctx contractapi.Transact
 
 	// Check available quantity
 	if senderInventory.Available < quantity {
-		return fmt.Errorf("insufficient material: requested %.2f, available %.2f", quantity, senderInventory.Available)
+		return newError(ErrInsufficientInventory, "insufficient material: requested %.2f, available %.2f", quantity, senderInventory.Available)
 	}
 
 	// Deduct from sender
@@ -904,11 +1614,7 @@ func (s *SupplyChainContract) TransferMaterialInventory(ctx contractapi.Transact
 	senderInventory.Transfers = append(senderInventory.Transfers, transferRecord)
 
 	// Update sender inventory
-	updatedSenderJSON, err := json.Marshal(senderInventory)
-	if err != nil {
-		return err
-	}
-	err = ctx.GetStub().PutState(senderInventoryKey, updatedSenderJSON)
+	err = putMaterialInventory(ctx, senderInventoryKey, &senderInventory)
 	if err != nil {
 		return err
 	}
@@ -924,12 +1630,15 @@ func (s *SupplyChainContract) TransferMaterialInventory(ctx contractapi.Transact
 	if receiverInventoryJSON == nil {
 		// Create new inventory for receiver
 		receiverInventory = MaterialInventory{
+			SchemaVersion: CurrentSchemaVersion,
 			ID:            receiverInventoryKey,
 			MaterialID:    materialID,
 			Batch:         senderInventory.Batch,
 			Owner:         toOrganization,
 			Supplier:      senderInventory.Supplier, // Original supplier
 			Type:          senderInventory.Type,
+			Certification: senderInventory.Certification,
+			ExpiryDate:    senderInventory.ExpiryDate,
 			TotalReceived: 0, // Will be updated after confirmation
 			Available:     0, // Will be updated after confirmation
 			Used:          0,
@@ -946,34 +1655,36 @@ func (s *SupplyChainContract) TransferMaterialInventory(ctx contractapi.Transact
 	receiverInventory.Transfers = append(receiverInventory.Transfers, transferRecord)
 
 	// Update receiver inventory
-	updatedReceiverJSON, err := json.Marshal(receiverInventory)
-	if err != nil {
-		return err
-	}
-
-	err = ctx.GetStub().PutState(receiverInventoryKey, updatedReceiverJSON)
+	err = putMaterialInventory(ctx, receiverInventoryKey, &receiverInventory)
 	if err != nil {
 		return err
 	}
 	
 	// Emit event for consensus tracking
-	eventData := map[string]interface{}{
-		"transferID": transferID,
-		"materialID": materialID,
-		"from":       fromOrganization,
-		"to":         toOrganization,
-		"quantity":   quantity,
-	}
-	eventJSON, _ := json.Marshal(eventData)
-	ctx.GetStub().SetEvent("MaterialTransferInitiated", eventJSON)
-	
-	return nil
+	return emitEvent(ctx, ChaincodeEvent{
+		EventType:  EventMaterialTransferInitiated,
+		EntityType: EventEntityMaterial,
+		EntityID:   materialID,
+		Attributes: map[string]interface{}{
+			"transferId": transferID,
+			"from":       fromOrganization,
+			"to":         toOrganization,
+			"quantity":   quantity,
+		},
+	})
 }
 
 // ConfirmMaterialReceived confirms material receipt and updates inventory with consensus
 func (s *SupplyChainContract) ConfirmMaterialReceived(ctx contractapi.TransactionContextInterface,
 	transferID string, materialID string) error {
 
+	if err := validateAll(
+		validateID("transferID", transferID),
+		validateID("materialID", materialID),
+	); err != nil {
+		return err
+	}
+
 	// Get receiver identity
 	receiver, err := ctx.GetClientIdentity().GetMSPID()
 	if err != nil {
@@ -987,7 +1698,7 @@ func (s *SupplyChainContract) ConfirmMaterialReceived(ctx contractapi.Transactio
 		return err
 	}
 	if inventoryJSON == nil {
-		return fmt.Errorf("material inventory not found for %s", receiver)
+		return newError(ErrNotFound, "material inventory not found for %s", receiver)
 	}
 
 	var inventory MaterialInventory
@@ -1002,7 +1713,10 @@ func (s *SupplyChainContract) ConfirmMaterialReceived(ctx contractapi.Transactio
 	for i, transfer := range inventory.Transfers {
 		if transfer.TransferID == transferID && transfer.To == receiver {
 			if transfer.Verified {
-				return fmt.Errorf("transfer %s already confirmed", transferID)
+				return newError(ErrInvalidState, "transfer %s already confirmed", transferID)
+			}
+			if transfer.Status == materialTransferCancelled {
+				return newError(ErrInvalidState, "transfer %s was cancelled", transferID)
 			}
 			inventory.Transfers[i].Verified = true
 			inventory.Transfers[i].Status = "COMPLETED" // Update status when verified
@@ -1013,7 +1727,7 @@ func (s *SupplyChainContract) ConfirmMaterialReceived(ctx contractapi.Transactio
 	}
 
 	if !transferFound {
-		return fmt.Errorf("transfer %s not found for material %s", transferID, materialID)
+		return newError(ErrNotFound, "transfer %s not found for material %s", transferID, materialID)
 	}
 
 	// Update quantities after confirmation
@@ -1021,12 +1735,7 @@ func (s *SupplyChainContract) ConfirmMaterialReceived(ctx contractapi.Transactio
 	inventory.Available += transferQuantity
 
 	// Update receiver's inventory
-	updatedInventoryJSON, err := json.Marshal(inventory)
-	if err != nil {
-		return err
-	}
-	
-	err = ctx.GetStub().PutState(inventoryKey, updatedInventoryJSON)
+	err = putMaterialInventory(ctx, inventoryKey, &inventory)
 	if err != nil {
 		return err
 	}
@@ -1041,6 +1750,19 @@ func (s *SupplyChainContract) ConfirmMaterialReceived(ctx contractapi.Transactio
 		}
 	}
 	
+	err = checkReceiptAnomalies(ctx, receiptObservation{
+		itemType:   FlowItemMaterial,
+		entityID:   materialID,
+		transferID: transferID,
+		sender:     senderMSP,
+		receiver:   receiver,
+		origin:     inventory.Supplier,
+		quantity:   transferQuantity,
+	})
+	if err != nil {
+		return err
+	}
+	
 	// Get sender's inventory
 	senderInventoryKey := fmt.Sprintf("material_inventory_%s_%s", materialID, senderMSP)
 	senderInventoryJSON, err := ctx.GetStub().GetState(senderInventoryKey)
@@ -1065,36 +1787,34 @@ func (s *SupplyChainContract) ConfirmMaterialReceived(ctx contractapi.Transactio
 		}
 		
 		// Update sender's inventory
-		updatedSenderJSON, err := json.Marshal(senderInventory)
-		if err != nil {
-			return err
-		}
-		
-		err = ctx.GetStub().PutState(senderInventoryKey, updatedSenderJSON)
+		err = putMaterialInventory(ctx, senderInventoryKey, &senderInventory)
 		if err != nil {
 			return err
 		}
 	}
 	
 	// Notify consensus of receipt confirmation
-	consensus := NewConsensusIntegration("2check-consensus", "luxury-supply-chain")
+	consensus, err := LoadConsensusIntegration(ctx)
+	if err != nil {
+		return err
+	}
 	err = consensus.NotifyConsensusOfReceived(ctx, transferID, receiver)
 	if err != nil {
 		// Log but don't fail - material transfer is already complete
-		fmt.Printf("Warning: Failed to notify consensus of material receipt: %v\n", err)
+		logFor(ctx).Warn("failed to notify consensus of material receipt", "transferId", transferID, "error", err)
 	}
 	
 	// Emit event
-	eventData := map[string]interface{}{
-		"transferID": transferID,
-		"materialID": materialID,
-		"receiver":   receiver,
-		"quantity":   transferQuantity,
-	}
-	eventJSON, _ := json.Marshal(eventData)
-	ctx.GetStub().SetEvent("MaterialReceiptConfirmed", eventJSON)
-
-	return nil
+	return emitEvent(ctx, ChaincodeEvent{
+		EventType:  EventMaterialReceiptConfirmed,
+		EntityType: EventEntityMaterial,
+		EntityID:   materialID,
+		Attributes: map[string]interface{}{
+			"transferId": transferID,
+			"to":         receiver,
+			"quantity":   transferQuantity,
+		},
+	})
 }
 
 // ConfirmReturnTransferReceived confirms receipt of a return transfer from dispute resolution
@@ -1102,6 +1822,13 @@ func (s *SupplyChainContract) ConfirmMaterialReceived(ctx contractapi.Transactio
 func (s *SupplyChainContract) ConfirmReturnTransferReceived(ctx contractapi.TransactionContextInterface,
 	transferID string, materialID string) error {
 
+	if err := validateAll(
+		validateID("transferID", transferID),
+		validateID("materialID", materialID),
+	); err != nil {
+		return err
+	}
+
 	// Get receiver identity
 	receiver, err := ctx.GetClientIdentity().GetMSPID()
 	if err != nil {
@@ -1143,6 +1870,7 @@ func (s *SupplyChainContract) ConfirmReturnTransferReceived(ctx contractapi.Tran
 	if inventoryJSON == nil {
 		// Create new inventory for returned materials
 		inventory = MaterialInventory{
+			SchemaVersion: CurrentSchemaVersion,
 			ID:           fmt.Sprintf("%s_%s", materialID, receiver),
 			MaterialID:   materialID,
 			Batch:        "RETURN-" + transferID,
@@ -1177,49 +1905,54 @@ func (s *SupplyChainContract) ConfirmReturnTransferReceived(ctx contractapi.Tran
 	inventory.Available += transferQuantity
 
 	// Save updated inventory
-	updatedJSON, err := json.Marshal(inventory)
+	err = putMaterialInventory(ctx, inventoryKey, &inventory)
 	if err != nil {
 		return err
 	}
 
-	err = ctx.GetStub().PutState(inventoryKey, updatedJSON)
+	// Notify consensus of receipt confirmation
+	consensus, err := LoadConsensusIntegration(ctx)
 	if err != nil {
 		return err
 	}
-
-	// Notify consensus of receipt confirmation
-	consensus := NewConsensusIntegration("2check-consensus", "luxury-supply-chain")
 	err = consensus.NotifyConsensusOfReceived(ctx, transferID, receiver)
 	if err != nil {
 		// Log but don't fail - return transfer is already complete
-		fmt.Printf("Warning: Failed to notify consensus of return receipt: %v\n", err)
+		logFor(ctx).Warn("failed to notify consensus of return receipt", "transferId", transferID, "error", err)
 	}
 
 	// Emit event
-	eventData := map[string]interface{}{
-		"transferID": transferID,
-		"materialID": materialID,
-		"receiver":   receiver,
-		"quantity":   transferQuantity,
-		"isReturn":   true,
-	}
-	eventJSON, _ := json.Marshal(eventData)
-	ctx.GetStub().SetEvent("ReturnTransferReceiptConfirmed", eventJSON)
-
-	return nil
+	return emitEvent(ctx, ChaincodeEvent{
+		EventType:  EventReturnTransferReceiptConfirmed,
+		EntityType: EventEntityMaterial,
+		EntityID:   materialID,
+		Attributes: map[string]interface{}{
+			"transferId": transferID,
+			"to":         receiver,
+			"quantity":   transferQuantity,
+			"isReturn":   true,
+		},
+	})
 }
 
 // GetMaterialInventory retrieves material inventory for an organization
 func (s *SupplyChainContract) GetMaterialInventory(ctx contractapi.TransactionContextInterface,
 	materialID string, organization string) (*MaterialInventory, error) {
 
+	if err := validateAll(
+		validateID("materialID", materialID),
+		validateID("organization", organization),
+	); err != nil {
+		return nil, err
+	}
+
 	inventoryKey := fmt.Sprintf("material_inventory_%s_%s", materialID, organization)
 	inventoryJSON, err := ctx.GetStub().GetState(inventoryKey)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read inventory: %v", err)
 	}
 	if inventoryJSON == nil {
-		return nil, fmt.Errorf("inventory not found for material %s and organization %s", materialID, organization)
+		return nil, newError(ErrNotFound, "inventory not found for material %s and organization %s", materialID, organization)
 	}
 
 	var inventory MaterialInventory
@@ -1227,20 +1960,47 @@ func (s *SupplyChainContract) GetMaterialInventory(ctx contractapi.TransactionCo
 	if err != nil {
 		return nil, err
 	}
+	inventory.upgradeSchema()
 
 	return &inventory, nil
 }
 
-// GetAllMaterialInventories returns all material inventories from the blockchain
-func (s *SupplyChainContract) GetAllMaterialInventories(ctx contractapi.TransactionContextInterface) ([]*MaterialInventory, error) {
-	// Use GetStateByRange to query all material inventories
-	resultsIterator, err := ctx.GetStub().GetStateByRange("material_inventory_", "material_inventory_~")
+// GetAllMaterialInventories returns one page of the material inventories. Start with an
+// empty bookmark and pass each page's bookmark to the next call.
+func (s *SupplyChainContract) GetAllMaterialInventories(ctx contractapi.TransactionContextInterface,
+	pageSize int, bookmark string) (*MaterialInventoryPage, error) {
+
+	page := &MaterialInventoryPage{Records: []*MaterialInventory{}}
+	result, err := scanPage(ctx, "material_inventory_", pageSize, bookmark, addMaterialInventory(&page.Records))
+	if err != nil {
+		return nil, err
+	}
+	page.PaginatedResult = *result
+
+	return page, nil
+}
+
+// GetMaterialAvailabilityByType totals received, available and used quantities of a
+// material type across every organization holding it
+func (s *SupplyChainContract) GetMaterialAvailabilityByType(ctx contractapi.TransactionContextInterface,
+	materialType string) (*MaterialAvailability, error) {
+
+	if err := validateName("materialType", materialType); err != nil {
+		return nil, err
+	}
+
+	queryString := fmt.Sprintf(`{"selector":{"type":"%s","materialId":{"$exists":true}},`+
+		`"use_index":["_design/indexMaterialTypeDoc","indexMaterialType"]}`, materialType)
+	resultsIterator, err := ctx.GetStub().GetQueryResult(queryString)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query material inventories: %v", err)
 	}
 	defer resultsIterator.Close()
 
-	var inventories []*MaterialInventory
+	availability := &MaterialAvailability{
+		MaterialType:     materialType,
+		AvailableByOwner: make(map[string]float64),
+	}
 	for resultsIterator.HasNext() {
 		queryResponse, err := resultsIterator.Next()
 		if err != nil {
@@ -1250,26 +2010,67 @@ func (s *SupplyChainContract) GetAllMaterialInventories(ctx contractapi.Transact
 		var inventory MaterialInventory
 		err = json.Unmarshal(queryResponse.Value, &inventory)
 		if err != nil {
-			return nil, err
+			continue
 		}
 
-		inventories = append(inventories, &inventory)
+		availability.Inventories++
+		availability.TotalReceived += inventory.TotalReceived
+		availability.Available += inventory.Available
+		availability.Reserved += inventory.Reserved
+		availability.Used += inventory.Used
+		availability.AvailableByOwner[inventory.Owner] += inventory.Available
 	}
 
-	return inventories, nil
+	return availability, nil
+}
+
+// Products are indexed by batch and unique identifier as uid_<batchID>_<uniqueIdentifier>
+const productIdentifierKeyPrefix = "uid_"
+
+// productIdentifierKey returns the index key of a product's unique identifier within its batch
+func productIdentifierKey(batchID string, uniqueIdentifier string) string {
+	return productIdentifierKeyPrefix + batchID + "_" + uniqueIdentifier
+}
+
+// indexProductIdentifier indexes a batch product by its unique identifier
+func indexProductIdentifier(ctx contractapi.TransactionContextInterface, product *Product) error {
+	if product.BatchID == "" || product.UniqueIdentifier == "" {
+		return nil
+	}
+	// Empty values are deletes in Fabric, so the entry holds the product ID
+	err := ctx.GetStub().PutState(productIdentifierKey(product.BatchID, product.UniqueIdentifier), []byte(product.ID))
+	if err != nil {
+		return fmt.Errorf("failed to index product identifier: %v", err)
+	}
+	return nil
 }
 
 // VerifyProductByBatch allows customer to verify a product using batch QR code and unique identifier
 func (s *SupplyChainContract) VerifyProductByBatch(ctx contractapi.TransactionContextInterface,
 	batchID string, uniqueIdentifier string) (*Product, error) {
 	
-	// Get batch
+	if err := validateAll(
+		validateID("batchID", batchID),
+		validateID("uniqueIdentifier", uniqueIdentifier),
+	); err != nil {
+		return nil, err
+	}
+
+	indexed, err := ctx.GetStub().GetState(productIdentifierKey(batchID, uniqueIdentifier))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read product identifier index: %v", err)
+	}
+	if indexed != nil {
+		return s.GetProduct(ctx, string(indexed))
+	}
+
+	// Batches created before the index are searched product by product
 	batchJSON, err := ctx.GetStub().GetState("batch_" + batchID)
 	if err != nil {
 		return nil, err
 	}
 	if batchJSON == nil {
-		return nil, fmt.Errorf("batch %s not found", batchID)
+		return nil, newError(ErrNotFound, "batch %s not found", batchID)
 	}
 	
 	var batch ProductBatch
@@ -1277,6 +2078,7 @@ func (s *SupplyChainContract) VerifyProductByBatch(ctx contractapi.TransactionCo
 	if err != nil {
 		return nil, err
 	}
+	batch.upgradeSchema()
 	
 	// Find product with matching unique identifier
 	var targetProductID string
@@ -1292,7 +2094,7 @@ func (s *SupplyChainContract) VerifyProductByBatch(ctx contractapi.TransactionCo
 	}
 	
 	if targetProductID == "" {
-		return nil, fmt.Errorf("product with identifier %s not found in batch %s", uniqueIdentifier, batchID)
+		return nil, newError(ErrNotFound, "product with identifier %s not found in batch %s", uniqueIdentifier, batchID)
 	}
 	
 	// Get and return the product
@@ -1303,8 +2105,17 @@ func (s *SupplyChainContract) VerifyProductByBatch(ctx contractapi.TransactionCo
 // Called by RETAILER organization after customer purchase (customer auth handled off-chain)
 // Now includes securityHash (password+PIN) for secure transfers
 func (s *SupplyChainContract) TakeOwnership(ctx contractapi.TransactionContextInterface,
-	productID string, ownerHash string, securityHash string, purchaseLocation string) error {
-	
+	productID string, ownerHash string, securityHash string, purchaseLocation string, expectedVersion int) error {
+	
+	if err := validateAll(
+		validateID("productID", productID),
+		validateID("ownerHash", ownerHash),
+		validateID("securityHash", securityHash),
+		validateText("purchaseLocation", purchaseLocation, maxNameLength),
+	); err != nil {
+		return err
+	}
+
 	// Get caller identity
 	caller, err := ctx.GetClientIdentity().GetMSPID()
 	if err != nil {
@@ -1315,7 +2126,7 @@ func (s *SupplyChainContract) TakeOwnership(ctx contractapi.TransactionContextIn
 	roleContract := &RoleManagementContract{}
 	hasPermission, err := roleContract.CheckPermission(ctx, caller, "TAKE_OWNERSHIP")
 	if err != nil || !hasPermission {
-		return fmt.Errorf("caller %s does not have permission to assign ownership", caller)
+		return newError(ErrPermissionDenied, "caller %s does not have permission to assign ownership", caller)
 	}
 	
 	// Get product
@@ -1323,21 +2134,49 @@ func (s *SupplyChainContract) TakeOwnership(ctx contractapi.TransactionContextIn
 	if err != nil {
 		return err
 	}
+	if err := checkVersion("product", productID, product.Version, expectedVersion); err != nil {
+		return err
+	}
 	
 	// Verify product is at retailer and available for sale
 	if product.Status != ProductStatusInStore {
-		return fmt.Errorf("product is not available for sale, current status: %s", product.Status)
+		return newError(ErrInvalidState, "product is not available for sale, current status: %s", product.Status)
+	}
+	if err := checkStolenProduct(ctx, product); err != nil {
+		return err
+	}
+	if err := checkCertificateNotRevoked(product); err != nil {
+		return err
+	}
+	// A consigned product is sold by the retailer holding it, which buys it from the
+	// consignor at that moment
+	consignor := ""
+	if product.Consignment.active() {
+		if !product.Consignment.heldBy(caller) {
+			return newError(ErrPermissionDenied, "product %s is on consignment at %s", productID, product.Consignment.Consignee)
+		}
+		consignor = product.Consignment.Consignor
+		if err := settleConsignment(ctx, product); err != nil {
+			return err
+		}
 	}
 	
 	// Check if already owned
 	ownershipKey := "ownership_" + productID
 	existingOwnership, _ := ctx.GetStub().GetState(ownershipKey)
 	if existingOwnership != nil {
-		return fmt.Errorf("product already has an owner")
+		return newError(ErrAlreadyExists, "product already has an owner")
+	}
+	
+	// Register the warranty for the product type
+	warranty, err := newWarranty(ctx, product)
+	if err != nil {
+		return err
 	}
 	
 	// Create ownership record
 	ownership := Ownership{
+		SchemaVersion: CurrentSchemaVersion,
 		ProductID:        productID,
 		OwnerHash:        ownerHash,
 		SecurityHash:     securityHash,  // Store security hash for PIN verification
@@ -1346,114 +2185,224 @@ func (s *SupplyChainContract) TakeOwnership(ctx contractapi.TransactionContextIn
 		Status:           OwnershipStatusActive,
 		ServiceHistory:   []ServiceRecord{},
 		PreviousOwners:   []PreviousOwner{},
+		Warranty:         warranty,
 	}
 	
 	// Store ownership
-	ownershipJSON, err := json.Marshal(ownership)
-	if err != nil {
-		return err
-	}
-	err = ctx.GetStub().PutState(ownershipKey, ownershipJSON)
+	err = putOwnership(ctx, &ownership)
 	if err != nil {
 		return err
 	}
 	
 	// Update product status and ownership
-	product.Status = ProductStatusSold
+	if err := setProductStatus(product, ProductStatusSold); err != nil {
+		return err
+	}
 	product.OwnershipHash = ownerHash
-	product.CurrentOwner = "customer" // Generic label for privacy (actual owner identified by hash)
+	product.CurrentOwner = customerOwner // Generic label for privacy (actual owner identified by hash)
 	product.IsStolen = false
-	
-	productJSON, err := json.Marshal(product)
+	// Selling a donated product again, e.g. after a return, is a resale unless the charity sells it
+	soldAt, err := txTime(ctx)
 	if err != nil {
 		return err
 	}
-	err = ctx.GetStub().PutState(productID, productJSON)
+	provenanceNoted := product.Donation != nil && product.Donation.Charity != caller &&
+		addDonationNote(product, soldAt.UTC().Format(time.RFC3339))
+	
+	err = putProduct(ctx, product)
 	if err != nil {
 		return err
 	}
 	
 	// Update batch status if needed
+	var batchStatus BatchStatus
 	if product.BatchID != "" {
-		err = s.updateBatchStatus(ctx, product.BatchID)
+		batchStatus, err = s.updateBatchStatus(ctx, product)
 		if err != nil {
 			// Log error but don't fail the ownership transfer
-			fmt.Printf("Warning: failed to update batch status: %v\n", err)
+			logFor(ctx).Warn("failed to update batch status", "batchId", product.BatchID, "productId", product.ID, "error", err)
 		}
 	}
 	
-	// Emit event
-	ctx.GetStub().SetEvent("OwnershipTaken", ownershipJSON)
-	
-	return nil
+	// Emit event; the owner is only known by hash and stays off the event stream.
+	// A batch status change is reported here, Fabric keeps one event per transaction.
+	event := ChaincodeEvent{
+		EventType:  EventOwnershipTaken,
+		EntityType: EventEntityProduct,
+		EntityID:   productID,
+		ToState:    string(product.Status),
+		Attributes: map[string]interface{}{
+			"batchId": product.BatchID,
+		},
+	}
+	if batchStatus != "" {
+		event.Attributes["batchStatus"] = batchStatus
+	}
+	if provenanceNoted {
+		event.Attributes["provenanceNote"] = provenanceNoteDonation
+	}
+	if consignor != "" {
+		event.Attributes["consignor"] = consignor
+	}
+	return emitEvent(ctx, event)
 }
 
-// updateBatchStatus updates batch status based on sold products
+// updateBatchStatus updates the status of a product's current batch after the product
+// was sold or destroyed and returns the new status, empty when it did not change.
+// Destroyed products are counted in DestroyedQuantity and no longer have to be sold
+// for the batch to sell out.
 func (s *SupplyChainContract) updateBatchStatus(ctx contractapi.TransactionContextInterface,
-	batchID string) error {
+	product *Product) (BatchStatus, error) {
 	
-	// Get batch
-	batch, err := s.GetBatch(ctx, batchID)
-	if err != nil {
-		return err
+	// Get batch, which may be the one the product was merged into
+	batch, err := s.currentBatchOf(ctx, product)
+	if err != nil || batch == nil {
+		return "", err
 	}
 	
-	// Count sold products
-	soldCount := 0
+	// Count sold and destroyed products; the changed product is not re-read, a
+	// transaction does not read its own writes
+	soldCount, destroyedCount := 0, 0
 	for _, productID := range batch.ProductIDs {
-		product, err := s.GetProduct(ctx, productID)
-		if err != nil {
-			continue
+		member := product
+		if productID != product.ID {
+			member, err = s.GetProduct(ctx, productID)
+			if err != nil {
+				continue
+			}
 		}
-		if product.Status == ProductStatusSold {
+		switch member.Status {
+		case ProductStatusSold:
 			soldCount++
+		case ProductStatusDestroyed:
+			destroyedCount++
 		}
 	}
 	
 	// Update batch status
-	if soldCount == 0 {
-		// No change needed
-		return nil
-	} else if soldCount == batch.Quantity {
-		batch.Status = BatchStatusSold
-	} else {
-		batch.Status = BatchStatusPartial
+	previousStatus := batch.Status
+	if soldCount > 0 {
+		status := BatchStatusPartial
+		if soldCount+destroyedCount == batch.Quantity {
+			status = BatchStatusSold
+		}
+		if err := setBatchStatus(batch, status); err != nil {
+			return "", err
+		}
+	}
+	destroyedChanged := batch.DestroyedQuantity != destroyedCount
+	batch.DestroyedQuantity = destroyedCount
+	if batch.Status == previousStatus && !destroyedChanged {
+		return "", nil
+	}
+	
+	// Save updated batch
+	if err := putBatch(ctx, batch); err != nil {
+		return "", err
+	}
+	if batch.Status == previousStatus {
+		return "", nil
+	}
+	return batch.Status, nil
+}
+
+// GetBatch retrieves a batch by ID
+func (s *SupplyChainContract) GetBatch(ctx contractapi.TransactionContextInterface,
+	batchID string) (*ProductBatch, error) {
+	
+	if err := validateID("batchID", batchID); err != nil {
+		return nil, err
+	}
+
+	batchJSON, err := ctx.GetStub().GetState("batch_" + batchID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read batch: %v", err)
+	}
+	if batchJSON == nil {
+		return nil, newError(ErrNotFound, "batch %s does not exist", batchID)
+	}
+	
+	var batch ProductBatch
+	err = json.Unmarshal(batchJSON, &batch)
+	if err != nil {
+		return nil, err
+	}
+	batch.upgradeSchema()
+	
+	return &batch, nil
+}
+
+// ApproveBatch records the brand's approval of a batch, which lets it leave the
+// manufacturer while the requireBrandApproval feature flag is set
+func (s *SupplyChainContract) ApproveBatch(ctx contractapi.TransactionContextInterface,
+	batchID string) error {
+
+	if err := validateID("batchID", batchID); err != nil {
+		return err
+	}
+
+	caller, err := requireSuperAdmin(ctx)
+	if err != nil {
+		return err
+	}
+
+	batch, err := s.GetBatch(ctx, batchID)
+	if err != nil {
+		return err
 	}
-	
-	// Save updated batch
-	batchJSON, err := json.Marshal(batch)
+	if batch.BrandApprovedAt != "" {
+		return newError(ErrInvalidState, "batch %s was already approved by %s", batchID, batch.BrandApprovedBy)
+	}
+
+	batch.BrandApprovedBy = caller
+	batch.BrandApprovedAt = time.Now().Format(time.RFC3339)
+	err = putBatch(ctx, batch)
 	if err != nil {
 		return err
 	}
-	
-	return ctx.GetStub().PutState("batch_"+batchID, batchJSON)
+
+	return emitEvent(ctx, ChaincodeEvent{
+		EventType:  EventBatchApproved,
+		EntityType: EventEntityBatch,
+		EntityID:   batchID,
+		Attributes: map[string]interface{}{
+			"manufacturer": batch.Manufacturer,
+		},
+	})
 }
 
-// GetBatch retrieves a batch by ID
-func (s *SupplyChainContract) GetBatch(ctx contractapi.TransactionContextInterface,
-	batchID string) (*ProductBatch, error) {
-	
-	batchJSON, err := ctx.GetStub().GetState("batch_" + batchID)
+// checkBrandApproval rejects moving a batch, or a product of it, out of its manufacturer
+// before the brand approved it, when the requireBrandApproval feature flag is set
+func (s *SupplyChainContract) checkBrandApproval(ctx contractapi.TransactionContextInterface,
+	batchID string, sender string) error {
+
+	flags, err := getFeatureFlags(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read batch: %v", err)
+		return err
 	}
-	if batchJSON == nil {
-		return nil, fmt.Errorf("batch %s does not exist", batchID)
+	if !flags.RequireBrandApproval {
+		return nil
 	}
-	
-	var batch ProductBatch
-	err = json.Unmarshal(batchJSON, &batch)
+
+	batch, err := s.GetBatch(ctx, batchID)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	
-	return &batch, nil
+	if batch.Manufacturer != sender || batch.BrandApprovedAt != "" {
+		return nil
+	}
+
+	return newError(ErrInvalidState, "batch %s must be approved by the brand before it leaves the manufacturer", batchID)
 }
 
 // GetPublicProductInfo returns only public information about a product
 func (s *SupplyChainContract) GetPublicProductInfo(ctx contractapi.TransactionContextInterface, 
 	productID string) (map[string]interface{}, error) {
 	
+	if err := validateID("productID", productID); err != nil {
+		return nil, err
+	}
+
 	product, err := s.GetProduct(ctx, productID)
 	if err != nil {
 		return nil, err
@@ -1470,65 +2419,48 @@ func (s *SupplyChainContract) GetPublicProductInfo(ctx contractapi.TransactionCo
 		"hasOwner":     product.OwnershipHash != "",
 		"createdAt":    product.CreatedAt,
 	}
+	if len(product.ProvenanceNotes) > 0 {
+		publicInfo["provenanceNotes"] = product.ProvenanceNotes
+	}
 	
 	return publicInfo, nil
 }
 
-// GetAllProducts returns all products from the blockchain
-func (s *SupplyChainContract) GetAllProducts(ctx contractapi.TransactionContextInterface) ([]*Product, error) {
-	// Query all products - we'll use a range query to get all product keys
-	// Products are stored with their productID as the key directly
-	resultsIterator, err := ctx.GetStub().GetStateByRange("", "")
-	if err != nil {
-		return nil, fmt.Errorf("failed to query products: %v", err)
-	}
-	defer resultsIterator.Close()
-
-	var products []*Product
-	for resultsIterator.HasNext() {
-		queryResponse, err := resultsIterator.Next()
-		if err != nil {
-			return nil, err
-		}
-
-		// Skip if this is not a product (e.g., transfers or material inventories)
-		key := queryResponse.Key
-		if strings.HasPrefix(key, "transfer_") || strings.HasPrefix(key, "material_inventory_") {
-			continue
-		}
-
-		var product Product
-		err = json.Unmarshal(queryResponse.Value, &product)
-		if err != nil {
-			// Skip items that don't unmarshal as products
-			continue
-		}
-		// Ensure Materials is never nil
-		if product.Materials == nil {
-			product.Materials = []Material{}
-		}
+// GetAllProducts returns one page of the products. Start with an empty bookmark and
+// pass each page's bookmark to the next call.
+func (s *SupplyChainContract) GetAllProducts(ctx contractapi.TransactionContextInterface,
+	pageSize int, bookmark string) (*ProductPage, error) {
 
-		// Verify it's actually a product by checking required fields
-		if product.ID != "" && product.Brand != "" {
-			products = append(products, &product)
-		}
+	page := &ProductPage{Records: []*Product{}}
+	result, err := scanPage(ctx, productKeyPrefix, pageSize, bookmark, addProduct(&page.Records))
+	if err != nil {
+		return nil, err
 	}
+	page.PaginatedResult = *result
 
-	return products, nil
+	return page, nil
 }
 
 // UpdateTransferStatus updates the status of a material transfer
 func (s *SupplyChainContract) UpdateTransferStatus(ctx contractapi.TransactionContextInterface, 
 	transferID string, status string) error {
 	
+	if err := validateAll(
+		validateID("transferID", transferID),
+		validateEnum("status", status, "DISPUTED", "RESOLVED"),
+	); err != nil {
+		return err
+	}
+
 	// Query all material inventories to find the transfer
-	inventories, err := s.GetAllMaterialInventories(ctx)
+	inventories, err := allMaterialInventories(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to get inventories: %v", err)
 	}
 	
 	// Find and update the transfer
 	found := false
+	materialID := ""
 	for _, inventory := range inventories {
 		for i, transfer := range inventory.Transfers {
 			if transfer.TransferID == transferID {
@@ -1542,17 +2474,13 @@ func (s *SupplyChainContract) UpdateTransferStatus(ctx contractapi.TransactionCo
 				
 				// Save the updated inventory
 				inventoryKey := fmt.Sprintf("material_inventory_%s_%s", inventory.MaterialID, inventory.Owner)
-				inventoryJSON, err := json.Marshal(inventory)
-				if err != nil {
-					return fmt.Errorf("failed to marshal inventory: %v", err)
-				}
-				
-				err = ctx.GetStub().PutState(inventoryKey, inventoryJSON)
+				err = putMaterialInventory(ctx, inventoryKey, inventory)
 				if err != nil {
 					return fmt.Errorf("failed to update inventory: %v", err)
 				}
 				
 				found = true
+				materialID = inventory.MaterialID
 				break
 			}
 		}
@@ -1562,14 +2490,26 @@ func (s *SupplyChainContract) UpdateTransferStatus(ctx contractapi.TransactionCo
 	}
 	
 	if !found {
-		return fmt.Errorf("transfer %s not found", transferID)
+		return newError(ErrNotFound, "transfer %s not found", transferID)
 	}
 	
-	return nil
+	return emitEvent(ctx, ChaincodeEvent{
+		EventType:  EventMaterialTransferStatusUpdated,
+		EntityType: EventEntityMaterial,
+		EntityID:   materialID,
+		ToState:    status,
+		Attributes: map[string]interface{}{
+			"transferId": transferID,
+		},
+	})
 }
 
 // GetMaterialTransfer retrieves a material transfer by ID
 func (s *SupplyChainContract) GetMaterialTransfer(ctx contractapi.TransactionContextInterface, transferID string) (*MaterialTransferRecord, error) {
+	if err := validateID("transferID", transferID); err != nil {
+		return nil, err
+	}
+
 	// Search through all material inventories to find the transfer
 	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey("material_inventory", []string{})
 	if err != nil {
@@ -1596,7 +2536,7 @@ func (s *SupplyChainContract) GetMaterialTransfer(ctx contractapi.TransactionCon
 		}
 	}
 	
-	return nil, fmt.Errorf("transfer %s not found", transferID)
+	return nil, newError(ErrNotFound, "transfer %s not found", transferID)
 }
 
 // ============= MISSING FUNCTIONS IMPLEMENTATION =============
@@ -1605,6 +2545,10 @@ func (s *SupplyChainContract) GetMaterialTransfer(ctx contractapi.TransactionCon
 func (s *SupplyChainContract) GetProductsByBatch(ctx contractapi.TransactionContextInterface,
 	batchID string) ([]*Product, error) {
 	
+	if err := validateID("batchID", batchID); err != nil {
+		return nil, err
+	}
+
 	// Get the batch first
 	batch, err := s.GetBatch(ctx, batchID)
 	if err != nil {
@@ -1624,16 +2568,38 @@ func (s *SupplyChainContract) GetProductsByBatch(ctx contractapi.TransactionCont
 	return products, nil
 }
 
-// GetAllBatches retrieves all batches from the blockchain
-func (s *SupplyChainContract) GetAllBatches(ctx contractapi.TransactionContextInterface) ([]*ProductBatch, error) {
-	// Query all batches
-	resultsIterator, err := ctx.GetStub().GetStateByRange("batch_", "batch_~")
+// GetAllBatches returns one page of the batches. Start with an empty bookmark and pass
+// each page's bookmark to the next call.
+func (s *SupplyChainContract) GetAllBatches(ctx contractapi.TransactionContextInterface,
+	pageSize int, bookmark string) (*BatchPage, error) {
+
+	page := &BatchPage{Records: []*ProductBatch{}}
+	result, err := scanPage(ctx, "batch_", pageSize, bookmark, addBatch(&page.Records))
+	if err != nil {
+		return nil, err
+	}
+	page.PaginatedResult = *result
+
+	return page, nil
+}
+
+// GetBatchesByOrganization retrieves all batches owned by an organization
+func (s *SupplyChainContract) GetBatchesByOrganization(ctx contractapi.TransactionContextInterface,
+	orgMSPID string) ([]*ProductBatch, error) {
+	
+	if err := validateID("orgMSPID", orgMSPID); err != nil {
+		return nil, err
+	}
+
+	queryString := fmt.Sprintf(`{"selector":{"currentOwner":"%s","productIds":{"$exists":true}},`+
+		`"use_index":["_design/indexBatchOwnerDoc","indexBatchOwner"]}`, orgMSPID)
+	resultsIterator, err := ctx.GetStub().GetQueryResult(queryString)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query batches: %v", err)
 	}
 	defer resultsIterator.Close()
 	
-	var batches []*ProductBatch
+	var orgBatches []*ProductBatch
 	for resultsIterator.HasNext() {
 		queryResponse, err := resultsIterator.Next()
 		if err != nil {
@@ -1646,28 +2612,7 @@ func (s *SupplyChainContract) GetAllBatches(ctx contractapi.TransactionContextIn
 			continue
 		}
 		
-		batches = append(batches, &batch)
-	}
-	
-	return batches, nil
-}
-
-// GetBatchesByOrganization retrieves all batches owned by an organization
-func (s *SupplyChainContract) GetBatchesByOrganization(ctx contractapi.TransactionContextInterface,
-	orgMSPID string) ([]*ProductBatch, error) {
-	
-	// Get all batches
-	allBatches, err := s.GetAllBatches(ctx)
-	if err != nil {
-		return nil, err
-	}
-	
-	// Filter by organization
-	var orgBatches []*ProductBatch
-	for _, batch := range allBatches {
-		if batch.CurrentOwner == orgMSPID {
-			orgBatches = append(orgBatches, batch)
-		}
+		orgBatches = append(orgBatches, &batch)
 	}
 	
 	return orgBatches, nil
@@ -1675,8 +2620,15 @@ func (s *SupplyChainContract) GetBatchesByOrganization(ctx contractapi.Transacti
 
 // UpdateBatchLocation updates the location and status of a batch
 func (s *SupplyChainContract) UpdateBatchLocation(ctx contractapi.TransactionContextInterface,
-	batchID string, newLocation string, newStatus string) error {
+	batchID string, newLocation string, newStatus string, expectedVersion int) error {
 	
+	if err := validateAll(
+		validateID("batchID", batchID),
+		validateName("newLocation", newLocation),
+	); err != nil {
+		return err
+	}
+
 	// Get caller identity to verify permission
 	caller, err := ctx.GetClientIdentity().GetMSPID()
 	if err != nil {
@@ -1687,7 +2639,7 @@ func (s *SupplyChainContract) UpdateBatchLocation(ctx contractapi.TransactionCon
 	roleContract := &RoleManagementContract{}
 	hasPermission, err := roleContract.CheckPermission(ctx, caller, "UPDATE_LOCATION")
 	if err != nil || !hasPermission {
-		return fmt.Errorf("caller %s does not have permission to update batch location", caller)
+		return newError(ErrPermissionDenied, "caller %s does not have permission to update batch location", caller)
 	}
 	
 	// Get batch
@@ -1698,10 +2650,17 @@ func (s *SupplyChainContract) UpdateBatchLocation(ctx contractapi.TransactionCon
 	
 	// Verify caller owns the batch
 	if batch.CurrentOwner != caller {
-		return fmt.Errorf("only the current owner can update batch location")
+		return newError(ErrPermissionDenied, "only the current owner can update batch location")
+	}
+	if err := checkVersion("batch", batchID, batch.Version, expectedVersion); err != nil {
+		return err
+	}
+	if batch.Status == BatchStatusAssembling {
+		return newError(ErrInvalidState, "batch %s has not been finalized", batchID)
 	}
 	
 	// Update location
+	previousStatus := batch.Status
 	batch.CurrentLocation = newLocation
 	
 	// Update status if provided
@@ -1721,24 +2680,44 @@ func (s *SupplyChainContract) UpdateBatchLocation(ctx contractapi.TransactionCon
 		case "SOLD_OUT":
 			status = BatchStatusSold
 		default:
-			return fmt.Errorf("invalid batch status: %s", newStatus)
+			return newError(ErrInvalidArgument, "invalid batch status: %s", newStatus)
+		}
+		if err := setBatchStatus(batch, status); err != nil {
+			return err
 		}
-		batch.Status = status
 	}
 	
 	// Save updated batch
-	batchJSON, err := json.Marshal(batch)
+	err = putBatch(ctx, batch)
 	if err != nil {
 		return err
 	}
-	
-	return ctx.GetStub().PutState("batch_"+batchID, batchJSON)
+
+	return emitEvent(ctx, ChaincodeEvent{
+		EventType:  EventBatchLocationUpdated,
+		EntityType: EventEntityBatch,
+		EntityID:   batchID,
+		FromState:  string(previousStatus),
+		ToState:    string(batch.Status),
+		Attributes: map[string]interface{}{
+			"location": newLocation,
+		},
+	})
 }
 
 // ProcessReturn handles inventory adjustments after dispute resolution
 func (s *SupplyChainContract) ProcessReturn(ctx contractapi.TransactionContextInterface,
 	returnTransferID string, itemType string, itemID string, quantity int) error {
 	
+	if err := validateAll(
+		validateID("returnTransferID", returnTransferID),
+		validateEnum("itemType", itemType, "MATERIAL", "PRODUCT", "BATCH"),
+		validateID("itemID", itemID),
+		validateQuantity("quantity", float64(quantity)),
+	); err != nil {
+		return err
+	}
+
 	// Get the return transfer
 	transfer, err := s.GetTransfer(ctx, returnTransferID)
 	if err != nil {
@@ -1747,7 +2726,19 @@ func (s *SupplyChainContract) ProcessReturn(ctx contractapi.TransactionContextIn
 	
 	// Verify this is a return transfer
 	if transfer.TransferType != TransferTypeReturn {
-		return fmt.Errorf("transfer %s is not a return transfer", returnTransferID)
+		return newError(ErrInvalidState, "transfer %s is not a return transfer", returnTransferID)
+	}
+
+	// Both parties confirmed the return in the consensus chaincode, so record the
+	// sent step if it is missing. A return that was already processed is rejected.
+	previousStatus := transfer.Status
+	if transfer.Status == TransferStatusInitiated {
+		if err := setTransferStatus(transfer, TransferStatusPending); err != nil {
+			return err
+		}
+	}
+	if err := setTransferStatus(transfer, TransferStatusCompleted); err != nil {
+		return err
 	}
 	
 	// Check item type
@@ -1766,8 +2757,9 @@ func (s *SupplyChainContract) ProcessReturn(ctx contractapi.TransactionContextIn
 			json.Unmarshal(fromInventoryJSON, &fromInventory)
 			fromInventory.Available -= float64(quantity)
 			
-			updatedFromJSON, _ := json.Marshal(fromInventory)
-			ctx.GetStub().PutState(fromInventoryKey, updatedFromJSON)
+			if err := putMaterialInventory(ctx, fromInventoryKey, &fromInventory); err != nil {
+				return err
+			}
 		}
 		
 		// Add to receiver's inventory
@@ -1780,8 +2772,9 @@ func (s *SupplyChainContract) ProcessReturn(ctx contractapi.TransactionContextIn
 			json.Unmarshal(toInventoryJSON, &toInventory)
 			toInventory.Available += float64(quantity)
 			
-			updatedToJSON, _ := json.Marshal(toInventory)
-			ctx.GetStub().PutState(toInventoryKey, updatedToJSON)
+			if err := putMaterialInventory(ctx, toInventoryKey, &toInventory); err != nil {
+				return err
+			}
 		}
 	} else if itemType == "PRODUCT" || itemType == "BATCH" {
 		// Handle product/batch return
@@ -1795,8 +2788,9 @@ func (s *SupplyChainContract) ProcessReturn(ctx contractapi.TransactionContextIn
 			batch.CurrentOwner = transfer.To
 			batch.CurrentLocation = transfer.To
 			
-			batchJSON, _ := json.Marshal(batch)
-			ctx.GetStub().PutState("batch_"+itemID, batchJSON)
+			if err := putBatch(ctx, batch); err != nil {
+				return err
+			}
 			
 			// Update all products in batch
 			for _, productID := range batch.ProductIDs {
@@ -1804,8 +2798,9 @@ func (s *SupplyChainContract) ProcessReturn(ctx contractapi.TransactionContextIn
 				if err == nil {
 					product.CurrentOwner = transfer.To
 					product.CurrentLocation = transfer.To
-					productJSON, _ := json.Marshal(product)
-					ctx.GetStub().PutState(productID, productJSON)
+					if err := putProduct(ctx, product); err != nil {
+						return err
+					}
 				}
 			}
 		} else {
@@ -1818,62 +2813,71 @@ func (s *SupplyChainContract) ProcessReturn(ctx contractapi.TransactionContextIn
 			product.CurrentOwner = transfer.To
 			product.CurrentLocation = transfer.To
 			
-			productJSON, _ := json.Marshal(product)
-			ctx.GetStub().PutState(itemID, productJSON)
+			if err := putProduct(ctx, product); err != nil {
+				return err
+			}
 		}
 	}
 	
 	// Mark transfer as processed
-	transfer.Status = TransferStatusCompleted
 	transfer.CompletedAt = time.Now().Format(time.RFC3339)
 	
-	transferJSON, _ := json.Marshal(transfer)
-	ctx.GetStub().PutState("transfer_"+returnTransferID, transferJSON)
-	
-	// Emit event
-	eventData := map[string]interface{}{
-		"transferID": returnTransferID,
-		"itemType":   itemType,
-		"itemID":     itemID,
-		"quantity":   quantity,
-		"from":       transfer.From,
-		"to":         transfer.To,
+	if err := putTransfer(ctx, transfer); err != nil {
+		return err
 	}
-	eventJSON, _ := json.Marshal(eventData)
-	ctx.GetStub().SetEvent("ReturnProcessed", eventJSON)
 	
-	return nil
+	// Emit event
+	event := transferEvent(EventReturnProcessed, transfer, previousStatus)
+	event.Attributes["itemType"] = itemType
+	event.Attributes["itemId"] = itemID
+	event.Attributes["quantity"] = quantity
+	return emitEvent(ctx, event)
 }
 
 // ProcessCustomerReturn handles direct returns from customers to retailers
 // No consensus needed since customers aren't blockchain participants
 func (s *SupplyChainContract) ProcessCustomerReturn(ctx contractapi.TransactionContextInterface,
-	productID string, reason string, retailerMSPID string) error {
+	productID string, reason string, retailerMSPID string, expectedVersion int) error {
 	
+	if err := validateAll(
+		validateID("productID", productID),
+		validateRequired("reason", reason, maxTextLength),
+		validateID("retailerMSPID", retailerMSPID),
+	); err != nil {
+		return err
+	}
+
 	// Get product
 	product, err := s.GetProduct(ctx, productID)
 	if err != nil {
-		return fmt.Errorf("failed to get product: %v", err)
+		return wrapError(err, "failed to get product")
+	}
+	if err := checkVersion("product", productID, product.Version, expectedVersion); err != nil {
+		return err
 	}
 	
 	// Verify product has customer ownership
 	if product.OwnershipHash == "" {
-		return fmt.Errorf("product %s has no customer owner", productID)
+		return newError(ErrInvalidState, "product %s has no customer owner", productID)
 	}
 	
 	// Verify retailer is valid
 	roleContract := &RoleManagementContract{}
 	retailerRole, err := roleContract.GetOrganizationRole(ctx, retailerMSPID)
 	if err != nil {
-		return fmt.Errorf("invalid retailer: %v", err)
+		return newError(ErrInvalidArgument, "invalid retailer: %v", err)
 	}
 	if retailerRole != RoleRetailer {
-		return fmt.Errorf("%s is not a retailer", retailerMSPID)
+		return newError(ErrInvalidArgument, "%s is not a retailer", retailerMSPID)
 	}
 	
 	// Clear customer ownership
 	product.OwnershipHash = "NONE"
-	product.Status = ProductStatusInStore // Back in store, not "SOLD" anymore
+	previousStatus := product.Status
+	// Back in store, not "SOLD" anymore
+	if err := setProductStatus(product, ProductStatusInStore); err != nil {
+		return err
+	}
 	product.CurrentOwner = retailerMSPID
 	product.CurrentLocation = retailerMSPID
 	
@@ -1887,26 +2891,25 @@ func (s *SupplyChainContract) ProcessCustomerReturn(ctx contractapi.TransactionC
 	
 	// Clear ownership record
 	ownershipKey := "ownership_" + productID
+	err = trackCompositeIndex(ctx, ownershipKey, (*Ownership)(nil), ownershipIndexKeys)
+	if err != nil {
+		return err
+	}
 	err = ctx.GetStub().DelState(ownershipKey)
 	if err != nil {
 		return fmt.Errorf("failed to clear ownership record: %v", err)
 	}
 	
 	// Save updated product
-	productJSON, err := json.Marshal(product)
-	if err != nil {
-		return err
-	}
-	
-	err = ctx.GetStub().PutState(productID, productJSON)
+	err = putProduct(ctx, product)
 	if err != nil {
 		return err
 	}
 	
 	// Update batch status if needed
 	if product.BatchID != "" {
-		batch, err := s.GetBatch(ctx, product.BatchID)
-		if err == nil {
+		batch, err := s.currentBatchOf(ctx, product)
+		if err == nil && batch != nil {
 			// Check if any products from this batch are still sold
 			stillSold := false
 			for _, pid := range batch.ProductIDs {
@@ -1917,156 +2920,127 @@ func (s *SupplyChainContract) ProcessCustomerReturn(ctx contractapi.TransactionC
 				}
 			}
 			if !stillSold {
-				batch.Status = BatchStatusAtRetailer
-				batchJSON, _ := json.Marshal(batch)
-				ctx.GetStub().PutState("batch_"+batch.ID, batchJSON)
+				if err := setBatchStatus(batch, BatchStatusAtRetailer); err != nil {
+					return err
+				}
+				if err := putBatch(ctx, batch); err != nil {
+					return err
+				}
 			}
 		}
 	}
 	
 	// Emit event
-	eventData := map[string]interface{}{
-		"productID": productID,
-		"reason":    reason,
-		"retailer":  retailerMSPID,
-		"timestamp": time.Now().Format(time.RFC3339),
-	}
-	eventJSON, _ := json.Marshal(eventData)
-	ctx.GetStub().SetEvent("CustomerReturnProcessed", eventJSON)
-	
-	return nil
+	return emitEvent(ctx, ChaincodeEvent{
+		EventType:  EventCustomerReturnProcessed,
+		EntityType: EventEntityProduct,
+		EntityID:   productID,
+		FromState:  string(previousStatus),
+		ToState:    string(product.Status),
+		Actor:      retailerMSPID,
+		Attributes: map[string]interface{}{
+			"reason": reason,
+		},
+	})
 }
 
-// GetTransfersByProduct retrieves all transfers for a specific product
+// GetTransfersByProduct retrieves all transfers for a specific product, along with
+// the batch transfers of the batch that lists it among its products
 func (s *SupplyChainContract) GetTransfersByProduct(ctx contractapi.TransactionContextInterface,
 	productID string) ([]*Transfer, error) {
 	
-	// Query all transfers
-	resultsIterator, err := ctx.GetStub().GetStateByRange("transfer_", "transfer_~")
+	if err := validateID("productID", productID); err != nil {
+		return nil, err
+	}
+
+	transfers, err := s.queryTransfersOfItem(ctx, productID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to query transfers: %v", err)
+		return nil, err
 	}
-	defer resultsIterator.Close()
-	
-	var transfers []*Transfer
-	for resultsIterator.HasNext() {
-		queryResponse, err := resultsIterator.Next()
-		if err != nil {
-			return nil, err
-		}
-		
-		var transfer Transfer
-		err = json.Unmarshal(queryResponse.Value, &transfer)
-		if err != nil {
-			continue
-		}
-		
-		// Check if this transfer involves the product
-		if transfer.ProductID == productID {
-			transfers = append(transfers, &transfer)
-		}
-		
-		// Also check if it's a batch containing this product
-		if transfer.Metadata != nil {
-			if batchType, ok := transfer.Metadata["type"].(string); ok && batchType == "BATCH" {
-				// Get the batch to check if it contains the product
-				batch, err := s.GetBatch(ctx, transfer.ProductID)
-				if err == nil {
-					for _, pid := range batch.ProductIDs {
-						if pid == productID {
-							transfers = append(transfers, &transfer)
-							break
-						}
-					}
-				}
-			}
+
+	// Also include the transfers of the batch containing this product
+	product, err := s.GetProduct(ctx, productID)
+	if err != nil || product.BatchID == "" || product.BatchID == productID {
+		return transfers, nil
+	}
+	batch, err := s.GetBatch(ctx, product.BatchID)
+	if err != nil || !containsString(batch.ProductIDs, productID) {
+		return transfers, nil
+	}
+	batchTransfers, err := s.queryTransfersOfItem(ctx, batch.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, transfer := range batchTransfers {
+		if batchType, ok := transfer.Metadata["type"].(string); ok && batchType == "BATCH" {
+			transfers = append(transfers, transfer)
 		}
 	}
 	
 	return transfers, nil
 }
 
-// GetPendingTransfers retrieves all pending transfers for an organization
-func (s *SupplyChainContract) GetPendingTransfers(ctx contractapi.TransactionContextInterface,
-	orgMSPID string) ([]*Transfer, error) {
-	
-	// Query all transfers
-	resultsIterator, err := ctx.GetStub().GetStateByRange("transfer_", "transfer_~")
+// queryTransfersOfItem returns the transfers whose productId is itemID, a product or batch
+func (s *SupplyChainContract) queryTransfersOfItem(ctx contractapi.TransactionContextInterface,
+	itemID string) ([]*Transfer, error) {
+
+	queryString := fmt.Sprintf(`{"selector":{"productId":"%s","transferType":{"$exists":true}},`+
+		`"use_index":["_design/indexTransferProductDoc","indexTransferProduct"]}`, itemID)
+	resultsIterator, err := ctx.GetStub().GetQueryResult(queryString)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query transfers: %v", err)
 	}
 	defer resultsIterator.Close()
-	
-	var pendingTransfers []*Transfer
+
+	var transfers []*Transfer
 	for resultsIterator.HasNext() {
 		queryResponse, err := resultsIterator.Next()
 		if err != nil {
 			return nil, err
 		}
-		
+
 		var transfer Transfer
 		err = json.Unmarshal(queryResponse.Value, &transfer)
 		if err != nil {
 			continue
 		}
-		
-		// Check if transfer is pending and involves the organization
-		if transfer.Status != TransferStatusCompleted && transfer.Status != TransferStatusCancelled {
-			if transfer.From == orgMSPID || transfer.To == orgMSPID {
-				// Skip return transfers from dispute resolutions - they should be handled separately
-				if metadata, ok := transfer.Metadata["resolutionType"].(string); ok && metadata == "dispute_resolution" {
-					continue
-				}
-				pendingTransfers = append(pendingTransfers, &transfer)
-			}
-		}
+
+		transfers = append(transfers, &transfer)
 	}
+
+	return transfers, nil
+}
+
+// GetPendingTransfers retrieves all pending transfers for an organization
+func (s *SupplyChainContract) GetPendingTransfers(ctx contractapi.TransactionContextInterface,
+	orgMSPID string) ([]*Transfer, error) {
 	
-	return pendingTransfers, nil
+	if err := validateID("orgMSPID", orgMSPID); err != nil {
+		return nil, err
+	}
+
+	return getIndexedTransfers(ctx, pendingTransferKey(orgMSPID, ""))
 }
 
 // GetDisputeReturnTransfers retrieves all pending return transfers from dispute resolutions
 func (s *SupplyChainContract) GetDisputeReturnTransfers(ctx contractapi.TransactionContextInterface,
 	orgMSPID string) ([]*Transfer, error) {
 	
-	// Query all transfers
-	resultsIterator, err := ctx.GetStub().GetStateByRange("transfer_", "transfer_~")
-	if err != nil {
-		return nil, fmt.Errorf("failed to query transfers: %v", err)
-	}
-	defer resultsIterator.Close()
-	
-	var returnTransfers []*Transfer
-	for resultsIterator.HasNext() {
-		queryResponse, err := resultsIterator.Next()
-		if err != nil {
-			return nil, err
-		}
-		
-		var transfer Transfer
-		err = json.Unmarshal(queryResponse.Value, &transfer)
-		if err != nil {
-			continue
-		}
-		
-		// Check if this is a dispute return transfer
-		if resolutionType, ok := transfer.Metadata["resolutionType"].(string); ok && resolutionType == "dispute_resolution" {
-			// Check if transfer is pending and involves the organization
-			if transfer.Status != TransferStatusCompleted && transfer.Status != TransferStatusCancelled {
-				if transfer.From == orgMSPID || transfer.To == orgMSPID {
-					returnTransfers = append(returnTransfers, &transfer)
-				}
-			}
-		}
+	if err := validateID("orgMSPID", orgMSPID); err != nil {
+		return nil, err
 	}
-	
-	return returnTransfers, nil
+
+	return getIndexedTransfers(ctx, disputeReturnKey(orgMSPID, ""))
 }
 
 // GetDashboardStats returns dashboard statistics for an organization
 func (s *SupplyChainContract) GetDashboardStats(ctx contractapi.TransactionContextInterface,
 	orgMSPID string) (map[string]interface{}, error) {
 	
+	if err := validateID("orgMSPID", orgMSPID); err != nil {
+		return nil, err
+	}
+
 	stats := make(map[string]interface{})
 	
 	// Get organization role
@@ -2074,37 +3048,22 @@ func (s *SupplyChainContract) GetDashboardStats(ctx contractapi.TransactionConte
 	orgRole, _ := roleContract.GetOrganizationRole(ctx, orgMSPID)
 	stats["organizationRole"] = string(orgRole)
 	
-	// Count products owned
-	allProducts, _ := s.GetAllProducts(ctx)
-	productCount := 0
-	for _, product := range allProducts {
-		if product.CurrentOwner == orgMSPID {
-			productCount++
-		}
+	// Read the counters maintained by the writes instead of scanning the ledger
+	counters, _, err := getOrganizationStats(ctx, orgMSPID)
+	if err != nil {
+		return nil, err
 	}
-	stats["totalProducts"] = productCount
-	
-	// Count batches owned
-	batches, _ := s.GetBatchesByOrganization(ctx, orgMSPID)
-	stats["totalBatches"] = len(batches)
-	
-	// Count pending transfers
-	pendingTransfers, _ := s.GetPendingTransfers(ctx, orgMSPID)
-	stats["pendingTransfers"] = len(pendingTransfers)
+	stats["totalProducts"] = counters.Products
+	stats["totalBatches"] = counters.Batches
+	stats["pendingTransfers"] = counters.PendingTransfers
+	stats["urgentPendingTransfers"] = counters.UrgentPendingTransfers
+	stats["demoUnits"] = counters.DemoUnits
+	stats["damagedUnits"] = counters.DamagedUnits
 	
 	// Count materials (if applicable)
 	if orgRole == RoleSupplier || orgRole == RoleManufacturer {
-		inventories, _ := s.GetAllMaterialInventories(ctx)
-		materialCount := 0
-		totalAvailable := 0.0
-		for _, inv := range inventories {
-			if inv.Owner == orgMSPID {
-				materialCount++
-				totalAvailable += inv.Available
-			}
-		}
-		stats["totalMaterials"] = materialCount
-		stats["availableMaterialQuantity"] = totalAvailable
+		stats["totalMaterials"] = counters.Materials
+		stats["availableMaterialQuantity"] = counters.AvailableMaterialQuantity
 	}
 	
 	// Add timestamp
@@ -0,0 +1,89 @@
+package contracts
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateBatchChecksAllMaterialsBeforeWriting(t *testing.T) {
+	const manufacturer = "CraftWorkshopMSP"
+	inventory := func(materialID string, available float64, expiryDate string) MaterialInventory {
+		return MaterialInventory{
+			ID:            materialID + "_" + manufacturer,
+			MaterialID:    materialID,
+			Batch:         "LOT-" + materialID,
+			Owner:         manufacturer,
+			Supplier:      "ItalianLeatherMSP",
+			Type:          "leather",
+			TotalReceived: available,
+			Available:     available,
+			Transfers:     []MaterialTransferRecord{},
+			ExpiryDate:    expiryDate,
+			SchemaVersion: CurrentSchemaVersion,
+		}
+	}
+
+	tests := []struct {
+		name      string
+		materials string
+		wantErr   ErrorCode
+	}{
+		{"first material insufficient", `[{"id":"L1","quantity":500},{"id":"L2","quantity":5},{"id":"L3","quantity":5}]`, ErrInsufficientInventory},
+		{"middle material insufficient", `[{"id":"L1","quantity":5},{"id":"L2","quantity":500},{"id":"L3","quantity":5}]`, ErrInsufficientInventory},
+		{"last material insufficient", `[{"id":"L1","quantity":5},{"id":"L2","quantity":5},{"id":"L3","quantity":500}]`, ErrInsufficientInventory},
+		{"first material missing", `[{"id":"L9","quantity":5},{"id":"L2","quantity":5},{"id":"L3","quantity":5}]`, ErrInsufficientInventory},
+		{"middle material missing", `[{"id":"L1","quantity":5},{"id":"L9","quantity":5},{"id":"L3","quantity":5}]`, ErrInsufficientInventory},
+		{"last material missing", `[{"id":"L1","quantity":5},{"id":"L2","quantity":5},{"id":"L9","quantity":5}]`, ErrInsufficientInventory},
+		{"last material expired", `[{"id":"L1","quantity":5},{"id":"L2","quantity":5},{"id":"LX","quantity":5}]`, ErrInvalidState},
+		{"material listed twice exceeds stock", `[{"id":"L1","quantity":60},{"id":"L2","quantity":5},{"id":"L1","quantity":60}]`, ErrInsufficientInventory},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ledger := newTestLedger(t)
+			for _, material := range []MaterialInventory{
+				inventory("L1", 100, ""),
+				inventory("L2", 100, ""),
+				inventory("L3", 100, ""),
+				inventory("LX", 100, "2000-01-01T00:00:00Z"),
+			} {
+				ledger.put(fmt.Sprintf("material_inventory_%s_%s", material.MaterialID, manufacturer), material)
+			}
+			before := map[string]string{}
+			for _, key := range ledger.keys("material_inventory_", "material_inventory_~") {
+				before[key] = string(ledger.stub.State[key])
+			}
+
+			batchID := fmt.Sprintf("BATCH-%d", i)
+			err := (&SupplyChainContract{}).CreateBatch(ledger.as(manufacturer),
+				batchID, "LuxeBags", "Handbag", 2, tt.materials, "")
+			require.True(t, hasErrorCode(err, tt.wantErr), "got %v", err)
+
+			after := map[string]string{}
+			for _, key := range ledger.keys("material_inventory_", "material_inventory_~") {
+				after[key] = string(ledger.stub.State[key])
+			}
+			require.Equal(t, before, after, "a failed batch must not write any inventory")
+			require.Empty(t, ledger.keys("batch_", "batch_~"))
+			require.Empty(t, ledger.keys(productKey(batchID), productKey(batchID)+"~"))
+		})
+	}
+}
+
+func TestCreateBatchConsumesMaterials(t *testing.T) {
+	ledger := newTestLedger(t)
+	ledger.put("material_inventory_L1_CraftWorkshopMSP", MaterialInventory{
+		ID: "L1_CraftWorkshopMSP", MaterialID: "L1", Owner: "CraftWorkshopMSP", Supplier: "ItalianLeatherMSP",
+		Type: "leather", TotalReceived: 100, Available: 100, Transfers: []MaterialTransferRecord{},
+	})
+
+	err := (&SupplyChainContract{}).CreateBatch(ledger.as("CraftWorkshopMSP"),
+		"BATCH-1", "LuxeBags", "Handbag", 2, `[{"id":"L1","quantity":30},{"id":"L1","quantity":20}]`, "")
+	require.NoError(t, err)
+
+	var inventory MaterialInventory
+	ledger.get("material_inventory_L1_CraftWorkshopMSP", &inventory)
+	require.Equal(t, 50.0, inventory.Available)
+	require.Equal(t, 50.0, inventory.Used)
+}
@@ -0,0 +1,385 @@
+package contracts
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// tradeDocumentRulesKey holds the TradeDocumentRules
+const tradeDocumentRulesKey = "config_trade_documents"
+
+// Trade documents a cross-border transfer can carry
+const (
+	TradeDocCommercialInvoice    = "COMMERCIAL_INVOICE"
+	TradeDocCertificateOfOrigin  = "CERTIFICATE_OF_ORIGIN"
+	TradeDocCITESPermit          = "CITES_PERMIT" // Re-export permit for goods made of CITES-listed species
+	TradeDocPackingList          = "PACKING_LIST"
+	TradeDocExportDeclaration    = "EXPORT_DECLARATION"
+	maxTradeDocumentsPerTransfer = 20
+)
+
+var tradeDocumentTypes = []string{TradeDocCommercialInvoice, TradeDocCertificateOfOrigin, TradeDocCITESPermit,
+	TradeDocPackingList, TradeDocExportDeclaration}
+
+// corridorPattern matches a corridor between two ISO 3166-1 alpha-2 countries, e.g. IT-CH
+var corridorPattern = regexp.MustCompile(`^[A-Z]{2}-[A-Z]{2}$`)
+
+// TradeDocumentRules place organizations in countries and list the documents a transfer
+// between two countries needs before it can be received
+type TradeDocumentRules struct {
+	OrganizationCountries map[string]string   `json:"organizationCountries"` // MSP ID to ISO 3166-1 alpha-2 code
+	Corridors             map[string][]string `json:"corridors"`             // e.g. IT-CH to its required document types
+	DefaultRequired       []string            `json:"defaultRequired"`       // For international corridors not listed
+	UpdatedBy             string              `json:"updatedBy,omitempty" metadata:",optional"`
+	UpdatedAt             string              `json:"updatedAt,omitempty" metadata:",optional"`
+	SchemaVersion         int                 `json:"schemaVersion"`
+}
+
+// TradeDocument is a customs or trade-compliance document attached to a transfer. Only
+// its hash is on the ledger.
+type TradeDocument struct {
+	DocType    string `json:"docType"`
+	DocHash    string `json:"docHash"` // SHA256 of the document
+	Issuer     string `json:"issuer"`  // e.g. the chamber of commerce or CITES management authority
+	AttachedBy string `json:"attachedBy"`
+	AttachedAt string `json:"attachedAt"`
+}
+
+// TradeDocumentStatus lists the documents a transfer needs and those still missing
+type TradeDocumentStatus struct {
+	TransferID    string          `json:"transferId"`
+	Corridor      string          `json:"corridor,omitempty" metadata:",optional"` // Empty for domestic transfers and organizations without a country
+	International bool            `json:"international"`
+	Required      []string        `json:"required"`
+	Attached      []TradeDocument `json:"attached"`
+	Missing       []string        `json:"missing"`
+}
+
+// defaultTradeDocumentRules apply until a super admin sets the rules. No organization
+// has a country yet, so no transfer is international.
+func defaultTradeDocumentRules() *TradeDocumentRules {
+	return &TradeDocumentRules{
+		OrganizationCountries: map[string]string{},
+		Corridors:             map[string][]string{},
+		DefaultRequired:       []string{TradeDocCommercialInvoice, TradeDocCertificateOfOrigin},
+		SchemaVersion:         CurrentSchemaVersion,
+	}
+}
+
+// getTradeDocumentRules reads the stored TradeDocumentRules, falling back to the defaults
+func getTradeDocumentRules(ctx contractapi.TransactionContextInterface) (*TradeDocumentRules, error) {
+	rulesJSON, err := ctx.GetStub().GetState(tradeDocumentRulesKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read trade document rules: %v", err)
+	}
+	if rulesJSON == nil {
+		return defaultTradeDocumentRules(), nil
+	}
+
+	var rules TradeDocumentRules
+	err = json.Unmarshal(rulesJSON, &rules)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse trade document rules: %v", err)
+	}
+	rules.upgradeSchema()
+
+	return &rules, nil
+}
+
+// corridor returns the corridor of a transfer, or "" unless both organizations have a
+// country and the countries differ
+func (r *TradeDocumentRules) corridor(transfer *Transfer) string {
+	from := r.OrganizationCountries[transfer.From]
+	to := r.OrganizationCountries[transfer.To]
+	if from == "" || to == "" || from == to {
+		return ""
+	}
+	return from + "-" + to
+}
+
+// transferMaterialTypes returns the material types of the goods of a transfer
+func (s *SupplyChainContract) transferMaterialTypes(ctx contractapi.TransactionContextInterface,
+	transfer *Transfer) ([]string, error) {
+
+	types := []string{}
+	if batchType, _ := transfer.Metadata["type"].(string); batchType == "BATCH" {
+		batch, err := s.GetBatch(ctx, transfer.ProductID)
+		if err != nil {
+			return nil, err
+		}
+		for _, usage := range batch.MaterialsUsed {
+			types = append(types, usage.MaterialType)
+		}
+		return types, nil
+	}
+	product, err := s.GetProduct(ctx, transfer.ProductID)
+	if err != nil {
+		return nil, err
+	}
+	for _, material := range product.Materials {
+		types = append(types, material.Type)
+	}
+	return types, nil
+}
+
+// tradeDocumentStatus works out the documents a transfer needs: those of its corridor,
+// and a CITES permit when its goods are made of a material regulated under CITES
+func (s *SupplyChainContract) tradeDocumentStatus(ctx contractapi.TransactionContextInterface,
+	transfer *Transfer) (*TradeDocumentStatus, error) {
+
+	status := &TradeDocumentStatus{
+		TransferID: transfer.ID,
+		Required:   []string{},
+		Attached:   transfer.TradeDocuments,
+		Missing:    []string{},
+	}
+	if status.Attached == nil {
+		status.Attached = []TradeDocument{}
+	}
+
+	rules, err := getTradeDocumentRules(ctx)
+	if err != nil {
+		return nil, err
+	}
+	status.Corridor = rules.corridor(transfer)
+	if status.Corridor == "" {
+		return status, nil
+	}
+	status.International = true
+
+	required, listed := rules.Corridors[status.Corridor]
+	if !listed {
+		required = rules.DefaultRequired
+	}
+	needed := make(map[string]bool)
+	for _, docType := range required {
+		if !needed[docType] {
+			needed[docType] = true
+			status.Required = append(status.Required, docType)
+		}
+	}
+
+	regulated, err := getRegulatedMaterials(ctx)
+	if err != nil {
+		return nil, err
+	}
+	materialTypes, err := s.transferMaterialTypes(ctx, transfer)
+	if err != nil {
+		return nil, err
+	}
+	for _, materialType := range materialTypes {
+		if regulated.requiredScheme(materialType) == CertificationCITES && !needed[TradeDocCITESPermit] {
+			needed[TradeDocCITESPermit] = true
+			status.Required = append(status.Required, TradeDocCITESPermit)
+		}
+	}
+
+	attached := make(map[string]bool)
+	for _, document := range status.Attached {
+		attached[document.DocType] = true
+	}
+	for _, docType := range status.Required {
+		if !attached[docType] {
+			status.Missing = append(status.Missing, docType)
+		}
+	}
+	return status, nil
+}
+
+// checkTradeDocuments rejects the receipt of an international transfer while any of its
+// required trade documents is missing
+func (s *SupplyChainContract) checkTradeDocuments(ctx contractapi.TransactionContextInterface, transfer *Transfer) error {
+	status, err := s.tradeDocumentStatus(ctx, transfer)
+	if err != nil {
+		return err
+	}
+	if len(status.Missing) > 0 {
+		return newError(ErrInvalidState, "transfer %s on corridor %s is missing trade documents: %s",
+			transfer.ID, status.Corridor, strings.Join(status.Missing, ", "))
+	}
+	return nil
+}
+
+// AttachTradeDocument attaches the hash of a customs or trade document to a transfer:
+// COMMERCIAL_INVOICE, CERTIFICATE_OF_ORIGIN, CITES_PERMIT, PACKING_LIST or
+// EXPORT_DECLARATION. Attaching a type again replaces the earlier document. The sender,
+// the receiver and customs or logistics organizations may attach documents until the
+// transfer is received.
+func (s *SupplyChainContract) AttachTradeDocument(ctx contractapi.TransactionContextInterface,
+	transferID string, docType string, docHash string, issuer string) error {
+
+	if err := validateAll(
+		validateID("transferID", transferID),
+		validateEnum("docType", docType, tradeDocumentTypes...),
+		validateID("docHash", docHash),
+		validateName("issuer", issuer),
+	); err != nil {
+		return err
+	}
+
+	transfer, err := s.GetTransfer(ctx, transferID)
+	if err != nil {
+		return err
+	}
+
+	caller, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to get caller identity: %v", err)
+	}
+	if caller != transfer.From && caller != transfer.To {
+		roleContract := &RoleManagementContract{}
+		hasPermission, err := roleContract.CheckPermission(ctx, caller, "RECORD_DUTY")
+		if err != nil || !hasPermission {
+			return newError(ErrPermissionDenied, "caller %s does not have permission to attach trade documents", caller)
+		}
+	}
+	if transfer.Status != TransferStatusInitiated && transfer.Status != TransferStatusPending {
+		return newError(ErrInvalidState, "trade documents cannot be attached to a %s transfer", transfer.Status)
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	document := TradeDocument{
+		DocType:    docType,
+		DocHash:    docHash,
+		Issuer:     issuer,
+		AttachedBy: caller,
+		AttachedAt: now.UTC().Format(time.RFC3339),
+	}
+	replaced := false
+	for i := range transfer.TradeDocuments {
+		if transfer.TradeDocuments[i].DocType == docType {
+			transfer.TradeDocuments[i] = document
+			replaced = true
+		}
+	}
+	if !replaced {
+		if len(transfer.TradeDocuments) >= maxTradeDocumentsPerTransfer {
+			return newError(ErrInvalidState, "transfer %s already has %d trade documents", transferID, maxTradeDocumentsPerTransfer)
+		}
+		transfer.TradeDocuments = append(transfer.TradeDocuments, document)
+	}
+
+	err = putTransfer(ctx, transfer)
+	if err != nil {
+		return err
+	}
+
+	event := transferEvent(EventTradeDocumentAttached, transfer, "")
+	event.Attributes["docType"] = docType
+	event.Attributes["docHash"] = docHash
+	event.Attributes["issuer"] = issuer
+	event.Attributes["replaced"] = replaced
+	return emitEvent(ctx, event)
+}
+
+// GetTradeDocumentStatus returns the trade documents a transfer needs under the current
+// rules, those attached and those still missing
+func (s *SupplyChainContract) GetTradeDocumentStatus(ctx contractapi.TransactionContextInterface,
+	transferID string) (*TradeDocumentStatus, error) {
+
+	if err := validateID("transferID", transferID); err != nil {
+		return nil, err
+	}
+	transfer, err := s.GetTransfer(ctx, transferID)
+	if err != nil {
+		return nil, err
+	}
+	return s.tradeDocumentStatus(ctx, transfer)
+}
+
+// SetTradeDocumentRules replaces the trade document rules, e.g.
+// {"organizationCountries":{"ItalianLeatherMSP":"IT","LuxuryRetailMSP":"CH"},
+// "corridors":{"IT-CH":["COMMERCIAL_INVOICE","CERTIFICATE_OF_ORIGIN"]},
+// "defaultRequired":["COMMERCIAL_INVOICE"]}. The rules apply to every transfer received
+// afterwards, including those already in progress.
+func (a *AdminContract) SetTradeDocumentRules(ctx contractapi.TransactionContextInterface,
+	rulesJSON string) error {
+
+	var rules TradeDocumentRules
+	if err := validateJSON("rulesJSON", rulesJSON, &rules); err != nil {
+		return err
+	}
+	for organization, country := range rules.OrganizationCountries {
+		if err := validateID("organization", organization); err != nil {
+			return err
+		}
+		if !regionCodePattern.MatchString(country) {
+			return newError(ErrInvalidArgument, "country %q of %s is not an ISO 3166-1 alpha-2 code", country, organization)
+		}
+	}
+	for corridor, docTypes := range rules.Corridors {
+		if !corridorPattern.MatchString(corridor) {
+			return newError(ErrInvalidArgument, "corridor %q must be two ISO 3166-1 alpha-2 codes, e.g. IT-CH", corridor)
+		}
+		for _, docType := range docTypes {
+			if err := validateEnum("docType", docType, tradeDocumentTypes...); err != nil {
+				return err
+			}
+		}
+	}
+	for _, docType := range rules.DefaultRequired {
+		if err := validateEnum("docType", docType, tradeDocumentTypes...); err != nil {
+			return err
+		}
+	}
+
+	caller, err := requireSuperAdmin(ctx)
+	if err != nil {
+		return err
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	if rules.OrganizationCountries == nil {
+		rules.OrganizationCountries = map[string]string{}
+	}
+	if rules.Corridors == nil {
+		rules.Corridors = map[string][]string{}
+	}
+	if rules.DefaultRequired == nil {
+		rules.DefaultRequired = []string{}
+	}
+	rules.UpdatedBy = caller
+	rules.UpdatedAt = now.UTC().Format(time.RFC3339)
+	rules.SchemaVersion = CurrentSchemaVersion
+	storedJSON, err := json.Marshal(rules)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState(tradeDocumentRulesKey, storedJSON)
+	if err != nil {
+		return fmt.Errorf("failed to store trade document rules: %v", err)
+	}
+
+	corridors := make([]string, 0, len(rules.Corridors))
+	for corridor := range rules.Corridors {
+		corridors = append(corridors, corridor)
+	}
+	sort.Strings(corridors)
+
+	return emitEvent(ctx, ChaincodeEvent{
+		EventType:  EventTradeDocumentRulesUpdated,
+		EntityType: EventEntityConfig,
+		EntityID:   tradeDocumentRulesKey,
+		Attributes: map[string]interface{}{
+			"organizations": len(rules.OrganizationCountries),
+			"corridors":     strings.Join(corridors, ","),
+		},
+	})
+}
+
+// GetTradeDocumentRules returns the trade document rules in effect
+func (a *AdminContract) GetTradeDocumentRules(ctx contractapi.TransactionContextInterface) (*TradeDocumentRules, error) {
+	return getTradeDocumentRules(ctx)
+}
@@ -0,0 +1,256 @@
+package contracts
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// materialTransferCancelled is the status of a cancelled or rejected material transfer record
+const materialTransferCancelled = "CANCELLED"
+
+// CancelTransfer lets the sender withdraw a transfer before confirming it as sent.
+// Material transfers return their quantity to the sender's available stock.
+func (s *SupplyChainContract) CancelTransfer(ctx contractapi.TransactionContextInterface,
+	transferID string, reason string) error {
+
+	if err := validateAll(
+		validateID("transferID", transferID),
+		validateText("reason", reason, maxTextLength),
+	); err != nil {
+		return err
+	}
+
+	return s.closeTransfer(ctx, transferID, reason, false)
+}
+
+// RejectTransfer lets the receiver decline an incoming transfer it has not confirmed as
+// received, whether or not it was sent. Material transfers return their quantity to the
+// sender's available stock.
+func (s *SupplyChainContract) RejectTransfer(ctx contractapi.TransactionContextInterface,
+	transferID string, reason string) error {
+
+	if err := validateAll(
+		validateID("transferID", transferID),
+		validateRequired("reason", reason, maxTextLength),
+	); err != nil {
+		return err
+	}
+
+	return s.closeTransfer(ctx, transferID, reason, true)
+}
+
+// closeTransfer cancels a product, batch or material transfer on behalf of its sender,
+// or of its receiver when rejected is set, and passes the cancellation to consensus
+func (s *SupplyChainContract) closeTransfer(ctx contractapi.TransactionContextInterface,
+	transferID string, reason string, rejected bool) error {
+
+	caller, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to get caller identity: %v", err)
+	}
+
+	transfer, err := s.GetTransfer(ctx, transferID)
+	if hasErrorCode(err, ErrNotFound) {
+		return s.closeMaterialTransfer(ctx, caller, transferID, reason, rejected)
+	}
+	if err != nil {
+		return err
+	}
+
+	if rejected {
+		if transfer.To != caller {
+			return newError(ErrPermissionDenied, "only the receiver can reject a transfer")
+		}
+	} else {
+		if transfer.From != caller {
+			return newError(ErrPermissionDenied, "only the sender can cancel a transfer")
+		}
+		if transfer.Status == TransferStatusPending {
+			return newError(ErrInvalidState, "transfer %s was already sent and can only be rejected by the receiver", transferID)
+		}
+	}
+
+	previousStatus := transfer.Status
+	if err := setTransferStatus(transfer, TransferStatusCancelled); err != nil {
+		return err
+	}
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	transfer.CancelledBy = caller
+	transfer.CancelledAt = now.UTC().Format(time.RFC3339)
+	transfer.CancellationReason = reason
+
+	// A scheduled transfer that was not activated yet also leaves the schedule
+	if transfer.EffectiveAt != "" && transfer.ActivatedAt == "" {
+		err = ctx.GetStub().DelState(scheduledTransferKey(transfer.To, transfer.EffectiveAt, transferID))
+		if err != nil {
+			return fmt.Errorf("failed to update scheduled transfer index: %v", err)
+		}
+	}
+
+	err = putTransfer(ctx, transfer)
+	if err != nil {
+		return err
+	}
+	if err := s.returnToSender(ctx, transfer); err != nil {
+		return err
+	}
+
+	// Transfers initiated without consensus have no consensus transaction to cancel
+	consensus, err := LoadConsensusIntegration(ctx)
+	if err != nil {
+		return err
+	}
+	err = consensus.NotifyConsensusOfCancellation(ctx, transferID, caller, reason, rejected)
+	if err != nil && !hasErrorCode(err, ErrNotFound) {
+		return err
+	}
+
+	eventType := EventTransferCancelled
+	if rejected {
+		eventType = EventTransferRejected
+	}
+	event := transferEvent(eventType, transfer, previousStatus)
+	if reason != "" {
+		event.Attributes["reason"] = reason
+	}
+	return emitEvent(ctx, event)
+}
+
+// returnToSender gives the batch or product of a closed transfer back the status it had
+// with the sender, if it is still in transit and owned by the sender
+func (s *SupplyChainContract) returnToSender(ctx contractapi.TransactionContextInterface,
+	transfer *Transfer) error {
+
+	roleContract := &RoleManagementContract{}
+	senderRole, err := roleContract.GetOrganizationRole(ctx, transfer.From)
+	if err != nil {
+		return wrapError(err, "failed to get sender role")
+	}
+
+	if batchType, _ := transfer.Metadata["type"].(string); batchType == "BATCH" {
+		batch, err := s.GetBatch(ctx, transfer.ProductID)
+		if err != nil {
+			return wrapError(err, "failed to get batch")
+		}
+		if batch.CurrentOwner != transfer.From || batch.Status != BatchStatusInTransit {
+			return nil
+		}
+		if err := setBatchStatus(batch, receivedBatchStatus(senderRole)); err != nil {
+			return err
+		}
+		return putBatch(ctx, batch)
+	}
+
+	product, err := s.GetProduct(ctx, transfer.ProductID)
+	if err != nil {
+		return err
+	}
+	if product.CurrentOwner != transfer.From || product.Status != ProductStatusInTransit {
+		return nil
+	}
+	if err := setProductStatus(product, receivedProductStatus(senderRole)); err != nil {
+		return err
+	}
+	return putProduct(ctx, product)
+}
+
+// closeMaterialTransfer cancels a pending material transfer found in the caller's
+// inventories and returns its quantity to the sender's available stock. Material
+// transfers are always submitted to consensus, so the cancellation must reach it.
+func (s *SupplyChainContract) closeMaterialTransfer(ctx contractapi.TransactionContextInterface,
+	caller string, transferID string, reason string, rejected bool) error {
+
+	materialID, err := materialOfTransfer(ctx, caller, transferID)
+	if err != nil {
+		return err
+	}
+	inventory, i, err := findMaterialTransfer(ctx, materialID, caller, transferID)
+	if err != nil {
+		return err
+	}
+	record := inventory.Transfers[i]
+	if rejected && record.To != caller {
+		return newError(ErrPermissionDenied, "only the receiver can reject a transfer")
+	}
+	if !rejected && record.From != caller {
+		return newError(ErrPermissionDenied, "only the sender can cancel a transfer")
+	}
+	if record.Verified || (record.Status != "" && record.Status != "PENDING") {
+		return newError(ErrInvalidState, "material transfer %s cannot be cancelled in status %s", transferID, record.Status)
+	}
+
+	// Both parties' inventories list the transfer; the sender's also gets the stock back
+	for _, owner := range []string{record.To, record.From} {
+		inventory, i, err := findMaterialTransfer(ctx, materialID, owner, transferID)
+		if hasErrorCode(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		inventory.Transfers[i].Status = materialTransferCancelled
+		if owner == record.From {
+			inventory.Available += record.Quantity
+		}
+		inventoryKey := fmt.Sprintf("material_inventory_%s_%s", materialID, owner)
+		if err := putMaterialInventory(ctx, inventoryKey, inventory); err != nil {
+			return err
+		}
+	}
+
+	consensus, err := LoadConsensusIntegration(ctx)
+	if err != nil {
+		return err
+	}
+	err = consensus.NotifyConsensusOfCancellation(ctx, transferID, caller, reason, rejected)
+	if err != nil {
+		return err
+	}
+
+	eventType := EventTransferCancelled
+	if rejected {
+		eventType = EventTransferRejected
+	}
+	attributes := map[string]interface{}{
+		"transferId": transferID,
+		"from":       record.From,
+		"to":         record.To,
+		"quantity":   record.Quantity,
+	}
+	if reason != "" {
+		attributes["reason"] = reason
+	}
+	return emitEvent(ctx, ChaincodeEvent{
+		EventType:  eventType,
+		EntityType: EventEntityMaterial,
+		EntityID:   materialID,
+		ToState:    materialTransferCancelled,
+		Attributes: attributes,
+	})
+}
+
+// materialOfTransfer returns the material of a transfer listed in an organization's
+// inventories, which are read from the owner index
+func materialOfTransfer(ctx contractapi.TransactionContextInterface, owner string,
+	transferID string) (string, error) {
+
+	materialIDs, err := getIndexedIDs(ctx, inventoryOwnerIndex, owner)
+	if err != nil {
+		return "", err
+	}
+	for _, materialID := range materialIDs {
+		_, _, err := findMaterialTransfer(ctx, materialID, owner, transferID)
+		if err == nil {
+			return materialID, nil
+		}
+		if !hasErrorCode(err, ErrNotFound) {
+			return "", err
+		}
+	}
+	return "", newError(ErrNotFound, "transfer %s does not exist", transferID)
+}
@@ -0,0 +1,79 @@
+package contracts
+
+import (
+	"testing"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+	"github.com/stretchr/testify/require"
+)
+
+// acceptingChaincode stands in for the consensus chaincode and accepts every call
+type acceptingChaincode struct{}
+
+func (acceptingChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
+	return shim.Success(nil)
+}
+func (acceptingChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
+	return shim.Success(nil)
+}
+
+func TestClosedTransferReturnsItemToSender(t *testing.T) {
+	tests := []struct {
+		name     string
+		rejected bool
+	}{
+		{"cancelled by the sender", false},
+		{"rejected by the receiver", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ledger := newTestLedger(t)
+			consensus := shimtest.NewMockStub(DefaultConsensusChaincodeName, acceptingChaincode{})
+			ledger.stub.MockPeerChaincode(DefaultConsensusChaincodeName, consensus, DefaultConsensusChannelName)
+
+			ledger.put("batch_B1", ProductBatch{
+				ID: "B1", Manufacturer: "CraftWorkshopMSP", Brand: "LuxeBags", ProductType: "Handbag",
+				Quantity: 1, ProductIDs: []string{}, MaterialsUsed: []MaterialUsage{},
+				CurrentOwner: "CraftWorkshopMSP", Status: BatchStatusInTransit, Metadata: map[string]string{},
+				SchemaVersion: CurrentSchemaVersion,
+			})
+			ledger.put(productKey("P1"), Product{
+				ID: "P1", Brand: "LuxeBags", Type: "Handbag", CurrentOwner: "CraftWorkshopMSP",
+				Status: ProductStatusInTransit, Materials: []Material{}, SchemaVersion: CurrentSchemaVersion,
+			})
+			for _, transfer := range []Transfer{
+				{ID: "T1", ProductID: "B1", Metadata: map[string]interface{}{"type": "BATCH"}},
+				{ID: "T2", ProductID: "P1", Metadata: map[string]interface{}{}},
+			} {
+				transfer.From = "CraftWorkshopMSP"
+				transfer.To = "LuxuryRetailMSP"
+				transfer.TransferType = TransferTypeSupplyChain
+				transfer.Status = TransferStatusInitiated
+				ledger.put("transfer_"+transfer.ID, transfer)
+			}
+
+			contract := &SupplyChainContract{}
+			for _, transferID := range []string{"T1", "T2"} {
+				if tt.rejected {
+					require.NoError(t, contract.RejectTransfer(ledger.as("LuxuryRetailMSP"), transferID, "wrong order"))
+				} else {
+					require.NoError(t, contract.CancelTransfer(ledger.as("CraftWorkshopMSP"), transferID, ""))
+				}
+			}
+
+			var transfer Transfer
+			ledger.get("transfer_T1", &transfer)
+			require.Equal(t, TransferStatusCancelled, transfer.Status)
+			var batch ProductBatch
+			ledger.get("batch_B1", &batch)
+			require.Equal(t, "CraftWorkshopMSP", batch.CurrentOwner)
+			require.Equal(t, BatchStatusCreated, batch.Status)
+			var product Product
+			ledger.get(productKey("P1"), &product)
+			require.Equal(t, "CraftWorkshopMSP", product.CurrentOwner)
+			require.Equal(t, ProductStatusInProduction, product.Status)
+		})
+	}
+}
@@ -0,0 +1,188 @@
+package contracts
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// transferFlowRulesKey holds the TransferFlowRules
+const transferFlowRulesKey = "config_transfer_flows"
+
+// Item types of the transfer flow rules
+const (
+	FlowItemProduct  = "PRODUCT"  // InitiateTransfer
+	FlowItemBatch    = "BATCH"    // TransferBatch
+	FlowItemMaterial = "MATERIAL" // TransferMaterialInventory
+)
+
+// TransferFlowRules lists, per item type, the roles each sender role may transfer to.
+// A sender role without an entry cannot transfer that item type at all.
+type TransferFlowRules struct {
+	Flows         map[string]map[string][]string `json:"flows"` // Item type to sender role to receiver roles
+	UpdatedBy     string                         `json:"updatedBy,omitempty" metadata:",optional"`
+	UpdatedAt     string                         `json:"updatedAt,omitempty" metadata:",optional"`
+	SchemaVersion int                            `json:"schemaVersion"`
+}
+
+// flowRoles are the roles that can appear in transfer flow rules
+var flowRoles = []string{
+	string(RoleSuperAdmin), string(RoleSupplier), string(RoleManufacturer), string(RoleWarehouse),
+	string(RoleRetailer), string(RoleCustoms), string(RoleLogistics), string(RoleCharity), string(RoleCarrier),
+}
+
+// defaultTransferFlowRules applies until a super admin sets the rules. Materials go
+// from suppliers to workshops, finished goods from workshops through the brand and
+// warehouses to retail, and returns go back up that chain but never to a supplier.
+func defaultTransferFlowRules() *TransferFlowRules {
+	return &TransferFlowRules{
+		Flows: map[string]map[string][]string{
+			FlowItemProduct: {
+				string(RoleManufacturer): {string(RoleSuperAdmin), string(RoleWarehouse), string(RoleRetailer)},
+				string(RoleSuperAdmin):   {string(RoleSuperAdmin), string(RoleManufacturer), string(RoleWarehouse), string(RoleRetailer), string(RoleCharity)},
+				string(RoleWarehouse):    {string(RoleSuperAdmin), string(RoleManufacturer), string(RoleWarehouse), string(RoleRetailer)},
+				string(RoleRetailer):     {string(RoleSuperAdmin), string(RoleManufacturer), string(RoleWarehouse), string(RoleRetailer)},
+			},
+			FlowItemBatch: {
+				string(RoleManufacturer): {string(RoleSuperAdmin), string(RoleWarehouse), string(RoleRetailer)},
+				string(RoleSuperAdmin):   {string(RoleSuperAdmin), string(RoleManufacturer), string(RoleWarehouse), string(RoleRetailer)},
+				string(RoleWarehouse):    {string(RoleSuperAdmin), string(RoleWarehouse), string(RoleRetailer)},
+				string(RoleRetailer):     {string(RoleSuperAdmin), string(RoleWarehouse)},
+			},
+			FlowItemMaterial: {
+				string(RoleSupplier):     {string(RoleSuperAdmin), string(RoleManufacturer)},
+				string(RoleManufacturer): {string(RoleSuperAdmin), string(RoleSupplier), string(RoleManufacturer)},
+				string(RoleSuperAdmin):   {string(RoleSupplier), string(RoleManufacturer)},
+			},
+		},
+		SchemaVersion: CurrentSchemaVersion,
+	}
+}
+
+// getTransferFlowRules reads the stored TransferFlowRules, falling back to the defaults
+func getTransferFlowRules(ctx contractapi.TransactionContextInterface) (*TransferFlowRules, error) {
+	rulesJSON, err := ctx.GetStub().GetState(transferFlowRulesKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read transfer flow rules: %v", err)
+	}
+	if rulesJSON == nil {
+		return defaultTransferFlowRules(), nil
+	}
+
+	var rules TransferFlowRules
+	err = json.Unmarshal(rulesJSON, &rules)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse transfer flow rules: %v", err)
+	}
+	rules.upgradeSchema()
+
+	return &rules, nil
+}
+
+// allows reports whether the rules let fromRole transfer itemType to toRole
+func (r *TransferFlowRules) allows(itemType string, fromRole OrganizationRole, toRole OrganizationRole) bool {
+	for _, role := range r.Flows[itemType][string(fromRole)] {
+		if role == string(toRole) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkTransferFlow rejects a transfer of itemType from one organization to another
+// unless the transfer flow rules allow it between their roles
+func checkTransferFlow(ctx contractapi.TransactionContextInterface, itemType string, from string, to string) error {
+	roleContract := &RoleManagementContract{}
+	fromRole, err := roleContract.GetOrganizationRole(ctx, from)
+	if err != nil {
+		return newError(ErrPermissionDenied, "%s is not a registered organization", from)
+	}
+	toRole, err := roleContract.GetOrganizationRole(ctx, to)
+	if err != nil {
+		return newError(ErrInvalidArgument, "%s is not a registered organization", to)
+	}
+
+	rules, err := getTransferFlowRules(ctx)
+	if err != nil {
+		return err
+	}
+	if !rules.allows(itemType, fromRole, toRole) {
+		return newError(ErrPermissionDenied, "a %s cannot transfer a %s to a %s", fromRole, strings.ToLower(itemType), toRole)
+	}
+	return nil
+}
+
+// SetTransferFlowRules replaces the transfer flow rules, e.g.
+// {"MATERIAL":{"SUPPLIER":["MANUFACTURER"]},"BATCH":{...},"PRODUCT":{...}}.
+// Item types left out cannot be transferred at all.
+func (a *AdminContract) SetTransferFlowRules(ctx contractapi.TransactionContextInterface,
+	flowsJSON string) error {
+
+	var flows map[string]map[string][]string
+	if err := validateJSON("flowsJSON", flowsJSON, &flows); err != nil {
+		return err
+	}
+	for itemType, senders := range flows {
+		if err := validateEnum("item type", itemType, FlowItemProduct, FlowItemBatch, FlowItemMaterial); err != nil {
+			return err
+		}
+		for fromRole, toRoles := range senders {
+			if err := validateEnum("sender role", fromRole, flowRoles...); err != nil {
+				return err
+			}
+			for _, toRole := range toRoles {
+				if err := validateEnum("receiver role", toRole, flowRoles...); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	caller, err := requireSuperAdmin(ctx)
+	if err != nil {
+		return err
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	rules := &TransferFlowRules{
+		Flows:         flows,
+		UpdatedBy:     caller,
+		UpdatedAt:     now.UTC().Format(time.RFC3339),
+		SchemaVersion: CurrentSchemaVersion,
+	}
+	rulesJSON, err := json.Marshal(rules)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState(transferFlowRulesKey, rulesJSON)
+	if err != nil {
+		return fmt.Errorf("failed to store transfer flow rules: %v", err)
+	}
+
+	itemTypes := make([]string, 0, len(flows))
+	for itemType := range flows {
+		itemTypes = append(itemTypes, itemType)
+	}
+	sort.Strings(itemTypes)
+
+	return emitEvent(ctx, ChaincodeEvent{
+		EventType:  EventTransferFlowRulesUpdated,
+		EntityType: EventEntityConfig,
+		EntityID:   transferFlowRulesKey,
+		Attributes: map[string]interface{}{
+			"itemTypes": strings.Join(itemTypes, ","),
+		},
+	})
+}
+
+// GetTransferFlowRules returns the transfer flow rules in effect
+func (a *AdminContract) GetTransferFlowRules(ctx contractapi.TransactionContextInterface) (*TransferFlowRules, error) {
+	return getTransferFlowRules(ctx)
+}
@@ -0,0 +1,112 @@
+package contracts
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Open transfers are indexed per organization as <prefix><org>_<transferID>, in the
+// pending list or, for returns created by dispute resolutions, the dispute-return list.
+// IDs cannot contain '_', so an organization's entries are exactly the range after
+// "<prefix><org>_".
+const (
+	pendingTransferKeyPrefix = "pending_"
+	disputeReturnKeyPrefix   = "dispute_return_"
+)
+
+// pendingTransferKey returns the index key of a transfer in an organization's pending list
+func pendingTransferKey(orgMSPID string, transferID string) string {
+	return pendingTransferKeyPrefix + orgMSPID + "_" + transferID
+}
+
+// disputeReturnKey returns the index key of a transfer in an organization's dispute-return list
+func disputeReturnKey(orgMSPID string, transferID string) string {
+	return disputeReturnKeyPrefix + orgMSPID + "_" + transferID
+}
+
+// isOpenTransfer reports whether a transfer still awaits a confirmation
+func isOpenTransfer(transfer *Transfer) bool {
+	return transfer.Status != TransferStatusCompleted && transfer.Status != TransferStatusCancelled &&
+		transfer.Status != TransferStatusTimedOut
+}
+
+// isDisputeReturn reports whether a transfer was created by CreateReturnTransferAfterDispute
+func isDisputeReturn(transfer *Transfer) bool {
+	resolutionType, _ := transfer.Metadata["resolutionType"].(string)
+	return resolutionType == "dispute_resolution"
+}
+
+// putTransfer writes a transfer to the ledger and keeps the indexes and pending counters
+// of both parties, and the status index, in sync with its status
+func putTransfer(ctx contractapi.TransactionContextInterface, transfer *Transfer) error {
+	transferJSON, err := json.Marshal(transfer)
+	if err != nil {
+		return err
+	}
+	if err := trackRecordStats(ctx, "transfer_"+transfer.ID, transfer, transferStats); err != nil {
+		return err
+	}
+	if err := trackCompositeIndex(ctx, "transfer_"+transfer.ID, transfer, transferIndexKeys); err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState("transfer_"+transfer.ID, transferJSON)
+	if err != nil {
+		return fmt.Errorf("failed to store transfer: %v", err)
+	}
+	return indexTransfer(ctx, transfer)
+}
+
+// indexTransfer adds an open transfer to its parties' pending or dispute-return list and
+// removes a closed one
+func indexTransfer(ctx contractapi.TransactionContextInterface, transfer *Transfer) error {
+	keyFor := pendingTransferKey
+	if isDisputeReturn(transfer) {
+		keyFor = disputeReturnKey
+	}
+	for _, org := range []string{transfer.From, transfer.To} {
+		if org == "" {
+			continue
+		}
+		key := keyFor(org, transfer.ID)
+		var err error
+		if isOpenTransfer(transfer) {
+			// Empty values are deletes in Fabric, so the entry holds the transfer ID
+			err = ctx.GetStub().PutState(key, []byte(transfer.ID))
+		} else {
+			err = ctx.GetStub().DelState(key)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to update transfer index: %v", err)
+		}
+	}
+	return nil
+}
+
+// getIndexedTransfers reads the transfers of an index range built by indexTransfer
+func getIndexedTransfers(ctx contractapi.TransactionContextInterface, prefix string) ([]*Transfer, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByRange(prefix, prefix+"~")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query transfer index: %v", err)
+	}
+	defer resultsIterator.Close()
+
+	supplyChain := &SupplyChainContract{}
+	var transfers []*Transfer
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		transfer, err := supplyChain.GetTransfer(ctx, string(queryResponse.Value))
+		if err != nil {
+			logFor(ctx).Warn("skipping unreadable indexed transfer", "key", queryResponse.Key, "error", err)
+			continue
+		}
+		transfers = append(transfers, transfer)
+	}
+
+	return transfers, nil
+}
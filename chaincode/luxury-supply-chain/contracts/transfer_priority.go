@@ -0,0 +1,128 @@
+package contracts
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Transfer priorities. Transfers without a priority are NORMAL.
+const (
+	TransferPriorityNormal = "NORMAL"
+	TransferPriorityUrgent = "URGENT" // e.g. launch-week replenishment or a recall return
+)
+
+// Default confirmation timeouts of a transfer, counted from its initiation, see
+// ConsensusPolicy
+const (
+	transferTimeout       = 24 * time.Hour
+	urgentTransferTimeout = 6 * time.Hour
+)
+
+// isUrgentTransfer reports whether a transfer was marked URGENT
+func isUrgentTransfer(transfer *Transfer) bool {
+	return transfer.Priority == TransferPriorityUrgent
+}
+
+// transferTimeoutAt returns when a transfer times out: by its priority, consensus policy
+// and sender's trust tier after its initiation, or after its effective date if it is
+// scheduled
+func transferTimeoutAt(ctx contractapi.TransactionContextInterface, transfer *Transfer) (string, error) {
+	start, err := time.Parse(time.RFC3339, transfer.InitiatedAt)
+	if err != nil {
+		return "", newError(ErrInvalidState, "transfer %s has an invalid initiation time", transfer.ID)
+	}
+	if transfer.EffectiveAt != "" {
+		start, err = time.Parse(time.RFC3339, transfer.EffectiveAt)
+		if err != nil {
+			return "", newError(ErrInvalidState, "transfer %s has an invalid effective date", transfer.ID)
+		}
+	}
+
+	policy, err := getConsensusPolicy(ctx, transfer.From, transfer.To, string(transfer.TransferType))
+	if err != nil {
+		return "", err
+	}
+	timeout := policy.transferTimeout(isUrgentTransfer(transfer))
+	if transfer.ConsensusDetails.SenderTier == TrustTierPlatinum {
+		timeout *= platinumTimeoutFactor
+	}
+	return start.Add(timeout).Format(time.RFC3339), nil
+}
+
+// SetTransferPriority marks a transfer URGENT or back to NORMAL before it is sent. An
+// urgent transfer times out after 6 hours instead of 24, and its events carry priority
+// URGENT so the receiver's dashboard can raise them.
+func (s *SupplyChainContract) SetTransferPriority(ctx contractapi.TransactionContextInterface,
+	transferID string, priority string) error {
+
+	_, err := s.setTransferPriority(ctx, transferID, priority)
+	return err
+}
+
+// setTransferPriority implements SetTransferPriority and returns the updated transfer,
+// since a transaction does not read its own writes
+func (s *SupplyChainContract) setTransferPriority(ctx contractapi.TransactionContextInterface,
+	transferID string, priority string) (*Transfer, error) {
+
+	if err := validateAll(
+		validateID("transferID", transferID),
+		validateEnum("priority", priority, TransferPriorityNormal, TransferPriorityUrgent),
+	); err != nil {
+		return nil, err
+	}
+
+	transfer, err := s.GetTransfer(ctx, transferID)
+	if err != nil {
+		return nil, err
+	}
+
+	sender, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get sender identity: %v", err)
+	}
+	if transfer.From != sender {
+		return nil, newError(ErrPermissionDenied, "only the sender can set the priority of a transfer")
+	}
+	if transfer.Status != TransferStatusInitiated {
+		return nil, newError(ErrInvalidState, "the priority must be set before the transfer is sent, status is %s", transfer.Status)
+	}
+
+	transfer.Priority = ""
+	if priority == TransferPriorityUrgent {
+		transfer.Priority = TransferPriorityUrgent
+	}
+	transfer.ConsensusDetails.TimeoutAt, err = transferTimeoutAt(ctx, transfer)
+	if err != nil {
+		return nil, err
+	}
+
+	err = putTransfer(ctx, transfer)
+	if err != nil {
+		return nil, err
+	}
+
+	event := transferEvent(EventTransferPriorityChanged, transfer, "")
+	event.Attributes["priority"] = priority
+	event.Attributes["timeoutAt"] = transfer.ConsensusDetails.TimeoutAt
+	return transfer, emitEvent(ctx, event)
+}
+
+// SetTransferPriorityWithConsensus sets a transfer's priority and shortens or restores
+// the timeout of the consensus transaction as well
+func (s *SupplyChainContract) SetTransferPriorityWithConsensus(ctx contractapi.TransactionContextInterface,
+	transferID string, priority string) error {
+
+	transfer, err := s.setTransferPriority(ctx, transferID, priority)
+	if err != nil {
+		return err
+	}
+
+	// Both records must agree, so a failed notification fails the transaction
+	consensus, err := LoadConsensusIntegration(ctx)
+	if err != nil {
+		return err
+	}
+	return consensus.NotifyConsensusOfPriority(ctx, transferID, transfer.From, priority)
+}
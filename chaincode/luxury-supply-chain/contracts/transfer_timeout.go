@@ -0,0 +1,120 @@
+package contracts
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// maxExpiredTransfers limits the transfers one ExpirePendingTransfers call times out
+const maxExpiredTransfers = 100
+
+// consensusStateTimeout is the state of a consensus transaction that timed out
+const consensusStateTimeout = "TIMEOUT"
+
+// ExpirePendingTransfers marks the initiated and sent transfers whose timeout passed at
+// asOfTime as TIMED_OUT, up to 100 per call, and returns their IDs. The goods stay with
+// the sender. Consensus is asked to validate each transfer, which applies its timeout
+// penalties, and a transfer with a consensus transaction only times out once consensus
+// has timed that out too. Any organization may run it, e.g. from a scheduler; asOfTime
+// cannot be later than the transaction time. Dispute returns do not time out.
+func (s *SupplyChainContract) ExpirePendingTransfers(ctx contractapi.TransactionContextInterface,
+	asOfTime string) ([]string, error) {
+
+	asOf, err := time.Parse(time.RFC3339, asOfTime)
+	if err != nil {
+		return nil, newError(ErrInvalidArgument, "asOfTime must be an RFC3339 time")
+	}
+	now, err := txTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if asOf.After(now) {
+		return nil, newError(ErrInvalidArgument, "asOfTime cannot be in the future")
+	}
+
+	consensus, err := LoadConsensusIntegration(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	expired := []string{}
+	for _, status := range []TransferStatus{TransferStatusInitiated, TransferStatusPending} {
+		transferIDs, err := getIndexedIDs(ctx, transferStatusIndex, string(status))
+		if err != nil {
+			return nil, err
+		}
+		for _, transferID := range transferIDs {
+			if len(expired) == maxExpiredTransfers {
+				break
+			}
+			transfer, err := s.GetTransfer(ctx, transferID)
+			if err != nil {
+				logFor(ctx).Warn("skipping unreadable indexed transfer", "transferId", transferID, "error", err)
+				continue
+			}
+			if isDisputeReturn(transfer) {
+				continue
+			}
+			timeoutAt, err := time.Parse(time.RFC3339, transfer.ConsensusDetails.TimeoutAt)
+			if err != nil {
+				logFor(ctx).Warn("skipping transfer with an invalid timeout", "transferId", transferID, "timeoutAt", transfer.ConsensusDetails.TimeoutAt)
+				continue
+			}
+			if timeoutAt.After(asOf) {
+				continue
+			}
+
+			// Transfers initiated without consensus have no consensus transaction to time out.
+			// The others time out only together with their consensus transaction.
+			consensusState, err := consensus.NotifyConsensusOfTimeout(ctx, transferID)
+			if err != nil && !hasErrorCode(err, ErrNotFound) {
+				return nil, err
+			}
+			if err == nil && consensusState != consensusStateTimeout {
+				logFor(ctx).Info("consensus has not timed out transfer", "transferId", transferID, "consensusState", consensusState)
+				continue
+			}
+
+			if err := s.expireTransfer(ctx, transfer); err != nil {
+				return nil, err
+			}
+			expired = append(expired, transferID)
+		}
+	}
+
+	if len(expired) == 0 {
+		return expired, nil
+	}
+	return expired, emitEvent(ctx, ChaincodeEvent{
+		EventType:  EventTransfersExpired,
+		EntityType: EventEntityTransfer,
+		ToState:    string(TransferStatusTimedOut),
+		Attributes: map[string]interface{}{
+			"transferIds": expired,
+			"asOfTime":    asOf.UTC().Format(time.RFC3339),
+		},
+	})
+}
+
+// expireTransfer times out an open transfer and returns a batch or product the sender
+// had already marked IN_TRANSIT to the status it has at the sender
+func (s *SupplyChainContract) expireTransfer(ctx contractapi.TransactionContextInterface,
+	transfer *Transfer) error {
+
+	if err := setTransferStatus(transfer, TransferStatusTimedOut); err != nil {
+		return err
+	}
+	if transfer.EffectiveAt != "" && transfer.ActivatedAt == "" {
+		err := ctx.GetStub().DelState(scheduledTransferKey(transfer.To, transfer.EffectiveAt, transfer.ID))
+		if err != nil {
+			return fmt.Errorf("failed to update scheduled transfer index: %v", err)
+		}
+	}
+	if err := putTransfer(ctx, transfer); err != nil {
+		return err
+	}
+
+	return s.returnToSender(ctx, transfer)
+}
@@ -0,0 +1,194 @@
+package contracts
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Transit checkpoints are stored as transit_checkpoint_<transferID>_<recordedAt>_<txID>,
+// so a transfer's route is one key range in the order the checkpoints were recorded.
+// The transfer itself is not updated, so checkpoints never conflict with its
+// confirmations.
+const (
+	transitCheckpointKeyPrefix = "transit_checkpoint_"
+	maxGeoHashLength           = 12
+	checkpointClockSkew        = 5 * time.Minute // Device clocks may run ahead of the orderer
+)
+
+// geoHashAlphabet is the base32 alphabet of geohashes
+const geoHashAlphabet = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+// TransitCheckpoint is one position report of a shipment in transit
+type TransitCheckpoint struct {
+	TransferID     string `json:"transferId"`
+	GeoHash        string `json:"geoHash"`
+	CarrierID      string `json:"carrierId"`                                     // Carrier MSP ID
+	SensorDataHash string `json:"sensorDataHash,omitempty" metadata:",optional"` // e.g. temperature and shock log kept off-chain
+	RecordedAt     string `json:"recordedAt"`                                    // When the position was taken, RFC3339
+	SubmittedBy    string `json:"submittedBy"`                                   // MSP ID
+	SubmittedAt    string `json:"submittedAt"`                                   // Transaction time
+	TxID           string `json:"txId"`
+	SchemaVersion  int    `json:"schemaVersion"`
+}
+
+// validateGeoHash checks a geohash of up to 12 characters
+func validateGeoHash(geoHash string) error {
+	if geoHash == "" || len(geoHash) > maxGeoHashLength {
+		return newError(ErrInvalidArgument, "geoHash must have 1 to %d characters", maxGeoHashLength)
+	}
+	for _, c := range geoHash {
+		if !strings.ContainsRune(geoHashAlphabet, c) {
+			return newError(ErrInvalidArgument, "geoHash contains invalid character %q", c)
+		}
+	}
+	return nil
+}
+
+// AddCheckpoint records a position of an open transfer's shipment. carrierID is the
+// registered carrier moving it; the carrier or the sender, e.g. relaying the carrier's
+// tracker, may submit it. timestamp is when the position was taken and must lie between
+// the transfer's initiation and the transaction time.
+func (s *SupplyChainContract) AddCheckpoint(ctx contractapi.TransactionContextInterface,
+	transferID string, geoHash string, carrierID string, sensorDataHash string, timestamp string) error {
+
+	if err := validateAll(
+		validateID("transferID", transferID),
+		validateGeoHash(geoHash),
+		validateID("carrierID", carrierID),
+	); err != nil {
+		return err
+	}
+	if sensorDataHash != "" {
+		if err := validateID("sensorDataHash", sensorDataHash); err != nil {
+			return err
+		}
+	}
+	recordedAt, err := time.Parse(time.RFC3339, timestamp)
+	if err != nil {
+		return newError(ErrInvalidArgument, "timestamp must be an RFC3339 time")
+	}
+
+	caller, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to get caller identity: %v", err)
+	}
+
+	if err := checkCarrier(ctx, carrierID); err != nil {
+		return err
+	}
+
+	transfer, err := s.GetTransfer(ctx, transferID)
+	if err != nil {
+		return err
+	}
+	if caller != carrierID && caller != transfer.From {
+		return newError(ErrPermissionDenied, "only the carrier or the sender can record checkpoints of transfer %s", transferID)
+	}
+	if transfer.Status != TransferStatusInitiated && transfer.Status != TransferStatusPending {
+		return newError(ErrInvalidState, "transfer %s is %s, not in transit", transferID, transfer.Status)
+	}
+	if transfer.ConsensusDetails.Carrier != "" && transfer.ConsensusDetails.Carrier != carrierID {
+		return newError(ErrInvalidArgument, "transfer %s was handed off to carrier %s", transferID, transfer.ConsensusDetails.Carrier)
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	if recordedAt.After(now.Add(checkpointClockSkew)) {
+		return newError(ErrInvalidArgument, "timestamp %s is in the future", timestamp)
+	}
+	if initiatedAt, err := time.Parse(time.RFC3339, transfer.InitiatedAt); err == nil && recordedAt.Before(initiatedAt) {
+		return newError(ErrInvalidArgument, "timestamp %s is before transfer %s was initiated", timestamp, transferID)
+	}
+
+	txID := ctx.GetStub().GetTxID()
+	checkpoint := TransitCheckpoint{
+		TransferID:     transferID,
+		GeoHash:        geoHash,
+		CarrierID:      carrierID,
+		SensorDataHash: sensorDataHash,
+		RecordedAt:     recordedAt.UTC().Format(time.RFC3339),
+		SubmittedBy:    caller,
+		SubmittedAt:    now.UTC().Format(time.RFC3339),
+		TxID:           txID,
+		SchemaVersion:  CurrentSchemaVersion,
+	}
+	checkpointJSON, err := json.Marshal(checkpoint)
+	if err != nil {
+		return err
+	}
+
+	// The ledger log's fixed-width time layout keeps the keys in route order
+	key := transitCheckpointKeyPrefix + transferID + "_" + recordedAt.UTC().Format(ledgerLogTimeLayout) + "_" + txID
+	err = ctx.GetStub().PutState(key, checkpointJSON)
+	if err != nil {
+		return fmt.Errorf("failed to store checkpoint: %v", err)
+	}
+
+	// The position stays off the event stream, which every channel member receives
+	return emitEvent(ctx, ChaincodeEvent{
+		EventType:  EventTransitCheckpointAdded,
+		EntityType: EventEntityTransfer,
+		EntityID:   transferID,
+		Attributes: map[string]interface{}{
+			"carrier":    carrierID,
+			"recordedAt": checkpoint.RecordedAt,
+			"sensorData": sensorDataHash != "",
+		},
+	})
+}
+
+// GetTransitHistory returns the checkpoints of a transfer in the order they were
+// recorded. Positions of high-value shipments are sensitive, so only the sender, the
+// receiver, the carrier it was handed off to and the brand may read them.
+func (s *SupplyChainContract) GetTransitHistory(ctx contractapi.TransactionContextInterface,
+	transferID string) ([]*TransitCheckpoint, error) {
+
+	if err := validateID("transferID", transferID); err != nil {
+		return nil, err
+	}
+
+	caller, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get caller identity: %v", err)
+	}
+	transfer, err := s.GetTransfer(ctx, transferID)
+	if err != nil {
+		return nil, err
+	}
+	if transfer.From != caller && transfer.To != caller && transfer.ConsensusDetails.Carrier != caller {
+		if _, err := requireSuperAdmin(ctx); err != nil {
+			return nil, newError(ErrPermissionDenied, "only the parties and carrier of transfer %s and the brand can read its route", transferID)
+		}
+	}
+
+	prefix := transitCheckpointKeyPrefix + transferID + "_"
+	resultsIterator, err := ctx.GetStub().GetStateByRange(prefix, prefix+"~")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query checkpoints: %v", err)
+	}
+	defer resultsIterator.Close()
+
+	checkpoints := []*TransitCheckpoint{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var checkpoint TransitCheckpoint
+		err = json.Unmarshal(queryResponse.Value, &checkpoint)
+		if err != nil {
+			return nil, err
+		}
+		checkpoint.upgradeSchema()
+		checkpoints = append(checkpoints, &checkpoint)
+	}
+
+	return checkpoints, nil
+}
@@ -0,0 +1,90 @@
+package contracts
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// TrustTier is a sender's trust tier in consensus, see its GetTrustTier
+type TrustTier string
+
+const (
+	TrustTierProbation TrustTier = "PROBATION"
+	TrustTierStandard  TrustTier = "STANDARD"
+	TrustTierTrusted   TrustTier = "TRUSTED"
+	TrustTierPlatinum  TrustTier = "PLATINUM"
+)
+
+// PLATINUM senders' transfers time out this many times later
+const platinumTimeoutFactor = 2
+
+// senderTrustTier returns the consensus trust tier of a transfer's sender. When
+// consensus cannot be reached the tier is left empty, which is treated as STANDARD.
+func senderTrustTier(ctx contractapi.TransactionContextInterface, sender string) TrustTier {
+	consensus, err := LoadConsensusIntegration(ctx)
+	if err == nil {
+		var tier TrustTier
+		tier, err = consensus.GetTrustTier(ctx, sender)
+		if err == nil {
+			return tier
+		}
+	}
+	logFor(ctx).Warn("failed to get sender trust tier", "sender", sender, "error", err)
+	return ""
+}
+
+// checkSenderEvidence checks that a sender on PROBATION attached shipping evidence
+// before confirming the goods were sent
+func checkSenderEvidence(transfer *Transfer) error {
+	if transfer.ConsensusDetails.SenderTier == TrustTierProbation && transfer.ConsensusDetails.ShippingEvidenceHash == "" {
+		return newError(ErrInvalidState, "sender is on probation and must attach shipping evidence to transfer %s with AttachShippingEvidence", transfer.ID)
+	}
+	return nil
+}
+
+// AttachShippingEvidence records evidence that the goods of a transfer were packed and
+// dispatched, e.g. the IPFS hash of a waybill and photos. Any sender may attach it
+// before confirming sent; senders on PROBATION must. Attaching again replaces it.
+func (s *SupplyChainContract) AttachShippingEvidence(ctx contractapi.TransactionContextInterface,
+	transferID string, evidenceHash string) error {
+
+	if err := validateAll(
+		validateID("transferID", transferID),
+		validateID("evidenceHash", evidenceHash),
+	); err != nil {
+		return err
+	}
+
+	sender, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to get sender identity: %v", err)
+	}
+	transfer, err := s.GetTransfer(ctx, transferID)
+	if err != nil {
+		return err
+	}
+	if transfer.From != sender {
+		return newError(ErrPermissionDenied, "only the sender can attach shipping evidence to transfer %s", transferID)
+	}
+	if transfer.Status != TransferStatusInitiated {
+		return newError(ErrInvalidState, "transfer %s was already sent", transferID)
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	transfer.ConsensusDetails.ShippingEvidenceHash = evidenceHash
+	transfer.ConsensusDetails.ShippingEvidenceAt = now.UTC().Format(time.RFC3339)
+
+	err = putTransfer(ctx, transfer)
+	if err != nil {
+		return err
+	}
+
+	event := transferEvent(EventShippingEvidenceAttached, transfer, "")
+	event.Attributes["evidenceHash"] = evidenceHash
+	return emitEvent(ctx, event)
+}
@@ -19,10 +19,44 @@ type Product struct {
 	StolenDate         string                 `json:"stolenDate"`
 	RecoveredDate      string                 `json:"recoveredDate"`
 	Materials          []Material             `json:"materials"`
+	Variant            string                 `json:"variant,omitempty" metadata:",optional"`           // Variant code within the batch
+	VariantAttributes  map[string]string      `json:"variantAttributes,omitempty" metadata:",optional"` // e.g. size, color, dial
+	Donation           *Donation              `json:"donation,omitempty" metadata:",optional"`          // Set when the brand donated the product to a charity
+	ProvenanceNotes    []ProvenanceNote       `json:"provenanceNotes,omitempty" metadata:",optional"`   // Shown to buyers, see addDonationNote
+	DemoUnit           *DemoUnit              `json:"demoUnit,omitempty" metadata:",optional"`          // Set while or since the product was a display unit
+	Damage             *ConditionReport       `json:"damage,omitempty" metadata:",optional"`            // Set by MarkProductDamaged
+	Destruction        *ConditionReport       `json:"destruction,omitempty" metadata:",optional"`       // Set by MarkProductDestroyed
+	AuthenticationRequest *AuthenticationRequest `json:"authenticationRequest,omitempty" metadata:",optional"` // Set by ownership transfers whose rule asks for it
+	CertifiedPreOwned  bool                   `json:"certifiedPreOwned,omitempty" metadata:",optional"` // Set by ResaleContract:CertifyForResale
+	CertificateRevoked bool                   `json:"certificateRevoked,omitempty" metadata:",optional"` // Set by RevokeBirthCertificate
+	Consignment        *Consignment           `json:"consignment,omitempty" metadata:",optional"` // Set while or since the product was held on consignment
+	Passport           *DigitalPassport       `json:"passport,omitempty" metadata:",optional"` // ERC-721 twin, see RequestPassportMint
+	Sustainability     *SustainabilityInfo    `json:"sustainability,omitempty" metadata:",optional"` // Overrides the batch's, see SetProductSustainability
+	Footprint          *ProductFootprint      `json:"footprint,omitempty" metadata:",optional"` // Computed from the materials' emission factors at creation
 	// QualityCheckpoints removed - quality verified through 2-check consensus
 	Metadata           map[string]interface{} `json:"metadata"`
 	// Privacy fields
 	OwnershipHash string `json:"ownershipHash"` // SHA256 of owner details
+	Version       int    `json:"version"`       // Incremented on every write, see putProduct
+	SchemaVersion int `json:"schemaVersion"`
+}
+
+// ProductSummary carries the identity, status and owner fields of a Product
+// for list views and verification screens
+type ProductSummary struct {
+	ID              string        `json:"id"`
+	BatchID         string        `json:"batchId"`
+	Brand           string        `json:"brand"`
+	Name            string        `json:"name"`
+	Type            string        `json:"type"`
+	SerialNumber    string        `json:"serialNumber"`
+	Variant         string        `json:"variant,omitempty" metadata:",optional"`
+	Status          ProductStatus `json:"status"`
+	CurrentOwner    string        `json:"currentOwner"`
+	CurrentLocation string        `json:"currentLocation"`
+	IsStolen        bool          `json:"isStolen"`
+	HasOwner        bool          `json:"hasOwner"` // Claimed by a customer
+	Version         int           `json:"version"`
 }
 
 // DigitalBirthCertificate represents the immutable creation record
@@ -32,10 +66,23 @@ type DigitalBirthCertificate struct {
 	ManufacturingDate  string           `json:"manufacturingDate"`
 	ManufacturingPlace string              `json:"manufacturingPlace"`
 	Craftsman          string              `json:"craftsman"`
+	CraftsmanIDs       []string            `json:"craftsmanIds,omitempty" metadata:",optional"` // Registered craftsmen credited, see GetCraftsman
 	Materials          []MaterialRecord    `json:"materials"`
 	Authenticity       AuthenticityDetails `json:"authenticity"`
 	InitialPhotos      []string            `json:"initialPhotos"` // IPFS hashes
+	Documents          []ProductDocument   `json:"documents,omitempty" metadata:",optional"` // Anchored with AddProductDocument
+	DocumentRoot       string              `json:"documentRoot,omitempty" metadata:",optional"` // Merkle root over photos, documents and service photos
+	DisclosureRoot     string              `json:"disclosureRoot,omitempty" metadata:",optional"` // Commitment over salted per-field hashes
+	ResaleCertifications []ResaleCertification `json:"resaleCertifications,omitempty" metadata:",optional"` // CPO certifications, one revision each
+	Revision           int                 `json:"revision,omitempty" metadata:",optional"` // 1 at creation, raised by every change; absent on certificates from before versioning
+	PreviousHash       string              `json:"previousHash,omitempty" metadata:",optional"` // Certificate hash of the previous revision
+	AmendedBy          string              `json:"amendedBy,omitempty" metadata:",optional"`
+	AmendedAt          string              `json:"amendedAt,omitempty" metadata:",optional"`
+	AmendmentReason    string              `json:"amendmentReason,omitempty" metadata:",optional"`
+	Revocation         *CertificateRevocation `json:"revocation,omitempty" metadata:",optional"` // Set by RevokeBirthCertificate
+	Sustainability     *ProductFootprint   `json:"sustainability,omitempty" metadata:",optional"` // The product's footprint and material certifications at creation
 	CertificateHash    string              `json:"certificateHash"`
+	SchemaVersion int `json:"schemaVersion"`
 }
 
 // Material represents raw materials used in the product
@@ -48,6 +95,9 @@ type Material struct {
 	QuantityUsed float64   `json:"quantityUsed"` // Amount used in this product/batch
 	Verification string    `json:"verification"`
 	ReceivedDate string `json:"receivedDate"`
+	EmissionFactorKgCO2e float64 `json:"emissionFactorKgCO2e,omitempty" metadata:",optional"` // Per unit, recorded by the supplier
+	FootprintKgCO2e      float64 `json:"footprintKgCO2e,omitempty" metadata:",optional"`      // QuantityUsed times the emission factor
+	Certifications       []string `json:"certifications,omitempty" metadata:",optional"`      // e.g. "LWG GOLD LWG-1234"
 }
 
 // MaterialInventory tracks material ownership and usage per organization
@@ -60,8 +110,27 @@ type MaterialInventory struct {
 	Type         string  `json:"type"`         // Material type
 	TotalReceived float64 `json:"totalReceived"` // Total quantity received
 	Available    float64 `json:"available"`    // Currently available quantity
+	Reserved     float64 `json:"reserved,omitempty" metadata:",optional"` // Held for planned production, see ReserveMaterial
 	Used         float64 `json:"used"`         // Amount used in products
+	WrittenOff   float64 `json:"writtenOff,omitempty" metadata:",optional"` // Lost quantity, see WriteOffMaterial
 	Transfers    []MaterialTransferRecord `json:"transfers"` // All transfers of this material
+	Certification *OriginCertification `json:"certification,omitempty" metadata:",optional"` // Origin certificate of a regulated material
+	UpstreamSources []UpstreamSource `json:"upstreamSources,omitempty" metadata:",optional"` // Declared by the supplier, see DeclareUpstreamSources
+	UpstreamDeclaredAt string `json:"upstreamDeclaredAt,omitempty" metadata:",optional"`
+	ExpiryDate   string  `json:"expiryDate,omitempty" metadata:",optional"` // RFC3339 end of the lot's shelf life, see CheckExpiredMaterials
+	Emissions    *MaterialEmissions `json:"emissions,omitempty" metadata:",optional"` // Kept on the supplier's inventory, see RecordEmissionsData
+	SchemaVersion int `json:"schemaVersion"`
+}
+
+// MaterialAvailability aggregates inventory of one material type across all owners
+type MaterialAvailability struct {
+	MaterialType     string             `json:"materialType"`
+	Inventories      int                `json:"inventories"` // Number of inventory records aggregated
+	TotalReceived    float64            `json:"totalReceived"`
+	Available        float64            `json:"available"`
+	Reserved         float64            `json:"reserved"`
+	Used             float64            `json:"used"`
+	AvailableByOwner map[string]float64 `json:"availableByOwner"`
 }
 
 // MaterialTransferRecord tracks each transfer of a material
@@ -72,7 +141,8 @@ type MaterialTransferRecord struct {
 	Quantity     float64 `json:"quantity"`
 	TransferDate string  `json:"transferDate"`
 	Verified     bool    `json:"verified"` // 2-check consensus completed
-	Status       string  `json:"status,omitempty"` // DISPUTED, RESOLVED - only set when dispute happens
+	Status       string  `json:"status,omitempty" metadata:",optional"` // PENDING, COMPLETED, CANCELLED, DISPUTED or RESOLVED
+	CommercialTermsHash string `json:"commercialTermsHash,omitempty" metadata:",optional"` // SHA256 of the private terms, see SetMaterialTransferTerms
 }
 
 // MaterialRecord is a simplified version for the birth certificate
@@ -89,9 +159,13 @@ type MaterialRecord struct {
 // AuthenticityDetails contains anti-counterfeit information
 type AuthenticityDetails struct {
 	NFCChipID        string   `json:"nfcChipId"`
-	QRCodeData       string   `json:"qrCodeData"`
+	QRCodeData       string   `json:"qrCodeData"` // Tagged, versioned code from issueQRCode
 	HologramID       string   `json:"hologramId"`
 	SecurityFeatures []string `json:"securityFeatures"`
+	NFCChipPublicKeys []string `json:"nfcChipPublicKeys,omitempty" metadata:",optional"` // PEM keys of the secure NFC chip, see VerifyChipSignature
+	RetiredQRCodes   []RetiredCode `json:"retiredQrCodes,omitempty" metadata:",optional"` // Replaced codes, rejected when scanned
+	RetiredNFCChipIDs []string `json:"retiredNfcChipIds,omitempty" metadata:",optional"` // Replaced chips, see ReplaceNFCChip
+	FeatureAmendments []SecurityFeatureAmendment `json:"featureAmendments,omitempty" metadata:",optional"` // Retrofitted features, see AmendSecurityFeatures
 }
 
 // Ownership represents customer ownership record
@@ -102,11 +176,17 @@ type Ownership struct {
 	OwnershipDate    string         `json:"ownershipDate"`
 	PurchaseLocation string            `json:"purchaseLocation"`
 	PurchasePrice    float64           `json:"-"` // Private, not stored on chain
-	TransferCode     string            `json:"transferCode,omitempty"`
-	TransferExpiry   string         `json:"transferExpiry,omitempty"`
+	TransferCode     string            `json:"transferCode,omitempty" metadata:",optional"`
+	TransferExpiry   string         `json:"transferExpiry,omitempty" metadata:",optional"`
+	TransferCodeType     string        `json:"transferCodeType,omitempty" metadata:",optional"` // sale, resale, gift or inheritance
+	TransferDocumentHash string        `json:"transferDocumentHash,omitempty" metadata:",optional"` // e.g. a notarized will, see OwnershipTransferRule
+	TransferTwoPhase     bool          `json:"transferTwoPhase,omitempty" metadata:",optional"` // The code must go through RedeemTransferCode
 	Status           OwnershipStatus   `json:"status"`
 	ServiceHistory   []ServiceRecord   `json:"serviceHistory"`
 	PreviousOwners   []PreviousOwner   `json:"previousOwners"`
+	PendingTransfer  *PendingOwnershipTransfer `json:"pendingTransfer,omitempty" metadata:",optional"` // Redeemed transfer code awaiting FinalizeOwnershipTransfer
+	Warranty         *Warranty         `json:"warranty,omitempty" metadata:",optional"` // Registered by TakeOwnership
+	SchemaVersion int `json:"schemaVersion"`
 }
 
 // PreviousOwner represents historical ownership (privacy preserved)
@@ -114,7 +194,8 @@ type PreviousOwner struct {
 	OwnerHash     string    `json:"ownerHash"`
 	OwnershipDate string `json:"ownershipDate"`
 	TransferDate  string `json:"transferDate"`
-	TransferType  string    `json:"transferType"` // sale, gift, inheritance
+	TransferType  string    `json:"transferType"` // sale, resale, gift, inheritance
+	DocumentHash  string    `json:"documentHash,omitempty" metadata:",optional"` // Presented with the transfer code
 }
 
 // ServiceRecord represents maintenance/service history
@@ -126,6 +207,7 @@ type ServiceRecord struct {
 	Description   string    `json:"description"`
 	Technician    string    `json:"technician"`
 	Warranty      bool      `json:"warranty"`
+	Photos        []string  `json:"photos,omitempty" metadata:",optional"` // IPFS CIDs, see AddServicePhotos
 }
 
 // Transfer represents a B2B transfer in the supply chain
@@ -136,19 +218,56 @@ type Transfer struct {
 	To               string                 `json:"to"`
 	TransferType     TransferType           `json:"transferType"`
 	InitiatedAt      string                 `json:"initiatedAt"`
-	CompletedAt      string                 `json:"completedAt,omitempty"`
+	CompletedAt      string                 `json:"completedAt,omitempty" metadata:",optional"`
 	Status           TransferStatus         `json:"status"`
 	ConsensusDetails ConsensusInfo          `json:"consensusDetails"`
-	Metadata         map[string]interface{} `json:"metadata,omitempty"`  // Additional transfer info
+	Metadata         map[string]interface{} `json:"metadata,omitempty" metadata:",optional"`  // Additional transfer info
+	Payment          *PaymentTerms          `json:"payment,omitempty" metadata:",optional"`   // Delivery-versus-payment terms, see SetPaymentTerms
+	DeclaredValue    *DeclaredValue         `json:"declaredValue,omitempty" metadata:",optional"` // For insurance and customs, see SetDeclaredValue
+	Priority         string                 `json:"priority,omitempty" metadata:",optional"` // URGENT, or empty for NORMAL, see SetTransferPriority
+	EffectiveAt      string                 `json:"effectiveAt,omitempty" metadata:",optional"` // Receipt is refused before this time, see ScheduleTransfer
+	ActivatedAt      string                 `json:"activatedAt,omitempty" metadata:",optional"` // Set by ActivateScheduledTransfers
+	CancelledBy        string               `json:"cancelledBy,omitempty" metadata:",optional"` // Sender (CancelTransfer) or receiver (RejectTransfer)
+	CancelledAt        string               `json:"cancelledAt,omitempty" metadata:",optional"`
+	CancellationReason string               `json:"cancellationReason,omitempty" metadata:",optional"`
+	CommercialTermsHash string              `json:"commercialTermsHash,omitempty" metadata:",optional"` // SHA256 of the private terms, see StoreTransferPrivateDetails
+	ExpectedDeliveryDate   string           `json:"expectedDeliveryDate,omitempty" metadata:",optional"` // Promised by the sender, see SetExpectedDeliveryDate
+	LateDeliveryReportedAt string           `json:"lateDeliveryReportedAt,omitempty" metadata:",optional"` // LATE_DELIVERY passed to consensus, see CheckDeliverySLA
+	StolenFlags            []string         `json:"stolenFlags,omitempty" metadata:",optional"` // Stolen registry matches when initiated, e.g. SERIAL_NUMBER:<serial>
+	TradeDocuments         []TradeDocument  `json:"tradeDocuments,omitempty" metadata:",optional"` // Customs documents, see AttachTradeDocument
+	SchemaVersion int `json:"schemaVersion"`
+}
+
+// PaymentStatus represents the state of a transfer's payment
+type PaymentStatus string
+
+const (
+	PaymentStatusPending PaymentStatus = "PENDING"
+	PaymentStatusSettled PaymentStatus = "SETTLED"
+)
+
+// PaymentTerms are paid by the receiver to the payee when it confirms receipt
+type PaymentTerms struct {
+	Amount         int           `json:"amount"` // In the token's smallest unit
+	Payee          string        `json:"payee"`  // Token account credited by the payment
+	Status         PaymentStatus `json:"status"`
+	SettledAt      string        `json:"settledAt,omitempty" metadata:",optional"`
+	SettlementTxID string        `json:"settlementTxId,omitempty" metadata:",optional"` // The ConfirmReceived transaction
 }
 
 // ConsensusInfo contains 2-Check consensus information
 type ConsensusInfo struct {
 	SenderConfirmed   bool    `json:"senderConfirmed"`
 	ReceiverConfirmed bool    `json:"receiverConfirmed"`
-	SenderTimestamp   string  `json:"senderTimestamp,omitempty"`
-	ReceiverTimestamp string  `json:"receiverTimestamp,omitempty"`
+	SenderTimestamp   string  `json:"senderTimestamp,omitempty" metadata:",optional"`
+	ReceiverTimestamp string  `json:"receiverTimestamp,omitempty" metadata:",optional"`
 	TimeoutAt         string  `json:"timeoutAt"`
+	Carrier           string  `json:"carrier,omitempty" metadata:",optional"` // LOGISTICS transfers, set by HandoffToCarrier
+	CarrierConfirmed  bool    `json:"carrierConfirmed,omitempty" metadata:",optional"` // Carrier delivered to the receiver, see CarrierDeliver
+	CarrierTimestamp  string  `json:"carrierTimestamp,omitempty" metadata:",optional"`
+	SenderTier        TrustTier `json:"senderTier,omitempty" metadata:",optional"` // Sender's consensus trust tier when the transfer was initiated
+	ShippingEvidenceHash string `json:"shippingEvidenceHash,omitempty" metadata:",optional"` // See AttachShippingEvidence, required from PROBATION senders
+	ShippingEvidenceAt   string `json:"shippingEvidenceAt,omitempty" metadata:",optional"`
 }
 
 // OrganizationRole represents the role of an organization in the supply chain
@@ -160,6 +279,13 @@ const (
 	RoleManufacturer OrganizationRole = "MANUFACTURER"
 	RoleWarehouse    OrganizationRole = "WAREHOUSE"
 	RoleRetailer     OrganizationRole = "RETAILER"
+	RoleCustoms      OrganizationRole = "CUSTOMS"   // Customs brokers and authorities
+	RoleLogistics    OrganizationRole = "LOGISTICS" // Freight forwarders and carriers
+	RoleCharity      OrganizationRole = "CHARITY"   // Registered charities receiving donated products
+	RoleCarrier      OrganizationRole = "CARRIER"   // Carriers answering damage claims, see ConfirmReceivedWithDamage
+	RoleLawEnforcement OrganizationRole = "LAW_ENFORCEMENT" // Police querying stolen goods, see GetStolenProductDetail
+	RoleInsurer OrganizationRole = "INSURER" // Insurers registering policies and settling loss claims, see insurance.go
+	RoleAppraiser OrganizationRole = "APPRAISER" // Authorized appraisers grading condition, see AddAppraisalRecord
 )
 
 // OrganizationInfo stores organization details and role
@@ -170,6 +296,10 @@ type OrganizationInfo struct {
 	AssignedBy  string           `json:"assignedBy"`
 	AssignedAt  string           `json:"assignedAt"`
 	IsActive    bool             `json:"isActive"`
+	DID              string               `json:"did,omitempty" metadata:",optional"`              // Set by the organization with RegisterDID
+	VerificationKeys []DIDVerificationKey `json:"verificationKeys,omitempty" metadata:",optional"` // Keys that sign the organization's DID payloads
+	DIDUpdatedAt     string               `json:"didUpdatedAt,omitempty" metadata:",optional"`
+	SchemaVersion int `json:"schemaVersion"`
 }
 
 // Enums
@@ -181,8 +311,11 @@ const (
 	ProductStatusInTransit    ProductStatus = "IN_TRANSIT"
 	ProductStatusInStore      ProductStatus = "IN_STORE"
 	ProductStatusSold         ProductStatus = "SOLD"
+	ProductStatusDemo         ProductStatus = "DEMO" // Retail display unit, see MarkAsDemoUnit
 	ProductStatusStolen       ProductStatus = "STOLEN"
+	ProductStatusDamaged      ProductStatus = "DAMAGED" // Out of sale pending repair or destruction, see MarkProductDamaged
 	ProductStatusDestroyed    ProductStatus = "DESTROYED"
+	ProductStatusWrittenOff   ProductStatus = "WRITTEN_OFF" // Lost in the holder's custody, see WriteOffProduct
 )
 
 type OwnershipStatus string
@@ -201,6 +334,9 @@ const (
 	TransferTypeSupplyChain TransferType = "SUPPLY_CHAIN"
 	TransferTypeOwnership   TransferType = "OWNERSHIP"
 	TransferTypeReturn      TransferType = "RETURN"
+	TransferTypeDonation    TransferType = "DONATION" // From the brand to a registered charity
+	TransferTypeLogistics   TransferType = "LOGISTICS" // Shipped through a carrier, see HandoffToCarrier
+	TransferTypeConsignment TransferType = "CONSIGNMENT" // Placed with a retailer, the sender stays the owner
 )
 
 // ProductBatch represents a batch of products manufactured together
@@ -214,10 +350,22 @@ type ProductBatch struct {
 	MaterialsUsed    []MaterialUsage   `json:"materialsUsed"`
 	ManufactureDate  string            `json:"manufactureDate"`
 	QRCode           string            `json:"qrCode"` // QR code for batch tracking
+	RetiredQRCodes   []RetiredCode     `json:"retiredQrCodes,omitempty" metadata:",optional"` // Replaced by RegenerateBatchQRCode
 	CurrentOwner     string            `json:"currentOwner"`
 	CurrentLocation  string            `json:"currentLocation"`
 	Status           BatchStatus       `json:"status"`
 	Metadata         map[string]string `json:"metadata"`
+	BrandApprovedBy  string            `json:"brandApprovedBy,omitempty" metadata:",optional"` // Set by ApproveBatch
+	BrandApprovedAt  string            `json:"brandApprovedAt,omitempty" metadata:",optional"`
+	Variants         []BatchVariant    `json:"variants,omitempty" metadata:",optional"` // Variant lines, see assignBatchVariants
+	ManifestHash        string `json:"manifestHash,omitempty" metadata:",optional"` // Set by GenerateBatchManifest
+	ManifestGeneratedBy string `json:"manifestGeneratedBy,omitempty" metadata:",optional"`
+	ManifestGeneratedAt string `json:"manifestGeneratedAt,omitempty" metadata:",optional"`
+	DestroyedQuantity   int    `json:"destroyedQuantity,omitempty" metadata:",optional"` // Products no longer sellable, see updateBatchStatus
+	Consignment         *Consignment `json:"consignment,omitempty" metadata:",optional"` // Set while the batch is held on consignment
+	Sustainability      *SustainabilityInfo `json:"sustainability,omitempty" metadata:",optional"` // Shared by its products, see SetBatchSustainability
+	Version          int               `json:"version"` // Incremented on every write, see putBatch
+	SchemaVersion int `json:"schemaVersion"`
 }
 
 // MaterialUsage tracks how much material was used in a batch
@@ -227,18 +375,22 @@ type MaterialUsage struct {
 	Supplier     string  `json:"supplier"`
 	QuantityUsed float64 `json:"quantityUsed"`
 	Batch        string  `json:"batch"` // Material batch number
+	EmissionFactorKgCO2e float64 `json:"emissionFactorKgCO2e,omitempty" metadata:",optional"` // From the supplier's emissions data when the batch was created
+	Certifications       []string `json:"certifications,omitempty" metadata:",optional"`
 }
 
 // BatchStatus represents the status of a product batch
 type BatchStatus string
 
 const (
+	BatchStatusAssembling  BatchStatus = "ASSEMBLING" // Products still being appended, see CreateBatchHeader
 	BatchStatusCreated     BatchStatus = "CREATED"
 	BatchStatusInTransit   BatchStatus = "IN_TRANSIT"
 	BatchStatusAtWarehouse BatchStatus = "AT_WAREHOUSE"
 	BatchStatusAtRetailer  BatchStatus = "AT_RETAILER"
 	BatchStatusPartial     BatchStatus = "PARTIAL" // Some products sold
 	BatchStatusSold        BatchStatus = "SOLD_OUT"
+	BatchStatusMerged      BatchStatus = "MERGED" // Leftover products repackaged into another batch, see MergeBatches
 )
 
 type TransferStatus string
@@ -249,4 +401,5 @@ const (
 	TransferStatusCompleted TransferStatus = "COMPLETED"
 	TransferStatusCancelled TransferStatus = "CANCELLED"
 	TransferStatusDisputed  TransferStatus = "DISPUTED"
+	TransferStatusTimedOut  TransferStatus = "TIMED_OUT" // Not confirmed before its timeout, see ExpirePendingTransfers
 )
\ No newline at end of file
@@ -0,0 +1,170 @@
+package contracts
+
+import (
+	"encoding/json"
+	"math"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// Input limits shared by all transactions
+const (
+	maxIDLength   = 128
+	maxNameLength = 256
+	maxTextLength = 2048
+	maxJSONLength = 64 * 1024
+)
+
+// IDs end up inside ledger keys, so they may not contain the "_" key separator,
+// the "~" range terminator, whitespace or control characters
+var idPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9.:-]*$`)
+
+// IPFS content identifiers: base58btc CIDv0 ("Qm...") or base32 CIDv1 ("bafy...")
+var (
+	cidV0Pattern = regexp.MustCompile(`^Qm[1-9A-HJ-NP-Za-km-z]{44}$`)
+	cidV1Pattern = regexp.MustCompile(`^b[a-z2-7]{58,126}$`)
+)
+
+// Fabric's naming rules for chaincodes and channels
+var (
+	chaincodeNamePattern = regexp.MustCompile(`^[A-Za-z0-9]+([-_][A-Za-z0-9]+)*$`)
+	channelNamePattern   = regexp.MustCompile(`^[a-z][a-z0-9.-]*$`)
+)
+
+// validateAll returns the first failed validation
+func validateAll(errs ...error) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateID checks a required identifier used in ledger keys
+func validateID(field string, value string) error {
+	if value == "" {
+		return newError(ErrInvalidArgument, "%s is required", field)
+	}
+	if len(value) > maxIDLength {
+		return newError(ErrInvalidArgument, "%s exceeds %d characters", field, maxIDLength)
+	}
+	if !idPattern.MatchString(value) {
+		return newError(ErrInvalidArgument, "%s %q may only contain letters, digits, '.', ':' and '-'", field, value)
+	}
+	return nil
+}
+
+// validateCID checks a required IPFS content identifier
+func validateCID(field string, value string) error {
+	if value == "" {
+		return newError(ErrInvalidArgument, "%s is required", field)
+	}
+	if !cidV0Pattern.MatchString(value) && !cidV1Pattern.MatchString(value) {
+		return newError(ErrInvalidArgument, "%s %q is not an IPFS CIDv0 or base32 CIDv1", field, value)
+	}
+	return nil
+}
+
+// validateChaincodeName checks a chaincode name against Fabric's naming rules
+func validateChaincodeName(field string, value string) error {
+	if len(value) > maxIDLength || !chaincodeNamePattern.MatchString(value) {
+		return newError(ErrInvalidArgument, "%s %q is not a valid chaincode name", field, value)
+	}
+	return nil
+}
+
+// validateChannelName checks a channel name against Fabric's naming rules
+func validateChannelName(field string, value string) error {
+	if len(value) > 249 || !channelNamePattern.MatchString(value) {
+		return newError(ErrInvalidArgument, "%s %q is not a valid channel name", field, value)
+	}
+	return nil
+}
+
+// validateName checks a required short value such as a brand, type or location.
+// Names are used in CouchDB selectors, so quotes and backslashes are rejected.
+func validateName(field string, value string) error {
+	if err := validateRequired(field, value, maxNameLength); err != nil {
+		return err
+	}
+	if strings.ContainsAny(value, "\"\\") {
+		return newError(ErrInvalidArgument, "%s may not contain quotes or backslashes", field)
+	}
+	return nil
+}
+
+// validateRequired checks a mandatory free-text value
+func validateRequired(field string, value string, maxLength int) error {
+	if strings.TrimSpace(value) == "" {
+		return newError(ErrInvalidArgument, "%s is required", field)
+	}
+	return validateText(field, value, maxLength)
+}
+
+// validateText checks an optional free-text value for length and control characters
+func validateText(field string, value string, maxLength int) error {
+	if len(value) > maxLength {
+		return newError(ErrInvalidArgument, "%s exceeds %d characters", field, maxLength)
+	}
+	for _, r := range value {
+		if unicode.IsControl(r) && r != '\n' && r != '\t' {
+			return newError(ErrInvalidArgument, "%s contains control characters", field)
+		}
+	}
+	return nil
+}
+
+// validateQuantity checks that a quantity is a finite number greater than zero
+func validateQuantity(field string, value float64) error {
+	if math.IsNaN(value) || math.IsInf(value, 0) || value <= 0 {
+		return newError(ErrInvalidArgument, "%s must be greater than zero, got %v", field, value)
+	}
+	return nil
+}
+
+// validateEnum checks that value is one of the allowed values
+func validateEnum(field string, value string, allowed ...string) error {
+	for _, candidate := range allowed {
+		if value == candidate {
+			return nil
+		}
+	}
+	return newError(ErrInvalidArgument, "invalid %s %q, must be one of %s", field, value, strings.Join(allowed, ", "))
+}
+
+// validateJSON checks the size of a JSON argument and decodes it into target
+func validateJSON(field string, value string, target interface{}) error {
+	if len(value) > maxJSONLength {
+		return newError(ErrInvalidArgument, "%s exceeds %d bytes", field, maxJSONLength)
+	}
+	if err := json.Unmarshal([]byte(value), target); err != nil {
+		return newError(ErrInvalidArgument, "invalid %s: %v", field, err)
+	}
+	return nil
+}
+
+// productStatuses lists the values accepted as a ProductStatus argument
+var productStatuses = []string{
+	string(ProductStatusCreated),
+	string(ProductStatusInProduction),
+	string(ProductStatusInTransit),
+	string(ProductStatusInStore),
+	string(ProductStatusSold),
+	string(ProductStatusDemo),
+	string(ProductStatusStolen),
+	string(ProductStatusDamaged),
+	string(ProductStatusDestroyed),
+	string(ProductStatusWrittenOff),
+}
+
+// transferStatuses lists the values accepted as a TransferStatus argument
+var transferStatuses = []string{
+	string(TransferStatusInitiated),
+	string(TransferStatusPending),
+	string(TransferStatusCompleted),
+	string(TransferStatusCancelled),
+	string(TransferStatusDisputed),
+	string(TransferStatusTimedOut),
+}
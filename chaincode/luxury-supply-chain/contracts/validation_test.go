@@ -0,0 +1,117 @@
+package contracts
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateID(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		wantErr bool
+	}{
+		{"plain", "BATCH-2024.001:A", false},
+		{"empty", "", true},
+		{"underscore key separator", "BATCH_1", true},
+		{"range terminator", "BATCH~", true},
+		{"newline", "BATCH\n1", true},
+		{"null byte", "BATCH\x001", true},
+		{"space", "BATCH 1", true},
+		{"leading dash", "-BATCH", true},
+		{"length 128", strings.Repeat("a", maxIDLength), false},
+		{"length 129", strings.Repeat("a", maxIDLength+1), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateID("batchID", tt.value)
+			if tt.wantErr {
+				require.True(t, hasErrorCode(err, ErrInvalidArgument), "got %v", err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestValidateQuantity(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   float64
+		wantErr bool
+	}{
+		{"positive", 1, false},
+		{"fraction", 0.25, false},
+		{"zero", 0, true},
+		{"negative", -5, true},
+		{"NaN", math.NaN(), true},
+		{"positive infinity", math.Inf(1), true},
+		{"negative infinity", math.Inf(-1), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateQuantity("quantity", tt.value)
+			if tt.wantErr {
+				require.True(t, hasErrorCode(err, ErrInvalidArgument), "got %v", err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestValidateEnum(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		wantErr bool
+	}{
+		{"allowed", string(TransferStatusPending), false},
+		{"last allowed", string(TransferStatusTimedOut), false},
+		{"lower case", "pending", true},
+		{"empty", "", true},
+		{"unknown", "SHIPPED", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateEnum("status", tt.value, transferStatuses...)
+			if tt.wantErr {
+				require.True(t, hasErrorCode(err, ErrInvalidArgument), "got %v", err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestValidateJSON(t *testing.T) {
+	padded := func(length int) string {
+		// A JSON string of exactly length bytes, quotes included
+		return `"` + strings.Repeat("a", length-2) + `"`
+	}
+	tests := []struct {
+		name    string
+		value   string
+		wantErr bool
+	}{
+		{"at size limit", padded(maxJSONLength), false},
+		{"over size limit", padded(maxJSONLength + 1), true},
+		{"malformed", `"unterminated`, true},
+		{"wrong type", `{"id":"L1","quantity":10}`, true},
+		{"empty", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var target string
+			err := validateJSON("valueJSON", tt.value, &target)
+			if tt.wantErr {
+				require.True(t, hasErrorCode(err, ErrInvalidArgument), "got %v", err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
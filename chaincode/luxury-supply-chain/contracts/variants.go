@@ -0,0 +1,184 @@
+package contracts
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Limits on the variant lines of one batch
+const (
+	maxBatchVariants      = 100
+	maxVariantAttributes  = 10
+	variantAttributeField = "variantAttributes" // Product field queried by QueryProductsByVariant
+)
+
+// BatchVariant is a line of a batch sharing attributes such as size, color or dial.
+// Its products are numbered consecutively from FirstSerial to LastSerial.
+type BatchVariant struct {
+	Code        string            `json:"code"`       // e.g. BLK-38
+	Attributes  map[string]string `json:"attributes"` // e.g. {"color":"black","size":"38"}
+	Quantity    int               `json:"quantity"`
+	FirstSerial string            `json:"firstSerial"`
+	LastSerial  string            `json:"lastSerial"`
+}
+
+// batchSerialNumber returns the serial number of product number i of a batch
+func batchSerialNumber(batchID string, i int) string {
+	return fmt.Sprintf("%s-%04d", batchID, i)
+}
+
+// validateVariantAttributes checks variant attribute names and values. Names become
+// CouchDB field paths, so '.' and a leading '$' are rejected.
+func validateVariantAttributes(attributes map[string]string) error {
+	if len(attributes) > maxVariantAttributes {
+		return newError(ErrInvalidArgument, "at most %d variant attributes are allowed", maxVariantAttributes)
+	}
+	for name, value := range attributes {
+		if err := validateAll(
+			validateName("variant attribute", name),
+			validateName("variant attribute "+name, value),
+		); err != nil {
+			return err
+		}
+		if strings.Contains(name, ".") || strings.HasPrefix(name, "$") {
+			return newError(ErrInvalidArgument, "variant attribute %q may not contain '.' or start with '$'", name)
+		}
+	}
+	return nil
+}
+
+// assignBatchVariants checks the variant lines of a batch of quantity products and
+// assigns their serial ranges in order. Nil variants means a uniform batch.
+func assignBatchVariants(variants []BatchVariant, batchID string, quantity int) ([]BatchVariant, error) {
+	if variants == nil {
+		return nil, nil
+	}
+	if len(variants) == 0 || len(variants) > maxBatchVariants {
+		return nil, newError(ErrInvalidArgument, "a batch needs between 1 and %d variants", maxBatchVariants)
+	}
+
+	codes := make(map[string]bool, len(variants))
+	next := 1
+	for i := range variants {
+		variant := &variants[i]
+		if err := validateAll(
+			validateID("variant code", variant.Code),
+			validateQuantity("variant quantity", float64(variant.Quantity)),
+			validateVariantAttributes(variant.Attributes),
+		); err != nil {
+			return nil, err
+		}
+		if codes[variant.Code] {
+			return nil, newError(ErrInvalidArgument, "variant %s is listed twice", variant.Code)
+		}
+		codes[variant.Code] = true
+		if variant.Attributes == nil {
+			variant.Attributes = map[string]string{}
+		}
+
+		variant.FirstSerial = batchSerialNumber(batchID, next)
+		next += variant.Quantity
+		variant.LastSerial = batchSerialNumber(batchID, next-1)
+	}
+	if next-1 != quantity {
+		return nil, newError(ErrInvalidArgument, "variant quantities add up to %d, batch quantity is %d", next-1, quantity)
+	}
+
+	return variants, nil
+}
+
+// variantRange returns the first and last product numbers of variant index v
+func variantRange(variants []BatchVariant, v int) (int, int) {
+	first := 1
+	for i := 0; i < v; i++ {
+		first += variants[i].Quantity
+	}
+	return first, first + variants[v].Quantity - 1
+}
+
+// variantOf returns the variant of product number i of a batch, nil for a uniform batch
+func variantOf(batch *ProductBatch, i int) *BatchVariant {
+	for v := range batch.Variants {
+		first, last := variantRange(batch.Variants, v)
+		if i >= first && i <= last {
+			return &batch.Variants[v]
+		}
+	}
+	return nil
+}
+
+// GetProductsByVariant returns the products of one variant line of a batch
+func (s *SupplyChainContract) GetProductsByVariant(ctx contractapi.TransactionContextInterface,
+	batchID string, variantCode string) ([]*Product, error) {
+
+	if err := validateAll(
+		validateID("batchID", batchID),
+		validateID("variantCode", variantCode),
+	); err != nil {
+		return nil, err
+	}
+
+	batch, err := s.GetBatch(ctx, batchID)
+	if err != nil {
+		return nil, err
+	}
+
+	for v, variant := range batch.Variants {
+		if variant.Code != variantCode {
+			continue
+		}
+		first, last := variantRange(batch.Variants, v)
+		products := []*Product{}
+		// Products of a batch being assembled may not exist yet
+		for i := first; i <= last && i <= len(batch.ProductIDs); i++ {
+			product, err := s.GetProduct(ctx, batch.ProductIDs[i-1])
+			if err != nil {
+				continue // Skip if product not found
+			}
+			products = append(products, product)
+		}
+		return products, nil
+	}
+
+	return nil, newError(ErrNotFound, "batch %s has no variant %s", batchID, variantCode)
+}
+
+// QueryProductsByVariant queries a brand's products whose variant has all the given
+// attributes, e.g. {"color":"black","size":"38"}
+func (s *SupplyChainContract) QueryProductsByVariant(ctx contractapi.TransactionContextInterface,
+	brand string, attributesJSON string) ([]*Product, error) {
+
+	var attributes map[string]string
+	if err := validateAll(
+		validateName("brand", brand),
+		validateJSON("attributesJSON", attributesJSON, &attributes),
+	); err != nil {
+		return nil, err
+	}
+	if len(attributes) == 0 {
+		return nil, newError(ErrInvalidArgument, "at least one variant attribute is required")
+	}
+	if err := validateVariantAttributes(attributes); err != nil {
+		return nil, err
+	}
+
+	selector := map[string]interface{}{
+		"brand":        brand,
+		"serialNumber": map[string]bool{"$exists": true},
+	}
+	for name, value := range attributes {
+		selector[variantAttributeField+"."+name] = value
+	}
+	query, err := json.Marshal(map[string]interface{}{
+		"selector":  selector,
+		"use_index": []string{"_design/indexProductBrandDoc", "indexProductBrand"},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s.queryProducts(ctx, string(query))
+}
@@ -0,0 +1,198 @@
+package contracts
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Verification scans are stored as verification_scan_<scannedAt>_<txID>, with the
+// ledger log's fixed-width time layout, so the scans of all products are one key range
+// in scan order
+const verificationScanKeyPrefix = "verification_scan_"
+
+const (
+	impossibleScanWindow   = time.Hour      // Scans of one serial in two regions closer than this cannot both be genuine
+	defaultScanLookback    = 24 * time.Hour // Scans DetectScanAnomalies reads when no start is given
+	maxScanAnomalyReadSize = 10000
+)
+
+// Channels a verification scan can come from
+const (
+	ScanChannelApp     = "APP"     // Customer app or web page
+	ScanChannelPOS     = "POS"     // Point of sale
+	ScanChannelService = "SERVICE" // Service center or authenticator
+)
+
+var scanChannels = []string{ScanChannelApp, ScanChannelPOS, ScanChannelService}
+
+// regionCodePattern matches ISO 3166-1 alpha-2 country codes
+var regionCodePattern = regexp.MustCompile(`^[A-Z]{2}$`)
+
+// VerificationScan records that a product was verified in a region
+type VerificationScan struct {
+	ProductID     string `json:"productId"`
+	SerialNumber  string `json:"serialNumber"`
+	RegionCode    string `json:"regionCode"` // ISO 3166-1 alpha-2
+	Channel       string `json:"channel"`
+	ScannedBy     string `json:"scannedBy"` // MSP ID of the app's or terminal's organization
+	ScannedAt     string `json:"scannedAt"`
+	TxID          string `json:"txId"`
+	SchemaVersion int    `json:"schemaVersion"`
+}
+
+// ScanAnomaly flags a serial number scanned in two regions closer in time than anyone
+// could travel, so at least one of the scanned items is a copy
+type ScanAnomaly struct {
+	ProductID       string            `json:"productId"`
+	SerialNumber    string            `json:"serialNumber"`
+	Scan            *VerificationScan `json:"scan"`            // First scan of the first impossible pair
+	ConflictingScan *VerificationScan `json:"conflictingScan"` // The scan in another region that followed it
+	Conflicts       int               `json:"conflicts"`       // Impossible pairs found for the serial
+}
+
+// RecordVerificationScan records where a product was just verified, for
+// DetectScanAnomalies. Verification queries cannot write to the ledger, so apps and
+// terminals submit this after each VerifyAuthenticity or VerifyProductByBatch.
+// regionCode is the ISO 3166-1 alpha-2 country of the scan.
+func (o *OwnershipContract) RecordVerificationScan(ctx contractapi.TransactionContextInterface,
+	productID string, regionCode string, channel string) error {
+
+	if err := validateAll(
+		validateID("productID", productID),
+		validateEnum("channel", channel, scanChannels...),
+	); err != nil {
+		return err
+	}
+	if !regionCodePattern.MatchString(regionCode) {
+		return newError(ErrInvalidArgument, "regionCode %q is not an ISO 3166-1 alpha-2 code", regionCode)
+	}
+
+	supplyChain := &SupplyChainContract{}
+	product, err := supplyChain.GetProduct(ctx, productID)
+	if err != nil {
+		return err
+	}
+	serialNumber := product.SerialNumber
+	if serialNumber == "" {
+		serialNumber = productID
+	}
+
+	caller, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to get caller identity: %v", err)
+	}
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	txID := ctx.GetStub().GetTxID()
+	scan := VerificationScan{
+		ProductID:     productID,
+		SerialNumber:  serialNumber,
+		RegionCode:    regionCode,
+		Channel:       channel,
+		ScannedBy:     caller,
+		ScannedAt:     now.UTC().Format(time.RFC3339),
+		TxID:          txID,
+		SchemaVersion: CurrentSchemaVersion,
+	}
+	scanJSON, err := json.Marshal(scan)
+	if err != nil {
+		return err
+	}
+
+	key := verificationScanKeyPrefix + now.UTC().Format(ledgerLogTimeLayout) + "_" + txID
+	err = ctx.GetStub().PutState(key, scanJSON)
+	if err != nil {
+		return fmt.Errorf("failed to record verification scan: %v", err)
+	}
+	return nil
+}
+
+// DetectScanAnomalies returns the serial numbers scanned in two regions less than an
+// hour apart since the given RFC3339 time, or in the last 24 hours when since is empty,
+// in the order they were detected. At most 10000 scans are read per call. Only the
+// brand reads scan patterns.
+func (o *OwnershipContract) DetectScanAnomalies(ctx contractapi.TransactionContextInterface,
+	since string) ([]*ScanAnomaly, error) {
+
+	_, err := requireSuperAdmin(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var start time.Time
+	if since == "" {
+		now, err := txTime(ctx)
+		if err != nil {
+			return nil, err
+		}
+		start = now.Add(-defaultScanLookback)
+	} else {
+		start, err = time.Parse(time.RFC3339, since)
+		if err != nil {
+			return nil, newError(ErrInvalidArgument, "since must be an RFC3339 time: %v", err)
+		}
+	}
+
+	startKey := verificationScanKeyPrefix + start.UTC().Format(ledgerLogTimeLayout)
+	resultsIterator, err := ctx.GetStub().GetStateByRange(startKey, verificationScanKeyPrefix+"~")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query verification scans: %v", err)
+	}
+	defer resultsIterator.Close()
+
+	anomalies := []*ScanAnomaly{}
+	bySerial := make(map[string]*ScanAnomaly)
+	lastScan := make(map[string]*VerificationScan)
+	lastScanAt := make(map[string]time.Time)
+	for read := 0; resultsIterator.HasNext(); read++ {
+		if read == maxScanAnomalyReadSize {
+			logFor(ctx).Warn("scan anomaly detection stopped at its read limit", "since", start.UTC().Format(time.RFC3339))
+			break
+		}
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var scan VerificationScan
+		if err := json.Unmarshal(queryResponse.Value, &scan); err != nil {
+			return nil, err
+		}
+		scan.upgradeSchema()
+		scannedAt, err := time.Parse(time.RFC3339, scan.ScannedAt)
+		if err != nil {
+			logFor(ctx).Warn("skipping verification scan with an invalid time", "key", queryResponse.Key)
+			continue
+		}
+
+		previous := lastScan[scan.SerialNumber]
+		if previous != nil && previous.RegionCode != scan.RegionCode &&
+			scannedAt.Sub(lastScanAt[scan.SerialNumber]) < impossibleScanWindow {
+
+			anomaly, flagged := bySerial[scan.SerialNumber]
+			if !flagged {
+				conflicting := scan
+				anomaly = &ScanAnomaly{
+					ProductID:       scan.ProductID,
+					SerialNumber:    scan.SerialNumber,
+					Scan:            previous,
+					ConflictingScan: &conflicting,
+				}
+				bySerial[scan.SerialNumber] = anomaly
+				anomalies = append(anomalies, anomaly)
+			}
+			anomaly.Conflicts++
+		}
+		current := scan
+		lastScan[scan.SerialNumber] = &current
+		lastScanAt[scan.SerialNumber] = scannedAt
+	}
+
+	return anomalies, nil
+}
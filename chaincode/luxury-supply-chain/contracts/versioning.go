@@ -0,0 +1,94 @@
+package contracts
+
+import (
+	"encoding/json"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// checkVersion rejects an update made against a stale copy of a record.
+// An expected version of 0 skips the check for callers that don't track versions.
+func checkVersion(entity string, id string, current int, expected int) error {
+	if expected != 0 && expected != current {
+		return newError(ErrConflict, "%s %s was modified concurrently: expected version %d, current version %d",
+			entity, id, expected, current)
+	}
+	return nil
+}
+
+// productKeyPrefix namespaces products. Products written before it existed are
+// stored under their bare ID until the legacyProduct namespace is migrated.
+const productKeyPrefix = "product_"
+
+// productKey returns the ledger key of a product
+func productKey(productID string) string {
+	return productKeyPrefix + productID
+}
+
+// getProductState reads a stored product, falling back to its legacy bare-ID key
+func getProductState(ctx contractapi.TransactionContextInterface, productID string) ([]byte, error) {
+	productJSON, err := ctx.GetStub().GetState(productKey(productID))
+	if err != nil || productJSON != nil {
+		return productJSON, err
+	}
+	return ctx.GetStub().GetState(productID)
+}
+
+// putProduct upgrades the product to the current schema, bumps its version and writes it to the ledger
+func putProduct(ctx contractapi.TransactionContextInterface, product *Product) error {
+	product.upgradeSchema()
+	product.Version++
+	productJSON, err := json.Marshal(product)
+	if err != nil {
+		return err
+	}
+	if err := trackRecordStats(ctx, productKey(product.ID), product, productStats); err != nil {
+		return err
+	}
+	if err := trackCompositeIndex(ctx, productKey(product.ID), product, productIndexKeys); err != nil {
+		return err
+	}
+	return ctx.GetStub().PutState(productKey(product.ID), productJSON)
+}
+
+// putBatch upgrades the batch to the current schema, bumps its version and writes it to the ledger
+func putBatch(ctx contractapi.TransactionContextInterface, batch *ProductBatch) error {
+	batch.upgradeSchema()
+	batch.Version++
+	batchJSON, err := json.Marshal(batch)
+	if err != nil {
+		return err
+	}
+	if err := trackRecordStats(ctx, "batch_"+batch.ID, batch, batchStats); err != nil {
+		return err
+	}
+	return ctx.GetStub().PutState("batch_"+batch.ID, batchJSON)
+}
+
+// putMaterialInventory writes a material inventory under key
+func putMaterialInventory(ctx contractapi.TransactionContextInterface, key string, inventory *MaterialInventory) error {
+	inventoryJSON, err := json.Marshal(inventory)
+	if err != nil {
+		return err
+	}
+	if err := trackRecordStats(ctx, key, inventory, inventoryStats); err != nil {
+		return err
+	}
+	if err := trackCompositeIndex(ctx, key, inventory, inventoryIndexKeys); err != nil {
+		return err
+	}
+	return ctx.GetStub().PutState(key, inventoryJSON)
+}
+
+// putOwnership writes a product's ownership record
+func putOwnership(ctx contractapi.TransactionContextInterface, ownership *Ownership) error {
+	ownershipJSON, err := json.Marshal(ownership)
+	if err != nil {
+		return err
+	}
+	key := "ownership_" + ownership.ProductID
+	if err := trackCompositeIndex(ctx, key, ownership, ownershipIndexKeys); err != nil {
+		return err
+	}
+	return ctx.GetStub().PutState(key, ownershipJSON)
+}
@@ -0,0 +1,356 @@
+package contracts
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+	// warrantyTermsKeyPrefix keys a brand's warranty terms per product type
+	warrantyTermsKeyPrefix = "warranty_terms_"
+
+	// defaultWarrantyMonths applies to product types without terms, the two-year
+	// statutory guarantee most markets require
+	defaultWarrantyMonths = 24
+	maxWarrantyMonths     = 120
+)
+
+// Warranty claim states
+const (
+	WarrantyClaimOpen     = "OPEN"
+	WarrantyClaimApproved = "APPROVED"
+	WarrantyClaimRejected = "REJECTED"
+)
+
+// WarrantyTerms are a brand's warranty for one product type
+type WarrantyTerms struct {
+	Brand          string `json:"brand"`
+	ProductType    string `json:"productType"`
+	DurationMonths int    `json:"durationMonths"`
+	TermsHash      string `json:"termsHash,omitempty" metadata:",optional"` // e.g. IPFS hash of the warranty conditions
+	UpdatedBy      string `json:"updatedBy,omitempty" metadata:",optional"`
+	UpdatedAt      string `json:"updatedAt,omitempty" metadata:",optional"`
+	SchemaVersion  int    `json:"schemaVersion"`
+}
+
+// Warranty is the warranty registered with a product's first sale. It runs from the
+// sale and passes to later owners with the ownership record.
+type Warranty struct {
+	StartDate      string          `json:"startDate"`
+	DurationMonths int             `json:"durationMonths"`
+	EndDate        string          `json:"endDate"`
+	TermsHash      string          `json:"termsHash,omitempty" metadata:",optional"`
+	Claims         []WarrantyClaim `json:"claims,omitempty" metadata:",optional"`
+}
+
+// WarrantyClaim is a repair claimed under warranty. It refers to the service record of
+// the intake and, once resolved, to the service record of the work done.
+type WarrantyClaim struct {
+	ClaimID                   string `json:"claimId"`
+	ServiceRecordID           string `json:"serviceRecordId"`
+	Description               string `json:"description"`
+	FiledBy                   string `json:"filedBy"` // MSP ID of the service center
+	FiledAt                   string `json:"filedAt"`
+	Status                    string `json:"status"`
+	ResolvedBy                string `json:"resolvedBy,omitempty" metadata:",optional"`
+	ResolvedAt                string `json:"resolvedAt,omitempty" metadata:",optional"`
+	ResolutionNote            string `json:"resolutionNote,omitempty" metadata:",optional"`
+	ResolutionServiceRecordID string `json:"resolutionServiceRecordId,omitempty" metadata:",optional"`
+}
+
+// valid reports whether the warranty covers the given time
+func (w *Warranty) valid(at time.Time) bool {
+	if w == nil {
+		return false
+	}
+	end, err := time.Parse(time.RFC3339, w.EndDate)
+	return err == nil && at.Before(end)
+}
+
+// getWarrantyTerms returns a brand's warranty terms for a product type, or the default
+func getWarrantyTerms(ctx contractapi.TransactionContextInterface, brand string, productType string) (*WarrantyTerms, error) {
+	termsJSON, err := ctx.GetStub().GetState(warrantyTermsKeyPrefix + brand + "_" + productType)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read warranty terms: %v", err)
+	}
+	if termsJSON == nil {
+		return &WarrantyTerms{
+			Brand:          brand,
+			ProductType:    productType,
+			DurationMonths: defaultWarrantyMonths,
+			SchemaVersion:  CurrentSchemaVersion,
+		}, nil
+	}
+
+	var terms WarrantyTerms
+	err = json.Unmarshal(termsJSON, &terms)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse warranty terms: %v", err)
+	}
+	terms.upgradeSchema()
+	return &terms, nil
+}
+
+// newWarranty registers the warranty for a product sold now under its brand's terms
+func newWarranty(ctx contractapi.TransactionContextInterface, product *Product) (*Warranty, error) {
+	terms, err := getWarrantyTerms(ctx, product.Brand, product.Type)
+	if err != nil {
+		return nil, err
+	}
+	now, err := txTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+	start := now.UTC()
+	return &Warranty{
+		StartDate:      start.Format(time.RFC3339),
+		DurationMonths: terms.DurationMonths,
+		EndDate:        start.AddDate(0, terms.DurationMonths, 0).Format(time.RFC3339),
+		TermsHash:      terms.TermsHash,
+	}, nil
+}
+
+// findServiceRecord returns the ownership's service record with the given ID, or nil
+func findServiceRecord(ownership *Ownership, serviceID string) *ServiceRecord {
+	for i := range ownership.ServiceHistory {
+		if ownership.ServiceHistory[i].ID == serviceID {
+			return &ownership.ServiceHistory[i]
+		}
+	}
+	return nil
+}
+
+// SetWarrantyTerms sets the warranty a brand gives on a product type, applied to
+// products of that type sold from now on. Only the brand (super admin) may set it.
+func (o *OwnershipContract) SetWarrantyTerms(ctx contractapi.TransactionContextInterface,
+	brand string, productType string, durationMonths int, termsHash string) error {
+
+	if err := validateAll(
+		validateName("brand", brand),
+		validateID("productType", productType),
+		validateText("termsHash", termsHash, maxNameLength),
+	); err != nil {
+		return err
+	}
+	if durationMonths < 1 || durationMonths > maxWarrantyMonths {
+		return newError(ErrInvalidArgument, "durationMonths must be between 1 and %d", maxWarrantyMonths)
+	}
+
+	caller, err := requireSuperAdmin(ctx)
+	if err != nil {
+		return err
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	terms := &WarrantyTerms{
+		Brand:          brand,
+		ProductType:    productType,
+		DurationMonths: durationMonths,
+		TermsHash:      termsHash,
+		UpdatedBy:      caller,
+		UpdatedAt:      now.UTC().Format(time.RFC3339),
+		SchemaVersion:  CurrentSchemaVersion,
+	}
+	termsJSON, err := json.Marshal(terms)
+	if err != nil {
+		return err
+	}
+	key := warrantyTermsKeyPrefix + brand + "_" + productType
+	err = ctx.GetStub().PutState(key, termsJSON)
+	if err != nil {
+		return fmt.Errorf("failed to store warranty terms: %v", err)
+	}
+
+	return emitEvent(ctx, ChaincodeEvent{
+		EventType:  EventWarrantyTermsUpdated,
+		EntityType: EventEntityConfig,
+		EntityID:   key,
+		Attributes: map[string]interface{}{
+			"durationMonths": durationMonths,
+			"termsHash":      termsHash,
+		},
+	})
+}
+
+// GetWarrantyTerms returns the warranty terms in effect for a brand's product type
+func (o *OwnershipContract) GetWarrantyTerms(ctx contractapi.TransactionContextInterface,
+	brand string, productType string) (*WarrantyTerms, error) {
+
+	if err := validateAll(
+		validateName("brand", brand),
+		validateID("productType", productType),
+	); err != nil {
+		return nil, err
+	}
+	return getWarrantyTerms(ctx, brand, productType)
+}
+
+// FileWarrantyClaim records that a service center took in a product for a repair it
+// claims under warranty. serviceID names the service record of the intake, which must
+// already be in the product's service history. The warranty must still be valid.
+func (o *OwnershipContract) FileWarrantyClaim(ctx contractapi.TransactionContextInterface,
+	productID string, claimID string, serviceID string, description string) error {
+
+	if err := validateAll(
+		validateID("productID", productID),
+		validateID("claimID", claimID),
+		validateID("serviceID", serviceID),
+		validateRequired("description", description, maxTextLength),
+	); err != nil {
+		return err
+	}
+
+	caller, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to get caller identity: %v", err)
+	}
+	roleContract := &RoleManagementContract{}
+	hasPermission, err := roleContract.CheckPermission(ctx, caller, "ADD_SERVICE_RECORD")
+	if err != nil || !hasPermission {
+		return newError(ErrPermissionDenied, "caller %s does not have permission to file warranty claims", caller)
+	}
+
+	ownership, err := o.GetOwnership(ctx, productID)
+	if err != nil {
+		return err
+	}
+	warranty := ownership.Warranty
+	if warranty == nil {
+		return newError(ErrInvalidState, "product %s has no registered warranty", productID)
+	}
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	if !warranty.valid(now) {
+		return newError(ErrInvalidState, "the warranty of product %s expired on %s", productID, warranty.EndDate)
+	}
+	if findServiceRecord(ownership, serviceID) == nil {
+		return newError(ErrNotFound, "service record %s not found for product %s", serviceID, productID)
+	}
+	for _, claim := range warranty.Claims {
+		if claim.ClaimID == claimID {
+			return newError(ErrAlreadyExists, "warranty claim %s already exists for product %s", claimID, productID)
+		}
+		if claim.ServiceRecordID == serviceID {
+			return newError(ErrAlreadyExists, "service record %s is already claimed by %s", serviceID, claim.ClaimID)
+		}
+	}
+
+	warranty.Claims = append(warranty.Claims, WarrantyClaim{
+		ClaimID:         claimID,
+		ServiceRecordID: serviceID,
+		Description:     description,
+		FiledBy:         caller,
+		FiledAt:         now.UTC().Format(time.RFC3339),
+		Status:          WarrantyClaimOpen,
+	})
+	err = putOwnership(ctx, ownership)
+	if err != nil {
+		return err
+	}
+
+	return emitEvent(ctx, ChaincodeEvent{
+		EventType:  EventWarrantyClaimFiled,
+		EntityType: EventEntityOwnership,
+		EntityID:   productID,
+		ToState:    WarrantyClaimOpen,
+		Attributes: map[string]interface{}{
+			"claimId":   claimID,
+			"serviceId": serviceID,
+			"filedBy":   caller,
+		},
+	})
+}
+
+// ResolveWarrantyClaim lets the brand approve or reject an open warranty claim.
+// resolutionServiceID optionally names the service record of the work done; it and the
+// intake record are marked as warranty work when the claim is approved.
+func (o *OwnershipContract) ResolveWarrantyClaim(ctx contractapi.TransactionContextInterface,
+	productID string, claimID string, approved bool, note string, resolutionServiceID string) error {
+
+	if err := validateAll(
+		validateID("productID", productID),
+		validateID("claimID", claimID),
+		validateText("note", note, maxTextLength),
+	); err != nil {
+		return err
+	}
+	if resolutionServiceID != "" {
+		if err := validateID("resolutionServiceID", resolutionServiceID); err != nil {
+			return err
+		}
+	}
+
+	caller, err := requireSuperAdmin(ctx)
+	if err != nil {
+		return err
+	}
+
+	ownership, err := o.GetOwnership(ctx, productID)
+	if err != nil {
+		return err
+	}
+	var claim *WarrantyClaim
+	if ownership.Warranty != nil {
+		for i := range ownership.Warranty.Claims {
+			if ownership.Warranty.Claims[i].ClaimID == claimID {
+				claim = &ownership.Warranty.Claims[i]
+			}
+		}
+	}
+	if claim == nil {
+		return newError(ErrNotFound, "warranty claim %s not found for product %s", claimID, productID)
+	}
+	if claim.Status != WarrantyClaimOpen {
+		return newError(ErrInvalidState, "warranty claim %s is already %s", claimID, claim.Status)
+	}
+	var resolutionRecord *ServiceRecord
+	if resolutionServiceID != "" {
+		resolutionRecord = findServiceRecord(ownership, resolutionServiceID)
+		if resolutionRecord == nil {
+			return newError(ErrNotFound, "service record %s not found for product %s", resolutionServiceID, productID)
+		}
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	claim.Status = WarrantyClaimRejected
+	if approved {
+		claim.Status = WarrantyClaimApproved
+		if intake := findServiceRecord(ownership, claim.ServiceRecordID); intake != nil {
+			intake.Warranty = true
+		}
+		if resolutionRecord != nil {
+			resolutionRecord.Warranty = true
+		}
+	}
+	claim.ResolvedBy = caller
+	claim.ResolvedAt = now.UTC().Format(time.RFC3339)
+	claim.ResolutionNote = note
+	claim.ResolutionServiceRecordID = resolutionServiceID
+	err = putOwnership(ctx, ownership)
+	if err != nil {
+		return err
+	}
+
+	return emitEvent(ctx, ChaincodeEvent{
+		EventType:  EventWarrantyClaimResolved,
+		EntityType: EventEntityOwnership,
+		EntityID:   productID,
+		FromState:  WarrantyClaimOpen,
+		ToState:    claim.Status,
+		Attributes: map[string]interface{}{
+			"claimId":             claimID,
+			"resolutionServiceId": resolutionServiceID,
+		},
+	})
+}
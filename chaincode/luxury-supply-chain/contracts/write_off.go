@@ -0,0 +1,416 @@
+package contracts
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Write-offs are stored as write_off_<writeOffID>
+const writeOffKeyPrefix = "write_off_"
+
+// Write-off reasons
+const (
+	WriteOffReasonTheft    = "THEFT"    // e.g. stolen from a warehouse
+	WriteOffReasonAccident = "ACCIDENT" // e.g. destroyed in a fire or flood
+	WriteOffReasonMissing  = "MISSING"  // Not found in a stock count
+)
+
+// Write-off statuses
+const (
+	WriteOffPending  = "PENDING"
+	WriteOffApproved = "APPROVED"
+	WriteOffRejected = "REJECTED"
+)
+
+// WriteOff is a confirmed loss of a product or of a quantity of material. The holder
+// requests it and the brand approves it.
+type WriteOff struct {
+	WriteOffID    string  `json:"writeOffId"`
+	ItemType      string  `json:"itemType"` // PRODUCT or MATERIAL
+	ItemID        string  `json:"itemId"`
+	MaterialType  string  `json:"materialType,omitempty" metadata:",optional"`
+	Quantity      float64 `json:"quantity"` // 1 for a product
+	Organization  string  `json:"organization"`
+	Reason        string  `json:"reason"`
+	Description   string  `json:"description"`
+	EvidenceHash  string  `json:"evidenceHash"` // e.g. police or incident report
+	Status        string  `json:"status"`
+	RequestedAt   string  `json:"requestedAt"`
+	DecidedBy     string  `json:"decidedBy,omitempty" metadata:",optional"`
+	DecidedAt     string  `json:"decidedAt,omitempty" metadata:",optional"`
+	DecisionNote  string  `json:"decisionNote,omitempty" metadata:",optional"`
+	SchemaVersion int     `json:"schemaVersion"`
+}
+
+// ShrinkageReport summarizes the write-offs of an organization approved in a period
+type ShrinkageReport struct {
+	Organization     string             `json:"organization"`
+	From             string             `json:"from"`
+	To               string             `json:"to"`
+	WriteOffs        int                `json:"writeOffs"`
+	Products         int                `json:"products"`
+	ProductIDs       []string           `json:"productIds"`
+	MaterialQuantity map[string]float64 `json:"materialQuantity"` // By material type
+	ByReason         map[string]int     `json:"byReason"`
+}
+
+// getWriteOff reads a write-off
+func getWriteOff(ctx contractapi.TransactionContextInterface, writeOffID string) (*WriteOff, error) {
+	writeOffJSON, err := ctx.GetStub().GetState(writeOffKeyPrefix + writeOffID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read write-off: %v", err)
+	}
+	if writeOffJSON == nil {
+		return nil, newError(ErrNotFound, "write-off %s does not exist", writeOffID)
+	}
+
+	var writeOff WriteOff
+	err = json.Unmarshal(writeOffJSON, &writeOff)
+	if err != nil {
+		return nil, err
+	}
+	writeOff.upgradeSchema()
+
+	return &writeOff, nil
+}
+
+// putWriteOff stores a write-off
+func putWriteOff(ctx contractapi.TransactionContextInterface, writeOff *WriteOff) error {
+	writeOffJSON, err := json.Marshal(writeOff)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState(writeOffKeyPrefix+writeOff.WriteOffID, writeOffJSON)
+	if err != nil {
+		return fmt.Errorf("failed to store write-off: %v", err)
+	}
+	return nil
+}
+
+// newWriteOff validates the common fields of a write-off request
+func newWriteOff(ctx contractapi.TransactionContextInterface,
+	writeOffID string, reason string, description string, evidenceHash string) (*WriteOff, error) {
+
+	if err := validateAll(
+		validateID("writeOffID", writeOffID),
+		validateEnum("reason", reason, WriteOffReasonTheft, WriteOffReasonAccident, WriteOffReasonMissing),
+		validateRequired("description", description, maxTextLength),
+		validateID("evidenceHash", evidenceHash),
+	); err != nil {
+		return nil, err
+	}
+
+	existing, err := ctx.GetStub().GetState(writeOffKeyPrefix + writeOffID)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return nil, newError(ErrAlreadyExists, "write-off %s already exists", writeOffID)
+	}
+
+	caller, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get caller identity: %v", err)
+	}
+	now, err := txTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &WriteOff{
+		WriteOffID:    writeOffID,
+		Organization:  caller,
+		Reason:        reason,
+		Description:   description,
+		EvidenceHash:  evidenceHash,
+		Status:        WriteOffPending,
+		RequestedAt:   now.UTC().Format(time.RFC3339),
+		SchemaVersion: CurrentSchemaVersion,
+	}, nil
+}
+
+// writeOffEvent builds the event for a write-off state change
+func writeOffEvent(eventType string, writeOff *WriteOff) ChaincodeEvent {
+	entityType := EventEntityProduct
+	if writeOff.ItemType == "MATERIAL" {
+		entityType = EventEntityMaterial
+	}
+	return ChaincodeEvent{
+		EventType:  eventType,
+		EntityType: entityType,
+		EntityID:   writeOff.ItemID,
+		Attributes: map[string]interface{}{
+			"writeOffId":   writeOff.WriteOffID,
+			"organization": writeOff.Organization,
+			"reason":       writeOff.Reason,
+			"quantity":     writeOff.Quantity,
+		},
+	}
+}
+
+// WriteOffProduct asks the brand to write off a product the caller holds as lost.
+// The product is unchanged until ApproveWriteOff.
+func (s *SupplyChainContract) WriteOffProduct(ctx contractapi.TransactionContextInterface,
+	writeOffID string, productID string, reason string, description string, evidenceHash string) error {
+
+	if err := validateID("productID", productID); err != nil {
+		return err
+	}
+	writeOff, err := newWriteOff(ctx, writeOffID, reason, description, evidenceHash)
+	if err != nil {
+		return err
+	}
+
+	product, err := s.GetProduct(ctx, productID)
+	if err != nil {
+		return err
+	}
+	if product.CurrentOwner != writeOff.Organization {
+		return newError(ErrPermissionDenied, "only the holder of product %s can write it off", productID)
+	}
+	if !canTransition(product.Status, ProductStatusWrittenOff, productStatusTransitions) {
+		return newError(ErrInvalidState, "product %s cannot be written off in status %s", productID, product.Status)
+	}
+
+	writeOff.ItemType = "PRODUCT"
+	writeOff.ItemID = productID
+	writeOff.Quantity = 1
+	err = putWriteOff(ctx, writeOff)
+	if err != nil {
+		return err
+	}
+
+	return emitEvent(ctx, writeOffEvent(EventWriteOffRequested, writeOff))
+}
+
+// WriteOffMaterial asks the brand to write off a quantity of the caller's available
+// material as lost. The inventory is unchanged until ApproveWriteOff.
+func (s *SupplyChainContract) WriteOffMaterial(ctx contractapi.TransactionContextInterface,
+	writeOffID string, materialID string, quantity float64, reason string, description string, evidenceHash string) error {
+
+	if err := validateAll(
+		validateID("materialID", materialID),
+		validateQuantity("quantity", quantity),
+	); err != nil {
+		return err
+	}
+	writeOff, err := newWriteOff(ctx, writeOffID, reason, description, evidenceHash)
+	if err != nil {
+		return err
+	}
+
+	inventory, err := s.GetMaterialInventory(ctx, materialID, writeOff.Organization)
+	if err != nil {
+		return err
+	}
+	if quantity > inventory.Available {
+		return newError(ErrInvalidArgument, "cannot write off %.2f of material %s, only %.2f available", quantity, materialID, inventory.Available)
+	}
+
+	writeOff.ItemType = "MATERIAL"
+	writeOff.ItemID = materialID
+	writeOff.MaterialType = inventory.Type
+	writeOff.Quantity = quantity
+	err = putWriteOff(ctx, writeOff)
+	if err != nil {
+		return err
+	}
+
+	return emitEvent(ctx, writeOffEvent(EventWriteOffRequested, writeOff))
+}
+
+// decideWriteOff checks that the caller is the brand and the write-off is pending
+func decideWriteOff(ctx contractapi.TransactionContextInterface, writeOffID string) (*WriteOff, string, error) {
+	caller, err := requireSuperAdmin(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+
+	writeOff, err := getWriteOff(ctx, writeOffID)
+	if err != nil {
+		return nil, "", err
+	}
+	if writeOff.Status != WriteOffPending {
+		return nil, "", newError(ErrInvalidState, "write-off %s is already %s", writeOffID, writeOff.Status)
+	}
+
+	return writeOff, caller, nil
+}
+
+// ApproveWriteOff is the brand's approval of a write-off. A product becomes
+// WRITTEN_OFF, which is final; a material's quantity leaves its available stock.
+func (s *SupplyChainContract) ApproveWriteOff(ctx contractapi.TransactionContextInterface,
+	writeOffID string, note string) error {
+
+	if err := validateAll(
+		validateID("writeOffID", writeOffID),
+		validateText("note", note, maxTextLength),
+	); err != nil {
+		return err
+	}
+
+	writeOff, caller, err := decideWriteOff(ctx, writeOffID)
+	if err != nil {
+		return err
+	}
+
+	// The item may have moved since the request
+	if writeOff.ItemType == "PRODUCT" {
+		product, err := s.GetProduct(ctx, writeOff.ItemID)
+		if err != nil {
+			return err
+		}
+		if product.CurrentOwner != writeOff.Organization {
+			return newError(ErrInvalidState, "product %s is no longer held by %s", writeOff.ItemID, writeOff.Organization)
+		}
+		if err := setProductStatus(product, ProductStatusWrittenOff); err != nil {
+			return err
+		}
+		if err := putProduct(ctx, product); err != nil {
+			return err
+		}
+	} else {
+		inventory, err := s.GetMaterialInventory(ctx, writeOff.ItemID, writeOff.Organization)
+		if err != nil {
+			return err
+		}
+		if writeOff.Quantity > inventory.Available {
+			return newError(ErrInvalidState, "only %.2f of material %s is still available", inventory.Available, writeOff.ItemID)
+		}
+		inventory.Available -= writeOff.Quantity
+		inventory.WrittenOff += writeOff.Quantity
+		err = putMaterialInventory(ctx, fmt.Sprintf("material_inventory_%s_%s", writeOff.ItemID, writeOff.Organization), inventory)
+		if err != nil {
+			return err
+		}
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	writeOff.Status = WriteOffApproved
+	writeOff.DecidedBy = caller
+	writeOff.DecidedAt = now.UTC().Format(time.RFC3339)
+	writeOff.DecisionNote = note
+	err = putWriteOff(ctx, writeOff)
+	if err != nil {
+		return err
+	}
+
+	return emitEvent(ctx, writeOffEvent(EventWriteOffApproved, writeOff))
+}
+
+// RejectWriteOff is the brand's refusal of a write-off, e.g. for missing evidence
+func (s *SupplyChainContract) RejectWriteOff(ctx contractapi.TransactionContextInterface,
+	writeOffID string, note string) error {
+
+	if err := validateAll(
+		validateID("writeOffID", writeOffID),
+		validateRequired("note", note, maxTextLength),
+	); err != nil {
+		return err
+	}
+
+	writeOff, caller, err := decideWriteOff(ctx, writeOffID)
+	if err != nil {
+		return err
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	writeOff.Status = WriteOffRejected
+	writeOff.DecidedBy = caller
+	writeOff.DecidedAt = now.UTC().Format(time.RFC3339)
+	writeOff.DecisionNote = note
+	err = putWriteOff(ctx, writeOff)
+	if err != nil {
+		return err
+	}
+
+	return emitEvent(ctx, writeOffEvent(EventWriteOffRejected, writeOff))
+}
+
+// GetWriteOff returns a write-off
+func (s *SupplyChainContract) GetWriteOff(ctx contractapi.TransactionContextInterface,
+	writeOffID string) (*WriteOff, error) {
+
+	if err := validateID("writeOffID", writeOffID); err != nil {
+		return nil, err
+	}
+
+	return getWriteOff(ctx, writeOffID)
+}
+
+// GetShrinkageReport summarizes an organization's write-offs approved from one RFC3339
+// time up to, but excluding, another
+func (s *SupplyChainContract) GetShrinkageReport(ctx contractapi.TransactionContextInterface,
+	orgMSPID string, from string, to string) (*ShrinkageReport, error) {
+
+	if err := validateID("orgMSPID", orgMSPID); err != nil {
+		return nil, err
+	}
+	fromTime, err := time.Parse(time.RFC3339, from)
+	if err != nil {
+		return nil, newError(ErrInvalidArgument, "from must be an RFC3339 time")
+	}
+	toTime, err := time.Parse(time.RFC3339, to)
+	if err != nil {
+		return nil, newError(ErrInvalidArgument, "to must be an RFC3339 time")
+	}
+	if !toTime.After(fromTime) {
+		return nil, newError(ErrInvalidArgument, "to must be after from")
+	}
+
+	resultsIterator, err := ctx.GetStub().GetStateByRange(writeOffKeyPrefix, writeOffKeyPrefix+"~")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query write-offs: %v", err)
+	}
+	defer resultsIterator.Close()
+
+	report := &ShrinkageReport{
+		Organization:     orgMSPID,
+		From:             from,
+		To:               to,
+		ProductIDs:       []string{},
+		MaterialQuantity: make(map[string]float64),
+		ByReason:         make(map[string]int),
+	}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var writeOff WriteOff
+		err = json.Unmarshal(queryResponse.Value, &writeOff)
+		if err != nil {
+			return nil, err
+		}
+		if writeOff.Organization != orgMSPID || writeOff.Status != WriteOffApproved {
+			continue
+		}
+		decidedAt, err := time.Parse(time.RFC3339, writeOff.DecidedAt)
+		if err != nil || decidedAt.Before(fromTime) || !decidedAt.Before(toTime) {
+			continue
+		}
+
+		report.WriteOffs++
+		report.ByReason[writeOff.Reason]++
+		if writeOff.ItemType == "PRODUCT" {
+			report.Products++
+			report.ProductIDs = append(report.ProductIDs, writeOff.ItemID)
+		} else {
+			report.MaterialQuantity[writeOff.MaterialType] += writeOff.Quantity
+		}
+	}
+	sort.Strings(report.ProductIDs)
+
+	return report, nil
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// maxTrackedFunctions bounds the counter map; further function names are counted as "other"
+const maxTrackedFunctions = 200
+
+// buildVersion can be set at build time with -ldflags "-X main.buildVersion=<version>"
+var buildVersion = "dev"
+
+// FunctionCounters counts the invocations of one chaincode function
+type FunctionCounters struct {
+	Invocations uint64 `json:"invocations"`
+	Errors      uint64 `json:"errors"`
+}
+
+// healthMonitor tracks uptime, readiness and invocation counters for the health listener
+type healthMonitor struct {
+	startedAt time.Time
+	ready     atomic.Bool
+
+	mu        sync.Mutex
+	functions map[string]*FunctionCounters
+}
+
+func newHealthMonitor() *healthMonitor {
+	return &healthMonitor{
+		startedAt: time.Now(),
+		functions: make(map[string]*FunctionCounters),
+	}
+}
+
+// record counts one invocation of function
+func (h *healthMonitor) record(function string, failed bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	counters, exists := h.functions[function]
+	if !exists && len(h.functions) >= maxTrackedFunctions {
+		function = "other"
+		counters, exists = h.functions[function]
+	}
+	if !exists {
+		counters = &FunctionCounters{}
+		h.functions[function] = counters
+	}
+	counters.Invocations++
+	if failed {
+		counters.Errors++
+	}
+}
+
+// instrument wraps cc so that every invocation is counted
+func (h *healthMonitor) instrument(cc shim.Chaincode) shim.Chaincode {
+	return &instrumentedChaincode{cc: cc, monitor: h}
+}
+
+// serve starts the health listener on address in the background
+func (h *healthMonitor) serve(address string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if !h.ready.Load() {
+			writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "starting"})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ready"})
+	})
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, h.snapshot())
+	})
+
+	go func() {
+		log.Printf("Health listener on %s", address)
+		if err := http.ListenAndServe(address, mux); err != nil {
+			log.Printf("Health listener stopped: %v", err)
+		}
+	}()
+}
+
+// snapshot returns the current build info, uptime and counters
+func (h *healthMonitor) snapshot() map[string]interface{} {
+	h.mu.Lock()
+	functions := make(map[string]FunctionCounters, len(h.functions))
+	var total FunctionCounters
+	for name, counters := range h.functions {
+		functions[name] = *counters
+		total.Invocations += counters.Invocations
+		total.Errors += counters.Errors
+	}
+	h.mu.Unlock()
+
+	return map[string]interface{}{
+		"build":         buildInfo(),
+		"startedAt":     h.startedAt.Format(time.RFC3339),
+		"uptimeSeconds": int64(time.Since(h.startedAt).Seconds()),
+		"ready":         h.ready.Load(),
+		"invocations":   total,
+		"functions":     functions,
+	}
+}
+
+// buildInfo describes the running binary
+func buildInfo() map[string]string {
+	info := map[string]string{
+		"version":     buildVersion,
+		"chaincodeId": os.Getenv("CHAINCODE_ID"),
+		"goVersion":   runtime.Version(),
+	}
+	if build, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range build.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				info["revision"] = setting.Value
+			case "vcs.time":
+				info["revisionTime"] = setting.Value
+			}
+		}
+	}
+	return info
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// instrumentedChaincode counts invocations before handing them to the wrapped chaincode
+type instrumentedChaincode struct {
+	cc      shim.Chaincode
+	monitor *healthMonitor
+}
+
+func (i *instrumentedChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
+	response := i.cc.Init(stub)
+	i.monitor.record("Init", response.Status >= shim.ERRORTHRESHOLD)
+	return response
+}
+
+func (i *instrumentedChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
+	function, _ := stub.GetFunctionAndParameters()
+	response := i.cc.Invoke(stub)
+	i.monitor.record(function, response.Status >= shim.ERRORTHRESHOLD)
+	return response
+}
@@ -134,7 +134,7 @@ case "$1" in
         QUANTITY=$6
         
         print_info "Creating material $MATERIAL_ID..."
-        invoke_with_endorsements "SupplyChainContract:CreateMaterialInventory" "\"$MATERIAL_ID\",\"$TYPE\",\"$BATCH\",\"$QUANTITY\""
+        invoke_with_endorsements "SupplyChainContract:CreateMaterialInventory" "\"$MATERIAL_ID\",\"$TYPE\",\"$BATCH\",\"$QUANTITY\",\"\",\"\""
         print_success "Material created!"
         ;;
         
@@ -16,6 +16,13 @@ ORDERER_CA="/opt/gopath/src/github.com/hyperledger/fabric/peer/crypto/ordererOrg
 CONSENSUS_CC_PATH="/opt/gopath/src/github.com/hyperledger/fabric/chaincode/2check-consensus"
 LUXURY_CC_PATH="/opt/gopath/src/github.com/hyperledger/fabric/chaincode/luxury-supply-chain"
 
+# Private data collections must be passed identically to approve, readiness check and commit
+collections_config_args() {
+    if [ "$1" = "luxury-supply-chain" ]; then
+        echo "--collections-config ${LUXURY_CC_PATH}/collections_config.json"
+    fi
+}
+
 # Colors for output
 RED='\033[0;31m'
 GREEN='\033[0;32m'
@@ -158,7 +165,8 @@ approve_chaincode() {
         --name ${CC_NAME} \
         --version ${CC_VERSION} \
         --package-id ${PACKAGE_ID} \
-        --sequence ${CC_SEQUENCE}
+        --sequence ${CC_SEQUENCE} \
+        $(collections_config_args ${CC_NAME})
     
     if [ $? -eq 0 ]; then
         print_success "${CC_NAME} approved for ${ORG_NAME}"
@@ -184,6 +192,7 @@ check_commit_readiness() {
         --name ${CC_NAME} \
         --version ${CC_VERSION} \
         --sequence ${CC_SEQUENCE} \
+        $(collections_config_args ${CC_NAME}) \
         --output json
 }
 
@@ -224,6 +233,7 @@ commit_chaincode() {
         --name ${CC_NAME} \
         --version ${CC_VERSION} \
         --sequence ${CC_SEQUENCE} \
+        $(collections_config_args ${CC_NAME}) \
         --peerAddresses peer0.luxebags.${BRAND_DOMAIN}:7051 \
         --tlsRootCertFiles /tmp/tls-certs/tlsca.luxebags.${BRAND_DOMAIN}-cert.pem \
         --peerAddresses peer0.italianleather.${BRAND_DOMAIN}:9051 \
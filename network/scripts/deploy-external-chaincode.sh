@@ -83,20 +83,30 @@ package_external_chaincode() {
   \"tls_required\": false
 }"
     
+    # Clean up any previous attempts
+    docker exec ${PEER_CONTAINER} bash -c "rm -rf /tmp/${CC_NAME}_pkg /tmp/${CC_NAME}.tar.gz && mkdir -p /tmp/${CC_NAME}_pkg"
+    
+    # Ship the CouchDB index definitions so the peer creates them on install
+    local CC_SOURCE_DIR="${SCRIPT_DIR}/../../chaincode/${CC_NAME}"
+    if [ -d "${CC_SOURCE_DIR}/META-INF" ]; then
+        docker cp "${CC_SOURCE_DIR}/META-INF" ${PEER_CONTAINER}:/tmp/${CC_NAME}_pkg/META-INF
+    fi
+    
     # Package as external service
     docker exec ${PEER_CONTAINER} bash -c "
-        # Clean up any previous attempts
-        rm -rf /tmp/${CC_NAME}_pkg /tmp/${CC_NAME}.tar.gz
-        mkdir -p /tmp/${CC_NAME}_pkg
-        
         # Create metadata.json for Chaincode as a Service (ccaas in Fabric 2.5)
         echo '{\"type\":\"ccaas\",\"label\":\"${CC_NAME}_${CC_VERSION}\"}' > /tmp/${CC_NAME}_pkg/metadata.json
         
         # Create connection.json
         echo '${CONNECTION_JSON}' > /tmp/${CC_NAME}_pkg/connection.json
         
-        # For ccaas (Chaincode as a Service), code.tar.gz contains just connection.json
-        cd /tmp/${CC_NAME}_pkg && tar -czf code.tar.gz connection.json
+        # For ccaas (Chaincode as a Service), code.tar.gz contains connection.json plus any index metadata
+        cd /tmp/${CC_NAME}_pkg
+        if [ -d META-INF ]; then
+            tar -czf code.tar.gz connection.json META-INF
+        else
+            tar -czf code.tar.gz connection.json
+        fi
         
         # Create the final package with metadata.json and code.tar.gz
         cd /tmp/${CC_NAME}_pkg && tar -czf /tmp/${CC_NAME}.tar.gz metadata.json code.tar.gz